@@ -0,0 +1,14 @@
+//go:build chaos
+
+package main
+
+import "github.com/celerix-dev/celerix-store/internal/chaos"
+
+// newChaos returns the injector configured by CELERIX_CHAOS (see chaos.Parse).
+func newChaos(spec string) (chaosInjector, error) {
+	config, err := chaos.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	return chaos.New(config), nil
+}
@@ -0,0 +1,10 @@
+//go:build !chaos
+
+package main
+
+import "errors"
+
+// newChaos refuses CELERIX_CHAOS: regular builds can't disturb themselves.
+func newChaos(spec string) (chaosInjector, error) {
+	return nil, errors.New("chaos mode is only available in builds with -tags chaos")
+}
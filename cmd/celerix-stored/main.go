@@ -1,28 +1,58 @@
 package main
 
 import (
-	"embed"
+	"context"
+	"flag"
 	"fmt"
-	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/celerix-dev/celerix-store/internal/api"
-	"github.com/celerix-dev/celerix-store/internal/server"
+	"github.com/celerix-dev/celerix-store/internal/ingest"
+	"github.com/celerix-dev/celerix-store/internal/jobs"
+	"github.com/celerix-dev/celerix-store/internal/redismirror"
 	"github.com/celerix-dev/celerix-store/internal/vault"
+	"github.com/celerix-dev/celerix-store/pkg/compute"
 	"github.com/celerix-dev/celerix-store/pkg/engine"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+	"github.com/celerix-dev/celerix-store/pkg/server"
+	"github.com/celerix-dev/celerix-store/pkg/version"
 	"github.com/gin-gonic/gin"
 )
 
-//go:embed all:dist
-var frontendDist embed.FS
-
 func main() {
-	fmt.Println("Starting Celerix Store Daemon...")
+	showVersion := flag.Bool("version", false, "print version information and exit")
+	fsck := flag.Bool("fsck", false, "check the data directory and exit; the daemon must not be running")
+	repair := flag.Bool("repair", false, "with --fsck, restore or set aside damaged files")
+	flag.Parse()
+	if *showVersion {
+		fmt.Println("celerix-stored", version.Get())
+		return
+	}
+	if *fsck {
+		os.Exit(runFsck(*repair))
+	}
+
+	if flag.Arg(0) == "service" {
+		runService(flag.Args()[1:])
+		return
+	}
+
+	// Shutdown is triggered by a signal or, for a Windows service, by the
+	// service manager
+	sigChan := make(chan os.Signal, 1)
+	serviceStart(sigChan)
+
+	fmt.Printf("Starting Celerix Store Daemon %s...\n", version.Get().Version)
 
 	dataDir := os.Getenv("CELERIX_DATA_DIR")
 	if dataDir == "" {
@@ -39,25 +69,239 @@ func main() {
 		httpPort = "7002"
 	}
 
+	// Listen on localhost unless told otherwise, e.g. CELERIX_BIND_ADDR=0.0.0.0
+	tcpAddr := listenAddr("CELERIX_TCP_BIND_ADDR", port)
+	httpAddr := listenAddr("CELERIX_HTTP_BIND_ADDR", httpPort)
+
 	useTLS := os.Getenv("CELERIX_DISABLE_TLS") != "true"
 
-	// 2. Initialize Persistence
-	persister, err := engine.NewPersistence(dataDir)
+	// 2. Configure the engine
+	// Durability: CELERIX_FSYNC=always|interval|never
+	fsyncPolicy, err := engine.ParseFsyncPolicy(os.Getenv("CELERIX_FSYNC"))
 	if err != nil {
-		log.Fatalf("Failed to initialize persistence: %v", err)
+		log.Fatalf("Invalid CELERIX_FSYNC: %v", err)
 	}
+	var fsyncInterval time.Duration
+	if v := os.Getenv("CELERIX_FSYNC_INTERVAL"); v != "" {
+		if fsyncInterval, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid CELERIX_FSYNC_INTERVAL: %v", err)
+		}
+	}
+	opts := []engine.Option{engine.WithFsync(fsyncPolicy, fsyncInterval)}
+	fmt.Printf("Fsync policy: %s\n", fsyncPolicy)
+
+	// Optionally keep raw persona IDs out of logs and metrics
+	var hasher *engine.PersonaHasher
+	if os.Getenv("CELERIX_HASH_PERSONA_IDS") == "true" {
+		hasher = engine.NewPersonaHasher([]byte(os.Getenv("CELERIX_PERSONA_HASH_KEY")))
+		opts = append(opts, engine.WithPersonaHasher(hasher))
+		fmt.Println("Persona IDs will be hashed in logs and metrics.")
+	}
+
+	// Memory cap: CELERIX_MAX_MEMORY=512MB with CELERIX_EVICTION=reject|ephemeral|lru
+	if v := os.Getenv("CELERIX_MAX_MEMORY"); v != "" {
+		limit, err := engine.ParseByteSize(v)
+		if err != nil {
+			log.Fatalf("Invalid CELERIX_MAX_MEMORY: %v", err)
+		}
+		policy, err := engine.ParseEvictionPolicy(os.Getenv("CELERIX_EVICTION"))
+		if err != nil {
+			log.Fatalf("Invalid CELERIX_EVICTION: %v", err)
+		}
+		var ephemeral []string
+		if apps := os.Getenv("CELERIX_EPHEMERAL_APPS"); apps != "" {
+			ephemeral = strings.Split(apps, ",")
+		}
+		opts = append(opts, engine.WithMemoryLimit(limit, policy, ephemeral...))
+		fmt.Printf("Memory limit: %s (eviction: %s)\n", v, policy)
+	}
+	if os.Getenv("CELERIX_RAW_JSON") == "true" {
+		opts = append(opts, engine.WithRawJSON())
+	}
+	// Background saves: CELERIX_SAVE_WORKERS goroutines, writes held up once
+	// CELERIX_SAVE_QUEUE_DEPTH apps are waiting to be saved
+	var saveWorkers, saveQueueDepth int
+	for env, n := range map[string]*int{"CELERIX_SAVE_WORKERS": &saveWorkers, "CELERIX_SAVE_QUEUE_DEPTH": &saveQueueDepth} {
+		if v := os.Getenv(env); v != "" {
+			if *n, err = strconv.Atoi(v); err != nil || *n <= 0 {
+				log.Fatalf("Invalid %s: %q", env, v)
+			}
+		}
+	}
+	opts = append(opts, engine.WithSaveQueue(saveWorkers, saveQueueDepth))
+	if os.Getenv("CELERIX_REJECT_WRITES_ON_SAVE_FAILURE") == "true" {
+		opts = append(opts, engine.WithRejectWritesOnSaveFailure())
+	}
+	preciseNumbers := os.Getenv("CELERIX_PRECISE_NUMBERS") == "true"
+	if preciseNumbers {
+		opts = append(opts, engine.WithPreciseNumbers())
+	}
+	// Test builds only: CELERIX_CHAOS="latency=50ms-200ms,drop=0.01,save_fail=0.05"
+	var chaos chaosInjector
+	if spec := os.Getenv("CELERIX_CHAOS"); spec != "" {
+		if chaos, err = newChaos(spec); err != nil {
+			log.Fatalf("Invalid CELERIX_CHAOS: %v", err)
+		}
+		opts = append(opts, engine.WithSaveFault(chaos.SaveFault))
+		fmt.Printf("CHAOS MODE, do not use in production: %s\n", chaos)
+	}
+
+	// Write transformers: CELERIX_TRANSFORMS="users=lowercase_keys,strip_nulls;*=normalize_timestamps",
+	// with more loaded from the Go plugins in CELERIX_TRANSFORM_PLUGINS
+	transformOpts, err := transformOptions(os.Getenv("CELERIX_TRANSFORMS"), os.Getenv("CELERIX_TRANSFORM_PLUGINS"))
+	if err != nil {
+		log.Fatalf("Invalid CELERIX_TRANSFORMS: %v", err)
+	}
+	opts = append(opts, transformOpts...)
 
 	// 3. Load existing data and start the Engine
-	initialData, err := persister.LoadAll()
+	store, err := engine.Open(dataDir, opts...)
 	if err != nil {
-		log.Printf("Warning: Could not load existing data: %v", err)
+		log.Fatalf("Failed to start the engine: %v", err)
+	}
+	personas, _ := store.GetPersonas()
+	fmt.Printf("Engine started. Loaded %d personas.\n", len(personas))
+
+	// First boot of a dev or test environment: CELERIX_SEED_FILE=seed.yaml
+	if path := os.Getenv("CELERIX_SEED_FILE"); path != "" {
+		if len(personas) > 0 {
+			fmt.Println("Store is not empty, skipping the seed file.")
+		} else if err := seedStore(store, path, preciseNumbers); err != nil {
+			log.Fatalf("Failed to seed the store from %s: %v", path, err)
+		}
+	}
+
+	// Optionally mirror writes to a second daemon to build confidence in an upgrade
+	var served sdk.CelerixStore = store
+	var shadow *sdk.ShadowStore
+	if addr := os.Getenv("CELERIX_SHADOW_ADDR"); addr != "" {
+		shadow, err = startShadow(store, addr, hasher)
+		if err != nil {
+			log.Fatalf("Failed to start shadow writes: %v", err)
+		}
+		served = shadow
+		fmt.Printf("Mirroring writes to shadow store at %s.\n", addr)
 	}
 
-	store := engine.NewMemStore(initialData, persister)
-	fmt.Printf("Engine started. Loaded %d personas.\n", len(initialData))
+	// Keep apps readable by services that only speak Redis:
+	// CELERIX_REDIS_MIRROR_URL=redis://host:6379/0 CELERIX_REDIS_MIRROR_APPS=config,flags_*
+	var mirror *redismirror.Mirror
+	if redisURL := os.Getenv("CELERIX_REDIS_MIRROR_URL"); redisURL != "" {
+		apps := os.Getenv("CELERIX_REDIS_MIRROR_APPS")
+		if apps == "" {
+			log.Fatal("CELERIX_REDIS_MIRROR_URL needs CELERIX_REDIS_MIRROR_APPS, the apps to mirror")
+		}
+		mirror, err = redismirror.Start(store, redismirror.Options{
+			URL:        redisURL,
+			Apps:       strings.Split(apps, ","),
+			KeyPattern: os.Getenv("CELERIX_REDIS_MIRROR_KEY_PATTERN"),
+		})
+		if err != nil {
+			log.Fatalf("Failed to start the Redis mirror: %v", err)
+		}
+		fmt.Printf("Mirroring apps %s to Redis.\n", apps)
+	}
+
+	// Apply mutation events from Kafka or NATS:
+	// CELERIX_INGEST="kafka://broker:9092/orders;nats://nats:4222/config.>"
+	ingestCtx, stopIngest := context.WithCancel(context.Background())
+	ingestDone := make(chan struct{})
+	if spec := os.Getenv("CELERIX_INGEST"); spec != "" {
+		ingester, sources, err := startIngest(served, spec, preciseNumbers)
+		if err != nil {
+			log.Fatalf("Invalid CELERIX_INGEST: %v", err)
+		}
+		go func() {
+			ingester.Run(ingestCtx, sources...)
+			close(ingestDone)
+		}()
+		fmt.Printf("Ingesting events from %d sources.\n", len(sources))
+	} else {
+		close(ingestDone)
+	}
 
 	// 4. Initialize the TCP Router
-	router := server.NewRouter(store)
+	router := server.NewRouter(served)
+	var routerConfig server.RouterConfig
+	if v := os.Getenv("CELERIX_MAX_CONNECTIONS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid CELERIX_MAX_CONNECTIONS: %q", v)
+		}
+		routerConfig.MaxConnections = n
+	}
+	// CELERIX_IDLE_TIMEOUT=0 keeps idle connections open indefinitely
+	if v := os.Getenv("CELERIX_IDLE_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatalf("Invalid CELERIX_IDLE_TIMEOUT: %q", v)
+		}
+		routerConfig.IdleTimeout = d
+		if d == 0 {
+			routerConfig.IdleTimeout = -1
+		}
+	}
+	if v := os.Getenv("CELERIX_WRITE_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatalf("Invalid CELERIX_WRITE_TIMEOUT: %q", v)
+		}
+		routerConfig.WriteTimeout = d
+	}
+	// CELERIX_DEDUP_WINDOW=0 turns off request IDs, so clients don't resend writes
+	if v := os.Getenv("CELERIX_DEDUP_WINDOW"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatalf("Invalid CELERIX_DEDUP_WINDOW: %q", v)
+		}
+		routerConfig.DedupWindow = d
+		if d == 0 {
+			routerConfig.DedupWindow = -1
+		}
+	}
+	// CELERIX_COMPUTE runs the WebAssembly functions stored in _system/functions
+	if os.Getenv("CELERIX_COMPUTE") == "true" {
+		var computeOpts compute.Options
+		if v := os.Getenv("CELERIX_COMPUTE_TIMEOUT"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				log.Fatalf("Invalid CELERIX_COMPUTE_TIMEOUT: %q", v)
+			}
+			computeOpts.Timeout = d
+		}
+		if v := os.Getenv("CELERIX_COMPUTE_MEMORY"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				log.Fatalf("Invalid CELERIX_COMPUTE_MEMORY: %q", v)
+			}
+			computeOpts.MemoryLimit = n << 20
+		}
+		if routerConfig.Compute, err = compute.NewRunner(computeOpts); err != nil {
+			log.Fatalf("Failed to start compute functions: %v", err)
+		}
+	}
+	// CELERIX_ADMIN_TOKEN makes the _system persona read-only for other clients
+	adminToken := os.Getenv("CELERIX_ADMIN_TOKEN")
+	routerConfig.AdminToken = adminToken
+
+	// Users log in over HTTP; CELERIX_REQUIRE_AUTH makes their tokens mandatory
+	tokens := sdk.NewTokenStore(served, sdk.NewUserStore(served), 0, 0)
+	requireAuth := os.Getenv("CELERIX_REQUIRE_AUTH") == "true"
+	routerConfig.Tokens, routerConfig.RequireAuth = tokens, requireAuth
+	routerConfig.PreciseNumbers = preciseNumbers
+	router.SetConfig(routerConfig)
+
+	// Isolated namespaces: CELERIX_NAMESPACES=dev,staging=<token>,prod=<token>
+	namespaces, err := openNamespaces(router, dataDir, os.Getenv("CELERIX_NAMESPACES"), opts)
+	if err != nil {
+		log.Fatalf("Invalid CELERIX_NAMESPACES: %v", err)
+	}
+
+	// Scheduled jobs: CELERIX_JOBS="snapshot=@daily;retention=0 4 * * *"
+	scheduler, err := startJobs(os.Getenv("CELERIX_JOBS"), dataDir, store)
+	if err != nil {
+		log.Fatalf("Invalid CELERIX_JOBS: %v", err)
+	}
 
 	// 5. Setup TLS
 	if useTLS {
@@ -73,40 +317,55 @@ func main() {
 	}
 
 	// 6. Initialize HTTP API & UI
-	h := &api.Handler{Store: store}
-	r := gin.Default()
-
-	// CORS
-	r.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
+	h := &api.Handler{Store: served, Hasher: hasher, AdminToken: adminToken, Tokens: tokens, APIKeys: sdk.NewAPIKeyStore(served),
+		RequireAuth: requireAuth, RequireIfMatch: os.Getenv("CELERIX_REQUIRE_IF_MATCH") == "true", PreciseNumbers: preciseNumbers, Jobs: scheduler}
+	// CELERIX_REDACT hides sensitive values from exports and share links
+	if spec := os.Getenv("CELERIX_REDACT"); spec != "" {
+		if h.Redaction, err = sdk.ParseRedactionPolicy(spec); err != nil {
+			log.Fatalf("Invalid CELERIX_REDACT: %v", err)
 		}
-		c.Next()
-	})
+	}
+	// Share links are signed with CELERIX_SHARE_KEY; without it they don't survive a restart
+	h.Shares = sdk.NewShareSigner(served, []byte(os.Getenv("CELERIX_SHARE_KEY")))
+	// The UI's app dumps are cached up to CELERIX_HTTP_CACHE_SIZE (default 64MB; 0 turns it off)
+	cacheSize := int64(64 << 20)
+	if v := os.Getenv("CELERIX_HTTP_CACHE_SIZE"); v != "" {
+		if cacheSize, err = engine.ParseByteSize(v); err != nil {
+			log.Fatalf("Invalid CELERIX_HTTP_CACHE_SIZE: %v", err)
+		}
+	}
+	h.DumpCache = api.NewAppDumpCache(served, int(cacheSize))
+	r := gin.New()
+	r.Use(api.Logger(hasher), gin.Recovery())
 
-	apiGroup := r.Group("/api")
-	{
-		apiGroup.GET("/personas", h.GetPersonas)
-		apiGroup.GET("/personas/:persona/apps", h.GetApps)
-		apiGroup.GET("/personas/:persona/apps/:app", h.GetAppStore)
-		apiGroup.GET("/global/:app/:key", h.GetGlobal)
-		apiGroup.POST("/personas/:persona/apps/:app/:key", h.Set)
-		apiGroup.DELETE("/personas/:persona/apps/:app/:key", h.Delete)
-		apiGroup.POST("/move", h.Move)
+	// CORS: CELERIX_CORS_ORIGINS=https://admin.example.com,... (default: any origin)
+	var corsOrigins []string
+	if v := os.Getenv("CELERIX_CORS_ORIGINS"); v != "" {
+		corsOrigins = strings.Split(v, ",")
 	}
+	r.Use(api.CORS(corsOrigins))
+
+	h.RegisterRoutes(r.Group("/api"))
 
-	// Serve UI
-	distFS, _ := fs.Sub(frontendDist, "dist")
+	// Serve UI: from CELERIX_UI_DIR when set (handy for UI development against a
+	// running daemon), otherwise from the embedded build unless built with -tags noui.
+	distFS, hasUI := embeddedUI()
+	if uiDir := os.Getenv("CELERIX_UI_DIR"); uiDir != "" {
+		distFS, hasUI = os.DirFS(uiDir), true
+		fmt.Printf("Serving management UI from %s\n", uiDir)
+	} else if !hasUI {
+		fmt.Println("Management UI not included in this build (set CELERIX_UI_DIR to serve it from disk).")
+	}
 	r.NoRoute(func(c *gin.Context) {
 		path := c.Request.URL.Path
 		if strings.HasPrefix(path, "/api") {
 			c.JSON(http.StatusNotFound, gin.H{"error": "API route not found"})
 			return
 		}
+		if !hasUI {
+			c.JSON(http.StatusNotFound, gin.H{"error": "management UI not available in this build"})
+			return
+		}
 		file, err := distFS.Open(strings.TrimPrefix(path, "/"))
 		if err == nil {
 			file.Close()
@@ -116,29 +375,77 @@ func main() {
 		c.FileFromFS("/", http.FS(distFS))
 	})
 
-	// 7. Start servers
+	// 7. Start servers, on the sockets systemd passed if it socket-activated us
+	inherited, err := systemdListeners()
+	if err != nil {
+		log.Fatalf("Socket activation failed: %v", err)
+	}
+	httpListener, err := listen(inherited, "http", httpAddr)
+	if err != nil {
+		log.Fatalf("HTTP server failed: %v", err)
+	}
+	if chaos != nil {
+		httpListener = chaos.Listener(httpListener)
+	}
 	go func() {
-		fmt.Printf("HTTP Management UI listening on :%s\n", httpPort)
-		if err := r.Run(":" + httpPort); err != nil {
+		fmt.Printf("HTTP Management UI listening on %s\n", httpListener.Addr())
+		if err := http.Serve(httpListener, r); err != nil {
 			log.Fatalf("HTTP server failed: %v", err)
 		}
 	}()
 
 	// 8. Handle Graceful Shutdown
-	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
 	go func() {
 		<-sigChan
 		fmt.Println("\nShutdown signal received. Finalizing disk writes...")
-		store.Wait()
+		sdNotify("STOPPING=1")
+		if scheduler != nil {
+			scheduler.Stop()
+		}
+		stopIngest()
+		<-ingestDone
+		if shadow != nil {
+			shadow.Close()
+		}
+		if mirror != nil {
+			mirror.Close()
+		}
+		if err := store.Close(); err != nil {
+			log.Printf("Warning: Could not close storage backend: %v", err)
+		}
+		for name, ns := range namespaces {
+			if err := ns.Close(); err != nil {
+				log.Printf("Warning: Could not close storage backend of namespace %s: %v", name, err)
+			}
+		}
 		fmt.Println("Persistence complete. Exiting.")
+		serviceStopped()
 		os.Exit(0)
 	}()
 
 	// 9. Start the TCP Server
-	fmt.Printf("Celerix Engine listening on :%s (TCP)\n", port)
-	err = router.Listen(port)
+	listener, err := listen(inherited, "tcp", tcpAddr)
+	if err != nil {
+		log.Fatalf("TCP Server failed: %v", err)
+	}
+	if chaos != nil {
+		listener = chaos.Listener(listener)
+	}
+	fmt.Printf("Celerix Engine listening on %s (TCP)\n", listener.Addr())
+
+	// Data is loaded and both listeners are bound, so connections queue up
+	// until Serve accepts them: tell systemd (Type=notify) we're ready.
+	if err := sdNotify("READY=1"); err != nil {
+		log.Printf("Warning: Could not notify systemd: %v", err)
+	}
+	serviceReady()
+	startWatchdog(func() error {
+		_, err := store.GetPersonas()
+		return err
+	})
+	err = router.Serve(context.Background(), listener)
 	if err != nil {
 		select {
 		case <-sigChan:
@@ -147,3 +454,283 @@ func main() {
 		}
 	}
 }
+
+// chaosInjector disturbs the daemon on purpose (see internal/chaos). It is
+// only linked into builds with the chaos tag.
+type chaosInjector interface {
+	Listener(l net.Listener) net.Listener
+	SaveFault(personaID, appID string) error
+	String() string
+}
+
+// runFsck checks (and with repair, fixes) the files in CELERIX_DATA_DIR and
+// returns the exit code: 1 if problems remain, 2 if the check itself failed.
+func runFsck(repair bool) int {
+	dataDir := os.Getenv("CELERIX_DATA_DIR")
+	if dataDir == "" {
+		dataDir = "./data"
+	}
+	report, err := engine.Fsck(dataDir, repair)
+	if err != nil {
+		log.Printf("fsck %s: %v", dataDir, err)
+		return 2
+	}
+	for _, p := range report.Problems {
+		fmt.Printf("%s: %s", p.File, p.Kind)
+		if p.Detail != "" {
+			fmt.Printf(" (%s)", p.Detail)
+		}
+		if p.Repair != "" {
+			fmt.Printf(": %s", p.Repair)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("Checked %d files: %d problems, %d repaired.\n", report.Files, len(report.Problems), len(report.Problems)-report.Unresolved())
+	if report.Unresolved() > 0 {
+		return 1
+	}
+	return 0
+}
+
+// listen returns the listener systemd passed under name, or listens on addr.
+func listen(inherited map[string]net.Listener, name, addr string) (net.Listener, error) {
+	if l, ok := inherited[name]; ok {
+		return l, nil
+	}
+	return server.Listen(addr)
+}
+
+// listenAddr returns the address to listen on for port: the value of env or,
+// if unset, of CELERIX_BIND_ADDR, which may be a host, a host:port or
+// unix:<socket path>. Both default to 127.0.0.1.
+func listenAddr(env, port string) string {
+	bind := os.Getenv(env)
+	if bind == "" {
+		bind = os.Getenv("CELERIX_BIND_ADDR")
+	}
+	if bind == "" {
+		bind = "127.0.0.1"
+	}
+	if _, ok := sdk.UnixSocketPath(bind); ok {
+		return bind
+	}
+	if _, _, err := net.SplitHostPort(bind); err == nil {
+		return bind
+	}
+	return net.JoinHostPort(strings.Trim(bind, "[]"), port)
+}
+
+// openNamespaces opens a store for each namespace in spec, a comma-separated
+// list of name or name=token, under <data-dir>/.namespaces/<name> (LoadAll
+// skips dot directories, so they never show up as personas of the default
+// namespace), and serves them on router.
+func openNamespaces(router *server.Router, dataDir, spec string, opts []engine.Option) (map[string]*engine.MemStore, error) {
+	stores := make(map[string]*engine.MemStore)
+	for _, entry := range strings.Split(spec, ",") {
+		name, token, _ := strings.Cut(strings.TrimSpace(entry), "=")
+		if name == "" {
+			continue
+		}
+		if err := sdk.ValidateNamespace(name); err != nil {
+			return nil, err
+		}
+		if _, dup := stores[name]; dup {
+			return nil, fmt.Errorf("namespace %s listed twice", name)
+		}
+		store, err := engine.Open(filepath.Join(dataDir, ".namespaces", name), opts...)
+		if err != nil {
+			return nil, err
+		}
+		if err := router.AddNamespace(name, store, token); err != nil {
+			return nil, err
+		}
+		stores[name] = store
+		auth := "no token"
+		if token != "" {
+			auth = "token required"
+		}
+		fmt.Printf("Namespace %s ready (%s).\n", name, auth)
+	}
+	return stores, nil
+}
+
+// seedStore creates the personas, users and API keys of a seed file, which
+// is YAML if its name ends in .yaml or .yml and JSON otherwise, then waits
+// for them to be saved.
+func seedStore(store *engine.MemStore, path string, preciseNumbers bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	format := sdk.FormatJSON
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		format = sdk.FormatYAML
+	}
+	seed, err := sdk.ReadSeed(f, format, preciseNumbers)
+	if err != nil {
+		return err
+	}
+	result, err := sdk.ApplySeed(store, seed)
+	store.Wait()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Seeded %d values, %d users and %d API keys from %s.\n", result.Records, result.Users, result.APIKeys, path)
+	return nil
+}
+
+// transformOptions loads the transformer plugins, a comma-separated list of
+// files, and enables the transformers spec lists as "apps=name,name" pairs
+// separated by semicolons, where apps is an app ID or a pattern.
+func transformOptions(spec, plugins string) ([]engine.Option, error) {
+	for _, file := range strings.Split(plugins, ",") {
+		if file = strings.TrimSpace(file); file == "" {
+			continue
+		}
+		names, err := engine.LoadTransformerPlugin(file)
+		if err != nil {
+			return nil, fmt.Errorf("load plugin %s: %w", file, err)
+		}
+		fmt.Printf("Loaded transformers from %s: %s\n", file, strings.Join(names, ", "))
+	}
+	var opts []engine.Option
+	for _, entry := range strings.Split(spec, ";") {
+		apps, names, ok := strings.Cut(entry, "=")
+		apps = strings.TrimSpace(apps)
+		if apps == "" && !ok {
+			continue
+		}
+		if apps == "" || strings.TrimSpace(names) == "" {
+			return nil, fmt.Errorf("invalid entry %q: want apps=transformer,...", entry)
+		}
+		if _, err := path.Match(apps, ""); err != nil {
+			return nil, fmt.Errorf("invalid app pattern %q: %w", apps, err)
+		}
+		for _, name := range strings.Split(names, ",") {
+			t, err := engine.LookupTransformer(strings.TrimSpace(name))
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, engine.WithTransformer(t, apps))
+		}
+		fmt.Printf("Transforming writes to %s: %s\n", apps, strings.TrimSpace(names))
+	}
+	return opts, nil
+}
+
+// startJobs schedules the built-in jobs listed in spec, a semicolon-separated
+// list of name=schedule (see jobs.ParseSchedule), on store. Snapshots go to
+// CELERIX_SNAPSHOT_DIR, by default <data-dir>/.snapshots, which LoadAll skips.
+// Purged personas are erased from the snapshots there, even those taken
+// before the jobs were turned off. It returns nil if no jobs are listed.
+func startJobs(spec, dataDir string, store *engine.MemStore) (*jobs.Scheduler, error) {
+	snapshotDir := os.Getenv("CELERIX_SNAPSHOT_DIR")
+	if snapshotDir == "" {
+		snapshotDir = filepath.Join(dataDir, ".snapshots")
+	}
+	store.OnPurge(func(personaID string) error {
+		n, err := jobs.PurgeSnapshots(snapshotDir, personaID)
+		if n > 0 {
+			log.Printf("Purged a persona from %d snapshots", n)
+		}
+		return err
+	})
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	keep := 7
+	if v := os.Getenv("CELERIX_SNAPSHOT_KEEP"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid CELERIX_SNAPSHOT_KEEP: %q", v)
+		}
+		keep = n
+	}
+	var maxAge time.Duration
+	if v := os.Getenv("CELERIX_SNAPSHOT_MAX_AGE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid CELERIX_SNAPSHOT_MAX_AGE: %q", v)
+		}
+		maxAge = d
+	}
+
+	scheduler := jobs.New()
+	for _, entry := range strings.Split(spec, ";") {
+		name, schedule, _ := strings.Cut(entry, "=")
+		name, schedule = strings.TrimSpace(name), strings.TrimSpace(schedule)
+		if name == "" {
+			continue
+		}
+		var fn jobs.Func
+		switch name {
+		case jobs.JobSnapshot:
+			fn = jobs.Snapshot(store, snapshotDir)
+		case jobs.JobRetention:
+			fn = jobs.Retention(snapshotDir, keep, maxAge)
+		case jobs.JobCompaction:
+			fn = jobs.Compaction(store)
+		case jobs.JobUsage:
+			fn = jobs.Usage(store)
+		default:
+			return nil, fmt.Errorf("unknown job %q (want snapshot, retention, compaction or usage)", name)
+		}
+		if err := scheduler.Add(name, schedule, fn); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Job %s scheduled (%s).\n", name, schedule)
+	}
+	scheduler.Start()
+	return scheduler, nil
+}
+
+// startIngest parses CELERIX_INGEST, a semicolon-separated list of source
+// URLs (see ingest.ParseSource).
+func startIngest(store sdk.CelerixStore, spec string, preciseNumbers bool) (*ingest.Ingester, []ingest.Source, error) {
+	opts := ingest.Options{PreciseNumbers: preciseNumbers}
+	if v := os.Getenv("CELERIX_INGEST_DEDUP_WINDOW"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, nil, fmt.Errorf("invalid CELERIX_INGEST_DEDUP_WINDOW: %q", v)
+		}
+		opts.DedupWindow = d
+	}
+	var sources []ingest.Source
+	for _, entry := range strings.Split(spec, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		src, err := ingest.ParseSource(entry)
+		if err != nil {
+			return nil, nil, err
+		}
+		sources = append(sources, src)
+	}
+	return ingest.New(store, opts), sources, nil
+}
+
+// startShadow connects to the shadow daemon and wraps store so that every write
+// is mirrored to it. Divergences are logged and counted in STATS.
+func startShadow(store sdk.CelerixStore, addr string, hasher *engine.PersonaHasher) (*sdk.ShadowStore, error) {
+	target, err := sdk.Connect(addr)
+	if err != nil {
+		return nil, err
+	}
+	opts := sdk.ShadowOptions{
+		Verify: os.Getenv("CELERIX_SHADOW_VERIFY") == "true",
+		OnDivergence: func(d sdk.Divergence) {
+			reason := d.Kind
+			if d.Error != "" {
+				reason = d.Error
+			}
+			log.Printf("Shadow divergence on %s %s/%s/%s: %s", d.Op, hasher.ID(d.PersonaID), d.AppID, d.Key, reason)
+		},
+	}
+	if v := os.Getenv("CELERIX_SHADOW_COMPARE_READS"); v != "" {
+		if opts.CompareReads, err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, fmt.Errorf("invalid CELERIX_SHADOW_COMPARE_READS: %w", err)
+		}
+	}
+	return sdk.NewShadowStore(store, target, opts), nil
+}
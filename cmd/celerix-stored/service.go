@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	serviceName        = "celerix-stored"
+	serviceDisplayName = "Celerix Store"
+	serviceDescription = "Celerix Store daemon: persona/app key-value storage over TCP and HTTP."
+	serviceUsage       = "Usage: celerix-stored service <install|uninstall|start|stop>"
+)
+
+// runService manages celerix-stored as a Windows service or a macOS launchd
+// agent. Linux hosts use systemd units instead (see USAGE.md).
+func runService(args []string) {
+	if len(args) != 1 {
+		log.Fatal(serviceUsage)
+	}
+	var err error
+	switch strings.ToLower(args[0]) {
+	case "install":
+		err = installService()
+	case "uninstall":
+		err = uninstallService()
+	case "start":
+		err = startService()
+	case "stop":
+		err = stopService()
+	default:
+		log.Fatal(serviceUsage)
+	}
+	if err != nil {
+		log.Fatalf("service %s: %v", args[0], err)
+	}
+	fmt.Println("OK")
+}
+
+// serviceEnv returns the environment an installed service runs with, and its
+// data directory: the CELERIX_* variables set when it was installed, with
+// CELERIX_DATA_DIR made absolute and defaulting to the platform's data
+// directory, since services don't start in the directory they were installed
+// from.
+func serviceEnv() (env []string, dataDir string, err error) {
+	dataDir = os.Getenv("CELERIX_DATA_DIR")
+	if dataDir == "" {
+		dataDir = defaultServiceDataDir()
+	}
+	if dataDir, err = filepath.Abs(dataDir); err != nil {
+		return nil, "", err
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, "", err
+	}
+	env = []string{"CELERIX_DATA_DIR=" + dataDir}
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "CELERIX_") && !strings.HasPrefix(kv, "CELERIX_DATA_DIR=") {
+			env = append(env, kv)
+		}
+	}
+	sort.Strings(env)
+	return env, dataDir, nil
+}
+
+// serviceExecutable returns the absolute path of the running binary, which
+// the service definition points at.
+func serviceExecutable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(exe)
+}
@@ -0,0 +1,138 @@
+//go:build darwin
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// launchdLabel names the launchd agent.
+const launchdLabel = "dev.celerix.stored"
+
+func launchdPlistPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", launchdLabel+".plist"), nil
+}
+
+func defaultServiceDataDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, "Library", "Application Support", "Celerix", "data")
+}
+
+// installService writes a launchd agent that starts celerix-stored at login
+// and restarts it if it crashes. Output goes to ~/Library/Logs/Celerix.
+func installService() error {
+	path, err := launchdPlistPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists; uninstall first", path)
+	}
+	exe, err := serviceExecutable()
+	if err != nil {
+		return err
+	}
+	env, dataDir, err := serviceEnv()
+	if err != nil {
+		return err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	logDir := filepath.Join(home, "Library", "Logs", "Celerix")
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return err
+	}
+	logFile := filepath.Join(logDir, serviceName+".log")
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	b.WriteString("<plist version=\"1.0\">\n<dict>\n")
+	plistString(&b, "Label", launchdLabel)
+	b.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n\t\t<string>")
+	xml.EscapeText(&b, []byte(exe))
+	b.WriteString("</string>\n\t</array>\n\t<key>EnvironmentVariables</key>\n\t<dict>\n")
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		b.WriteString("\t")
+		plistString(&b, k, v)
+	}
+	b.WriteString("\t</dict>\n")
+	plistString(&b, "WorkingDirectory", filepath.Dir(dataDir))
+	plistString(&b, "StandardOutPath", logFile)
+	plistString(&b, "StandardErrorPath", logFile)
+	b.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n")
+	b.WriteString("\t<key>KeepAlive</key>\n\t<dict>\n\t\t<key>SuccessfulExit</key>\n\t\t<false/>\n\t</dict>\n")
+	b.WriteString("</dict>\n</plist>\n")
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Installed %s (data in %s, logs in %s)\n", path, dataDir, logFile)
+	return nil
+}
+
+// plistString writes a <key> and its <string> value.
+func plistString(b *bytes.Buffer, key, value string) {
+	b.WriteString("\t<key>")
+	xml.EscapeText(b, []byte(key))
+	b.WriteString("</key>\n\t<string>")
+	xml.EscapeText(b, []byte(value))
+	b.WriteString("</string>\n")
+}
+
+func uninstallService() error {
+	path, err := launchdPlistPath()
+	if err != nil {
+		return err
+	}
+	stopService() // Not loaded is fine
+	return os.Remove(path)
+}
+
+// startService loads the agent, which starts it (RunAtLoad).
+func startService() error {
+	path, err := launchdPlistPath()
+	if err != nil {
+		return err
+	}
+	return launchctl("bootstrap", launchdDomain(), path)
+}
+
+// stopService unloads the agent, so KeepAlive doesn't restart it.
+func stopService() error {
+	return launchctl("bootout", launchdDomain()+"/"+launchdLabel)
+}
+
+func launchdDomain() string {
+	return fmt.Sprintf("gui/%d", os.Getuid())
+}
+
+func launchctl(args ...string) error {
+	out, err := exec.Command("launchctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("launchctl %s: %v: %s", args[0], err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// serviceStart, serviceReady and serviceStopped tie the daemon's lifecycle to
+// a Windows service; launchd stops agents with SIGTERM.
+func serviceStart(stop chan<- os.Signal) {}
+func serviceReady()                      {}
+func serviceStopped()                    {}
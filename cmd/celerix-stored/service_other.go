@@ -0,0 +1,23 @@
+//go:build !windows && !darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+var errUseSystemd = errors.New("not supported on this platform; run celerix-stored as a systemd unit (see USAGE.md)")
+
+func installService() error   { return errUseSystemd }
+func uninstallService() error { return errUseSystemd }
+func startService() error     { return errUseSystemd }
+func stopService() error      { return errUseSystemd }
+
+func defaultServiceDataDir() string { return "/var/lib/celerix" }
+
+// serviceStart, serviceReady and serviceStopped tie the daemon's lifecycle to
+// a Windows service; elsewhere signals and sd_notify do that.
+func serviceStart(stop chan<- os.Signal) {}
+func serviceReady()                      {}
+func serviceStopped()                    {}
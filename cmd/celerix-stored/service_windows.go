@@ -0,0 +1,193 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+func defaultServiceDataDir() string {
+	programData := os.Getenv("ProgramData")
+	if programData == "" {
+		programData = `C:\ProgramData`
+	}
+	return filepath.Join(programData, "Celerix", "data")
+}
+
+// installService registers celerix-stored with the service manager to start
+// at boot and restart after crashes. The CELERIX_* environment is stored in
+// the service's registry key, where the service manager picks it up.
+func installService() error {
+	exe, err := serviceExecutable()
+	if err != nil {
+		return err
+	}
+	env, _, err := serviceEnv()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists; uninstall first", serviceName)
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: serviceDisplayName,
+		Description: serviceDescription,
+		StartType:   mgr.StartAutomatic,
+	})
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	restart := mgr.RecoveryAction{Type: mgr.ServiceRestart, Delay: 5 * time.Second}
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{restart, restart, restart}, 24*60*60); err != nil {
+		return err
+	}
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\`+serviceName, registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+	return key.SetStringsValue("Environment", env)
+}
+
+func uninstallService() error {
+	return withService(func(s *mgr.Service) error {
+		stopAndWait(s) // Already stopped is fine
+		return s.Delete()
+	})
+}
+
+func startService() error {
+	return withService(func(s *mgr.Service) error { return s.Start() })
+}
+
+func stopService() error {
+	return withService(stopAndWait)
+}
+
+func withService(fn func(*mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", serviceName, err)
+	}
+	defer s.Close()
+	return fn(s)
+}
+
+// stopAndWait asks the service to stop and waits for it to flush its data.
+func stopAndWait(s *mgr.Service) error {
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for the service to stop")
+		}
+		time.Sleep(300 * time.Millisecond)
+		if status, err = s.Query(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// windowsService reports the daemon's state to the service manager and turns
+// its stop requests into the shutdown a signal triggers elsewhere.
+type windowsService struct {
+	stop    chan<- os.Signal
+	ready   chan struct{}
+	stopped chan struct{}
+	exited  chan struct{} // closed once the final status is reported
+}
+
+var runningService *windowsService
+
+func (ws *windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	const accepts = svc.AcceptStop | svc.AcceptShutdown
+	status <- svc.Status{State: svc.StartPending}
+	for {
+		select {
+		case <-ws.ready:
+			status <- svc.Status{State: svc.Running, Accepts: accepts}
+			ws.ready = nil
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				ws.stop <- os.Interrupt
+				<-ws.stopped
+				return false, 0
+			}
+		case <-ws.stopped: // Shut down for another reason
+			return false, 0
+		}
+	}
+}
+
+// serviceStart hands control to the service manager when running as a
+// Windows service, logging to celerix-stored.log beside the data directory
+// since services have no console.
+func serviceStart(stop chan<- os.Signal) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return
+	}
+	dataDir := os.Getenv("CELERIX_DATA_DIR")
+	if dataDir == "" {
+		dataDir = defaultServiceDataDir()
+	}
+	if f, err := os.OpenFile(filepath.Join(filepath.Dir(dataDir), serviceName+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err == nil {
+		os.Stdout, os.Stderr = f, f
+		gin.DefaultWriter, gin.DefaultErrorWriter = f, f
+		log.SetOutput(f)
+	}
+	ws := &windowsService{stop: stop, ready: make(chan struct{}), stopped: make(chan struct{}), exited: make(chan struct{})}
+	runningService = ws
+	go func() {
+		defer close(ws.exited)
+		if err := svc.Run(serviceName, ws); err != nil {
+			log.Printf("Warning: Service manager: %v", err)
+		}
+	}()
+}
+
+// serviceReady tells the service manager the daemon is serving.
+func serviceReady() {
+	if runningService != nil {
+		close(runningService.ready)
+	}
+}
+
+// serviceStopped reports the service stopped once data is flushed, before the
+// process exits.
+func serviceStopped() {
+	if runningService != nil {
+		close(runningService.stopped)
+		<-runningService.exited
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor systemd passes to a
+// socket-activated service.
+const listenFDsStart = 3
+
+// systemdListeners returns the sockets systemd passed by socket activation,
+// keyed "tcp" and "http". Sockets are told apart by their FileDescriptorName=
+// in the .socket unit or, without names, by order: the first is the TCP
+// socket and the second the HTTP one. It returns nil if the daemon wasn't
+// socket-activated.
+func systemdListeners() (map[string]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	// Child processes must not take the sockets for their own
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	positional := []string{"tcp", "http"}
+	listeners := make(map[string]net.Listener, n)
+	for i := 0; i < n; i++ {
+		name := ""
+		if i < len(names) && (names[i] == "tcp" || names[i] == "http") {
+			name = names[i]
+		} else if i < len(positional) {
+			name = positional[i]
+		}
+		if name == "" || listeners[name] != nil {
+			return nil, fmt.Errorf("unexpected socket %d: name the sockets tcp and http with FileDescriptorName=", listenFDsStart+i)
+		}
+		file := os.NewFile(uintptr(listenFDsStart+i), "systemd:"+name)
+		l, err := net.FileListener(file)
+		file.Close() // FileListener works on a duplicate
+		if err != nil {
+			return nil, fmt.Errorf("socket %s: %w", name, err)
+		}
+		listeners[name] = l
+	}
+	return listeners, nil
+}
+
+// sdNotify sends a state such as "READY=1" to systemd for Type=notify
+// services. It does nothing outside systemd.
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:] // Abstract namespace
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// startWatchdog pings systemd twice per WatchdogSec= while healthy succeeds,
+// so systemd restarts a daemon that hangs. It does nothing if the watchdog is
+// off.
+func startWatchdog(healthy func() error) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid, err := strconv.Atoi(os.Getenv("WATCHDOG_PID")); err == nil && pid != os.Getpid() {
+		return
+	}
+	interval := time.Duration(usec) * time.Microsecond / 2
+	go func() {
+		for range time.Tick(interval) {
+			if err := healthy(); err != nil {
+				log.Printf("Warning: Health check failed, skipping watchdog ping: %v", err)
+				continue
+			}
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				log.Printf("Warning: Could not ping the systemd watchdog: %v", err)
+			}
+		}
+	}()
+}
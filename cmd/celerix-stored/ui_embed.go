@@ -0,0 +1,20 @@
+//go:build !noui
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed all:dist
+var frontendDist embed.FS
+
+// embeddedUI returns the management UI compiled into the binary.
+func embeddedUI() (fs.FS, bool) {
+	distFS, err := fs.Sub(frontendDist, "dist")
+	if err != nil {
+		return nil, false
+	}
+	return distFS, true
+}
@@ -0,0 +1,11 @@
+//go:build noui
+
+package main
+
+import "io/fs"
+
+// embeddedUI reports that this headless build has no management UI compiled in.
+// The UI can still be served from disk via CELERIX_UI_DIR.
+func embeddedUI() (fs.FS, bool) {
+	return nil, false
+}
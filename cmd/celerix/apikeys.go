@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+const apiKeyUsage = "Usage: celerix APIKEY <CREATE|LIST|REVOKE> [name|id] [--scopes read,write,admin]"
+
+func runAPIKey(keys *sdk.APIKeyStore, args []string) {
+	fs := flag.NewFlagSet("APIKEY", flag.ContinueOnError)
+	scopes := fs.String("scopes", sdk.ScopeRead, "comma-separated scopes: read, write or admin")
+	args = parseArgs(fs, args)
+	if len(args) < 1 {
+		usage(apiKeyUsage)
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "LIST":
+		list, err := keys.List()
+		if err != nil {
+			fatal(err)
+		}
+		for _, k := range list {
+			lastUsed := "never"
+			if !k.LastUsed.IsZero() {
+				lastUsed = k.LastUsed.Format(time.RFC3339)
+			}
+			fmt.Printf("%s  %-20s %-18s last used %s\n", k.ID, k.Name, strings.Join(k.Scopes, ","), lastUsed)
+		}
+	case "CREATE":
+		if len(args) < 2 {
+			usage(apiKeyUsage)
+		}
+		key, rec, err := keys.Create(strings.Join(args[1:], " "), strings.Split(*scopes, ","))
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("Created API key %s (%s)\n", rec.ID, strings.Join(rec.Scopes, ","))
+		fmt.Fprintln(os.Stderr, "Store the key now; it can't be shown again:")
+		fmt.Println(key)
+	case "REVOKE":
+		if len(args) < 2 {
+			usage(apiKeyUsage)
+		}
+		if err := keys.Revoke(args[1]); err != nil {
+			fatal(err)
+		}
+		fmt.Println("OK")
+	default:
+		usage(apiKeyUsage)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+const clusterUsage = "Usage: celerix CLUSTER <STATUS|REBALANCE|JOIN|REMOVE|OWNER> --nodes <addr,addr,...> [addr|personaID] [--dry-run]"
+
+// runCluster manages the personas of daemons sharded with sdk.ConnectCluster.
+// Like MIGRATE, it opens its own connections, one per node. Membership is the
+// node list every client is given, so JOIN and REMOVE move the personas for a
+// new list rather than telling the daemons anything.
+func runCluster(args []string) {
+	fs := flag.NewFlagSet("CLUSTER", flag.ContinueOnError)
+	nodes := fs.String("nodes", "", "comma-separated addresses of every node, as given to the clients")
+	dryRun := fs.Bool("dry-run", false, "report the personas that would move without moving them")
+	args = parseArgs(fs, args)
+	if len(args) < 1 || *nodes == "" {
+		usage(clusterUsage)
+	}
+	addrs := strings.Split(*nodes, ",")
+
+	switch strings.ToUpper(args[0]) {
+	case "OWNER":
+		if len(args) < 2 {
+			usage("Usage: celerix CLUSTER OWNER --nodes <addr,addr,...> <personaID>")
+		}
+		// The owner follows from the node list alone.
+		fmt.Println(sdk.NewHashRing(addrs...).Owner(args[1]))
+	case "STATUS":
+		down := 0
+		fmt.Printf("%-24s %-10s %10s %10s %10s  %s\n", "NODE", "STATUS", "PERSONAS", "MISPLACED", "LATENCY", "VERSION")
+		for _, st := range sdk.ProbeCluster(addrs, clusterOptions()...) {
+			if st.Error != "" {
+				down++
+				fmt.Printf("%-24s %-10s %s\n", st.Name, "down", st.Error)
+				continue
+			}
+			fmt.Printf("%-24s %-10s %10d %10d %8.1fms  %s\n", st.Name, st.Health, st.Personas, st.Misplaced, st.LatencyMs, st.Version)
+		}
+		if down > 0 {
+			fatalf("%d of %d nodes are down", down, len(addrs))
+		}
+	case "REBALANCE":
+		cluster := connectCluster(addrs)
+		defer cluster.Close()
+		reportMoves(cluster.Rebalance(*dryRun, printMove(*dryRun)))
+	case "JOIN":
+		if len(args) < 2 {
+			usage("Usage: celerix CLUSTER JOIN --nodes <addr,addr,...> <addr> [--dry-run]")
+		}
+		if slices.Contains(addrs, args[1]) {
+			fatalf("%s is already a node of the cluster", args[1])
+		}
+		addrs = append(addrs, args[1])
+		cluster := connectCluster(addrs)
+		defer cluster.Close()
+		reportMoves(cluster.Rebalance(*dryRun, printMove(*dryRun)))
+		fmt.Printf("Nodes: %s\n", strings.Join(addrs, ","))
+	case "REMOVE":
+		if len(args) < 2 {
+			usage("Usage: celerix CLUSTER REMOVE --nodes <addr,addr,...> <addr> [--dry-run]")
+		}
+		cluster := connectCluster(addrs)
+		defer cluster.Close()
+		reportMoves(cluster.Drain(args[1], *dryRun, printMove(*dryRun)))
+		fmt.Printf("Nodes: %s\n", strings.Join(slices.DeleteFunc(addrs, func(addr string) bool { return addr == args[1] }), ","))
+	default:
+		usage(clusterUsage)
+	}
+}
+
+// clusterOptions returns the credentials to connect to the nodes with.
+func clusterOptions() []sdk.ClientOption {
+	var opts []sdk.ClientOption
+	if token := os.Getenv("CELERIX_ADMIN_TOKEN"); token != "" {
+		opts = append(opts, sdk.WithAdminToken(token))
+	}
+	if token := os.Getenv("CELERIX_AUTH_TOKEN"); token != "" {
+		opts = append(opts, sdk.WithAuthToken(token))
+	}
+	return opts
+}
+
+func connectCluster(addrs []string) *sdk.Cluster {
+	cluster, err := sdk.ConnectCluster(addrs, clusterOptions()...)
+	if err != nil {
+		fatal(err)
+	}
+	return cluster
+}
+
+// printMove returns the progress callback printing each persona moved.
+func printMove(dryRun bool) func(sdk.RebalanceMove) {
+	verb := "moved"
+	if dryRun {
+		verb = "would move"
+		fmt.Println("Dry run: no data will be moved.")
+	}
+	return func(m sdk.RebalanceMove) {
+		fmt.Printf("  %s: %s %d keys from %s to %s", m.PersonaID, verb, m.Keys, m.From, m.To)
+		if m.Kept > 0 {
+			fmt.Printf(", %d changed meanwhile and were kept", m.Kept)
+		}
+		fmt.Println()
+	}
+}
+
+// reportMoves prints the totals of a rebalance or drain.
+func reportMoves(moves []sdk.RebalanceMove, err error) {
+	keys, kept := 0, 0
+	for _, m := range moves {
+		keys += m.Keys
+		kept += m.Kept
+	}
+	fmt.Printf("Personas: %d, keys: %d, kept: %d\n", len(moves), keys, kept)
+	if err != nil {
+		fatalf("Moving personas failed: %w (run it again to continue)", err)
+	}
+	if kept > 0 {
+		fmt.Println("Run it again to move the kept keys.")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+const diffUsage = "Usage: celerix DIFF <personaA> [personaB] [--app X] [--remote addr]"
+
+// runDiff compares two personas key by key and prints the keys only in A
+// (-), only in B (+) and holding different values (~). With --remote, B is
+// read from another daemon and defaults to the same persona as A. It exits
+// with 1 if they differ, like diff(1).
+func runDiff(client *sdk.Client, opts []sdk.ClientOption, args []string) {
+	fs := flag.NewFlagSet("DIFF", flag.ContinueOnError)
+	app := fs.String("app", "", "only compare this app")
+	remote := fs.String("remote", "", "read personaB from the daemon at this address")
+	args = parseArgs(fs, args)
+	if len(args) < 1 || len(args) > 2 || (len(args) == 1 && *remote == "") {
+		usage(diffUsage)
+	}
+	personaA, personaB := args[0], args[0]
+	if len(args) == 2 {
+		personaB = args[1]
+	}
+
+	other := client
+	if *remote != "" {
+		var err error
+		if other, err = sdk.Connect(*remote, opts...); err != nil {
+			connectFailed(*remote, err)
+		}
+		defer other.Close()
+	}
+
+	a, err := diffSide(client, personaA, *app)
+	if err != nil {
+		fatal(err)
+	}
+	b, err := diffSide(other, personaB, *app)
+	if err != nil {
+		fatal(err)
+	}
+
+	removed, added, changed := 0, 0, 0
+	for _, appID := range slices.Sorted(maps.Keys(union(a, b))) {
+		for _, key := range slices.Sorted(maps.Keys(union(a[appID], b[appID]))) {
+			valA, inA := a[appID][key]
+			valB, inB := b[appID][key]
+			switch {
+			case !inB:
+				removed++
+				fmt.Printf("- %s/%s: %s\n", appID, key, compactJSON(valA))
+			case !inA:
+				added++
+				fmt.Printf("+ %s/%s: %s\n", appID, key, compactJSON(valB))
+			case sdk.Revision(valA) != sdk.Revision(valB):
+				changed++
+				fmt.Printf("~ %s/%s\n    %s: %s\n    %s: %s\n", appID, key, personaA, compactJSON(valA), diffLabel(personaB, *remote), compactJSON(valB))
+			}
+		}
+	}
+
+	if removed+added+changed == 0 {
+		fmt.Println("No differences")
+		return
+	}
+	fmt.Printf("%d removed, %d added, %d changed\n", removed, added, changed)
+	os.Exit(exitError)
+}
+
+// diffSide reads the apps of a persona to compare, or just app if set.
+func diffSide(client *sdk.Client, personaID, app string) (map[string]map[string]any, error) {
+	if app == "" {
+		return client.GetPersona(personaID)
+	}
+	data, err := client.GetAppStore(personaID, app)
+	if errors.Is(err, sdk.ErrAppNotFound) || errors.Is(err, sdk.ErrPersonaNotFound) {
+		return map[string]map[string]any{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]map[string]any{app: data}, nil
+}
+
+func diffLabel(personaID, remote string) string {
+	if remote == "" {
+		return personaID
+	}
+	return personaID + "@" + remote
+}
+
+func union[V any](a, b map[string]V) map[string]bool {
+	keys := make(map[string]bool, len(a)+len(b))
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	return keys
+}
+
+func compactJSON(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
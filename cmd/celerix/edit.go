@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// runEdit opens a value as indented JSON in $VISUAL or $EDITOR and writes it
+// back if it changed. The write only succeeds if nobody else changed the
+// value in the meantime; otherwise the edit is kept in its temporary file.
+// A missing key starts out as {} and is created.
+func runEdit(client *sdk.Client, args []string) {
+	if len(args) < 3 {
+		usage("Usage: celerix EDIT <personaID> <appID> <key>")
+	}
+	personaID, appID, key := args[0], args[1], args[2]
+
+	raw, err := sdk.GetRaw(client, personaID, appID, key)
+	rev := ""
+	switch {
+	case sdk.IsNotFound(err):
+		raw = json.RawMessage("{}")
+	case err != nil:
+		fatal(err)
+	default:
+		rev = sdk.RawRevision(raw)
+	}
+	var original bytes.Buffer
+	if err := json.Indent(&original, raw, "", "  "); err != nil {
+		fatal(err)
+	}
+	original.WriteByte('\n')
+
+	f, err := os.CreateTemp("", "celerix-"+key+"-*.json")
+	if err != nil {
+		fatal(err)
+	}
+	path := f.Name()
+	_, err = f.Write(original.Bytes())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		fatal(err)
+	}
+
+	var val any
+	for {
+		if err := runEditor(path); err != nil {
+			os.Remove(path)
+			fatalf("editor failed: %w", err)
+		}
+		edited, err := os.ReadFile(path)
+		if err != nil {
+			fatal(err)
+		}
+		if bytes.Equal(edited, original.Bytes()) {
+			os.Remove(path)
+			fmt.Println("No changes")
+			return
+		}
+		// Numbers are kept as written, so large integers survive the round trip.
+		if err = sdk.DecodeValue(edited, &val, true); err == nil {
+			break
+		}
+		fmt.Fprintf(os.Stderr, "Invalid JSON: %v\nEdit again? [Y/n] ", err)
+		line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if answer := strings.ToLower(strings.TrimSpace(line)); answer == "n" || answer == "no" {
+			os.Remove(path)
+			fmt.Println("Nothing was written")
+			return
+		}
+	}
+
+	if err := client.SetIfRevision(personaID, appID, key, val, rev); err != nil {
+		if errors.Is(err, sdk.ErrConflict) {
+			fatalf("%s/%s/%s changed while you were editing, your version is in %s: %w", personaID, appID, key, path, err)
+		}
+		fatalf("%w (your version is in %s)", err, path)
+	}
+	os.Remove(path)
+	fmt.Println("OK")
+}
+
+// runEditor opens path in $VISUAL, $EDITOR or vi. The variable may include
+// arguments, like "code --wait".
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	fields := strings.Fields(editor)
+	cmd := exec.Command(fields[0], append(fields[1:], path)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
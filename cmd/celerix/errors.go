@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// Exit codes, so scripts can branch on the kind of failure instead of
+// parsing messages.
+const (
+	exitError       = 1 // Any failure without a more specific code
+	exitUsage       = 2 // Bad arguments, like the flag package; statuses can't be negative
+	exitUnavailable = 3 // The daemon couldn't be reached, or is too busy
+	exitNotFound    = 4 // The persona, app or key doesn't exist
+	exitAuth        = 5 // Missing or wrong credentials
+	exitConflict    = 6 // A conditional write found the value changed
+)
+
+// Codes reported with --json-errors besides the sdk.ErrorCode values.
+const (
+	codeUsage            = "usage"
+	codeConnectionFailed = "connection_failed"
+)
+
+// jsonErrors makes failures print as {"error": ..., "code": ..., "exit": ...}
+// on stderr instead of a log line. Set by --json-errors or CELERIX_JSON_ERRORS=true.
+var jsonErrors = os.Getenv("CELERIX_JSON_ERRORS") == "true"
+
+// errConnection marks errors from reaching the daemon, as opposed to errors
+// the daemon answered with.
+var errConnection = errors.New("connection failed")
+
+// fatal reports err and exits with the code for its kind.
+func fatal(err error) {
+	code, exit := classify(err)
+	exitWith(err.Error(), code, exit)
+}
+
+// fatalf is fatal with a formatted message. Wrap the cause with %w to keep
+// its exit code.
+func fatalf(format string, args ...any) {
+	fatal(fmt.Errorf(format, args...))
+}
+
+// usage reports wrong arguments and exits with exitUsage.
+func usage(format string, args ...any) {
+	exitWith(fmt.Sprintf(format, args...), codeUsage, exitUsage)
+}
+
+// parseFlags parses args into fs, reporting bad flags through usage so they
+// honor --json-errors, and printing the help asked for with -h.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	printUsage := fs.Usage
+	fs.Usage = func() {}
+	fs.SetOutput(io.Discard)
+	err := fs.Parse(args)
+	fs.Usage = printUsage
+	fs.SetOutput(os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		fs.Usage()
+		os.Exit(0)
+	}
+	if err != nil {
+		usage("%v (see celerix %s -h)", err, fs.Name())
+	}
+}
+
+// connectFailed reports that addr couldn't be reached.
+func connectFailed(addr string, err error) {
+	fatal(fmt.Errorf("failed to connect to %s: %w: %w", addr, errConnection, err))
+}
+
+func exitWith(message, code string, exit int) {
+	if jsonErrors {
+		json.NewEncoder(os.Stderr).Encode(map[string]any{"error": message, "code": code, "exit": exit})
+	} else {
+		log.Print(message)
+	}
+	os.Exit(exit)
+}
+
+// classify picks the --json-errors code and exit code for err.
+func classify(err error) (string, int) {
+	var netErr net.Error
+	if errors.Is(err, errConnection) || errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return codeConnectionFailed, exitUnavailable
+	}
+	_, code := sdk.ClassifyError(err)
+	switch code {
+	case sdk.CodePersonaNotFound, sdk.CodeAppNotFound, sdk.CodeKeyNotFound:
+		return string(code), exitNotFound
+	case sdk.CodeUnauthorized:
+		return string(code), exitAuth
+	case sdk.CodeServerBusy:
+		return string(code), exitUnavailable
+	case sdk.CodeConflict:
+		return string(code), exitConflict
+	case sdk.CodeBadRequest, sdk.CodeUnknownCommand:
+		return string(code), exitUsage
+	}
+	return string(code), exitError
+}
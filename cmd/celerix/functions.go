@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+const functionUsage = "Usage: celerix FUNCTION <SET|GET|LIST|DEL> [name] [module.wasm]"
+
+func runFunction(functions *sdk.FunctionStore, args []string) {
+	if len(args) < 1 {
+		usage(functionUsage)
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "LIST":
+		list, err := functions.List()
+		if err != nil {
+			fatal(err)
+		}
+		for _, f := range list {
+			fmt.Printf("%-20s %9d bytes  sha256 %s  updated %s\n", f.Name, f.Size, f.SHA256[:12], f.UpdatedAt.Format(time.RFC3339))
+		}
+	case "SET":
+		if len(args) < 3 {
+			usage("Usage: celerix FUNCTION SET <name> <module.wasm>")
+		}
+		wasm, err := os.ReadFile(args[2])
+		if err != nil {
+			fatal(err)
+		}
+		rec, err := functions.Put(args[1], wasm)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("Stored function %s (%d bytes, sha256 %s)\n", rec.Name, rec.Size, rec.SHA256)
+	case "GET":
+		// With a file, the module is written to it; otherwise only described.
+		if len(args) < 2 {
+			usage("Usage: celerix FUNCTION GET <name> [module.wasm]")
+		}
+		rec, err := functions.Get(args[1])
+		if err != nil {
+			fatal(err)
+		}
+		if len(args) > 2 {
+			if err := os.WriteFile(args[2], rec.WASM, 0o644); err != nil {
+				fatal(err)
+			}
+		}
+		rec.WASM = nil
+		printJSON(rec)
+	case "DEL":
+		if len(args) < 2 {
+			usage("Usage: celerix FUNCTION DEL <name>")
+		}
+		if err := functions.Delete(args[1]); err != nil {
+			fatal(err)
+		}
+		fmt.Println("OK")
+	default:
+		usage(functionUsage)
+	}
+}
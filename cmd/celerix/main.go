@@ -1,49 +1,118 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/celerix-dev/celerix-store/pkg/sdk"
+	"github.com/celerix-dev/celerix-store/pkg/version"
 )
 
 func main() {
-	if len(os.Args) < 2 {
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "--json-errors" {
+		jsonErrors, args = true, args[1:]
+	}
+	if len(args) < 1 {
 		printUsage()
 		return
 	}
 
+	command := strings.ToUpper(args[0])
+	args = args[1:]
+
+	// MIGRATE talks to two daemons and manages its own connections.
+	if command == "MIGRATE" {
+		runMigrate(args)
+		return
+	}
+
+	// CLUSTER talks to every node of a cluster.
+	if command == "CLUSTER" {
+		runCluster(args)
+		return
+	}
+
+	// KEYGEN is local only: the identity must never leave the user's machine.
+	if command == "KEYGEN" {
+		identity, recipient, err := sdk.GenerateExportKey()
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("# created: %s\n# public key: %s\n%s\n", time.Now().Format(time.RFC3339), recipient, identity)
+		fmt.Fprintf(os.Stderr, "Public key: %s\n", recipient)
+		return
+	}
+
 	addr := os.Getenv("CELERIX_STORE_ADDR")
 	if addr == "" {
 		addr = "localhost:7001"
 	}
 
-	client, err := sdk.Connect(addr)
+	var opts []sdk.ClientOption
+	if token := os.Getenv("CELERIX_ADMIN_TOKEN"); token != "" {
+		opts = append(opts, sdk.WithAdminToken(token))
+	}
+	if token := os.Getenv("CELERIX_AUTH_TOKEN"); token != "" {
+		opts = append(opts, sdk.WithAuthToken(token))
+	}
+	if os.Getenv("CELERIX_COMPRESSION") == "true" {
+		opts = append(opts, sdk.WithCompression())
+	}
+	client, err := sdk.Connect(addr, opts...)
 	if err != nil {
-		log.Fatalf("Failed to connect to %s: %v", addr, err)
+		connectFailed(addr, err)
 	}
 	defer client.Close()
 
-	command := strings.ToUpper(os.Args[1])
-	args := os.Args[2:]
-
 	switch command {
 	case "GET":
 		if len(args) < 3 {
-			log.Fatal("Usage: celerix GET <personaID> <appID> <key>")
+			usage("Usage: celerix GET <personaID> <appID> <key> [field1,field2]")
+		}
+		var fields []string
+		if len(args) > 3 {
+			fields = sdk.ParseFields(args[3])
 		}
-		val, err := client.Get(args[0], args[1], args[2])
+		val, err := client.GetFields(args[0], args[1], args[2], fields)
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 		printJSON(val)
 
+	case "EXISTS":
+		if len(args) < 3 {
+			usage("Usage: celerix EXISTS <personaID> <appID> <key>")
+		}
+		ok, err := client.Exists(args[0], args[1], args[2])
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println(ok)
+
+	case "MGET":
+		if len(args) < 3 {
+			usage("Usage: celerix MGET <personaID> <appID> <key> [key...]")
+		}
+		values, err := client.MGet(args[0], args[1], args[2:])
+		if err != nil {
+			fatal(err)
+		}
+		printJSON(values)
+
 	case "SET":
 		if len(args) < 4 {
-			log.Fatal("Usage: celerix SET <personaID> <appID> <key> <value>")
+			usage("Usage: celerix SET <personaID> <appID> <key> <value>")
 		}
 		var val any
 		if err := json.Unmarshal([]byte(args[3]), &val); err != nil {
@@ -52,78 +121,534 @@ func main() {
 		}
 		err := client.Set(args[0], args[1], args[2], val)
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 		fmt.Println("OK")
 
+	case "EDIT":
+		runEdit(client, args)
+
+	case "SET_MERGE":
+		if len(args) < 4 {
+			usage("Usage: celerix SET_MERGE <personaID> <appID> <key> <json-patch>")
+		}
+		var patch any
+		if err := json.Unmarshal([]byte(args[3]), &patch); err != nil {
+			usage("Invalid JSON patch: %v", err)
+		}
+		merged, err := client.Merge(args[0], args[1], args[2], patch)
+		if err != nil {
+			fatal(err)
+		}
+		printJSON(merged)
+
 	case "DEL":
+		fs := flag.NewFlagSet("DEL", flag.ContinueOnError)
+		ifEquals := fs.String("if-equals", "", "only delete if the key holds this JSON value")
+		args = parseArgs(fs, args)
 		if len(args) < 3 {
-			log.Fatal("Usage: celerix DEL <personaID> <appID> <key>")
+			usage("Usage: celerix DEL <personaID> <appID> <key> [--if-equals json]")
+		}
+		var err error
+		if *ifEquals != "" {
+			var expected any
+			if err := json.Unmarshal([]byte(*ifEquals), &expected); err != nil {
+				usage("Invalid JSON value: %v", err)
+			}
+			err = client.DeleteIfEquals(args[0], args[1], args[2], expected)
+		} else {
+			err = client.Delete(args[0], args[1], args[2])
 		}
-		err := client.Delete(args[0], args[1], args[2])
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 		fmt.Println("OK")
 
+	case "DEL_PREFIX":
+		if len(args) < 3 {
+			usage("Usage: celerix DEL_PREFIX <personaID> <appID> <prefix>")
+		}
+		n, err := client.DeletePrefix(args[0], args[1], args[2])
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("Deleted %d keys\n", n)
+
 	case "LIST_PERSONAS":
-		list, err := client.GetPersonas()
+		fs := flag.NewFlagSet("LIST_PERSONAS", flag.ContinueOnError)
+		verbose := fs.Bool("verbose", false, "include each persona's metadata")
+		parseArgs(fs, args)
+		var list any
+		var err error
+		if *verbose {
+			list, err = client.GetPersonasVerbose()
+		} else {
+			list, err = client.GetPersonas()
+		}
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 		printJSON(list)
 
 	case "LIST_APPS":
+		fs := flag.NewFlagSet("LIST_APPS", flag.ContinueOnError)
+		verbose := fs.Bool("verbose", false, "include each app's metadata")
+		args = parseArgs(fs, args)
 		if len(args) < 1 {
-			log.Fatal("Usage: celerix LIST_APPS <personaID>")
+			usage("Usage: celerix LIST_APPS <personaID> [--verbose]")
+		}
+		var list any
+		var err error
+		if *verbose {
+			list, err = client.GetAppsVerbose(args[0])
+		} else {
+			list, err = client.GetApps(args[0])
 		}
-		list, err := client.GetApps(args[0])
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 		printJSON(list)
 
+	case "META":
+		runMeta(client, args)
+
+	case "TREE":
+		runTree(client, args)
+
+	case "DIFF":
+		runDiff(client, opts, args)
+
 	case "DUMP":
 		if len(args) < 2 {
-			log.Fatal("Usage: celerix DUMP <personaID> <appID>")
+			usage("Usage: celerix DUMP <personaID> <appID> [field1,field2]")
+		}
+		var fields []string
+		if len(args) > 2 {
+			fields = sdk.ParseFields(args[2])
+		}
+		data, err := client.GetAppStoreFields(args[0], args[1], fields)
+		if err != nil {
+			fatal(err)
 		}
-		data, err := client.GetAppStore(args[0], args[1])
+		printJSON(data)
+
+	case "DUMP_PERSONA":
+		if len(args) < 1 {
+			usage("Usage: celerix DUMP_PERSONA <personaID>")
+		}
+		data, err := client.GetPersona(args[0])
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 		printJSON(data)
 
 	case "DUMP_APP":
+		fs := flag.NewFlagSet("DUMP_APP", flag.ContinueOnError)
+		stream := fs.Bool("stream", false, "print one persona per line as it arrives")
+		redact := redactFlag(fs)
+		args = parseArgs(fs, args)
 		if len(args) < 1 {
-			log.Fatal("Usage: celerix DUMP_APP <appID>")
+			usage("Usage: celerix DUMP_APP <appID> [--stream] [--redact rules]")
+		}
+		redaction := parseRedaction(*redact)
+		if *stream {
+			enc := json.NewEncoder(os.Stdout)
+			err := client.StreamApp(args[0], "", func(personaID string, data map[string]any) error {
+				return enc.Encode(map[string]any{"persona": personaID, "data": redaction.RedactApp(args[0], data)})
+			})
+			if err != nil {
+				fatal(err)
+			}
+			break
 		}
 		data, err := client.DumpApp(args[0])
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
+		}
+		for personaID, appData := range data {
+			data[personaID] = redaction.RedactApp(args[0], appData)
 		}
 		printJSON(data)
 
 	case "GET_GLOBAL":
 		if len(args) < 2 {
-			log.Fatal("Usage: celerix GET_GLOBAL <appID> <key>")
+			usage("Usage: celerix GET_GLOBAL <appID> <key>")
 		}
 		val, personaID, err := client.GetGlobal(args[0], args[1])
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 		fmt.Printf("Persona: %s\n", personaID)
 		printJSON(val)
 
+	case "SEARCH":
+		fs := flag.NewFlagSet("SEARCH", flag.ContinueOnError)
+		app := fs.String("app", "", "only search apps matching this pattern")
+		limit := fs.Int("limit", sdk.DefaultSearchLimit, "hits per page")
+		after := fs.String("after", "", "continue after this persona/app/key")
+		all := fs.Bool("all", false, "fetch every page")
+		args = parseArgs(fs, args)
+		if len(args) < 1 {
+			usage("Usage: celerix SEARCH <keyPattern> [--app pattern] [--limit N] [--after persona/app/key] [--all]")
+		}
+		opts := sdk.SearchOptions{App: *app, Limit: *limit, After: *after}
+		for {
+			res, err := client.Search(args[0], opts)
+			if err != nil {
+				fatal(err)
+			}
+			for _, hit := range res.Hits {
+				fmt.Printf("%s/%s/%s\n", hit.PersonaID, hit.AppID, hit.Key)
+			}
+			if res.Next == "" {
+				break
+			}
+			if !*all {
+				fmt.Fprintf(os.Stderr, "More results: --after %s\n", res.Next)
+				break
+			}
+			opts.After = res.Next
+		}
+
 	case "MOVE":
+		fs := flag.NewFlagSet("MOVE", flag.ContinueOnError)
+		toApp := fs.String("to-app", "", "move into this app instead")
+		toKey := fs.String("to-key", "", "rename the key")
+		args = parseArgs(fs, args)
 		if len(args) < 4 {
-			log.Fatal("Usage: celerix MOVE <srcPersona> <dstPersona> <appID> <key>")
+			usage("Usage: celerix MOVE <srcPersona> <dstPersona> <appID> <key> [--to-app X] [--to-key Y]")
+		}
+		var err error
+		if *toApp == "" && *toKey == "" {
+			err = client.Move(args[0], args[1], args[2], args[3])
+		} else {
+			if *toApp == "" {
+				*toApp = args[2]
+			}
+			if *toKey == "" {
+				*toKey = args[3]
+			}
+			err = client.MoveKey(args[0], args[2], args[1], *toApp, args[3], *toKey)
 		}
-		err := client.Move(args[0], args[1], args[2], args[3])
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
+		}
+		fmt.Println("OK")
+
+	case "MERGE_PERSONA":
+		fs := flag.NewFlagSet("MERGE_PERSONA", flag.ContinueOnError)
+		strategy := fs.String("strategy", string(sdk.MergeFailOnConflict), "fail-on-conflict, prefer-src or prefer-dst")
+		args = parseArgs(fs, args)
+		if len(args) < 2 {
+			usage("Usage: celerix MERGE_PERSONA <srcPersona> <dstPersona> [--strategy fail-on-conflict|prefer-src|prefer-dst]")
+		}
+		if err := client.MergePersona(args[0], args[1], sdk.MergeStrategy(*strategy)); err != nil {
+			fatal(err)
 		}
 		fmt.Println("OK")
 
+	case "PURGE_PERSONA":
+		fs := flag.NewFlagSet("PURGE_PERSONA", flag.ContinueOnError)
+		confirm := fs.String("confirm", "", "the persona ID again, to skip the prompt")
+		args = parseArgs(fs, args)
+		if len(args) < 1 {
+			usage("Usage: celerix PURGE_PERSONA <personaID> [--confirm personaID]")
+		}
+		if *confirm == "" {
+			fmt.Fprintf(os.Stderr, "This erases every trace of %s and can't be undone.\nType the persona ID to confirm: ", args[0])
+			line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			*confirm = strings.TrimSpace(line)
+		}
+		if *confirm != args[0] {
+			usage("Confirmation doesn't match the persona ID, nothing was purged")
+		}
+		report, err := client.PurgePersona(args[0])
+		if err != nil {
+			fatal(err)
+		}
+		printJSON(report)
+
+	case "WATCH":
+		if len(args) < 2 {
+			usage("Usage: celerix WATCH <personaID> <appID> [prefix]")
+		}
+		prefix := ""
+		if len(args) > 2 {
+			prefix = args[2]
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		events, err := client.Watch(ctx, args[0], args[1], prefix)
+		if err != nil {
+			fatal(err)
+		}
+		// One JSON object per line so the output can be piped into jq or a log file.
+		enc := json.NewEncoder(os.Stdout)
+		for e := range events {
+			enc.Encode(e)
+		}
+
+	case "IMPORT":
+		fs := flag.NewFlagSet("IMPORT", flag.ContinueOnError)
+		format := fs.String("format", "ndjson", "ndjson, json, yaml, csv or env")
+		personaID := fs.String("persona", "", "persona to import into (required for csv and env)")
+		appID := fs.String("app", "", "app to import into (required for env)")
+		args = parseArgs(fs, args)
+		if len(args) < 1 {
+			usage("Usage: celerix IMPORT <file|-> [skip] [--format ndjson|json|yaml|csv|env] [--persona X] [--app Y]")
+		}
+		in := os.Stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				fatal(err)
+			}
+			defer f.Close()
+			in = f
+		}
+		if *format != "ndjson" {
+			exp, err := sdk.ReadExportFormat(in, *format, *personaID, *appID)
+			if err != nil {
+				fatal(err)
+			}
+			n, err := sdk.ImportPersona(client, exp)
+			if err != nil {
+				fatalf("Import failed: %w", err)
+			}
+			fmt.Printf("Imported %d values into %s.\n", n, exp.PersonaID)
+			return
+		}
+		var opts sdk.ImportOptions
+		if len(args) > 1 {
+			skip, err := strconv.ParseInt(args[1], 10, 64)
+			if err != nil {
+				usage("Invalid skip count: %v", err)
+			}
+			opts.Skip = skip
+		}
+		opts.Checkpoint = func(records int64) {
+			fmt.Fprintf(os.Stderr, "checkpoint: %d records\n", records)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		result, err := client.Import(ctx, in, opts)
+		if err != nil {
+			fatalf("Import failed: %w (resume with skip %d)", err, result.Records)
+		}
+		fmt.Printf("Imported %d records (%d read).\n", result.Applied, result.Records)
+
+	case "EXPORT":
+		fs := flag.NewFlagSet("EXPORT", flag.ContinueOnError)
+		format := fs.String("format", sdk.FormatJSON, "json, yaml, csv or env")
+		appID := fs.String("app", "", "export only this app (required for env with several apps)")
+		redact := redactFlag(fs)
+		args = parseArgs(fs, args)
+		if len(args) < 2 {
+			usage("Usage: celerix EXPORT <personaID> <file|-> [age-recipient] [--format json|yaml|csv|env] [--app Y] [--redact rules]")
+		}
+		redaction := parseRedaction(*redact)
+		var recipient string
+		if len(args) > 2 {
+			if *format != sdk.FormatJSON {
+				usage("Encrypted exports are JSON only")
+			}
+			recipient = args[2]
+			if err := sdk.ValidateExportRecipient(recipient); err != nil {
+				fatal(err)
+			}
+		}
+		exp, err := sdk.ExportPersona(client, args[0])
+		if err != nil {
+			fatal(err)
+		}
+		if *appID != "" {
+			data, ok := exp.Apps[*appID]
+			if !ok {
+				fatalf("%s has no app %s: %w", args[0], *appID, sdk.ErrAppNotFound)
+			}
+			exp.Apps = map[string]map[string]any{*appID: data}
+		}
+		exp = redaction.RedactExport(exp)
+		out := os.Stdout
+		if args[1] != "-" {
+			f, err := os.OpenFile(args[1], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+			if err != nil {
+				fatal(err)
+			}
+			defer f.Close()
+			out = f
+		}
+		if recipient != "" {
+			err = sdk.WriteExport(out, exp, recipient)
+		} else {
+			err = sdk.WriteExportFormat(out, exp, *format)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		if args[1] != "-" {
+			fmt.Fprintf(os.Stderr, "Exported %d apps of %s.\n", len(exp.Apps), args[0])
+		}
+
+	case "SCHEMA":
+		if len(args) < 2 {
+			usage("Usage: celerix SCHEMA <GET|SET|DEL> <appID> [schema.json|-|json]")
+		}
+		switch sub, appID := strings.ToUpper(args[0]), args[1]; sub {
+		case "GET":
+			schema, err := sdk.GetSchema(client, appID)
+			if err != nil {
+				fatal(err)
+			}
+			printJSON(schema)
+		case "SET":
+			if len(args) < 3 {
+				usage("Usage: celerix SCHEMA SET <appID> <schema.json|-|json>")
+			}
+			schema, err := readSchemaArg(args[2])
+			if err != nil {
+				fatal(err)
+			}
+			if err := sdk.SetSchema(client, appID, schema); err != nil {
+				fatal(err)
+			}
+			fmt.Println("OK")
+		case "DEL":
+			if err := sdk.DeleteSchema(client, appID); err != nil {
+				fatal(err)
+			}
+			fmt.Println("OK")
+		default:
+			usage("Unknown SCHEMA command: %s", sub)
+		}
+
+	case "USER":
+		runUser(sdk.NewUserStore(client), args)
+
+	case "APIKEY":
+		runAPIKey(sdk.NewAPIKeyStore(client), args)
+
+	case "FUNCTION":
+		runFunction(sdk.NewFunctionStore(client), args)
+
+	case "COMPUTE":
+		if len(args) < 3 {
+			usage("Usage: celerix COMPUTE <function> <personaID|*> <appID> [args json]")
+		}
+		var fnArgs any
+		if len(args) > 3 {
+			if err := json.Unmarshal([]byte(strings.Join(args[3:], " ")), &fnArgs); err != nil {
+				fatalf("invalid args: %v: %w", err, sdk.ErrBadRequest)
+			}
+		}
+		result, err := client.Compute(args[0], args[1], args[2], fnArgs)
+		if err != nil {
+			fatal(err)
+		}
+		printJSON(result)
+
+	case "STATS":
+		stats, err := client.Stats()
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("Personas: %d  Apps: %d  Keys: %d\n", stats.Personas, stats.Apps, stats.Keys)
+		fmt.Printf("Memory: %s", formatBytes(stats.MemoryBytes))
+		if stats.MemoryLimit > 0 {
+			fmt.Printf(" of %s (%s)  Evictions: %d  Evicted personas: %d",
+				formatBytes(stats.MemoryLimit), stats.EvictionPolicy, stats.Evictions, stats.EvictedPersonas)
+		}
+		fmt.Println()
+		if srv := stats.Server; srv != nil {
+			fmt.Printf("Connections: %d of %d  Accepted: %d  Rejected (busy): %d\n",
+				srv.ActiveConnections, srv.MaxConnections, srv.Accepted, srv.Rejected)
+		}
+		if sh := stats.Shadow; sh != nil {
+			fmt.Printf("Shadow: %d mirrored, %d dropped, %d errors, %d compared, %d diverged\n",
+				sh.Mirrored, sh.Dropped, sh.Errors, sh.Compared, sh.Diverged)
+		}
+		if len(stats.TopMemory) > 0 {
+			fmt.Println("\nLargest namespaces:")
+			for _, ns := range stats.TopMemory {
+				fmt.Printf("  %-24s %-24s %10s\n", ns.PersonaID, ns.AppID, formatBytes(ns.Bytes))
+			}
+			fmt.Println()
+		}
+		if len(stats.TopContended) == 0 {
+			fmt.Println("No write contention recorded.")
+			break
+		}
+		fmt.Println("\nTop contended namespaces:")
+		fmt.Printf("  %-24s %-24s %10s %10s %10s %12s %10s\n", "PERSONA", "APP", "WRITES", "CONTENDED", "CONFLICTS", "TOTAL WAIT", "MAX WAIT")
+		for _, ns := range stats.TopContended {
+			fmt.Printf("  %-24s %-24s %10d %9.1f%% %9.1f%% %10.2fms %8.2fms\n",
+				ns.PersonaID, ns.AppID, ns.Writes, ns.ContentionRate()*100, ns.ConflictRate()*100, ns.TotalWaitMs, ns.MaxWaitMs)
+		}
+		fmt.Println("\nHot namespaces serialize on the store lock, and conflicts mean clients race on the same keys;")
+		fmt.Println("consider spreading their keys across apps or personas.")
+
+	case "USAGE":
+		report, err := client.Usage()
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("%-24s %-24s %10s %10s\n", "PERSONA", "APP", "KEYS", "SIZE")
+		for _, app := range report.Apps {
+			fmt.Printf("%-24s %-24s %10d %10s\n", app.PersonaID, app.AppID, app.Keys, formatBytes(app.Bytes))
+		}
+		fmt.Printf("%-49s %10d %10s\n", "TOTAL", report.Keys, formatBytes(report.Bytes))
+
+	case "VERSION":
+		fmt.Println("client:", version.Get())
+		if server := client.ServerVersion(); server.Version != "" {
+			fmt.Println("server:", server)
+		} else {
+			fmt.Println("server: unknown (daemon predates version reporting)")
+		}
+
+	case "INFO":
+		health, err := client.Health()
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("Status: %s\n", health.Status)
+		for _, q := range health.Quarantined {
+			fmt.Printf("  quarantined %s since %s: %s", q.PersonaID, q.Since.Format(time.RFC3339), q.Reason)
+			if q.File != "" {
+				fmt.Printf(" (%s)", q.File)
+			}
+			fmt.Println()
+		}
+		for _, f := range health.SaveFailures {
+			target := f.PersonaID
+			if f.AppID != "" {
+				target += "/" + f.AppID
+			}
+			fmt.Printf("  saving %s failing since %s (%d attempts): %s\n", target, f.Since.Format(time.RFC3339), f.Attempts, f.Error)
+		}
+
+	case "FSCK":
+		report, err := client.Fsck()
+		if err != nil {
+			fatal(err)
+		}
+		for _, p := range report.Problems {
+			name := p.File
+			if name == "" {
+				name = p.PersonaID
+			}
+			fmt.Printf("  %s: %s", name, p.Kind)
+			if p.Detail != "" {
+				fmt.Printf(" (%s)", p.Detail)
+			}
+			fmt.Println()
+		}
+		fmt.Printf("Checked %d files: %d problems.\n", report.Files, len(report.Problems))
+		if len(report.Problems) > 0 {
+			fmt.Println("Stop the daemon and run celerix-stored --fsck --repair to fix them.")
+			os.Exit(1)
+		}
+
 	case "PING":
 		// PING is not explicitly in SDK but we can implement it or just use a simple check
 		// For now let's just use LIST_PERSONAS as a health check or add Ping to SDK
@@ -132,25 +657,118 @@ func main() {
 	default:
 		fmt.Printf("Unknown command: %s\n", command)
 		printUsage()
+		os.Exit(exitUsage)
 	}
 }
 
 func printUsage() {
 	fmt.Println("Celerix CLI - Interface for celerix-store")
-	fmt.Println("\nUsage:")
-	fmt.Println("  celerix GET <personaID> <appID> <key>")
+	fmt.Println("\nUsage: celerix [--json-errors] <command> [args]")
+	fmt.Println("  celerix GET <personaID> <appID> <key> [field1,field2]")
+	fmt.Println("  celerix EXISTS <personaID> <appID> <key>")
+	fmt.Println("  celerix MGET <personaID> <appID> <key> [key...]")
 	fmt.Println("  celerix SET <personaID> <appID> <key> <value>")
-	fmt.Println("  celerix DEL <personaID> <appID> <key>")
-	fmt.Println("  celerix LIST_PERSONAS")
-	fmt.Println("  celerix LIST_APPS <personaID>")
-	fmt.Println("  celerix DUMP <personaID> <appID>")
-	fmt.Println("  celerix DUMP_APP <appID>")
+	fmt.Println("  celerix EDIT <personaID> <appID> <key>")
+	fmt.Println("  celerix SET_MERGE <personaID> <appID> <key> <json-patch>")
+	fmt.Println("  celerix DEL <personaID> <appID> <key> [--if-equals json]")
+	fmt.Println("  celerix DEL_PREFIX <personaID> <appID> <prefix>")
+	fmt.Println("  celerix LIST_PERSONAS [--verbose]")
+	fmt.Println("  celerix LIST_APPS <personaID> [--verbose]")
+	fmt.Println("  celerix META <GET|SET> <personaID> [appID] [--name X] [--description Y] [--labels k=v,k2=v2]")
+	fmt.Println("  celerix TREE [personaID] [--depth 1|2|3]")
+	fmt.Println("  celerix DIFF <personaA> [personaB] [--app X] [--remote addr]")
+	fmt.Println("  celerix DUMP <personaID> <appID> [field1,field2]")
+	fmt.Println("  celerix DUMP_PERSONA <personaID>")
+	fmt.Println("  celerix DUMP_APP <appID> [--stream] [--redact rules]")
 	fmt.Println("  celerix GET_GLOBAL <appID> <key>")
-	fmt.Println("  celerix MOVE <srcPersona> <dstPersona> <appID> <key>")
+	fmt.Println("  celerix SEARCH <keyPattern> [--app pattern] [--limit N] [--after persona/app/key] [--all]")
+	fmt.Println("  celerix MOVE <srcPersona> <dstPersona> <appID> <key> [--to-app X] [--to-key Y]")
+	fmt.Println("  celerix MERGE_PERSONA <srcPersona> <dstPersona> [--strategy fail-on-conflict|prefer-src|prefer-dst]")
+	fmt.Println("  celerix PURGE_PERSONA <personaID> [--confirm personaID]")
+	fmt.Println("  celerix WATCH <personaID> <appID> [prefix]")
+	fmt.Println("  celerix IMPORT <file|-> [skip] [--format ndjson|json|yaml|csv|env] [--persona X] [--app Y]")
+	fmt.Println("  celerix EXPORT <personaID> <file|-> [age-recipient] [--format json|yaml|csv|env] [--app Y] [--redact rules]")
+	fmt.Println("  celerix SCHEMA <GET|SET|DEL> <appID> [schema.json|-|json]")
+	fmt.Println("  celerix USER <ADD|LIST|GET|PASSWD|DISABLE|ENABLE|RECOVERY|DEL> [username|id] [display name]")
+	fmt.Println("  celerix APIKEY <CREATE|LIST|REVOKE> [name|id] [--scopes read,write,admin]")
+	fmt.Println("  celerix FUNCTION <SET|GET|LIST|DEL> [name] [module.wasm]")
+	fmt.Println("  celerix COMPUTE <function> <personaID|*> <appID> [args json]")
+	fmt.Println("  celerix KEYGEN")
+	fmt.Println("  celerix STATS")
+	fmt.Println("  celerix USAGE")
+	fmt.Println("  celerix INFO")
+	fmt.Println("  celerix FSCK")
+	fmt.Println("  celerix VERSION")
+	fmt.Println("  celerix MIGRATE --from <addr> --to <addr> [--persona X] [--app Y] [--dry-run] [--diff] [--conflict skip|overwrite] [--resume-after persona/app] [--workers N] [--batch-size N]")
+	fmt.Println("  celerix CLUSTER <STATUS|REBALANCE|JOIN|REMOVE|OWNER> --nodes <addr,addr,...> [addr|personaID] [--dry-run]")
 	fmt.Println("  celerix PING")
+	fmt.Println("\nOptions:")
+	fmt.Println("  --json-errors         Print failures as JSON ({\"error\", \"code\", \"exit\"}) on stderr")
+	fmt.Println("\nExit codes: 1 error, 2 usage, 3 daemon unreachable or busy, 4 not found, 5 unauthorized, 6 conflict")
 	fmt.Println("\nEnvironment Variables:")
-	fmt.Println("  CELERIX_STORE_ADDR    Address of the store (default: localhost:7001)")
+	fmt.Println("  CELERIX_STORE_ADDR    Address of the store, host:port or unix:///path (default: localhost:7001)")
 	fmt.Println("  CELERIX_DISABLE_TLS   Set to true to disable TLS")
+	fmt.Println("  CELERIX_ADMIN_TOKEN   Admin token for writes to the _system persona")
+	fmt.Println("  CELERIX_AUTH_TOKEN    Access token for daemons that require authentication")
+	fmt.Println("  EDITOR, VISUAL        Editor for EDIT (default: vi)")
+	fmt.Println("  CELERIX_COMPRESSION   Set to true to compress large commands and responses")
+	fmt.Println("  CELERIX_JSON_ERRORS   Set to true for --json-errors")
+	fmt.Println("  CELERIX_REDACT        Default --redact rules for EXPORT and DUMP_APP")
+}
+
+// parseArgs parses fs's flags wherever they appear among args and returns the
+// positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		parseFlags(fs, args)
+		if fs.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// redactFlag adds --redact to fs, defaulting to CELERIX_REDACT like the
+// daemon's exports.
+func redactFlag(fs *flag.FlagSet) *string {
+	return fs.String("redact", os.Getenv("CELERIX_REDACT"), "hide values matching these rules, e.g. vault,billing/card_*,*/*/password")
+}
+
+// parseRedaction reads the rules of --redact; none means nothing is hidden.
+func parseRedaction(spec string) *sdk.RedactionPolicy {
+	if spec == "" {
+		return nil
+	}
+	policy, err := sdk.ParseRedactionPolicy(spec)
+	if err != nil {
+		usage("Invalid --redact: %v", err)
+	}
+	return policy
+}
+
+// readSchemaArg reads a schema given inline, from a file, or from stdin ("-").
+func readSchemaArg(arg string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(arg), "{") {
+		return []byte(arg), nil
+	}
+	if arg == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(arg)
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
 }
 
 func printJSON(v any) {
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/celerix-dev/celerix-store/pkg/schema"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+const metaUsage = "Usage: celerix META <GET|SET> <personaID> [appID] [--name X] [--description Y] [--labels k=v,k2=v2]"
+
+func runMeta(client *sdk.Client, args []string) {
+	fs := flag.NewFlagSet("META", flag.ContinueOnError)
+	name := fs.String("name", "", "display name")
+	description := fs.String("description", "", "description")
+	labels := fs.String("labels", "", "comma-separated key=value labels")
+	args = parseArgs(fs, args)
+	if len(args) < 2 {
+		usage(metaUsage)
+	}
+	personaID, appID := args[1], ""
+	if len(args) > 2 {
+		appID = args[2]
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		var meta schema.Metadata
+		var err error
+		if appID != "" {
+			meta, err = sdk.GetAppMeta(client, personaID, appID)
+		} else {
+			meta, err = sdk.GetPersonaMeta(client, personaID)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		printJSON(meta)
+	case "SET":
+		meta := schema.Metadata{DisplayName: *name, Description: *description}
+		if *labels != "" {
+			meta.Labels = map[string]string{}
+			for _, pair := range strings.Split(*labels, ",") {
+				k, v, ok := strings.Cut(pair, "=")
+				if !ok {
+					usage("Invalid label %q, want key=value", pair)
+				}
+				meta.Labels[k] = v
+			}
+		}
+		var err error
+		if appID != "" {
+			err = sdk.SetAppMeta(client, personaID, appID, meta)
+		} else {
+			err = sdk.SetPersonaMeta(client, personaID, meta)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println("OK")
+	default:
+		usage(metaUsage)
+	}
+}
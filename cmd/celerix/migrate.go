@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/celerix-dev/celerix-store/pkg/engine"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// runMigrate copies data between two daemons using engine.MigrateWithOptions.
+func runMigrate(args []string) {
+	fs := flag.NewFlagSet("MIGRATE", flag.ContinueOnError)
+	from := fs.String("from", "", "address of the source daemon")
+	to := fs.String("to", "", "address of the destination daemon")
+	persona := fs.String("persona", "", "only migrate this persona")
+	app := fs.String("app", "", "only migrate this app")
+	dryRun := fs.Bool("dry-run", false, "report what would be copied without writing")
+	conflict := fs.String("conflict", "overwrite", "what to do with keys that exist in the destination with another value: skip or overwrite")
+	diff := fs.Bool("diff", false, "print every key whose value differs in the destination")
+	resume := fs.String("resume-after", "", "skip persona/app pairs up to this one, as printed by an interrupted run")
+	workers := fs.Int("workers", engine.DefaultMigrateWorkers, "number of apps to transfer at once")
+	batchSize := fs.Int("batch-size", sdk.DefaultImportBatchSize, "number of keys to write per batch")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: celerix MIGRATE --from <addr> --to <addr> [--persona X] [--app Y] [--dry-run] [--diff] [--conflict skip|overwrite] [--resume-after persona/app] [--workers N] [--batch-size N]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	if *from == "" || *to == "" {
+		usage("MIGRATE needs --from and --to (see celerix MIGRATE -h)")
+	}
+
+	opts := engine.MigrateOptions{
+		PersonaID:   *persona,
+		AppID:       *app,
+		DryRun:      *dryRun,
+		ResumeAfter: *resume,
+		Workers:     *workers,
+		BatchSize:   *batchSize,
+	}
+	switch *conflict {
+	case "overwrite":
+		opts.OnConflict = engine.ConflictOverwrite
+	case "skip":
+		opts.OnConflict = engine.ConflictSkip
+	default:
+		usage("Unknown conflict policy %q (expected skip or overwrite)", *conflict)
+	}
+
+	var connOpts []sdk.ClientOption
+	if os.Getenv("CELERIX_COMPRESSION") == "true" {
+		connOpts = append(connOpts, sdk.WithCompression())
+	}
+	src, err := sdk.Connect(*from, connOpts...)
+	if err != nil {
+		connectFailed(*from, err)
+	}
+	defer src.Close()
+
+	dst, err := sdk.Connect(*to, connOpts...)
+	if err != nil {
+		connectFailed(*to, err)
+	}
+	defer dst.Close()
+
+	verb := "copied"
+	if opts.DryRun {
+		verb = "would copy"
+		fmt.Println("Dry run: no data will be written.")
+	}
+	last := *resume
+	opts.Progress = func(p engine.MigrateProgress) {
+		fmt.Printf("  %s: %s %d keys, skipped %d, conflicts %d, unchanged %d\n", p.Cursor(), verb, p.Copied, p.Skipped, p.Conflicts, p.Unchanged)
+		last = p.Cursor()
+	}
+	if *diff {
+		opts.OnDiff = func(d engine.MigrateDiff) {
+			srcJSON, _ := json.Marshal(d.Src)
+			dstJSON, _ := json.Marshal(d.Dst)
+			fmt.Printf("  ~ %s/%s/%s\n      source:      %s\n      destination: %s\n", d.PersonaID, d.AppID, d.Key, srcJSON, dstJSON)
+		}
+	}
+
+	result, err := engine.MigrateWithOptions(src, dst, opts)
+	if err != nil {
+		if last != "" && !opts.DryRun {
+			fatalf("Migration failed: %w (resume with --resume-after %s)", err, last)
+		}
+		fatalf("Migration failed: %w", err)
+	}
+	fmt.Printf("Done: %d personas, %d apps, %s %d keys, skipped %d, conflicts %d, unchanged %d\n",
+		result.Personas, result.Apps, verb, result.Copied, result.Skipped, result.Conflicts, result.Unchanged)
+}
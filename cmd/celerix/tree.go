@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+const treeUsage = "Usage: celerix TREE [personaID] [--depth 1|2|3]"
+
+// runTree prints personas, their apps and their keys as an indented tree,
+// with the type and encoded size of every value.
+func runTree(client *sdk.Client, args []string) {
+	fs := flag.NewFlagSet("TREE", flag.ContinueOnError)
+	depth := fs.Int("depth", 3, "levels to show: 1 personas, 2 apps, 3 keys")
+	args = parseArgs(fs, args)
+	if len(args) > 1 || *depth < 1 {
+		usage(treeUsage)
+	}
+
+	var personas []string
+	if len(args) == 1 {
+		personas = args
+	} else {
+		var err error
+		if personas, err = client.GetPersonas(); err != nil {
+			fatal(err)
+		}
+	}
+	sort.Strings(personas)
+
+	for _, personaID := range personas {
+		data, err := client.GetPersona(personaID)
+		if err != nil {
+			fatal(err)
+		}
+		if len(args) == 1 && len(data) == 0 {
+			fatal(sdk.ErrPersonaNotFound)
+		}
+		var size int64
+		keys := 0
+		for _, appData := range data {
+			keys += len(appData)
+			size += encodedSize(appData)
+		}
+		fmt.Printf("%s (%d apps, %d keys, %s)\n", personaID, len(data), keys, formatBytes(size))
+		if *depth > 1 {
+			printApps(data, *depth)
+		}
+	}
+}
+
+func printApps(data map[string]map[string]any, depth int) {
+	appIDs := slices.Sorted(maps.Keys(data))
+	for i, appID := range appIDs {
+		appData := data[appID]
+		last := i == len(appIDs)-1
+		fmt.Printf("%s%s (%d keys, %s)\n", branch("", last), appID, len(appData), formatBytes(encodedSize(appData)))
+		if depth < 3 {
+			continue
+		}
+		keys := slices.Sorted(maps.Keys(appData))
+		for j, key := range keys {
+			fmt.Printf("%s%s: %s\n", branch(indent("", last), j == len(keys)-1), key, describeValue(appData[key]))
+		}
+	}
+}
+
+// describeValue summarizes a value as its JSON type, its length where it has
+// one, and its encoded size.
+func describeValue(v any) string {
+	size := formatBytes(encodedSize(v))
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return fmt.Sprintf("string, %d chars, %s", len([]rune(v)), size)
+	case bool:
+		return "bool"
+	case float64, json.Number:
+		return "number"
+	case map[string]any:
+		return fmt.Sprintf("object, %d fields, %s", len(v), size)
+	case []any:
+		return fmt.Sprintf("array, %d items, %s", len(v), size)
+	}
+	return fmt.Sprintf("%T, %s", v, size)
+}
+
+func encodedSize(v any) int64 {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return int64(len(b))
+}
+
+func branch(prefix string, last bool) string {
+	if last {
+		return prefix + "└── "
+	}
+	return prefix + "├── "
+}
+
+func indent(prefix string, last bool) string {
+	if last {
+		return prefix + "    "
+	}
+	return prefix + "│   "
+}
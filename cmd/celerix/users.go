@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/celerix-dev/celerix-store/pkg/schema"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+const userUsage = "Usage: celerix USER <ADD|LIST|GET|PASSWD|DISABLE|ENABLE|RECOVERY|DEL> [username|id] [display name]"
+
+func runUser(users *sdk.UserStore, args []string) {
+	if len(args) < 1 {
+		usage(userUsage)
+	}
+	sub := strings.ToUpper(args[0])
+	if sub == "LIST" {
+		list, err := users.List()
+		if err != nil {
+			fatal(err)
+		}
+		for _, u := range list {
+			status := "active"
+			if u.Disabled {
+				status = "disabled"
+			}
+			fmt.Printf("%s  %-20s %-8s %s\n", u.ID, u.Username, status, u.DisplayName)
+		}
+		return
+	}
+	if len(args) < 2 {
+		usage(userUsage)
+	}
+
+	if sub == "ADD" {
+		user, code, err := users.Create(args[1], strings.Join(args[2:], " "))
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("Created user %s (%s).\n", user.Username, user.ID)
+		fmt.Printf("Recovery code: %s\n", code)
+		fmt.Println("Store it safely: it can't be shown again.")
+		return
+	}
+
+	user, err := findUser(users, args[1])
+	if err != nil {
+		fatal(err)
+	}
+	switch sub {
+	case "GET":
+		user.RecoveryCode, user.PasswordHash = "", ""
+		printJSON(user)
+	case "DISABLE", "ENABLE":
+		if _, err := users.SetDisabled(user.ID, sub == "DISABLE"); err != nil {
+			fatal(err)
+		}
+		fmt.Println("OK")
+	case "PASSWD":
+		// Read from stdin rather than an argument, so it stays out of shell history
+		fmt.Fprint(os.Stderr, "New password: ")
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && password == "" {
+			fatal(err)
+		}
+		if err := users.SetPassword(user.ID, strings.TrimRight(password, "\r\n")); err != nil {
+			fatal(err)
+		}
+		fmt.Println("OK")
+	case "RECOVERY":
+		code, err := users.ResetRecoveryCode(user.ID)
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Printf("New recovery code for %s: %s\n", user.Username, code)
+	case "DEL":
+		if err := users.Delete(user.ID); err != nil {
+			fatal(err)
+		}
+		fmt.Println("OK")
+	default:
+		usage(userUsage)
+	}
+}
+
+// findUser accepts either a user ID or a username.
+func findUser(users *sdk.UserStore, ref string) (schema.UserRecord, error) {
+	user, err := users.Get(ref)
+	if sdk.IsNotFound(err) {
+		return users.Lookup(ref)
+	}
+	return user, err
+}
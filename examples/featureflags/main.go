@@ -0,0 +1,44 @@
+// Command featureflags rolls a feature out to a share of personas, with an
+// explicit override for one of them.
+//
+//	go run ./examples/featureflags
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/celerix-dev/celerix-store/pkg/engine"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+func main() {
+	dataDir, err := os.MkdirTemp("", "celerix-flags")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	store, err := engine.OpenOrConnect(dataDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	flags := sdk.NewFeatureFlags(store, "feature-flags")
+	if err := flags.Define("new-editor", sdk.Flag{Enabled: true, Percent: 50}); err != nil {
+		log.Fatal(err)
+	}
+	// Beta testers always get the feature.
+	if err := flags.Override("carol", "new-editor", true); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, persona := range []string{"alice", "bob", "carol", "dave", "erin"} {
+		on, err := flags.Enabled(persona, "new-editor")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%-6s new-editor=%v\n", persona, on)
+	}
+}
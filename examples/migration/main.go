@@ -0,0 +1,54 @@
+// Command migration copies an embedded data directory into a running daemon,
+// e.g. when an application outgrows embedded mode:
+//
+//	CELERIX_STORE_ADDR=localhost:7001 go run ./examples/migration ./data
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/celerix-dev/celerix-store/pkg/engine"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+func main() {
+	if len(os.Args) < 2 || os.Getenv("CELERIX_STORE_ADDR") == "" {
+		log.Fatal("usage: CELERIX_STORE_ADDR=host:port migration <data-dir>")
+	}
+
+	persister, err := engine.NewPersistence(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	data, err := persister.LoadAll()
+	if err != nil {
+		log.Fatal(err)
+	}
+	src := engine.NewMemStore(data, nil) // read-only copy, never written back
+
+	dst, err := sdk.Connect(os.Getenv("CELERIX_STORE_ADDR"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer dst.Close()
+
+	// Preview first, then copy without clobbering keys the daemon already has.
+	opts := engine.MigrateOptions{DryRun: true, OnConflict: engine.ConflictSkip}
+	plan, err := engine.MigrateWithOptions(src, dst, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("would copy %d keys\n", plan.Copied)
+
+	opts.DryRun = false
+	opts.Progress = func(p engine.MigrateProgress) {
+		fmt.Printf("  %s/%s: %d copied\n", p.PersonaID, p.AppID, p.Copied)
+	}
+	result, err := engine.MigrateWithOptions(src, dst, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("copied %d keys, skipped %d\n", result.Copied, result.Skipped)
+}
@@ -0,0 +1,69 @@
+// Command preferences updates user preferences while a watcher reacts to changes.
+// It talks to a daemon when CELERIX_STORE_ADDR is set and runs embedded otherwise:
+//
+//	go run ./examples/preferences
+//	CELERIX_STORE_ADDR=localhost:7001 go run ./examples/preferences
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/engine"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+func main() {
+	dataDir, err := os.MkdirTemp("", "celerix-preferences")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	store, err := engine.OpenOrConnect(dataDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, remote := store.(*sdk.Client); remote {
+		fmt.Println("using remote store at", os.Getenv("CELERIX_STORE_ADDR"))
+	} else {
+		fmt.Println("using embedded store in", dataDir)
+	}
+
+	prefs := sdk.NewPreferences(store, "my-app", map[string]any{
+		"theme":         "light",
+		"notifications": map[string]any{"email": true, "push": false},
+	})
+
+	// Watch the persona's preferences, e.g. to push them to connected browsers.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if w, ok := store.(sdk.Watcher); ok {
+		events, err := w.Watch(ctx, "alice", "my-app", "")
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			for e := range events {
+				fmt.Printf("watch: %s %s = %v\n", e.Op, e.Key, e.Value)
+			}
+		}()
+	}
+
+	current, _ := prefs.Load("alice")
+	fmt.Println("defaults:", current)
+
+	current, err = prefs.Update("alice", map[string]any{
+		"theme":         "dark",
+		"notifications": map[string]any{"push": true},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("updated:", current)
+
+	<-ctx.Done()
+}
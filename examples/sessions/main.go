@@ -0,0 +1,60 @@
+// Command sessions shows an embedded store handling logins: sessions live in the
+// _system persona, and each user's API token is kept in the client-side vault.
+//
+//	go run ./examples/sessions
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/engine"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+func main() {
+	dataDir, err := os.MkdirTemp("", "celerix-sessions")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	// Without CELERIX_STORE_ADDR this runs the engine in-process.
+	store, err := engine.OpenOrConnect(dataDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sessions := sdk.NewSessionStore(store, "sessions", 30*time.Minute)
+
+	sess, err := sessions.Create("alice", map[string]any{"ip": "203.0.113.7"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("created session %s for %s (expires %s)\n", sess.ID, sess.PersonaID, sess.ExpiresAt.Format(time.Kitchen))
+
+	// Any service sharing the store can resolve the session ID back to its owner.
+	sess, err = sessions.Get(sess.ID)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Secrets are encrypted before they reach the store.
+	masterKey := []byte("a-very-secret-32-byte-long-key!!")
+	vault := store.App(sess.PersonaID, "integrations").Vault(masterKey)
+	if err := vault.Set("github_token", "ghp_example"); err != nil {
+		log.Fatal(err)
+	}
+	raw, _ := store.Get(sess.PersonaID, "integrations", "github_token")
+	token, _ := vault.Get("github_token")
+	fmt.Printf("stored ciphertext %v..., decrypted %q\n", fmt.Sprint(raw)[:16], token)
+
+	if err := sessions.Delete(sess.ID); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := sessions.Get(sess.ID); sdk.IsNotFound(err) {
+		fmt.Println("logged out")
+	}
+}
@@ -1,44 +1,277 @@
 package api
 
 import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/celerix-dev/celerix-store/internal/jobs"
+	"github.com/celerix-dev/celerix-store/pkg/engine"
+	"github.com/celerix-dev/celerix-store/pkg/schema"
 	"github.com/celerix-dev/celerix-store/pkg/sdk"
+	"github.com/celerix-dev/celerix-store/pkg/version"
 	"github.com/gin-gonic/gin"
 )
 
 type Handler struct {
 	Store sdk.CelerixStore
+	// Hasher, if set, is used to resolve persona hashes seen in logs and metrics.
+	Hasher *engine.PersonaHasher
+	// AdminToken, if set, makes the _system persona read-only for requests
+	// without an "Authorization: Bearer <AdminToken>" header.
+	AdminToken string
+	// Tokens, if set, serves /auth/login and accepts the access tokens it
+	// issues as "Authorization: Bearer" headers.
+	Tokens *sdk.TokenStore
+	// APIKeys, if set, accepts the API keys it issues as "Authorization:
+	// Bearer" headers, limited to the routes their scopes cover (see
+	// routeScope), and serves /api-keys.
+	APIKeys *sdk.APIKeyStore
+	// RequireAuth refuses requests without a valid access token, API key or
+	// the admin token, except logging in, refreshing, health and version.
+	RequireAuth bool
+	// RequireIfMatch refuses value writes without an If-Match (or
+	// "If-None-Match: *") header with 428, so no editor can overwrite a
+	// value it hasn't seen.
+	RequireIfMatch bool
+	// DumpCache, if set, serves unfiltered app dumps from a cache.
+	DumpCache *AppDumpCache
+	// PreciseNumbers decodes numbers in written values as json.Number instead
+	// of float64, so large integers are stored with every digit.
+	PreciseNumbers bool
+	// Redaction, if set, hides sensitive values from persona exports and
+	// share links. Admins can export without it with ?redact=false.
+	Redaction *sdk.RedactionPolicy
+	// Shares, if set, issues, lists and revokes share links through /shares
+	// and serves the app dumps they point to without credentials.
+	Shares *sdk.ShareSigner
+	// Jobs, if set, is reported and can be run through /admin/jobs.
+	Jobs *jobs.Scheduler
 }
 
+// clearDumpsAfterWrite empties the DumpCache after every request that may
+// have written, so a client reads its own writes without waiting for the
+// cache to learn about them from the change stream.
+func (h *Handler) clearDumpsAfterWrite(c *gin.Context) {
+	c.Next()
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		h.DumpCache.Clear()
+	}
+}
+
+// bindValue decodes a request body holding a value to store, keeping numbers
+// as json.Number if PreciseNumbers is set.
+func (h *Handler) bindValue(c *gin.Context, v any) error {
+	if !h.PreciseNumbers {
+		return c.ShouldBindJSON(v)
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+	return sdk.DecodeValue(body, v, true)
+}
+
+func (h *Handler) isAdmin(c *gin.Context) bool {
+	if key, ok := c.Get("api_key"); ok {
+		return sdk.HasScope(key.(schema.APIKeyRecord), sdk.ScopeAdmin)
+	}
+	if h.AdminToken == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(bearer(c)), []byte(h.AdminToken)) == 1
+}
+
+// allowWrite answers 401 and returns false if the request writes to the
+// _system persona without admin rights.
+func (h *Handler) allowWrite(c *gin.Context, personaIDs ...string) bool {
+	if h.isAdmin(c) {
+		return true
+	}
+	if err := sdk.CheckSystemWrite(personaIDs...); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// writeError answers with the status ClassifyError picks for err, e.g. 400
+// for an invalid ID.
+func writeError(c *gin.Context, err error) {
+	status, _ := sdk.ClassifyError(err)
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+// GetPersonas lists persona IDs, all of them or, with ?limit=, a page in ID
+// order.
 func (h *Handler) GetPersonas(c *gin.Context) {
+	limit, cursor, ok := pageParams(c, 0)
+	if !ok {
+		return
+	}
 	personas, err := h.Store.GetPersonas()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if limit > 0 || cursor != "" {
+		var next string
+		personas, next = sdk.PageIDs(personas, cursor, limit)
+		setNextCursor(c, next)
+	}
+	if c.Query("verbose") == "true" {
+		list, err := sdk.DescribePersonas(h.Store, personas)
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, list)
+		return
+	}
 	c.JSON(http.StatusOK, personas)
 }
 
 func (h *Handler) GetApps(c *gin.Context) {
+	limit, cursor, ok := pageParams(c, 0)
+	if !ok {
+		return
+	}
 	personaID := c.Param("persona")
 	apps, err := h.Store.GetApps(personaID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if limit > 0 || cursor != "" {
+		var next string
+		apps, next = sdk.PageIDs(apps, cursor, limit)
+		setNextCursor(c, next)
+	}
+	if c.Query("verbose") == "true" {
+		list, err := sdk.DescribeApps(h.Store, personaID, apps)
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, list)
+		return
+	}
 	c.JSON(http.StatusOK, apps)
 }
 
 func (h *Handler) GetAppStore(c *gin.Context) {
+	limit, cursor, ok := pageParams(c, 0)
+	if !ok {
+		return
+	}
 	personaID := c.Param("persona")
 	appID := c.Param("app")
-	data, err := h.Store.GetAppStore(personaID, appID)
+	fields, revisions := sdk.ParseFields(c.Query("fields")), c.Query("revisions") == "true"
+	keys := c.Query("keys")
+	if h.DumpCache != nil && keys == "" && limit == 0 && cursor == "" && len(fields) == 0 && !revisions {
+		body, err := h.DumpCache.Get(personaID, appID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		writeRevisioned(c, body)
+		return
+	}
+	var data map[string]any
+	var err error
+	if keys != "" {
+		// Only the listed keys, leaving out missing ones
+		data, err = sdk.MGet(h.Store, personaID, appID, strings.Split(keys, ","))
+	} else {
+		data, err = h.Store.GetAppStore(personaID, appID)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, data)
+	data = pageMap(c, data, limit, cursor)
+	if len(fields) > 0 || revisions {
+		for k, v := range data {
+			projected := sdk.Project(v, fields)
+			if revisions {
+				projected = gin.H{"value": projected, "revision": sdk.Revision(v)}
+			}
+			data[k] = projected
+		}
+	}
+	writeRevisionedJSON(c, data)
+}
+
+// MergePersona folds the persona into dst_persona and deletes it.
+func (h *Handler) MergePersona(c *gin.Context) {
+	var input struct {
+		DstPersona string `json:"dst_persona" binding:"required"`
+		Strategy   string `json:"strategy"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	src := c.Param("persona")
+	if !h.allowWrite(c, src, input.DstPersona) {
+		return
+	}
+	merger, ok := h.Store.(sdk.PersonaMerger)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "merging personas not supported"})
+		return
+	}
+	if err := merger.MergePersona(src, input.DstPersona, sdk.MergeStrategy(input.Strategy)); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// PurgePersona erases the persona irreversibly. Since there is no undo, the
+// request must repeat the persona ID as ?confirm=.
+func (h *Handler) PurgePersona(c *gin.Context) {
+	personaID := c.Param("persona")
+	if c.Query("confirm") != personaID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "purging a persona can't be undone: repeat its ID as ?confirm="})
+		return
+	}
+	if !h.allowWrite(c, personaID) {
+		return
+	}
+	purger, ok := h.Store.(sdk.Purger)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "purging personas not supported"})
+		return
+	}
+	report, err := purger.PurgePersona(personaID)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// ValueExists answers HEAD requests for a value with 200 or 404, without
+// reading the value.
+func (h *Handler) ValueExists(c *gin.Context) {
+	ok, err := sdk.Exists(h.Store, c.Param("persona"), c.Param("app"), c.Param("key"))
+	switch {
+	case err != nil:
+		status, _ := sdk.ClassifyError(err)
+		c.Status(status)
+	case ok:
+		c.Status(http.StatusOK)
+	default:
+		c.Status(http.StatusNotFound)
+	}
 }
 
 func (h *Handler) GetGlobal(c *gin.Context) {
@@ -49,48 +282,328 @@ func (h *Handler) GetGlobal(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{
+	writeRevisionedJSON(c, gin.H{
 		"persona": persona,
-		"value":   val,
+		"value":   sdk.Project(val, sdk.ParseFields(c.Query("fields"))),
 	})
 }
 
+// Search lists where keys matching ?key= are stored, e.g. ?key=*token*,
+// across all personas or, with ?app=, the apps matching a pattern. Results
+// are paged like DumpApp.
+func (h *Handler) Search(c *gin.Context) {
+	limit, cursor, ok := pageParams(c, sdk.DefaultSearchLimit)
+	if !ok {
+		return
+	}
+	res, err := sdk.Search(h.Store, c.Query("key"), sdk.SearchOptions{App: c.Query("app"), Limit: limit, After: cursor})
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	setNextCursor(c, res.Next)
+	c.JSON(http.StatusOK, res.Hits)
+}
+
 func (h *Handler) Set(c *gin.Context) {
 	personaID := c.Param("persona")
 	appID := c.Param("app")
 	key := c.Param("key")
+	if !h.allowWrite(c, personaID) {
+		return
+	}
+
+	rev, conditional := h.precondition(c)
+	if c.IsAborted() {
+		return
+	}
+	// ?sync=true answers once the value is on disk
+	sync := c.Query("sync") == "true"
+	if sync && conditional {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sync can't be combined with a precondition"})
+		return
+	}
 
 	var val any
-	if err := c.ShouldBindJSON(&val); err != nil {
+	if err := h.bindValue(c, &val); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if err := h.Store.Set(personaID, appID, key, val); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	var err error
+	if conditional {
+		writer, ok := h.conditionalWriter(c)
+		if !ok {
+			return
+		}
+		err = writer.SetIfRevision(personaID, appID, key, val, rev)
+	} else if sync {
+		err = sdk.SetSync(h.Store, personaID, appID, key, val)
+	} else {
+		err = h.Store.Set(personaID, appID, key, val)
+	}
+	if errors.Is(err, sdk.ErrConflict) {
+		h.writeConflict(c, personaID, appID, key)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"status": "success"})
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	writeSaved(c, val)
+}
+
+// Merge applies the request body as an RFC 7396 merge patch to the stored value.
+// A conditional merge patches the value read here and saves it only if that
+// value is still current.
+func (h *Handler) Merge(c *gin.Context) {
+	personaID, appID, key := c.Param("persona"), c.Param("app"), c.Param("key")
+	merger, ok := h.Store.(sdk.Merger)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "merge not supported"})
+		return
+	}
+	if !h.allowWrite(c, personaID) {
+		return
+	}
+	rev, conditional := h.precondition(c)
+	if c.IsAborted() {
+		return
+	}
+
+	var patch any
+	if err := h.bindValue(c, &patch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var merged any
+	var err error
+	if conditional {
+		writer, ok := h.conditionalWriter(c)
+		if !ok {
+			return
+		}
+		current, getErr := h.Store.Get(personaID, appID, key)
+		if getErr != nil && !sdk.IsNotFound(getErr) {
+			writeError(c, getErr)
+			return
+		}
+		merged = sdk.MergePatch(current, patch)
+		err = writer.SetIfRevision(personaID, appID, key, merged, rev)
+	} else {
+		merged, err = merger.Merge(personaID, appID, key, patch)
+	}
+	if errors.Is(err, sdk.ErrConflict) {
+		h.writeConflict(c, personaID, appID, key)
+		return
+	}
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Header("ETag", etag(sdk.Revision(merged)))
+	c.JSON(http.StatusOK, merged)
 }
 
 func (h *Handler) Delete(c *gin.Context) {
 	personaID := c.Param("persona")
 	appID := c.Param("app")
 	key := c.Param("key")
+	if !h.allowWrite(c, personaID) {
+		return
+	}
 
-	if err := h.Store.Delete(personaID, appID, key); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	rev, conditional := h.precondition(c)
+	if c.IsAborted() {
+		return
+	}
+
+	var err error
+	if conditional {
+		writer, ok := h.conditionalWriter(c)
+		if !ok {
+			return
+		}
+		err = writer.DeleteIfRevision(personaID, appID, key, rev)
+	} else {
+		err = h.Store.Delete(personaID, appID, key)
+	}
+	if errors.Is(err, sdk.ErrConflict) {
+		h.writeConflict(c, personaID, appID, key)
+		return
+	}
+	if err != nil {
+		writeError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "success"})
 }
 
+// DeletePrefix deletes the app's keys starting with ?prefix=. The parameter is
+// required, so emptying an app takes an explicit "?prefix=".
+func (h *Handler) DeletePrefix(c *gin.Context) {
+	personaID := c.Param("persona")
+	prefix, ok := c.GetQuery("prefix")
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "prefix is required"})
+		return
+	}
+	if !h.allowWrite(c, personaID) {
+		return
+	}
+	n, err := sdk.DeletePrefix(h.Store, personaID, c.Param("app"), prefix)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": n})
+}
+
+func (h *Handler) GetStats(c *gin.Context) {
+	reporter, ok := h.Store.(sdk.StatsReporter)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "stats not supported"})
+		return
+	}
+	stats, err := reporter.Stats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// GetUsage reports the keys and bytes of every app of every persona, so
+// dashboards can track capacity without dumping the data.
+func (h *Handler) GetUsage(c *gin.Context) {
+	report, err := sdk.Usage(h.Store)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// Import streams ndjson records (one {"persona_id","app_id","key","value"} object
+// per line) from the request body into the store. Records are applied in
+// batches as they arrive; ?skip=N resumes after the records a failed import
+// reported as applied.
+func (h *Handler) Import(c *gin.Context) {
+	opts := sdk.ImportOptions{PreciseNumbers: h.PreciseNumbers}
+	if v := c.Query("skip"); v != "" {
+		skip, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || skip < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid skip"})
+			return
+		}
+		opts.Skip = skip
+	}
+
+	var store sdk.KVWriter = h.Store
+	if !h.isAdmin(c) {
+		store = sdk.DenySystemWrites(store)
+	}
+	result, err := sdk.Import(store, c.Request.Body, opts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "records": result.Records})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// ExportPersona downloads everything stored for a persona as a JSON archive.
+// With ?recipient=age1..., the archive is encrypted to that public key in the
+// age format, so only the holder of the matching identity can open it.
+// Redaction applies unless an admin asks for ?redact=false.
+func (h *Handler) ExportPersona(c *gin.Context) {
+	personaID := c.Param("persona")
+	recipient := c.Query("recipient")
+	if recipient != "" {
+		if err := sdk.ValidateExportRecipient(recipient); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	redaction := h.Redaction
+	if c.Query("redact") == "false" {
+		if !h.isAdmin(c) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "exporting without redaction requires admin rights"})
+			return
+		}
+		redaction = nil
+	}
+
+	exp, err := sdk.ExportPersona(h.Store, personaID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	exp = redaction.RedactExport(exp)
+	var buf bytes.Buffer
+	if err := sdk.WriteExport(&buf, exp, recipient); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	contentType, filename := "application/json", personaID+".json"
+	if recipient != "" {
+		contentType, filename = "application/octet-stream", personaID+".json.age"
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
+
+// GetVersion reports the daemon's build info.
+func (h *Handler) GetVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, version.Get())
+}
+
+// GetHealth reports quarantined personas and other conditions needing attention.
+// It answers 200 even when degraded, since the store is still serving requests.
+func (h *Handler) GetHealth(c *gin.Context) {
+	health := sdk.Health{Status: sdk.HealthOK}
+	if reporter, ok := h.Store.(sdk.HealthReporter); ok {
+		var err error
+		if health, err = reporter.Health(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, health)
+}
+
+// LookupPersonaHash resolves a persona hash from logs or metrics back to the persona ID.
+func (h *Handler) LookupPersonaHash(c *gin.Context) {
+	if h.Hasher == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "persona ID hashing is disabled"})
+		return
+	}
+	personas, err := h.Store.GetPersonas()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	hash := c.Param("hash")
+	persona, ok := h.Hasher.Lookup(hash, personas...)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown persona hash"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"hash": hash, "persona": persona})
+}
+
+// Move moves a key to another persona. With dst_app_id or dst_key it also
+// moves it to another app or renames it, which needs an sdk.KeyMover store.
 func (h *Handler) Move(c *gin.Context) {
 	var input struct {
 		SrcPersona string `json:"src_persona" binding:"required"`
 		DstPersona string `json:"dst_persona" binding:"required"`
 		AppID      string `json:"app_id" binding:"required"`
 		Key        string `json:"key" binding:"required"`
+		DstAppID   string `json:"dst_app_id"`
+		DstKey     string `json:"dst_key"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -98,8 +611,27 @@ func (h *Handler) Move(c *gin.Context) {
 		return
 	}
 
-	if err := h.Store.Move(input.SrcPersona, input.DstPersona, input.AppID, input.Key); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if !h.allowWrite(c, input.SrcPersona, input.DstPersona) {
+		return
+	}
+
+	if input.DstAppID == "" {
+		input.DstAppID = input.AppID
+	}
+	if input.DstKey == "" {
+		input.DstKey = input.Key
+	}
+	var err error
+	if input.DstAppID == input.AppID && input.DstKey == input.Key {
+		err = h.Store.Move(input.SrcPersona, input.DstPersona, input.AppID, input.Key)
+	} else if mover, ok := h.Store.(sdk.KeyMover); ok {
+		err = mover.MoveKey(input.SrcPersona, input.AppID, input.DstPersona, input.DstAppID, input.Key, input.DstKey)
+	} else {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "moving keys not supported"})
+		return
+	}
+	if err != nil {
+		writeError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "success"})
@@ -5,9 +5,14 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/celerix-dev/celerix-store/internal/jobs"
 	"github.com/celerix-dev/celerix-store/pkg/engine"
+	"github.com/celerix-dev/celerix-store/pkg/schema"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
 	"github.com/gin-gonic/gin"
 )
 
@@ -112,6 +117,14 @@ func TestMove(t *testing.T) {
 	if err == nil {
 		t.Error("Key should have been deleted from source persona")
 	}
+
+	body = []byte(`{"src_persona":"p2","dst_persona":"p2","app_id":"a1","key":"k1","dst_app_id":"a2","dst_key":"renamed"}`)
+	req, _ = http.NewRequest("POST", "/move", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if val, err := h.Store.Get("p2", "a2", "renamed"); w.Code != http.StatusOK || val != "v1" {
+		t.Errorf("Expected the key moved to a2/renamed, got %d, %v, %v", w.Code, val, err)
+	}
 }
 
 func TestGetGlobalAPI(t *testing.T) {
@@ -164,3 +177,833 @@ func TestInvalidJSONSet(t *testing.T) {
 		t.Errorf("Expected status 400, got %d", w.Code)
 	}
 }
+
+func TestMergeAPI(t *testing.T) {
+	r, h := setupTestRouter()
+	r.PATCH("/personas/:persona/apps/:app/keys/:key", h.Merge)
+	h.Store.Set("p1", "a1", "k1", map[string]any{"a": "x", "b": "y"})
+
+	req, _ := http.NewRequest("PATCH", "/personas/p1/apps/a1/keys/k1", bytes.NewBufferString(`{"b":null,"c":"z"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	val, _ := h.Store.Get("p1", "a1", "k1")
+	m := val.(map[string]any)
+	if m["a"] != "x" || m["c"] != "z" || len(m) != 2 {
+		t.Errorf("Unexpected merged value: %v", m)
+	}
+}
+
+func TestPersonaHashing(t *testing.T) {
+	r, h := setupTestRouter()
+	h.Hasher = engine.NewPersonaHasher([]byte("secret"))
+	r.GET("/admin/persona-hashes/:hash", h.LookupPersonaHash)
+	h.Store.Set("alice", "a1", "k1", "v1")
+
+	redacted := RedactPath("/api/personas/alice/apps/a1?fields=name", h.Hasher)
+	if strings.Contains(redacted, "alice") || !strings.HasSuffix(redacted, "/apps/a1?fields=name") {
+		t.Errorf("Path not redacted: %s", redacted)
+	}
+	if redacted := RedactPath("/api/shared/p1/a1?id=1&expires=2&sig=secret", nil); redacted != "/api/shared/p1/a1?id=1&expires=2&sig=REDACTED" {
+		t.Errorf("Expected the signature of a share link to be left out, got %s", redacted)
+	}
+	for path, want := range map[string]string{
+		"/api/personas/alice?confirm=alice":         "/api/personas/{alice}?confirm={alice}",
+		"/api/shared/alice/a1?id=1&sig=s":           "/api/shared/{alice}/a1?id=1&sig=REDACTED",
+		"/api/apps/a1?limit=10&cursor=alice":        "/api/apps/a1?limit=10&cursor={alice}",
+		"/api/users/alice/recovery-code":            "/api/users/{alice}/recovery-code",
+		"/api/personas/al%69ce/apps?cursor=al%69ce": "/api/personas/{alice}/apps?cursor={alice}",
+	} {
+		want = strings.ReplaceAll(want, "{alice}", h.Hasher.ID("alice"))
+		if got := RedactPath(path, h.Hasher); got != want {
+			t.Errorf("RedactPath(%s) = %s, want %s", path, got, want)
+		}
+	}
+
+	// Request bodies naming personas aren't logged
+	var logged bytes.Buffer
+	defaultWriter := gin.DefaultWriter
+	gin.DefaultWriter = &logged
+	defer func() { gin.DefaultWriter = defaultWriter }()
+	logRouter := gin.New()
+	logRouter.Use(Logger(h.Hasher))
+	logRouter.POST("/move", h.Move)
+	req, _ := http.NewRequest("POST", "/move", strings.NewReader(`{"src_persona":"alice","dst_persona":"bob","app_id":"a1","key":"k1"}`))
+	logRouter.ServeHTTP(httptest.NewRecorder(), req)
+	if !strings.Contains(logged.String(), "/move") || strings.Contains(logged.String(), "alice") || strings.Contains(logged.String(), "bob") {
+		t.Errorf("Expected a log line without persona IDs, got %q", logged.String())
+	}
+
+	req, _ = http.NewRequest("GET", "/admin/persona-hashes/"+h.Hasher.ID("alice"), nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	var res map[string]string
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if w.Code != http.StatusOK || res["persona"] != "alice" {
+		t.Errorf("Lookup failed: %d %v", w.Code, res)
+	}
+}
+
+func TestImportAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &Handler{Store: engine.NewMemStore(nil, nil)}
+	r := gin.New()
+	h.RegisterRoutes(r.Group("/api"))
+
+	body := `{"persona_id":"p1","app_id":"a1","key":"k1","value":"v1"}
+{"persona_id":"p1","app_id":"a1","key":"k2","value":{"nested":true}}
+`
+	req, _ := http.NewRequest("POST", "/api/import", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"applied":2`) {
+		t.Fatalf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+	if val, _ := h.Store.Get("p1", "a1", "k1"); val != "v1" {
+		t.Errorf("Expected k1=v1, got %v", val)
+	}
+
+	req, _ = http.NewRequest("POST", "/api/import?skip=1", strings.NewReader(body+"{broken\n"))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a broken record, got %d", w.Code)
+	}
+}
+
+func TestExportPersonaAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &Handler{Store: engine.NewMemStore(nil, nil)}
+	r := gin.New()
+	h.RegisterRoutes(r.Group("/api"))
+	h.Store.Set("alice", "a1", "theme", "dark")
+
+	req, _ := http.NewRequest("GET", "/api/personas/alice/export", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"theme": "dark"`) {
+		t.Fatalf("Unexpected response %d: %s", w.Code, w.Body.String())
+	}
+
+	_, recipient, _ := sdk.GenerateExportKey()
+	req, _ = http.NewRequest("GET", "/api/personas/alice/export?recipient="+recipient, nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "dark") {
+		t.Fatalf("Expected an encrypted export, got %d: %s", w.Code, w.Body.String())
+	}
+
+	req, _ = http.NewRequest("GET", "/api/personas/alice/export?recipient=age1bogus", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid recipient, got %d", w.Code)
+	}
+
+	h.Redaction, _ = sdk.ParseRedactionPolicy("a1/theme")
+	h.AdminToken = "admin-secret"
+	req, _ = http.NewRequest("GET", "/api/personas/alice/export", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"theme": "[REDACTED]"`) {
+		t.Errorf("Expected a redacted export, got %d: %s", w.Code, w.Body.String())
+	}
+	req, _ = http.NewRequest("GET", "/api/personas/alice/export?redact=false", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an unredacted export to need admin rights, got %d", w.Code)
+	}
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"theme": "dark"`) {
+		t.Errorf("Expected admins to export without redaction, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestBlobAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := engine.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	h := &Handler{Store: store}
+	r := gin.New()
+	h.RegisterRoutes(r.Group("/api"))
+
+	req, _ := http.NewRequest("PUT", "/api/personas/p1/apps/a1/blobs/avatar", bytes.NewReader([]byte{0x89, 'P', 'N', 'G'}))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"size":4`) {
+		t.Fatalf("Unexpected upload response %d: %s", w.Code, w.Body.String())
+	}
+
+	req, _ = http.NewRequest("GET", "/api/personas/p1/apps/a1/blobs/avatar", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "\x89PNG" || w.Header().Get("Content-Length") != "4" {
+		t.Errorf("Unexpected download %d: %q", w.Code, w.Body.String())
+	}
+
+	req, _ = http.NewRequest("DELETE", "/api/personas/p1/apps/a1/blobs/avatar", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	req, _ = http.NewRequest("GET", "/api/personas/p1/apps/a1/blobs/avatar", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 after delete, got %d", w.Code)
+	}
+}
+
+func TestAuthAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := engine.NewMemStore(nil, nil)
+	users := sdk.NewUserStore(store)
+	alice, _, _ := users.Create("alice", "")
+	users.SetPassword(alice.ID, "secret")
+	h := &Handler{Store: store, Tokens: sdk.NewTokenStore(store, users, 0, 0), RequireAuth: true}
+	r := gin.New()
+	h.RegisterRoutes(r.Group("/api"))
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("GET", "/api/personas", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", w.Code)
+	}
+	if w := do("POST", "/api/auth/login", "", `{"username":"alice","password":"wrong"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong password, got %d", w.Code)
+	}
+	w := do("POST", "/api/auth/login", "", `{"username":"alice","password":"secret"}`)
+	var issued sdk.Tokens
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &issued) != nil || issued.AccessToken == "" {
+		t.Fatalf("Login failed with %d: %s", w.Code, w.Body.String())
+	}
+	if w := do("GET", "/api/personas", issued.AccessToken, ""); w.Code != http.StatusOK {
+		t.Errorf("Expected the access token to be accepted, got %d", w.Code)
+	}
+
+	if w := do("POST", "/api/auth/logout", issued.AccessToken, ""); w.Code != http.StatusOK {
+		t.Errorf("Logout failed with %d", w.Code)
+	}
+	if w := do("GET", "/api/personas", issued.AccessToken, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked token to be refused, got %d", w.Code)
+	}
+	if w := do("GET", "/api/health", "", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected health to need no token, got %d", w.Code)
+	}
+}
+
+func TestAPIKeysAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := engine.NewMemStore(nil, nil)
+	h := &Handler{Store: store, AdminToken: "admin-secret", APIKeys: sdk.NewAPIKeyStore(store), RequireAuth: true}
+	r := gin.New()
+	r.Use(CORS([]string{"https://admin.example.com"}))
+	h.RegisterRoutes(r.Group("/api"))
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Origin", "https://admin.example.com")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	create := func(scopes string) string {
+		w := do("POST", "/api/api-keys", "admin-secret", `{"name":"ci","scopes":`+scopes+`}`)
+		var out struct{ Key string }
+		if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &out) != nil {
+			t.Fatalf("Creating a key failed with %d: %s", w.Code, w.Body.String())
+		}
+		return out.Key
+	}
+	reader, writer, admin := create(`["read"]`), create(`["write"]`), create(`["admin"]`)
+
+	if w := do("GET", "/api/personas", reader, ""); w.Code != http.StatusOK {
+		t.Errorf("Expected a read key to read, got %d", w.Code)
+	} else if w.Header().Get("Access-Control-Allow-Origin") != "https://admin.example.com" {
+		t.Errorf("Expected the allowed origin to be echoed, got %q", w.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if w := do("POST", "/api/personas/p1/apps/a1/k1", reader, `"v"`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a read key to be refused writes, got %d", w.Code)
+	}
+	if w := do("POST", "/api/personas/p1/apps/a1/k1", writer, `"v"`); w.Code != http.StatusOK {
+		t.Errorf("Expected a write key to write, got %d %s", w.Code, w.Body.String())
+	}
+	if w := do("POST", "/api/personas/_system/apps/a1/k1", writer, `"v"`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a write key to be refused _system writes, got %d", w.Code)
+	}
+	if w := do("GET", "/api/api-keys", writer, ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a write key to be refused admin routes, got %d", w.Code)
+	}
+	w := do("GET", "/api/api-keys", admin, "")
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "secret_hash") {
+		t.Errorf("Expected the admin key to list keys without hashes, got %d %s", w.Code, w.Body.String())
+	}
+
+	id := strings.Split(reader, "_")[1]
+	if w := do("DELETE", "/api/api-keys/"+id, admin, ""); w.Code != http.StatusOK {
+		t.Errorf("Revoking failed with %d", w.Code)
+	}
+	if w := do("GET", "/api/personas", reader, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked key to be refused, got %d", w.Code)
+	}
+	wrong := writer[:len(writer)-1] + "0"
+	if wrong == writer {
+		wrong = writer[:len(writer)-1] + "1"
+	}
+	if w := do("GET", "/api/personas", wrong, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a wrong secret to be refused, got %d", w.Code)
+	}
+}
+
+func TestOptimisticLockingAPI(t *testing.T) {
+	r, h := setupTestRouter()
+	r.GET("/personas/:persona/apps/:app/keys/:key", h.GetValue)
+	h.Store.Set("p1", "a1", "k1", map[string]any{"name": "old"})
+
+	do := func(method, path, header, value, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("GET", "/personas/p1/apps/a1/keys/k1", "", "", "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag != `"`+sdk.Revision(map[string]any{"name": "old"})+`"` {
+		t.Fatalf("Expected the value with its revision, got %d %q", w.Code, etag)
+	}
+
+	w = do("POST", "/personas/p1/apps/a1/keys/k1", "If-Match", etag, `{"name":"first"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected a write at the current revision to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = do("POST", "/personas/p1/apps/a1/keys/k1", "If-Match", etag, `{"name":"second"}`)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("Expected a stale write to get 409, got %d", w.Code)
+	}
+	var conflict struct {
+		Value    map[string]any `json:"value"`
+		Revision string         `json:"revision"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &conflict)
+	if conflict.Value["name"] != "first" || conflict.Revision != sdk.Revision(map[string]any{"name": "first"}) {
+		t.Errorf("Expected the conflict to carry the current value, got %s", w.Body.String())
+	}
+
+	if w = do("DELETE", "/personas/p1/apps/a1/keys/k1", "If-Match", etag, ""); w.Code != http.StatusConflict {
+		t.Errorf("Expected a stale delete to get 409, got %d", w.Code)
+	}
+	if w = do("POST", "/personas/p1/apps/a1/keys/k2", "If-None-Match", "*", `1`); w.Code != http.StatusOK {
+		t.Errorf("Expected a create-only write of a new key to succeed, got %d", w.Code)
+	}
+
+	h.RequireIfMatch = true
+	if w = do("POST", "/personas/p1/apps/a1/keys/k1", "", "", `{"name":"blind"}`); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("Expected a write without If-Match to get 428, got %d", w.Code)
+	}
+}
+
+func TestPagination(t *testing.T) {
+	r, h := setupTestRouter()
+	r.GET("/apps/:app", h.DumpApp)
+	for _, p := range []string{"p1", "p2", "p3"} {
+		h.Store.Set(p, "a1", "k1", p)
+		h.Store.Set("p1", "keys", p, p)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/personas?limit=2")
+	if w.Code != http.StatusOK || w.Body.String() != `["p1","p2"]` || w.Header().Get("X-Next-Cursor") != "p2" {
+		t.Fatalf("Expected the first two personas, got %d %s %q", w.Code, w.Body.String(), w.Header().Get("X-Next-Cursor"))
+	}
+	w = get("/personas?limit=2&cursor=p2")
+	if w.Body.String() != `["p3"]` || w.Header().Get("X-Next-Cursor") != "" {
+		t.Errorf("Expected the last page to hold p3 only, got %s %q", w.Body.String(), w.Header().Get("X-Next-Cursor"))
+	}
+
+	w = get("/personas/p1/apps/keys?limit=1&cursor=p1")
+	if w.Body.String() != `{"p2":"p2"}` || w.Header().Get("X-Next-Cursor") != "p2" {
+		t.Errorf("Expected a page of one key, got %s %q", w.Body.String(), w.Header().Get("X-Next-Cursor"))
+	}
+
+	w = get("/apps/a1?limit=2")
+	var page map[string]map[string]any
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if w.Code != http.StatusOK || len(page) != 2 || page["p2"]["k1"] != "p2" || w.Header().Get("X-Next-Cursor") != "p2" {
+		t.Fatalf("Expected the app for two personas, got %d %s", w.Code, w.Body.String())
+	}
+	w = get("/apps/a1?cursor=p2")
+	if w.Body.String() != `{"p3":{"k1":"p3"}}` || w.Header().Get("X-Next-Cursor") != "" {
+		t.Errorf("Expected the rest of the app, got %s", w.Body.String())
+	}
+
+	if w = get("/personas?limit=0"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a bad limit, got %d", w.Code)
+	}
+}
+
+func TestExistsAndMGetAPI(t *testing.T) {
+	r, h := setupTestRouter()
+	r.HEAD("/personas/:persona/apps/:app/keys/:key", h.ValueExists)
+	h.Store.Set("p1", "a1", "k1", "v1")
+	h.Store.Set("p1", "a1", "k2", "v2")
+	h.Store.Set("p1", "a1", "k3", "v3")
+
+	for key, want := range map[string]int{"k1": http.StatusOK, "nope": http.StatusNotFound} {
+		req, _ := http.NewRequest("HEAD", "/personas/p1/apps/a1/keys/"+key, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != want || w.Body.Len() != 0 {
+			t.Errorf("HEAD %s: expected %d without a body, got %d %q", key, want, w.Code, w.Body.String())
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/personas/p1/apps/a1?keys=k1,k3,nope", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != `{"k1":"v1","k3":"v3"}` {
+		t.Errorf("Expected only k1 and k3, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDeletePrefixAPI(t *testing.T) {
+	r, h := setupTestRouter()
+	r.DELETE("/personas/:persona/apps/:app", h.DeletePrefix)
+	h.Store.Set("p1", "a1", "cache:1", "v1")
+	h.Store.Set("p1", "a1", "cache:2", "v2")
+	h.Store.Set("p1", "a1", "k3", "v3")
+
+	req, _ := http.NewRequest("DELETE", "/personas/p1/apps/a1", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a prefix, got %d", w.Code)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/personas/p1/apps/a1?prefix=cache:", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != `{"deleted":2}` {
+		t.Errorf("Expected 2 keys deleted, got %d %s", w.Code, w.Body.String())
+	}
+	if data, _ := h.Store.GetAppStore("p1", "a1"); len(data) != 1 {
+		t.Errorf("Expected only k3 left, got %v", data)
+	}
+}
+
+func TestPurgePersonaAPI(t *testing.T) {
+	r, h := setupTestRouter()
+	r.DELETE("/personas/:persona", h.PurgePersona)
+	h.Store.Set("p1", "a1", "k1", "v1")
+	h.Store.Set("p1", "a2", "k2", "v2")
+
+	req, _ := http.NewRequest("DELETE", "/personas/p1?confirm=p2", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a matching confirmation, got %d", w.Code)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/personas/p1?confirm=p1", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var report sdk.PurgeReport
+	json.Unmarshal(w.Body.Bytes(), &report)
+	if w.Code != http.StatusOK || report.PersonaID != "p1" || report.Keys != 2 {
+		t.Errorf("Expected p1 to be purged, got %d %s", w.Code, w.Body.String())
+	}
+	if personas, _ := h.Store.GetPersonas(); len(personas) != 0 {
+		t.Errorf("Expected no personas left, got %v", personas)
+	}
+}
+
+func TestMetadataAPI(t *testing.T) {
+	r, h := setupTestRouter()
+	r.PUT("/personas/:persona/meta", h.SetMeta)
+	r.GET("/personas/:persona/meta/:app", h.GetMeta)
+	r.PUT("/personas/:persona/meta/:app", h.SetMeta)
+	h.Store.Set("p1", "a1", "k1", "v1")
+
+	req, _ := http.NewRequest("PUT", "/personas/p1/meta", strings.NewReader(`{"display_name":"Alice"}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %s", w.Code, w.Body.String())
+	}
+
+	req, _ = http.NewRequest("GET", "/personas/p1/meta/a1", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an app without metadata, got %d", w.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/personas?verbose=true", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var list []sdk.Described
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list) != 1 || list[0].Meta == nil || list[0].Meta.DisplayName != "Alice" {
+		t.Errorf("Expected p1 described as Alice, got %s", w.Body.String())
+	}
+}
+
+func TestAppDumpCache(t *testing.T) {
+	r, h := setupTestRouter()
+	h.DumpCache = NewAppDumpCache(h.Store, 64)
+	defer h.DumpCache.Close()
+	h.Store.Set("p1", "a1", "k1", "v1")
+
+	get := func(personaID, appID string) string {
+		req, _ := http.NewRequest("GET", "/personas/"+personaID+"/apps/"+appID, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+	if body := get("p1", "a1"); body != `{"k1":"v1"}` {
+		t.Fatalf("Unexpected dump %s", body)
+	}
+	if len(h.DumpCache.entries) != 1 {
+		t.Fatal("Expected the dump to be cached")
+	}
+
+	// Writes that bypass the API reach the cache through the change stream
+	h.Store.Set("p2", "a1", "k1", strings.Repeat("x", 50))
+	h.Store.Set("p1", "a1", "k1", "v2")
+	deadline := time.Now().Add(time.Second)
+	for get("p1", "a1") != `{"k1":"v2"}` {
+		if time.Now().After(deadline) {
+			t.Fatal("The cached dump was never invalidated")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Within the 64-byte budget, caching a second app drops the first
+	get("p2", "a1")
+	h.DumpCache.mu.Lock()
+	_, kept := h.DumpCache.entries[appRef{"p1", "a1"}]
+	size := h.DumpCache.size
+	h.DumpCache.mu.Unlock()
+	if kept || size > 64 {
+		t.Errorf("Expected the least recently used app to be dropped, size %d", size)
+	}
+}
+
+func TestSearchAPI(t *testing.T) {
+	r, h := setupTestRouter()
+	r.GET("/search", h.Search)
+	h.Store.Set("p1", "a1", "db_password", "x")
+	h.Store.Set("p2", "a1", "db_password", "y")
+	h.Store.Set("p2", "a1", "theme", "dark")
+
+	req, _ := http.NewRequest("GET", "/search?key=db_*&limit=1", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != `[{"persona":"p1","app":"a1","key":"db_password"}]` || w.Header().Get("X-Next-Cursor") != "p1/a1/db_password" {
+		t.Fatalf("Expected the first hit, got %d %s %q", w.Code, w.Body.String(), w.Header().Get("X-Next-Cursor"))
+	}
+
+	req, _ = http.NewRequest("GET", "/search?key=", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a pattern, got %d", w.Code)
+	}
+}
+
+func TestJobsAPI(t *testing.T) {
+	r, h := setupTestRouter()
+	r.GET("/admin/jobs", h.ListJobs)
+	r.POST("/admin/jobs/:name/run", h.RunJob)
+
+	get := func() []jobs.Status {
+		req, _ := http.NewRequest("GET", "/admin/jobs", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var list []jobs.Status
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &list) != nil {
+			t.Fatalf("Expected the job list, got %d %s", w.Code, w.Body.String())
+		}
+		return list
+	}
+	if list := get(); len(list) != 0 {
+		t.Errorf("Expected no jobs without a scheduler, got %v", list)
+	}
+
+	h.Jobs = jobs.New()
+	h.Jobs.Add(jobs.JobUsage, "@daily", jobs.Usage(h.Store))
+	h.Jobs.Start()
+	defer h.Jobs.Stop()
+
+	for name, want := range map[string]int{"usage": http.StatusAccepted, "nope": http.StatusNotFound} {
+		req, _ := http.NewRequest("POST", "/admin/jobs/"+name+"/run", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("Expected %d running %s, got %d %s", want, name, w.Code, w.Body.String())
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		list := get()
+		if len(list) == 1 && len(list[0].History) == 1 {
+			if run := list[0].History[0]; !run.Manual || run.Error != "" || list[0].Next == nil {
+				t.Errorf("Expected a successful manual run, got %+v", list[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the run, got %+v", list)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestUsageAPI(t *testing.T) {
+	r, h := setupTestRouter()
+	r.GET("/stats/usage", h.GetUsage)
+	h.Store.Set("p1", "a1", "k1", "v1")
+	h.Store.Set("p1", "a1", "k2", "v2")
+	h.Store.Set("p2", "a2", "k1", "v1")
+
+	req, _ := http.NewRequest("GET", "/stats/usage", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var report sdk.UsageReport
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &report) != nil {
+		t.Fatalf("Expected a usage report, got %d %s", w.Code, w.Body.String())
+	}
+	if len(report.Apps) != 2 || report.Apps[0].Keys != 2 || report.Keys != 3 || report.Bytes == 0 {
+		t.Errorf("Expected 2 apps with 3 keys, got %+v", report)
+	}
+}
+
+func TestShareLinksAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := engine.NewMemStore(nil, nil)
+	store.Set("p1", "a1", "k1", "v1")
+	users := sdk.NewUserStore(store)
+	alice, _, _ := users.Create("alice", "")
+	users.SetPassword(alice.ID, "secret")
+	store.Set(alice.ID, "a1", "k1", "alice's")
+	tokens := sdk.NewTokenStore(store, users, 0, 0)
+	apiKeys := sdk.NewAPIKeyStore(store)
+	writeKey, _, _ := apiKeys.Create("ci", []string{sdk.ScopeWrite})
+	h := &Handler{Store: store, AdminToken: "admin-secret", Tokens: tokens, APIKeys: apiKeys, RequireAuth: true, Shares: sdk.NewShareSigner(store, []byte("key"))}
+	r := gin.New()
+	h.RegisterRoutes(r.Group("/api"))
+
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	type link struct {
+		ID        string    `json:"id"`
+		URL       string    `json:"url"`
+		Path      string    `json:"path"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	if w := do("POST", "/api/shares", "", `{"persona":"p1","app":"a1"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected creating a link to need credentials, got %d", w.Code)
+	}
+	w := do("POST", "/api/shares", "admin-secret", `{"persona":"p1","app":"a1","ttl":"10m"}`)
+	var l link
+	if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &l) != nil {
+		t.Fatalf("Creating a link failed with %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.HasPrefix(l.Path, "/api/shared/p1/a1?") || !strings.Contains(l.Path, "id="+l.ID) || !strings.HasSuffix(l.URL, l.Path) {
+		t.Errorf("Unexpected link %+v", l)
+	}
+	if d := time.Until(l.ExpiresAt); d < 9*time.Minute || d > 10*time.Minute {
+		t.Errorf("Expected the link to expire in 10 minutes, got %s", l.ExpiresAt)
+	}
+
+	w = do("GET", l.Path, "", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"k1":"v1"`) {
+		t.Errorf("Expected the link to serve the dump without credentials, got %d %s", w.Code, w.Body.String())
+	}
+	for _, path := range []string{
+		strings.Replace(l.Path, "/a1?", "/a2?", 1),
+		strings.Replace(l.Path, "expires=", "expires=1", 1),
+		strings.Replace(l.Path, "id=", "id=0", 1),
+		"/api/shared/p1/a1",
+		"/api/shared/p1/a1?id=" + l.ID + "&expires=1&sig=" + h.Shares.Sign(l.ID, "p1", "a1", time.Unix(1, 0)),
+	} {
+		if w := do("GET", path, "", ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected a tampered or expired link to be refused, got %d", path, w.Code)
+		}
+	}
+
+	for _, body := range []string{`{"persona":"p1","app":"a1","ttl":"720h"}`, `{"persona":"p1","app":"a1","ttl":"soon"}`, `{"persona":"../p1","app":"a1"}`, `{"persona":"p1"}`} {
+		if w := do("POST", "/api/shares", "admin-secret", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+
+	// Users share their own persona only, and keys without admin rights none
+	issued, err := tokens.Login("alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{`{"persona":"_system","app":"users"}`, `{"persona":"p1","app":"a1"}`} {
+		if w := do("POST", "/api/shares", issued.AccessToken, body); w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected sharing another persona to be refused, got %d", body, w.Code)
+		}
+	}
+	if w := do("POST", "/api/shares", writeKey, `{"persona":"p1","app":"a1"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a write key not to share personas, got %d", w.Code)
+	}
+	w = do("POST", "/api/shares", issued.AccessToken, `{"persona":"`+alice.ID+`","app":"a1"}`)
+	var own link
+	if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &own) != nil {
+		t.Fatalf("Expected users to share their own persona, got %d", w.Code)
+	}
+
+	// Each link is listed to those who may share its persona, and revoked alone
+	var listed []schema.ShareLinkRecord
+	w = do("GET", "/api/shares", issued.AccessToken, "")
+	if json.Unmarshal(w.Body.Bytes(), &listed); len(listed) != 1 || listed[0].ID != own.ID {
+		t.Errorf("Expected alice to see her link only, got %s", w.Body.String())
+	}
+	if w = do("DELETE", "/api/shares/"+l.ID, issued.AccessToken, ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected alice not to revoke another persona's link, got %d", w.Code)
+	}
+	if w = do("DELETE", "/api/shares/"+own.ID, issued.AccessToken, ""); w.Code != http.StatusOK {
+		t.Errorf("Expected alice to revoke her link, got %d", w.Code)
+	}
+	if w = do("GET", own.Path, "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked link to be refused, got %d", w.Code)
+	}
+	if w = do("GET", l.Path, "", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected other links to keep working, got %d", w.Code)
+	}
+}
+
+func TestWaitValueAPI(t *testing.T) {
+	r, h := setupTestRouter()
+	r.GET("/personas/:persona/apps/:app/keys/:key/wait", h.WaitValue)
+	h.Store.Set("p1", "a1", "k1", "v1")
+	rev := sdk.Revision("v1")
+
+	do := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	var got struct {
+		Value    any    `json:"value"`
+		Revision string `json:"revision"`
+	}
+
+	w := do("/personas/p1/apps/a1/keys/k1/wait")
+	if json.Unmarshal(w.Body.Bytes(), &got); w.Code != http.StatusOK || got.Value != "v1" || got.Revision != rev {
+		t.Fatalf("Expected the current value without since, got %d %s", w.Code, w.Body.String())
+	}
+	if w = do("/personas/p1/apps/a1/keys/k1/wait?since=stale"); w.Code != http.StatusOK || w.Header().Get("ETag") != `"`+rev+`"` {
+		t.Errorf("Expected a stale revision to be answered at once, got %d", w.Code)
+	}
+	if w = do("/personas/p1/apps/a1/keys/k1/wait?since=" + rev + "&timeout=50ms"); w.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 when nothing changes, got %d", w.Code)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		h.Store.Set("p1", "a1", "k10", "other key")
+		h.Store.Set("p1", "a1", "k1", "v2")
+	}()
+	w = do("/personas/p1/apps/a1/keys/k1/wait?since=" + rev + "&timeout=5s")
+	if json.Unmarshal(w.Body.Bytes(), &got); w.Code != http.StatusOK || got.Value != "v2" || got.Revision != sdk.Revision("v2") {
+		t.Errorf("Expected the changed value, got %d %s", w.Code, w.Body.String())
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		h.Store.Delete("p1", "a1", "k1")
+	}()
+	w = do("/personas/p1/apps/a1/keys/k1/wait?since=" + sdk.Revision("v2") + "&timeout=5s")
+	if w.Code != http.StatusOK || w.Body.String() != `{"revision":"","value":null}` {
+		t.Errorf("Expected the deletion, got %d %s", w.Code, w.Body.String())
+	}
+
+	if w = do("/personas/p1/apps/a1/keys/k1/wait?since=&timeout=1h"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a timeout over the limit to get 400, got %d", w.Code)
+	}
+}
+
+func TestConditionalGetAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &Handler{Store: engine.NewMemStore(nil, nil)}
+	r := gin.New()
+	h.RegisterRoutes(r.Group("/api"))
+	h.Store.Set("p1", "a1", "k1", "v1")
+
+	do := func(path, ifNoneMatch string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	paths := []string{"/api/personas/p1/apps/a1", "/api/apps/a1", "/api/global/a1/k1", "/api/personas/p1/apps/a1/k1"}
+	for _, path := range paths {
+		w := do(path, "")
+		etag := w.Header().Get("ETag")
+		if w.Code != http.StatusOK || etag == "" || w.Header().Get("Cache-Control") != "private, no-cache" {
+			t.Fatalf("%s: expected an ETag and Cache-Control, got %d %v", path, w.Code, w.Header())
+		}
+		if w = do(path, `"other", `+etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+			t.Errorf("%s: expected 304 for a matching If-None-Match, got %d", path, w.Code)
+		}
+		h.Store.Set("p1", "a1", "k1", path)
+		if w = do(path, etag); w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+			t.Errorf("%s: expected the changed data with a new ETag, got %d", path, w.Code)
+		}
+	}
+
+	// The value's ETag is its revision, as used by If-Match
+	if w := do("/api/personas/p1/apps/a1/k1", ""); w.Header().Get("ETag") != `"`+sdk.Revision(paths[len(paths)-1])+`"` {
+		t.Errorf("Expected the revision as the ETag, got %s", w.Header().Get("ETag"))
+	}
+}
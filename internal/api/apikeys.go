@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+	"github.com/gin-gonic/gin"
+)
+
+// apiKeyStore returns the store's API keys, answering 501 if they aren't
+// enabled and 401 without admin rights, since keys grant access to
+// everything.
+func (h *Handler) apiKeyStore(c *gin.Context) (*sdk.APIKeyStore, bool) {
+	if h.APIKeys == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "API keys not enabled"})
+		return nil, false
+	}
+	if !h.isAdmin(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "managing API keys requires the admin token"})
+		return nil, false
+	}
+	return h.APIKeys, true
+}
+
+// ListAPIKeys lists the API keys, without their secrets.
+func (h *Handler) ListAPIKeys(c *gin.Context) {
+	keys, ok := h.apiKeyStore(c)
+	if !ok {
+		return
+	}
+	list, err := keys.List()
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// CreateAPIKey issues a key and returns it, which is the only time it can be
+// seen.
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	keys, ok := h.apiKeyStore(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		Name   string   `json:"name" binding:"required"`
+		Scopes []string `json:"scopes" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	key, rec, err := keys.Create(input.Name, input.Scopes)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"key": key, "api_key": rec})
+}
+
+// RevokeAPIKey deletes an API key by ID.
+func (h *Handler) RevokeAPIKey(c *gin.Context) {
+	keys, ok := h.apiKeyStore(c)
+	if !ok {
+		return
+	}
+	if err := keys.Revoke(c.Param("id")); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
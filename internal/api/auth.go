@@ -0,0 +1,168 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/celerix-dev/celerix-store/pkg/schema"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+	"github.com/gin-gonic/gin"
+)
+
+// bearer returns the token of an "Authorization: Bearer" header.
+func bearer(c *gin.Context) string {
+	token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return token
+}
+
+// Authenticate checks the access token or API key of requests that carry
+// one, and refuses requests without one if RequireAuth is set. The admin
+// token is accepted too. The user, if any, is stored in the context as
+// "user", and the API key as "api_key".
+func (h *Handler) Authenticate(c *gin.Context) {
+	token := bearer(c)
+	if h.AdminToken != "" && h.isAdmin(c) {
+		c.Next()
+		return
+	}
+	if sdk.IsAPIKey(token) && h.APIKeys != nil {
+		key, err := h.APIKeys.Verify(token)
+		if err != nil {
+			writeAuthError(c, err)
+			c.Abort()
+			return
+		}
+		c.Set("api_key", key)
+		scope := sdk.ScopeWrite
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			scope = sdk.ScopeRead
+		}
+		h.requireScope(scope)(c)
+		return
+	}
+	if token == "" || h.Tokens == nil {
+		if h.RequireAuth {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+			return
+		}
+		c.Next()
+		return
+	}
+	user, err := h.Tokens.Verify(token)
+	if err != nil {
+		status, _ := sdk.ClassifyError(err)
+		if errors.Is(err, sdk.ErrUserDisabled) {
+			status = http.StatusForbidden
+		}
+		c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.Set("user", user)
+	c.Next()
+}
+
+// requireScope refuses requests made with an API key lacking scope with 403.
+// Other requests pass; handlers check admin rights of their own.
+func (h *Handler) requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key, ok := c.Get("api_key"); ok && !sdk.HasScope(key.(schema.APIKeyRecord), scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key lacks the " + scope + " scope"})
+		}
+	}
+}
+
+// tokenStore returns the store's token issuer, answering 501 if tokens
+// aren't enabled.
+func (h *Handler) tokenStore(c *gin.Context) (*sdk.TokenStore, bool) {
+	if h.Tokens == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "token authentication not enabled"})
+	}
+	return h.Tokens, h.Tokens != nil
+}
+
+func writeAuthError(c *gin.Context, err error) {
+	if errors.Is(err, sdk.ErrUserDisabled) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	writeError(c, err)
+}
+
+// Login trades a username and password for an access and a refresh token.
+func (h *Handler) Login(c *gin.Context) {
+	tokens, ok := h.tokenStore(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	issued, err := tokens.Login(input.Username, input.Password)
+	if err != nil {
+		writeAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, issued)
+}
+
+// Refresh trades a refresh token for a new pair; the old one stops working.
+func (h *Handler) Refresh(c *gin.Context) {
+	tokens, ok := h.tokenStore(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	issued, err := tokens.Refresh(input.RefreshToken)
+	if err != nil {
+		writeAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, issued)
+}
+
+// Logout revokes the request's access token and, if given, a refresh token.
+func (h *Handler) Logout(c *gin.Context) {
+	tokens, ok := h.tokenStore(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	c.ShouldBindJSON(&input)
+	for _, token := range []string{bearer(c), input.RefreshToken} {
+		if token == "" || token == h.AdminToken {
+			continue
+		}
+		if err := tokens.Revoke(token); err != nil {
+			writeError(c, err)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// RevokeUserTokens logs a user out everywhere.
+func (h *Handler) RevokeUserTokens(c *gin.Context) {
+	tokens, ok := h.tokenStore(c)
+	if !ok || !h.allowWrite(c, sdk.SystemPersona) {
+		return
+	}
+	if err := tokens.RevokeUser(c.Param("id")); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
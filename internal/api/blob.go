@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+	"github.com/gin-gonic/gin"
+)
+
+// blobStore returns the store's blob support, answering 501 if it has none.
+func (h *Handler) blobStore(c *gin.Context) (sdk.BlobStore, bool) {
+	blobs, ok := h.Store.(sdk.BlobStore)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "blobs not supported"})
+	}
+	return blobs, ok
+}
+
+// SetBlob stores the raw request body as a blob, streaming it to disk.
+func (h *Handler) SetBlob(c *gin.Context) {
+	blobs, ok := h.blobStore(c)
+	if !ok || !h.allowWrite(c, c.Param("persona")) {
+		return
+	}
+	size, err := blobs.SetBlob(c.Param("persona"), c.Param("app"), c.Param("key"), c.Request.Body)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "size": size})
+}
+
+// GetBlob streams a blob as application/octet-stream.
+func (h *Handler) GetBlob(c *gin.Context) {
+	blobs, ok := h.blobStore(c)
+	if !ok {
+		return
+	}
+	blob, size, err := blobs.GetBlob(c.Param("persona"), c.Param("app"), c.Param("key"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	defer blob.Close()
+	c.DataFromReader(http.StatusOK, size, "application/octet-stream", blob, nil)
+}
+
+func (h *Handler) DeleteBlob(c *gin.Context) {
+	blobs, ok := h.blobStore(c)
+	if !ok || !h.allowWrite(c, c.Param("persona")) {
+		return
+	}
+	if err := blobs.DeleteBlob(c.Param("persona"), c.Param("app"), c.Param("key")); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CORS answers preflight requests and lets browsers on origins call the API.
+// "*" allows every origin, which is what no origins mean too. Other origins
+// are echoed back only when they match one of origins exactly, e.g.
+// "https://admin.example.com".
+func CORS(origins []string) gin.HandlerFunc {
+	anyOrigin := len(origins) == 0 || slices.Contains(origins, "*")
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		if anyOrigin {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Add("Vary", "Origin")
+			if origin := c.GetHeader("Origin"); slices.Contains(origins, origin) {
+				header.Set("Access-Control-Allow-Origin", origin)
+			}
+		}
+		header.Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, HEAD, PUT, PATCH, DELETE")
+		header.Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, If-Match, If-None-Match")
+		header.Set("Access-Control-Expose-Headers", "ETag, X-Next-Cursor")
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// AppDumpCache keeps the JSON of recently dumped apps, so the management UI
+// refreshing a big app doesn't copy and serialize all of it every time. It
+// watches the store and drops an app as soon as any of its keys changes,
+// whoever changed it. The least recently used apps are dropped to stay within
+// a byte budget.
+type AppDumpCache struct {
+	store    sdk.CelerixStore
+	maxBytes int
+	stop     context.CancelFunc
+
+	mu      sync.Mutex
+	entries map[appRef]*list.Element // of *dumpEntry, most recently used first
+	lru     *list.List
+	size    int
+	pending map[appRef]*struct{} // dumps being read, see Get
+	live    bool                 // subscribed to the change stream
+}
+
+type appRef struct{ personaID, appID string }
+
+type dumpEntry struct {
+	ref  appRef
+	body []byte
+}
+
+// NewAppDumpCache caches dumps of the apps of s, up to maxBytes of JSON. It
+// returns nil, which caches nothing, if s can't be watched or maxBytes isn't
+// positive. Close stops it.
+func NewAppDumpCache(s sdk.CelerixStore, maxBytes int) *AppDumpCache {
+	w, ok := s.(sdk.Watcher)
+	if !ok || maxBytes <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &AppDumpCache{
+		store:    s,
+		maxBytes: maxBytes,
+		stop:     cancel,
+		entries:  make(map[appRef]*list.Element),
+		lru:      list.New(),
+		pending:  make(map[appRef]*struct{}),
+	}
+	events, err := w.Watch(ctx, sdk.WatchAll, sdk.WatchAll, "")
+	if err != nil {
+		cancel()
+		return nil
+	}
+	d.live = true
+	go d.invalidate(ctx, w, events)
+	return d
+}
+
+// invalidate drops apps as their keys change. A stream dropped for lagging
+// may have missed changes, so everything is dropped and the stream resumed.
+func (d *AppDumpCache) invalidate(ctx context.Context, w sdk.Watcher, events <-chan sdk.ChangeEvent) {
+	for {
+		for e := range events {
+			d.mu.Lock()
+			d.dropLocked(appRef{e.PersonaID, e.AppID})
+			d.mu.Unlock()
+		}
+
+		d.mu.Lock()
+		d.live = false
+		d.clearLocked()
+		d.mu.Unlock()
+
+		if ctx.Err() != nil {
+			return
+		}
+		var err error
+		if events, err = w.Watch(ctx, sdk.WatchAll, sdk.WatchAll, ""); err != nil {
+			return // Caching stays off
+		}
+		d.mu.Lock()
+		d.live = true
+		d.mu.Unlock()
+	}
+}
+
+// Get returns the JSON of an app's data, from the cache or from the store.
+func (d *AppDumpCache) Get(personaID, appID string) ([]byte, error) {
+	ref := appRef{personaID, appID}
+	d.mu.Lock()
+	if el, ok := d.entries[ref]; ok {
+		d.lru.MoveToFront(el)
+		body := el.Value.(*dumpEntry).body
+		d.mu.Unlock()
+		return body, nil
+	}
+	// A change arriving while the app is read drops the pending marker, so
+	// a dump that may be older than the change isn't kept.
+	marker := &struct{}{}
+	if d.live {
+		d.pending[ref] = marker
+	}
+	d.mu.Unlock()
+
+	data, err := sdk.GetAppStoreRaw(d.store, personaID, appID)
+	if err != nil {
+		d.forgetPending(ref, marker)
+		return nil, err
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		d.forgetPending(ref, marker)
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pending[ref] != marker || len(body) > d.maxBytes {
+		return body, nil
+	}
+	delete(d.pending, ref)
+	d.entries[ref] = d.lru.PushFront(&dumpEntry{ref: ref, body: body})
+	d.size += len(body)
+	for d.size > d.maxBytes {
+		d.dropLocked(d.lru.Back().Value.(*dumpEntry).ref)
+	}
+	return body, nil
+}
+
+func (d *AppDumpCache) forgetPending(ref appRef, marker *struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pending[ref] == marker {
+		delete(d.pending, ref)
+	}
+}
+
+// dropLocked forgets an app and any dump of it being read. It MUST be called
+// while holding d.mu.
+func (d *AppDumpCache) dropLocked(ref appRef) {
+	delete(d.pending, ref)
+	if el, ok := d.entries[ref]; ok {
+		d.size -= len(el.Value.(*dumpEntry).body)
+		d.lru.Remove(el)
+		delete(d.entries, ref)
+	}
+}
+
+// Clear drops every cached app and any dump being read.
+func (d *AppDumpCache) Clear() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clearLocked()
+}
+
+// clearLocked MUST be called while holding d.mu.
+func (d *AppDumpCache) clearLocked() {
+	d.entries = make(map[appRef]*list.Element)
+	d.lru.Init()
+	d.size = 0
+	d.pending = make(map[appRef]*struct{})
+}
+
+// Close stops watching the store.
+func (d *AppDumpCache) Close() {
+	if d != nil {
+		d.stop()
+	}
+}
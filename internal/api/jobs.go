@@ -0,0 +1,39 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/celerix-dev/celerix-store/internal/jobs"
+	"github.com/gin-gonic/gin"
+)
+
+// ListJobs reports the scheduled jobs with their next run, latest error and
+// recent runs.
+func (h *Handler) ListJobs(c *gin.Context) {
+	if h.Jobs == nil {
+		c.JSON(http.StatusOK, []jobs.Status{})
+		return
+	}
+	c.JSON(http.StatusOK, h.Jobs.Status())
+}
+
+// RunJob starts a scheduled job now, e.g. a snapshot before an upgrade. It
+// answers 202 once the job has started; its outcome shows in ListJobs.
+func (h *Handler) RunJob(c *gin.Context) {
+	if h.Jobs == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no jobs scheduled"})
+		return
+	}
+	err := h.Jobs.RunNow(c.Param("name"))
+	switch {
+	case errors.Is(err, jobs.ErrUnknownJob):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, jobs.ErrRunning):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"status": "started"})
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/celerix-dev/celerix-store/pkg/engine"
+	"github.com/gin-gonic/gin"
+)
+
+// secretParams are query parameters whose values never reach the logs, such
+// as the signatures of share links.
+var secretParams = map[string]bool{"sig": true}
+
+// personaSegments are the path segments followed by a persona ID: users share
+// their ID with their persona.
+var personaSegments = map[string]bool{"personas": true, "shared": true, "users": true}
+
+// personaParams are query parameters that may hold persona IDs: the
+// confirmation of a purge, and the cursor of persona listings and app dumps.
+var personaParams = map[string]bool{"confirm": true, "cursor": true}
+
+// Logger returns a request logger that writes persona IDs in URLs as hashes
+// and leaves out secrets such as share link signatures. With a nil hasher
+// persona IDs are written as they are. Request bodies, which name personas
+// in moves, merges and imports, are never logged.
+func Logger(hasher *engine.PersonaHasher) gin.HandlerFunc {
+	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
+		return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+			param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+			param.StatusCode,
+			param.Latency,
+			param.ClientIP,
+			param.Method,
+			RedactPath(param.Path, hasher),
+			param.ErrorMessage,
+		)
+	})
+}
+
+// RedactPath replaces the persona IDs in a request path, such as the segment
+// after /personas/ and the ?confirm= of a purge, with their hashes, and the
+// values of secret query parameters with "REDACTED".
+func RedactPath(path string, hasher *engine.PersonaHasher) string {
+	path, query, hasQuery := strings.Cut(path, "?")
+	segments := strings.Split(path, "/")
+	for i := 0; i < len(segments)-1; i++ {
+		if personaSegments[segments[i]] && segments[i+1] != "" {
+			id, err := url.PathUnescape(segments[i+1])
+			if err != nil {
+				id = segments[i+1]
+			}
+			segments[i+1] = hasher.ID(id)
+		}
+	}
+	path = strings.Join(segments, "/")
+	if !hasQuery {
+		return path
+	}
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		rawName, value, _ := strings.Cut(param, "=")
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			name = rawName
+		}
+		switch {
+		case secretParams[name]:
+			params[i] = rawName + "=REDACTED"
+		case personaParams[name] && value != "":
+			id, err := url.QueryUnescape(value)
+			if err != nil {
+				id = value
+			}
+			params[i] = rawName + "=" + url.QueryEscape(hasher.ID(id))
+		}
+	}
+	return path + "?" + strings.Join(params, "&")
+}
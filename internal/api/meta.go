@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/celerix-dev/celerix-store/pkg/schema"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+	"github.com/gin-gonic/gin"
+)
+
+// GetMeta returns the metadata of a persona, or of one of its apps if the
+// route has an :app.
+func (h *Handler) GetMeta(c *gin.Context) {
+	var meta schema.Metadata
+	var err error
+	if appID := c.Param("app"); appID != "" {
+		meta, err = sdk.GetAppMeta(h.Store, c.Param("persona"), appID)
+	} else {
+		meta, err = sdk.GetPersonaMeta(h.Store, c.Param("persona"))
+	}
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, meta)
+}
+
+// SetMeta replaces the metadata of a persona or app with the request body.
+// The creation time is kept from the first time metadata was set.
+func (h *Handler) SetMeta(c *gin.Context) {
+	personaID := c.Param("persona")
+	var meta schema.Metadata
+	if err := c.ShouldBindJSON(&meta); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.allowWrite(c, personaID) {
+		return
+	}
+	var err error
+	if appID := c.Param("app"); appID != "" {
+		err = sdk.SetAppMeta(h.Store, personaID, appID, meta)
+	} else {
+		err = sdk.SetPersonaMeta(h.Store, personaID, meta)
+	}
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
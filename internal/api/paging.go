@@ -0,0 +1,76 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+	"github.com/gin-gonic/gin"
+)
+
+// Page sizes for ?limit=. Lists are unpaged unless a limit is given, but app
+// dumps across personas always are.
+const (
+	maxPageSize     = 1000
+	defaultDumpPage = 100
+)
+
+// nextCursorHeader carries the ?cursor= of the next page. It is missing on the
+// last page.
+const nextCursorHeader = "X-Next-Cursor"
+
+// pageParams reads ?limit= and ?cursor=, using def without a limit. It answers
+// 400 and returns false for a bad limit.
+func pageParams(c *gin.Context, def int) (limit int, cursor string, ok bool) {
+	limit = def
+	if text := c.Query("limit"); text != "" {
+		n, err := strconv.Atoi(text)
+		if err != nil || n < 1 || n > maxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageSize)})
+			return 0, "", false
+		}
+		limit = n
+	}
+	return limit, c.Query("cursor"), true
+}
+
+func setNextCursor(c *gin.Context, next string) {
+	if next != "" {
+		c.Header(nextCursorHeader, next)
+	}
+}
+
+// pageMap keeps the page of data's keys that pageParams asked for.
+func pageMap(c *gin.Context, data map[string]any, limit int, cursor string) map[string]any {
+	if limit == 0 && cursor == "" {
+		return data
+	}
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	keys, next := sdk.PageIDs(keys, cursor, limit)
+	page := make(map[string]any, len(keys))
+	for _, k := range keys {
+		page[k] = data[k]
+	}
+	setNextCursor(c, next)
+	return page
+}
+
+// DumpApp returns a page of an app's data across personas, keyed by persona
+// ID, without building the whole dump in memory.
+func (h *Handler) DumpApp(c *gin.Context) {
+	limit, cursor, ok := pageParams(c, defaultDumpPage)
+	if !ok {
+		return
+	}
+	page, next, err := sdk.DumpAppPage(h.Store, c.Param("app"), cursor, limit)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	setNextCursor(c, next)
+	writeRevisionedJSON(c, page)
+}
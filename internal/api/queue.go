@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) queuer(c *gin.Context) (sdk.Queuer, bool) {
+	queuer, ok := h.Store.(sdk.Queuer)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "queues not supported"})
+		return nil, false
+	}
+	return queuer, h.allowWrite(c, c.Param("persona"))
+}
+
+// Enqueue appends the request body to a queue.
+func (h *Handler) Enqueue(c *gin.Context) {
+	queuer, ok := h.queuer(c)
+	if !ok {
+		return
+	}
+	var item any
+	if err := h.bindValue(c, &item); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	id, err := queuer.Enqueue(c.Param("persona"), c.Param("app"), c.Param("queue"), item)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"id": id})
+}
+
+// Dequeue hands out the oldest visible message, hidden for ?visibility=
+// (a duration such as 30s; 0 removes it right away). An empty queue is 404.
+func (h *Handler) Dequeue(c *gin.Context) {
+	queuer, ok := h.queuer(c)
+	if !ok {
+		return
+	}
+	visibility := sdk.DefaultVisibilityTimeout
+	if v := c.Query("visibility"); v != "" {
+		var err error
+		if visibility, err = time.ParseDuration(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid visibility"})
+			return
+		}
+	}
+	msg, err := queuer.Dequeue(c.Param("persona"), c.Param("app"), c.Param("queue"), visibility)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, msg)
+}
+
+// Ack removes a dequeued message by its receipt.
+func (h *Handler) Ack(c *gin.Context) {
+	queuer, ok := h.queuer(c)
+	if !ok {
+		return
+	}
+	if err := queuer.Ack(c.Param("persona"), c.Param("app"), c.Param("queue"), c.Param("receipt")); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+	"github.com/gin-gonic/gin"
+)
+
+// GetValue returns a single value with its revision as the ETag, to send back
+// in If-Match when saving an edit, or in If-None-Match to skip an unchanged
+// value.
+func (h *Handler) GetValue(c *gin.Context) {
+	raw, err := sdk.GetRaw(h.Store, c.Param("persona"), c.Param("app"), c.Param("key"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	writeRevisioned(c, raw)
+}
+
+// writeRevisioned answers a JSON body with its revision as a strong ETag, or
+// with 304 if If-None-Match names it. Responses may be cached by the client
+// only, and must be revalidated on every use since values change any time.
+func writeRevisioned(c *gin.Context, body []byte) {
+	rev := sdk.RawRevision(body)
+	c.Header("ETag", etag(rev))
+	c.Header("Cache-Control", "private, no-cache")
+	if notModified(c.GetHeader("If-None-Match"), rev) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// writeRevisionedJSON is writeRevisioned for a value to encode.
+func writeRevisionedJSON(c *gin.Context, val any) {
+	body, err := json.Marshal(val)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	writeRevisioned(c, body)
+}
+
+// notModified reports whether an If-None-Match header lists rev, or is "*".
+func notModified(header, rev string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag != "" && strings.Trim(strings.TrimPrefix(tag, "W/"), `"`) == rev {
+			return true
+		}
+	}
+	return false
+}
+
+// precondition reads the revision a write expects from If-Match, or "" (no
+// value yet) for "If-None-Match: *". It reports false for unconditional writes,
+// after answering 428 if the handler requires If-Match.
+func (h *Handler) precondition(c *gin.Context) (rev string, conditional bool) {
+	if v := c.GetHeader("If-Match"); v != "" {
+		return strings.Trim(strings.TrimPrefix(v, "W/"), `"`), true
+	}
+	if strings.TrimSpace(c.GetHeader("If-None-Match")) == "*" {
+		return "", true
+	}
+	if h.RequireIfMatch {
+		c.AbortWithStatusJSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header required"})
+	}
+	return "", false
+}
+
+// conditionalWriter returns the store as a ConditionalWriter, or answers 501.
+func (h *Handler) conditionalWriter(c *gin.Context) (sdk.ConditionalWriter, bool) {
+	writer, ok := h.Store.(sdk.ConditionalWriter)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "conditional writes not supported"})
+	}
+	return writer, ok
+}
+
+// writeConflict answers 409 with the value the write lost against, so the
+// editor can show it and retry with its revision.
+func (h *Handler) writeConflict(c *gin.Context, personaID, appID, key string) {
+	body := gin.H{"error": sdk.ErrConflict.Error(), "value": nil, "revision": ""}
+	if val, err := h.Store.Get(personaID, appID, key); err == nil {
+		rev := sdk.Revision(val)
+		body["value"], body["revision"] = val, rev
+		c.Header("ETag", etag(rev))
+	}
+	c.JSON(http.StatusConflict, body)
+}
+
+// writeSaved answers a successful write of val.
+func writeSaved(c *gin.Context, val any) {
+	rev := sdk.Revision(val)
+	c.Header("ETag", etag(rev))
+	c.JSON(http.StatusOK, gin.H{"status": "success", "revision": rev})
+}
+
+func etag(rev string) string {
+	return `"` + rev + `"`
+}
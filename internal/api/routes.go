@@ -0,0 +1,76 @@
+package api
+
+import (
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterRoutes mounts the management API on a router group (normally "/api").
+// Everything but logging in, health, version and share links goes through
+// Authenticate.
+// API keys need the read scope for GET and HEAD requests, the write scope for
+// the others and the admin scope for the routes marked admin.
+func (h *Handler) RegisterRoutes(g *gin.RouterGroup) {
+	admin := h.requireScope(sdk.ScopeAdmin)
+
+	g.POST("/auth/login", h.Login)
+	g.POST("/auth/refresh", h.Refresh)
+	g.GET("/health", h.GetHealth)
+	g.GET("/version", h.GetVersion)
+	g.GET("/shared/:persona/:app", h.GetSharedApp)
+
+	g.Use(h.Authenticate)
+	if h.DumpCache != nil {
+		g.Use(h.clearDumpsAfterWrite)
+	}
+	g.POST("/auth/logout", h.Logout)
+	g.GET("/personas", h.GetPersonas)
+	g.GET("/personas/:persona/apps", h.GetApps)
+	g.GET("/personas/:persona/apps/:app", h.GetAppStore)
+	g.DELETE("/personas/:persona/apps/:app", h.DeletePrefix)
+	g.GET("/personas/:persona/export", h.ExportPersona)
+	g.POST("/personas/:persona/merge", h.MergePersona)
+	g.DELETE("/personas/:persona", h.PurgePersona)
+	g.GET("/personas/:persona/meta", h.GetMeta)
+	g.PUT("/personas/:persona/meta", h.SetMeta)
+	g.GET("/personas/:persona/meta/:app", h.GetMeta)
+	g.PUT("/personas/:persona/meta/:app", h.SetMeta)
+	g.GET("/global/:app/:key", h.GetGlobal)
+	g.GET("/search", h.Search)
+	g.GET("/apps/:app", h.DumpApp)
+	g.GET("/personas/:persona/apps/:app/:key", h.GetValue)
+	g.HEAD("/personas/:persona/apps/:app/:key", h.ValueExists)
+	g.POST("/personas/:persona/apps/:app/:key", h.Set)
+	g.PATCH("/personas/:persona/apps/:app/:key", h.Merge)
+	g.DELETE("/personas/:persona/apps/:app/:key", h.Delete)
+	g.PUT("/personas/:persona/apps/:app/blobs/:key", h.SetBlob)
+	g.GET("/personas/:persona/apps/:app/blobs/:key", h.GetBlob)
+	g.DELETE("/personas/:persona/apps/:app/blobs/:key", h.DeleteBlob)
+	g.GET("/personas/:persona/apps/:app/keys/:key/wait", h.WaitValue)
+	g.POST("/personas/:persona/apps/:app/queues/:queue", h.Enqueue)
+	g.POST("/personas/:persona/apps/:app/queues/:queue/dequeue", h.Dequeue)
+	g.DELETE("/personas/:persona/apps/:app/queues/:queue/receipts/:receipt", h.Ack)
+	g.GET("/schemas/:app", h.GetSchema)
+	g.PUT("/schemas/:app", admin, h.SetSchema)
+	g.DELETE("/schemas/:app", admin, h.DeleteSchema)
+	g.GET("/users", admin, h.ListUsers)
+	g.POST("/users", admin, h.CreateUser)
+	g.GET("/users/:id", admin, h.GetUser)
+	g.PATCH("/users/:id", admin, h.UpdateUser)
+	g.DELETE("/users/:id", admin, h.DeleteUser)
+	g.POST("/users/:id/recovery-code", admin, h.ResetRecoveryCode)
+	g.POST("/users/:id/revoke-tokens", admin, h.RevokeUserTokens)
+	g.GET("/api-keys", admin, h.ListAPIKeys)
+	g.POST("/api-keys", admin, h.CreateAPIKey)
+	g.DELETE("/api-keys/:id", admin, h.RevokeAPIKey)
+	g.POST("/move", h.Move)
+	g.POST("/import", h.Import)
+	g.POST("/shares", h.CreateShareLink)
+	g.GET("/shares", h.ListShareLinks)
+	g.DELETE("/shares/:id", h.RevokeShareLink)
+	g.GET("/stats", h.GetStats)
+	g.GET("/stats/usage", h.GetUsage)
+	g.GET("/admin/persona-hashes/:hash", admin, h.LookupPersonaHash)
+	g.GET("/admin/jobs", admin, h.ListJobs)
+	g.POST("/admin/jobs/:name/run", admin, h.RunJob)
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+	"github.com/gin-gonic/gin"
+)
+
+// GetSchema returns the JSON Schema registered for an app.
+func (h *Handler) GetSchema(c *gin.Context) {
+	schema, err := sdk.GetSchema(h.Store, c.Param("app"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, schema)
+}
+
+// SetSchema registers the request body as the JSON Schema of an app. Schemas
+// live in the _system persona, so this needs admin rights if they are enforced.
+func (h *Handler) SetSchema(c *gin.Context) {
+	if !h.allowWrite(c, sdk.SystemPersona) {
+		return
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := sdk.SetSchema(h.Store, c.Param("app"), body); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// DeleteSchema stops validating an app's values.
+func (h *Handler) DeleteSchema(c *gin.Context) {
+	if !h.allowWrite(c, sdk.SystemPersona) {
+		return
+	}
+	if err := sdk.DeleteSchema(h.Store, c.Param("app")); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/schema"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+	"github.com/gin-gonic/gin"
+)
+
+// CreateShareLink issues a link granting read access to the dump of one app
+// of one persona until it expires, e.g. for a support engineer to pass on
+// diagnostic data without handing out credentials. The body names the
+// persona, the app and optionally a ttl (default sdk.DefaultShareTTL, at most
+// sdk.MaxShareTTL). See canShare for who may share a persona.
+func (h *Handler) CreateShareLink(c *gin.Context) {
+	if h.Shares == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "share links not enabled"})
+		return
+	}
+	var input struct {
+		Persona string `json:"persona" binding:"required"`
+		App     string `json:"app" binding:"required"`
+		TTL     string `json:"ttl"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, err := range []error{sdk.ValidateID("persona ID", input.Persona), sdk.ValidateID("app ID", input.App)} {
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+	}
+	if !h.canShare(c, input.Persona) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "sharing a persona requires admin rights or being its user"})
+		return
+	}
+	ttl := sdk.DefaultShareTTL
+	if input.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(input.TTL); err != nil || ttl <= 0 || ttl > sdk.MaxShareTTL {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid ttl: want a duration up to %s", sdk.MaxShareTTL)})
+			return
+		}
+	}
+
+	link, sig, err := h.Shares.Create(input.Persona, input.App, ttl)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	query := url.Values{
+		"id":      {link.ID},
+		"expires": {strconv.FormatInt(link.ExpiresAt.Unix(), 10)},
+		"sig":     {sig},
+	}
+	path := fmt.Sprintf("%s/shared/%s/%s?%s", shareBase(c, "/shares"), url.PathEscape(input.Persona), url.PathEscape(input.App), query.Encode())
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	c.JSON(http.StatusCreated, gin.H{"id": link.ID, "url": scheme + "://" + c.Request.Host + path, "path": path, "expires_at": link.ExpiresAt})
+}
+
+// canShare reports whether the request may share, list and revoke links to
+// personaID: admins may for any persona, users only for their own, the
+// persona with their ID. API keys without the admin scope aren't tied to a
+// persona, so they may not.
+func (h *Handler) canShare(c *gin.Context, personaID string) bool {
+	if h.isAdmin(c) {
+		return true
+	}
+	user, ok := c.Get("user")
+	return ok && user.(schema.UserRecord).ID == personaID
+}
+
+// ListShareLinks lists the unexpired links the request may revoke.
+func (h *Handler) ListShareLinks(c *gin.Context) {
+	if h.Shares == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "share links not enabled"})
+		return
+	}
+	links, err := h.Shares.List()
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	visible := make([]schema.ShareLinkRecord, 0, len(links))
+	for _, link := range links {
+		if h.canShare(c, link.PersonaID) {
+			visible = append(visible, link)
+		}
+	}
+	c.JSON(http.StatusOK, visible)
+}
+
+// RevokeShareLink makes a link stop working before it expires.
+func (h *Handler) RevokeShareLink(c *gin.Context) {
+	if h.Shares == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "share links not enabled"})
+		return
+	}
+	id := c.Param("id")
+	if err := sdk.ValidateID("share link ID", id); err != nil {
+		writeError(c, err)
+		return
+	}
+	link, err := h.Shares.Get(id)
+	// Links the request can't see look the same as unknown ones
+	if err == nil && !h.canShare(c, link.PersonaID) {
+		err = sdk.ErrKeyNotFound
+	}
+	if err == nil {
+		err = h.Shares.Revoke(id)
+	}
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// shareBase returns the path the routes are mounted on, e.g. "/api", from the
+// path of the request creating a link, which ends in route.
+func shareBase(c *gin.Context, route string) string {
+	path := c.FullPath()
+	return path[:len(path)-len(route)]
+}
+
+// GetSharedApp serves the app dump a share link points to, with Redaction
+// applied. It needs no credentials, only the link's unexpired signature.
+func (h *Handler) GetSharedApp(c *gin.Context) {
+	if h.Shares == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "share links not enabled"})
+		return
+	}
+	personaID, appID := c.Param("persona"), c.Param("app")
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil {
+		writeError(c, sdk.ErrInvalidShare)
+		return
+	}
+	if err := h.Shares.Verify(c.Query("id"), personaID, appID, time.Unix(expires, 0), c.Query("sig")); err != nil {
+		writeError(c, err)
+		return
+	}
+	data, err := h.Store.GetAppStore(personaID, appID)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Header("Cache-Control", "private, no-store")
+	c.JSON(http.StatusOK, h.Redaction.RedactApp(appID, data))
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/celerix-dev/celerix-store/pkg/schema"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+	"github.com/gin-gonic/gin"
+)
+
+// publicUser hides the recovery code and password hashes from API responses.
+func publicUser(u schema.UserRecord) schema.UserRecord {
+	u.RecoveryCode = ""
+	u.PasswordHash = ""
+	return u
+}
+
+func writeUserError(c *gin.Context, err error) {
+	if errors.Is(err, sdk.ErrUsernameTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	writeError(c, err)
+}
+
+func (h *Handler) ListUsers(c *gin.Context) {
+	users, err := sdk.NewUserStore(h.Store).List()
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	for i := range users {
+		users[i] = publicUser(users[i])
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+// CreateUser adds a user and returns its recovery code, which can't be
+// retrieved again.
+func (h *Handler) CreateUser(c *gin.Context) {
+	if !h.allowWrite(c, sdk.SystemPersona) {
+		return
+	}
+	var input struct {
+		Username    string `json:"username" binding:"required"`
+		DisplayName string `json:"display_name"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, code, err := sdk.NewUserStore(h.Store).Create(input.Username, input.DisplayName)
+	if err != nil {
+		writeUserError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"user": publicUser(user), "recovery_code": code})
+}
+
+func (h *Handler) GetUser(c *gin.Context) {
+	user, err := sdk.NewUserStore(h.Store).Get(c.Param("id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, publicUser(user))
+}
+
+// UpdateUser changes the display name, disabled flag or password of a user;
+// fields missing from the body are left alone.
+func (h *Handler) UpdateUser(c *gin.Context) {
+	if !h.allowWrite(c, sdk.SystemPersona) {
+		return
+	}
+	var input struct {
+		DisplayName *string `json:"display_name"`
+		Disabled    *bool   `json:"disabled"`
+		Password    *string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	users := sdk.NewUserStore(h.Store)
+	user, err := users.Get(c.Param("id"))
+	if err == nil && input.DisplayName != nil {
+		user, err = users.Update(user.ID, *input.DisplayName)
+	}
+	if err == nil && input.Disabled != nil {
+		user, err = users.SetDisabled(user.ID, *input.Disabled)
+	}
+	if err == nil && input.Password != nil {
+		if err = users.SetPassword(user.ID, *input.Password); err == nil {
+			user, err = users.Get(user.ID)
+		}
+	}
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, publicUser(user))
+}
+
+func (h *Handler) DeleteUser(c *gin.Context) {
+	if !h.allowWrite(c, sdk.SystemPersona) {
+		return
+	}
+	if err := sdk.NewUserStore(h.Store).Delete(c.Param("id")); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// ResetRecoveryCode replaces a user's recovery code and returns the new one.
+func (h *Handler) ResetRecoveryCode(c *gin.Context) {
+	if !h.allowWrite(c, sdk.SystemPersona) {
+		return
+	}
+	code, err := sdk.NewUserStore(h.Store).ResetRecoveryCode(c.Param("id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"recovery_code": code})
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultWaitTimeout = 30 * time.Second
+	maxWaitTimeout     = 5 * time.Minute
+)
+
+// WaitValue long-polls a key for clients that can't hold a change stream
+// open. Given ?since=<revision>, it answers as soon as the key's revision
+// differs, with {"value", "revision"} (null and "" once the key is deleted),
+// or with 304 when ?timeout= (default 30s, at most 5m) elapses first. Without
+// since it answers at once with the current state.
+func (h *Handler) WaitValue(c *gin.Context) {
+	personaID, appID, key := c.Param("persona"), c.Param("app"), c.Param("key")
+	if err := sdk.ValidateIDs(personaID, appID, key); err != nil {
+		writeError(c, err)
+		return
+	}
+	timeout := defaultWaitTimeout
+	if v := c.Query("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxWaitTimeout {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid timeout: want a duration up to %s", maxWaitTimeout)})
+			return
+		}
+		timeout = d
+	}
+	since, waiting := c.GetQuery("since")
+	since = strings.Trim(strings.TrimPrefix(since, "W/"), `"`)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	var (
+		w      sdk.Watcher
+		events <-chan sdk.ChangeEvent
+	)
+	if waiting {
+		var ok bool
+		if w, ok = h.Store.(sdk.Watcher); !ok {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": "watching not supported"})
+			return
+		}
+	}
+
+	for {
+		// Subscribe before reading, so no change slips in between
+		if waiting && events == nil {
+			var err error
+			if events, err = w.Watch(ctx, personaID, appID, key); err != nil {
+				writeError(c, err)
+				return
+			}
+		}
+		raw, rev, err := h.currentRevision(personaID, appID, key)
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		if !waiting || rev != since {
+			if raw == nil {
+				raw = json.RawMessage("null")
+			} else {
+				c.Header("ETag", etag(rev))
+			}
+			c.JSON(http.StatusOK, gin.H{"value": raw, "revision": rev})
+			return
+		}
+		if !nextChange(ctx, &events, key) {
+			if c.Request.Context().Err() != nil {
+				return // the client went away
+			}
+			if since != "" {
+				c.Header("ETag", etag(since))
+			}
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+}
+
+// currentRevision returns a key's raw value and revision, or nil and "" if
+// the key is missing.
+func (h *Handler) currentRevision(personaID, appID, key string) (json.RawMessage, string, error) {
+	raw, err := sdk.GetRaw(h.Store, personaID, appID, key)
+	if err != nil {
+		if sdk.IsNotFound(err) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	return raw, sdk.RawRevision(raw), nil
+}
+
+// nextChange waits for an event on key, reporting false once ctx is done. A
+// stream dropped for lagging may have missed the change, so it is reported as
+// one and *events reset for the caller to subscribe again.
+func nextChange(ctx context.Context, events *<-chan sdk.ChangeEvent, key string) bool {
+	for {
+		select {
+		case ev, ok := <-*events:
+			if !ok {
+				*events = nil
+				return ctx.Err() == nil
+			}
+			if ev.Key == key {
+				return true
+			}
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Package chaos disturbs the daemon on purpose, to test how clients cope with
+// a slow or unreliable store: it delays requests, drops connections and fails
+// saves at configurable rates. The daemon only offers it in builds with the
+// chaos tag, so that a production binary can't be talked into it.
+package chaos
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInjected is the error of a failure injected on purpose.
+var ErrInjected = errors.New("chaos: injected failure")
+
+// Config sets what to disturb and how often. Rates are the chance, from 0 to
+// 1, that a single event is disturbed.
+type Config struct {
+	// LatencyMin and LatencyMax bound the delay added to a request; each
+	// delay is picked uniformly between them.
+	LatencyMin, LatencyMax time.Duration
+	// LatencyRate is the share of requests delayed.
+	LatencyRate float64
+	// DropRate is the share of requests and responses on which the connection
+	// is closed instead, so that a client can't tell whether a write it sent
+	// was applied.
+	DropRate float64
+	// SaveFailRate is the share of attempts at saving to disk that fail.
+	SaveFailRate float64
+	// Seed makes the disturbances repeatable for the same traffic; zero picks
+	// a random seed.
+	Seed uint64
+}
+
+// Parse reads a Config from a comma-separated list of settings, e.g.
+// "latency=50ms-200ms,latency_rate=0.2,drop=0.01,save_fail=0.05,seed=42".
+// latency is a duration or a range of durations; without latency_rate, every
+// request is delayed.
+func Parse(spec string) (Config, error) {
+	var c Config
+	latencyRateSet := false
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return c, fmt.Errorf("invalid setting %q: want name=value", item)
+		}
+		var err error
+		switch name {
+		case "latency":
+			lo, hi, isRange := strings.Cut(value, "-")
+			if c.LatencyMin, err = time.ParseDuration(lo); err == nil {
+				c.LatencyMax = c.LatencyMin
+				if isRange {
+					c.LatencyMax, err = time.ParseDuration(hi)
+				}
+			}
+			if err == nil && (c.LatencyMin < 0 || c.LatencyMax < c.LatencyMin) {
+				err = errors.New("want a duration or a range like 50ms-200ms")
+			}
+		case "latency_rate":
+			c.LatencyRate, err = parseRate(value)
+			latencyRateSet = true
+		case "drop":
+			c.DropRate, err = parseRate(value)
+		case "save_fail":
+			c.SaveFailRate, err = parseRate(value)
+		case "seed":
+			c.Seed, err = strconv.ParseUint(value, 10, 64)
+		default:
+			return c, fmt.Errorf("unknown setting %q", name)
+		}
+		if err != nil {
+			return c, fmt.Errorf("invalid %s %q: %v", name, value, err)
+		}
+	}
+	if c.LatencyMax > 0 && !latencyRateSet {
+		c.LatencyRate = 1
+	}
+	return c, nil
+}
+
+func parseRate(value string) (float64, error) {
+	rate, err := strconv.ParseFloat(value, 64)
+	if err == nil && (rate < 0 || rate > 1) {
+		err = errors.New("want a rate from 0 to 1")
+	}
+	return rate, err
+}
+
+// String describes the configuration for the daemon's startup log.
+func (c Config) String() string {
+	var parts []string
+	if c.LatencyRate > 0 && c.LatencyMax > 0 {
+		parts = append(parts, fmt.Sprintf("%s-%s latency on %g%% of requests", c.LatencyMin, c.LatencyMax, c.LatencyRate*100))
+	}
+	if c.DropRate > 0 {
+		parts = append(parts, fmt.Sprintf("dropping %g%% of requests and responses", c.DropRate*100))
+	}
+	if c.SaveFailRate > 0 {
+		parts = append(parts, fmt.Sprintf("failing %g%% of saves", c.SaveFailRate*100))
+	}
+	if len(parts) == 0 {
+		return "nothing disturbed"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Injector decides which events to disturb.
+type Injector struct {
+	config Config
+	mu     sync.Mutex
+	rng    *rand.Rand
+}
+
+// New returns an Injector disturbing events as configured.
+func New(config Config) *Injector {
+	seed := config.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	return &Injector{config: config, rng: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// String describes the injector's configuration.
+func (i *Injector) String() string {
+	return i.config.String()
+}
+
+// roll reports whether an event with the given rate happens.
+func (i *Injector) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.rng.Float64() < rate
+}
+
+// latency returns the delay to add to a request, if any.
+func (i *Injector) latency() time.Duration {
+	c := i.config
+	if !i.roll(c.LatencyRate) || c.LatencyMax <= 0 {
+		return 0
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return c.LatencyMin + time.Duration(i.rng.Int64N(int64(c.LatencyMax-c.LatencyMin)+1))
+}
+
+// SaveFault fails saves at the configured rate; it is meant for
+// engine.WithSaveFault.
+func (i *Injector) SaveFault(personaID, appID string) error {
+	if i.roll(i.config.SaveFailRate) {
+		return ErrInjected
+	}
+	return nil
+}
+
+// Listener wraps l so that the connections it accepts are delayed and dropped
+// at the configured rates.
+func (i *Injector) Listener(l net.Listener) net.Listener {
+	return &listener{Listener: l, injector: i}
+}
+
+type listener struct {
+	net.Listener
+	injector *Injector
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, injector: l.injector}, nil
+}
+
+// conn disturbs a connection: each read that receives data is a request,
+// each write a response.
+type conn struct {
+	net.Conn
+	injector *Injector
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n == 0 {
+		return n, err
+	}
+	if c.injector.roll(c.injector.config.DropRate) {
+		return 0, c.drop("request")
+	}
+	if d := c.injector.latency(); d > 0 {
+		time.Sleep(d)
+	}
+	return n, err
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	if c.injector.roll(c.injector.config.DropRate) {
+		return 0, c.drop("response")
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *conn) drop(what string) error {
+	log.Printf("Chaos: dropping the connection from %s on a %s", c.RemoteAddr(), what)
+	c.Conn.Close()
+	return ErrInjected
+}
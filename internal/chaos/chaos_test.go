@@ -0,0 +1,86 @@
+package chaos
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	c, err := Parse("latency=50ms-200ms, drop=0.01,save_fail=0.5,seed=42")
+	want := Config{LatencyMin: 50 * time.Millisecond, LatencyMax: 200 * time.Millisecond, LatencyRate: 1, DropRate: 0.01, SaveFailRate: 0.5, Seed: 42}
+	if err != nil || c != want {
+		t.Errorf("Expected %+v, got %+v, %v", want, c, err)
+	}
+	if c, _ := Parse("latency=10ms,latency_rate=0.2"); c.LatencyMin != c.LatencyMax || c.LatencyRate != 0.2 {
+		t.Errorf("Expected a fixed latency on a fifth of requests, got %+v", c)
+	}
+	for _, spec := range []string{"drop", "drop=2", "latency=200ms-50ms", "latency=slow", "jitter=5ms", "seed=-1"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Expected %q to be refused", spec)
+		}
+	}
+}
+
+func TestInjector_SaveFault(t *testing.T) {
+	if err := New(Config{SaveFailRate: 1}).SaveFault("p1", "a1"); !errors.Is(err, ErrInjected) {
+		t.Errorf("Expected an injected failure, got %v", err)
+	}
+	if err := New(Config{}).SaveFault("p1", "a1"); err != nil {
+		t.Errorf("Expected saves to go through, got %v", err)
+	}
+
+	// The same seed fails the same saves
+	a, b := New(Config{SaveFailRate: 0.5, Seed: 7}), New(Config{SaveFailRate: 0.5, Seed: 7})
+	for i := 0; i < 20; i++ {
+		if (a.SaveFault("p1", "") == nil) != (b.SaveFault("p1", "") == nil) {
+			t.Fatal("Expected a seed to make failures repeatable")
+		}
+	}
+}
+
+// echo serves one connection through the injector's listener and echoes it.
+func echo(t *testing.T, i *Injector) net.Conn {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		c, err := i.Listener(l).Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestInjector_Listener(t *testing.T) {
+	c := echo(t, New(Config{LatencyMin: 30 * time.Millisecond, LatencyMax: 30 * time.Millisecond, LatencyRate: 1}))
+	start := time.Now()
+	c.Write([]byte("PING"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "PING" {
+		t.Fatalf("Expected the echo, got %q, %v", buf, err)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("Expected the request to be delayed, took %s", d)
+	}
+
+	c = echo(t, New(Config{DropRate: 1}))
+	c.Write([]byte("PING"))
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Read(buf); err == nil {
+		t.Error("Expected the connection to be dropped")
+	}
+}
@@ -0,0 +1,406 @@
+// Package ingest applies mutation events read from an event stream, such as
+// a Kafka topic or a NATS subject, to a store, so the store can serve as a
+// materialized view of the stream.
+//
+// Each message is one JSON event:
+//
+//	{"id": "order-42-v3", "op": "set", "persona": "acme", "app": "orders", "key": "42", "value": {...}}
+//
+// op is "set", "merge" (value is a JSON merge patch) or "delete". Events
+// with an id are applied once: the IDs seen within the dedup window are
+// remembered in the _system persona, so redelivered and replayed events are
+// skipped. They are kept in a ring of apps, one per eighth of the window,
+// and an app is emptied whole once the window has passed it, so the state
+// stays bounded by the events of one window. Events that are malformed, or
+// that the store refuses for good (e.g. a schema violation), are logged and
+// skipped; events failing for reasons that may pass, such as a full disk,
+// are retried.
+package ingest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// SeenApp starts the names of the apps in the _system persona remembering
+// the IDs of applied events, SeenApp.0 to SeenApp.9, used in turn for one
+// bucket of the dedup window each.
+const SeenApp = "ingested"
+
+const (
+	// buckets is how many buckets a dedup window spans.
+	buckets = 8
+	// slots is how many apps the buckets take turns in: those the window
+	// spans, which may be one more than buckets, and one that is emptied
+	// for the next bucket.
+	slots = buckets + 2
+)
+
+// DefaultDedupWindow is how long event IDs are remembered when
+// Options.DedupWindow is zero.
+const DefaultDedupWindow = 24 * time.Hour
+
+// Event operations.
+const (
+	OpSet    = "set"
+	OpMerge  = "merge"
+	OpDelete = "delete"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// Event is a mutation read from a stream.
+type Event struct {
+	// ID is the event's idempotency key. Events without one are applied
+	// every time they are delivered.
+	ID      string          `json:"id,omitempty"`
+	Op      string          `json:"op"`
+	Persona string          `json:"persona"`
+	App     string          `json:"app"`
+	Key     string          `json:"key"`
+	Value   json.RawMessage `json:"value,omitempty"`
+}
+
+// seenRecord remembers an applied event.
+type seenRecord struct {
+	ID string    `json:"id"`
+	At time.Time `json:"at"`
+}
+
+// Options tune an Ingester.
+type Options struct {
+	// DedupWindow is how long event IDs are remembered (default
+	// DefaultDedupWindow). An event redelivered later is applied again.
+	DedupWindow time.Duration
+	// PreciseNumbers decodes numbers in values as json.Number, as the
+	// daemon's CELERIX_PRECISE_NUMBERS does.
+	PreciseNumbers bool
+}
+
+// Stats counts the events an Ingester handled.
+type Stats struct {
+	Applied    int64 `json:"applied"`
+	Duplicates int64 `json:"duplicates"`
+	Rejected   int64 `json:"rejected"`
+}
+
+// Ingester applies events to a store.
+type Ingester struct {
+	store sdk.CelerixStore
+	opts  Options
+	// bucket is the span of time, in seconds, whose IDs share an app.
+	bucket int64
+
+	// locks make checking and remembering an event ID atomic, for sources
+	// delivering the same event at once, without holding up other events.
+	mu    sync.Mutex
+	locks map[string]*idLock
+
+	applied, duplicates, rejected atomic.Int64
+}
+
+// idLock serializes the events with one ID.
+type idLock struct {
+	sync.Mutex
+	waiters int // guarded by Ingester.mu
+}
+
+// New returns an Ingester applying events to store.
+func New(store sdk.CelerixStore, opts Options) *Ingester {
+	if opts.DedupWindow <= 0 {
+		opts.DedupWindow = DefaultDedupWindow
+	}
+	bucket := max(int64(opts.DedupWindow/buckets/time.Second), 1)
+	return &Ingester{store: store, opts: opts, bucket: bucket, locks: make(map[string]*idLock)}
+}
+
+// Stats returns the events handled so far.
+func (in *Ingester) Stats() Stats {
+	return Stats{Applied: in.applied.Load(), Duplicates: in.duplicates.Load(), Rejected: in.rejected.Load()}
+}
+
+// rejectedError marks events that will never apply, so they are skipped
+// instead of retried.
+type rejectedError struct{ err error }
+
+func (e rejectedError) Error() string { return e.err.Error() }
+func (e rejectedError) Unwrap() error { return e.err }
+
+// Rejected reports whether err, returned by Apply, means the event will
+// never apply and should be skipped rather than retried.
+func Rejected(err error) bool {
+	var r rejectedError
+	return errors.As(err, &r)
+}
+
+// Apply applies the event in msg, unless its ID was seen within the dedup
+// window. Errors for which Rejected reports true mean the event should be
+// skipped; others that it may apply if retried.
+func (in *Ingester) Apply(msg []byte) error {
+	var ev Event
+	if err := json.Unmarshal(msg, &ev); err != nil {
+		return in.reject(fmt.Errorf("malformed event: %w", err))
+	}
+	if err := in.check(ev); err != nil {
+		return in.reject(err)
+	}
+
+	seenKey := ""
+	if ev.ID != "" {
+		sum := sha256.Sum256([]byte(ev.ID))
+		seenKey = hex.EncodeToString(sum[:16])
+		defer in.lock(seenKey)()
+		if in.seen(seenKey, ev.ID, time.Now()) {
+			in.duplicates.Add(1)
+			return nil
+		}
+	}
+
+	if err := in.apply(ev); err != nil {
+		if transient(err) {
+			return err
+		}
+		return in.reject(err)
+	}
+	in.applied.Add(1)
+	if seenKey != "" {
+		// A failure here only means a redelivery would be applied again
+		now := time.Now().UTC()
+		if err := in.store.Set(sdk.SystemPersona, in.bucketApp(in.bucketStart(now)), seenKey, seenRecord{ID: ev.ID, At: now}); err != nil {
+			log.Printf("Ingest: could not remember event %s: %v", ev.ID, err)
+		}
+	}
+	return nil
+}
+
+// lock locks the events with the ID hashed to seenKey, and returns the
+// function unlocking them.
+func (in *Ingester) lock(seenKey string) func() {
+	in.mu.Lock()
+	l := in.locks[seenKey]
+	if l == nil {
+		l = &idLock{}
+		in.locks[seenKey] = l
+	}
+	l.waiters++
+	in.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		in.mu.Lock()
+		if l.waiters--; l.waiters == 0 {
+			delete(in.locks, seenKey)
+		}
+		in.mu.Unlock()
+	}
+}
+
+// bucketStart returns the start of the bucket of IDs applied at t.
+func (in *Ingester) bucketStart(t time.Time) int64 {
+	return t.Unix() / in.bucket * in.bucket
+}
+
+// bucketApp returns the app remembering the IDs of the bucket starting at
+// start.
+func (in *Ingester) bucketApp(start int64) string {
+	return SeenApp + "." + strconv.FormatInt(start/in.bucket%slots, 10)
+}
+
+// seen reports whether the event id, hashed to seenKey, was applied within
+// the dedup window before now.
+func (in *Ingester) seen(seenKey, id string, now time.Time) bool {
+	oldest := in.bucketStart(now.Add(-in.opts.DedupWindow))
+	for start := in.bucketStart(now); start >= oldest; start -= in.bucket {
+		seen, err := sdk.Get[seenRecord](in.store, sdk.SystemPersona, in.bucketApp(start), seenKey)
+		if err == nil && seen.ID == id {
+			return now.Sub(seen.At) < in.opts.DedupWindow
+		}
+	}
+	return false
+}
+
+func (in *Ingester) reject(err error) error {
+	in.rejected.Add(1)
+	return rejectedError{err}
+}
+
+// check refuses events that can't be applied.
+func (in *Ingester) check(ev Event) error {
+	if err := sdk.ValidateIDs(ev.Persona, ev.App, ev.Key); err != nil {
+		return err
+	}
+	// Streams are not trusted with the store's own records
+	if err := sdk.CheckSystemWrite(ev.Persona); err != nil {
+		return err
+	}
+	switch ev.Op {
+	case OpSet, OpMerge:
+		if len(ev.Value) == 0 {
+			return fmt.Errorf("%s event without a value: %w", ev.Op, sdk.ErrBadRequest)
+		}
+	case OpDelete:
+	default:
+		return fmt.Errorf("unknown op %q (expected set, merge or delete): %w", ev.Op, sdk.ErrBadRequest)
+	}
+	return nil
+}
+
+func (in *Ingester) apply(ev Event) error {
+	if ev.Op == OpDelete {
+		if err := in.store.Delete(ev.Persona, ev.App, ev.Key); err != nil && !sdk.IsNotFound(err) {
+			return err
+		}
+		return nil
+	}
+	var val any
+	if err := sdk.DecodeValue(ev.Value, &val, in.opts.PreciseNumbers); err != nil {
+		return fmt.Errorf("invalid value: %w", sdk.ErrBadRequest)
+	}
+	if ev.Op == OpMerge {
+		merger, ok := in.store.(sdk.Merger)
+		if !ok {
+			return fmt.Errorf("merge events: %w", sdk.ErrNotSupported)
+		}
+		_, err := merger.Merge(ev.Persona, ev.App, ev.Key, val)
+		return err
+	}
+	return in.store.Set(ev.Persona, ev.App, ev.Key, val)
+}
+
+// transient reports whether a store error may pass, so the event is retried.
+func transient(err error) bool {
+	switch _, code := sdk.ClassifyError(err); code {
+	case sdk.CodeStorageFailure, sdk.CodeMemoryLimit, sdk.CodeServerBusy, sdk.CodeInternal:
+		return true
+	}
+	return false
+}
+
+// Prune forgets the event IDs older than the dedup window, emptying the
+// apps of the buckets the window has passed whole, and returns how many it
+// forgot.
+func (in *Ingester) Prune() (int, error) {
+	return in.prune(time.Now())
+}
+
+func (in *Ingester) prune(now time.Time) (int, error) {
+	live := make(map[string]bool)
+	oldest := in.bucketStart(now.Add(-in.opts.DedupWindow))
+	for start := in.bucketStart(now); start >= oldest; start -= in.bucket {
+		live[in.bucketApp(start)] = true
+	}
+	pruned := 0
+	for slot := 0; slot < slots; slot++ {
+		appID := SeenApp + "." + strconv.Itoa(slot)
+		if live[appID] {
+			continue
+		}
+		n, err := sdk.DeletePrefix(in.store, sdk.SystemPersona, appID, "")
+		pruned += n
+		if err != nil && !sdk.IsNotFound(err) {
+			return pruned, err
+		}
+	}
+	return pruned, nil
+}
+
+// pruneInterval is how often Run prunes: once per bucket, so the app the
+// next bucket takes is empty by then, and at least hourly.
+func (in *Ingester) pruneInterval() time.Duration {
+	return min(time.Duration(in.bucket)*time.Second, time.Hour)
+}
+
+// Source delivers the messages of a stream.
+type Source interface {
+	// Run passes every message to handle until ctx is done or the
+	// connection fails. A message counts as consumed once handle returns
+	// nil; handle only fails once ctx is done.
+	Run(ctx context.Context, handle func(msg []byte) error) error
+	// String names the source in logs, without credentials.
+	String() string
+}
+
+// Run applies the events of sources until ctx is done, reconnecting to
+// sources that fail, and prunes remembered IDs as they leave the window.
+func (in *Ingester) Run(ctx context.Context, sources ...Source) {
+	var wg sync.WaitGroup
+	for _, src := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			in.consume(ctx, src)
+		}()
+	}
+	ticker := time.NewTicker(in.pruneInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+			if _, err := in.Prune(); err != nil {
+				log.Printf("Ingest: pruning event IDs: %v", err)
+			}
+		}
+	}
+}
+
+// consume runs src until ctx is done, reconnecting with backoff.
+func (in *Ingester) consume(ctx context.Context, src Source) {
+	backoff := minBackoff
+	for {
+		started := time.Now()
+		err := src.Run(ctx, func(msg []byte) error { return in.handle(ctx, src, msg) })
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > maxBackoff {
+			backoff = minBackoff
+		}
+		log.Printf("Ingest: %s: %v; reconnecting in %s", src, err, backoff)
+		select {
+		case <-time.After(backoff):
+			backoff = min(backoff*2, maxBackoff)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handle applies msg, retrying while it fails for reasons that may pass.
+func (in *Ingester) handle(ctx context.Context, src Source, msg []byte) error {
+	backoff := minBackoff
+	for {
+		err := in.Apply(msg)
+		if err == nil {
+			return nil
+		}
+		if Rejected(err) {
+			log.Printf("Ingest: %s: skipping event: %v", src, err)
+			return nil
+		}
+		log.Printf("Ingest: %s: applying event failed, retrying in %s: %v", src, backoff, err)
+		select {
+		case <-time.After(backoff):
+			backoff = min(backoff*2, maxBackoff)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/engine"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+func TestIngester_Apply(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	in := New(store, Options{})
+
+	events := []string{
+		`{"id": "e1", "op": "set", "persona": "p1", "app": "orders", "key": "42", "value": {"status": "new", "total": 10}}`,
+		`{"id": "e2", "op": "merge", "persona": "p1", "app": "orders", "key": "42", "value": {"status": "paid"}}`,
+		`{"id": "e3", "op": "set", "persona": "p1", "app": "orders", "key": "43", "value": "x"}`,
+		`{"id": "e4", "op": "delete", "persona": "p1", "app": "orders", "key": "43"}`,
+		`{"op": "delete", "persona": "p1", "app": "orders", "key": "missing"}`,
+	}
+	for _, ev := range events {
+		if err := in.Apply([]byte(ev)); err != nil {
+			t.Fatalf("Apply(%s): %v", ev, err)
+		}
+	}
+	// A replay of e1 must not undo e2
+	if err := in.Apply([]byte(events[0])); err != nil {
+		t.Fatal(err)
+	}
+	val, err := store.Get("p1", "orders", "42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := val.(map[string]any); got["status"] != "paid" || got["total"] != float64(10) {
+		t.Errorf("Expected the merged order, got %v", got)
+	}
+	if _, err := store.Get("p1", "orders", "43"); !sdk.IsNotFound(err) {
+		t.Errorf("Expected 43 to be deleted, got %v", err)
+	}
+
+	for _, ev := range []string{
+		`not json`,
+		`{"id": "r1", "op": "set", "persona": "p1", "app": "orders", "key": "44"}`,
+		`{"id": "r2", "op": "upsert", "persona": "p1", "app": "orders", "key": "44", "value": 1}`,
+		`{"id": "r3", "op": "set", "persona": "p1", "app": "orders", "key": "bad key", "value": 1}`,
+		`{"id": "r4", "op": "set", "persona": "_system", "app": "api_keys", "key": "k", "value": 1}`,
+	} {
+		if err := in.Apply([]byte(ev)); !Rejected(err) {
+			t.Errorf("Apply(%s) = %v, want a rejection", ev, err)
+		}
+	}
+	if got, want := in.Stats(), (Stats{Applied: 5, Duplicates: 1, Rejected: 5}); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+
+	// IDs are forgotten after the window, their buckets emptied whole
+	if n, err := in.Prune(); err != nil || n != 0 {
+		t.Errorf("Prune() = %d, %v; want nothing forgotten within the window", n, err)
+	}
+	if n, err := in.prune(time.Now().Add(DefaultDedupWindow + in.opts.DedupWindow/buckets)); err != nil || n != 4 {
+		t.Errorf("prune() = %d, %v; want the 4 IDs seen", n, err)
+	}
+	if err := in.Apply([]byte(events[0])); err != nil || in.Stats().Applied != 6 {
+		t.Errorf("Expected a forgotten event to apply again, got %v", err)
+	}
+}
+
+func TestIngester_ConcurrentDuplicates(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	in := New(store, Options{})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			in.Apply([]byte(fmt.Sprintf(`{"id": "e%d", "op": "set", "persona": "p1", "app": "counts", "key": "k%d", "value": 1}`, i%5, i%5)))
+		}()
+	}
+	wg.Wait()
+	if got, want := in.Stats(), (Stats{Applied: 5, Duplicates: 15}); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+	if len(in.locks) != 0 {
+		t.Errorf("Expected the ID locks to be released, got %d", len(in.locks))
+	}
+}
+
+func TestParseSource(t *testing.T) {
+	src, err := ParseSource("kafka://user:secret@b1:9092,b2:9092/orders?group=view&tls=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := src.(*kafkaSource)
+	if strings.Join(k.brokers, ",") != "b1:9092,b2:9092" || k.topic != "orders" || k.group != "view" || !k.tls || k.user != "user" || k.pass != "secret" {
+		t.Errorf("Parsed %+v", k)
+	}
+	if strings.Contains(src.String(), "secret") {
+		t.Errorf("Expected String() without credentials, got %s", src)
+	}
+
+	src, err = ParseSource("nats://tok@nats.internal/config.%3E")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := src.(*natsSource)
+	if n.addr != "nats.internal:4222" || n.subject != "config.>" || n.queue != DefaultGroup || n.user != "tok" || n.tls {
+		t.Errorf("Parsed %+v", n)
+	}
+
+	for _, bad := range []string{"orders", "amqp://host/q", "kafka://host", "nats://a:4222,b:4222/s"} {
+		if _, err := ParseSource(bad); err == nil {
+			t.Errorf("ParseSource(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestNATSSource(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	commands := make(chan string, 10)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		fmt.Fprint(c, "INFO {\"server_id\":\"test\"}\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSpace(line)
+			commands <- line
+			if line == "PING" {
+				ev := `{"id": "n1", "op": "set", "persona": "p1", "app": "config", "key": "theme", "value": "dark"}`
+				fmt.Fprintf(c, "PONG\r\nPING\r\nMSG config.p1 1 %d\r\n%s\r\n", len(ev), ev)
+			}
+		}
+	}()
+
+	store := engine.NewMemStore(nil, nil)
+	in := New(store, Options{})
+	src, err := ParseSource("nats://user:pw@" + l.Addr().String() + "/config.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		in.Run(ctx, src)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if val, err := store.Get("p1", "config", "theme"); err == nil && val == "dark" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the event from NATS to be applied")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	connect := <-commands
+	if !strings.HasPrefix(connect, "CONNECT ") || !strings.Contains(connect, `"user":"user"`) || !strings.Contains(connect, `"pass":"pw"`) {
+		t.Errorf("Expected CONNECT with the credentials, got %s", connect)
+	}
+	if sub := <-commands; sub != "SUB config.* "+DefaultGroup+" 1" {
+		t.Errorf("Expected a queue subscription, got %s", sub)
+	}
+	<-commands // PING
+	if pong := <-commands; pong != "PONG" {
+		t.Errorf("Expected the server's PING to be answered, got %s", pong)
+	}
+}
@@ -0,0 +1,127 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// natsSource subscribes to a NATS subject. It speaks just enough of the core
+// NATS protocol for that: CONNECT, SUB, MSG and PING.
+type natsSource struct {
+	addr           string
+	subject, queue string
+	tls            bool
+	user, pass     string
+}
+
+func (s *natsSource) String() string {
+	return fmt.Sprintf("nats://%s/%s", s.addr, s.subject)
+}
+
+func (s *natsSource) Run(ctx context.Context, handle func(msg []byte) error) error {
+	conn, err := (&net.Dialer{Timeout: 10 * time.Second}).DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer conn.Close()
+
+	// The server introduces itself first, then TLS may start
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+	line, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	infoJSON, ok := strings.CutPrefix(line, "INFO ")
+	if !ok {
+		return fmt.Errorf("unexpected greeting %q", line)
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	json.Unmarshal([]byte(infoJSON), &info)
+	if s.tls || info.TLSRequired {
+		host, _, _ := net.SplitHostPort(s.addr)
+		tc := tls.Client(conn, &tls.Config{ServerName: host})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return err
+		}
+		conn, r = tc, bufio.NewReader(tc)
+		defer tc.Close()
+	}
+
+	options := map[string]any{"verbose": false, "pedantic": false, "tls_required": s.tls || info.TLSRequired, "name": DefaultGroup, "lang": "go", "protocol": 1}
+	switch {
+	case s.pass != "":
+		options["user"], options["pass"] = s.user, s.pass
+	case s.user != "":
+		options["auth_token"] = s.user
+	}
+	connect, _ := json.Marshal(options)
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nSUB %s %s 1\r\nPING\r\n", connect, s.subject, s.queue); err != nil {
+		return err
+	}
+	// PONG confirms the server took CONNECT and SUB; -ERR comes before it
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		if msg, ok := strings.CutPrefix(line, "-ERR "); ok {
+			return errors.New(strings.Trim(msg, "'"))
+		}
+		if line == "PONG" {
+			break
+		}
+	}
+	conn.SetDeadline(time.Time{})
+
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(line, "MSG "):
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			fields := strings.Fields(line)
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil || size < 0 {
+				return fmt.Errorf("bad message header %q", line)
+			}
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return err
+			}
+			if err := handle(payload[:size]); err != nil {
+				return err
+			}
+		case line == "PING":
+			if _, err := io.WriteString(conn, "PONG\r\n"); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR "):
+			return errors.New(strings.Trim(strings.TrimPrefix(line, "-ERR "), "'"))
+		}
+		// +OK, PONG and INFO updates need no answer
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,143 @@
+package ingest
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+// DefaultGroup is the Kafka consumer group and the NATS queue group used
+// when the source URL doesn't name one.
+const DefaultGroup = "celerix-store"
+
+// ParseSource returns the source a URL describes:
+//
+//	kafka://[user:password@]broker:9092[,broker:9092...]/topic[?group=name&tls=true]
+//	nats://[user:password@|token@]host:4222/subject[?queue=name]
+//	tls://... as nats://, over TLS
+//
+// Kafka consumes as a consumer group (default DefaultGroup), from the start
+// of the topic for a new group, and commits each message once it is applied.
+// With a user and password it authenticates with SASL/PLAIN. NATS subscribes
+// in a queue group (default DefaultGroup), so that several daemons share the
+// work; subjects may use wildcards. NATS only delivers messages published
+// while connected.
+func ParseSource(raw string) (Source, error) {
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok {
+		return nil, fmt.Errorf("source %q: missing scheme", raw)
+	}
+	// Kafka lists several brokers, which url.Parse refuses as a host
+	authority, target, _ := strings.Cut(rest, "/")
+	userinfo, hosts, found := strings.Cut(authority, "@")
+	if !found {
+		userinfo, hosts = "", authority
+	}
+	u, err := url.Parse(scheme + "://placeholder/" + target)
+	if err != nil {
+		return nil, fmt.Errorf("source %q: %w", raw, err)
+	}
+	if userinfo != "" {
+		info, err := url.Parse(scheme + "://" + userinfo + "@placeholder")
+		if err != nil {
+			return nil, fmt.Errorf("source %q: %w", raw, err)
+		}
+		u.User = info.User
+	}
+	name, err := url.PathUnescape(u.Path)
+	if err != nil {
+		return nil, fmt.Errorf("source %q: %w", raw, err)
+	}
+	name = strings.Trim(name, "/")
+	if hosts == "" || name == "" {
+		return nil, fmt.Errorf("source %q: want %s://host/topic", raw, scheme)
+	}
+	password, _ := u.User.Password()
+
+	switch scheme {
+	case "kafka":
+		src := &kafkaSource{
+			brokers: strings.Split(hosts, ","),
+			topic:   name,
+			group:   u.Query().Get("group"),
+			tls:     u.Query().Get("tls") == "true",
+			user:    u.User.Username(),
+			pass:    password,
+		}
+		if src.group == "" {
+			src.group = DefaultGroup
+		}
+		return src, nil
+	case "nats", "tls":
+		if strings.Contains(hosts, ",") {
+			return nil, fmt.Errorf("source %q: give a single NATS server", raw)
+		}
+		src := &natsSource{
+			addr:    hosts,
+			subject: name,
+			queue:   u.Query().Get("queue"),
+			tls:     scheme == "tls",
+			user:    u.User.Username(),
+			pass:    password,
+		}
+		if src.queue == "" {
+			src.queue = DefaultGroup
+		}
+		if !strings.Contains(src.addr, ":") {
+			src.addr += ":4222"
+		}
+		return src, nil
+	}
+	return nil, fmt.Errorf("source %q: unsupported scheme %q (expected kafka, nats or tls)", raw, scheme)
+}
+
+type kafkaSource struct {
+	brokers      []string
+	topic, group string
+	tls          bool
+	user, pass   string
+}
+
+func (s *kafkaSource) String() string {
+	return fmt.Sprintf("kafka://%s/%s", strings.Join(s.brokers, ","), s.topic)
+}
+
+func (s *kafkaSource) Run(ctx context.Context, handle func(msg []byte) error) error {
+	dialer := &kafka.Dialer{Timeout: 10 * time.Second, DualStack: true}
+	if s.tls {
+		dialer.TLS = &tls.Config{}
+	}
+	if s.user != "" {
+		dialer.SASLMechanism = plain.Mechanism{Username: s.user, Password: s.pass}
+	}
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     s.brokers,
+		Topic:       s.topic,
+		GroupID:     s.group,
+		Dialer:      dialer,
+		StartOffset: kafka.FirstOffset,
+		ErrorLogger: kafka.LoggerFunc(func(format string, args ...any) {
+			log.Printf("Ingest: %s: "+format, append([]any{s}, args...)...)
+		}),
+	})
+	defer r.Close()
+	for {
+		m, err := r.FetchMessage(ctx)
+		if err != nil {
+			return err
+		}
+		if err := handle(m.Value); err != nil {
+			return err
+		}
+		if err := r.CommitMessages(ctx, m); err != nil {
+			return err
+		}
+	}
+}
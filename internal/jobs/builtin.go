@@ -0,0 +1,296 @@
+package jobs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// Names of the built-in jobs.
+const (
+	JobSnapshot   = "snapshot"
+	JobRetention  = "retention"
+	JobCompaction = "compaction"
+	JobUsage      = "usage"
+)
+
+// UsageApp is the app of sdk.SystemPersona the usage job writes its latest
+// report to, under the key "latest".
+const UsageApp = "usage"
+
+// snapshotPrefix and snapshotExt name snapshot files, which sort by age.
+const (
+	snapshotPrefix = "snapshot-"
+	snapshotExt    = ".ndjson"
+)
+
+// snapshotMu keeps PurgeSnapshots from missing a snapshot being written.
+var snapshotMu sync.Mutex
+
+// Snapshot writes every value of store to a new file in dir, as the ndjson
+// records celerix IMPORT reads, so a snapshot is restored by importing it
+// into an empty daemon. Files are named after the time of the snapshot, to
+// the second, and only appear once complete.
+func Snapshot(store sdk.CelerixStore, dir string) Func {
+	return func(ctx context.Context) (string, error) {
+		snapshotMu.Lock()
+		defer snapshotMu.Unlock()
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return "", err
+		}
+		f, err := os.CreateTemp(dir, ".snapshot-*.tmp")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(f.Name()) // Gone after the rename
+		records, err := writeSnapshot(ctx, store, f)
+		if err == nil {
+			err = f.Sync()
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return "", err
+		}
+		name := snapshotPrefix + time.Now().UTC().Format("20060102T150405Z") + snapshotExt
+		if err := os.Rename(f.Name(), filepath.Join(dir, name)); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("wrote %d records to %s", records, name), nil
+	}
+}
+
+func writeSnapshot(ctx context.Context, store sdk.CelerixStore, f *os.File) (int64, error) {
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	personas, err := store.GetPersonas()
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(personas)
+	var records int64
+	for _, personaID := range personas {
+		if err := ctx.Err(); err != nil {
+			return records, err
+		}
+		apps, err := store.GetApps(personaID)
+		if err != nil {
+			return records, err
+		}
+		sort.Strings(apps)
+		for _, appID := range apps {
+			data, err := store.GetAppStore(personaID, appID)
+			if sdk.IsNotFound(err) {
+				continue // Deleted meanwhile
+			}
+			if err != nil {
+				return records, err
+			}
+			keys := make([]string, 0, len(data))
+			for key := range data {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if err := enc.Encode(sdk.Record{PersonaID: personaID, AppID: appID, Key: key, Value: data[key]}); err != nil {
+					return records, err
+				}
+				records++
+			}
+		}
+	}
+	return records, w.Flush()
+}
+
+// PurgeSnapshots rewrites the snapshots in dir without the records of
+// personaID, so that purging a persona erases it from them too (see
+// engine.MemStore.OnPurge). Rewritten files keep their modification time, so
+// Retention ages them as before. It returns how many files it rewrote.
+func PurgeSnapshots(dir, personaID string) (int, error) {
+	snapshotMu.Lock()
+	defer snapshotMu.Unlock()
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	rewritten := 0
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			continue
+		}
+		// Left behind by a snapshot that didn't finish
+		if strings.HasPrefix(name, "."+snapshotPrefix) && strings.HasSuffix(name, ".tmp") {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+				return rewritten, err
+			}
+			continue
+		}
+		if !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, snapshotExt) {
+			continue
+		}
+		dropped, err := purgeSnapshot(filepath.Join(dir, name), personaID)
+		if err != nil {
+			return rewritten, fmt.Errorf("%s: %w", name, err)
+		}
+		if dropped {
+			rewritten++
+		}
+	}
+	return rewritten, nil
+}
+
+// purgeSnapshot rewrites a snapshot file without the records of personaID,
+// if it has any.
+func purgeSnapshot(path, personaID string) (dropped bool, err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return false, err
+	}
+	out, err := os.CreateTemp(filepath.Dir(path), ".snapshot-*.tmp")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(out.Name()) // Gone after the rename
+	defer out.Close()
+
+	r := bufio.NewReader(in)
+	w := bufio.NewWriter(out)
+	for {
+		line, readErr := r.ReadBytes('\n')
+		if len(line) > 0 {
+			var rec struct {
+				PersonaID string `json:"persona_id"`
+			}
+			if err := json.Unmarshal(line, &rec); err != nil {
+				return false, err
+			}
+			if rec.PersonaID == personaID {
+				dropped = true
+			} else if _, err := w.Write(line); err != nil {
+				return false, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return false, readErr
+		}
+	}
+	if !dropped {
+		return false, nil
+	}
+	if err := w.Flush(); err != nil {
+		return false, err
+	}
+	if err := out.Sync(); err != nil {
+		return false, err
+	}
+	if err := out.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Chtimes(out.Name(), info.ModTime(), info.ModTime()); err != nil {
+		return false, err
+	}
+	return true, os.Rename(out.Name(), path)
+}
+
+// Retention deletes the snapshots in dir beyond the newest keep, and those
+// older than maxAge. Zero turns either limit off. The newest snapshot is
+// always kept.
+func Retention(dir string, keep int, maxAge time.Duration) Func {
+	return func(ctx context.Context) (string, error) {
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			return "no snapshots", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		var snapshots []os.DirEntry
+		for _, e := range entries {
+			if !e.IsDir() && strings.HasPrefix(e.Name(), snapshotPrefix) && strings.HasSuffix(e.Name(), snapshotExt) {
+				snapshots = append(snapshots, e)
+			}
+		}
+		// Newest first
+		sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Name() > snapshots[j].Name() })
+
+		removed := 0
+		for i, e := range snapshots {
+			if i == 0 {
+				continue
+			}
+			expired := keep > 0 && i >= keep
+			if !expired && maxAge > 0 {
+				info, err := e.Info()
+				expired = err == nil && time.Since(info.ModTime()) > maxAge
+			}
+			if !expired {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, e.Name())); err != nil && !os.IsNotExist(err) {
+				return fmt.Sprintf("removed %d of %d snapshots", removed, len(snapshots)), err
+			}
+			removed++
+		}
+		return fmt.Sprintf("removed %d of %d snapshots", removed, len(snapshots)), nil
+	}
+}
+
+// Compactor is a store that can rewrite its stored state, like the engine.
+type Compactor interface {
+	Compact() (int, error)
+}
+
+// Compaction rewrites the stored state of store (see engine.MemStore.Compact).
+func Compaction(store Compactor) Func {
+	return func(ctx context.Context) (string, error) {
+		n, err := store.Compact()
+		return fmt.Sprintf("rewrote %d personas", n), err
+	}
+}
+
+// Usage computes the usage report of store (see sdk.Usage) and keeps the
+// latest in the UsageApp of sdk.SystemPersona, so that dashboards can read it
+// without the cost of computing it.
+func Usage(store sdk.CelerixStore) Func {
+	return func(ctx context.Context) (string, error) {
+		report, err := sdk.Usage(store)
+		if err != nil {
+			return "", err
+		}
+		var val any
+		data, err := json.Marshal(struct {
+			sdk.UsageReport
+			At time.Time `json:"at"`
+		}{report, time.Now().UTC()})
+		if err == nil {
+			err = json.Unmarshal(data, &val)
+		}
+		if err == nil {
+			err = store.Set(sdk.SystemPersona, UsageApp, "latest", val)
+		}
+		return fmt.Sprintf("%d keys, %d bytes in %d apps", report.Keys, report.Bytes, len(report.Apps)), err
+	}
+}
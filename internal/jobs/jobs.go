@@ -0,0 +1,242 @@
+// Package jobs runs the daemon's scheduled jobs, such as snapshots and their
+// retention, on cron schedules, and remembers how their recent runs went for
+// the admin API.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MaxHistory is the number of runs remembered per job.
+const MaxHistory = 20
+
+// Errors returned by RunNow.
+var (
+	ErrUnknownJob = errors.New("unknown job")
+	ErrRunning    = errors.New("job already running")
+)
+
+// Func does the work of a job and returns a short summary of what it did.
+// It should give up once ctx is done, which happens when the daemon stops.
+type Func func(ctx context.Context) (string, error)
+
+// Run is one run of a job.
+type Run struct {
+	Started    time.Time `json:"started"`
+	DurationMs float64   `json:"duration_ms"`
+	// Manual is set for runs started through RunNow rather than the schedule.
+	Manual bool   `json:"manual,omitempty"`
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Status is the state of a job, as reported by the admin API.
+type Status struct {
+	Name     string     `json:"name"`
+	Schedule string     `json:"schedule"`
+	Next     *time.Time `json:"next,omitempty"`
+	Running  bool       `json:"running"`
+	// LastError is the error of the latest failed run, kept after later runs
+	// succeed so that intermittent failures show.
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	// History lists the latest runs, newest first.
+	History []Run `json:"history"`
+}
+
+type job struct {
+	name     string
+	spec     string
+	schedule Schedule
+	fn       Func
+
+	mu        sync.Mutex
+	running   bool
+	next      time.Time
+	history   []Run
+	lastError *Run
+}
+
+// Scheduler runs jobs on their schedules from Start until Stop. A job never
+// runs twice at once: a run that is due while the previous one is still
+// going is skipped.
+type Scheduler struct {
+	mu     sync.Mutex
+	jobs   map[string]*job
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New returns a scheduler without jobs.
+func New() *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{jobs: make(map[string]*job), ctx: ctx, cancel: cancel}
+}
+
+// Add schedules fn as the job name on spec (see ParseSchedule). Jobs must be
+// added before Start.
+func (s *Scheduler) Add(name, spec string, fn Func) error {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", name, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, dup := s.jobs[name]; dup {
+		return fmt.Errorf("job %s scheduled twice", name)
+	}
+	s.jobs[name] = &job{name: name, spec: spec, schedule: schedule, fn: fn}
+	return nil
+}
+
+// Start runs every job on its schedule in the background.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(j)
+	}
+}
+
+// Stop cancels the jobs that are running and waits for them to return, so
+// the store they work on can be closed.
+func (s *Scheduler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// RunNow starts a run of a job outside its schedule and returns without
+// waiting for it to finish.
+func (s *Scheduler) RunNow(name string) error {
+	s.mu.Lock()
+	j, ok := s.jobs[name]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s: %w", name, ErrUnknownJob)
+	}
+	if s.ctx.Err() != nil {
+		return s.ctx.Err()
+	}
+	if !j.begin() {
+		return fmt.Errorf("%s: %w", name, ErrRunning)
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.execute(j, true)
+	}()
+	return nil
+}
+
+// Status reports every job, sorted by name.
+func (s *Scheduler) Status() []Status {
+	s.mu.Lock()
+	jobs := make([]*job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+	s.mu.Unlock()
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].name < jobs[k].name })
+
+	list := make([]Status, 0, len(jobs))
+	for _, j := range jobs {
+		j.mu.Lock()
+		st := Status{Name: j.name, Schedule: j.spec, Running: j.running, History: make([]Run, len(j.history))}
+		if !j.next.IsZero() {
+			next := j.next
+			st.Next = &next
+		}
+		if j.lastError != nil {
+			at := j.lastError.Started
+			st.LastError, st.LastErrorAt = j.lastError.Error, &at
+		}
+		for i, run := range j.history {
+			st.History[len(j.history)-1-i] = run
+		}
+		j.mu.Unlock()
+		list = append(list, st)
+	}
+	return list
+}
+
+// loop runs a job whenever it is due until the scheduler stops.
+func (s *Scheduler) loop(j *job) {
+	defer s.wg.Done()
+	for {
+		next := j.schedule.Next(time.Now())
+		j.mu.Lock()
+		j.next = next
+		j.mu.Unlock()
+		if next.IsZero() {
+			log.Printf("Job %s will never run again", j.name)
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if !j.begin() {
+			log.Printf("Job %s skipped: the previous run is still going", j.name)
+			continue
+		}
+		s.execute(j, false)
+	}
+}
+
+// begin marks a job as running, unless it already is.
+func (j *job) begin() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.running {
+		return false
+	}
+	j.running = true
+	return true
+}
+
+// execute runs a job begun with begin and records the run.
+func (s *Scheduler) execute(j *job, manual bool) {
+	run := Run{Started: time.Now(), Manual: manual}
+	result, err := j.call(s.ctx)
+	run.DurationMs = float64(time.Since(run.Started)) / float64(time.Millisecond)
+	run.Result = result
+	if err != nil {
+		run.Error = err.Error()
+		log.Printf("Job %s failed: %v", j.name, err)
+	} else if result != "" {
+		log.Printf("Job %s: %s", j.name, result)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.running = false
+	j.history = append(j.history, run)
+	if len(j.history) > MaxHistory {
+		j.history = j.history[len(j.history)-MaxHistory:]
+	}
+	if err != nil {
+		j.lastError = &run
+	}
+}
+
+// call runs the job's function, turning a panic into an error so that one
+// broken job doesn't take the daemon down.
+func (j *job) call(ctx context.Context) (result string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return j.fn(ctx)
+}
@@ -0,0 +1,253 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/engine"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+func TestParseSchedule(t *testing.T) {
+	from := time.Date(2026, 1, 15, 10, 30, 0, 0, time.UTC) // A Thursday
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 1, 15, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 1, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2026, 1, 16, 3, 0, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2026, 1, 16, 10, 30, 0, 0, time.UTC)},
+		{"0 9-17 * * 1-5", time.Date(2026, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 1, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		// Restricted day of the month and of the week: either matches
+		{"0 0 20 * 5", time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 90m", time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+			continue
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("%q: expected %v, got %v", tt.spec, tt.want, got)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "x * * * *", "@every", "@every -1s", "@sometimes"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("Expected %q to be refused", spec)
+		}
+	}
+}
+
+// waitFor polls the status of a job until cond holds.
+func waitFor(t *testing.T, s *Scheduler, cond func(Status) bool) Status {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st := s.Status()[0]
+		if cond(st) {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the job, last status %+v", st)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	s := New()
+	runs := 0
+	err := s.Add("flaky", "@every 10ms", func(ctx context.Context) (string, error) {
+		runs++
+		if runs == 2 {
+			return "", errors.New("disk full")
+		}
+		if runs == 3 {
+			panic("boom")
+		}
+		return "ok", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("flaky", "@daily", nil); err == nil {
+		t.Error("Expected a second job with the same name to be refused")
+	}
+	if err := s.Add("broken", "whenever", nil); err == nil {
+		t.Error("Expected an invalid schedule to be refused")
+	}
+
+	s.Start()
+	st := waitFor(t, s, func(st Status) bool { return len(st.History) >= 4 })
+	s.Stop()
+
+	if st.Name != "flaky" || st.Schedule != "@every 10ms" || st.Next == nil {
+		t.Errorf("Expected the job's schedule, got %+v", st)
+	}
+	oldest := st.History[len(st.History)-1]
+	if oldest.Result != "ok" || oldest.Error != "" || oldest.Manual {
+		t.Errorf("Expected the first run to succeed, got %+v", oldest)
+	}
+	if st.History[len(st.History)-3].Error != "panic: boom" {
+		t.Errorf("Expected the panic to be recorded, got %+v", st.History)
+	}
+	// The latest error is kept after later runs succeed
+	if st.LastError != "panic: boom" || st.LastErrorAt == nil || st.History[0].Error != "" {
+		t.Errorf("Expected the last error to be reported, got %+v", st)
+	}
+	if err := s.RunNow("flaky"); err == nil {
+		t.Error("Expected RunNow to fail once stopped")
+	}
+}
+
+func TestScheduler_RunNow(t *testing.T) {
+	s := New()
+	release := make(chan struct{})
+	s.Add("slow", "@yearly", func(ctx context.Context) (string, error) {
+		<-release
+		return "done", nil
+	})
+	s.Start()
+	defer s.Stop()
+
+	if err := s.RunNow("missing"); !errors.Is(err, ErrUnknownJob) {
+		t.Errorf("Expected ErrUnknownJob, got %v", err)
+	}
+	if err := s.RunNow("slow"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RunNow("slow"); !errors.Is(err, ErrRunning) {
+		t.Errorf("Expected ErrRunning while the job runs, got %v", err)
+	}
+	close(release)
+	st := waitFor(t, s, func(st Status) bool { return len(st.History) == 1 })
+	if !st.History[0].Manual || st.History[0].Result != "done" || st.Running {
+		t.Errorf("Expected a finished manual run, got %+v", st)
+	}
+}
+
+func TestSnapshotAndRetention(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	store.Set("alice", "billing", "plan", "pro")
+	store.Set("alice", "billing", "seats", float64(5))
+	store.Set("bob", "prefs", "theme", "dark")
+
+	dir := t.TempDir()
+	result, err := Snapshot(store, dir)(context.Background())
+	if err != nil || !strings.HasPrefix(result, "wrote 3 records") {
+		t.Fatalf("Expected 3 records written, got %q, %v", result, err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 1 || !strings.HasSuffix(files[0], snapshotExt) {
+		t.Fatalf("Expected a single snapshot, got %v", files)
+	}
+
+	// A snapshot restores with Import
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := engine.NewMemStore(nil, nil)
+	_, err = sdk.Import(restored, f, sdk.ImportOptions{})
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"alice", "bob"} {
+		want, _ := sdk.GetPersona(store, p)
+		got, _ := sdk.GetPersona(restored, p)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %s restored as %v, got %v", p, want, got)
+		}
+	}
+
+	// Retention keeps the newest
+	for _, name := range []string{"snapshot-20200101T000000Z.ndjson", "snapshot-20200102T000000Z.ndjson", "notes.txt"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0o600)
+	}
+	result, err = Retention(dir, 2, 0)(context.Background())
+	if err != nil || result != "removed 1 of 3 snapshots" {
+		t.Errorf("Expected the oldest snapshot removed, got %q, %v", result, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "snapshot-20200101T000000Z.ndjson")); !os.IsNotExist(err) {
+		t.Error("Expected the oldest snapshot to be gone")
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(filepath.Join(dir, "snapshot-20200102T000000Z.ndjson"), old, old)
+	if result, _ = Retention(dir, 0, 24*time.Hour)(context.Background()); result != "removed 1 of 2 snapshots" {
+		t.Errorf("Expected the expired snapshot removed, got %q", result)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Error("Expected other files to be left alone")
+	}
+	if result, _ = Retention(dir, 0, time.Nanosecond)(context.Background()); result != "removed 0 of 1 snapshots" {
+		t.Errorf("Expected the newest snapshot to be kept, got %q", result)
+	}
+}
+
+func TestPurgeSnapshots(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	store.Set("alice", "billing", "plan", "pro")
+	store.Set("bob", "prefs", "theme", "dark")
+	dir := t.TempDir()
+	store.OnPurge(func(personaID string) error {
+		_, err := PurgeSnapshots(dir, personaID)
+		return err
+	})
+
+	if _, err := Snapshot(store, dir)(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, snapshotPrefix+"*"))
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(files[0], old, old)
+	os.WriteFile(filepath.Join(dir, ".snapshot-1.tmp"), []byte(`{"persona_id":"alice"}`), 0o600)
+
+	if _, err := sdk.PurgePersona(store, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("Expected only the snapshot to be left, got %v", entries)
+	}
+	data, _ := os.ReadFile(files[0])
+	if strings.Contains(string(data), "alice") || !strings.Contains(string(data), `"persona_id":"bob"`) {
+		t.Errorf("Expected the snapshot without the purged persona, got %s", data)
+	}
+	if info, _ := os.Stat(files[0]); !info.ModTime().Equal(old) {
+		t.Errorf("Expected the snapshot to keep its age, got %s", info.ModTime())
+	}
+	if n, err := PurgeSnapshots(dir, "alice"); n != 0 || err != nil {
+		t.Errorf("Expected nothing left to purge, got %d, %v", n, err)
+	}
+}
+
+func TestUsageJob(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	store.Set("alice", "billing", "plan", "pro")
+
+	result, err := Usage(store)(context.Background())
+	if err != nil || result != "1 keys, "+strings.Fields(result)[2]+" bytes in 1 apps" {
+		t.Fatalf("Expected a summary of 1 key, got %q, %v", result, err)
+	}
+	latest, err := store.Get(sdk.SystemPersona, UsageApp, "latest")
+	report, _ := latest.(map[string]any)
+	if err != nil || report["keys"] != float64(1) || report["at"] == nil {
+		t.Errorf("Expected the report to be stored, got %v, %v", latest, err)
+	}
+}
@@ -0,0 +1,396 @@
+// Package redismirror keeps a read-only copy of selected apps in Redis, so
+// services that only speak Redis can read configuration managed in celerix
+// without code changes.
+//
+// Every value of a mirrored app is written to its own Redis key, named by a
+// pattern such as "celerix:{persona}:{app}:{key}". Strings are stored as they
+// are and other values as JSON. The mirror follows the store's writes and
+// deletes as they happen, and copies the selected apps in full when it starts
+// and whenever it may have missed changes, e.g. while Redis was unreachable.
+// A full copy also deletes the keys matching the pattern that no longer
+// exist in the store.
+package redismirror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/engine"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// DefaultKeyPattern names the Redis key of a value when Options.KeyPattern
+// is empty.
+const DefaultKeyPattern = "celerix:{persona}:{app}:{key}"
+
+const (
+	// queueSize is the number of changes that may wait for Redis. When more
+	// pile up, they are dropped and the apps are copied in full instead.
+	queueSize = 4096
+	// batchSize is the most commands sent in one pipeline.
+	batchSize = 500
+	// timeout bounds connecting to Redis and each pipeline.
+	timeout = 10 * time.Second
+
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// Options configure a Mirror.
+type Options struct {
+	// URL is the Redis server, as redis://[user:password@]host[:port][/db],
+	// or rediss:// for TLS.
+	URL string
+	// Apps are path.Match patterns of the apps to mirror. At least one is
+	// needed. The _system persona is never mirrored.
+	Apps []string
+	// KeyPattern names the Redis key of each value, with {persona}, {app}
+	// and {key} replaced by its IDs. It must contain {key}. Defaults to
+	// DefaultKeyPattern.
+	KeyPattern string
+}
+
+// change is a write or delete waiting to be sent to Redis.
+type change struct {
+	personaID, appID, key string
+	val                   any
+	deleted               bool
+}
+
+// Mirror copies apps of a store to Redis from Start until Close.
+type Mirror struct {
+	store      *engine.MemStore
+	url        *url.URL
+	apps       []string
+	keyPattern string
+
+	changes chan change
+	// stale is set when the copy in Redis may have missed changes, so the
+	// apps are copied in full before further changes are sent.
+	stale  atomic.Bool
+	closed atomic.Bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Start mirrors the apps opts selects from store to Redis. It doesn't wait
+// for Redis: the apps are copied in the background, and failures are logged
+// and retried.
+func Start(store *engine.MemStore, opts Options) (*Mirror, error) {
+	u, err := parseURL(opts.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	if len(opts.Apps) == 0 {
+		return nil, errors.New("no apps to mirror")
+	}
+	for _, pattern := range opts.Apps {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid app pattern %q: %w", pattern, err)
+		}
+	}
+	if opts.KeyPattern == "" {
+		opts.KeyPattern = DefaultKeyPattern
+	}
+	if !strings.Contains(opts.KeyPattern, "{key}") {
+		return nil, fmt.Errorf("key pattern %q must contain {key}", opts.KeyPattern)
+	}
+
+	m := &Mirror{
+		store:      store,
+		url:        u,
+		apps:       opts.Apps,
+		keyPattern: opts.KeyPattern,
+		changes:    make(chan change, queueSize),
+		done:       make(chan struct{}),
+	}
+	m.stale.Store(true)
+	store.OnAfterSet(func(personaID, appID, key string, val any) {
+		m.enqueue(change{personaID: personaID, appID: appID, key: key, val: val})
+	})
+	store.OnDelete(func(personaID, appID, key string) {
+		m.enqueue(change{personaID: personaID, appID: appID, key: key, deleted: true})
+	})
+	m.wg.Add(1)
+	go m.run()
+	return m, nil
+}
+
+// Close stops mirroring, after sending the changes still waiting if Redis
+// can be reached.
+func (m *Mirror) Close() {
+	if m.closed.Swap(true) {
+		return
+	}
+	close(m.done)
+	m.wg.Wait()
+}
+
+// mirrored reports whether the app of personaID is mirrored.
+func (m *Mirror) mirrored(personaID, appID string) bool {
+	if personaID == sdk.SystemPersona {
+		return false
+	}
+	for _, pattern := range m.apps {
+		if ok, _ := path.Match(pattern, appID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue is called by the store's hooks, under its write lock, so it never
+// blocks: when the queue is full the change is dropped and the apps are
+// copied in full instead.
+func (m *Mirror) enqueue(c change) {
+	if m.closed.Load() || !m.mirrored(c.personaID, c.appID) {
+		return
+	}
+	select {
+	case m.changes <- c:
+	default:
+		m.stale.Store(true)
+	}
+}
+
+func (m *Mirror) run() {
+	defer m.wg.Done()
+	var c *conn
+	defer func() {
+		if c != nil {
+			c.Close()
+		}
+	}()
+	backoff := minBackoff
+	failing := false
+	fail := func(format string, args ...any) bool {
+		if !failing {
+			log.Printf("Redis mirror: "+format+"; retrying", args...)
+			failing = true
+		}
+		if c != nil {
+			c.Close()
+			c = nil
+		}
+		m.stale.Store(true)
+		select {
+		case <-time.After(backoff):
+			backoff = min(backoff*2, maxBackoff)
+			return true
+		case <-m.done:
+			return false
+		}
+	}
+
+	for {
+		if c == nil {
+			var err error
+			if c, err = dial(m.url, timeout); err != nil {
+				if !fail("connecting to %s: %v", m.url.Redacted(), err) {
+					return
+				}
+				continue
+			}
+		}
+		if m.stale.Swap(false) {
+			if err := m.resync(c); err != nil {
+				if !fail("copying apps: %v", err) {
+					return
+				}
+				continue
+			}
+		}
+		if failing {
+			log.Printf("Redis mirror: %s is in sync again", m.url.Redacted())
+			failing, backoff = false, minBackoff
+		}
+
+		var batch []change
+		select {
+		case ch := <-m.changes:
+			batch = append(batch, ch)
+		case <-m.done:
+			// Send what is left, once, if the copy is otherwise current.
+			if batch = m.drain(batchSize * 4); len(batch) > 0 && !m.stale.Load() {
+				if err := c.do(m.commands(batch)); err != nil {
+					log.Printf("Redis mirror: sending the last changes: %v", err)
+				}
+			}
+			return
+		}
+		batch = append(batch, m.drain(batchSize-1)...)
+		if m.stale.Load() {
+			continue // The full copy covers the batch
+		}
+		if err := c.do(m.commands(batch)); err != nil {
+			if !fail("sending changes: %v", err) {
+				return
+			}
+		}
+	}
+}
+
+// drain takes up to n changes that are waiting, without blocking.
+func (m *Mirror) drain(n int) []change {
+	var batch []change
+	for len(batch) < n {
+		select {
+		case c := <-m.changes:
+			batch = append(batch, c)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// resync copies the mirrored apps in full, then deletes the keys matching
+// their patterns in Redis that the copy didn't write, such as keys deleted
+// while the copy was stale. Changes waiting are dropped first: the copy, read
+// after them, includes them.
+func (m *Mirror) resync(c *conn) error {
+	for len(m.drain(queueSize)) > 0 {
+	}
+	personas, err := m.store.GetPersonas()
+	if err != nil {
+		return err
+	}
+	var cmds [][]string
+	flush := func() error {
+		err := c.do(cmds)
+		cmds = cmds[:0]
+		return err
+	}
+	written := make(map[string]bool)
+	for _, personaID := range personas {
+		apps, err := m.store.GetApps(personaID)
+		if err != nil {
+			continue // Purged meanwhile
+		}
+		for _, appID := range apps {
+			if !m.mirrored(personaID, appID) {
+				continue
+			}
+			data, err := m.store.GetAppStore(personaID, appID)
+			if err != nil {
+				continue
+			}
+			for key, val := range data {
+				cmd := m.command(change{personaID: personaID, appID: appID, key: key, val: val})
+				written[cmd[1]] = true
+				cmds = append(cmds, cmd)
+				if len(cmds) == batchSize {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	for _, glob := range m.globs() {
+		err := c.scan(glob, func(keys []string) error {
+			for _, key := range keys {
+				if !written[key] {
+					cmds = append(cmds, []string{"DEL", key})
+				}
+			}
+			if len(cmds) >= batchSize {
+				return flush()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return flush()
+}
+
+// globs returns the Redis patterns matching the keys of the mirrored apps.
+// Since IDs may contain the separators of the key pattern, they may match
+// keys of other apps too: keys matching the pattern of a mirrored app belong
+// to the mirror.
+func (m *Mirror) globs() []string {
+	literal := strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+	var globs []string
+	for _, app := range m.apps {
+		var glob strings.Builder
+		rest := m.keyPattern
+		for rest != "" {
+			before, placeholder, after := cutPlaceholder(rest)
+			glob.WriteString(literal.Replace(before))
+			switch placeholder {
+			case "{app}":
+				glob.WriteString(app) // path.Match patterns read the same in Redis
+			case "{persona}", "{key}":
+				glob.WriteString("*")
+			}
+			rest = after
+		}
+		globs = append(globs, glob.String())
+	}
+	return globs
+}
+
+// cutPlaceholder splits s around its first placeholder, if any.
+func cutPlaceholder(s string) (before, placeholder, after string) {
+	first := -1
+	for _, p := range []string{"{persona}", "{app}", "{key}"} {
+		if i := strings.Index(s, p); i >= 0 && (first < 0 || i < first) {
+			first, placeholder = i, p
+		}
+	}
+	if first < 0 {
+		return s, "", ""
+	}
+	return s[:first], placeholder, s[first+len(placeholder):]
+}
+
+func (m *Mirror) commands(batch []change) [][]string {
+	cmds := make([][]string, len(batch))
+	for i, c := range batch {
+		cmds[i] = m.command(c)
+	}
+	return cmds
+}
+
+// command returns the Redis command applying c.
+func (m *Mirror) command(c change) []string {
+	key := strings.NewReplacer("{persona}", c.personaID, "{app}", c.appID, "{key}", c.key).Replace(m.keyPattern)
+	if c.deleted {
+		return []string{"DEL", key}
+	}
+	return []string{"SET", key, encode(c.val)}
+}
+
+// encode returns what Redis stores for val: strings as they are, other
+// values as JSON.
+func encode(val any) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case json.RawMessage:
+		var s string
+		if json.Unmarshal(v, &s) == nil {
+			return s
+		}
+		return string(v)
+	}
+	data, err := json.Marshal(val)
+	if err != nil {
+		return "null"
+	}
+	return string(data)
+}
@@ -0,0 +1,226 @@
+package redismirror
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/engine"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// fakeRedis speaks enough RESP for the mirror: AUTH, SELECT, SET, DEL and
+// SCAN, which returns every match at once.
+type fakeRedis struct {
+	net.Listener
+	mu    sync.Mutex
+	data  map[string]string
+	auth  []string
+	down  bool // drop connections, as if unreachable
+	conns []net.Conn
+}
+
+func newFakeRedis(t *testing.T, addr string) *fakeRedis {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{Listener: l, data: make(map[string]string)}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			r.mu.Lock()
+			if r.down {
+				c.Close()
+			} else {
+				r.conns = append(r.conns, c)
+				go r.serve(c)
+			}
+			r.mu.Unlock()
+		}
+	}()
+	return r
+}
+
+func (r *fakeRedis) serve(c net.Conn) {
+	defer c.Close()
+	reader := bufio.NewReader(c)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			line, _ = reader.ReadString('\n')
+			size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			buf := make([]byte, size+2)
+			io.ReadFull(reader, buf)
+			args[i] = string(buf[:size])
+		}
+		r.mu.Lock()
+		switch args[0] {
+		case "AUTH", "SELECT":
+			r.auth = append(r.auth, strings.Join(args, " "))
+			fmt.Fprint(c, "+OK\r\n")
+		case "SET":
+			r.data[args[1]] = args[2]
+			fmt.Fprint(c, "+OK\r\n")
+		case "DEL":
+			delete(r.data, args[1])
+			fmt.Fprint(c, ":1\r\n")
+		case "SCAN":
+			var keys []string
+			for key := range r.data {
+				if ok, _ := path.Match(args[3], key); ok {
+					keys = append(keys, key)
+				}
+			}
+			fmt.Fprintf(c, "*2\r\n$1\r\n0\r\n*%d\r\n", len(keys))
+			for _, key := range keys {
+				fmt.Fprintf(c, "$%d\r\n%s\r\n", len(key), key)
+			}
+		default:
+			fmt.Fprint(c, "-ERR unknown command\r\n")
+		}
+		r.mu.Unlock()
+	}
+}
+
+// setDown makes the server unreachable, or reachable again.
+func (r *fakeRedis) setDown(down bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.down = down
+	if down {
+		for _, c := range r.conns {
+			c.Close()
+		}
+		r.conns = nil
+	}
+}
+
+// waitFor polls until key holds want, or is missing for want "".
+func (r *fakeRedis) waitFor(t *testing.T, key, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r.mu.Lock()
+		got, ok := r.data[key]
+		r.mu.Unlock()
+		if got == want && ok == (want != "") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Redis key %s = %q (present %v), want %q", key, got, ok, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	redis := newFakeRedis(t, "127.0.0.1:0")
+	store := engine.NewMemStore(nil, nil)
+	store.Set("p1", "config", "theme", "dark")
+	store.Set("p1", "config", "limits", map[string]any{"max": 5})
+	store.Set("p1", "private", "secret", "x")
+	store.Set(sdk.SystemPersona, "config", "admin", "x")
+
+	m, err := Start(store, Options{URL: "redis://:pw@" + redis.Addr().String() + "/2", Apps: []string{"conf*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	// Existing values are copied on start
+	redis.waitFor(t, "celerix:p1:config:theme", "dark")
+	redis.waitFor(t, "celerix:p1:config:limits", `{"max":5}`)
+
+	// Changes follow
+	store.Set("p2", "config", "theme", "light")
+	redis.waitFor(t, "celerix:p2:config:theme", "light")
+	store.Delete("p1", "config", "theme")
+	redis.waitFor(t, "celerix:p1:config:theme", "")
+
+	store.Set("p1", "private", "other", "x")
+	store.Set("p1", "config", "last", true)
+	redis.waitFor(t, "celerix:p1:config:last", "true")
+	redis.mu.Lock()
+	defer redis.mu.Unlock()
+	for key := range redis.data {
+		if strings.Contains(key, "private") || strings.Contains(key, sdk.SystemPersona) {
+			t.Errorf("Expected only mirrored apps in Redis, found %s", key)
+		}
+	}
+	if len(redis.auth) < 2 || redis.auth[0] != "AUTH pw" || redis.auth[1] != "SELECT 2" {
+		t.Errorf("Expected AUTH and SELECT from the URL, got %q", redis.auth)
+	}
+}
+
+func TestMirror_KeyPatternAndReconnect(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	if _, err := Start(store, Options{URL: "redis://localhost", Apps: []string{"a"}, KeyPattern: "{persona}:{app}"}); err == nil {
+		t.Error("Expected a key pattern without {key} to be refused")
+	}
+	if _, err := Start(store, Options{URL: "http://localhost", Apps: []string{"a"}}); err == nil {
+		t.Error("Expected a URL that isn't redis:// to be refused")
+	}
+
+	// Redis isn't up yet: the mirror keeps trying, then copies what it missed
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	m, err := Start(store, Options{URL: "redis://" + addr, Apps: []string{"flags"}, KeyPattern: "cfg/{app}/{key}@{persona}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	store.Set("p1", "flags", "beta", true)
+	time.Sleep(100 * time.Millisecond)
+
+	redis := newFakeRedis(t, addr)
+	redis.waitFor(t, "cfg/flags/beta@p1", "true")
+}
+
+func TestMirror_DeletesWhileDown(t *testing.T) {
+	redis := newFakeRedis(t, "127.0.0.1:0")
+	redis.mu.Lock()
+	redis.data["celerix:p1:other:x"] = "not mirrored"
+	redis.mu.Unlock()
+	store := engine.NewMemStore(nil, nil)
+	store.Set("p1", "config", "theme", "dark")
+	store.Set("p1", "config", "lang", "en")
+
+	m, err := Start(store, Options{URL: "redis://" + redis.Addr().String(), Apps: []string{"config"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	redis.waitFor(t, "celerix:p1:config:theme", "dark")
+
+	// A key deleted while Redis is unreachable is deleted once it is back
+	redis.setDown(true)
+	store.Set("p1", "config", "poke", true) // Fails to send, so the mirror notices
+	time.Sleep(100 * time.Millisecond)
+	store.Delete("p1", "config", "theme")
+	redis.setDown(false)
+	redis.waitFor(t, "celerix:p1:config:theme", "")
+	redis.waitFor(t, "celerix:p1:config:lang", "en")
+	redis.waitFor(t, "celerix:p1:config:poke", "true")
+	redis.waitFor(t, "celerix:p1:other:x", "not mirrored")
+}
@@ -0,0 +1,231 @@
+package redismirror
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// conn is a minimal Redis client: just enough of RESP to authenticate, select
+// a database and send pipelines of commands.
+type conn struct {
+	net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	timeout time.Duration
+}
+
+// parseURL checks a redis:// or rediss:// (TLS) URL.
+func parseURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("unsupported scheme %q: want redis:// or rediss://", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("missing host")
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if _, err := strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid database %q", db)
+		}
+	}
+	return u, nil
+}
+
+// dial connects to the server at u, then sends AUTH and SELECT as u asks.
+func dial(u *url.URL, timeout time.Duration) (*conn, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	var nc net.Conn
+	var err error
+	if u.Scheme == "rediss" {
+		nc, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: u.Hostname()})
+	} else {
+		nc, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc), timeout: timeout}
+
+	var setup [][]string
+	if password, ok := u.User.Password(); ok {
+		if user := u.User.Username(); user != "" {
+			setup = append(setup, []string{"AUTH", user, password})
+		} else {
+			setup = append(setup, []string{"AUTH", password})
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" && db != "0" {
+		setup = append(setup, []string{"SELECT", db})
+	}
+	if err := c.do(setup); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// do sends cmds in one pipeline and reads all their replies. It returns the
+// first error reply, or the error that broke the connection.
+func (c *conn) do(cmds [][]string) error {
+	if len(cmds) == 0 {
+		return nil
+	}
+	c.SetDeadline(time.Now().Add(c.timeout))
+	for _, cmd := range cmds {
+		c.write(cmd)
+	}
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	var first error
+	for range cmds {
+		if err := c.readReply(); err != nil {
+			var reply replyError
+			if !errors.As(err, &reply) {
+				return err
+			}
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// write buffers a command.
+func (c *conn) write(cmd []string) {
+	fmt.Fprintf(c.w, "*%d\r\n", len(cmd))
+	for _, arg := range cmd {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+// replyError is an error the server answered with, as opposed to one that
+// broke the connection.
+type replyError string
+
+func (e replyError) Error() string {
+	return "redis: " + string(e)
+}
+
+// readReply reads and discards one reply, returning it if it's an error.
+func (c *conn) readReply() error {
+	_, err := c.readValue()
+	return err
+}
+
+// readValue reads one reply: a string for simple and bulk strings, an int64
+// for integers, nil for null and a []any for arrays. Error replies are
+// returned as a replyError, after the rest of an array they are part of.
+func (c *conn) readValue() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad reply %q", line)
+		}
+		return n, nil
+	case '-':
+		return nil, replyError(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		var first error
+		values := make([]any, n)
+		for i := range values {
+			if values[i], err = c.readValue(); err != nil {
+				var reply replyError
+				if !errors.As(err, &reply) {
+					return nil, err
+				}
+				if first == nil {
+					first = err
+				}
+			}
+		}
+		return values, first
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// scan calls fn with the keys matching the glob pattern match, a batch at a
+// time, until SCAN has gone through every key.
+func (c *conn) scan(match string, fn func(keys []string) error) error {
+	cursor := "0"
+	for {
+		c.SetDeadline(time.Now().Add(c.timeout))
+		c.write([]string{"SCAN", cursor, "MATCH", match, "COUNT", "1000"})
+		if err := c.w.Flush(); err != nil {
+			return err
+		}
+		reply, err := c.readValue()
+		if err != nil {
+			return err
+		}
+		parts, ok := reply.([]any)
+		if !ok || len(parts) != 2 {
+			return fmt.Errorf("redis: unexpected SCAN reply %v", reply)
+		}
+		next, ok := parts[0].(string)
+		found, ok2 := parts[1].([]any)
+		if !ok || !ok2 {
+			return fmt.Errorf("redis: unexpected SCAN reply %v", reply)
+		}
+		keys := make([]string, 0, len(found))
+		for _, k := range found {
+			if key, ok := k.(string); ok {
+				keys = append(keys, key)
+			}
+		}
+		if err := fn(keys); err != nil {
+			return err
+		}
+		if next == "0" {
+			return nil
+		}
+		cursor = next
+	}
+}
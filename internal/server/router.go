@@ -1,276 +0,0 @@
-package server
-
-import (
-	"bufio"
-	"crypto/tls"
-	"encoding/json"
-	"fmt"
-	"net"
-	"strings"
-	"sync"
-	"time"
-
-	"github.com/celerix-dev/celerix-store/pkg/sdk"
-)
-
-type Router struct {
-	store    sdk.CelerixStore
-	cert     *tls.Certificate
-	listener net.Listener
-	mu       sync.Mutex
-}
-
-func NewRouter(s sdk.CelerixStore) *Router {
-	return &Router{store: s}
-}
-
-// SetCertificate sets the TLS certificate for the router
-func (r *Router) SetCertificate(cert tls.Certificate) {
-	r.cert = &cert
-}
-
-// Stop closes the listener and stops the server
-func (r *Router) Stop() {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	if r.listener != nil {
-		r.listener.Close()
-		r.listener = nil
-	}
-}
-
-// Listen starts the TCP server
-func (r *Router) Listen(port string) error {
-	var listener net.Listener
-	var err error
-
-	if r.cert != nil {
-		config := &tls.Config{Certificates: []tls.Certificate{*r.cert}}
-		listener, err = tls.Listen("tcp", ":"+port, config)
-	} else {
-		listener, err = net.Listen("tcp", ":"+port)
-	}
-	if err != nil {
-		return err
-	}
-
-	r.mu.Lock()
-	r.listener = listener
-	r.mu.Unlock()
-
-	defer func() {
-		r.mu.Lock()
-		if r.listener != nil {
-			r.listener.Close()
-			r.listener = nil
-		}
-		r.mu.Unlock()
-	}()
-
-	semaphore := make(chan struct{}, 100) // Max 100 concurrent connections
-
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			r.mu.Lock()
-			isNil := r.listener == nil
-			r.mu.Unlock()
-			if isNil {
-				return nil // Graceful shutdown
-			}
-			continue
-		}
-
-		go func(c net.Conn) {
-			select {
-			case semaphore <- struct{}{}:
-				// Acquired semaphore
-			default:
-				fmt.Println("[Celerix Store] Server busy: too many concurrent connections. Rejecting...")
-				c.Close()
-				return
-			}
-
-			defer func() {
-				<-semaphore
-				c.Close()
-			}()
-			r.handleConnection(c)
-		}(conn)
-	}
-}
-
-func (r *Router) HandleConnection(conn net.Conn) {
-	r.handleConnection(conn)
-}
-
-func (r *Router) handleConnection(conn net.Conn) {
-	reader := bufio.NewReader(conn)
-
-	for {
-		// Set a deadline for the next command (5 minutes idle timeout)
-		conn.SetReadDeadline(time.Now().Add(5 * time.Minute))
-
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			return // Connection closed or timeout
-		}
-
-		line = strings.TrimSpace(line)
-		parts := strings.Fields(line)
-		if len(parts) < 1 {
-			continue
-		}
-
-		command := strings.ToUpper(parts[0])
-
-		switch command {
-		case "GET":
-			if len(parts) < 4 {
-				continue
-			}
-			val, err := r.store.Get(parts[1], parts[2], parts[3])
-			if err != nil {
-				fmt.Fprintln(conn, "ERR", err)
-			} else {
-				// Send back as JSON
-				res, err := json.Marshal(val)
-				if err != nil {
-					fmt.Fprintln(conn, "ERR internal error")
-				} else {
-					fmt.Fprintln(conn, "OK", string(res))
-				}
-			}
-
-		case "SET":
-			if len(parts) < 5 {
-				continue
-			}
-			// The value is everything after the 4th word
-			valueStr := strings.Join(parts[4:], " ")
-			var val any
-			if err := json.Unmarshal([]byte(valueStr), &val); err != nil {
-				fmt.Fprintln(conn, "ERR invalid json value")
-				continue
-			}
-
-			err := r.store.Set(parts[1], parts[2], parts[3], val)
-			if err != nil {
-				fmt.Fprintln(conn, "ERR", err)
-			} else {
-				fmt.Fprintln(conn, "OK")
-			}
-
-		case "DEL":
-			if len(parts) < 4 {
-				continue
-			}
-			err := r.store.Delete(parts[1], parts[2], parts[3])
-			if err != nil {
-				fmt.Fprintln(conn, "ERR", err)
-			} else {
-				fmt.Fprintln(conn, "OK")
-			}
-
-		case "LIST_PERSONAS":
-			list, err := r.store.GetPersonas()
-			if err != nil {
-				fmt.Fprintln(conn, "ERR", err)
-			} else {
-				res, err := json.Marshal(list)
-				if err != nil {
-					fmt.Fprintln(conn, "ERR internal error")
-				} else {
-					fmt.Fprintln(conn, "OK", string(res))
-				}
-			}
-
-		case "LIST_APPS":
-			if len(parts) < 2 {
-				continue
-			}
-			list, err := r.store.GetApps(parts[1])
-			if err != nil {
-				fmt.Fprintln(conn, "ERR", err)
-			} else {
-				res, err := json.Marshal(list)
-				if err != nil {
-					fmt.Fprintln(conn, "ERR internal error")
-				} else {
-					fmt.Fprintln(conn, "OK", string(res))
-				}
-			}
-
-		case "DUMP":
-			if len(parts) < 3 {
-				continue
-			}
-			data, err := r.store.GetAppStore(parts[1], parts[2])
-			if err != nil {
-				fmt.Fprintln(conn, "ERR", err)
-			} else {
-				res, err := json.Marshal(data)
-				if err != nil {
-					fmt.Fprintln(conn, "ERR internal error")
-				} else {
-					fmt.Fprintln(conn, "OK", string(res))
-				}
-			}
-
-		case "DUMP_APP":
-			if len(parts) < 2 {
-				continue
-			}
-			data, err := r.store.DumpApp(parts[1])
-			if err != nil {
-				fmt.Fprintln(conn, "ERR", err)
-			} else {
-				res, err := json.Marshal(data)
-				if err != nil {
-					fmt.Fprintln(conn, "ERR internal error")
-				} else {
-					fmt.Fprintln(conn, "OK", string(res))
-				}
-			}
-
-		case "GET_GLOBAL":
-			if len(parts) < 3 {
-				continue
-			}
-			val, personaID, err := r.store.GetGlobal(parts[1], parts[2])
-			if err != nil {
-				fmt.Fprintln(conn, "ERR", err)
-			} else {
-				// We return a small JSON object with both value and persona
-				out := map[string]any{
-					"persona": personaID,
-					"value":   val,
-				}
-				final, err := json.Marshal(out)
-				if err != nil {
-					fmt.Fprintln(conn, "ERR internal error")
-				} else {
-					fmt.Fprintln(conn, "OK", string(final))
-				}
-			}
-
-		case "MOVE":
-			if len(parts) < 5 {
-				continue
-			}
-			// MOVE src dst app key
-			err := r.store.Move(parts[1], parts[2], parts[3], parts[4])
-			if err != nil {
-				fmt.Fprintln(conn, "ERR", err)
-			} else {
-				fmt.Fprintln(conn, "OK")
-			}
-
-		case "PING":
-			fmt.Fprintln(conn, "PONG")
-
-		case "QUIT":
-			return
-		}
-	}
-}
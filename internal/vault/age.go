@@ -0,0 +1,458 @@
+package vault
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// This file implements the X25519 recipient type of the age v1 format
+// (https://age-encryption.org/v1), so data encrypted here can be opened with
+// the standard age tools and vice versa.
+
+const (
+	ageIntro          = "age-encryption.org/v1"
+	ageX25519Label    = "age-encryption.org/v1/X25519"
+	ageRecipientHRP   = "age"
+	ageIdentityHRP    = "AGE-SECRET-KEY-"
+	ageFileKeySize    = 16
+	ageStreamChunk    = 64 * 1024
+	ageStanzaColumns  = 64
+	agePayloadNonceSz = 16
+)
+
+var b64 = base64.RawStdEncoding.Strict()
+
+// GenerateAgeIdentity creates an X25519 key pair. The identity (AGE-SECRET-KEY-1...)
+// stays with the user; the recipient (age1...) is what data is encrypted to.
+func GenerateAgeIdentity() (identity, recipient string, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	identity, err = bech32Encode(ageIdentityHRP, key.Bytes())
+	if err != nil {
+		return "", "", err
+	}
+	recipient, err = bech32Encode(ageRecipientHRP, key.PublicKey().Bytes())
+	if err != nil {
+		return "", "", err
+	}
+	return strings.ToUpper(identity), recipient, nil
+}
+
+// ParseAgeRecipient validates an age1... public key.
+func ParseAgeRecipient(recipient string) (*ecdh.PublicKey, error) {
+	hrp, data, err := bech32Decode(recipient)
+	if err != nil || hrp != ageRecipientHRP {
+		return nil, fmt.Errorf("invalid age recipient")
+	}
+	return ecdh.X25519().NewPublicKey(data)
+}
+
+// ParseAgeIdentity validates an AGE-SECRET-KEY-1... private key. Identity files
+// as written by age-keygen are accepted too: comment lines are skipped.
+func ParseAgeIdentity(identity string) (*ecdh.PrivateKey, error) {
+	for _, line := range strings.Split(identity, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hrp, data, err := bech32Decode(line)
+		if err != nil || hrp != strings.ToLower(ageIdentityHRP) {
+			return nil, fmt.Errorf("invalid age identity")
+		}
+		return ecdh.X25519().NewPrivateKey(data)
+	}
+	return nil, fmt.Errorf("no age identity found")
+}
+
+// IsAgeEncrypted reports whether data starts with the age v1 header.
+func IsAgeEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(ageIntro+"\n"))
+}
+
+// EncryptToRecipient encrypts plaintext to an age1... public key. Only the
+// holder of the matching identity can decrypt the result.
+func EncryptToRecipient(plaintext []byte, recipient string) ([]byte, error) {
+	pub, err := ParseAgeRecipient(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	fileKey := make([]byte, ageFileKeySize)
+	if _, err := io.ReadFull(rand.Reader, fileKey); err != nil {
+		return nil, err
+	}
+
+	// Wrap the file key for the recipient with an ephemeral X25519 exchange.
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(pub)
+	if err != nil {
+		return nil, err
+	}
+	share := ephemeral.PublicKey().Bytes()
+	wrapped, err := ageWrap(shared, share, pub.Bytes(), fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var header bytes.Buffer
+	header.WriteString(ageIntro + "\n")
+	header.WriteString("-> X25519 " + b64.EncodeToString(share) + "\n")
+	writeStanzaBody(&header, wrapped)
+	header.WriteString("---")
+	mac, err := ageHeaderMAC(fileKey, header.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	out := bytes.NewBuffer(header.Bytes())
+	out.WriteString(" " + b64.EncodeToString(mac) + "\n")
+
+	nonce := make([]byte, agePayloadNonceSz)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out.Write(nonce)
+	payloadKey, err := hkdf.Key(sha256.New, fileKey, nonce, "payload", chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	if err := ageStreamSeal(out, payloadKey, plaintext); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// DecryptWithIdentity opens data produced by EncryptToRecipient (or by age
+// for an X25519 recipient) with the matching identity.
+func DecryptWithIdentity(ciphertext []byte, identity string) ([]byte, error) {
+	priv, err := ParseAgeIdentity(identity)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(bytes.NewReader(ciphertext))
+	var header bytes.Buffer
+	readLine := func() (string, error) {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("malformed age header")
+		}
+		header.WriteString(line)
+		return strings.TrimSuffix(line, "\n"), nil
+	}
+
+	if line, err := readLine(); err != nil || line != ageIntro {
+		return nil, fmt.Errorf("not an age v1 file")
+	}
+
+	var fileKey []byte
+	var macLine string
+	for {
+		line, err := readLine()
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(line, "---") {
+			macLine = line
+			break
+		}
+		args := strings.Fields(strings.TrimPrefix(line, "->"))
+		if !strings.HasPrefix(line, "-> ") || len(args) == 0 {
+			return nil, fmt.Errorf("malformed age header")
+		}
+		var body []byte
+		for {
+			bodyLine, err := readLine()
+			if err != nil {
+				return nil, err
+			}
+			chunk, err := b64.DecodeString(bodyLine)
+			if err != nil {
+				return nil, fmt.Errorf("malformed age header")
+			}
+			body = append(body, chunk...)
+			if len(bodyLine) < ageStanzaColumns {
+				break
+			}
+		}
+		if fileKey != nil || args[0] != "X25519" || len(args) != 2 {
+			continue
+		}
+		share, err := b64.DecodeString(args[1])
+		if err != nil {
+			return nil, fmt.Errorf("malformed age header")
+		}
+		pub, err := ecdh.X25519().NewPublicKey(share)
+		if err != nil {
+			return nil, fmt.Errorf("malformed age header")
+		}
+		shared, err := priv.ECDH(pub)
+		if err != nil {
+			continue
+		}
+		fileKey, _ = ageUnwrap(shared, share, priv.PublicKey().Bytes(), body)
+	}
+	if fileKey == nil {
+		return nil, fmt.Errorf("no matching recipient (wrong identity?)")
+	}
+
+	// The MAC covers the header up to and including "---".
+	mac, err := b64.DecodeString(strings.TrimPrefix(macLine, "--- "))
+	if err != nil {
+		return nil, fmt.Errorf("malformed age header")
+	}
+	signed := header.Bytes()[:header.Len()-len(macLine)-1+len("---")]
+	expected, err := ageHeaderMAC(fileKey, signed)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, expected) {
+		return nil, fmt.Errorf("age header MAC mismatch (tampered data)")
+	}
+
+	nonce := make([]byte, agePayloadNonceSz)
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, fmt.Errorf("truncated age payload")
+	}
+	payloadKey, err := hkdf.Key(sha256.New, fileKey, nonce, "payload", chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ageStreamOpen(payloadKey, payload)
+}
+
+func ageWrapKey(shared, share, recipient []byte) ([]byte, error) {
+	if bytes.Equal(shared, make([]byte, len(shared))) {
+		return nil, errors.New("invalid X25519 shared secret")
+	}
+	salt := append(append([]byte{}, share...), recipient...)
+	return hkdf.Key(sha256.New, shared, salt, ageX25519Label, chacha20poly1305.KeySize)
+}
+
+func ageWrap(shared, share, recipient, fileKey []byte) ([]byte, error) {
+	key, err := ageWrapKey(shared, share, recipient)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, make([]byte, chacha20poly1305.NonceSize), fileKey, nil), nil
+}
+
+func ageUnwrap(shared, share, recipient, body []byte) ([]byte, error) {
+	key, err := ageWrapKey(shared, share, recipient)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	fileKey, err := aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), body, nil)
+	if err != nil || len(fileKey) != ageFileKeySize {
+		return nil, errors.New("wrong identity")
+	}
+	return fileKey, nil
+}
+
+func ageHeaderMAC(fileKey, header []byte) ([]byte, error) {
+	key, err := hkdf.Key(sha256.New, fileKey, nil, "header", 32)
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write(header)
+	return h.Sum(nil), nil
+}
+
+// writeStanzaBody writes base64 wrapped at 64 columns. The last line is always
+// shorter than 64 characters (possibly empty), which is how readers find the end.
+func writeStanzaBody(w *bytes.Buffer, body []byte) {
+	encoded := b64.EncodeToString(body)
+	for len(encoded) >= ageStanzaColumns {
+		w.WriteString(encoded[:ageStanzaColumns] + "\n")
+		encoded = encoded[ageStanzaColumns:]
+	}
+	w.WriteString(encoded + "\n")
+}
+
+// ageStreamNonce is an 11-byte big-endian chunk counter followed by a flag
+// byte marking the final chunk.
+func ageStreamNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	for i := 10; i >= 3; i-- {
+		nonce[i] = byte(counter)
+		counter >>= 8
+	}
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func ageStreamSeal(w *bytes.Buffer, key, plaintext []byte) error {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return err
+	}
+	for counter := uint64(0); ; counter++ {
+		n := min(len(plaintext), ageStreamChunk)
+		last := n == len(plaintext)
+		w.Write(aead.Seal(nil, ageStreamNonce(counter, last), plaintext[:n], nil))
+		plaintext = plaintext[n:]
+		if last {
+			return nil
+		}
+	}
+}
+
+func ageStreamOpen(key, payload []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	sealedChunk := ageStreamChunk + aead.Overhead()
+	var plaintext []byte
+	for counter := uint64(0); ; counter++ {
+		n := min(len(payload), sealedChunk)
+		last := n == len(payload)
+		chunk, err := aead.Open(nil, ageStreamNonce(counter, last), payload[:n], nil)
+		if err != nil {
+			return nil, fmt.Errorf("decryption failed (truncated or tampered data)")
+		}
+		if last && len(chunk) == 0 && counter > 0 {
+			return nil, fmt.Errorf("decryption failed (truncated or tampered data)")
+		}
+		plaintext = append(plaintext, chunk...)
+		payload = payload[n:]
+		if last {
+			return plaintext, nil
+		}
+	}
+}
+
+// --- Bech32 (BIP 173), used for age key encoding ---
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	out := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]>>5)
+	}
+	out = append(out, 0)
+	for i := 0; i < len(hrp); i++ {
+		out = append(out, hrp[i]&31)
+	}
+	return out
+}
+
+// convertBits regroups a byte slice between bit widths (8 to 5 and back).
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	var acc uint32
+	var bits uint
+	var out []byte
+	maxv := uint32(1)<<to - 1
+	for _, b := range data {
+		acc = acc<<from | uint32(b)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil, errors.New("invalid bech32 padding")
+	}
+	return out, nil
+}
+
+func bech32Encode(hrp string, data []byte) (string, error) {
+	hrp = strings.ToLower(hrp)
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	poly := bech32Polymod(append(append(bech32HRPExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	var sb strings.Builder
+	sb.WriteString(hrp + "1")
+	for _, v := range values {
+		sb.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(bech32Charset[(poly>>uint(5*(5-i)))&31])
+	}
+	return sb.String(), nil
+}
+
+// bech32Decode decodes s without the usual 90 character limit, as age does.
+// The returned HRP is lowercase.
+func bech32Decode(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case bech32")
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("invalid bech32 separator")
+	}
+	hrp := s[:pos]
+	values := make([]byte, 0, len(s)-pos-1)
+	for i := pos + 1; i < len(s); i++ {
+		v := strings.IndexByte(bech32Charset, s[i])
+		if v < 0 {
+			return "", nil, errors.New("invalid bech32 character")
+		}
+		values = append(values, byte(v))
+	}
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != 1 {
+		return "", nil, errors.New("invalid bech32 checksum")
+	}
+	data, err := convertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return hrp, data, nil
+}
@@ -7,9 +7,18 @@ import (
 	"crypto/rand"
 	"fmt"
 	"io"
+	"strings"
 )
 
-// Encrypt takes a plaintext string and a 32-byte key, returning an encrypted hex string.
+// formatV1 prefixes values produced by Encrypt, so the format can evolve.
+// Values written before it existed are bare hex and still decrypt.
+const (
+	formatPrefix = "vault:"
+	formatV1     = formatPrefix + "v1:"
+)
+
+// Encrypt takes a plaintext string and a 32-byte key, returning an encrypted
+// hex string prefixed with the format version.
 func Encrypt(plaintext string, key []byte) (string, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -30,11 +39,24 @@ func Encrypt(plaintext string, key []byte) (string, error) {
 
 	// Encrypt the data and prepend the nonce so we can decrypt it later
 	ciphertext := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
-	return fmt.Sprintf("%x", ciphertext), nil
+	return fmt.Sprintf("%s%x", formatV1, ciphertext), nil
+}
+
+// IsEncrypted reports whether stored carries the format prefix of Encrypt.
+// Bare hex values from before the prefix existed can't be told apart from
+// other strings.
+func IsEncrypted(stored string) bool {
+	return strings.HasPrefix(stored, formatPrefix)
 }
 
-// Decrypt takes the hex string and the 32-byte key to return the original text.
+// Decrypt takes the string from Encrypt and the 32-byte key to return the original text.
 func Decrypt(cipherHex string, key []byte) (string, error) {
+	if strings.HasPrefix(cipherHex, formatV1) {
+		cipherHex = strings.TrimPrefix(cipherHex, formatV1)
+	} else if IsEncrypted(cipherHex) {
+		return "", fmt.Errorf("unsupported vault format %q", strings.SplitN(cipherHex, ":", 3)[1])
+	}
+
 	var ciphertext []byte
 	_, err := fmt.Sscanf(cipherHex, "%x", &ciphertext)
 	if err != nil {
@@ -64,3 +86,20 @@ func Decrypt(cipherHex string, key []byte) (string, error) {
 
 	return string(plaintext), nil
 }
+
+// Reencrypt decrypts stored with oldKey and encrypts the result with newKey.
+// rotated is false, with no error, when stored already decrypts with newKey.
+func Reencrypt(stored string, oldKey, newKey []byte) (updated string, rotated bool, err error) {
+	plaintext, err := Decrypt(stored, oldKey)
+	if err != nil {
+		if _, newErr := Decrypt(stored, newKey); newErr == nil {
+			return stored, false, nil
+		}
+		return "", false, err
+	}
+	updated, err = Encrypt(plaintext, newKey)
+	if err != nil {
+		return "", false, err
+	}
+	return updated, true, nil
+}
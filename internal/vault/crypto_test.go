@@ -1,6 +1,8 @@
 package vault
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 )
 
@@ -16,6 +18,9 @@ func TestEncryptDecrypt(t *testing.T) {
 	if ciphertext == plaintext {
 		t.Fatal("Ciphertext should not be equal to plaintext")
 	}
+	if !strings.HasPrefix(ciphertext, "vault:v1:") || !IsEncrypted(ciphertext) {
+		t.Errorf("Expected a versioned ciphertext, got %q", ciphertext)
+	}
 
 	decrypted, err := Decrypt(ciphertext, key)
 	if err != nil {
@@ -89,3 +94,144 @@ func TestDecryptTooShort(t *testing.T) {
 		t.Fatal("Decryption should fail with too short ciphertext")
 	}
 }
+
+func TestBech32Vectors(t *testing.T) {
+	// Valid strings from BIP 173
+	for _, s := range []string{"A12UEL5L", "abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw"} {
+		if _, _, err := bech32Decode(s); err != nil {
+			t.Errorf("Expected %s to decode: %v", s, err)
+		}
+	}
+	if _, _, err := bech32Decode("abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxx"); err == nil {
+		t.Error("Expected a checksum error")
+	}
+}
+
+func TestAgeRoundTrip(t *testing.T) {
+	identity, recipient, err := GenerateAgeIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(identity, "AGE-SECRET-KEY-1") || !strings.HasPrefix(recipient, "age1") {
+		t.Fatalf("Unexpected key encoding: %s %s", identity, recipient)
+	}
+
+	// Empty, single chunk and multi-chunk payloads
+	for _, size := range []int{0, 100, ageStreamChunk, 3*ageStreamChunk + 7} {
+		plaintext := bytes.Repeat([]byte("x"), size)
+		ciphertext, err := EncryptToRecipient(plaintext, recipient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsAgeEncrypted(ciphertext) {
+			t.Fatal("Expected an age header")
+		}
+		got, err := DecryptWithIdentity(ciphertext, "# public key: "+recipient+"\n"+identity+"\n")
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("size %d: round trip mismatch", size)
+		}
+	}
+
+	ciphertext, _ := EncryptToRecipient([]byte("secret"), recipient)
+	other, _, _ := GenerateAgeIdentity()
+	if _, err := DecryptWithIdentity(ciphertext, other); err == nil {
+		t.Error("Expected the wrong identity to fail")
+	}
+	ciphertext[len(ciphertext)-1] ^= 1
+	if _, err := DecryptWithIdentity(ciphertext, identity); err == nil {
+		t.Error("Expected tampered data to fail")
+	}
+	if _, err := EncryptToRecipient([]byte("x"), "age1notakey"); err == nil {
+		t.Error("Expected an invalid recipient to be rejected")
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	if !bytes.Equal(KeyFromPassphrase("correct horse", salt), KeyFromPassphrase("correct horse", salt)) {
+		t.Error("Expected the same passphrase and salt to give the same key")
+	}
+	if len(KeyFromPassphrase("correct horse", salt)) != 32 {
+		t.Error("Expected a 32-byte key")
+	}
+
+	stored, err := NewPassphrase("correct horse").Encrypt("secret")
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if !IsPassphraseEncrypted(stored) || !strings.HasPrefix(stored, "$argon2id$v=19$m=65536,t=3,p=4$") {
+		t.Errorf("Expected encoded KDF parameters, got %q", stored)
+	}
+
+	// Another instance, with its own salt for new values, still reads it
+	if plain, err := NewPassphrase("correct horse").Decrypt(stored); err != nil || plain != "secret" {
+		t.Errorf("Expected round trip, got %q, %v", plain, err)
+	}
+	if _, err := NewPassphrase("wrong").Decrypt(stored); err == nil {
+		t.Error("Expected decryption with the wrong passphrase to fail")
+	}
+	tampered := strings.Replace(stored, "m=65536", "m=99999999", 1)
+	if _, err := NewPassphrase("correct horse").Decrypt(tampered); err == nil {
+		t.Error("Expected out-of-range parameters to be rejected")
+	}
+}
+
+func TestVersionedFormat(t *testing.T) {
+	key := []byte("thisis32byteslongsecretkey123456")
+	newKey := []byte("another32byteslongsecretkey65432")
+	stored, err := Encrypt("secret", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Values from before the format prefix are bare hex
+	legacy := strings.TrimPrefix(stored, "vault:v1:")
+	if plain, err := Decrypt(legacy, key); err != nil || plain != "secret" {
+		t.Errorf("Expected legacy values to decrypt, got %q, %v", plain, err)
+	}
+	if _, err := Decrypt("vault:v9:"+legacy, key); err == nil || !strings.Contains(err.Error(), "v9") {
+		t.Errorf("Expected an unsupported version error, got %v", err)
+	}
+
+	rotated, ok, err := Reencrypt(legacy, key, newKey)
+	if err != nil || !ok || !IsEncrypted(rotated) {
+		t.Fatalf("Reencrypt failed: %q, %v, %v", rotated, ok, err)
+	}
+	if plain, err := Decrypt(rotated, newKey); err != nil || plain != "secret" {
+		t.Errorf("Expected the new key to decrypt, got %q, %v", plain, err)
+	}
+	if _, ok, err := Reencrypt(rotated, key, newKey); ok || err != nil {
+		t.Errorf("Expected a value already under the new key to be left alone, got %v, %v", ok, err)
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyPassword("correct horse", hash) {
+		t.Error("Expected the password to verify")
+	}
+	if VerifyPassword("wrong", hash) || VerifyPassword("correct horse", "plain") {
+		t.Error("Expected a wrong password or malformed hash to fail")
+	}
+}
+
+// FuzzDecrypt checks that hostile stored values fail cleanly instead of panicking.
+func FuzzDecrypt(f *testing.F) {
+	key := []byte("thisis32byteslongsecretkey123456")
+	valid, err := Encrypt("Hello, Celerix!", key)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, seed := range []string{valid, "", "vault:", "vault:v2:00", "vault:v1:zz", "vault:v1:0", "00", strings.Repeat("ab", 12)} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, cipherHex string) {
+		Decrypt(cipherHex, key)
+	})
+}
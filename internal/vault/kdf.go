@@ -0,0 +1,186 @@
+package vault
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// KDFParams are the argon2id cost parameters used to derive keys from passphrases.
+type KDFParams struct {
+	Memory  uint32 // KiB
+	Time    uint32
+	Threads uint8
+}
+
+// DefaultKDFParams follow the second recommended option of RFC 9106 (64 MiB, 3 passes).
+var DefaultKDFParams = KDFParams{Memory: 64 * 1024, Time: 3, Threads: 4}
+
+// SaltSize is the length of salts generated by NewSalt.
+const SaltSize = 16
+
+// passphrasePrefix starts every value encrypted with a passphrase:
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<ciphertext hex>
+const passphrasePrefix = "$argon2id$"
+
+// NewSalt returns a random salt for KeyFromPassphrase.
+func NewSalt() ([]byte, error) {
+	salt := make([]byte, SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// KeyFromPassphrase derives a 32-byte key from a passphrase with argon2id and
+// DefaultKDFParams. The same passphrase and salt always give the same key, so
+// the salt must be kept (it need not be secret).
+func KeyFromPassphrase(passphrase string, salt []byte) []byte {
+	return DefaultKDFParams.derive(passphrase, salt)
+}
+
+func (p KDFParams) derive(passphrase string, salt []byte) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, p.Time, p.Memory, p.Threads, 32)
+}
+
+// Passphrase encrypts values with keys derived from a passphrase. Each value
+// records the KDF parameters and salt it was encrypted with, so it can be
+// decrypted later even if the defaults change. Derived keys are cached, so
+// only the first use of each salt pays for the derivation.
+type Passphrase struct {
+	passphrase string
+	params     KDFParams
+
+	mu   sync.Mutex
+	salt []byte            // Used for new values, created on first Encrypt
+	keys map[string][]byte // By encoded parameters and salt
+}
+
+// NewPassphrase returns a Passphrase using DefaultKDFParams for new values.
+func NewPassphrase(passphrase string) *Passphrase {
+	return &Passphrase{passphrase: passphrase, params: DefaultKDFParams, keys: make(map[string][]byte)}
+}
+
+// IsPassphraseEncrypted reports whether a stored value was encrypted with a Passphrase.
+func IsPassphraseEncrypted(stored string) bool {
+	return strings.HasPrefix(stored, passphrasePrefix)
+}
+
+// Encrypt encrypts plaintext, prefixing the result with the KDF parameters and salt.
+func (p *Passphrase) Encrypt(plaintext string) (string, error) {
+	p.mu.Lock()
+	if p.salt == nil {
+		salt, err := NewSalt()
+		if err != nil {
+			p.mu.Unlock()
+			return "", err
+		}
+		p.salt = salt
+	}
+	header := encodeKDF(p.params, p.salt)
+	key := p.keyLocked(header, p.params, p.salt)
+	p.mu.Unlock()
+
+	ciphertext, err := Encrypt(plaintext, key)
+	if err != nil {
+		return "", err
+	}
+	return header + "$" + ciphertext, nil
+}
+
+// Decrypt decrypts a value produced by Encrypt.
+func (p *Passphrase) Decrypt(stored string) (string, error) {
+	i := strings.LastIndexByte(stored, '$')
+	if !IsPassphraseEncrypted(stored) || i < 0 {
+		return "", fmt.Errorf("value is not passphrase-encrypted")
+	}
+	header, ciphertext := stored[:i], stored[i+1:]
+	params, salt, err := parseKDF(header)
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	key := p.keyLocked(header, params, salt)
+	p.mu.Unlock()
+	return Decrypt(ciphertext, key)
+}
+
+// keyLocked returns the cached key for header, deriving it if needed. It MUST
+// be called while holding p.mu.
+func (p *Passphrase) keyLocked(header string, params KDFParams, salt []byte) []byte {
+	if key, ok := p.keys[header]; ok {
+		return key
+	}
+	key := params.derive(p.passphrase, salt)
+	p.keys[header] = key
+	return key
+}
+
+// encodeKDF renders parameters and salt like the PHC string format.
+func encodeKDF(p KDFParams, salt []byte) string {
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s", passphrasePrefix, argon2.Version,
+		p.Memory, p.Time, p.Threads, base64.RawStdEncoding.EncodeToString(salt))
+}
+
+// Bounds on the work a stored value can make Decrypt do.
+const (
+	maxKDFMemory = 1024 * 1024 // 1 GiB in KiB
+	maxKDFTime   = 100
+)
+
+func parseKDF(header string) (KDFParams, []byte, error) {
+	var p KDFParams
+	var version int
+	fields := strings.Split(strings.TrimPrefix(header, passphrasePrefix), "$")
+	if len(fields) != 3 {
+		return p, nil, fmt.Errorf("malformed passphrase parameters")
+	}
+	if _, err := fmt.Sscanf(fields[0], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, fmt.Errorf("unsupported argon2 version %q", fields[0])
+	}
+	if _, err := fmt.Sscanf(fields[1], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, fmt.Errorf("malformed argon2 parameters %q", fields[1])
+	}
+	if p.Memory == 0 || p.Memory > maxKDFMemory || p.Time == 0 || p.Time > maxKDFTime || p.Threads == 0 {
+		return p, nil, fmt.Errorf("argon2 parameters out of range %q", fields[1])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(fields[2])
+	if err != nil || len(salt) == 0 {
+		return p, nil, fmt.Errorf("malformed salt")
+	}
+	return p, salt, nil
+}
+
+// HashPassword hashes a password with argon2id and DefaultKDFParams for
+// storage, in the PHC string format: $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>.
+func HashPassword(password string) (string, error) {
+	salt, err := NewSalt()
+	if err != nil {
+		return "", err
+	}
+	hash := DefaultKDFParams.derive(password, salt)
+	return encodeKDF(DefaultKDFParams, salt) + "$" + base64.RawStdEncoding.EncodeToString(hash), nil
+}
+
+// VerifyPassword reports whether password matches a hash from HashPassword.
+func VerifyPassword(password, stored string) bool {
+	i := strings.LastIndexByte(stored, '$')
+	if !IsPassphraseEncrypted(stored) || i < 0 {
+		return false
+	}
+	params, salt, err := parseKDF(stored[:i])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(stored[i+1:])
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(params.derive(password, salt), want) == 1
+}
@@ -0,0 +1,29 @@
+// Package celerixtest gives services using the store a one-line integration
+// test setup:
+//
+//	func TestMyService(t *testing.T) {
+//	    client := celerixtest.StartTestServer(t)
+//	    svc := myservice.New(client)
+//	    ...
+//	}
+//
+// The daemon runs in process on a random local port and is torn down through
+// t.Cleanup. Tests that need its HTTP API, data directory or engine use
+// testutil.StartServer, which this wraps.
+package celerixtest
+
+import (
+	"testing"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+	"github.com/celerix-dev/celerix-store/pkg/testutil"
+)
+
+// StartTestServer starts an in-memory engine and router on a random local port
+// and returns a client connected to it. opts are those of
+// testutil.StartServer, e.g. testutil.WithSeed. It fails the test immediately
+// if anything can't be started.
+func StartTestServer(t testing.TB, opts ...testutil.Option) *sdk.Client {
+	t.Helper()
+	return testutil.StartServer(t, opts...).Client
+}
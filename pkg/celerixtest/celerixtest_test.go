@@ -0,0 +1,23 @@
+package celerixtest
+
+import (
+	"testing"
+
+	"github.com/celerix-dev/celerix-store/pkg/testutil"
+)
+
+func TestStartTestServer(t *testing.T) {
+	client := StartTestServer(t, testutil.WithData(map[string]map[string]map[string]any{
+		"p1": {"a1": {"seeded": "yes"}},
+	}))
+
+	if err := client.Set("p1", "a1", "k1", "v1"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if val, err := client.Get("p1", "a1", "k1"); err != nil || val != "v1" {
+		t.Errorf("Expected v1, got %v, %v", val, err)
+	}
+	if val, err := client.Get("p1", "a1", "seeded"); err != nil || val != "yes" {
+		t.Errorf("Expected the seeded value, got %v, %v", val, err)
+	}
+}
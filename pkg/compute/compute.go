@@ -0,0 +1,256 @@
+// Package compute runs compute functions: small WebAssembly modules executed
+// server-side against an app's data, so that maps, filters and aggregates
+// don't need the data shipped to clients first.
+//
+// A function is a WebAssembly module exporting its memory and two functions:
+//
+//	alloc(size i32) i32          returns a buffer of size bytes for the input
+//	compute(ptr i32, len i32) i64
+//
+// compute reads its input, the JSON object
+//
+//	{"persona": "...", "app": "...", "data": {...}, "args": ...}
+//
+// where data holds the app's keys and values (or, for persona "*", the
+// values of every persona, as DUMP_APP returns them), and returns where its
+// output is, as ptr<<32 | len. The output is the JSON object
+// {"result": ...}, or {"error": "..."} to fail.
+//
+// Modules may import WASI preview 1, but see no files, environment or real
+// clock, and what they print is discarded. An optional _initialize export
+// runs first, which is what Go's wasip1 c-shared modules and TinyGo's
+// reactors need. Every call gets a fresh instance, so nothing carries over
+// between calls.
+package compute
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Defaults for Options.
+const (
+	DefaultTimeout     = 5 * time.Second
+	DefaultMemoryLimit = 64 << 20
+)
+
+// AllPersonas, given as the persona, runs a function over the app's values
+// in every persona.
+const AllPersonas = "*"
+
+// maxCompiled is how many compiled modules a Runner keeps. The least recently
+// used are closed to make room, so replaced or deleted functions don't stay
+// compiled for the life of the Runner.
+const maxCompiled = 32
+
+// Options limit what functions may use.
+type Options struct {
+	// Timeout bounds each call, including instantiation but not compiling,
+	// which happens once per module. Defaults to DefaultTimeout.
+	Timeout time.Duration
+	// MemoryLimit bounds the memory of each instance, in bytes, rounded down
+	// to 64 KiB pages. Defaults to DefaultMemoryLimit.
+	MemoryLimit int
+	// MaxConcurrent bounds the calls running at once; the others wait.
+	// Defaults to the number of CPUs.
+	MaxConcurrent int
+}
+
+// Runner compiles and runs functions. It is safe for concurrent use.
+type Runner struct {
+	runtime wazero.Runtime
+	timeout time.Duration
+	slots   chan struct{}
+
+	mu       sync.Mutex
+	compiled map[[sha256.Size]byte]*list.Element
+	recent   *list.List // Of *compiledModule, most recently used first
+}
+
+// compiledModule is a module in a Runner's cache.
+type compiledModule struct {
+	sum    [sha256.Size]byte
+	module wazero.CompiledModule
+}
+
+// NewRunner returns a Runner applying opts. Close releases it.
+func NewRunner(opts Options) (*Runner, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.MemoryLimit <= 0 {
+		opts.MemoryLimit = DefaultMemoryLimit
+	}
+	if opts.MaxConcurrent <= 0 {
+		opts.MaxConcurrent = runtime.NumCPU()
+	}
+	pages := opts.MemoryLimit >> 16
+	if pages < 1 || pages > 65536 {
+		return nil, fmt.Errorf("memory limit must be between 64 KiB and 4 GiB, got %d bytes", opts.MemoryLimit)
+	}
+
+	ctx := context.Background()
+	rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(pages)).
+		WithCloseOnContextDone(true))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, rt); err != nil {
+		rt.Close(ctx)
+		return nil, err
+	}
+	return &Runner{
+		runtime:  rt,
+		timeout:  opts.Timeout,
+		slots:    make(chan struct{}, opts.MaxConcurrent),
+		compiled: make(map[[sha256.Size]byte]*list.Element),
+		recent:   list.New(),
+	}, nil
+}
+
+// Close releases the compiled modules. Calls still running are stopped.
+func (r *Runner) Close() error {
+	return r.runtime.Close(context.Background())
+}
+
+// Compute runs the function stored as name in the functions of store (see
+// sdk.FunctionStore) over the app of personaID, or of every persona for
+// AllPersonas, passing it args, and returns its result as JSON on one line.
+// Failing functions, traps, timeouts and functions not following the calling
+// convention return errors wrapping sdk.ErrBadRequest.
+func (r *Runner) Compute(ctx context.Context, store sdk.CelerixStore, name, personaID, appID string, args any) (json.RawMessage, error) {
+	fn, err := sdk.NewFunctionStore(store).Get(name)
+	if err != nil {
+		if sdk.IsNotFound(err) {
+			return nil, fmt.Errorf("no function %s: %w", name, err)
+		}
+		return nil, err
+	}
+	var data any
+	if personaID == AllPersonas {
+		data, err = store.DumpApp(appID)
+	} else {
+		data, err = store.GetAppStore(personaID, appID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	input, err := json.Marshal(map[string]any{"persona": personaID, "app": appID, "data": data, "args": args})
+	if err != nil {
+		return nil, err
+	}
+	return r.Run(ctx, fn.WASM, input)
+}
+
+// Run calls the function in wasm with input, which should be the JSON object
+// described in the package documentation, and returns its result.
+func (r *Runner) Run(ctx context.Context, wasm, input []byte) (json.RawMessage, error) {
+	select {
+	case r.slots <- struct{}{}:
+		defer func() { <-r.slots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	// Compiling is done once per module, so it doesn't count.
+	compiled, err := r.compile(ctx, wasm)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	output, err := r.call(ctx, compiled, input)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("function ran longer than %s: %w", r.timeout, sdk.ErrBadRequest)
+		}
+		return nil, err
+	}
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+		Error  string          `json:"error"`
+	}
+	if err := json.Unmarshal(output, &envelope); err != nil {
+		return nil, fmt.Errorf("function output is not a JSON object: %w", sdk.ErrBadRequest)
+	}
+	if envelope.Error != "" {
+		return nil, fmt.Errorf("function failed: %s: %w", envelope.Error, sdk.ErrBadRequest)
+	}
+	if envelope.Result == nil {
+		return json.RawMessage("null"), nil
+	}
+	var result bytes.Buffer
+	if err := json.Compact(&result, envelope.Result); err != nil {
+		return nil, err
+	}
+	return result.Bytes(), nil
+}
+
+// call instantiates compiled and calls its compute export with input.
+func (r *Runner) call(ctx context.Context, compiled wazero.CompiledModule, input []byte) ([]byte, error) {
+	mod, err := r.runtime.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize"))
+	if err != nil {
+		return nil, badFunction("instantiating", err)
+	}
+	defer mod.Close(context.Background())
+
+	alloc, compute, memory := mod.ExportedFunction("alloc"), mod.ExportedFunction("compute"), mod.Memory()
+	if alloc == nil || compute == nil || memory == nil {
+		return nil, fmt.Errorf("function must export memory, alloc and compute: %w", sdk.ErrBadRequest)
+	}
+	res, err := alloc.Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, badFunction("alloc", err)
+	}
+	ptr := uint32(res[0])
+	if !memory.Write(ptr, input) {
+		return nil, fmt.Errorf("alloc returned a buffer out of memory: %w", sdk.ErrBadRequest)
+	}
+	if res, err = compute.Call(ctx, uint64(ptr), uint64(len(input))); err != nil {
+		return nil, badFunction("compute", err)
+	}
+	output, ok := memory.Read(uint32(res[0]>>32), uint32(res[0]))
+	if !ok {
+		return nil, fmt.Errorf("compute returned output out of memory: %w", sdk.ErrBadRequest)
+	}
+	return bytes.Clone(output), nil
+}
+
+// compile returns wasm compiled, from the cache if it was compiled before.
+func (r *Runner) compile(ctx context.Context, wasm []byte) (wazero.CompiledModule, error) {
+	sum := sha256.Sum256(wasm)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.compiled[sum]; ok {
+		r.recent.MoveToFront(e)
+		return e.Value.(*compiledModule).module, nil
+	}
+	compiled, err := r.runtime.CompileModule(ctx, wasm)
+	if err != nil {
+		return nil, badFunction("compiling", err)
+	}
+	for r.recent.Len() >= maxCompiled {
+		// Closing is safe while calls are still running them.
+		old := r.recent.Remove(r.recent.Back()).(*compiledModule)
+		old.module.Close(context.Background())
+		delete(r.compiled, old.sum)
+	}
+	r.compiled[sum] = r.recent.PushFront(&compiledModule{sum, compiled})
+	return compiled, nil
+}
+
+func badFunction(stage string, err error) error {
+	return fmt.Errorf("%s function: %v: %w", stage, err, sdk.ErrBadRequest)
+}
@@ -0,0 +1,141 @@
+package compute_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/compute"
+	"github.com/celerix-dev/celerix-store/pkg/engine"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// buildCount compiles the function in testdata/count.
+func buildCount(t *testing.T) []byte {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("building a WebAssembly module is slow")
+	}
+	out := filepath.Join(t.TempDir(), "count.wasm")
+	cmd := exec.Command("go", "build", "-buildmode=c-shared", "-o", out, "./testdata/count")
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	if msg, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("building testdata/count: %v\n%s", err, msg)
+	}
+	wasm, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wasm
+}
+
+func TestRunner_Compute(t *testing.T) {
+	wasm := buildCount(t)
+	store := engine.NewMemStore(nil, nil)
+	store.Set("p1", "tasks", "t1", map[string]any{"title": "a", "done": true})
+	store.Set("p1", "tasks", "t2", map[string]any{"title": "b"})
+	store.Set("p2", "tasks", "t3", map[string]any{"title": "c", "done": false})
+
+	functions := sdk.NewFunctionStore(store)
+	if _, err := functions.Put("count", []byte("not wasm")); !errors.Is(err, sdk.ErrBadRequest) {
+		t.Fatalf("Put(not wasm) = %v, want ErrBadRequest", err)
+	}
+	rec, err := functions.Put("count", wasm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Size != len(wasm) || rec.WASM != nil || len(rec.SHA256) != 64 {
+		t.Errorf("Put returned %+v", rec)
+	}
+	if list, err := functions.List(); err != nil || len(list) != 1 || list[0].Name != "count" || list[0].WASM != nil {
+		t.Errorf("List() = %+v, %v", list, err)
+	}
+
+	runner, err := compute.NewRunner(compute.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Close()
+	ctx := context.Background()
+
+	tests := []struct {
+		persona string
+		args    map[string]any
+		want    string
+	}{
+		{"p1", map[string]any{"field": "done"}, `{"count":1,"of":2}`},
+		{"p1", map[string]any{"field": "title"}, `{"count":2,"of":2}`},
+		{compute.AllPersonas, map[string]any{"field": "done"}, `{"count":2,"of":3}`},
+	}
+	for _, tt := range tests {
+		got, err := runner.Compute(ctx, store, "count", tt.persona, "tasks", tt.args)
+		if err != nil {
+			t.Fatalf("Compute(%s, %v): %v", tt.persona, tt.args, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("Compute(%s, %v) = %s, want %s", tt.persona, tt.args, got, tt.want)
+		}
+	}
+
+	_, err = runner.Compute(ctx, store, "count", "p1", "tasks", map[string]any{"fail": true})
+	if !errors.Is(err, sdk.ErrBadRequest) || !strings.Contains(err.Error(), "asked to fail") {
+		t.Errorf("failing function: %v", err)
+	}
+	_, err = runner.Compute(ctx, store, "count", "p1", "tasks", map[string]any{"spin": true})
+	if !errors.Is(err, sdk.ErrBadRequest) || !strings.Contains(err.Error(), "longer than") {
+		t.Errorf("spinning function: %v", err)
+	}
+	if _, err := runner.Compute(ctx, store, "missing", "p1", "tasks", nil); !sdk.IsNotFound(err) {
+		t.Errorf("unknown function: %v", err)
+	}
+	if _, err := runner.Compute(ctx, store, "count", "nobody", "tasks", nil); !sdk.IsNotFound(err) {
+		t.Errorf("unknown persona: %v", err)
+	}
+
+	if err := functions.Delete("count"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := functions.Get("count"); !sdk.IsNotFound(err) {
+		t.Errorf("Get after Delete: %v", err)
+	}
+}
+
+// emptyModule returns a valid module exporting nothing, made unique by a
+// custom section named name.
+func emptyModule(name string) []byte {
+	wasm := []byte("\x00asm\x01\x00\x00\x00")
+	wasm = append(wasm, 0, byte(2+len(name)), byte(len(name)))
+	return append(append(wasm, name...), 0) // wazero wants a payload
+}
+
+func TestRunner_CompiledCache(t *testing.T) {
+	runner, err := compute.NewRunner(compute.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Close()
+	ctx := context.Background()
+
+	first := emptyModule("first")
+	if _, err := runner.Run(ctx, first, nil); !errors.Is(err, sdk.ErrBadRequest) || !strings.Contains(err.Error(), "must export") {
+		t.Fatalf("Expected a module without exports to be refused, got %v", err)
+	}
+	for i := 1; i < compute.MaxCompiled; i++ {
+		runner.Run(ctx, emptyModule(fmt.Sprintf("v%d", i)), nil)
+	}
+	runner.Run(ctx, first, nil) // Used again, so v1 is the least recent
+	runner.Run(ctx, emptyModule("last"), nil)
+
+	if n := runner.CompiledCount(); n != compute.MaxCompiled {
+		t.Errorf("Expected %d compiled modules, got %d", compute.MaxCompiled, n)
+	}
+	if !runner.Compiled(first) || runner.Compiled(emptyModule("v1")) {
+		t.Error("Expected the least recently used module to be closed")
+	}
+}
@@ -0,0 +1,21 @@
+package compute
+
+import "crypto/sha256"
+
+// MaxCompiled is maxCompiled, for tests.
+const MaxCompiled = maxCompiled
+
+// Compiled reports whether wasm is in r's cache of compiled modules.
+func (r *Runner) Compiled(wasm []byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.compiled[sha256.Sum256(wasm)]
+	return ok
+}
+
+// CompiledCount returns how many compiled modules r keeps.
+func (r *Runner) CompiledCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.compiled)
+}
@@ -0,0 +1,78 @@
+// Command count is a compute function for the tests: it counts the keys of
+// an app, or of every persona's app, whose values have the field given in the
+// arguments, and fails on purpose when asked to.
+package main
+
+import (
+	"encoding/json"
+	"unsafe"
+)
+
+type input struct {
+	Persona string          `json:"persona"`
+	App     string          `json:"app"`
+	Data    json.RawMessage `json:"data"`
+	Args    struct {
+		Field string `json:"field"`
+		Fail  bool   `json:"fail"`
+		Spin  bool   `json:"spin"`
+	} `json:"args"`
+}
+
+// buffers keeps what alloc hands out from being collected.
+var buffers = map[uintptr][]byte{}
+
+//go:wasmexport alloc
+func alloc(size int32) int32 {
+	buf := make([]byte, size)
+	ptr := uintptr(unsafe.Pointer(unsafe.SliceData(buf)))
+	buffers[ptr] = buf
+	return int32(ptr)
+}
+
+//go:wasmexport compute
+func compute(ptr, size int32) int64 {
+	in := buffers[uintptr(ptr)][:size]
+	delete(buffers, uintptr(ptr))
+	var req input
+	if err := json.Unmarshal(in, &req); err != nil {
+		return reply(map[string]any{"error": err.Error()})
+	}
+	if req.Args.Fail {
+		return reply(map[string]any{"error": "asked to fail"})
+	}
+	for req.Args.Spin {
+	}
+	var values []map[string]any
+	if req.Persona == "*" {
+		var apps map[string]map[string]map[string]any
+		json.Unmarshal(req.Data, &apps)
+		for _, app := range apps {
+			for _, v := range app {
+				values = append(values, v)
+			}
+		}
+	} else {
+		var app map[string]map[string]any
+		json.Unmarshal(req.Data, &app)
+		for _, v := range app {
+			values = append(values, v)
+		}
+	}
+	n := 0
+	for _, v := range values {
+		if _, ok := v[req.Args.Field]; ok {
+			n++
+		}
+	}
+	return reply(map[string]any{"result": map[string]any{"count": n, "of": len(values)}})
+}
+
+func reply(v any) int64 {
+	out, _ := json.Marshal(v)
+	ptr := uintptr(unsafe.Pointer(unsafe.SliceData(out)))
+	buffers[ptr] = out
+	return int64(ptr)<<32 | int64(len(out))
+}
+
+func main() {}
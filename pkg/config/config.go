@@ -0,0 +1,188 @@
+// Package config serves an app scope of a celerix store as service
+// configuration, as environment variables or through a Provider that config
+// libraries can load from:
+//
+//	p := config.New(client, "my-service", "config")
+//	p.Setenv("MYSVC_")                           // 12-factor style
+//	k.Load(p, nil)                               // koanf
+//	p.Watch(func(any, error) { k.Load(p, nil) }) // live reload
+//
+//	values, _ := p.Read()
+//	v.MergeConfigMap(values) // viper
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// resubscribeDelay is how long Watch waits before resubscribing after the
+// store dropped its subscription.
+const resubscribeDelay = time.Second
+
+// Store is the subset of sdk.CelerixStore a Provider needs. Watch also needs
+// an sdk.Watcher, such as the engine or the daemon client.
+type Store interface {
+	GetAppStore(personaID, appID string) (map[string]any, error)
+}
+
+// Provider reads the configuration of one persona and app. It satisfies
+// koanf's Provider interface (Read, ReadBytes and Watch) without depending on
+// koanf.
+type Provider struct {
+	store     Store
+	personaID string
+	appID     string
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// New serves the values of personaID/appID as configuration.
+func New(store Store, personaID, appID string) *Provider {
+	return &Provider{store: store, personaID: personaID, appID: appID}
+}
+
+// Read returns the current values by key. A missing app is empty
+// configuration, not an error.
+func (p *Provider) Read() (map[string]any, error) {
+	data, err := p.store.GetAppStore(p.personaID, p.appID)
+	if sdk.IsNotFound(err) {
+		return map[string]any{}, nil
+	}
+	return data, err
+}
+
+// ReadBytes returns the current values as a JSON object.
+func (p *Provider) ReadBytes() ([]byte, error) {
+	data, err := p.Read()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(data)
+}
+
+// Environ returns the current values as sorted NAME=value pairs, named by
+// EnvName. Strings are used as they are, other values as JSON.
+func (p *Provider) Environ(prefix string) ([]string, error) {
+	data, err := p.Read()
+	if err != nil {
+		return nil, err
+	}
+	env := make([]string, 0, len(data))
+	for key, val := range data {
+		text, err := envValue(val)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		env = append(env, EnvName(prefix, key)+"="+text)
+	}
+	sort.Strings(env)
+	return env, nil
+}
+
+// Setenv sets the current values as environment variables of this process.
+// Variables of keys deleted since are left alone.
+func (p *Provider) Setenv(prefix string) error {
+	env, err := p.Environ(prefix)
+	if err != nil {
+		return err
+	}
+	for _, kv := range env {
+		name, val, _ := strings.Cut(kv, "=")
+		if err := os.Setenv(name, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Watch calls cb with the sdk.ChangeEvent of every change to the app until
+// Close, typically to reload. If the store drops the subscription, Watch
+// resubscribes and calls cb with a nil event, since changes may have been
+// missed; failures to resubscribe are passed as err. Watching again replaces
+// the previous watch.
+func (p *Provider) Watch(cb func(event any, err error)) error {
+	watcher, ok := p.store.(sdk.Watcher)
+	if !ok {
+		return fmt.Errorf("watching config: %w", sdk.ErrNotSupported)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := watcher.Watch(ctx, p.personaID, p.appID, "")
+	if err != nil {
+		cancel()
+		return err
+	}
+	p.mu.Lock()
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.cancel = cancel
+	p.mu.Unlock()
+
+	go func() {
+		for {
+			for e := range events {
+				cb(e, nil)
+			}
+			// Closed, or dropped by the store: resubscribe, then report a
+			// reload since changes may have been missed.
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(resubscribeDelay):
+				}
+				var err error
+				if events, err = watcher.Watch(ctx, p.personaID, p.appID, ""); err == nil {
+					break
+				}
+				cb(nil, err)
+			}
+			cb(nil, nil)
+		}
+	}()
+	return nil
+}
+
+// Close stops watching.
+func (p *Provider) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cancel != nil {
+		p.cancel()
+		p.cancel = nil
+	}
+	return nil
+}
+
+// EnvName turns a key into an environment variable name: the prefix followed
+// by the upper-cased key, with anything but letters and digits replaced by
+// underscores. With prefix "MYSVC_", "db.host" becomes MYSVC_DB_HOST.
+func EnvName(prefix, key string) string {
+	return prefix + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+func envValue(val any) (string, error) {
+	if s, ok := val.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(val)
+	return string(data), err
+}
@@ -0,0 +1,54 @@
+package config_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/config"
+	"github.com/celerix-dev/celerix-store/pkg/engine"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+func TestProvider(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	p := config.New(store, "svc", "config")
+	defer p.Close()
+
+	if data, err := p.Read(); err != nil || len(data) != 0 {
+		t.Fatalf("Expected a missing app to be empty config, got %v, %v", data, err)
+	}
+
+	store.Set("svc", "config", "db.host", "db.local")
+	store.Set("svc", "config", "port", 5432)
+	env, err := p.Environ("SVC_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"SVC_DB_HOST=db.local", "SVC_PORT=5432"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("Expected %v, got %v", want, env)
+	}
+	if err := p.Setenv("SVC_"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("SVC_DB_HOST")
+	defer os.Unsetenv("SVC_PORT")
+	if got := os.Getenv("SVC_DB_HOST"); got != "db.local" {
+		t.Errorf("Expected SVC_DB_HOST=db.local, got %q", got)
+	}
+
+	changes := make(chan any, 1)
+	if err := p.Watch(func(event any, err error) { changes <- event }); err != nil {
+		t.Fatal(err)
+	}
+	store.Set("svc", "config", "port", 6543)
+	select {
+	case e := <-changes:
+		if e.(sdk.ChangeEvent).Key != "port" {
+			t.Errorf("Unexpected change %+v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected Watch to report the change")
+	}
+}
@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// blobDir holds the blobs of an app: <data-dir>/<persona>/<app>.blobs/.
+// Load skips directories inside a persona, so blobs are never read into memory.
+func (p *Persistence) blobDir(personaID, appID string) string {
+	return filepath.Join(p.personaDir(personaID), appID+".blobs")
+}
+
+// blobPath names a blob file after its key in unpadded base64url, which can't
+// contain path separators or be "." or "..".
+func (p *Persistence) blobPath(personaID, appID, key string) string {
+	return filepath.Join(p.blobDir(personaID, appID), base64.RawURLEncoding.EncodeToString([]byte(key)))
+}
+
+// WriteBlob streams r into a temporary file and renames it over the blob once
+// r is exhausted, so readers see either the old blob or the new one.
+func (p *Persistence) WriteBlob(personaID, appID, key string, r io.Reader) (int64, error) {
+	if p.IsQuarantined(personaID) {
+		return 0, ErrPersonaQuarantined
+	}
+	dir := p.blobDir(personaID, appID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, p.scrub(err)
+	}
+
+	// The copy happens outside p.mu: a slow upload must not hold up other saves.
+	f, err := os.CreateTemp(dir, ".upload-*.tmp")
+	if err != nil {
+		return 0, p.scrub(err)
+	}
+	tempPath := f.Name()
+	n, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		os.Remove(tempPath)
+		return n, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	path := p.blobPath(personaID, appID, key)
+	err = p.syncWrite(f, path)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return n, p.scrub(err)
+	}
+	return n, p.syncDirAfterRename(dir)
+}
+
+// OpenBlob opens a blob for reading and reports its size.
+func (p *Persistence) OpenBlob(personaID, appID, key string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(p.blobPath(personaID, appID, key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, 0, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, 0, p.scrub(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, p.scrub(err)
+	}
+	return f, info.Size(), nil
+}
+
+// DeleteBlob removes a blob. Deleting a missing blob is not an error.
+func (p *Persistence) DeleteBlob(personaID, appID, key string) error {
+	if p.IsQuarantined(personaID) {
+		return ErrPersonaQuarantined
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err := os.Remove(p.blobPath(personaID, appID, key))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return p.scrub(err)
+	}
+	return nil
+}
+
+// blobs returns the backend's blob support, or an error if it has none or the
+// IDs can't be used as file names.
+func (m *MemStore) blobs(personaID, appID, key string) (BlobBackend, error) {
+	if err := checkIDs(personaID, appID, key); err != nil {
+		return nil, err
+	}
+	b, ok := m.persister.(BlobBackend)
+	if !ok {
+		return nil, fmt.Errorf("blobs need a storage backend that stores them: %w", sdk.ErrNotSupported)
+	}
+	return b, nil
+}
+
+// SetBlob stores everything read from r as a blob. Blobs go straight to the
+// storage backend and are never held in memory.
+func (m *MemStore) SetBlob(personaID, appID, key string, r io.Reader) (int64, error) {
+	b, err := m.blobs(personaID, appID, key)
+	if err != nil {
+		return 0, err
+	}
+	if err := m.writable(personaID); err != nil {
+		return 0, err
+	}
+	return b.WriteBlob(personaID, appID, key, r)
+}
+
+// GetBlob opens a blob for reading and reports its size. The caller must close it.
+func (m *MemStore) GetBlob(personaID, appID, key string) (io.ReadCloser, int64, error) {
+	b, err := m.blobs(personaID, appID, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return b.OpenBlob(personaID, appID, key)
+}
+
+// DeleteBlob removes a blob.
+func (m *MemStore) DeleteBlob(personaID, appID, key string) error {
+	b, err := m.blobs(personaID, appID, key)
+	if err != nil {
+		return err
+	}
+	if err := m.writable(personaID); err != nil {
+		return err
+	}
+	return b.DeleteBlob(personaID, appID, key)
+}
@@ -0,0 +1,42 @@
+package engine
+
+import "sort"
+
+// Compact rewrites the stored state of every persona held in memory in one
+// save per persona, which drops the files of apps that no longer exist and
+// rewrites files left in an older format. Personas evicted from memory or
+// quarantined are left alone. It returns the number of personas rewritten
+// and the first failed save.
+func (m *MemStore) Compact() (int, error) {
+	if m.persister == nil {
+		return 0, nil
+	}
+	m.mu.RLock()
+	personas := make([]string, 0, len(m.data))
+	for personaID := range m.data {
+		personas = append(personas, personaID)
+	}
+	m.mu.RUnlock()
+	sort.Strings(personas)
+
+	var firstErr error
+	compacted := 0
+	for _, personaID := range personas {
+		// One persona at a time, so writes elsewhere aren't held up meanwhile.
+		m.mu.Lock()
+		if _, ok := m.data[personaID]; !ok || m.quarantined(personaID) {
+			m.mu.Unlock()
+			continue
+		}
+		wait := m.persistAsync(personaID, m.copyPersonaData(personaID))
+		m.mu.Unlock()
+		if err := wait.wait(); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		compacted++
+	}
+	return compacted, firstErr
+}
@@ -0,0 +1,102 @@
+package engine
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// SetDeepCopy makes the store copy values on their way in and out: what Set,
+// SetBatch and Merge store, and what reads, after-write hooks and watchers
+// hand back. Without it an embedding caller that mutates a map after storing
+// it, or a value it read, changes the stored value behind the store's back
+// (and races with background saves). Values crossing the daemon's protocol
+// are decoded afresh anyway, so only embedded use needs it.
+func (m *MemStore) SetDeepCopy(enabled bool) {
+	m.deepCopy = enabled
+}
+
+// copyValue returns val, or a deep copy of it if SetDeepCopy is on.
+func (m *MemStore) copyValue(val any) any {
+	if !m.deepCopy {
+		return val
+	}
+	return deepCopy(val)
+}
+
+// deepCopy returns a copy of v sharing no maps, slices or pointers with it.
+// The generic types JSON decodes into are copied directly; anything else,
+// such as structs and typed maps stored in embedded mode, is copied by
+// reflection, keeping its type. Unexported struct fields are copied as they
+// are.
+func deepCopy(v any) any {
+	switch v := v.(type) {
+	case nil, string, bool, float64, json.Number:
+		return v
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			out[k] = deepCopy(item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = deepCopy(item)
+		}
+		return out
+	}
+	return copyReflect(reflect.ValueOf(v)).Interface()
+}
+
+func copyReflect(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Elem().Type())
+		out.Elem().Set(copyReflect(v.Elem()))
+		return out
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type()).Elem()
+		out.Set(copyReflect(v.Elem()))
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			out.SetMapIndex(iter.Key(), copyReflect(iter.Value()))
+		}
+		return out
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(copyReflect(v.Index(i)))
+		}
+		return out
+	case reflect.Array:
+		out := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(copyReflect(v.Index(i)))
+		}
+		return out
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		out.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if out.Field(i).CanSet() {
+				out.Field(i).Set(copyReflect(v.Field(i)))
+			}
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,76 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LockFile is the file in the data directory that an open store holds an
+// exclusive lock on, so two processes never write the same files.
+const LockFile = ".lock"
+
+// ErrDataDirLocked is returned by Open (and Persistence.Lock) when another
+// process already has the data directory open.
+var ErrDataDirLocked = errors.New("data directory is in use by another process")
+
+// Lock takes an exclusive lock on the data directory, failing with
+// ErrDataDirLocked if another process holds it. Open calls it; Close releases
+// it. The lock goes away with the process, so a crash never leaves it behind.
+func (p *Persistence) Lock() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lock != nil {
+		return nil
+	}
+
+	f, err := os.OpenFile(filepath.Join(p.DataDir, LockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if !errors.Is(err, errWouldBlock) {
+			return err
+		}
+		if pid := readLockPID(filepath.Join(p.DataDir, LockFile)); pid != "" {
+			return fmt.Errorf("%s: %w (pid %s)", p.DataDir, ErrDataDirLocked, pid)
+		}
+		return fmt.Errorf("%s: %w", p.DataDir, ErrDataDirLocked)
+	}
+
+	// The PID is only informational, for the error above.
+	f.Truncate(0)
+	f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	p.lock = f
+	return nil
+}
+
+// unlockLocked releases the data directory lock, if held.
+// It MUST be called while holding p.mu.
+func (p *Persistence) unlockLocked() {
+	if p.lock == nil {
+		return
+	}
+	unlockFile(p.lock)
+	p.lock.Close()
+	p.lock = nil
+}
+
+// readLockPID returns the PID the holder of the lock wrote to it, if it can be read.
+func readLockPID(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	b, err := io.ReadAll(io.LimitReader(f, 32))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package engine
+
+import (
+	"errors"
+	"os"
+)
+
+var errWouldBlock = errors.New("would block")
+
+// lockFile does nothing where file locks aren't available.
+func lockFile(f *os.File) error   { return nil }
+func unlockFile(f *os.File) error { return nil }
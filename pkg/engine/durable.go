@@ -0,0 +1,59 @@
+package engine
+
+import "sync"
+
+// Flusher is implemented by storage backends that can flush what they have
+// written to stable storage on demand, whatever their fsync policy. SetSync
+// uses it to confirm durability.
+type Flusher interface {
+	Flush() error
+}
+
+// SetSync is Set, returning only once the value has been saved by the
+// backend and, if it is a Flusher, flushed to disk. A failed save is
+// reported, although the value stays in memory like with any other write.
+// A store without a backend has nothing to wait for.
+func (m *MemStore) SetSync(personaID, appID, key string, val any) error {
+	wait, err := m.set(personaID, appID, key, val, nil)
+	if err != nil {
+		return err
+	}
+	if err := wait.wait(); err != nil {
+		return err
+	}
+	if f, ok := m.persister.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// saveWait collects the outcome of the background saves started by a write.
+// A nil saveWait stands for no saves.
+type saveWait struct {
+	wg  sync.WaitGroup
+	mu  sync.Mutex
+	err error
+}
+
+func (w *saveWait) record(err error) {
+	if err == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+// wait blocks until the saves have landed (or were skipped for newer ones)
+// and returns the first error.
+func (w *saveWait) wait() error {
+	if w == nil {
+		return nil
+	}
+	w.wg.Wait()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
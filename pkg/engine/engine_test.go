@@ -1,11 +1,22 @@
 package engine
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
 )
 
 func TestMemStore_GetSetDelete(t *testing.T) {
@@ -90,8 +101,8 @@ func TestPersistence(t *testing.T) {
 	}
 
 	// Verify file exists
-	if _, err := os.Stat(filepath.Join(tmpDir, "user1.json")); os.IsNotExist(err) {
-		t.Fatal("Persona file was not created")
+	if _, err := os.Stat(filepath.Join(tmpDir, "user1", "app1.json")); os.IsNotExist(err) {
+		t.Fatal("App file was not created")
 	}
 
 	allData, err := p.LoadAll()
@@ -154,25 +165,7 @@ func TestMemStore_AppScopeAndVault(t *testing.T) {
 	}
 
 	// Test Vault
-	v := scope.Vault(masterKey)
-	// We need to type assert since ms.App returns sdk.AppScope which returns any for Vault
-	// But in memstore.go:280 it returns *memVaultScope.
-	// Actually sdk.AppScope defines Vault(masterKey []byte) any
-
-	// Let's use it as it is supposed to be used.
-	// Since we are in the same package, we can type assert to *memVaultScope if we want,
-	// but the interface return type makes it a bit tricky if we want to use the methods.
-
-	// Let's check memstore.go again.
-	// func (v *memVaultScope) Set(key string, plaintext string) error
-	// func (v *memVaultScope) Get(key string) (string, error)
-
-	type vaulter interface {
-		Set(key string, plaintext string) error
-		Get(key string) (string, error)
-	}
-
-	vv := v.(vaulter)
+	vv := scope.Vault(masterKey)
 	err = vv.Set("password", "topsecret")
 	if err != nil {
 		t.Fatalf("Vault Set failed: %v", err)
@@ -191,6 +184,18 @@ func TestMemStore_AppScopeAndVault(t *testing.T) {
 	if raw == "topsecret" {
 		t.Error("Vault value should be encrypted in store")
 	}
+
+	type creds struct {
+		User  string `json:"user"`
+		Token string `json:"token"`
+	}
+	if err := vv.SetJSON("creds", creds{User: "alice", Token: "t0k"}); err != nil {
+		t.Fatalf("Vault SetJSON failed: %v", err)
+	}
+	var got creds
+	if err := vv.GetJSON("creds", &got); err != nil || got.Token != "t0k" {
+		t.Errorf("Vault GetJSON failed: %+v, %v", got, err)
+	}
 }
 
 func TestMemStore_Concurrent(t *testing.T) {
@@ -276,3 +281,1892 @@ func TestMemStore_Move(t *testing.T) {
 		t.Errorf("Move failed to delete src: %v", err)
 	}
 }
+
+func TestMemStore_MoveKey(t *testing.T) {
+	ms := NewMemStore(nil, nil)
+	ms.Set("p1", "drafts", "post", "hello")
+	ms.Set("p1", "drafts", "count", "many")
+	if err := sdk.SetSchema(ms, "counters", map[string]any{"type": "integer"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ms.MoveKey("p1", "drafts", "p1", "drafts", "post", "post-1"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if err := ms.MoveKey("p1", "drafts", "p2", "published", "post-1", "post-1"); err != nil {
+		t.Fatalf("Move across apps failed: %v", err)
+	}
+	if val, err := ms.Get("p2", "published", "post-1"); err != nil || val != "hello" {
+		t.Errorf("Expected the post at its destination, got %v, %v", val, err)
+	}
+	if _, err := ms.Get("p1", "drafts", "post"); err != ErrKeyNotFound {
+		t.Errorf("Expected the source to be gone, got %v", err)
+	}
+
+	if err := ms.MoveKey("p1", "drafts", "p1", "counters", "count", "count"); !errors.Is(err, sdk.ErrBadRequest) {
+		t.Errorf("Expected the destination schema to reject the value, got %v", err)
+	}
+	if val, err := ms.Get("p1", "drafts", "count"); err != nil || val != "many" {
+		t.Errorf("Expected a rejected move to leave the source alone, got %v, %v", val, err)
+	}
+	if err := ms.MoveKey("p1", "drafts", "p1", "drafts", "count", "count"); err != nil {
+		t.Errorf("Expected moving a key onto itself to succeed, got %v", err)
+	}
+	if err := ms.MoveKey("p1", "drafts", "p1", "drafts", "missing", "other"); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound for a missing key, got %v", err)
+	}
+}
+
+func TestMemStore_MergePersona(t *testing.T) {
+	p, _ := NewPersistence(t.TempDir())
+	ms := NewMemStore(nil, p)
+	ms.Set("dup", "prefs", "theme", "dark")
+	ms.Set("dup", "prefs", "lang", "de")
+	ms.Set("dup", "notes", "n1", "hello")
+	ms.Set("main", "prefs", "theme", "light")
+	ms.Set("main", "prefs", "lang", "de")
+
+	err := ms.MergePersona("dup", "main", sdk.MergeFailOnConflict)
+	if !errors.Is(err, sdk.ErrConflict) || !strings.Contains(err.Error(), "prefs/theme") {
+		t.Fatalf("Expected a conflict on prefs/theme only, got %v", err)
+	}
+	if _, err := ms.Get("main", "notes", "n1"); err != ErrAppNotFound && err != ErrKeyNotFound {
+		t.Errorf("Expected a failed merge to change nothing, got %v", err)
+	}
+
+	if err := ms.MergePersona("dup", "main", sdk.MergePreferDst); err != nil {
+		t.Fatalf("MergePersona failed: %v", err)
+	}
+	if val, _ := ms.Get("main", "prefs", "theme"); val != "light" {
+		t.Errorf("Expected prefer-dst to keep light, got %v", val)
+	}
+	if val, _ := ms.Get("main", "notes", "n1"); val != "hello" {
+		t.Errorf("Expected the source's other apps to be merged, got %v", val)
+	}
+	if personas, _ := ms.GetPersonas(); len(personas) != 1 || personas[0] != "main" {
+		t.Errorf("Expected the source persona to be deleted, got %v", personas)
+	}
+
+	ms.Wait()
+	data, _ := p.LoadAll()
+	if data["dup"] != nil || data["main"]["notes"]["n1"] != "hello" {
+		t.Errorf("Expected the merge on disk, got %v", data)
+	}
+
+	ms.Set("dup", "prefs", "theme", "blue")
+	if err := ms.MergePersona("dup", "main", sdk.MergePreferSrc); err != nil {
+		t.Fatalf("MergePersona failed: %v", err)
+	}
+	if val, _ := ms.Get("main", "prefs", "theme"); val != "blue" {
+		t.Errorf("Expected prefer-src to take blue, got %v", val)
+	}
+	if err := ms.MergePersona("main", "main", sdk.MergePreferSrc); !errors.Is(err, sdk.ErrBadRequest) {
+		t.Errorf("Expected merging a persona into itself to fail, got %v", err)
+	}
+	ms.Wait() // Before the temp dir is removed
+}
+
+func TestMemStore_MergePersonaPipeline(t *testing.T) {
+	ms := NewMemStore(nil, nil)
+	ms.Set("dup", "settings", "count", "3")
+	ms.Set("dup", "settings", "size", " 4 ")
+	ms.Set("main", "settings", "count", 3)
+	if err := sdk.SetSchema(ms, "settings", map[string]any{"type": "integer"}); err != nil {
+		t.Fatal(err)
+	}
+	ms.AddTransformer(func(personaID, appID, key string, val any) (any, error) {
+		if s, ok := val.(string); ok {
+			return strings.TrimSpace(s), nil
+		}
+		return val, nil
+	})
+
+	// A value the schema refuses fails the merge, whatever the strategy
+	for _, strategy := range []sdk.MergeStrategy{sdk.MergeFailOnConflict, sdk.MergePreferSrc} {
+		err := ms.MergePersona("dup", "main", strategy)
+		if !errors.Is(err, sdk.ErrBadRequest) || !strings.Contains(err.Error(), "settings/") {
+			t.Errorf("Expected %s to fail on a settings value, got %v", strategy, err)
+		}
+	}
+	if _, err := ms.Get("main", "settings", "size"); err != ErrKeyNotFound {
+		t.Errorf("Expected a failed merge to change nothing, got %v", err)
+	}
+
+	// Unless prefer-src has the destination's value to keep instead
+	ms.Delete("dup", "settings", "size")
+	if err := ms.MergePersona("dup", "main", sdk.MergePreferSrc); err != nil {
+		t.Fatalf("MergePersona failed: %v", err)
+	}
+	if val, _ := ms.Get("main", "settings", "count"); val != 3 {
+		t.Errorf("Expected main's conforming value to be kept, got %v", val)
+	}
+
+	// Values are transformed as Set would write them
+	ms.Set("dup", "notes", "n1", "  hello ")
+	ms.Set("main", "notes", "n1", "hello")
+	if err := ms.MergePersona("dup", "main", sdk.MergeFailOnConflict); err != nil {
+		t.Fatalf("Expected the transformed values not to conflict, got %v", err)
+	}
+}
+
+func TestMemStore_PurgeWaitsOffLock(t *testing.T) {
+	backend := &gatedBackend{&memBackend{saved: make(map[string]map[string]map[string]any)}, "slow", make(chan struct{})}
+	ms := NewMemStore(nil, backend)
+	ms.Set("slow", "a1", "k1", "v1") // Its save is held
+	time.Sleep(20 * time.Millisecond)
+
+	purged := make(chan error)
+	go func() {
+		_, err := ms.PurgePersona("slow")
+		purged <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// Other personas aren't stalled while the purge waits for the save
+	done := make(chan struct{})
+	go func() {
+		ms.Set("other", "a1", "k1", "v1")
+		ms.Get("other", "a1", "k1")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected other personas to be usable during the purge")
+	}
+
+	close(backend.gate)
+	if err := <-purged; err != nil {
+		t.Fatalf("PurgePersona failed: %v", err)
+	}
+	ms.Wait()
+	if data, _ := backend.LoadAll(); data["slow"] != nil || data["other"] == nil {
+		t.Errorf("Expected only the purged persona gone from the backend, got %v", data)
+	}
+}
+
+func TestMemStore_PurgePersona(t *testing.T) {
+	dir := t.TempDir()
+	p, _ := NewPersistence(dir)
+	p.SavePersona("flipped", map[string]map[string]any{"a": {"k": "v"}})
+	os.WriteFile(filepath.Join(dir, "flipped", "a.json"), []byte(`{"a": {"k":`), 0644)
+	data, _ := p.LoadAll()
+	if !p.IsQuarantined("flipped") {
+		t.Fatal("Expected flipped to be quarantined")
+	}
+
+	ms := NewMemStore(data, p)
+	hasher := NewPersonaHasher(nil)
+	ms.SetPersonaHasher(hasher)
+	ms.Set("alice", "prefs", "theme", "dark")
+	ms.Set("alice", "notes", "n1", "hello")
+	ms.Set("bob", "prefs", "theme", "light")
+	ms.SetBlob("alice", "files", "avatar", strings.NewReader("png"))
+	ms.Lock("alice", "prefs", "edit", time.Minute)
+	hash := hasher.ID("alice")
+
+	report, err := ms.PurgePersona("alice")
+	if err != nil {
+		t.Fatalf("PurgePersona failed: %v", err)
+	}
+	if report.Apps != 2 || report.Keys != 2 || report.PurgedAt.IsZero() {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	if personas, _ := ms.GetPersonas(); len(personas) != 1 || personas[0] != "bob" {
+		t.Errorf("Expected only bob to be left, got %v", personas)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "alice")); !os.IsNotExist(err) {
+		t.Errorf("Expected alice's files and blobs to be gone, got %v", err)
+	}
+	if _, ok := hasher.Lookup(hash); ok {
+		t.Error("Expected the hasher to forget alice")
+	}
+	if _, err := ms.Lock("alice", "prefs", "edit", time.Minute); err != nil {
+		t.Errorf("Expected alice's leases to be gone, got %v", err)
+	}
+
+	// Quarantined personas can be purged, which lifts the quarantine
+	if _, err := ms.PurgePersona("flipped"); err != nil {
+		t.Fatalf("PurgePersona of a quarantined persona failed: %v", err)
+	}
+	if files, _ := os.ReadDir(filepath.Join(dir, QuarantineDir)); len(files) != 0 || p.IsQuarantined("flipped") {
+		t.Errorf("Expected the quarantine to be cleared, got %v", files)
+	}
+	if report, err := ms.PurgePersona("nobody"); err != nil || report.Keys != 0 {
+		t.Errorf("Expected purging an unknown persona to do nothing, got %+v, %v", report, err)
+	}
+	ms.Wait()
+}
+
+func TestMemStore_ExistsAndMGet(t *testing.T) {
+	ms := NewMemStore(nil, nil)
+	ms.Set("p1", "a1", "k1", "v1")
+	ms.Set("p1", "a1", "k2", nil)
+
+	for _, tc := range []struct {
+		personaID, appID, key string
+		want                  bool
+	}{
+		{"p1", "a1", "k1", true},
+		{"p1", "a1", "k2", true}, // A null value still exists
+		{"p1", "a1", "k3", false},
+		{"p1", "a2", "k1", false},
+		{"p2", "a1", "k1", false},
+	} {
+		if got, err := ms.Exists(tc.personaID, tc.appID, tc.key); err != nil || got != tc.want {
+			t.Errorf("Exists(%s, %s, %s) = %v, %v; want %v", tc.personaID, tc.appID, tc.key, got, err, tc.want)
+		}
+	}
+
+	values, err := ms.MGet("p1", "a1", []string{"k1", "k2", "k3"})
+	if err != nil || !reflect.DeepEqual(values, map[string]any{"k1": "v1", "k2": nil}) {
+		t.Errorf("Expected k1 and k2 only, got %v, %v", values, err)
+	}
+	if values, err := ms.MGet("p2", "a1", []string{"k1"}); err != nil || len(values) != 0 {
+		t.Errorf("Expected nothing for a missing persona, got %v, %v", values, err)
+	}
+}
+
+func TestMemStore_Prune(t *testing.T) {
+	ms := NewMemStore(nil, nil)
+	ms.Set("p1", "a1", "session:1", "x")
+	ms.Set("p1", "a1", "session:2", "y")
+	ms.Set("p1", "a1", "config", map[string]any{"n": 1.0})
+
+	if err := ms.DeleteIfEquals("p1", "a1", "config", map[string]any{"n": 2.0}); !errors.Is(err, sdk.ErrConflict) {
+		t.Errorf("Expected a conflict for a different value, got %v", err)
+	}
+	if err := ms.DeleteIfEquals("p1", "a1", "missing", "x"); !errors.Is(err, sdk.ErrConflict) {
+		t.Errorf("Expected a conflict for a missing key, got %v", err)
+	}
+	if err := ms.DeleteIfEquals("p1", "a1", "config", map[string]any{"n": 1.0}); err != nil {
+		t.Errorf("Expected an equal value to be deleted, got %v", err)
+	}
+
+	if n, err := ms.DeletePrefix("p1", "a1", "session:"); err != nil || n != 2 {
+		t.Errorf("Expected 2 keys deleted, got %d, %v", n, err)
+	}
+	if n, err := ms.DeletePrefix("p2", "a1", ""); err != nil || n != 0 {
+		t.Errorf("Expected nothing deleted from a missing persona, got %d, %v", n, err)
+	}
+	if data, _ := ms.GetAppStore("p1", "a1"); len(data) != 0 {
+		t.Errorf("Expected the app to be empty, got %v", data)
+	}
+}
+
+func TestMemStore_Stats(t *testing.T) {
+	ms := NewMemStore(nil, nil)
+	ms.Set("p1", "a1", "k1", "v1")
+	ms.Set("p1", "a1", "k2", "v2")
+	ms.Set("p2", "a2", "k1", "v3")
+
+	// Hold the lock so the next write has to wait for it.
+	ms.mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		ms.Set("p2", "a2", "k2", "v4")
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	ms.mu.Unlock()
+	<-done
+
+	stats, err := ms.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.Personas != 2 || stats.Apps != 2 || stats.Keys != 4 {
+		t.Errorf("Unexpected counts: %+v", stats)
+	}
+	if len(stats.TopContended) != 2 {
+		t.Fatalf("Expected 2 namespaces, got %d", len(stats.TopContended))
+	}
+	top := stats.TopContended[0]
+	if top.PersonaID != "p2" || top.AppID != "a2" {
+		t.Errorf("Expected p2/a2 to be most contended, got %s/%s", top.PersonaID, top.AppID)
+	}
+	if top.Writes != 2 || top.Contended != 1 || top.MaxWaitMs <= 0 {
+		t.Errorf("Unexpected contention record: %+v", top)
+	}
+}
+
+func TestMemStore_StatsConflicts(t *testing.T) {
+	ms := NewMemStore(nil, nil)
+	ms.Set("p1", "a1", "k1", "v1")
+	rev := sdk.Revision("v1")
+	if err := ms.SetIfRevision("p1", "a1", "k1", "v2", rev); err != nil {
+		t.Fatalf("SetIfRevision failed: %v", err)
+	}
+	if err := ms.SetIfRevision("p1", "a1", "k1", "v3", rev); !errors.Is(err, sdk.ErrConflict) {
+		t.Fatalf("Expected a conflict, got %v", err)
+	}
+	if err := ms.DeleteIfRevision("p1", "a1", "k1", rev); !errors.Is(err, sdk.ErrConflict) {
+		t.Fatalf("Expected a conflict, got %v", err)
+	}
+	if err := ms.DeleteIfRevision("p1", "a1", "k1", ""); !errors.Is(err, sdk.ErrConflict) {
+		t.Fatalf("Expected a conflict, got %v", err)
+	}
+
+	stats, _ := ms.Stats()
+	if len(stats.TopContended) != 1 {
+		t.Fatalf("Expected 1 namespace, got %+v", stats.TopContended)
+	}
+	if ns := stats.TopContended[0]; ns.Writes != 5 || ns.Conflicts != 3 {
+		t.Errorf("Expected 3 conflicts in 5 writes, got %+v", ns)
+	}
+
+	// The tracked namespaces are bounded, keeping the most contended
+	for i := 0; i < maxTrackedNamespaces; i++ {
+		ms.contention.record("p2", fmt.Sprintf("a%d", i), 0, false)
+	}
+	ms.contention.mu.Lock()
+	tracked, kept := len(ms.contention.byNS), ms.contention.byNS[nsKey{"p1", "a1"}] != nil
+	ms.contention.mu.Unlock()
+	if tracked > maxTrackedNamespaces || !kept {
+		t.Errorf("Expected at most %d namespaces keeping p1/a1, got %d (kept %v)", maxTrackedNamespaces, tracked, kept)
+	}
+}
+
+func TestMemStore_Watch(t *testing.T) {
+	ms := NewMemStore(nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	events, err := ms.Watch(ctx, "p1", "a1", "user.")
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	ms.Set("p1", "a1", "user.theme", "dark")
+	ms.Set("p1", "a1", "other", "ignored")
+	ms.Set("p2", "a1", "user.theme", "ignored")
+	ms.Delete("p1", "a1", "user.theme")
+	ms.Delete("p1", "a1", "user.missing") // no-op deletes are not reported
+
+	e := <-events
+	if e.Op != sdk.OpSet || e.Key != "user.theme" || e.Value != "dark" {
+		t.Errorf("Unexpected first event: %+v", e)
+	}
+	e = <-events
+	if e.Op != sdk.OpDelete || e.Key != "user.theme" {
+		t.Errorf("Unexpected second event: %+v", e)
+	}
+
+	cancel()
+	for range events {
+		t.Error("Unexpected event after cancel")
+	}
+}
+
+func TestMemStore_WatchDropped(t *testing.T) {
+	ms := NewMemStore(nil, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Subscribers dropped for lagging end their goroutine, even while ctx
+	// lives on to subscribe again
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		events, _ := ms.Watch(ctx, "p1", "a1", "")
+		for j := 0; j <= watchBufferSize; j++ {
+			ms.Set("p1", "a1", "k", j)
+		}
+		for range events {
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("Expected dropped subscriptions to leave no goroutines, got %d more", n-before)
+	}
+}
+
+func TestMemStore_Merge(t *testing.T) {
+	ms := NewMemStore(nil, nil)
+	original := map[string]any{
+		"theme": "light",
+		"editor": map[string]any{
+			"tabs":     float64(2),
+			"wordWrap": true,
+		},
+	}
+	ms.Set("p1", "settings", "prefs", original)
+
+	merged, err := ms.Merge("p1", "settings", "prefs", map[string]any{
+		"theme":  "dark",
+		"editor": map[string]any{"wordWrap": nil, "font": "mono"},
+	})
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+
+	want := map[string]any{
+		"theme": "dark",
+		"editor": map[string]any{
+			"tabs": float64(2),
+			"font": "mono",
+		},
+	}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("Expected %v, got %v", want, merged)
+	}
+	if got, _ := ms.Get("p1", "settings", "prefs"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Stored value mismatch: %v", got)
+	}
+	if original["theme"] != "light" {
+		t.Error("Merge mutated the previously stored value")
+	}
+
+	// Merging into a missing key starts from an empty object.
+	merged, _ = ms.Merge("p1", "settings", "new", map[string]any{"a": float64(1), "b": nil})
+	if !reflect.DeepEqual(merged, map[string]any{"a": float64(1)}) {
+		t.Errorf("Unexpected merge into missing key: %v", merged)
+	}
+
+	// A non-object patch replaces the value.
+	merged, _ = ms.Merge("p1", "settings", "prefs", "reset")
+	if merged != "reset" {
+		t.Errorf("Expected replacement, got %v", merged)
+	}
+}
+
+func TestMigrateWithOptions(t *testing.T) {
+	src := NewMemStore(nil, nil)
+	src.Set("p1", "a1", "k1", "new")
+	src.Set("p1", "a1", "k2", "new")
+	src.Set("p1", "a2", "k1", "new")
+	src.Set("p2", "a1", "k1", "new")
+
+	dst := NewMemStore(nil, nil)
+	dst.Set("p1", "a1", "k1", "old")
+
+	// Dry run writes nothing but reports the conflict.
+	result, err := MigrateWithOptions(src, dst, MigrateOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if result.Copied != 4 || result.Conflicts != 1 {
+		t.Errorf("Unexpected dry run result: %+v", result)
+	}
+	if personas, _ := dst.GetPersonas(); len(personas) != 1 {
+		t.Errorf("Dry run wrote to destination: %v", personas)
+	}
+
+	// Skip keeps the existing value and only copies the filtered app.
+	var progress []MigrateProgress
+	result, err = MigrateWithOptions(src, dst, MigrateOptions{
+		PersonaID:  "p1",
+		AppID:      "a1",
+		OnConflict: ConflictSkip,
+		Progress:   func(p MigrateProgress) { progress = append(progress, p) },
+	})
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if result.Copied != 1 || result.Skipped != 1 || len(progress) != 1 {
+		t.Errorf("Unexpected result: %+v, progress %v", result, progress)
+	}
+	if val, _ := dst.Get("p1", "a1", "k1"); val != "old" {
+		t.Errorf("Expected existing value to be kept, got %v", val)
+	}
+	if _, err := dst.Get("p1", "a2", "k1"); err == nil {
+		t.Error("App filter was not applied")
+	}
+
+	// Filtering on an app a persona doesn't have is not an error.
+	if _, err := MigrateWithOptions(src, dst, MigrateOptions{AppID: "a2"}); err != nil {
+		t.Errorf("Expected missing apps to be skipped, got %v", err)
+	}
+
+	// The plain Migrate overwrites.
+	if err := Migrate(src, dst); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if val, _ := dst.Get("p1", "a1", "k1"); val != "new" {
+		t.Errorf("Expected overwrite, got %v", val)
+	}
+}
+
+func TestMigrateDiffAndResume(t *testing.T) {
+	src := NewMemStore(nil, nil)
+	src.Set("p1", "a1", "same", "v")
+	src.Set("p1", "a1", "changed", "new")
+	src.Set("p1", "a2", "k", "v")
+	src.Set("p2", "a1", "k", "v")
+
+	dst := NewMemStore(nil, nil)
+	dst.Set("p1", "a1", "same", "v")
+	dst.Set("p1", "a1", "changed", "old")
+
+	var diffs []MigrateDiff
+	result, err := MigrateWithOptions(src, dst, MigrateOptions{
+		DryRun: true,
+		OnDiff: func(d MigrateDiff) { diffs = append(diffs, d) },
+	})
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if result.Conflicts != 1 || result.Unchanged != 1 || result.Copied != 3 {
+		t.Errorf("Unexpected dry run result: %+v", result)
+	}
+	want := []MigrateDiff{{PersonaID: "p1", AppID: "a1", Key: "changed", Src: "new", Dst: "old"}}
+	if !reflect.DeepEqual(diffs, want) {
+		t.Errorf("Expected %v, got %v", want, diffs)
+	}
+
+	// Resuming after p1/a1 migrates the pairs after it, in order.
+	var cursors []string
+	_, err = MigrateWithOptions(src, dst, MigrateOptions{
+		ResumeAfter: "p1/a1",
+		Progress:    func(p MigrateProgress) { cursors = append(cursors, p.Cursor()) },
+	})
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if !reflect.DeepEqual(cursors, []string{"p1/a2", "p2/a1"}) {
+		t.Errorf("Expected p1/a2 and p2/a1, got %v", cursors)
+	}
+	if val, _ := dst.Get("p1", "a1", "changed"); val != "old" {
+		t.Errorf("Expected p1/a1 to be skipped, got %v", val)
+	}
+}
+
+// batchCounter counts the SetBatch calls reaching a MemStore.
+type batchCounter struct {
+	*MemStore
+	batches atomic.Int64
+}
+
+func (b *batchCounter) SetBatch(records []sdk.Record) error {
+	b.batches.Add(1)
+	return b.MemStore.SetBatch(records)
+}
+
+func TestMigrateParallel(t *testing.T) {
+	src := NewMemStore(nil, nil)
+	for p := 0; p < 10; p++ {
+		for a := 0; a < 3; a++ {
+			for k := 0; k < 5; k++ {
+				src.Set(fmt.Sprintf("p%d", p), fmt.Sprintf("a%d", a), fmt.Sprintf("k%d", k), k)
+			}
+		}
+	}
+	dst := &batchCounter{MemStore: NewMemStore(nil, nil)}
+
+	var cursors []string
+	result, err := MigrateWithOptions(src, dst, MigrateOptions{
+		Workers:   8,
+		BatchSize: 2,
+		Progress:  func(p MigrateProgress) { cursors = append(cursors, p.Cursor()) },
+	})
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if result.Personas != 10 || result.Apps != 30 || result.Copied != 150 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if len(cursors) != 30 || !sort.StringsAreSorted(cursors) {
+		t.Errorf("Expected progress for 30 apps in order, got %v", cursors)
+	}
+	if n := dst.batches.Load(); n != 90 {
+		t.Errorf("Expected 3 batches per app, got %d", n)
+	}
+	if val, _ := dst.Get("p9", "a2", "k4"); val != 4 {
+		t.Errorf("Expected p9/a2/k4 to be copied, got %v", val)
+	}
+}
+
+func TestMemStore_ExistenceFilter(t *testing.T) {
+	ms := NewMemStore(map[string]map[string]map[string]any{
+		"p1": {"a1": {"loaded": "v"}},
+	}, nil)
+
+	// Loaded apps have no filter until the first miss builds one.
+	if _, err := ms.Get("p1", "a1", "missing"); err != ErrKeyNotFound {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+	if _, err := ms.Get("p1", "a1", "missing"); err != ErrKeyNotFound {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+	if stats, _ := ms.Stats(); stats.FastMisses != 1 {
+		t.Errorf("Expected 1 fast miss, got %d", stats.FastMisses)
+	}
+
+	// The filter must never hide existing keys, including across rebuilds.
+	for i := 0; i < 2000; i++ {
+		ms.Set("p1", "a1", fmt.Sprintf("key-%d", i), i)
+	}
+	for i := 0; i < 2000; i++ {
+		if _, err := ms.Get("p1", "a1", fmt.Sprintf("key-%d", i)); err != nil {
+			t.Fatalf("key-%d not found: %v", i, err)
+		}
+	}
+	if val, err := ms.Get("p1", "a1", "loaded"); err != nil || val != "v" {
+		t.Errorf("Loaded key lost after rebuild: %v, %v", val, err)
+	}
+
+	// Deleted keys stay in the filter but are still reported missing.
+	ms.Delete("p1", "a1", "key-1")
+	if _, err := ms.Get("p1", "a1", "key-1"); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound after delete, got %v", err)
+	}
+}
+
+func TestPersonaHasher(t *testing.T) {
+	h := NewPersonaHasher([]byte("secret"))
+	hash := h.ID("alice@example.com")
+	if hash == "alice@example.com" || !strings.HasPrefix(hash, "p_") {
+		t.Fatalf("Expected hashed ID, got %q", hash)
+	}
+	if h.ID("alice@example.com") != hash {
+		t.Error("Hash is not stable")
+	}
+	if id, ok := h.Lookup(hash); !ok || id != "alice@example.com" {
+		t.Errorf("Lookup failed: %q, %v", id, ok)
+	}
+
+	// Hashes not seen yet can be resolved from candidates.
+	other := NewPersonaHasher([]byte("secret"))
+	if id, ok := other.Lookup(hash, "bob", "alice@example.com"); !ok || id != "alice@example.com" {
+		t.Errorf("Candidate lookup failed: %q, %v", id, ok)
+	}
+
+	// Only the most recently hashed IDs are remembered
+	other.memory = 2
+	for _, id := range []string{"alice@example.com", "bob", "alice@example.com", "carol"} {
+		other.ID(id)
+	}
+	if _, ok := other.Lookup(other.hash("bob")); ok {
+		t.Error("Expected the least recently hashed ID to be forgotten")
+	}
+	if id, ok := other.Lookup(hash); !ok || id != "alice@example.com" || len(other.reverse) != 2 {
+		t.Errorf("Expected recent IDs to be kept, got %q, %v with %d remembered", id, ok, len(other.reverse))
+	}
+
+	var disabled *PersonaHasher
+	if disabled.ID("alice") != "alice" {
+		t.Error("Nil hasher should return IDs unchanged")
+	}
+
+	ms := NewMemStore(nil, nil)
+	ms.SetPersonaHasher(h)
+	ms.Set("alice@example.com", "a1", "k1", "v1")
+	stats, _ := ms.Stats()
+	if stats.TopContended[0].PersonaID != hash {
+		t.Errorf("Expected hashed persona in stats, got %q", stats.TopContended[0].PersonaID)
+	}
+}
+
+// memBackend is a StorageBackend that keeps snapshots in memory.
+// gatedBackend holds saves of a persona until gate is closed.
+type gatedBackend struct {
+	*memBackend
+	personaID string
+	gate      chan struct{}
+}
+
+func (b *gatedBackend) SavePersona(personaID string, data map[string]map[string]any) error {
+	if personaID == b.personaID {
+		<-b.gate
+	}
+	return b.memBackend.SavePersona(personaID, data)
+}
+
+type memBackend struct {
+	mu     sync.Mutex
+	saved  map[string]map[string]map[string]any
+	closed bool
+}
+
+func (b *memBackend) LoadAll() (map[string]map[string]map[string]any, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.saved, nil
+}
+
+func (b *memBackend) SavePersona(personaID string, data map[string]map[string]any) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.saved[personaID] = data
+	return nil
+}
+
+func (b *memBackend) DeletePersona(personaID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.saved, personaID)
+	return nil
+}
+
+func (b *memBackend) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestMemStore_CustomBackend(t *testing.T) {
+	backend := &memBackend{saved: make(map[string]map[string]map[string]any)}
+	ms := NewMemStore(nil, backend)
+
+	ms.Set("p1", "a1", "k1", "v1")
+	ms.Wait()
+	ms.Move("p1", "p2", "a1", "k1")
+	if err := ms.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if !backend.closed {
+		t.Error("Backend was not closed")
+	}
+	if backend.saved["p2"]["a1"]["k1"] != "v1" || len(backend.saved["p1"]["a1"]) != 0 {
+		t.Errorf("Unexpected backend state: %v", backend.saved)
+	}
+
+	// A nil *Persistence behaves like no backend at all.
+	var nilPersistence *Persistence
+	if err := NewMemStore(nil, nilPersistence).Set("p1", "a1", "k1", "v1"); err != nil {
+		t.Errorf("Set with nil persistence failed: %v", err)
+	}
+}
+
+func TestPersistence_DeletePersona(t *testing.T) {
+	p, err := NewPersistence(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewPersistence failed: %v", err)
+	}
+	p.SavePersona("user1", map[string]map[string]any{"a": {"k": "v"}})
+
+	if err := p.DeletePersona("user1"); err != nil {
+		t.Fatalf("DeletePersona failed: %v", err)
+	}
+	if err := p.DeletePersona("user1"); err != nil {
+		t.Errorf("Deleting a missing persona should succeed, got %v", err)
+	}
+	if all, _ := p.LoadAll(); len(all) != 0 {
+		t.Errorf("Expected no personas, got %v", all)
+	}
+}
+
+func TestPersistence_Quarantine(t *testing.T) {
+	dir := t.TempDir()
+	p, _ := NewPersistence(dir)
+	p.SavePersona("good", map[string]map[string]any{"a": {"k": "v"}})
+	p.SavePersona("flipped", map[string]map[string]any{"a": {"k": "v"}})
+
+	// A flipped byte fails the checksum; truncation fails to parse
+	content, _ := os.ReadFile(filepath.Join(dir, "flipped", "a.json"))
+	content = []byte(strings.Replace(string(content), `"v"`, `"x"`, 1))
+	os.WriteFile(filepath.Join(dir, "flipped", "a.json"), content, 0644)
+	os.WriteFile(filepath.Join(dir, "truncated.json"), []byte(`{"a": {"k":`), 0644)
+	// Files from older versions have no footer and must still load
+	os.WriteFile(filepath.Join(dir, "legacy.json"), []byte(`{"a": {"k": "v"}}`), 0644)
+
+	p, _ = NewPersistence(dir)
+	data, err := p.LoadAll()
+	if err != nil {
+		t.Fatalf("LoadAll failed: %v", err)
+	}
+	if len(data) != 2 || data["good"] == nil || data["legacy"] == nil {
+		t.Errorf("Expected only good and legacy personas to load, got %v", data)
+	}
+
+	q := p.Quarantined()
+	if len(q) != 2 || q[0].PersonaID != "flipped" || q[0].Reason != errChecksumMismatch.Error() || q[1].PersonaID != "truncated" {
+		t.Fatalf("Unexpected quarantine list: %+v", q)
+	}
+	if _, err := os.Stat(filepath.Join(dir, q[0].File)); err != nil {
+		t.Errorf("Expected corrupt file in quarantine: %v", err)
+	}
+
+	ms := NewMemStore(data, p)
+	if err := ms.Set("flipped", "a", "k", "new"); err != ErrPersonaQuarantined {
+		t.Errorf("Expected ErrPersonaQuarantined, got %v", err)
+	}
+	if err := ms.Move("good", "truncated", "a", "k"); err != ErrPersonaQuarantined {
+		t.Errorf("Expected Move into a quarantined persona to fail, got %v", err)
+	}
+	if err := ms.Set("good", "a", "k2", "v2"); err != nil {
+		t.Errorf("Expected writes to healthy personas to succeed, got %v", err)
+	}
+	ms.Wait()
+
+	if health, _ := ms.Health(); health.Status != sdk.HealthDegraded || len(health.Quarantined) != 2 {
+		t.Errorf("Expected degraded health, got %+v", health)
+	}
+
+	// The quarantine survives a restart until the operator clears it
+	p, _ = NewPersistence(dir)
+	p.LoadAll()
+	if !p.IsQuarantined("flipped") {
+		t.Error("Expected quarantine to persist across restarts")
+	}
+	os.RemoveAll(filepath.Join(dir, QuarantineDir))
+	p, _ = NewPersistence(dir)
+	p.LoadAll()
+	if len(p.Quarantined()) != 0 {
+		t.Errorf("Expected no quarantine after clearing the directory, got %v", p.Quarantined())
+	}
+}
+
+func TestFsck(t *testing.T) {
+	dir := t.TempDir()
+	p, _ := NewPersistence(dir)
+	p.SavePersona("good", map[string]map[string]any{"a": {"k": "v"}})
+	p.SavePersona("crashed", map[string]map[string]any{"a": {"k": "old"}, "b": {"k": "v"}})
+
+	// A save that finished writing its temporary file, and one that didn't
+	complete, _ := encodeDataFile(map[string]any{"k": "new"})
+	os.WriteFile(filepath.Join(dir, "crashed", "a.json.tmp"), complete, 0644)
+	os.WriteFile(filepath.Join(dir, "crashed", "b.json.tmp"), complete[:10], 0644)
+	content, _ := os.ReadFile(filepath.Join(dir, "good", "a.json"))
+	os.WriteFile(filepath.Join(dir, "good", "a.json"), []byte(strings.Replace(string(content), `"v"`, `"x"`, 1)), 0644)
+
+	report, err := Fsck(dir, false)
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	if report.Files != 3 || len(report.Problems) != 3 || report.Unresolved() != 3 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	if report.Problems[2].Kind != sdk.FsckChecksum || report.Problems[2].PersonaID != "good" {
+		t.Errorf("Expected the checksum mismatch to be reported, got %+v", report.Problems[2])
+	}
+	if _, err := os.Stat(filepath.Join(dir, "crashed", "b.json.tmp")); err != nil {
+		t.Error("Expected a check without repair to leave files alone")
+	}
+
+	report, err = Fsck(dir, true)
+	if err != nil {
+		t.Fatalf("Fsck with repair failed: %v", err)
+	}
+	if report.Unresolved() != 0 {
+		t.Errorf("Expected every problem to be repaired, got %+v", report)
+	}
+	if report, _ := Fsck(dir, false); len(report.Problems) != 1 || report.Problems[0].Kind != sdk.FsckQuarantined {
+		t.Errorf("Expected only the quarantine to remain, got %+v", report)
+	}
+
+	p, _ = NewPersistence(dir)
+	data, _ := p.LoadAll()
+	if data["crashed"]["a"]["k"] != "new" || data["crashed"]["b"]["k"] != "v" {
+		t.Errorf("Expected the complete temporary file to be restored, got %v", data["crashed"])
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "crashed")); len(entries) != 2 {
+		t.Errorf("Expected the temporary files to be gone, got %v", entries)
+	}
+}
+
+func TestPersistence_RecoversInterruptedSaves(t *testing.T) {
+	dir := t.TempDir()
+	p, _ := NewPersistence(dir)
+	p.SavePersona("crashed", map[string]map[string]any{"a": {"k": "old"}, "b": {"k": "v"}})
+
+	complete, _ := encodeDataFile(map[string]any{"k": "new"})
+	os.WriteFile(filepath.Join(dir, "crashed", "a.json.tmp"), complete, 0644)
+	os.WriteFile(filepath.Join(dir, "crashed", "b.json.tmp"), complete[:len(complete)-5], 0644)
+	os.WriteFile(filepath.Join(dir, "crashed", "c.json.tmp"), complete, 0644) // A new app never renamed into place
+	os.MkdirAll(filepath.Join(dir, "crashed", "a.blobs"), 0755)
+	os.WriteFile(filepath.Join(dir, "crashed", "a.blobs", ".upload-1.tmp"), []byte("partial"), 0644)
+
+	p, _ = NewPersistence(dir)
+	data, err := p.LoadAll()
+	if err != nil {
+		t.Fatalf("LoadAll failed: %v", err)
+	}
+	if data["crashed"]["a"]["k"] != "new" || data["crashed"]["b"]["k"] != "v" || data["crashed"]["c"]["k"] != "new" {
+		t.Errorf("Expected complete saves to be recovered and incomplete ones discarded, got %v", data["crashed"])
+	}
+	if p.IsQuarantined("crashed") {
+		t.Error("Expected an interrupted save not to quarantine the persona")
+	}
+	for _, name := range []string{"a.json.tmp", "b.json.tmp", "c.json.tmp", filepath.Join("a.blobs", ".upload-1.tmp")} {
+		if _, err := os.Stat(filepath.Join(dir, "crashed", name)); err == nil {
+			t.Errorf("Expected %s to be cleaned up", name)
+		}
+	}
+}
+
+func TestPersistence_FsyncPolicies(t *testing.T) {
+	if _, err := ParseFsyncPolicy("sometimes"); err == nil {
+		t.Error("Expected an invalid policy to be rejected")
+	}
+
+	for _, policy := range []FsyncPolicy{FsyncNever, FsyncInterval, FsyncAlways} {
+		t.Run(string(policy), func(t *testing.T) {
+			p, _ := NewPersistence(t.TempDir())
+			p.SetFsyncPolicy(policy, 10*time.Millisecond)
+
+			if err := p.SavePersona("user1", map[string]map[string]any{"a": {"k": "v"}}); err != nil {
+				t.Fatalf("SavePersona failed: %v", err)
+			}
+			if policy == FsyncInterval {
+				time.Sleep(30 * time.Millisecond)
+				p.mu.Lock()
+				pending := len(p.dirty)
+				p.mu.Unlock()
+				if pending != 0 {
+					t.Errorf("Expected the flusher to have synced the write, %d pending", pending)
+				}
+			}
+			if err := p.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+
+			data, _ := p.LoadAll()
+			if data["user1"]["a"]["k"] != "v" {
+				t.Errorf("Expected data to round-trip, got %v", data)
+			}
+		})
+	}
+}
+
+// BenchmarkPersistence_SavePersona shows the throughput cost of each fsync policy:
+//
+//	go test ./pkg/engine -run '^$' -bench SavePersona
+func BenchmarkPersistence_SavePersona(b *testing.B) {
+	data := map[string]map[string]any{"app": {}}
+	for i := 0; i < 100; i++ {
+		data["app"][fmt.Sprintf("key%d", i)] = strings.Repeat("x", 100)
+	}
+
+	for _, policy := range []FsyncPolicy{FsyncNever, FsyncInterval, FsyncAlways} {
+		b.Run(string(policy), func(b *testing.B) {
+			p, _ := NewPersistence(b.TempDir())
+			p.SetFsyncPolicy(policy, DefaultFsyncInterval)
+			defer p.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := p.SavePersona(fmt.Sprintf("user%d", i%10), data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestPersistence_PerAppFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	// A persona in the old single-file layout is split into app files on load
+	legacy := `{"a1": {"k": "v1"}, "a2": {"k": "v2"}}`
+	os.WriteFile(filepath.Join(dir, "user1.json"), []byte(legacy), 0644)
+
+	p, _ := NewPersistence(dir)
+	data, err := p.LoadAll()
+	if err != nil {
+		t.Fatalf("LoadAll failed: %v", err)
+	}
+	if data["user1"]["a2"]["k"] != "v2" {
+		t.Errorf("Expected legacy data to load, got %v", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "user1.json")); !os.IsNotExist(err) {
+		t.Error("Expected the legacy file to be removed after migration")
+	}
+
+	// Writes only touch the app they change
+	ms := NewMemStore(data, p)
+	before, _ := os.Stat(filepath.Join(dir, "user1", "a2.json"))
+	time.Sleep(10 * time.Millisecond)
+	ms.Set("user1", "a1", "k", "changed")
+	ms.Wait()
+	after, _ := os.Stat(filepath.Join(dir, "user1", "a2.json"))
+	if !after.ModTime().Equal(before.ModTime()) {
+		t.Error("Expected an untouched app file not to be rewritten")
+	}
+
+	p, _ = NewPersistence(dir)
+	data, _ = p.LoadAll()
+	if data["user1"]["a1"]["k"] != "changed" || data["user1"]["a2"]["k"] != "v2" {
+		t.Errorf("Unexpected data after reload: %v", data)
+	}
+
+	// SavePersona drops files of apps that are gone
+	p.SavePersona("user1", map[string]map[string]any{"a1": {"k": "only"}})
+	if _, err := os.Stat(filepath.Join(dir, "user1", "a2.json")); !os.IsNotExist(err) {
+		t.Error("Expected the removed app's file to be deleted")
+	}
+}
+
+func TestMemStore_MemoryLimit(t *testing.T) {
+	big := strings.Repeat("x", 1000)
+
+	// Accounting follows writes, overwrites and deletes
+	ms := NewMemStore(nil, nil)
+	ms.Set("p1", "a1", "k1", big)
+	stats, _ := ms.Stats()
+	if stats.MemoryBytes < 1000 || stats.MemoryBytes > 1200 {
+		t.Fatalf("Unexpected memory estimate: %d", stats.MemoryBytes)
+	}
+	ms.Set("p1", "a1", "k1", "small")
+	ms.Delete("p1", "a1", "k1")
+	if stats, _ = ms.Stats(); stats.MemoryBytes != 0 {
+		t.Errorf("Expected memory to drop to 0 after delete, got %d", stats.MemoryBytes)
+	}
+
+	// reject
+	ms.SetMemoryLimit(2500, EvictReject)
+	ms.Set("p1", "a1", "k1", big)
+	ms.Set("p1", "a1", "k2", big)
+	if err := ms.Set("p1", "a1", "k3", big); !errors.Is(err, ErrMemoryLimit) {
+		t.Errorf("Expected ErrMemoryLimit, got %v", err)
+	}
+	if err := ms.Set("p1", "a1", "k1", "shrinking is fine"); err != nil {
+		t.Errorf("Expected a shrinking write to pass, got %v", err)
+	}
+
+	// ephemeral
+	ms = NewMemStore(nil, nil)
+	ms.SetEphemeralApps("cache")
+	ms.SetMemoryLimit(2500, EvictEphemeral)
+	ms.Set("p1", "cache", "k1", big)
+	ms.Set("p1", "data", "k1", big)
+	if err := ms.Set("p1", "data", "k2", big); err != nil {
+		t.Fatalf("Expected the cache to be evicted, got %v", err)
+	}
+	if _, err := ms.Get("p1", "cache", "k1"); err == nil {
+		t.Error("Expected the ephemeral app to be gone")
+	}
+	if err := ms.Set("p1", "data", "k3", big); !errors.Is(err, ErrMemoryLimit) {
+		t.Errorf("Expected ErrMemoryLimit once nothing is evictable, got %v", err)
+	}
+
+	// lru needs a backend that can load personas back
+	if err := NewMemStore(nil, nil).SetMemoryLimit(2500, EvictLRU); err == nil {
+		t.Error("Expected lru to require a PersonaLoader")
+	}
+	p, _ := NewPersistence(t.TempDir())
+	ms = NewMemStore(nil, p)
+	ms.SetMemoryLimit(2500, EvictLRU)
+	ms.Set("old", "a1", "k1", big)
+	ms.Wait()
+	ms.Set("new", "a1", "k1", big)
+	ms.Wait()
+	ms.Set("new", "a1", "k2", big)
+	ms.Wait()
+	stats, _ = ms.Stats()
+	if stats.EvictedPersonas != 1 || stats.Personas != 2 {
+		t.Fatalf("Expected old to be evicted: %+v", stats)
+	}
+	if val, err := ms.Get("old", "a1", "k1"); err != nil || val != big {
+		t.Fatalf("Expected old to be loaded back, got %v", err)
+	}
+	if dump, _ := ms.DumpApp("a1"); len(dump) != 2 {
+		t.Errorf("Expected DumpApp to include every persona, got %d", len(dump))
+	}
+}
+
+func TestMemStore_ReadsDuringEviction(t *testing.T) {
+	p, _ := NewPersistence(t.TempDir())
+	ms := NewMemStore(nil, p)
+	big := strings.Repeat("x", 1000)
+	ms.SetMemoryLimit(2500, EvictLRU)
+	ms.Set("p1", "a1", "k1", big)
+	ms.Set("p2", "a1", "k1", big)
+	ms.Wait()
+
+	// A writer keeps evicting the personas the reader keeps loading back
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			ms.Set("w", "a1", "k1", big)
+			ms.Delete("w", "a1", "k1")
+			ms.Wait()
+		}
+	}()
+	for i := 0; ; i++ {
+		select {
+		case <-done:
+			if stats, _ := ms.Stats(); stats.Evictions == 0 {
+				t.Error("Expected the writer to evict personas")
+			}
+			return
+		default:
+		}
+		personaID := fmt.Sprintf("p%d", i%2+1)
+		if val, err := ms.Get(personaID, "a1", "k1"); err != nil || val != big {
+			t.Fatalf("Expected %s to read back while evicted, got %v", personaID, err)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	for in, want := range map[string]int64{"1024": 1024, "512MB": 512 << 20, "2GiB": 2 << 30, "64k": 64 << 10} {
+		if got, err := ParseByteSize(in); err != nil || got != want {
+			t.Errorf("ParseByteSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	if _, err := ParseByteSize("lots"); err == nil {
+		t.Error("Expected an error for an invalid size")
+	}
+}
+
+func TestMemStore_SavesLandInOrder(t *testing.T) {
+	backend := &memBackend{saved: make(map[string]map[string]map[string]any)}
+	ms := NewMemStore(nil, backend)
+
+	// Back-to-back writes start overlapping background saves of the same persona
+	for i := 0; i < 100; i++ {
+		ms.Set("p1", "a1", "counter", i)
+	}
+	ms.Close()
+	if got := backend.saved["p1"]["a1"]["counter"]; got != 99 {
+		t.Errorf("Expected the newest snapshot to be saved last, got %v", got)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir, WithFsync(FsyncAlways, 0))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	store.Set("p1", "a1", "k1", "v1")
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	store, err = Open(dir, WithMemoryLimit(1, EvictReject))
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer store.Close()
+	if val, err := store.Get("p1", "a1", "k1"); err != nil || val != "v1" {
+		t.Errorf("Expected data to survive reopening, got %v, %v", val, err)
+	}
+	if err := store.Set("p1", "a1", "k2", "v2"); !errors.Is(err, ErrMemoryLimit) {
+		t.Errorf("Expected the memory limit to apply, got %v", err)
+	}
+
+	// Only one store at a time may have the directory open
+	if _, err := Open(dir); !errors.Is(err, ErrDataDirLocked) {
+		t.Errorf("Expected a second Open to fail with ErrDataDirLocked, got %v", err)
+	}
+	if _, err := Fsck(dir, true); !errors.Is(err, ErrDataDirLocked) {
+		t.Errorf("Expected Fsck to refuse a directory in use, got %v", err)
+	}
+}
+
+func TestMemStore_Blobs(t *testing.T) {
+	if _, err := NewMemStore(nil, nil).SetBlob("p1", "a1", "k1", strings.NewReader("x")); !errors.Is(err, sdk.ErrNotSupported) {
+		t.Errorf("Expected blobs to need a backend, got %v", err)
+	}
+
+	dir := t.TempDir()
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Set("p1", "a1", "k1", "json value")
+	data := strings.Repeat("\x00\xffblob\n", 1000)
+	if _, err := store.SetBlob("../p2", "a1", "k1", strings.NewReader(data)); !errors.Is(err, sdk.ErrBadRequest) {
+		t.Errorf("Expected a path in the persona ID to be rejected, got %v", err)
+	}
+	if n, err := store.SetBlob("p1", "a1", "avatar:v2.png", strings.NewReader(data)); err != nil || n != int64(len(data)) {
+		t.Fatalf("SetBlob failed: %d, %v", n, err)
+	}
+	store.Close()
+
+	// Blobs live beside the JSON values without being loaded with them
+	store, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer store.Close()
+	if apps, _ := store.GetApps("p1"); len(apps) != 1 {
+		t.Errorf("Expected blobs not to show up as apps, got %v", apps)
+	}
+	blob, size, err := store.GetBlob("p1", "a1", "avatar:v2.png")
+	if err != nil || size != int64(len(data)) {
+		t.Fatalf("GetBlob failed: %d, %v", size, err)
+	}
+	got, err := io.ReadAll(blob)
+	if err != nil || string(got) != data {
+		t.Errorf("Blob round trip failed: %v", err)
+	}
+	blob.Close()
+
+	if err := store.DeleteBlob("p1", "a1", "avatar:v2.png"); err != nil {
+		t.Fatalf("DeleteBlob failed: %v", err)
+	}
+	if _, _, err := store.GetBlob("p1", "a1", "avatar:v2.png"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected a deleted blob to be missing, got %v", err)
+	}
+	if val, _ := store.Get("p1", "a1", "k1"); val != "json value" {
+		t.Errorf("Expected the JSON value to be untouched, got %v", val)
+	}
+}
+
+func TestMemStore_ValidatesIDs(t *testing.T) {
+	store := NewMemStore(map[string]map[string]map[string]any{
+		"p1": {"a1": {"legacy key": "v"}},
+	}, nil)
+
+	for _, ids := range [][3]string{
+		{"", "a1", "k1"},
+		{"p 1", "a1", "k1"},
+		{"..", "a1", "k1"},
+		{"p1", "a/1", "k1"},
+		{"p1", "a1", "k\n1"},
+		{"p1", "a1", strings.Repeat("k", sdk.MaxIDLength+1)},
+		{QuarantineDir, "a1", "k1"},
+	} {
+		if err := store.Set(ids[0], ids[1], ids[2], "v"); !errors.Is(err, sdk.ErrBadRequest) {
+			t.Errorf("Set %q: expected a bad request, got %v", ids, err)
+		}
+	}
+	if err := store.Set("user@example.com", "app.v2", "theme:dark", "v"); err != nil {
+		t.Errorf("Expected a valid ID to be accepted, got %v", err)
+	}
+	if err := store.Move("p1", "../p2", "a1", "legacy key"); !errors.Is(err, sdk.ErrBadRequest) {
+		t.Errorf("Expected an invalid move target to be rejected, got %v", err)
+	}
+
+	// Keys stored before validation existed can still be deleted
+	if err := store.Delete("p1", "a1", "legacy key"); err != nil {
+		t.Errorf("Expected a legacy key to be deletable, got %v", err)
+	}
+}
+
+func TestMemStore_Schemas(t *testing.T) {
+	store := NewMemStore(nil, nil)
+	store.Set("p1", "settings", "stale", "stored before the schema")
+
+	if err := sdk.SetSchema(store, "settings", map[string]any{"type": "integer"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(SystemPersona, sdk.SchemaApp, "other", map[string]any{"type": 5.0}); !errors.Is(err, sdk.ErrBadRequest) {
+		t.Errorf("Expected an invalid schema to be rejected, got %v", err)
+	}
+
+	if err := store.Set("p1", "settings", "k1", 3); err != nil {
+		t.Errorf("Expected a matching value to be stored, got %v", err)
+	}
+	if err := store.Set("p1", "settings", "k1", "three"); !errors.Is(err, sdk.ErrBadRequest) {
+		t.Errorf("Expected a mismatch to be rejected, got %v", err)
+	}
+	if _, err := store.Merge("p1", "settings", "k1", map[string]any{"a": 1.0}); !errors.Is(err, sdk.ErrBadRequest) {
+		t.Errorf("Expected a mismatching merge to be rejected, got %v", err)
+	}
+	if err := store.SetBatch([]sdk.Record{{PersonaID: "p1", AppID: "settings", Key: "k2", Value: true}}); !errors.Is(err, sdk.ErrBadRequest) {
+		t.Errorf("Expected a mismatching batch to be rejected, got %v", err)
+	}
+	if val, _ := store.Get("p1", "settings", "k1"); val != 3 {
+		t.Errorf("Expected rejected writes to leave the value alone, got %v", val)
+	}
+	if err := store.Set("p1", "other", "k1", "anything"); err != nil {
+		t.Errorf("Expected apps without a schema to accept anything, got %v", err)
+	}
+
+	if err := sdk.DeleteSchema(store, "settings"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("p1", "settings", "k1", "three"); err != nil {
+		t.Errorf("Expected no validation after deleting the schema, got %v", err)
+	}
+}
+
+func TestMemStore_ConditionalWrites(t *testing.T) {
+	store := NewMemStore(nil, nil)
+
+	if err := store.SetIfRevision("p1", "a1", "k1", "v1", ""); err != nil {
+		t.Fatalf("Expected a create-only write to succeed, got %v", err)
+	}
+	if err := store.SetIfRevision("p1", "a1", "k1", "v1", ""); !errors.Is(err, sdk.ErrConflict) {
+		t.Errorf("Expected a second create to conflict, got %v", err)
+	}
+
+	rev := sdk.Revision("v1")
+	if err := store.SetIfRevision("p1", "a1", "k1", "v2", rev); err != nil {
+		t.Fatalf("Expected a write at the current revision to succeed, got %v", err)
+	}
+	if err := store.SetIfRevision("p1", "a1", "k1", "v3", rev); !errors.Is(err, sdk.ErrConflict) {
+		t.Errorf("Expected a stale write to conflict, got %v", err)
+	}
+	if err := store.DeleteIfRevision("p1", "a1", "k1", rev); !errors.Is(err, sdk.ErrConflict) {
+		t.Errorf("Expected a stale delete to conflict, got %v", err)
+	}
+	if val, _ := store.Get("p1", "a1", "k1"); val != "v2" {
+		t.Errorf("Expected conflicts to leave the value alone, got %v", val)
+	}
+
+	if err := store.DeleteIfRevision("p1", "a1", "k1", sdk.Revision("v2")); err != nil {
+		t.Errorf("Expected a delete at the current revision to succeed, got %v", err)
+	}
+	if _, err := store.Get("p1", "a1", "k1"); !sdk.IsNotFound(err) {
+		t.Errorf("Expected the key to be deleted, got %v", err)
+	}
+}
+
+func TestMemStore_Queue(t *testing.T) {
+	store := NewMemStore(nil, nil)
+	for _, item := range []string{"a", "b", "c"} {
+		if _, err := store.Enqueue("p1", "jobs", "q", item); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+	}
+
+	first, err := store.Dequeue("p1", "jobs", "q", 20*time.Millisecond)
+	if err != nil || first.Item != "a" || first.Receives != 1 {
+		t.Fatalf("Expected a, got %+v, %v", first, err)
+	}
+	second, _ := store.Dequeue("p1", "jobs", "q", time.Minute)
+	if second.Item != "b" {
+		t.Errorf("Expected a hidden message to be skipped, got %+v", second)
+	}
+	if err := store.Ack("p1", "jobs", "q", second.Receipt); err != nil {
+		t.Errorf("Ack failed: %v", err)
+	}
+
+	// Unacked messages come back, in order, and their old receipt is void
+	time.Sleep(30 * time.Millisecond)
+	again, _ := store.Dequeue("p1", "jobs", "q", 0)
+	if again.Item != "a" || again.Receives != 2 {
+		t.Errorf("Expected a to be handed out again, got %+v", again)
+	}
+	if err := store.Ack("p1", "jobs", "q", first.Receipt); !errors.Is(err, sdk.ErrReceiptExpired) {
+		t.Errorf("Expected a stale receipt to be refused, got %v", err)
+	}
+	if last, _ := store.Dequeue("p1", "jobs", "q", 0); last.Item != "c" {
+		t.Errorf("Expected c, got %+v", last)
+	}
+	if _, err := store.Dequeue("p1", "jobs", "q", 0); !errors.Is(err, sdk.ErrQueueEmpty) || !sdk.IsNotFound(err) {
+		t.Errorf("Expected the queue to be empty, got %v", err)
+	}
+
+	store.Set("p1", "jobs", "plain", "not a queue")
+	if _, err := store.Enqueue("p1", "jobs", "plain", 1); !errors.Is(err, sdk.ErrBadRequest) {
+		t.Errorf("Expected enqueueing onto a plain value to fail, got %v", err)
+	}
+}
+
+func TestMemStore_StreamApp(t *testing.T) {
+	store := NewMemStore(nil, nil)
+	store.Set("p2", "app", "k", "two")
+	store.Set("p1", "app", "k", "one")
+	store.Set("p3", "other", "k", "skipped")
+	store.Set("p4", "app", "k", "four")
+
+	var seen []string
+	err := store.StreamApp("app", "", func(personaID string, data map[string]any) error {
+		seen = append(seen, personaID+"="+data["k"].(string))
+		return nil
+	})
+	if err != nil || strings.Join(seen, ",") != "p1=one,p2=two,p4=four" {
+		t.Fatalf("Expected the app's personas in order, got %v, %v", seen, err)
+	}
+
+	seen = nil
+	stop := errors.New("stop")
+	err = store.StreamApp("app", "p1", func(personaID string, data map[string]any) error {
+		seen = append(seen, personaID)
+		return stop
+	})
+	if err != stop || strings.Join(seen, ",") != "p2" {
+		t.Errorf("Expected to resume after p1 and stop at fn's error, got %v, %v", seen, err)
+	}
+}
+
+func TestMemStore_Hooks(t *testing.T) {
+	ms := NewMemStore(nil, nil)
+	errReadOnly := errors.New("read-only app")
+	var log []string
+	ms.OnBeforeSet(func(personaID, appID, key string, val any) error {
+		if appID == "frozen" {
+			return errReadOnly
+		}
+		return nil
+	})
+	ms.OnAfterSet(func(personaID, appID, key string, val any) {
+		log = append(log, fmt.Sprintf("set %s/%s/%s=%v", personaID, appID, key, val))
+	})
+	ms.OnBeforeDelete(func(personaID, appID, key string) error {
+		if key == "keep" {
+			return errReadOnly
+		}
+		return nil
+	})
+	ms.OnDelete(func(personaID, appID, key string) {
+		log = append(log, fmt.Sprintf("delete %s/%s/%s", personaID, appID, key))
+	})
+
+	if err := ms.Set("p1", "frozen", "k", "v"); !errors.Is(err, errReadOnly) {
+		t.Errorf("Expected the hook's error, got %v", err)
+	}
+	if _, err := ms.Get("p1", "frozen", "k"); err == nil {
+		t.Error("A vetoed write was applied")
+	}
+	ms.Set("p1", "a", "keep", 1)
+	ms.Set("p1", "a", "tmp", 2)
+	if _, err := ms.DeletePrefix("p1", "a", ""); !errors.Is(err, errReadOnly) {
+		t.Errorf("Expected DeletePrefix to be vetoed, got %v", err)
+	}
+	if val, _ := ms.Get("p1", "a", "tmp"); val != 2 {
+		t.Error("A vetoed DeletePrefix deleted keys")
+	}
+	if err := ms.Move("p1", "p2", "a", "keep"); !errors.Is(err, errReadOnly) {
+		t.Errorf("Expected the move's source delete to be vetoed, got %v", err)
+	}
+	ms.Delete("p1", "a", "tmp")
+	ms.Move("p1", "p1", "a", "missing") // Fails before any hook
+
+	want := []string{"set p1/a/keep=1", "set p1/a/tmp=2", "delete p1/a/tmp"}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("Expected %v, got %v", want, log)
+	}
+}
+
+func TestMemStore_Transformers(t *testing.T) {
+	ms := NewMemStore(nil, nil)
+	for _, name := range []string{TransformStripNulls, TransformLowercaseKeys, TransformNormalizeTimestamps} {
+		tr, err := LookupTransformer(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ms.AddTransformer(tr, "users", "events_*"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ms.AddTransformer(func(personaID, appID, key string, val any) (any, error) {
+		if s, ok := val.(string); ok {
+			return strings.TrimSpace(s), nil
+		}
+		return val, nil
+	})
+	if _, err := LookupTransformer("shout"); err == nil {
+		t.Error("Expected an unknown transformer to be refused")
+	}
+	if err := ms.AddTransformer(nil, "[users"); err == nil {
+		t.Error("Expected an invalid pattern to be refused")
+	}
+
+	written := map[string]any{"Name": "Alice", "Nick": nil, "Seen": "2024-05-01T12:00:00+02:00", "Tags": []any{map[string]any{"Kind": nil}}}
+	ms.Set("p1", "users", "alice", written)
+	want := map[string]any{"name": "Alice", "seen": "2024-05-01T10:00:00Z", "tags": []any{map[string]any{}}}
+	if val, _ := ms.Get("p1", "users", "alice"); !reflect.DeepEqual(val, want) {
+		t.Errorf("Expected %v, got %v", want, val)
+	}
+	if written["Nick"] != nil || len(written) != 4 {
+		t.Error("A transformer changed the value it was given")
+	}
+	if err := ms.Set("p1", "users", "bob", map[string]any{"id": 1, "ID": 2}); !errors.Is(err, sdk.ErrBadRequest) {
+		t.Errorf("Expected colliding fields to be refused, got %v", err)
+	}
+	if _, err := ms.Get("p1", "users", "bob"); err == nil {
+		t.Error("A refused write was applied")
+	}
+
+	// Other apps only get the transformer enabled everywhere
+	ms.Set("p1", "settings", "theme", map[string]any{"Mode": " dark ", "Font": nil})
+	if val, _ := ms.Get("p1", "settings", "theme"); !reflect.DeepEqual(val, map[string]any{"Mode": " dark ", "Font": nil}) {
+		t.Errorf("Expected other apps to be left alone, got %v", val)
+	}
+	ms.Set("p1", "settings", "name", "  x ")
+	if val, _ := ms.Get("p1", "settings", "name"); val != "x" {
+		t.Errorf("Expected the catch-all transformer to run, got %q", val)
+	}
+
+	// Merges are transformed once merged, batches record by record
+	if _, err := ms.Merge("p1", "users", "alice", map[string]any{"Role": "admin", "name": nil}); err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := ms.Get("p1", "users", "alice"); val.(map[string]any)["role"] != "admin" {
+		t.Errorf("Expected the merged value to be transformed, got %v", val)
+	}
+	ms.SetBatch([]sdk.Record{{PersonaID: "p2", AppID: "events_web", Key: "e1", Value: map[string]any{"At": "Wed, 01 May 2024 12:00:00 +0200"}}})
+	if val, _ := ms.Get("p2", "events_web", "e1"); !reflect.DeepEqual(val, map[string]any{"at": "2024-05-01T10:00:00Z"}) {
+		t.Errorf("Expected the batch to be transformed, got %v", val)
+	}
+
+	// The store's own records are never transformed
+	ms.Set(SystemPersona, "users", "u1", map[string]any{"Name": "Root"})
+	if val, _ := ms.Get(SystemPersona, "users", "u1"); !reflect.DeepEqual(val, map[string]any{"Name": "Root"}) {
+		t.Errorf("Expected _system to be left alone, got %v", val)
+	}
+}
+
+func TestMemStore_RawJSON(t *testing.T) {
+	ms := NewMemStore(nil, nil)
+	ms.SetRawJSON(true)
+	ms.Set("p1", "a1", "k1", map[string]any{"name": "Alice"})
+	ms.Set("p1", "a1", "k2", 42)
+
+	first, err := ms.GetRaw("p1", "a1", "k1")
+	if err != nil || string(first) != `{"name":"Alice"}` {
+		t.Fatalf("Expected the encoded value, got %s (%v)", first, err)
+	}
+	again, _ := ms.GetRaw("p1", "a1", "k1")
+	if &again[0] != &first[0] {
+		t.Error("Expected the kept encoding to be reused")
+	}
+
+	// A change replaces the kept encoding
+	ms.Set("p1", "a1", "k1", map[string]any{"name": "Bob"})
+	if raw, _ := ms.GetRaw("p1", "a1", "k1"); string(raw) != `{"name":"Bob"}` {
+		t.Errorf("Expected the new value, got %s", raw)
+	}
+	ms.Delete("p1", "a1", "k1")
+	if _, err := ms.GetRaw("p1", "a1", "k1"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound after delete, got %v", err)
+	}
+
+	app, err := ms.GetAppStoreRaw("p1", "a1")
+	if err != nil || len(app) != 1 || string(app["k2"]) != "42" {
+		t.Errorf("Expected the app's encoded values, got %v (%v)", app, err)
+	}
+	if _, err := ms.GetAppStoreRaw("p1", "missing"); !errors.Is(err, ErrAppNotFound) {
+		t.Errorf("Expected ErrAppNotFound, got %v", err)
+	}
+
+	// Emptying the app drops what was kept for it
+	ms.Delete("p1", "a1", "k2")
+	ms.raw.mu.Lock()
+	kept := len(ms.raw.byNS)
+	ms.raw.mu.Unlock()
+	if kept != 0 {
+		t.Errorf("Expected no encodings to be kept, got %d apps", kept)
+	}
+}
+
+func TestMemStore_DeepCopy(t *testing.T) {
+	ms := NewMemStore(nil, nil)
+	ms.SetDeepCopy(true)
+
+	settings := map[string]any{"theme": "dark", "tags": []any{"a"}}
+	ms.Set("p1", "a1", "settings", settings)
+	settings["theme"] = "light"
+	settings["tags"].([]any)[0] = "b"
+
+	val, _ := ms.Get("p1", "a1", "settings")
+	want := map[string]any{"theme": "dark", "tags": []any{"a"}}
+	if !reflect.DeepEqual(val, want) {
+		t.Fatalf("Expected the stored value to be unaffected by the caller, got %v", val)
+	}
+	val.(map[string]any)["theme"] = "light"
+	if again, _ := ms.Get("p1", "a1", "settings"); !reflect.DeepEqual(again, want) {
+		t.Errorf("Expected the stored value to be unaffected by a reader, got %v", again)
+	}
+
+	// Other types are copied by reflection and keep their type
+	type profile struct {
+		Name   string
+		Emails []string
+		Prefs  map[string]int
+	}
+	p := &profile{Name: "Alice", Emails: []string{"a@example.com"}, Prefs: map[string]int{"size": 1}}
+	ms.Set("p1", "a1", "profile", p)
+	p.Emails[0], p.Prefs["size"] = "b@example.com", 2
+
+	got, _ := ms.Get("p1", "a1", "profile")
+	stored, ok := got.(*profile)
+	if !ok || stored == p {
+		t.Fatalf("Expected a copy of the same type, got %#v", got)
+	}
+	if stored.Emails[0] != "a@example.com" || stored.Prefs["size"] != 1 {
+		t.Errorf("Expected the copy to be unaffected by the caller, got %+v", stored)
+	}
+
+	// Watchers get their own copy too
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, _ := ms.Watch(ctx, "p1", "a1", "")
+	ms.Set("p1", "a1", "settings", map[string]any{"theme": "blue"})
+	e := <-events
+	e.Value.(map[string]any)["theme"] = "red"
+	if val, _ := ms.Get("p1", "a1", "settings"); val.(map[string]any)["theme"] != "blue" {
+		t.Errorf("Expected the stored value to be unaffected by a watcher, got %v", val)
+	}
+}
+
+// brokenBackend is a memBackend whose saves fail.
+type brokenBackend struct {
+	memBackend
+}
+
+var errDiskFull = errors.New("disk full")
+
+func (b *brokenBackend) SavePersona(personaID string, data map[string]map[string]any) error {
+	return errDiskFull
+}
+
+// flakyBackend is a memBackend whose saves fail while full is set.
+type flakyBackend struct {
+	memBackend
+	full  atomic.Bool
+	tries atomic.Int32
+}
+
+func (b *flakyBackend) SavePersona(personaID string, data map[string]map[string]any) error {
+	b.tries.Add(1)
+	if b.full.Load() {
+		return errDiskFull
+	}
+	return b.memBackend.SavePersona(personaID, data)
+}
+
+func TestMemStore_SaveFailures(t *testing.T) {
+	backend := &flakyBackend{memBackend: memBackend{saved: make(map[string]map[string]map[string]any)}}
+	backend.full.Store(true)
+	ms := NewMemStore(nil, backend)
+	ms.SetSaveRetry(3, time.Millisecond)
+
+	// The write is accepted, but the store knows it isn't on disk
+	if err := ms.Set("p1", "a1", "k1", "v1"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	ms.Wait()
+	if n := backend.tries.Load(); n != 3 {
+		t.Errorf("Expected 3 attempts, got %d", n)
+	}
+	health, _ := ms.Health()
+	if health.Status != sdk.HealthDegraded || len(health.SaveFailures) != 1 || health.SaveFailures[0].Error != errDiskFull.Error() {
+		t.Fatalf("Expected a degraded store reporting the failure, got %+v", health)
+	}
+
+	ms.SetRejectWritesOnSaveFailure(true)
+	if err := ms.Set("p2", "a1", "k1", "v1"); !errors.Is(err, sdk.ErrStorageFailure) {
+		t.Errorf("Expected writes to be refused, got %v", err)
+	}
+	ms.SetRejectWritesOnSaveFailure(false)
+
+	// A later successful save clears the failure
+	backend.full.Store(false)
+	if err := ms.SetSync("p1", "a1", "k2", "v2"); err != nil {
+		t.Fatalf("SetSync failed: %v", err)
+	}
+	if health, _ := ms.Health(); health.Status != sdk.HealthOK {
+		t.Errorf("Expected the store to recover, got %+v", health)
+	}
+	if backend.saved["p1"]["a1"]["k1"] != "v1" {
+		t.Errorf("Expected the earlier write to be saved too, got %v", backend.saved["p1"])
+	}
+}
+
+func TestMemStore_SaveFault(t *testing.T) {
+	backend := &memBackend{saved: make(map[string]map[string]map[string]any)}
+	ms := NewMemStore(nil, backend)
+	ms.SetSaveRetry(3, time.Millisecond)
+	var faults atomic.Int32
+	ms.SetSaveFault(func(personaID, appID string) error {
+		if faults.Add(1) == 1 {
+			return errDiskFull
+		}
+		return nil
+	})
+
+	// The first attempt fails, the retry goes through to the backend
+	ms.Set("p1", "a1", "k1", "v1")
+	ms.Wait()
+	if n := faults.Load(); n != 2 {
+		t.Errorf("Expected the fault to be consulted twice, got %d", n)
+	}
+	if backend.saved["p1"]["a1"]["k1"] != "v1" {
+		t.Errorf("Expected the value saved on retry, got %v", backend.saved["p1"])
+	}
+	if health, _ := ms.Health(); health.Status != sdk.HealthOK {
+		t.Errorf("Expected the store to be healthy, got %+v", health)
+	}
+}
+
+// blockingBackend is a memBackend whose saves wait for release.
+type blockingBackend struct {
+	memBackend
+	release chan struct{}
+	saves   atomic.Int32
+}
+
+func (b *blockingBackend) SavePersona(personaID string, data map[string]map[string]any) error {
+	<-b.release
+	b.saves.Add(1)
+	return b.memBackend.SavePersona(personaID, data)
+}
+
+func TestMemStore_SaveQueue(t *testing.T) {
+	backend := &blockingBackend{memBackend: memBackend{saved: make(map[string]map[string]map[string]any)}, release: make(chan struct{})}
+	ms := NewMemStore(nil, backend)
+	ms.SetSaveQueue(1, 2)
+
+	// p1 is being saved; writes to p2 coalesce into one queued save
+	ms.Set("p1", "a1", "k1", "v1")
+	time.Sleep(10 * time.Millisecond)
+	for i := range 10 {
+		ms.Set("p2", "a1", "k1", i)
+	}
+	ms.Set("p3", "a1", "k1", "v1")
+
+	// The queue is full, so a write to another persona waits for room
+	done := make(chan struct{})
+	go func() {
+		ms.Set("p4", "a1", "k1", "v1")
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Expected the write to wait for room in the save queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if val, _ := ms.Get("p2", "a1", "k1"); val != 9 {
+		t.Errorf("Expected reads to go on while writes wait, got %v", val)
+	}
+
+	close(backend.release)
+	<-done
+	ms.Wait()
+	if n := backend.saves.Load(); n != 4 {
+		t.Errorf("Expected one save per persona, got %d", n)
+	}
+	if backend.saved["p2"]["a1"]["k1"] != 9 {
+		t.Errorf("Expected the newest snapshot to be saved, got %v", backend.saved["p2"])
+	}
+}
+
+func TestMemStore_SetSync(t *testing.T) {
+	dir := t.TempDir()
+	p, _ := NewPersistence(dir)
+	ms := NewMemStore(nil, p)
+	defer ms.Close()
+
+	if err := ms.SetSync("p1", "a1", "k1", "v1"); err != nil {
+		t.Fatalf("SetSync failed: %v", err)
+	}
+	// The value is on disk without waiting for background saves
+	content, err := os.ReadFile(filepath.Join(dir, "p1", "a1.json"))
+	if err != nil || !strings.Contains(string(content), `"v1"`) {
+		t.Fatalf("Expected the value to be saved, got %q (%v)", content, err)
+	}
+	p.mu.Lock()
+	pending := len(p.dirty)
+	p.mu.Unlock()
+	if pending != 0 {
+		t.Errorf("Expected the write to be flushed, %d files pending", pending)
+	}
+
+	// A failed save is reported, unlike with Set
+	broken := NewMemStore(nil, &brokenBackend{})
+	if err := broken.Set("p1", "a1", "k1", "v1"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := broken.SetSync("p1", "a1", "k1", "v2"); !errors.Is(err, errDiskFull) {
+		t.Errorf("Expected the save error, got %v", err)
+	}
+}
+
+func TestMemStore_Search(t *testing.T) {
+	ms := NewMemStore(nil, nil)
+	ms.Set("alice", "billing", "stripe_token", "x")
+	ms.Set("alice", "billing", "plan", "pro")
+	ms.Set("alice", "github", "api_token", "y")
+	ms.Set("bob", "billing", "stripe_token", "z")
+	ms.Set("carol", "prefs", "theme", "dark")
+
+	all, err := ms.Search("*token*", sdk.SearchOptions{})
+	want := []sdk.SearchHit{
+		{PersonaID: "alice", AppID: "billing", Key: "stripe_token"},
+		{PersonaID: "alice", AppID: "github", Key: "api_token"},
+		{PersonaID: "bob", AppID: "billing", Key: "stripe_token"},
+	}
+	if err != nil || !reflect.DeepEqual(all.Hits, want) || all.Next != "" {
+		t.Fatalf("Expected %v, got %+v, %v", want, all, err)
+	}
+
+	// Paging
+	var paged []sdk.SearchHit
+	opts := sdk.SearchOptions{Limit: 2}
+	for pages := 0; ; pages++ {
+		res, err := ms.Search("*token*", opts)
+		if err != nil || pages > 2 {
+			t.Fatalf("Paging failed after %d pages: %v", pages, err)
+		}
+		paged = append(paged, res.Hits...)
+		if res.Next == "" {
+			break
+		}
+		opts.After = res.Next
+	}
+	if !reflect.DeepEqual(paged, want) {
+		t.Errorf("Expected pages to add up to %v, got %v", want, paged)
+	}
+
+	// App pattern
+	res, _ := ms.Search("*", sdk.SearchOptions{App: "bill*"})
+	if len(res.Hits) != 3 {
+		t.Errorf("Expected the 3 keys of billing apps, got %v", res.Hits)
+	}
+
+	if _, err := ms.Search("[", sdk.SearchOptions{}); !errors.Is(err, sdk.ErrBadRequest) {
+		t.Errorf("Expected a bad pattern to be refused, got %v", err)
+	}
+	if _, err := ms.Search("*", sdk.SearchOptions{After: "nonsense"}); !errors.Is(err, sdk.ErrBadRequest) {
+		t.Errorf("Expected a bad cursor to be refused, got %v", err)
+	}
+}
+
+func TestMemStore_Usage(t *testing.T) {
+	ms := NewMemStore(nil, nil)
+	ms.Set("bob", "prefs", "theme", "dark")
+	ms.Set("alice", "billing", "plan", "pro")
+	ms.Set("alice", "billing", "seats", 5)
+	ms.Set("alice", "github", "api_token", "y")
+
+	report, err := ms.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, app := range report.Apps {
+		order = append(order, app.PersonaID+"/"+app.AppID)
+	}
+	if want := []string{"alice/billing", "alice/github", "bob/prefs"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("Expected apps %v, got %v", want, order)
+	}
+	if report.Apps[0].Keys != 2 || report.Keys != 4 {
+		t.Errorf("Expected 2 keys in alice/billing and 4 in all, got %+v", report)
+	}
+	stats, _ := ms.Stats()
+	if report.Bytes != stats.MemoryBytes || report.Apps[0].Bytes <= report.Apps[1].Bytes {
+		t.Errorf("Expected sizes adding up to %d bytes, got %+v", stats.MemoryBytes, report)
+	}
+
+	ms.SetPersonaHasher(NewPersonaHasher(nil))
+	report, _ = ms.Usage()
+	if report.Apps[0].PersonaID == "alice" {
+		t.Error("Expected hashed persona IDs")
+	}
+}
+
+func TestMemStore_Compact(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	store.SetSync("alice", "billing", "plan", "pro")
+	store.SetSync("bob", "prefs", "theme", "dark")
+
+	// A file the store doesn't know about, e.g. left behind by an old release
+	stray := filepath.Join(dir, "alice", "stale.json")
+	content, err := os.ReadFile(filepath.Join(dir, "alice", "billing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(stray, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := store.Compact()
+	if err != nil || n != 2 {
+		t.Fatalf("Expected 2 personas rewritten, got %d, %v", n, err)
+	}
+	if _, err := os.Stat(stray); !os.IsNotExist(err) {
+		t.Errorf("Expected the stray app file to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "alice", "billing.json")); err != nil {
+		t.Errorf("Expected the billing app to stay, got %v", err)
+	}
+
+	if n, err := NewMemStore(nil, nil).Compact(); n != 0 || err != nil {
+		t.Errorf("Expected nothing to compact without a backend, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// minFilterBits is the size of a fresh per-app existence filter.
+	minFilterBits = 4096
+	// filterBitsPerKey keeps the false positive rate of the filter around 2% with filterHashes probes.
+	filterBitsPerKey = 8
+	filterHashes     = 3
+)
+
+// existenceFilter is a lock-free Bloom filter over the keys of one (persona, app).
+// It never reports a stored key as missing, which lets Get answer "key not found"
+// for hot misses without taking the store lock. Deleted keys are not removed;
+// they just fall back to the locked path until the filter is rebuilt.
+type existenceFilter struct {
+	words    []atomic.Uint64
+	inserted atomic.Int64
+	capacity int64
+}
+
+func newExistenceFilter(keys int) *existenceFilter {
+	bits := minFilterBits
+	for bits < keys*filterBitsPerKey*2 {
+		bits *= 2
+	}
+	return &existenceFilter{
+		words:    make([]atomic.Uint64, bits/64),
+		capacity: int64(bits / filterBitsPerKey),
+	}
+}
+
+func (f *existenceFilter) probes(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h1 := h.Sum64()
+	h2 := h1>>33 | h1<<31
+	return h1, h2 | 1
+}
+
+func (f *existenceFilter) add(key string) {
+	h1, h2 := f.probes(key)
+	n := uint64(len(f.words) * 64)
+	for i := uint64(0); i < filterHashes; i++ {
+		bit := (h1 + i*h2) % n
+		f.words[bit/64].Or(1 << (bit % 64))
+	}
+	f.inserted.Add(1)
+}
+
+func (f *existenceFilter) mayContain(key string) bool {
+	h1, h2 := f.probes(key)
+	n := uint64(len(f.words) * 64)
+	for i := uint64(0); i < filterHashes; i++ {
+		bit := (h1 + i*h2) % n
+		if f.words[bit/64].Load()&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *existenceFilter) full() bool {
+	return f.inserted.Load() > f.capacity
+}
+
+// existenceIndex holds one filter per (persona, app).
+type existenceIndex struct {
+	filters    sync.Map // nsKey -> *existenceFilter
+	fastMisses atomic.Uint64
+}
+
+// definitelyMissing reports whether key is known not to exist, without locking.
+// A false result means "unknown" and the caller must check the map.
+func (x *existenceIndex) definitelyMissing(personaID, appID, key string) bool {
+	f, ok := x.filters.Load(nsKey{personaID, appID})
+	if !ok || f.(*existenceFilter).mayContain(key) {
+		return false
+	}
+	x.fastMisses.Add(1)
+	return true
+}
+
+// ensure builds the filter for an app if it doesn't have one yet.
+// It must be called while holding m.mu (read or write) so appData is stable.
+func (x *existenceIndex) ensure(personaID, appID string, appData map[string]any) {
+	if _, ok := x.filters.Load(nsKey{personaID, appID}); !ok {
+		x.build(personaID, appID, appData)
+	}
+}
+
+// build (re)creates the filter for an app from its current keys.
+// It must be called while holding m.mu (read or write) so appData is stable.
+func (x *existenceIndex) build(personaID, appID string, appData map[string]any) {
+	f := newExistenceFilter(len(appData))
+	for k := range appData {
+		f.add(k)
+	}
+	x.filters.Store(nsKey{personaID, appID}, f)
+}
+
+// record adds a key to its app's filter, rebuilding the filter when it is missing
+// or saturated. It must be called while holding the write lock, before the write
+// becomes visible to readers.
+func (x *existenceIndex) record(personaID, appID, key string, appData map[string]any) {
+	f, ok := x.filters.Load(nsKey{personaID, appID})
+	if !ok || f.(*existenceFilter).full() {
+		x.build(personaID, appID, appData)
+		return
+	}
+	f.(*existenceFilter).add(key)
+}
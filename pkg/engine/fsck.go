@@ -0,0 +1,279 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// tempSuffix marks the temporary file a data file is written to before it is
+// renamed into place (see writeAppLocked).
+const tempSuffix = ".tmp"
+
+// Fsck checks the data directory of a store that isn't running, for
+// celerix-stored --fsck. With repair, it also fixes what it can (see
+// Persistence.Fsck). It fails with ErrDataDirLocked while a store has the
+// directory open.
+func Fsck(dataDir string, repair bool) (sdk.FsckReport, error) {
+	if _, err := os.Stat(dataDir); err != nil {
+		return sdk.FsckReport{}, err
+	}
+	p, err := NewPersistence(dataDir)
+	if err != nil {
+		return sdk.FsckReport{}, err
+	}
+	defer p.Close()
+	if err := p.Lock(); err != nil {
+		return sdk.FsckReport{}, err
+	}
+	return p.Fsck(repair)
+}
+
+// Fsck verifies that every data file parses and matches its checksum, and
+// reports temporary files left behind by interrupted saves and personas in
+// quarantine. With repair, an orphaned temporary file that is complete (its
+// checksum matches) holds the newest data and is renamed into place, an
+// incomplete one is removed, and a corrupt data file with nothing to restore
+// it from is moved to quarantine. Repairs must only be made while no MemStore
+// is serving the data, since it would overwrite them.
+func (p *Persistence) Fsck(repair bool) (sdk.FsckReport, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var report sdk.FsckReport
+	files, err := os.ReadDir(p.DataDir)
+	if err != nil {
+		return report, err
+	}
+
+	// Temporary files first: recovering one may replace a corrupt file.
+	for _, file := range files {
+		if personaID, ok := strings.CutSuffix(file.Name(), ".json"+tempSuffix); ok && !file.IsDir() {
+			report.Problems = append(report.Problems, p.checkTempLocked(personaID, file.Name(), repair))
+		}
+	}
+	if repair {
+		if files, err = os.ReadDir(p.DataDir); err != nil {
+			return report, err
+		}
+	}
+
+	p.loadQuarantine()
+	for _, file := range files {
+		name := file.Name()
+		switch {
+		case file.IsDir() && name != QuarantineDir && !strings.HasPrefix(name, "."):
+			apps, err := os.ReadDir(p.personaDir(name))
+			if err != nil {
+				report.Problems = append(report.Problems, sdk.FsckProblem{PersonaID: name, File: name, Kind: sdk.FsckUnreadable, Detail: p.scrub(err).Error()})
+				continue
+			}
+			for _, app := range apps {
+				if !app.IsDir() && strings.HasSuffix(app.Name(), ".json"+tempSuffix) {
+					report.Problems = append(report.Problems, p.checkTempLocked(name, filepath.Join(name, app.Name()), repair))
+				}
+			}
+			for _, app := range apps {
+				if app.IsDir() || filepath.Ext(app.Name()) != ".json" {
+					continue
+				}
+				report.Files++
+				if problem, ok := p.checkFileLocked(name, filepath.Join(name, app.Name()), repair, func(content []byte) error {
+					_, err := decodeAppFile(content, false)
+					return err
+				}); !ok {
+					report.Problems = append(report.Problems, problem)
+				}
+			}
+
+		case !file.IsDir() && filepath.Ext(name) == ".json":
+			// A leftover single-file persona (see LoadAll)
+			report.Files++
+			personaID := strings.TrimSuffix(name, ".json")
+			if problem, ok := p.checkFileLocked(personaID, name, repair, func(content []byte) error {
+				_, err := decodePersonaFile(content, false)
+				return err
+			}); !ok {
+				report.Problems = append(report.Problems, problem)
+			}
+		}
+	}
+
+	reported := make(map[string]bool)
+	for _, problem := range report.Problems {
+		if problem.Repair == "moved to quarantine" {
+			reported[problem.PersonaID] = true
+		}
+	}
+	for _, q := range p.Quarantined() {
+		if reported[q.PersonaID] {
+			continue // Quarantined just now
+		}
+		report.Problems = append(report.Problems, sdk.FsckProblem{PersonaID: q.PersonaID, File: q.File, Kind: sdk.FsckQuarantined, Detail: q.Reason})
+	}
+	return report, nil
+}
+
+// checkFileLocked reads and decodes a data file. It reports false with the
+// problem if that fails, after moving the file to quarantine if repair is set.
+// It MUST be called while holding p.mu.
+func (p *Persistence) checkFileLocked(personaID, relPath string, repair bool, decode func([]byte) error) (sdk.FsckProblem, bool) {
+	problem := sdk.FsckProblem{PersonaID: personaID, File: relPath}
+	content, err := os.ReadFile(filepath.Join(p.DataDir, relPath))
+	if err != nil {
+		problem.Kind, problem.Detail = sdk.FsckUnreadable, p.scrub(err).Error()
+		return problem, false
+	}
+	if err = decode(content); err == nil {
+		return problem, true
+	}
+
+	problem.Kind, problem.Detail = sdk.FsckCorrupt, err.Error()
+	if errors.Is(err, errChecksumMismatch) {
+		problem.Kind = sdk.FsckChecksum
+	}
+	if repair {
+		p.quarantineFile(personaID, relPath, err)
+		problem.Repair = "moved to quarantine"
+	}
+	return problem, false
+}
+
+// checkTempLocked reports an orphaned temporary file. Writing it finished if
+// its checksum footer is present and matches, in which case it is newer than
+// the file it was meant to replace; otherwise it holds nothing worth keeping.
+// With repair, it is renamed into place or removed accordingly.
+// It MUST be called while holding p.mu.
+func (p *Persistence) checkTempLocked(personaID, relPath string, repair bool) sdk.FsckProblem {
+	problem := sdk.FsckProblem{PersonaID: personaID, File: relPath, Kind: sdk.FsckOrphanedTemp}
+	path := filepath.Join(p.DataDir, relPath)
+	target := strings.TrimSuffix(path, tempSuffix)
+
+	if err := checkTemp(path); err != nil {
+		problem.Detail = fmt.Sprintf("incomplete: %v", err)
+		if repair {
+			if err := os.Remove(path); err != nil {
+				problem.Detail += fmt.Sprintf("; could not remove it: %v", p.scrub(err))
+			} else {
+				problem.Repair = "removed"
+			}
+		}
+		return problem
+	}
+
+	problem.Detail = "complete, newer than " + filepath.Base(target)
+	if repair {
+		if err := os.Rename(path, target); err != nil {
+			problem.Detail += fmt.Sprintf("; could not restore it: %v", p.scrub(err))
+		} else {
+			problem.Repair = "restored as " + filepath.Base(target)
+			if err := p.syncDirAfterRename(filepath.Dir(target)); err != nil {
+				problem.Detail += fmt.Sprintf("; could not sync the directory: %v", p.scrub(err))
+			}
+		}
+	}
+	return problem
+}
+
+// recoverTempFilesLocked deals with the files left behind by saves interrupted
+// by a crash, logging what it decided: a complete temporary data file holds the
+// newest data and replaces the file it was meant for, an incomplete one is
+// removed (see checkTempLocked), and so are blob uploads that never finished.
+// It MUST be called while holding p.mu.
+func (p *Persistence) recoverTempFilesLocked() {
+	files, err := os.ReadDir(p.DataDir)
+	if err != nil {
+		return // LoadAll reports it
+	}
+	for _, file := range files {
+		name := file.Name()
+		if personaID, ok := strings.CutSuffix(name, ".json"+tempSuffix); ok && !file.IsDir() {
+			p.logTempRecovery(p.checkTempLocked(personaID, name, true))
+			continue
+		}
+		if !file.IsDir() || name == QuarantineDir || strings.HasPrefix(name, ".") {
+			continue
+		}
+		apps, _ := os.ReadDir(p.personaDir(name))
+		for _, app := range apps {
+			switch {
+			case !app.IsDir() && strings.HasSuffix(app.Name(), ".json"+tempSuffix):
+				p.logTempRecovery(p.checkTempLocked(name, filepath.Join(name, app.Name()), true))
+			case app.IsDir() && strings.HasSuffix(app.Name(), ".blobs"):
+				p.removeStaleUploads(name, filepath.Join(p.personaDir(name), app.Name()))
+			}
+		}
+	}
+}
+
+// logTempRecovery logs what became of a temporary file found at startup.
+func (p *Persistence) logTempRecovery(problem sdk.FsckProblem) {
+	file := strings.TrimSuffix(filepath.Base(problem.File), tempSuffix)
+	switch {
+	case strings.HasPrefix(problem.Repair, "restored"):
+		log.Printf("Recovered %s of persona %s from a save interrupted by a crash", file, p.hasher.ID(problem.PersonaID))
+	case problem.Repair != "":
+		log.Printf("Discarded an incomplete save of %s of persona %s (%s)", file, p.hasher.ID(problem.PersonaID), problem.Detail)
+	default:
+		log.Printf("Warning: Could not clean up an interrupted save of %s of persona %s: %s", file, p.hasher.ID(problem.PersonaID), problem.Detail)
+	}
+}
+
+// removeStaleUploads removes the temporary files of blob uploads that were
+// never renamed into place (see WriteBlob). Nobody was told they succeeded.
+func (p *Persistence) removeStaleUploads(personaID, dir string) {
+	files, _ := os.ReadDir(dir)
+	removed := 0
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), ".upload-") && strings.HasSuffix(file.Name(), tempSuffix) {
+			if err := os.Remove(filepath.Join(dir, file.Name())); err != nil {
+				log.Printf("Warning: Could not remove an unfinished blob upload of persona %s: %v", p.hasher.ID(personaID), p.scrub(err))
+				continue
+			}
+			removed++
+		}
+	}
+	if removed > 0 {
+		log.Printf("Discarded %d unfinished blob uploads of persona %s", removed, p.hasher.ID(personaID))
+	}
+}
+
+// checkTemp reports why a temporary data file wasn't written in full: it can't
+// be read, lacks its checksum footer or doesn't match it.
+func checkTemp(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(content, []byte(checksumFooter)) {
+		return errors.New("no checksum footer")
+	}
+	var v any
+	return decodeDataFile(content, &v, false)
+}
+
+// Fsck checks the files of the storage backend, if it supports that, without
+// repairing anything: the data in memory would overwrite repairs. With hashed
+// persona IDs, file names are left out.
+func (m *MemStore) Fsck() (sdk.FsckReport, error) {
+	checker, ok := m.persister.(interface {
+		Fsck(repair bool) (sdk.FsckReport, error)
+	})
+	if !ok {
+		return sdk.FsckReport{}, fmt.Errorf("fsck: %w", sdk.ErrNotSupported)
+	}
+	report, err := checker.Fsck(false)
+	if m.hasher != nil {
+		for i := range report.Problems {
+			report.Problems[i].PersonaID = m.hasher.ID(report.Problems[i].PersonaID)
+			report.Problems[i].File = ""
+		}
+	}
+	return report, err
+}
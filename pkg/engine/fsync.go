@@ -0,0 +1,178 @@
+package engine
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// FsyncPolicy controls when Persistence forces written files to stable storage.
+// Renaming a freshly written file is atomic but not durable: without fsync a
+// power loss can still lose the most recent writes.
+type FsyncPolicy string
+
+const (
+	// FsyncNever leaves flushing to the operating system (the default, and the fastest).
+	FsyncNever FsyncPolicy = "never"
+	// FsyncInterval flushes files written since the last flush in the background,
+	// bounding data loss to roughly one interval.
+	FsyncInterval FsyncPolicy = "interval"
+	// FsyncAlways flushes every file and its directory before a save returns.
+	FsyncAlways FsyncPolicy = "always"
+)
+
+// DefaultFsyncInterval is used by FsyncInterval when no interval is given.
+const DefaultFsyncInterval = time.Second
+
+// ParseFsyncPolicy parses a CELERIX_FSYNC value. An empty string means FsyncNever.
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+	switch FsyncPolicy(s) {
+	case "", FsyncNever:
+		return FsyncNever, nil
+	case FsyncInterval, FsyncAlways:
+		return FsyncPolicy(s), nil
+	}
+	return "", fmt.Errorf("invalid fsync policy %q (want always, interval or never)", s)
+}
+
+// SetFsyncPolicy changes the durability policy. interval only applies to
+// FsyncInterval; zero or less uses DefaultFsyncInterval.
+func (p *Persistence) SetFsyncPolicy(policy FsyncPolicy, interval time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopFlusherLocked()
+	p.fsync = policy
+	if policy != FsyncInterval {
+		return
+	}
+
+	if interval <= 0 {
+		interval = DefaultFsyncInterval
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	p.flushStop, p.flushDone = stop, done
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.mu.Lock()
+				p.flushLocked()
+				p.mu.Unlock()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// stopFlusherLocked stops the FsyncInterval goroutine and flushes what it
+// hadn't gotten to yet. It MUST be called while holding p.mu.
+func (p *Persistence) stopFlusherLocked() {
+	if p.flushStop == nil {
+		return
+	}
+	close(p.flushStop)
+	p.mu.Unlock()
+	<-p.flushDone // The flusher may be waiting for p.mu
+	p.mu.Lock()
+	p.flushStop, p.flushDone = nil, nil
+	p.flushLocked()
+}
+
+// syncWrite applies the fsync policy to a data file that is about to be
+// renamed into place at path. Files that aren't synced right away are
+// remembered for the interval flusher and Flush. It MUST be called while
+// holding p.mu.
+func (p *Persistence) syncWrite(file *os.File, path string) error {
+	switch p.fsync {
+	case FsyncAlways:
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	default:
+		if p.dirty == nil {
+			p.dirty = make(map[string]struct{})
+		}
+		p.dirty[path] = struct{}{}
+	}
+	return nil
+}
+
+// syncDirAfterRename makes a rename in dir durable under FsyncAlways. The
+// interval flusher syncs directories itself. It MUST be called while holding p.mu.
+func (p *Persistence) syncDirAfterRename(dir string) error {
+	if p.fsync != FsyncAlways {
+		return nil
+	}
+	return syncDir(dir)
+}
+
+// Flush fsyncs every data file written since the last flush, whatever the
+// fsync policy, so everything saved so far survives a power loss.
+func (p *Persistence) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.flushLocked()
+}
+
+// flushLocked fsyncs every file written since the last flush, then the
+// directories holding them so the renames survive too, and returns the first
+// error. It MUST be called while holding p.mu.
+func (p *Persistence) flushLocked() error {
+	if len(p.dirty) == 0 {
+		return nil
+	}
+	var firstErr error
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	dirs := make(map[string]struct{})
+	for path := range p.dirty {
+		dirs[filepath.Dir(path)] = struct{}{}
+		f, err := os.Open(path)
+		if err != nil {
+			continue // Deleted since it was written
+		}
+		if err := f.Sync(); err != nil {
+			log.Printf("Warning: Could not fsync data file: %v", p.scrub(err))
+			fail(err)
+		}
+		f.Close()
+	}
+	for dir := range dirs {
+		if err := syncDir(dir); err != nil {
+			log.Printf("Warning: Could not fsync data directory: %v", p.scrub(err))
+			fail(err)
+		}
+	}
+	// New persona directories must also be recorded in the data directory itself.
+	if err := syncDir(p.DataDir); err != nil {
+		log.Printf("Warning: Could not fsync data directory: %v", p.scrub(err))
+		fail(err)
+	}
+	p.dirty = nil
+	return firstErr
+}
+
+// syncDir makes renames in dir durable. Windows doesn't support fsync on
+// directories, and NTFS journals renames anyway.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,119 @@
+package engine
+
+import "github.com/celerix-dev/celerix-store/pkg/sdk"
+
+// BeforeSetHook is called before a value is written and vetoes the write by
+// returning an error, which the write returns unchanged.
+type BeforeSetHook func(personaID, appID, key string, val any) error
+
+// AfterSetHook is called after a value is written.
+type AfterSetHook func(personaID, appID, key string, val any)
+
+// BeforeDeleteHook is called before a key is deleted and vetoes the delete by
+// returning an error, which the delete returns unchanged.
+type BeforeDeleteHook func(personaID, appID, key string) error
+
+// DeleteHook is called after a key is deleted.
+type DeleteHook func(personaID, appID, key string)
+
+// PurgeHook is called after a persona is purged, to erase the copies of it
+// kept outside the store, such as snapshots.
+type PurgeHook func(personaID string) error
+
+// hookSet holds the hooks of a MemStore. It is guarded by MemStore.mu: hooks
+// are added under the write lock and run under it.
+type hookSet struct {
+	beforeSet    []BeforeSetHook
+	afterSet     []AfterSetHook
+	beforeDelete []BeforeDeleteHook
+	afterDelete  []DeleteHook
+	purge        []PurgeHook
+	transformers []appTransformer // see AddTransformer
+}
+
+// OnBeforeSet adds a hook that may veto writes: Set and its conditional
+// variants, Merge, SetBatch, queue operations and the destination of moves and
+// persona merges. A batch stops at the first vetoed record, like one failing
+// its schema.
+//
+// Hooks run in the order they were added, while the store holds its write
+// lock, so they see writes in the order they are applied. They must be quick,
+// must not modify val and must not call the store.
+func (m *MemStore) OnBeforeSet(hook BeforeSetHook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks.beforeSet = append(m.hooks.beforeSet, hook)
+}
+
+// OnAfterSet adds a hook called after every write, under the same rules as
+// OnBeforeSet. Keys written by a move or a persona merge are included.
+func (m *MemStore) OnAfterSet(hook AfterSetHook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks.afterSet = append(m.hooks.afterSet, hook)
+}
+
+// OnBeforeDelete adds a hook that may veto deletes: Delete and its
+// conditional variants, DeletePrefix (which deletes nothing if any key is
+// vetoed) and the source of moves and persona merges. PurgePersona can't be
+// vetoed. Hooks run under the rules of OnBeforeSet.
+func (m *MemStore) OnBeforeDelete(hook BeforeDeleteHook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks.beforeDelete = append(m.hooks.beforeDelete, hook)
+}
+
+// OnDelete adds a hook called after every deleted key, including those of
+// moved, merged and purged personas, under the rules of OnBeforeSet.
+func (m *MemStore) OnDelete(hook DeleteHook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks.afterDelete = append(m.hooks.afterDelete, hook)
+}
+
+// OnPurge adds a hook called after PurgePersona erased a persona. Unlike the
+// other hooks, purge hooks run after the store released its lock, since they
+// may be slow, and may call the store. PurgePersona runs every hook and
+// returns their errors.
+func (m *MemStore) OnPurge(hook PurgeHook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks.purge = append(m.hooks.purge, hook)
+}
+
+// vetoSetLocked runs the OnBeforeSet hooks. It MUST be called while holding
+// m.mu.Lock.
+func (m *MemStore) vetoSetLocked(personaID, appID, key string, val any) error {
+	for _, hook := range m.hooks.beforeSet {
+		if err := hook(personaID, appID, key, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vetoDeleteLocked runs the OnBeforeDelete hooks. It MUST be called while
+// holding m.mu.Lock.
+func (m *MemStore) vetoDeleteLocked(personaID, appID, key string) error {
+	for _, hook := range m.hooks.beforeDelete {
+		if err := hook(personaID, appID, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runAfterHooksLocked runs the hooks for a change the store just applied. It
+// MUST be called while holding m.mu.Lock.
+func (m *MemStore) runAfterHooksLocked(op, personaID, appID, key string, val any) {
+	switch op {
+	case sdk.OpSet:
+		for _, hook := range m.hooks.afterSet {
+			hook(personaID, appID, key, val)
+		}
+	case sdk.OpDelete:
+		for _, hook := range m.hooks.afterDelete {
+			hook(personaID, appID, key)
+		}
+	}
+}
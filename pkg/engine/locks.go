@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// lockKey identifies a named lock within a persona and app.
+type lockKey struct {
+	personaID, appID, name string
+}
+
+type heldLease struct {
+	token   string
+	expires time.Time
+}
+
+// leaseTable holds the live leases. Leases are kept in memory only: after a
+// restart every lock is free.
+type leaseTable struct {
+	mu     sync.Mutex
+	leases map[lockKey]heldLease
+}
+
+// Lock acquires a named lock for ttl, or returns sdk.ErrLockHeld while another
+// holder's lease is live.
+func (m *MemStore) Lock(personaID, appID, name string, ttl time.Duration) (sdk.Lease, error) {
+	if err := checkIDs(personaID, appID, name); err != nil {
+		return sdk.Lease{}, err
+	}
+	if ttl <= 0 {
+		return sdk.Lease{}, fmt.Errorf("lease ttl must be positive: %w", sdk.ErrBadRequest)
+	}
+	token, err := randomID()
+	if err != nil {
+		return sdk.Lease{}, err
+	}
+
+	t := &m.leases
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	k := lockKey{personaID, appID, name}
+	if held, ok := t.leases[k]; ok && now.Before(held.expires) {
+		return sdk.Lease{}, sdk.ErrLockHeld
+	}
+	if t.leases == nil {
+		t.leases = make(map[lockKey]heldLease)
+	}
+	for other, held := range t.leases {
+		if !now.Before(held.expires) {
+			delete(t.leases, other) // Nobody renewed it; don't keep it forever
+		}
+	}
+	held := heldLease{token: token, expires: now.Add(ttl)}
+	t.leases[k] = held
+	return sdk.Lease{PersonaID: personaID, AppID: appID, Name: name, Token: held.token, ExpiresAt: held.expires.UTC()}, nil
+}
+
+// Renew extends a lease to ttl from now. A lease that expired can still be
+// renewed as long as nobody else has taken the lock since.
+func (m *MemStore) Renew(lease sdk.Lease, ttl time.Duration) (sdk.Lease, error) {
+	if ttl <= 0 {
+		return sdk.Lease{}, fmt.Errorf("lease ttl must be positive: %w", sdk.ErrBadRequest)
+	}
+	t := &m.leases
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	k := lockKey{lease.PersonaID, lease.AppID, lease.Name}
+	held, ok := t.leases[k]
+	if !ok || held.token != lease.Token {
+		return sdk.Lease{}, sdk.ErrLeaseLost
+	}
+	held.expires = time.Now().Add(ttl)
+	t.leases[k] = held
+	lease.ExpiresAt = held.expires.UTC()
+	return lease, nil
+}
+
+// Unlock releases a lease.
+func (m *MemStore) Unlock(lease sdk.Lease) error {
+	t := &m.leases
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	k := lockKey{lease.PersonaID, lease.AppID, lease.Name}
+	if held, ok := t.leases[k]; !ok || held.token != lease.Token {
+		return sdk.ErrLeaseLost
+	}
+	delete(t.leases, k)
+	return nil
+}
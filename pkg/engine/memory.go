@@ -0,0 +1,429 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// DefaultTopMemory is the number of namespaces reported by Stats as the largest.
+const DefaultTopMemory = 10
+
+// EvictionPolicy decides what happens when a write would take the store past its memory limit.
+type EvictionPolicy string
+
+const (
+	// EvictReject refuses writes that would exceed the limit with ErrMemoryLimit.
+	EvictReject EvictionPolicy = "reject"
+	// EvictEphemeral drops the data of apps marked ephemeral (see SetEphemeralApps),
+	// largest first, from memory and from the backend.
+	EvictEphemeral EvictionPolicy = "ephemeral"
+	// EvictLRU unloads the least recently used personas from memory. Their data
+	// stays in the backend and is loaded again on the next access, so the backend
+	// must implement PersonaLoader.
+	EvictLRU EvictionPolicy = "lru"
+)
+
+// ParseEvictionPolicy parses a CELERIX_EVICTION value. An empty string means EvictReject.
+func ParseEvictionPolicy(s string) (EvictionPolicy, error) {
+	switch EvictionPolicy(s) {
+	case "":
+		return EvictReject, nil
+	case EvictReject, EvictEphemeral, EvictLRU:
+		return EvictionPolicy(s), nil
+	}
+	return "", fmt.Errorf("invalid eviction policy %q (want reject, ephemeral or lru)", s)
+}
+
+// ParseByteSize parses sizes such as "512MB", "2GiB" or a plain number of bytes.
+func ParseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+		{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	}
+	factor := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, factor = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * factor, nil
+}
+
+// memoryAccounting tracks the approximate size of the data per (persona, app).
+// Fields without their own lock are guarded by MemStore.mu.
+type memoryAccounting struct {
+	limit     int64
+	policy    EvictionPolicy
+	ephemeral map[string]bool
+
+	used    int64
+	byNS    map[nsKey]int64
+	evicted map[string]struct{} // Personas unloaded by EvictLRU, still held by the backend
+
+	evictions atomic.Uint64
+	trackUse  atomic.Bool // Set under EvictLRU, read without m.mu
+	lastUse   sync.Map    // personaID -> *atomic.Int64 (unix nanoseconds)
+
+	savingMu sync.Mutex
+	saving   map[string]int // Background saves in flight per persona
+}
+
+// Approximate per-item overheads of Go's map, string and interface representations.
+const (
+	sizeEntry  = 48
+	sizeString = 16
+	sizeScalar = 16
+	sizeMap    = 48
+	sizeSlice  = 24
+)
+
+// valueSize estimates the memory held by a decoded JSON value.
+func valueSize(v any) int64 {
+	switch val := v.(type) {
+	case string:
+		return sizeString + int64(len(val))
+	case json.Number:
+		return sizeString + int64(len(val))
+	case map[string]any:
+		size := int64(sizeMap)
+		for k, item := range val {
+			size += sizeEntry + int64(len(k)) + valueSize(item)
+		}
+		return size
+	case []any:
+		size := int64(sizeSlice)
+		for _, item := range val {
+			size += sizeScalar + valueSize(item)
+		}
+		return size
+	default:
+		return sizeScalar
+	}
+}
+
+func entrySize(key string, val any) int64 {
+	return sizeEntry + int64(len(key)) + valueSize(val)
+}
+
+// accountLocked adds delta bytes to an app. It MUST be called while holding m.mu.Lock.
+func (m *MemStore) accountLocked(personaID, appID string, delta int64) {
+	if delta == 0 {
+		return
+	}
+	if m.memory.byNS == nil {
+		m.memory.byNS = make(map[nsKey]int64)
+	}
+	k := nsKey{personaID, appID}
+	m.memory.byNS[k] += delta
+	if m.memory.byNS[k] <= 0 {
+		delete(m.memory.byNS, k)
+		m.raw.forgetApp(k) // The app was emptied or unloaded
+	}
+	m.memory.used += delta
+}
+
+// accountPersonaLocked records the size of a whole persona that was loaded into memory.
+// It MUST be called while holding m.mu.Lock.
+func (m *MemStore) accountPersonaLocked(personaID string, apps map[string]map[string]any) {
+	for appID, appData := range apps {
+		var size int64
+		for k, v := range appData {
+			size += entrySize(k, v)
+		}
+		m.accountLocked(personaID, appID, size)
+	}
+}
+
+// SetMemoryLimit caps the approximate size of the data held in memory. A limit
+// of zero or less removes the cap. EvictLRU requires a backend that implements
+// PersonaLoader, since unloaded personas must be read back on demand.
+func (m *MemStore) SetMemoryLimit(limit int64, policy EvictionPolicy) error {
+	if policy == EvictLRU {
+		if _, ok := m.persister.(PersonaLoader); !ok {
+			return fmt.Errorf("eviction policy %s needs a backend that can load single personas", policy)
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.memory.limit, m.memory.policy = limit, policy
+	m.memory.trackUse.Store(limit > 0 && policy == EvictLRU)
+	return nil
+}
+
+// SetEphemeralApps marks apps whose data may be discarded under EvictEphemeral,
+// such as caches that can be rebuilt.
+func (m *MemStore) SetEphemeralApps(appIDs ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.memory.ephemeral = make(map[string]bool, len(appIDs))
+	for _, appID := range appIDs {
+		m.memory.ephemeral[appID] = true
+	}
+}
+
+// reserveLocked makes room for a write of extra bytes to an app, evicting per
+// the policy, and returns ErrMemoryLimit if there still isn't enough.
+// The app being written is never evicted. It MUST be called while holding m.mu.Lock.
+func (m *MemStore) reserveLocked(personaID, appID string, extra int64) error {
+	mem := &m.memory
+	if mem.limit <= 0 || extra <= 0 || mem.used+extra <= mem.limit {
+		return nil
+	}
+	need := mem.used + extra - mem.limit
+	switch mem.policy {
+	case EvictEphemeral:
+		m.evictEphemeralLocked(need, nsKey{personaID, appID})
+	case EvictLRU:
+		m.evictLRULocked(need, personaID)
+	}
+	if mem.used+extra > mem.limit {
+		return ErrMemoryLimit
+	}
+	return nil
+}
+
+// evictEphemeralLocked discards ephemeral apps, largest first, until need bytes
+// are freed. It MUST be called while holding m.mu.Lock.
+func (m *MemStore) evictEphemeralLocked(need int64, keep nsKey) {
+	var candidates []nsKey
+	for k := range m.memory.byNS {
+		if m.memory.ephemeral[k.appID] && k != keep {
+			candidates = append(candidates, k)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return m.memory.byNS[candidates[i]] > m.memory.byNS[candidates[j]]
+	})
+
+	touched := make(map[string]struct{})
+	for _, k := range candidates {
+		if need <= 0 {
+			break
+		}
+		if m.quarantined(k.personaID) {
+			continue
+		}
+		size := m.memory.byNS[k]
+		delete(m.data[k.personaID], k.appID)
+		m.accountLocked(k.personaID, k.appID, -size)
+		m.memory.evictions.Add(1)
+		touched[k.personaID] = struct{}{}
+		need -= size
+	}
+	// Saving the whole persona also removes the evicted apps from the backend.
+	for personaID := range touched {
+		m.persistAsync(personaID, m.copyPersonaData(personaID))
+	}
+}
+
+// evictLRULocked unloads the least recently used personas until need bytes are
+// freed. Personas with saves in flight are skipped: reloading them before the
+// save lands would read stale data. So are personas whose saves are failing,
+// whose latest data exists only in memory. It MUST be called while holding m.mu.Lock.
+func (m *MemStore) evictLRULocked(need int64, keep string) {
+	type candidate struct {
+		personaID string
+		lastUse   int64
+		size      int64
+	}
+	sizes := make(map[string]int64)
+	for k, size := range m.memory.byNS {
+		sizes[k.personaID] += size
+	}
+	var candidates []candidate
+	for personaID, size := range sizes {
+		if personaID == keep || m.savesPending(personaID) || m.saveFailing(personaID) || m.quarantined(personaID) {
+			continue
+		}
+		candidates = append(candidates, candidate{personaID, m.lastUsed(personaID), size})
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].lastUse < candidates[j].lastUse })
+
+	for _, c := range candidates {
+		if need <= 0 {
+			break
+		}
+		for appID := range m.data[c.personaID] {
+			m.accountLocked(c.personaID, appID, -m.memory.byNS[nsKey{c.personaID, appID}])
+		}
+		delete(m.data, c.personaID)
+		if m.memory.evicted == nil {
+			m.memory.evicted = make(map[string]struct{})
+		}
+		m.memory.evicted[c.personaID] = struct{}{}
+		m.memory.evictions.Add(1)
+		need -= c.size
+	}
+}
+
+// admitLocked prepares a write of val to key: it loads the persona back if it
+// was evicted and makes room for the growth. It MUST be called while holding m.mu.Lock.
+func (m *MemStore) admitLocked(personaID, appID, key string, val any) error {
+	m.touch(personaID)
+	if err := m.residentLocked(personaID); err != nil {
+		return err
+	}
+	extra := entrySize(key, val)
+	if old, ok := m.data[personaID][appID][key]; ok {
+		extra -= entrySize(key, old)
+	}
+	return m.reserveLocked(personaID, appID, extra)
+}
+
+// rlockResident takes the read lock, first loading personaID back into memory
+// if EvictLRU had unloaded it. The persona may be evicted again between the
+// reload and taking the read lock, so it reloads until the persona is
+// resident while the lock is held, or can't be loaded, in which case it reads
+// as missing.
+func (m *MemStore) rlockResident(personaID string) {
+	m.touch(personaID)
+	m.mu.RLock()
+	for m.isEvicted(personaID) {
+		m.mu.RUnlock()
+		err := m.reload(personaID)
+		m.mu.RLock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// scanEvictedLocked reads evicted personas straight from the backend, without
+// loading them back into memory, for scans across all personas. fn returns
+// false to stop. It MUST be called while holding m.mu (read or write).
+func (m *MemStore) scanEvictedLocked(fn func(personaID string, apps map[string]map[string]any) bool) {
+	loader, ok := m.persister.(PersonaLoader)
+	if !ok {
+		return
+	}
+	for personaID := range m.memory.evicted {
+		apps, err := loader.LoadPersona(personaID)
+		if err != nil {
+			continue
+		}
+		if !fn(personaID, apps) {
+			return
+		}
+	}
+}
+
+// isEvicted reports whether a persona was unloaded by EvictLRU. It MUST be
+// called while holding m.mu (read or write).
+func (m *MemStore) isEvicted(personaID string) bool {
+	_, ok := m.memory.evicted[personaID]
+	return ok
+}
+
+// residentLocked loads a persona unloaded by EvictLRU back into memory. It MUST
+// be called while holding m.mu.Lock.
+func (m *MemStore) residentLocked(personaID string) error {
+	if !m.isEvicted(personaID) {
+		return nil
+	}
+	loader, ok := m.persister.(PersonaLoader)
+	if !ok {
+		return ErrPersonaNotFound
+	}
+	data, err := loader.LoadPersona(personaID)
+	if err != nil {
+		return err
+	}
+	delete(m.memory.evicted, personaID)
+	m.data[personaID] = data
+	m.accountPersonaLocked(personaID, data)
+	return nil
+}
+
+// reload makes an evicted persona resident for a reader that found it missing,
+// returning the error if it can't be loaded.
+func (m *MemStore) reload(personaID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.residentLocked(personaID)
+}
+
+// touch records an access for EvictLRU.
+func (m *MemStore) touch(personaID string) {
+	if !m.memory.trackUse.Load() {
+		return
+	}
+	now := time.Now().UnixNano()
+	if t, ok := m.memory.lastUse.Load(personaID); ok {
+		t.(*atomic.Int64).Store(now)
+		return
+	}
+	t := &atomic.Int64{}
+	t.Store(now)
+	m.memory.lastUse.Store(personaID, t)
+}
+
+func (m *MemStore) lastUsed(personaID string) int64 {
+	if t, ok := m.memory.lastUse.Load(personaID); ok {
+		return t.(*atomic.Int64).Load()
+	}
+	return 0
+}
+
+// beginSave and endSave bracket a background save of a persona.
+func (m *MemStore) beginSave(personaID string) {
+	m.memory.savingMu.Lock()
+	defer m.memory.savingMu.Unlock()
+	if m.memory.saving == nil {
+		m.memory.saving = make(map[string]int)
+	}
+	m.memory.saving[personaID]++
+}
+
+func (m *MemStore) endSave(personaID string) {
+	m.memory.savingMu.Lock()
+	defer m.memory.savingMu.Unlock()
+	if m.memory.saving[personaID]--; m.memory.saving[personaID] <= 0 {
+		delete(m.memory.saving, personaID)
+	}
+}
+
+func (m *MemStore) savesPending(personaID string) bool {
+	m.memory.savingMu.Lock()
+	defer m.memory.savingMu.Unlock()
+	return m.memory.saving[personaID] > 0
+}
+
+// memoryStats fills in the memory section of Stats. It MUST be called while
+// holding m.mu (read or write).
+func (m *MemStore) memoryStats(stats *sdk.Stats) {
+	stats.MemoryBytes = m.memory.used
+	stats.MemoryLimit = m.memory.limit
+	if m.memory.limit > 0 {
+		stats.EvictionPolicy = string(m.memory.policy)
+	}
+	stats.Evictions = m.memory.evictions.Load()
+	stats.EvictedPersonas = len(m.memory.evicted)
+
+	list := make([]sdk.NamespaceMemory, 0, len(m.memory.byNS))
+	for k, size := range m.memory.byNS {
+		list = append(list, sdk.NamespaceMemory{PersonaID: m.hasher.ID(k.personaID), AppID: k.appID, Bytes: size})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Bytes > list[j].Bytes })
+	if len(list) > DefaultTopMemory {
+		list = list[:DefaultTopMemory]
+	}
+	stats.TopMemory = list
+}
@@ -1,7 +1,10 @@
 package engine
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/celerix-dev/celerix-store/internal/vault"
@@ -14,21 +17,65 @@ type MemStore struct {
 	mu sync.RWMutex
 	// Structure: [personaID][appID][key]value
 	data      map[string]map[string]map[string]any
-	persister *Persistence
+	persister StorageBackend
 	wg        sync.WaitGroup
+	saves     saveOrder
+
+	contention contentionTracker
+	events     broker
+	existence  existenceIndex
+	memory     memoryAccounting
+	leases     leaseTable
+	hasher     *PersonaHasher
+	hooks      hookSet
+	raw        rawIndex
+	failures   saveFailures
+	queue      saveQueue
+	tracer     sdk.Tracer // nil unless SetTracer is used
+
+	preciseNumbers bool // see SetPreciseNumbers
+	deepCopy       bool // see SetDeepCopy
 }
 
 // NewMemStore initializes a store.
-// It accepts existing data (from LoadAll) and a persister.
-func NewMemStore(initialData map[string]map[string]map[string]any, p *Persistence) *MemStore {
+// It accepts existing data (from LoadAll) and a storage backend, which may be nil
+// for a purely in-memory store.
+func NewMemStore(initialData map[string]map[string]map[string]any, p StorageBackend) *MemStore {
 	if initialData == nil {
 		initialData = make(map[string]map[string]map[string]any)
 	}
-	return &MemStore{
+	if pp, ok := p.(*Persistence); ok && pp == nil {
+		p = nil // A nil *Persistence means "no persistence", not a broken backend
+	}
+	m := &MemStore{
 		data:      initialData,
 		persister: p,
 		wg:        sync.WaitGroup{},
 	}
+	m.queue.room.L = &m.queue.mu
+	for personaID, apps := range initialData {
+		m.accountPersonaLocked(personaID, apps)
+	}
+	return m
+}
+
+// SetPersonaHasher makes the store report hashed persona IDs in statistics
+// (and in its persister's logs) instead of raw identifiers.
+func (m *MemStore) SetPersonaHasher(h *PersonaHasher) {
+	m.hasher = h
+	if hp, ok := m.persister.(interface{ SetPersonaHasher(*PersonaHasher) }); ok {
+		hp.SetPersonaHasher(h)
+	}
+}
+
+// SetPreciseNumbers keeps numbers as json.Number instead of float64 where the
+// store decodes values itself: in queues and (with a persister supporting it)
+// when loading data files. Set it before the store is used.
+func (m *MemStore) SetPreciseNumbers(enabled bool) {
+	m.preciseNumbers = enabled
+	if pp, ok := m.persister.(interface{ SetPreciseNumbers(bool) }); ok {
+		pp.SetPreciseNumbers(enabled)
+	}
 }
 
 // Wait waits for all background persistence tasks to complete.
@@ -36,11 +83,25 @@ func (m *MemStore) Wait() {
 	m.wg.Wait()
 }
 
+// Close waits for pending writes and closes the storage backend.
+func (m *MemStore) Close() error {
+	m.wg.Wait()
+	if m.persister != nil {
+		return m.persister.Close()
+	}
+	return nil
+}
+
 // --- Interface Implementation ---
 
 // Get retrieves a value for a specific persona, app, and key.
 func (m *MemStore) Get(personaID, appID, key string) (any, error) {
-	m.mu.RLock()
+	// Hot misses are answered from the existence filter without taking the lock.
+	if m.existence.definitelyMissing(personaID, appID, key) {
+		return nil, ErrKeyNotFound
+	}
+
+	m.rlockResident(personaID)
 	defer m.mu.RUnlock()
 
 	persona, ok := m.data[personaID]
@@ -55,63 +116,398 @@ func (m *MemStore) Get(personaID, appID, key string) (any, error) {
 
 	val, ok := app[key]
 	if !ok {
+		m.existence.ensure(personaID, appID, app)
 		return nil, ErrKeyNotFound
 	}
 
-	return val, nil
+	return m.copyValue(val), nil
+}
+
+// Exists reports whether key holds a value. Like Get, hot misses are answered
+// from the existence filter.
+func (m *MemStore) Exists(personaID, appID, key string) (bool, error) {
+	if m.existence.definitelyMissing(personaID, appID, key) {
+		return false, nil
+	}
+	m.rlockResident(personaID)
+	defer m.mu.RUnlock()
+	_, ok := m.data[personaID][appID][key]
+	return ok, nil
+}
+
+// MGet returns the values of the keys that exist, read under a single lock
+// so they are consistent with each other.
+func (m *MemStore) MGet(personaID, appID string, keys []string) (map[string]any, error) {
+	m.rlockResident(personaID)
+	defer m.mu.RUnlock()
+	values := make(map[string]any, len(keys))
+	app := m.data[personaID][appID]
+	for _, key := range keys {
+		if val, ok := app[key]; ok {
+			values[key] = m.copyValue(val)
+		}
+	}
+	return values, nil
 }
 
 func (m *MemStore) Set(personaID, appID, key string, val any) error {
-	m.mu.Lock()
-	if m.data[personaID] == nil {
-		m.data[personaID] = make(map[string]map[string]any)
+	_, err := m.set(personaID, appID, key, val, nil)
+	return err
+}
+
+// set stores a value if check, when given, accepts the current one, and
+// returns the background save of the write.
+func (m *MemStore) set(personaID, appID, key string, val any, check precondition) (*saveWait, error) {
+	if err := checkIDs(personaID, appID, key); err != nil {
+		return nil, err
 	}
-	if m.data[personaID][appID] == nil {
-		m.data[personaID][appID] = make(map[string]any)
+	if err := m.writable(personaID); err != nil {
+		return nil, err
+	}
+	val = m.copyValue(val)
+	m.lockFor(personaID, appID)
+	if err := m.checkLocked(personaID, appID, key, check); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	val, err := m.transformLocked(personaID, appID, key, val)
+	if err != nil {
+		m.mu.Unlock()
+		return nil, err
 	}
+	if err := m.conformsLocked(personaID, appID, val); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	if err := m.vetoSetLocked(personaID, appID, key, val); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	if err := m.admitLocked(personaID, appID, key, val); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	m.putLocked(personaID, appID, key, val)
 
-	m.data[personaID][appID][key] = val
+	// Snapshot the changed state and persist it in the background
+	wait := m.persistLocked(personaID, appID)
+	m.mu.Unlock()
+	return wait, nil
+}
+
+// SetBatch writes many records under a single lock and persists each affected
+// app once, which is what makes bulk imports affordable.
+func (m *MemStore) SetBatch(records []sdk.Record) error {
+	personas := make(map[string]map[string]struct{})
+	for _, rec := range records {
+		if err := checkIDs(rec.PersonaID, rec.AppID, rec.Key); err != nil {
+			return err
+		}
+		if personas[rec.PersonaID] == nil {
+			personas[rec.PersonaID] = make(map[string]struct{})
+		}
+		personas[rec.PersonaID][rec.AppID] = struct{}{}
+	}
+	for personaID := range personas {
+		if err := m.writable(personaID); err != nil {
+			return err
+		}
+	}
 
-	// Deep copy the persona's state to save safely in the background
-	currentPersonaData := m.copyPersonaData(personaID)
+	// Records applied before one is refused stay applied (and are persisted);
+	// importing is idempotent, so the caller can simply retry from its checkpoint.
+	var err error
+	m.mu.Lock()
+	for _, rec := range records {
+		if rec.Value, err = m.transformLocked(rec.PersonaID, rec.AppID, rec.Key, rec.Value); err != nil {
+			break
+		}
+		if err = m.conformsLocked(rec.PersonaID, rec.AppID, rec.Value); err != nil {
+			break
+		}
+		if err = m.vetoSetLocked(rec.PersonaID, rec.AppID, rec.Key, rec.Value); err != nil {
+			break
+		}
+		if err = m.admitLocked(rec.PersonaID, rec.AppID, rec.Key, rec.Value); err != nil {
+			break
+		}
+		m.putLocked(rec.PersonaID, rec.AppID, rec.Key, m.copyValue(rec.Value))
+	}
+	for personaID, apps := range personas {
+		appIDs := make([]string, 0, len(apps))
+		for appID := range apps {
+			appIDs = append(appIDs, appID)
+		}
+		m.persistLocked(personaID, appIDs...)
+	}
 	m.mu.Unlock()
+	return err
+}
 
-	// Persist in background
-	if m.persister != nil {
-		m.wg.Add(1)
-		go func(pID string, data map[string]map[string]any) {
-			defer m.wg.Done()
-			err := m.persister.SavePersona(pID, data)
-			if err != nil {
-				return
-			}
-		}(personaID, currentPersonaData)
+// Merge applies an RFC 7396 merge patch to the value at key under the write lock
+// and returns the merged result. A missing key is treated as an empty object.
+func (m *MemStore) Merge(personaID, appID, key string, patch any) (any, error) {
+	return m.update(personaID, appID, key, func(current any, _ bool) (any, error) {
+		return sdk.MergePatch(current, patch), nil
+	})
+}
+
+// update replaces a value with what change makes of the current one, under
+// the write lock. If change fails, nothing is written.
+func (m *MemStore) update(personaID, appID, key string, change func(current any, exists bool) (any, error)) (any, error) {
+	if err := checkIDs(personaID, appID, key); err != nil {
+		return nil, err
 	}
-	return nil
+	if err := m.writable(personaID); err != nil {
+		return nil, err
+	}
+	m.lockFor(personaID, appID)
+	if err := m.residentLocked(personaID); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	current, exists := m.data[personaID][appID][key]
+	next, err := change(current, exists)
+	if err == nil {
+		next, err = m.transformLocked(personaID, appID, key, next)
+	}
+	if err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	if err := m.conformsLocked(personaID, appID, next); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	if err := m.vetoSetLocked(personaID, appID, key, next); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	if err := m.admitLocked(personaID, appID, key, next); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	m.putLocked(personaID, appID, key, m.copyValue(next))
+
+	m.persistLocked(personaID, appID)
+	m.mu.Unlock()
+	return next, nil
 }
 
 func (m *MemStore) Delete(personaID, appID, key string) error {
-	m.mu.Lock()
+	return m.delete(personaID, appID, key, nil)
+}
+
+// delete removes a value if check, when given, accepts the current one.
+func (m *MemStore) delete(personaID, appID, key string, check precondition) error {
+	// Keys aren't checked, so ones stored before IDs were validated can be removed.
+	if err := checkIDs(personaID, appID, ""); err != nil {
+		return err
+	}
+	if err := m.writable(personaID); err != nil {
+		return err
+	}
+	m.lockFor(personaID, appID)
+	if err := m.residentLocked(personaID); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	if err := m.checkLocked(personaID, appID, key, check); err != nil {
+		m.mu.Unlock()
+		return err
+	}
 	if p, ok := m.data[personaID]; ok {
 		if a, ok := p[appID]; ok {
-			delete(a, key)
+			if old, exists := a[key]; exists {
+				if err := m.vetoDeleteLocked(personaID, appID, key); err != nil {
+					m.mu.Unlock()
+					return err
+				}
+				delete(a, key)
+				m.accountLocked(personaID, appID, -entrySize(key, old))
+				m.notify(sdk.OpDelete, personaID, appID, key, nil)
+			}
 		}
 	}
-	// Deep copy the persona's state to save safely in the background
-	currentPersonaData := m.copyPersonaData(personaID)
+	m.persistLocked(personaID, appID)
 	m.mu.Unlock()
+	return nil
+}
 
-	if m.persister != nil {
-		m.wg.Add(1)
-		go func(pID string, data map[string]map[string]any) {
-			defer m.wg.Done()
-			err := m.persister.SavePersona(pID, data)
-			if err != nil {
-				return
+// DeletePrefix deletes the app's keys starting with prefix under a single
+// lock and persists the app once.
+func (m *MemStore) DeletePrefix(personaID, appID, prefix string) (int, error) {
+	if err := checkIDs(personaID, appID, ""); err != nil {
+		return 0, err
+	}
+	if err := m.writable(personaID); err != nil {
+		return 0, err
+	}
+	m.lockFor(personaID, appID)
+	defer m.mu.Unlock()
+	if err := m.residentLocked(personaID); err == ErrPersonaNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	app := m.data[personaID][appID]
+	for key := range app {
+		if strings.HasPrefix(key, prefix) {
+			if err := m.vetoDeleteLocked(personaID, appID, key); err != nil {
+				return 0, err
 			}
-		}(personaID, currentPersonaData)
+		}
+	}
+	n := 0
+	for key, old := range app {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		delete(app, key)
+		m.accountLocked(personaID, appID, -entrySize(key, old))
+		m.notify(sdk.OpDelete, personaID, appID, key, nil)
+		n++
+	}
+	if n > 0 {
+		m.persistLocked(personaID, appID)
+	}
+	return n, nil
+}
+
+// putLocked stores a value, creating the persona and app as needed, and keeps
+// the existence filter and watchers in sync. It MUST be called while holding m.mu.Lock.
+func (m *MemStore) putLocked(personaID, appID, key string, val any) {
+	if m.data[personaID] == nil {
+		m.data[personaID] = make(map[string]map[string]any)
+	}
+	if m.data[personaID][appID] == nil {
+		m.data[personaID][appID] = make(map[string]any)
+	}
+
+	if old, exists := m.data[personaID][appID][key]; exists {
+		m.accountLocked(personaID, appID, -entrySize(key, old))
+	}
+	m.data[personaID][appID][key] = val
+	m.accountLocked(personaID, appID, entrySize(key, val))
+	m.existence.record(personaID, appID, key, m.data[personaID][appID])
+	m.notify(sdk.OpSet, personaID, appID, key, val)
+}
+
+// persistLocked snapshots what a write changed and queues it to be saved (see saveQueue):
+// only the given apps when the backend stores apps separately, otherwise the
+// whole persona. Writes that must be durable wait for the saves it returns.
+// It MUST be called while holding m.mu.Lock.
+func (m *MemStore) persistLocked(personaID string, appIDs ...string) *saveWait {
+	if m.persister == nil {
+		return nil
+	}
+	backend, ok := m.persister.(AppStorageBackend)
+	if !ok {
+		return m.persistAsync(personaID, m.copyPersonaData(personaID))
+	}
+
+	wait := &saveWait{}
+	for _, appID := range appIDs {
+		app, ok := m.data[personaID][appID]
+		if !ok {
+			continue // Nothing was ever stored, so there is nothing to update
+		}
+		appCopy := make(map[string]any, len(app))
+		for k, v := range app {
+			appCopy[k] = v
+		}
+
+		key := personaID + "/" + appID
+		target, seq := m.saves.ticket(key)
+		wait.wg.Add(1)
+		m.enqueueSave(key, personaID, target, seq, wait, func() error {
+			return m.save("celerix.save_app", key, personaID, appID, func() error {
+				return backend.SaveApp(personaID, appID, appCopy)
+			})
+		})
+	}
+	return wait
+}
+
+// persistAsync queues the save of a persona snapshot (see saveQueue).
+// The snapshot must be a copy taken while holding the lock (see copyPersonaData).
+func (m *MemStore) persistAsync(personaID string, data map[string]map[string]any) *saveWait {
+	if m.persister == nil {
+		return nil
+	}
+	wait := &saveWait{}
+	target, seq := m.saves.ticket(personaID)
+	wait.wg.Add(1)
+	m.enqueueSave(personaID, personaID, target, seq, wait, func() error {
+		return m.save("celerix.save_persona", personaID, personaID, "", func() error {
+			return m.persister.SavePersona(personaID, data)
+		})
+	})
+	return wait
+}
+
+// saveOrder keeps background saves of the same persona or app from landing
+// out of order: snapshots are numbered as they are taken, and a save is
+// skipped once a newer snapshot of the same target has been written.
+type saveOrder struct {
+	mu      sync.Mutex
+	targets map[string]*saveTarget
+}
+
+type saveTarget struct {
+	mu    sync.Mutex
+	taken uint64 // guarded by saveOrder.mu
+	saved uint64 // guarded by mu
+}
+
+// ticket numbers a snapshot of target. It MUST be called while holding
+// m.mu.Lock, where the snapshot is taken, so numbers follow snapshot order.
+func (o *saveOrder) ticket(target string) (*saveTarget, uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.targets == nil {
+		o.targets = make(map[string]*saveTarget)
+	}
+	t, ok := o.targets[target]
+	if !ok {
+		t = &saveTarget{}
+		o.targets[target] = t
+	}
+	t.taken++
+	return t, t.taken
+}
+
+// forget drops the targets of a persona that have nothing left to save:
+// saves still holding a ticket of them would be skipped as outdated. Targets
+// with a ticket taken since their newest save, by a write that came after a
+// purge, are kept so that save stays in order.
+func (o *saveOrder) forget(personaID string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for name, t := range o.targets {
+		if name != personaID && !strings.HasPrefix(name, personaID+"/") {
+			continue
+		}
+		// A target busy saving isn't idle, and isn't waited for here
+		if !t.mu.TryLock() {
+			continue
+		}
+		idle := t.saved >= t.taken
+		t.mu.Unlock()
+		if idle {
+			delete(o.targets, name)
+		}
+	}
+}
+
+// run performs save unless a newer snapshot was already saved.
+func (t *saveTarget) run(seq uint64, save func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if seq > t.saved {
+		save()
+		t.saved = seq
 	}
-	return nil
 }
 
 // copyPersonaData creates a deep copy of a persona's data.
@@ -141,11 +537,14 @@ func (m *MemStore) GetPersonas() ([]string, error) {
 	for id := range m.data {
 		list = append(list, id)
 	}
+	for id := range m.memory.evicted {
+		list = append(list, id)
+	}
 	return list, nil
 }
 
 func (m *MemStore) GetApps(personaID string) ([]string, error) {
-	m.mu.RLock()
+	m.rlockResident(personaID)
 	defer m.mu.RUnlock()
 
 	var list []string
@@ -158,7 +557,7 @@ func (m *MemStore) GetApps(personaID string) ([]string, error) {
 }
 
 func (m *MemStore) GetAppStore(personaID, appID string) (map[string]any, error) {
-	m.mu.RLock()
+	m.rlockResident(personaID)
 	defer m.mu.RUnlock()
 
 	if p, ok := m.data[personaID]; ok {
@@ -166,7 +565,33 @@ func (m *MemStore) GetAppStore(personaID, appID string) (map[string]any, error)
 			// Return a copy to prevent external mutation of the internal map
 			appCopy := make(map[string]any)
 			for k, v := range a {
-				appCopy[k] = v
+				appCopy[k] = m.copyValue(v)
+			}
+			return appCopy, nil
+		}
+	}
+	return nil, ErrAppNotFound
+}
+
+// GetFields retrieves a value projected down to the requested fields.
+func (m *MemStore) GetFields(personaID, appID, key string, fields []string) (any, error) {
+	val, err := m.Get(personaID, appID, key)
+	if err != nil {
+		return nil, err
+	}
+	return sdk.Project(val, fields), nil
+}
+
+// GetAppStoreFields returns an app's keys with every value projected down to the requested fields.
+func (m *MemStore) GetAppStoreFields(personaID, appID string, fields []string) (map[string]any, error) {
+	m.rlockResident(personaID)
+	defer m.mu.RUnlock()
+
+	if p, ok := m.data[personaID]; ok {
+		if a, ok := p[appID]; ok {
+			appCopy := make(map[string]any, len(a))
+			for k, v := range a {
+				appCopy[k] = m.copyValue(sdk.Project(v, fields))
 			}
 			return appCopy, nil
 		}
@@ -183,14 +608,67 @@ func (m *MemStore) DumpApp(appID string) (map[string]map[string]any, error) {
 		if appData, ok := apps[appID]; ok {
 			appCopy := make(map[string]any)
 			for k, v := range appData {
-				appCopy[k] = v
+				appCopy[k] = m.copyValue(v)
 			}
 			result[personaID] = appCopy
 		}
 	}
+	m.scanEvictedLocked(func(personaID string, apps map[string]map[string]any) bool {
+		if appData, ok := apps[appID]; ok {
+			result[personaID] = appData
+		}
+		return true
+	})
 	return result, nil
 }
 
+// StreamApp hands out the app one persona at a time. Each persona's data is
+// copied under the lock and fn is called without it, so a slow consumer
+// doesn't hold up writers. Evicted personas are read from the backend without
+// loading them back into memory.
+func (m *MemStore) StreamApp(appID, after string, fn func(personaID string, data map[string]any) error) error {
+	personas, err := m.GetPersonas()
+	if err != nil {
+		return err
+	}
+	personas, _ = sdk.PageIDs(personas, after, 0)
+	for _, personaID := range personas {
+		data, ok := m.appSnapshot(personaID, appID)
+		if !ok {
+			continue
+		}
+		if err := fn(personaID, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appSnapshot copies one persona's app for StreamApp.
+func (m *MemStore) appSnapshot(personaID, appID string) (map[string]any, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if apps, ok := m.data[personaID]; ok {
+		appData, ok := apps[appID]
+		if !ok {
+			return nil, false
+		}
+		appCopy := make(map[string]any, len(appData))
+		for k, v := range appData {
+			appCopy[k] = m.copyValue(v)
+		}
+		return appCopy, true
+	}
+	if loader, ok := m.persister.(PersonaLoader); ok && m.isEvicted(personaID) {
+		if apps, err := loader.LoadPersona(personaID); err == nil {
+			appData, ok := apps[appID]
+			return appData, ok
+		}
+	}
+	return nil, false
+}
+
 func (m *MemStore) GetGlobal(appID, key string) (any, string, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -198,64 +676,109 @@ func (m *MemStore) GetGlobal(appID, key string) (any, string, error) {
 	for personaID, apps := range m.data {
 		if appData, ok := apps[appID]; ok {
 			if val, ok := appData[key]; ok {
-				return val, personaID, nil
+				return m.copyValue(val), personaID, nil
 			}
 		}
 	}
+	var found any
+	var foundIn string
+	m.scanEvictedLocked(func(personaID string, apps map[string]map[string]any) bool {
+		if val, ok := apps[appID][key]; ok {
+			found, foundIn = val, personaID
+			return false
+		}
+		return true
+	})
+	if foundIn != "" {
+		return found, foundIn, nil
+	}
 	return nil, "", ErrKeyNotFound
 }
 
 func (m *MemStore) Move(srcPersona, dstPersona, appID, key string) error {
-	m.mu.Lock()
+	return m.MoveKey(srcPersona, appID, dstPersona, appID, key, key)
+}
+
+// MoveKey moves a value to another persona, app or key under a single lock,
+// replacing any value at the destination. Moving into another app validates
+// the value against that app's schema.
+func (m *MemStore) MoveKey(srcPersona, srcApp, dstPersona, dstApp, srcKey, dstKey string) error {
+	if err := checkIDs(srcPersona, srcApp, ""); err != nil {
+		return err
+	}
+	if err := checkIDs(dstPersona, dstApp, dstKey); err != nil {
+		return err
+	}
+	if err := m.writable(srcPersona, dstPersona); err != nil {
+		return err
+	}
+	m.lockFor(srcPersona, srcApp)
+	for _, personaID := range []string{srcPersona, dstPersona} {
+		if err := m.residentLocked(personaID); err != nil {
+			m.mu.Unlock()
+			return err
+		}
+	}
 	// 1. Check if a source exists
 	srcP, ok := m.data[srcPersona]
 	if !ok {
 		m.mu.Unlock()
 		return ErrPersonaNotFound
 	}
-	srcA, ok := srcP[appID]
+	srcA, ok := srcP[srcApp]
 	if !ok {
 		m.mu.Unlock()
 		return ErrAppNotFound
 	}
-	val, ok := srcA[key]
+	val, ok := srcA[srcKey]
 	if !ok {
 		m.mu.Unlock()
 		return ErrKeyNotFound
 	}
+	if srcPersona == dstPersona && srcApp == dstApp && srcKey == dstKey {
+		m.mu.Unlock()
+		return nil
+	}
 
-	// 2. Perform Move
-	delete(srcA, key)
-	if m.data[dstPersona] == nil {
-		m.data[dstPersona] = make(map[string]map[string]any)
+	if srcApp != dstApp {
+		if err := m.conformsLocked(dstPersona, dstApp, val); err != nil {
+			m.mu.Unlock()
+			return err
+		}
 	}
-	if m.data[dstPersona][appID] == nil {
-		m.data[dstPersona][appID] = make(map[string]any)
+	if srcPersona != dstPersona {
+		if err := m.admitLocked(dstPersona, dstApp, dstKey, val); err != nil {
+			m.mu.Unlock()
+			return err
+		}
 	}
-	m.data[dstPersona][appID][key] = val
 
-	// 3. Prepare background persistence for BOTH personas
-	srcCopy := m.copyPersonaData(srcPersona)
-	dstCopy := m.copyPersonaData(dstPersona)
-	m.mu.Unlock()
+	if err := m.vetoDeleteLocked(srcPersona, srcApp, srcKey); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	if err := m.vetoSetLocked(dstPersona, dstApp, dstKey, val); err != nil {
+		m.mu.Unlock()
+		return err
+	}
 
-	if m.persister != nil {
-		m.wg.Add(2)
-		go func() {
-			defer m.wg.Done()
-			err := m.persister.SavePersona(srcPersona, srcCopy)
-			if err != nil {
-				return
-			}
-		}()
-		go func() {
-			defer m.wg.Done()
-			err := m.persister.SavePersona(dstPersona, dstCopy)
-			if err != nil {
-				return
-			}
-		}()
+	// 2. Perform Move
+	delete(srcA, srcKey)
+	m.accountLocked(srcPersona, srcApp, -entrySize(srcKey, val))
+	m.notify(sdk.OpDelete, srcPersona, srcApp, srcKey, nil)
+	m.putLocked(dstPersona, dstApp, dstKey, val)
+
+	// 3. Background persistence for BOTH locations
+	switch {
+	case srcPersona != dstPersona:
+		m.persistLocked(srcPersona, srcApp)
+		m.persistLocked(dstPersona, dstApp)
+	case srcApp != dstApp:
+		m.persistLocked(srcPersona, srcApp, dstApp)
+	default:
+		m.persistLocked(srcPersona, srcApp)
 	}
+	m.mu.Unlock()
 
 	return nil
 }
@@ -289,7 +812,11 @@ func (a *memAppScope) Delete(key string) error {
 	return a.store.Delete(a.personaID, a.appID, key)
 }
 
-func (a *memAppScope) Vault(masterKey []byte) any {
+func (a *memAppScope) Watch(ctx context.Context, prefix string) (<-chan sdk.ChangeEvent, error) {
+	return a.store.Watch(ctx, a.personaID, a.appID, prefix)
+}
+
+func (a *memAppScope) Vault(masterKey []byte) sdk.VaultScope {
 	return &memVaultScope{
 		app:       a,
 		masterKey: masterKey,
@@ -321,6 +848,22 @@ func (v *memVaultScope) Get(key string) (string, error) {
 	return vault.Decrypt(cipherHex, v.masterKey)
 }
 
+func (v *memVaultScope) SetJSON(key string, val any) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return v.Set(key, string(data))
+}
+
+func (v *memVaultScope) GetJSON(key string, out any) error {
+	plaintext, err := v.Get(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(plaintext), out)
+}
+
 func init() {
 	sdk.RegisterEngine(&engineProvider{})
 }
@@ -332,7 +875,9 @@ func (e *engineProvider) NewPersistence(dir string) (sdk.Persistence, error) {
 }
 
 func (e *engineProvider) NewMemStore(initialData map[string]map[string]map[string]any, p sdk.Persistence) sdk.CelerixStore {
-	// We need to type assert Persistence back to our concrete type
-	persister, _ := p.(*Persistence)
-	return NewMemStore(initialData, persister)
+	// Only backends that can also write are used for persistence
+	if backend, ok := p.(StorageBackend); ok {
+		return NewMemStore(initialData, backend)
+	}
+	return NewMemStore(initialData, nil)
 }
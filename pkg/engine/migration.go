@@ -2,44 +2,316 @@ package engine
 
 import (
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/celerix-dev/celerix-store/pkg/sdk"
 )
 
+// ConflictPolicy decides what Migrate does with keys that already exist in the destination.
+type ConflictPolicy int
+
+const (
+	// ConflictOverwrite replaces existing destination values (the default).
+	ConflictOverwrite ConflictPolicy = iota
+	// ConflictSkip leaves existing destination values untouched.
+	ConflictSkip
+)
+
+// DefaultMigrateWorkers is the number of apps MigrateWithOptions transfers at
+// once unless MigrateOptions.Workers says otherwise.
+const DefaultMigrateWorkers = 4
+
+// MigrateOptions narrows and controls a migration.
+type MigrateOptions struct {
+	// PersonaID and AppID restrict the migration to a single persona and/or app.
+	PersonaID string
+	AppID     string
+	// DryRun reports what would be copied without writing to the destination.
+	DryRun     bool
+	OnConflict ConflictPolicy
+	// Progress, if set, is called after each app has been processed.
+	Progress func(MigrateProgress)
+	// OnDiff, if set, is called for every key holding different values in
+	// the source and the destination, before the conflict policy applies.
+	OnDiff func(MigrateDiff)
+	// ResumeAfter skips the persona/app pairs up to and including this
+	// cursor, as reported by MigrateProgress.Cursor. Pairs are migrated in
+	// persona ID, then app ID order, so an interrupted migration can pick up
+	// after the last app it finished.
+	ResumeAfter string
+	// Workers is the number of apps transferred at once (default
+	// DefaultMigrateWorkers).
+	Workers int
+	// BatchSize is the number of keys written per SetBatch call (default
+	// sdk.DefaultImportBatchSize).
+	BatchSize int
+}
+
+// MigrateProgress reports the outcome for a single persona/app pair.
+type MigrateProgress struct {
+	PersonaID string
+	AppID     string
+	Copied    int // Keys written (or that would be written in a dry run)
+	Skipped   int // Conflicting keys left alone under ConflictSkip
+	Conflicts int // Keys that already existed in the destination with another value
+	Unchanged int // Keys that already existed with the same value, which aren't rewritten
+}
+
+// Cursor identifies the pair for MigrateOptions.ResumeAfter.
+func (p MigrateProgress) Cursor() string {
+	return p.PersonaID + "/" + p.AppID
+}
+
+// MigrateDiff is a key whose value differs between source and destination.
+type MigrateDiff struct {
+	PersonaID string
+	AppID     string
+	Key       string
+	Src       any
+	Dst       any
+}
+
+// MigrateResult totals a migration.
+type MigrateResult struct {
+	Personas  int
+	Apps      int
+	Copied    int
+	Skipped   int
+	Conflicts int
+	Unchanged int
+}
+
 // Migrate takes data from a source store and pushes it to a destination store.
 // This works for:
 // - Embedded -> Remote (The "Upgrade")
 // - Remote -> Embedded (The "Backup/Offline")
 func Migrate(src sdk.CelerixStore, dst sdk.CelerixStore) error {
-	// 1. Get all Personas from the source
-	personas, err := src.GetPersonas()
-	if err != nil {
-		return fmt.Errorf("failed to list personas: %w", err)
+	_, err := MigrateWithOptions(src, dst, MigrateOptions{})
+	return err
+}
+
+// MigrateWithOptions is Migrate with filtering, dry-run, conflict handling,
+// diff and progress reporting, and resumption. Apps are transferred by a pool
+// of opts.Workers, each written in batches, but Progress is still called in
+// persona/app order, so the last pair it reported is always safe to resume
+// after. OnDiff calls may come from several apps at once, though never
+// concurrently.
+func MigrateWithOptions(src sdk.CelerixStore, dst sdk.CelerixStore, opts MigrateOptions) (MigrateResult, error) {
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultMigrateWorkers
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = sdk.DefaultImportBatchSize
+	}
+	if onDiff := opts.OnDiff; onDiff != nil {
+		var mu sync.Mutex
+		opts.OnDiff = func(d MigrateDiff) {
+			mu.Lock()
+			defer mu.Unlock()
+			onDiff(d)
+		}
 	}
 
-	for _, pID := range personas {
-		// 2. Get all Apps for this Persona
-		apps, err := src.GetApps(pID)
+	// 1. List the persona/app pairs, in order, while they are being migrated
+	pairs := make(chan migratePair)
+	stop := make(chan struct{})
+	var personas int
+	var listErr error
+	go func() {
+		defer close(pairs)
+		personas, listErr = listPairs(src, opts, func(p migratePair) bool {
+			select {
+			case pairs <- p:
+				return true
+			case <-stop:
+				return false
+			}
+		})
+	}()
+
+	// 2. Transfer each app on one of the workers
+	outcomes := make(chan migrateOutcome)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range pairs {
+				progress, skip, err := migrateApp(src, dst, p.personaID, p.appID, opts)
+				outcomes <- migrateOutcome{seq: p.seq, progress: progress, skip: skip, err: err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(outcomes)
+	}()
+
+	// 3. Report the outcomes in order. After a failure, the apps that finished
+	// later aren't reported, so resuming copies them again.
+	var result MigrateResult
+	var err error
+	pending := make(map[int]migrateOutcome)
+	next := 0
+	for out := range outcomes {
+		pending[out.seq] = out
+		for out, ok := pending[next]; ok; out, ok = pending[next] {
+			delete(pending, next)
+			next++
+			switch {
+			case err != nil, out.skip:
+			case out.err != nil:
+				err = out.err
+				close(stop)
+			default:
+				p := out.progress
+				result.Apps++
+				result.Copied += p.Copied
+				result.Skipped += p.Skipped
+				result.Conflicts += p.Conflicts
+				result.Unchanged += p.Unchanged
+				if opts.Progress != nil {
+					opts.Progress(p)
+				}
+			}
+		}
+	}
+	result.Personas = personas
+	if err == nil {
+		err = listErr
+	}
+	return result, err
+}
+
+// migratePair is one persona/app pair to migrate, numbered in migration order.
+type migratePair struct {
+	seq       int
+	personaID string
+	appID     string
+}
+
+type migrateOutcome struct {
+	seq      int
+	progress MigrateProgress
+	skip     bool
+	err      error
+}
+
+// listPairs hands the persona/app pairs to migrate to send, in order, until
+// send returns false. It returns the number of personas listed.
+func listPairs(src sdk.CelerixStore, opts MigrateOptions, send func(migratePair) bool) (int, error) {
+	resumePersona, resumeApp, _ := strings.Cut(opts.ResumeAfter, "/")
+	personas := []string{opts.PersonaID}
+	if opts.PersonaID == "" {
+		var err error
+		personas, err = src.GetPersonas()
 		if err != nil {
-			return fmt.Errorf("failed to list apps for persona %s: %w", pID, err)
+			return 0, fmt.Errorf("failed to list personas: %w", err)
 		}
+		sort.Strings(personas)
+	}
 
-		for _, aID := range apps {
-			// 3. Get the full KV map for this App
-			data, err := src.GetAppStore(pID, aID)
+	seq, count := 0, 0
+	for _, pID := range personas {
+		if opts.ResumeAfter != "" && pID < resumePersona {
+			continue
+		}
+		apps := []string{opts.AppID}
+		if opts.AppID == "" {
+			var err error
+			apps, err = src.GetApps(pID)
 			if err != nil {
-				return fmt.Errorf("failed to dump data for app %s: %w", aID, err)
+				return count, fmt.Errorf("failed to list apps for persona %s: %w", pID, err)
 			}
+			sort.Strings(apps)
+		}
+		count++
 
-			// 4. Push every key into the destination
-			for k, v := range data {
-				err := dst.Set(pID, aID, k, v)
-				if err != nil {
-					return fmt.Errorf("failed to set key %s in destination: %w", k, err)
-				}
+		for _, aID := range apps {
+			if opts.ResumeAfter != "" && pID == resumePersona && aID <= resumeApp {
+				continue
+			}
+			if !send(migratePair{seq: seq, personaID: pID, appID: aID}) {
+				return count, nil
+			}
+			seq++
+		}
+	}
+	return count, nil
+}
+
+// migrateApp copies one app, writing in batches of opts.BatchSize. skip means
+// the persona doesn't have the app opts filters on.
+func migrateApp(src, dst sdk.CelerixStore, pID, aID string, opts MigrateOptions) (progress MigrateProgress, skip bool, err error) {
+	progress = MigrateProgress{PersonaID: pID, AppID: aID}
+	data, err := src.GetAppStore(pID, aID)
+	if err != nil {
+		if opts.AppID != "" && isNotFound(err) {
+			return progress, true, nil // The requested app simply doesn't exist for this persona
+		}
+		return progress, false, fmt.Errorf("failed to dump data for app %s: %w", aID, err)
+	}
+
+	existing, err := dst.GetAppStore(pID, aID)
+	if err != nil && !isNotFound(err) {
+		return progress, false, fmt.Errorf("failed to read destination app %s: %w", aID, err)
+	}
+
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var batch []sdk.Record
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := sdk.WriteBatch(dst, batch); err != nil {
+			return fmt.Errorf("failed to write app %s to destination: %w", aID, err)
+		}
+		batch = batch[:0]
+		return nil
+	}
+	for _, k := range keys {
+		v := data[k]
+		if old, exists := existing[k]; exists {
+			if sdk.Revision(old) == sdk.Revision(v) {
+				progress.Unchanged++
+				continue
+			}
+			progress.Conflicts++
+			if opts.OnDiff != nil {
+				opts.OnDiff(MigrateDiff{PersonaID: pID, AppID: aID, Key: k, Src: v, Dst: old})
+			}
+			if opts.OnConflict == ConflictSkip {
+				progress.Skipped++
+				continue
+			}
+		}
+		progress.Copied++
+		if opts.DryRun {
+			continue
+		}
+		batch = append(batch, sdk.Record{PersonaID: pID, AppID: aID, Key: k, Value: v})
+		if len(batch) == opts.BatchSize {
+			if err := flush(); err != nil {
+				return progress, false, err
 			}
 		}
 	}
+	return progress, false, flush()
+}
 
-	return nil
+// isNotFound reports whether err means a persona or app does not exist.
+// Remote stores only surface the error text, so we compare messages as well.
+func isNotFound(err error) bool {
+	switch err.Error() {
+	case ErrPersonaNotFound.Error(), ErrAppNotFound.Error():
+		return true
+	}
+	return false
 }
@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// Option configures Open.
+type Option func(*openConfig)
+
+type openConfig struct {
+	fsync               FsyncPolicy
+	fsyncInterval       time.Duration
+	hasher              *PersonaHasher
+	memoryLimit         int64
+	eviction            EvictionPolicy
+	ephemeral           []string
+	tracer              sdk.Tracer
+	rawJSON             bool
+	precise             bool
+	deepCopy            bool
+	rejectOnSaveFailure bool
+	saveWorkers         int
+	saveQueueDepth      int
+	saveFault           func(personaID, appID string) error
+	transformers        []appTransformer
+}
+
+// WithFsync sets the durability policy of the data files (see SetFsyncPolicy).
+func WithFsync(policy FsyncPolicy, interval time.Duration) Option {
+	return func(c *openConfig) {
+		c.fsync, c.fsyncInterval = policy, interval
+	}
+}
+
+// WithPersonaHasher reports hashed persona IDs in logs and statistics.
+func WithPersonaHasher(h *PersonaHasher) Option {
+	return func(c *openConfig) {
+		c.hasher = h
+	}
+}
+
+// WithMemoryLimit caps the data held in memory (see SetMemoryLimit).
+// ephemeralApps are the apps EvictEphemeral may discard.
+func WithMemoryLimit(limit int64, policy EvictionPolicy, ephemeralApps ...string) Option {
+	return func(c *openConfig) {
+		c.memoryLimit, c.eviction, c.ephemeral = limit, policy, ephemeralApps
+	}
+}
+
+// WithTracer traces writes to the data files (see SetTracer).
+func WithTracer(tracer sdk.Tracer) Option {
+	return func(c *openConfig) {
+		c.tracer = tracer
+	}
+}
+
+// WithRawJSON keeps the JSON encoding of values that were read (see SetRawJSON).
+func WithRawJSON() Option {
+	return func(c *openConfig) {
+		c.rawJSON = true
+	}
+}
+
+// WithPreciseNumbers keeps numbers as json.Number, so large integers survive
+// a restart (see SetPreciseNumbers).
+func WithPreciseNumbers() Option {
+	return func(c *openConfig) {
+		c.precise = true
+	}
+}
+
+// WithDeepCopy copies values stored and read (see SetDeepCopy).
+func WithDeepCopy() Option {
+	return func(c *openConfig) {
+		c.deepCopy = true
+	}
+}
+
+// WithRejectWritesOnSaveFailure refuses writes while saves are failing (see
+// SetRejectWritesOnSaveFailure).
+func WithRejectWritesOnSaveFailure() Option {
+	return func(c *openConfig) {
+		c.rejectOnSaveFailure = true
+	}
+}
+
+// WithSaveQueue bounds background saves (see SetSaveQueue).
+func WithSaveQueue(workers, depth int) Option {
+	return func(c *openConfig) {
+		c.saveWorkers, c.saveQueueDepth = workers, depth
+	}
+}
+
+// WithSaveFault fails background saves on purpose (see SetSaveFault).
+func WithSaveFault(fault func(personaID, appID string) error) Option {
+	return func(c *openConfig) {
+		c.saveFault = fault
+	}
+}
+
+// WithTransformer runs t on the values written to the apps matching
+// appPatterns, or to every app (see AddTransformer).
+func WithTransformer(t Transformer, appPatterns ...string) Option {
+	return func(c *openConfig) {
+		c.transformers = append(c.transformers, appTransformer{t, appPatterns})
+	}
+}
+
+// Open starts an embedded store persisted to JSON files in dataDir, loading
+// what is already there. Close it to wait for pending writes.
+func Open(dataDir string, opts ...Option) (*MemStore, error) {
+	cfg := openConfig{fsync: FsyncNever}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	p, err := NewPersistence(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Lock(); err != nil {
+		return nil, err
+	}
+	p.SetFsyncPolicy(cfg.fsync, cfg.fsyncInterval)
+	if cfg.hasher != nil {
+		p.SetPersonaHasher(cfg.hasher)
+	}
+	p.SetPreciseNumbers(cfg.precise)
+	data, err := p.LoadAll()
+	if err != nil {
+		p.Close()
+		return nil, fmt.Errorf("load %s: %w", dataDir, err)
+	}
+
+	store := NewMemStore(data, p)
+	store.SetPersonaHasher(cfg.hasher)
+	store.SetTracer(cfg.tracer)
+	store.SetRawJSON(cfg.rawJSON)
+	store.SetPreciseNumbers(cfg.precise)
+	store.SetDeepCopy(cfg.deepCopy)
+	store.SetRejectWritesOnSaveFailure(cfg.rejectOnSaveFailure)
+	store.SetSaveQueue(cfg.saveWorkers, cfg.saveQueueDepth)
+	store.SetSaveFault(cfg.saveFault)
+	for _, t := range cfg.transformers {
+		if err := store.AddTransformer(t.transform, t.apps...); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+	if cfg.memoryLimit > 0 {
+		store.SetEphemeralApps(cfg.ephemeral...)
+		if err := store.SetMemoryLimit(cfg.memoryLimit, cfg.eviction); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// OpenOrConnect is sdk.New without the engine registry: it connects to the
+// daemon at CELERIX_STORE_ADDR when that is set and reachable, and otherwise
+// opens an embedded store in dataDir.
+func OpenOrConnect(dataDir string, opts ...Option) (sdk.CelerixStore, error) {
+	if addr := os.Getenv("CELERIX_STORE_ADDR"); addr != "" {
+		if client, err := sdk.Connect(addr); err == nil {
+			return client, nil
+		}
+	}
+	return Open(dataDir, opts...)
+}
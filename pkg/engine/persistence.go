@@ -1,18 +1,58 @@
 package engine
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
 )
 
-// Persistence handles the disk I/O for the MemStore
+// AppStorageBackend is an optional StorageBackend extension for backends that
+// store each app separately. MemStore uses it to save only the app a write
+// touched instead of a snapshot of the whole persona.
+type AppStorageBackend interface {
+	StorageBackend
+	// SaveApp replaces the stored state of one app of a persona.
+	SaveApp(personaID, appID string, data map[string]any) error
+}
+
+// Persistence handles the disk I/O for the MemStore.
+// It is the default StorageBackend, storing one JSON file per persona and app
+// (<data-dir>/<persona>/<app>.json), so a write only rewrites the app it touched.
 type Persistence struct {
 	DataDir string
 	mu      sync.Mutex // Protects concurrent writes to the filesystem
+	hasher  *PersonaHasher
+
+	preciseNumbers bool // see SetPreciseNumbers
+
+	qmu         sync.RWMutex
+	quarantined map[string]sdk.QuarantinedPersona
+
+	fsync     FsyncPolicy
+	dirty     map[string]struct{} // Files written but not yet fsynced (see Flush)
+	flushStop chan struct{}
+	flushDone chan struct{}
+
+	lock *os.File // Held on LockFile (see Lock)
+}
+
+// SetPersonaHasher makes log output refer to personas by hash instead of ID.
+func (p *Persistence) SetPersonaHasher(h *PersonaHasher) {
+	p.hasher = h
+}
+
+// SetPreciseNumbers makes loading keep numbers as json.Number instead of
+// float64, so large integers keep their precision. Set it before LoadAll.
+func (p *Persistence) SetPreciseNumbers(enabled bool) {
+	p.preciseNumbers = enabled
 }
 
 // NewPersistence initializes a persistence handler.
@@ -21,63 +61,313 @@ func NewPersistence(dir string) (*Persistence, error) {
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, err
 	}
-	return &Persistence{DataDir: dir}, nil
+	return &Persistence{DataDir: dir, quarantined: make(map[string]sdk.QuarantinedPersona)}, nil
+}
+
+func (p *Persistence) personaDir(personaID string) string {
+	return filepath.Join(p.DataDir, personaID)
+}
+
+// legacyFile is the single-file-per-persona layout used before apps were split
+// into their own files. LoadAll migrates these transparently.
+func (p *Persistence) legacyFile(personaID string) string {
+	return filepath.Join(p.DataDir, fmt.Sprintf("%s.json", personaID))
+}
+
+// SaveApp writes one app of a persona atomically.
+// The file ends with a checksum footer so corruption is detected on load.
+func (p *Persistence) SaveApp(personaID, appID string, data map[string]any) error {
+	if p.IsQuarantined(personaID) {
+		return ErrPersonaQuarantined
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.writeAppLocked(personaID, appID, data)
 }
 
-// SavePersona writes a single persona's data to a JSON file atomically.
+// SavePersona writes every app of a persona and removes files of apps that no longer exist.
 func (p *Persistence) SavePersona(personaID string, data map[string]map[string]any) error {
+	if p.IsQuarantined(personaID) {
+		return ErrPersonaQuarantined
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	filePath := filepath.Join(p.DataDir, fmt.Sprintf("%s.json", personaID))
-	tempPath := filePath + ".tmp"
+	for appID, appData := range data {
+		if err := p.writeAppLocked(personaID, appID, appData); err != nil {
+			return err
+		}
+	}
+
+	files, err := os.ReadDir(p.personaDir(personaID))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for _, file := range files {
+		appID, ok := strings.CutSuffix(file.Name(), ".json")
+		if _, exists := data[appID]; ok && !exists {
+			os.Remove(filepath.Join(p.personaDir(personaID), file.Name()))
+		}
+	}
+	return nil
+}
+
+// writeAppLocked writes an app file via a temporary file and an atomic rename.
+// It MUST be called while holding p.mu.
+func (p *Persistence) writeAppLocked(personaID, appID string, data map[string]any) error {
+	dir := p.personaDir(personaID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	filePath := filepath.Join(dir, fmt.Sprintf("%s.json", appID))
+	tempPath := filePath + tempSuffix
 
 	// 1. Convert map to JSON bytes
-	bytes, err := json.MarshalIndent(data, "", "  ")
+	bytes, err := encodeDataFile(data)
 	if err != nil {
 		return err
 	}
 
 	// 2. Write to a temporary file first
-	if err := os.WriteFile(tempPath, bytes, 0644); err != nil {
+	f, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(bytes)
+	if err == nil {
+		err = p.syncWrite(f, filePath)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
 		return err
 	}
 
 	// 3. Atomic Rename (The "Blink" swap)
 	// On Linux/Unix, this replaces the file instantly.
-	// If the power fails, you have either the old file or the new one, never a corrupt one.
-	return os.Rename(tempPath, filePath)
+	// If the power fails, you have either the old file or the new one, never a corrupt one
+	// (and with FsyncAlways, never an old one after the save has returned).
+	if err := os.Rename(tempPath, filePath); err != nil {
+		return err
+	}
+	return p.syncDirAfterRename(dir)
+}
+
+// DeletePersona removes a persona's files.
+func (p *Persistence) DeletePersona(personaID string) error {
+	if p.IsQuarantined(personaID) {
+		return ErrPersonaQuarantined
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := os.RemoveAll(p.personaDir(personaID)); err != nil {
+		return err
+	}
+	err := os.Remove(p.legacyFile(personaID))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// PurgePersona removes a persona's files and blobs, quarantined or not, along
+// with its quarantined copies, and lifts its quarantine.
+func (p *Persistence) PurgePersona(personaID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	dir := p.personaDir(personaID)
+	quarantine := filepath.Join(p.DataDir, QuarantineDir)
+	paths := []string{dir, p.legacyFile(personaID), filepath.Join(quarantine, personaID)}
+	files, _ := os.ReadDir(quarantine)
+	for _, file := range files {
+		// Old single-file personas were quarantined as <persona>.json.<time>.
+		if i := strings.LastIndex(file.Name(), ".json."); i > 0 && file.Name()[:i] == personaID {
+			paths = append(paths, filepath.Join(quarantine, file.Name()))
+		}
+	}
+	for _, path := range paths {
+		if err := os.RemoveAll(path); err != nil {
+			return p.scrub(err)
+		}
+	}
+	for path := range p.dirty {
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			delete(p.dirty, path)
+		}
+	}
+	if err := p.syncDirAfterRename(p.DataDir); err != nil {
+		return p.scrub(err)
+	}
+
+	p.qmu.Lock()
+	delete(p.quarantined, personaID)
+	p.qmu.Unlock()
+	return nil
+}
+
+// Close flushes writes still pending under FsyncInterval and releases the
+// data directory lock.
+func (p *Persistence) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopFlusherLocked()
+	p.unlockLocked()
+	return nil
+}
+
+// scrub strips the file path (which contains the persona ID) from I/O errors
+// when persona IDs are being hashed.
+func (p *Persistence) scrub(err error) error {
+	var pathErr *fs.PathError
+	if p.hasher != nil && errors.As(err, &pathErr) {
+		return pathErr.Err
+	}
+	return err
 }
 
 // LoadAll returns all persona data found in the data directory.
+// Files that fail their checksum or don't parse are moved to the quarantine
+// directory, and the persona is refused writes until an operator resolves it.
+// Personas still stored in the old single-file layout are split into per-app files.
+// Temporary files of saves interrupted by a crash are recovered or removed first.
 func (p *Persistence) LoadAll() (map[string]map[string]map[string]any, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
 	allData := make(map[string]map[string]map[string]any)
 
+	p.recoverTempFilesLocked()
 	files, err := os.ReadDir(p.DataDir)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, file := range files {
-		if filepath.Ext(file.Name()) == ".json" {
-			personaID := file.Name()[:len(file.Name())-5] // Strip .json
+	p.loadQuarantine()
 
-			content, err := os.ReadFile(filepath.Join(p.DataDir, file.Name()))
-			if err != nil {
-				log.Printf("Warning: Could not read persona file %s: %v", file.Name(), err)
-				continue // Skip corrupted/unreadable files
+	for _, file := range files {
+		switch {
+		case file.IsDir() && file.Name() != QuarantineDir && !strings.HasPrefix(file.Name(), "."):
+			personaID := file.Name()
+			if data, ok := p.loadPersonaDir(personaID); ok {
+				allData[personaID] = data
 			}
 
-			var personaData map[string]map[string]any
-			if err := json.Unmarshal(content, &personaData); err != nil {
-				log.Printf("Warning: Could not unmarshal persona data from %s: %v", file.Name(), err)
+		case !file.IsDir() && filepath.Ext(file.Name()) == ".json":
+			// A leftover single-file persona is the source of truth until it has been
+			// migrated; sorted order puts it after any directory of the same persona.
+			personaID := strings.TrimSuffix(file.Name(), ".json")
+			data, ok := p.loadFile(personaID, file.Name(), func(content []byte) (any, error) {
+				return decodePersonaFile(content, p.preciseNumbers)
+			})
+			if !ok {
+				delete(allData, personaID)
 				continue
 			}
+			personaData := data.(map[string]map[string]any)
+			if err := p.migrateLegacyLocked(personaID, personaData); err != nil {
+				log.Printf("Warning: Could not migrate persona file for %s to per-app files: %v", p.hasher.ID(personaID), p.scrub(err))
+			}
 			allData[personaID] = personaData
 		}
 	}
 	return allData, nil
 }
+
+// LoadPersona reads one persona's apps, e.g. to reload a persona evicted from memory.
+func (p *Persistence) LoadPersona(personaID string) (map[string]map[string]any, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.IsQuarantined(personaID) {
+		return nil, ErrPersonaQuarantined
+	}
+	if _, err := os.Stat(p.personaDir(personaID)); err != nil {
+		return nil, ErrPersonaNotFound
+	}
+	data, ok := p.loadPersonaDir(personaID)
+	if !ok {
+		return nil, ErrPersonaQuarantined
+	}
+	return data, nil
+}
+
+// loadPersonaDir reads every app file of a persona. A single bad file
+// quarantines the whole persona. It MUST be called while holding p.mu.
+func (p *Persistence) loadPersonaDir(personaID string) (map[string]map[string]any, bool) {
+	files, err := os.ReadDir(p.personaDir(personaID))
+	if err != nil {
+		return nil, false
+	}
+
+	personaData := make(map[string]map[string]any)
+	for _, file := range files {
+		appID, ok := strings.CutSuffix(file.Name(), ".json")
+		if file.IsDir() || !ok {
+			continue
+		}
+		data, ok := p.loadFile(personaID, filepath.Join(personaID, file.Name()), func(content []byte) (any, error) {
+			return decodeAppFile(content, p.preciseNumbers)
+		})
+		if !ok {
+			return nil, false
+		}
+		personaData[appID] = data.(map[string]any)
+	}
+	return personaData, !p.IsQuarantined(personaID)
+}
+
+// loadFile reads and decodes a data file, quarantining the persona if that fails.
+// It reports false if the persona must not be loaded. It MUST be called while holding p.mu.
+func (p *Persistence) loadFile(personaID, relPath string, decode func([]byte) (any, error)) (any, bool) {
+	content, err := os.ReadFile(filepath.Join(p.DataDir, relPath))
+	if err != nil {
+		// Unreadable isn't necessarily corrupt, so leave the file alone,
+		// but don't let an empty persona overwrite it either.
+		log.Printf("Warning: Could not read persona file for %s: %v", p.hasher.ID(personaID), p.scrub(err))
+		p.qmu.Lock()
+		p.quarantined[personaID] = sdk.QuarantinedPersona{
+			PersonaID: personaID,
+			File:      relPath,
+			Reason:    p.scrub(err).Error(),
+			Since:     time.Now().UTC(),
+		}
+		p.qmu.Unlock()
+		return nil, false
+	}
+
+	data, err := decode(content)
+	if err != nil {
+		p.quarantineFile(personaID, relPath, err)
+		return nil, false
+	}
+	if p.IsQuarantined(personaID) {
+		return nil, false // An older copy is still awaiting review in quarantine
+	}
+	return data, true
+}
+
+// migrateLegacyLocked rewrites a single-file persona as per-app files and then
+// removes the old file. A crash part way leaves the old file in place, and the
+// next load simply migrates it again. It MUST be called while holding p.mu.
+func (p *Persistence) migrateLegacyLocked(personaID string, data map[string]map[string]any) error {
+	for appID, appData := range data {
+		if err := p.writeAppLocked(personaID, appID, appData); err != nil {
+			return err
+		}
+	}
+	if len(data) == 0 {
+		if err := os.MkdirAll(p.personaDir(personaID), 0755); err != nil {
+			return err
+		}
+	}
+	if p.fsync == FsyncInterval {
+		p.flushLocked() // The new files must be durable before the old one goes
+	}
+	return os.Remove(p.legacyFile(personaID))
+}
@@ -0,0 +1,275 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// GetPersona returns a copy of every app of a persona, taken under a single
+// lock. An unknown persona has no apps.
+func (m *MemStore) GetPersona(personaID string) (map[string]map[string]any, error) {
+	m.rlockResident(personaID)
+	defer m.mu.RUnlock()
+
+	if data := m.copyPersonaData(personaID); data != nil {
+		for _, appData := range data {
+			for k, v := range appData {
+				appData[k] = m.copyValue(v)
+			}
+		}
+		return data, nil
+	}
+	return map[string]map[string]any{}, nil
+}
+
+// MergePersona moves every value of src into dst under a single lock and
+// deletes src, including its blobs. Conflicts are resolved by strategy; with
+// sdk.MergeFailOnConflict nothing changes if any key holds different values
+// in the two. Values go through dst's transformers and schemas as Set would
+// put them; one they refuse fails the merge, unless strategy is
+// sdk.MergePreferSrc and dst has a value to keep instead. Memory limits
+// aren't checked, since the merge frees as much as it adds.
+func (m *MemStore) MergePersona(src, dst string, strategy sdk.MergeStrategy) error {
+	strategy, err := sdk.ParseMergeStrategy(string(strategy))
+	if err != nil {
+		return err
+	}
+	for _, personaID := range []string{src, dst} {
+		if err := sdk.ValidateID("persona ID", personaID); err != nil {
+			return err
+		}
+	}
+	if src == dst {
+		return fmt.Errorf("can't merge persona %s into itself: %w", src, sdk.ErrBadRequest)
+	}
+	if err := m.writable(src, dst); err != nil {
+		return err
+	}
+
+	m.lockFor(src, "")
+	defer m.mu.Unlock()
+	for _, personaID := range []string{src, dst} {
+		if err := m.residentLocked(personaID); err != nil && err != ErrPersonaNotFound {
+			return err
+		}
+	}
+	srcApps, ok := m.data[src]
+	if !ok {
+		return ErrPersonaNotFound
+	}
+
+	appIDs := make([]string, 0, len(srcApps))
+	for appID := range srcApps {
+		appIDs = append(appIDs, appID)
+	}
+	sort.Strings(appIDs)
+
+	// Work out the values dst takes before changing anything
+	writes := make(map[string]map[string]any, len(appIDs))
+	for _, appID := range appIDs {
+		writes[appID] = make(map[string]any)
+		for key, val := range srcApps[appID] {
+			old, exists := m.data[dst][appID][key]
+			if exists && strategy == sdk.MergePreferDst {
+				continue
+			}
+			val, err := m.transformLocked(dst, appID, key, m.copyValue(val))
+			if err == nil {
+				err = m.conformsLocked(dst, appID, val)
+			}
+			if err != nil {
+				if exists && strategy == sdk.MergePreferSrc {
+					continue
+				}
+				return fmt.Errorf("can't merge %s/%s into %s: %w", appID, key, dst, err)
+			}
+			if exists && strategy == sdk.MergeFailOnConflict && sdk.Revision(old) != sdk.Revision(val) {
+				return sdk.NewProtocolError(sdk.CodeConflict, fmt.Sprintf("%s/%s differs in %s and %s", appID, key, src, dst))
+			}
+			writes[appID][key] = val
+		}
+	}
+
+	for _, appID := range appIDs {
+		for key, val := range writes[appID] {
+			if err := m.vetoSetLocked(dst, appID, key, val); err != nil {
+				return err
+			}
+		}
+		for key := range srcApps[appID] {
+			if err := m.vetoDeleteLocked(src, appID, key); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, appID := range appIDs {
+		for key, val := range writes[appID] {
+			m.putLocked(dst, appID, key, val)
+		}
+	}
+	m.dropPersonaLocked(src)
+	m.persistLocked(dst, appIDs...)
+	return nil
+}
+
+// dropPersonaLocked removes a persona from memory, telling watchers about
+// every deleted key, and deletes it from the backend. It MUST be called while
+// holding m.mu.Lock, with the persona resident.
+func (m *MemStore) dropPersonaLocked(personaID string) {
+	apps := m.data[personaID]
+	appIDs := make([]string, 0, len(apps))
+	for appID, appData := range apps {
+		appIDs = append(appIDs, appID)
+		m.accountLocked(personaID, appID, -m.memory.byNS[nsKey{personaID, appID}])
+		for key := range appData {
+			m.notify(sdk.OpDelete, personaID, appID, key, nil)
+		}
+	}
+	delete(m.data, personaID)
+	m.memory.lastUse.Delete(personaID)
+	m.deleteAsync(personaID, appIDs)
+}
+
+// deleteAsync deletes a persona from the backend in the background. It takes
+// its place in the order of the persona's saves (and, for an
+// AppStorageBackend, of the saves of each of its apps), so a save queued
+// before the delete can't bring the persona back. A save of a newer write
+// that lands first cancels the delete. It MUST be called while holding
+// m.mu.Lock.
+func (m *MemStore) deleteAsync(personaID string, appIDs []string) {
+	if m.persister == nil {
+		return
+	}
+	del := m.inSaveOrder(personaID, appIDs, func() { m.persister.DeletePersona(personaID) })
+	m.wg.Add(1)
+	m.beginSave(personaID)
+	go func() {
+		defer m.wg.Done()
+		defer m.endSave(personaID)
+		del()
+	}()
+}
+
+// inSaveOrder returns a function running fn once the saves of the persona
+// (or of each of appIDs, for an AppStorageBackend) numbered so far have
+// landed, and in their place: saves numbered later wait for it. It MUST be
+// called while holding m.mu.Lock.
+func (m *MemStore) inSaveOrder(personaID string, appIDs []string, fn func()) func() {
+	targets := []string{personaID}
+	if _, ok := m.persister.(AppStorageBackend); ok {
+		sort.Strings(appIDs)
+		targets = targets[:0]
+		for _, appID := range appIDs {
+			targets = append(targets, personaID+"/"+appID)
+		}
+	}
+	for i := len(targets) - 1; i >= 0; i-- {
+		target, seq := m.saves.ticket(targets[i])
+		next := fn
+		fn = func() { target.run(seq, next) }
+	}
+	return fn
+}
+
+// PurgePersona erases a persona irreversibly, unlike deleting its keys: its
+// data in memory and in the backend, its blobs, its quarantined files and
+// whatever the store remembers about it, such as leases and persona hashes.
+// It also works on quarantined personas and personas that fail to load. The
+// backend is purged before PurgePersona returns, after any save of the
+// persona still in flight, and then the OnPurge hooks run.
+func (m *MemStore) PurgePersona(personaID string) (sdk.PurgeReport, error) {
+	report, hooks, err := m.purge(personaID)
+	if err != nil {
+		return report, err
+	}
+	var errs []error
+	for _, hook := range hooks {
+		if err := hook(personaID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// purge erases a persona from the store and returns the hooks to run next.
+func (m *MemStore) purge(personaID string) (sdk.PurgeReport, []PurgeHook, error) {
+	if err := sdk.ValidateID("persona ID", personaID); err != nil {
+		return sdk.PurgeReport{}, nil, err
+	}
+	report := sdk.PurgeReport{PersonaID: personaID}
+
+	m.lockFor(personaID, "")
+	hooks := slices.Clone(m.hooks.purge)
+	// An evicted persona is loaded to be counted; one that can't be loaded
+	// is purged all the same.
+	m.residentLocked(personaID)
+	delete(m.memory.evicted, personaID)
+
+	var appIDs []string
+	if apps, ok := m.data[personaID]; ok {
+		for appID, appData := range apps {
+			appIDs = append(appIDs, appID)
+			report.Keys += len(appData)
+			m.accountLocked(personaID, appID, -m.memory.byNS[nsKey{personaID, appID}])
+			m.existence.filters.Delete(nsKey{personaID, appID})
+			for key := range appData {
+				m.notify(sdk.OpDelete, personaID, appID, key, nil)
+			}
+		}
+		report.Apps = len(apps)
+		delete(m.data, personaID)
+	}
+	m.memory.lastUse.Delete(personaID)
+	m.forgetPersona(personaID)
+
+	// The backend is purged in the order of the persona's saves, taken now,
+	// but off the lock: waiting for saves in flight and purging files must
+	// not stall other personas. Saves of later writes wait for the purge.
+	var err error
+	purge := func() {}
+	if m.persister != nil {
+		purge = m.inSaveOrder(personaID, appIDs, func() {
+			if purger, ok := m.persister.(PersonaPurger); ok {
+				err = purger.PurgePersona(personaID)
+			} else {
+				err = m.persister.DeletePersona(personaID)
+			}
+		})
+	}
+	m.mu.Unlock()
+
+	purge()
+	if err != nil {
+		return report, nil, err
+	}
+	m.saves.forget(personaID)
+	m.forgetSaveFailures(personaID)
+	m.hasher.Forget(personaID)
+	report.PurgedAt = time.Now().UTC()
+	return report, hooks, nil
+}
+
+// forgetPersona drops the leases and lock statistics of a persona.
+func (m *MemStore) forgetPersona(personaID string) {
+	m.leases.mu.Lock()
+	for k := range m.leases.leases {
+		if k.personaID == personaID {
+			delete(m.leases.leases, k)
+		}
+	}
+	m.leases.mu.Unlock()
+
+	m.contention.mu.Lock()
+	for k := range m.contention.byNS {
+		if k.personaID == personaID {
+			delete(m.contention.byNS, k)
+		}
+	}
+	m.contention.mu.Unlock()
+}
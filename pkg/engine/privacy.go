@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+// HasherMemory is how many persona IDs a PersonaHasher remembers, so that
+// busy stores don't keep every persona they ever hashed.
+const HasherMemory = 100_000
+
+// PersonaHasher replaces persona IDs with stable keyed hashes in logs and metrics,
+// so observability pipelines don't accumulate raw user identifiers.
+// It remembers the HasherMemory IDs it hashed most recently so admins can map a
+// hash back to a persona; older ones are found through Lookup's candidates.
+//
+// A nil *PersonaHasher is valid and returns IDs unchanged.
+type PersonaHasher struct {
+	key    []byte
+	memory int // HasherMemory, lowered in tests
+
+	mu      sync.Mutex
+	reverse map[string]*list.Element // hash -> element of recent
+	recent  *list.List               // hashedID, most recently hashed first
+}
+
+type hashedID struct {
+	hash, personaID string
+}
+
+// NewPersonaHasher creates a hasher keyed with key. With an empty key a random
+// one is generated, so hashes are only stable for the lifetime of the process.
+func NewPersonaHasher(key []byte) *PersonaHasher {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	return &PersonaHasher{key: key, memory: HasherMemory, reverse: make(map[string]*list.Element), recent: list.New()}
+}
+
+// ID returns the label to use for personaID in logs and metrics.
+func (h *PersonaHasher) ID(personaID string) string {
+	if h == nil {
+		return personaID
+	}
+
+	hash := h.hash(personaID)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if e, ok := h.reverse[hash]; ok {
+		h.recent.MoveToFront(e)
+		return hash
+	}
+	h.reverse[hash] = h.recent.PushFront(hashedID{hash, personaID})
+	if h.recent.Len() > h.memory {
+		oldest := h.recent.Back()
+		h.recent.Remove(oldest)
+		delete(h.reverse, oldest.Value.(hashedID).hash)
+	}
+	return hash
+}
+
+// Forget makes Lookup stop finding personaID unless it is among the
+// candidates, e.g. once the persona has been purged.
+func (h *PersonaHasher) Forget(personaID string) {
+	if h == nil {
+		return
+	}
+	hash := h.hash(personaID)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if e, ok := h.reverse[hash]; ok {
+		h.recent.Remove(e)
+		delete(h.reverse, hash)
+	}
+}
+
+func (h *PersonaHasher) hash(personaID string) string {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(personaID))
+	return "p_" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// Lookup maps a hash seen in logs back to its persona ID.
+// Only IDs recently hashed by this process (or passed in candidates) are known.
+func (h *PersonaHasher) Lookup(hash string, candidates ...string) (string, bool) {
+	if h == nil {
+		return "", false
+	}
+
+	h.mu.Lock()
+	e, ok := h.reverse[hash]
+	h.mu.Unlock()
+	if ok {
+		return e.Value.(hashedID).personaID, true
+	}
+
+	for _, c := range candidates {
+		if h.ID(c) == hash {
+			return c, true
+		}
+	}
+	return "", false
+}
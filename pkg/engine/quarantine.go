@@ -0,0 +1,224 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// QuarantineDir is the subdirectory of the data directory corrupt persona files are moved to.
+const QuarantineDir = "quarantine"
+
+// checksumFooter starts the last line of every persona file. The rest of the line is
+// the hex SHA-256 of the JSON document above it. Files without a footer (written by
+// older versions) are accepted as long as they parse.
+const checksumFooter = "\n#celerix:sha256="
+
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// QuarantineReporter is implemented by storage backends that set aside corrupt
+// persona data instead of silently loading it as empty. MemStore refuses writes
+// to quarantined personas so the corrupt data is never overwritten.
+type QuarantineReporter interface {
+	IsQuarantined(personaID string) bool
+	Quarantined() []sdk.QuarantinedPersona
+}
+
+// encodeDataFile renders app data as indented JSON followed by a checksum footer.
+func encodeDataFile(data any) ([]byte, error) {
+	body, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	return append(body, []byte(checksumFooter+hex.EncodeToString(sum[:])+"\n")...), nil
+}
+
+// decodeDataFile verifies the checksum footer (if present) and parses the JSON
+// document into v, keeping numbers as json.Number if preciseNumbers is set.
+func decodeDataFile(content []byte, v any, preciseNumbers bool) error {
+	body := content
+	if i := bytes.LastIndex(content, []byte(checksumFooter)); i >= 0 {
+		body = content[:i]
+		want := strings.TrimSpace(string(content[i+len(checksumFooter):]))
+		sum := sha256.Sum256(body)
+		if hex.EncodeToString(sum[:]) != want {
+			return errChecksumMismatch
+		}
+	}
+	return sdk.DecodeValue(body, v, preciseNumbers)
+}
+
+func decodeAppFile(content []byte, preciseNumbers bool) (map[string]any, error) {
+	var appData map[string]any
+	if err := decodeDataFile(content, &appData, preciseNumbers); err != nil {
+		return nil, err
+	}
+	if appData == nil {
+		appData = make(map[string]any)
+	}
+	return appData, nil
+}
+
+// decodePersonaFile reads a persona file in the old single-file layout.
+func decodePersonaFile(content []byte, preciseNumbers bool) (map[string]map[string]any, error) {
+	var personaData map[string]map[string]any
+	if err := decodeDataFile(content, &personaData, preciseNumbers); err != nil {
+		return nil, err
+	}
+	if personaData == nil {
+		personaData = make(map[string]map[string]any)
+	}
+	return personaData, nil
+}
+
+// quarantineFile moves a corrupt data file (relPath, relative to the data
+// directory) into the quarantine directory and records the persona as
+// quarantined. It MUST be called while holding p.mu.
+func (p *Persistence) quarantineFile(personaID, relPath string, reason error) {
+	q := sdk.QuarantinedPersona{
+		PersonaID: personaID,
+		File:      relPath,
+		Reason:    reason.Error(),
+		Since:     time.Now().UTC(),
+	}
+
+	target := filepath.Join(QuarantineDir, fmt.Sprintf("%s.%s", relPath, q.Since.Format("20060102T150405Z")))
+	err := os.MkdirAll(filepath.Dir(filepath.Join(p.DataDir, target)), 0755)
+	if err == nil {
+		err = os.Rename(filepath.Join(p.DataDir, relPath), filepath.Join(p.DataDir, target))
+	}
+	if err != nil {
+		// The file stays where it is; the persona is still protected from writes.
+		log.Printf("Warning: Could not move corrupt persona file for %s to quarantine: %v", p.hasher.ID(personaID), p.scrub(err))
+	} else {
+		q.File = target
+	}
+
+	log.Printf("Warning: Quarantined persona %s: %s", p.hasher.ID(personaID), q.Reason)
+	p.qmu.Lock()
+	p.quarantined[personaID] = q
+	p.qmu.Unlock()
+}
+
+// loadQuarantine rediscovers personas quarantined by earlier runs, so restarting
+// the daemon doesn't silently lift the protection. A persona stays quarantined
+// until every file for it has been removed from the quarantine directory:
+// quarantine/<persona>.json.<time> for old single-file personas and
+// quarantine/<persona>/<app>.json.<time> for app files.
+// It MUST be called while holding p.mu.
+func (p *Persistence) loadQuarantine() {
+	dir := filepath.Join(p.DataDir, QuarantineDir)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return // No quarantine directory means nothing was ever quarantined
+	}
+
+	p.qmu.Lock()
+	defer p.qmu.Unlock()
+
+	for _, file := range files {
+		var personaID, relPath string
+		if file.IsDir() {
+			apps, err := os.ReadDir(filepath.Join(dir, file.Name()))
+			if err != nil || len(apps) == 0 {
+				continue
+			}
+			personaID, relPath = file.Name(), filepath.Join(QuarantineDir, file.Name(), apps[0].Name())
+		} else {
+			i := strings.LastIndex(file.Name(), ".json.")
+			if i <= 0 {
+				continue
+			}
+			personaID, relPath = file.Name()[:i], filepath.Join(QuarantineDir, file.Name())
+		}
+		if _, ok := p.quarantined[personaID]; ok {
+			continue
+		}
+
+		q := sdk.QuarantinedPersona{
+			PersonaID: personaID,
+			File:      relPath,
+			Reason:    "quarantined by a previous run",
+		}
+		if info, err := os.Stat(filepath.Join(p.DataDir, relPath)); err == nil {
+			q.Since = info.ModTime().UTC()
+		}
+		p.quarantined[personaID] = q
+	}
+}
+
+// IsQuarantined reports whether writes to personaID are being refused.
+func (p *Persistence) IsQuarantined(personaID string) bool {
+	p.qmu.RLock()
+	defer p.qmu.RUnlock()
+	_, ok := p.quarantined[personaID]
+	return ok
+}
+
+// Quarantined lists quarantined personas, sorted by ID.
+func (p *Persistence) Quarantined() []sdk.QuarantinedPersona {
+	p.qmu.RLock()
+	defer p.qmu.RUnlock()
+
+	list := make([]sdk.QuarantinedPersona, 0, len(p.quarantined))
+	for _, q := range p.quarantined {
+		list = append(list, q)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].PersonaID < list[j].PersonaID })
+	return list
+}
+
+// writable returns ErrPersonaQuarantined if the backend has set the persona
+// aside, and sdk.ErrStorageFailure if writes are refused while saves fail.
+// Otherwise it waits for room in the save queue. Writes call it before taking
+// the store lock.
+func (m *MemStore) writable(personaIDs ...string) error {
+	if err := m.rejectingWrites(); err != nil {
+		return err
+	}
+	for _, id := range personaIDs {
+		if m.quarantined(id) {
+			return ErrPersonaQuarantined
+		}
+	}
+	m.waitForSaveRoom()
+	return nil
+}
+
+// quarantined reports whether the backend has set the persona aside.
+func (m *MemStore) quarantined(personaID string) bool {
+	q, ok := m.persister.(QuarantineReporter)
+	return ok && q.IsQuarantined(personaID)
+}
+
+// Health reports quarantined personas and failing saves. The store is
+// degraded while any exist.
+func (m *MemStore) Health() (sdk.Health, error) {
+	health := sdk.Health{Status: sdk.HealthOK, SaveFailures: m.saveFailureList()}
+
+	if q, ok := m.persister.(QuarantineReporter); ok {
+		for _, p := range q.Quarantined() {
+			if m.hasher != nil {
+				p.PersonaID = m.hasher.ID(p.PersonaID)
+				p.File = "" // File names contain the raw persona ID
+			}
+			health.Quarantined = append(health.Quarantined, p)
+		}
+	}
+	if len(health.Quarantined) > 0 || len(health.SaveFailures) > 0 {
+		health.Status = sdk.HealthDegraded
+	}
+	return health, nil
+}
@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// queueState is how a queue is stored: as the value of its key, oldest
+// message first.
+type queueState struct {
+	Messages []queuedMessage `json:"messages"`
+}
+
+type queuedMessage struct {
+	sdk.QueueMessage
+	// VisibleAt is when a dequeued message that wasn't acked is handed out again.
+	VisibleAt time.Time `json:"visible_at,omitempty"`
+}
+
+// decodeQueue reads a queue value.
+func decodeQueue(val any, exists, preciseNumbers bool) (queueState, error) {
+	q := queueState{Messages: []queuedMessage{}}
+	if !exists {
+		return q, nil
+	}
+	data, err := json.Marshal(val)
+	if err != nil {
+		return q, err
+	}
+	q.Messages = nil
+	if err := sdk.DecodeValue(data, &q, preciseNumbers); err != nil || q.Messages == nil {
+		return q, fmt.Errorf("value is not a queue: %w", sdk.ErrBadRequest)
+	}
+	return q, nil
+}
+
+// encodeQueue turns a queue into plain JSON data, as values are stored, so
+// memory accounting sees its size and readers see the same value before and
+// after a restart.
+func encodeQueue(q queueState, preciseNumbers bool) (any, error) {
+	data, err := json.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+	var val any
+	err = sdk.DecodeValue(data, &val, preciseNumbers)
+	return val, err
+}
+
+func randomID() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// Enqueue appends an item to the queue stored at key queue.
+func (m *MemStore) Enqueue(personaID, appID, queue string, item any) (string, error) {
+	id, err := randomID()
+	if err != nil {
+		return "", err
+	}
+	_, err = m.update(personaID, appID, queue, func(current any, exists bool) (any, error) {
+		q, err := decodeQueue(current, exists, m.preciseNumbers)
+		if err != nil {
+			return nil, err
+		}
+		q.Messages = append(q.Messages, queuedMessage{QueueMessage: sdk.QueueMessage{
+			ID: id, Item: item, EnqueuedAt: time.Now().UTC(),
+		}})
+		return encodeQueue(q, m.preciseNumbers)
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Dequeue hands out the oldest visible message of a queue and hides it for
+// visibility, or removes it if visibility isn't positive.
+func (m *MemStore) Dequeue(personaID, appID, queue string, visibility time.Duration) (sdk.QueueMessage, error) {
+	receipt, err := randomID()
+	if err != nil {
+		return sdk.QueueMessage{}, err
+	}
+	var msg sdk.QueueMessage
+	_, err = m.update(personaID, appID, queue, func(current any, exists bool) (any, error) {
+		q, err := decodeQueue(current, exists, m.preciseNumbers)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		for i, queued := range q.Messages {
+			if now.Before(queued.VisibleAt) {
+				continue
+			}
+			queued.Receives++
+			if visibility <= 0 {
+				q.Messages = append(q.Messages[:i], q.Messages[i+1:]...)
+			} else {
+				queued.Receipt = receipt
+				queued.VisibleAt = now.Add(visibility).UTC()
+				q.Messages[i] = queued
+			}
+			msg = queued.QueueMessage
+			return encodeQueue(q, m.preciseNumbers)
+		}
+		return nil, sdk.ErrQueueEmpty
+	})
+	return msg, err
+}
+
+// Ack removes a dequeued message from a queue, unless its visibility timeout
+// ran out.
+func (m *MemStore) Ack(personaID, appID, queue, receipt string) error {
+	_, err := m.update(personaID, appID, queue, func(current any, exists bool) (any, error) {
+		q, err := decodeQueue(current, exists, m.preciseNumbers)
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		for i, queued := range q.Messages {
+			if queued.Receipt == receipt && now.Before(queued.VisibleAt) {
+				q.Messages = append(q.Messages[:i], q.Messages[i+1:]...)
+				return encodeQueue(q, m.preciseNumbers)
+			}
+		}
+		return nil, sdk.ErrReceiptExpired
+	})
+	return err
+}
@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+)
+
+// rawIndex keeps the JSON encoding of values that were read, so reads of
+// unchanged values don't marshal them again. Readers fill it while holding
+// m.mu.RLock, hence its own lock; writers drop entries while holding m.mu.Lock,
+// so an entry never outlives the value it encodes.
+type rawIndex struct {
+	enabled atomic.Bool
+	mu      sync.Mutex
+	byNS    map[nsKey]map[string]json.RawMessage
+}
+
+// SetRawJSON makes the store keep the JSON encoding of values next to them, so
+// GetRaw and GetAppStoreRaw (and the GET and DUMP commands of the daemon)
+// answer repeated reads without re-encoding. Encodings are kept from the first
+// read until the value changes; they are not counted against the memory limit.
+func (m *MemStore) SetRawJSON(enabled bool) {
+	m.raw.enabled.Store(enabled)
+	if !enabled {
+		m.raw.mu.Lock()
+		m.raw.byNS = nil
+		m.raw.mu.Unlock()
+	}
+}
+
+// GetRaw returns the JSON encoding of a value.
+func (m *MemStore) GetRaw(personaID, appID, key string) (json.RawMessage, error) {
+	if !m.raw.enabled.Load() {
+		val, err := m.Get(personaID, appID, key)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(val)
+	}
+	if m.existence.definitelyMissing(personaID, appID, key) {
+		return nil, ErrKeyNotFound
+	}
+
+	m.rlockResident(personaID)
+	defer m.mu.RUnlock()
+
+	persona, ok := m.data[personaID]
+	if !ok {
+		return nil, ErrPersonaNotFound
+	}
+	app, ok := persona[appID]
+	if !ok {
+		return nil, ErrAppNotFound
+	}
+	val, ok := app[key]
+	if !ok {
+		m.existence.ensure(personaID, appID, app)
+		return nil, ErrKeyNotFound
+	}
+	return m.raw.encode(nsKey{personaID, appID}, key, val)
+}
+
+// GetAppStoreRaw returns the JSON encoding of every value of an app.
+func (m *MemStore) GetAppStoreRaw(personaID, appID string) (map[string]json.RawMessage, error) {
+	m.rlockResident(personaID)
+	defer m.mu.RUnlock()
+
+	app, ok := m.data[personaID][appID]
+	if !ok {
+		return nil, ErrAppNotFound
+	}
+	raw := make(map[string]json.RawMessage, len(app))
+	for key, val := range app {
+		data, err := m.raw.encode(nsKey{personaID, appID}, key, val)
+		if err != nil {
+			return nil, err
+		}
+		raw[key] = data
+	}
+	return raw, nil
+}
+
+// encode returns the kept encoding of val, encoding and keeping it first if
+// needed. It MUST be called while holding m.mu.RLock (or m.mu.Lock).
+func (r *rawIndex) encode(k nsKey, key string, val any) (json.RawMessage, error) {
+	if !r.enabled.Load() {
+		return json.Marshal(val)
+	}
+	r.mu.Lock()
+	data, ok := r.byNS[k][key]
+	r.mu.Unlock()
+	if ok {
+		return data, nil
+	}
+
+	data, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.byNS == nil {
+		r.byNS = make(map[nsKey]map[string]json.RawMessage)
+	}
+	if r.byNS[k] == nil {
+		r.byNS[k] = make(map[string]json.RawMessage)
+	}
+	r.byNS[k][key] = data
+	return data, nil
+}
+
+// forget drops the encoding of a key that changed. It MUST be called while
+// holding m.mu.Lock.
+func (r *rawIndex) forget(k nsKey, key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if app := r.byNS[k]; app != nil {
+		delete(app, key)
+		if len(app) == 0 {
+			delete(r.byNS, k)
+		}
+	}
+}
+
+// forgetApp drops the encodings of an app that left memory. It MUST be called
+// while holding m.mu.Lock.
+func (r *rawIndex) forgetApp(k nsKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.byNS, k)
+}
@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"errors"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// precondition decides whether a conditional write may replace the current value.
+type precondition func(current any, exists bool) error
+
+// checkLocked runs check, if any, against the current value at key, counting
+// conflicts in the namespace's contention stats. It MUST be called while
+// holding m.mu.Lock.
+func (m *MemStore) checkLocked(personaID, appID, key string, check precondition) error {
+	if check == nil {
+		return nil
+	}
+	if err := m.residentLocked(personaID); err != nil {
+		return err
+	}
+	current, exists := m.data[personaID][appID][key]
+	err := check(current, exists)
+	if errors.Is(err, sdk.ErrConflict) {
+		m.contention.conflict(personaID, appID)
+	}
+	return err
+}
+
+// ifRevision accepts the current value if it has revision rev; rev "" accepts
+// only a missing value.
+func ifRevision(rev string) precondition {
+	return func(current any, exists bool) error {
+		if !exists && rev == "" || exists && sdk.Revision(current) == rev {
+			return nil
+		}
+		return sdk.ErrConflict
+	}
+}
+
+// SetIfRevision stores val if the current value still has revision rev.
+func (m *MemStore) SetIfRevision(personaID, appID, key string, val any, rev string) error {
+	_, err := m.set(personaID, appID, key, val, ifRevision(rev))
+	return err
+}
+
+// DeleteIfRevision deletes the value if it still has revision rev.
+func (m *MemStore) DeleteIfRevision(personaID, appID, key, rev string) error {
+	check := ifRevision(rev)
+	if rev == "" {
+		// There is nothing to delete at revision "", but the conflict still counts
+		check = func(any, bool) error { return sdk.ErrConflict }
+	}
+	return m.delete(personaID, appID, key, check)
+}
+
+// DeleteIfEquals deletes the value if it is still equal to expected.
+func (m *MemStore) DeleteIfEquals(personaID, appID, key string, expected any) error {
+	return m.DeleteIfRevision(personaID, appID, key, sdk.Revision(expected))
+}
@@ -0,0 +1,190 @@
+package engine
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// Defaults for retrying failed saves (see SetSaveRetry).
+const (
+	DefaultSaveAttempts = 3
+	DefaultSaveBackoff  = 100 * time.Millisecond
+)
+
+// saveFailures tracks the save targets (see saveOrder) whose last save failed.
+// The store is degraded while any exist.
+type saveFailures struct {
+	mu       sync.Mutex
+	attempts int           // 0 means DefaultSaveAttempts
+	backoff  time.Duration // 0 means DefaultSaveBackoff
+	reject   bool          // see SetRejectWritesOnSaveFailure
+	failing  map[string]sdk.SaveFailure
+	// fault is set with SetSaveFault.
+	fault func(personaID, appID string) error
+}
+
+// SetSaveRetry sets how often a failed background save is attempted in total,
+// and the wait before the first retry, which doubles for each one after it.
+// Zero or less uses the defaults.
+func (m *MemStore) SetSaveRetry(attempts int, backoff time.Duration) {
+	m.failures.mu.Lock()
+	defer m.failures.mu.Unlock()
+	m.failures.attempts, m.failures.backoff = attempts, backoff
+}
+
+// SetRejectWritesOnSaveFailure makes writes fail with sdk.ErrStorageFailure
+// while any save is failing, instead of being accepted into memory only.
+func (m *MemStore) SetRejectWritesOnSaveFailure(enabled bool) {
+	m.failures.mu.Lock()
+	defer m.failures.mu.Unlock()
+	m.failures.reject = enabled
+}
+
+// SetSaveFault has fault called before every attempt at a background save;
+// an error it returns fails the attempt as if the backend had. It exists to
+// test how the store and its clients cope with a failing disk. appID is empty
+// for saves of a whole persona. A nil fault removes it.
+func (m *MemStore) SetSaveFault(fault func(personaID, appID string) error) {
+	m.failures.mu.Lock()
+	defer m.failures.mu.Unlock()
+	m.failures.fault = fault
+}
+
+// save runs a background save, retrying it with backoff, and records whether
+// the target is failing. Every failure is logged. Quarantined personas aren't
+// retried: they are reported separately.
+func (m *MemStore) save(name, target, personaID, appID string, save func() error) error {
+	m.failures.mu.Lock()
+	attempts, backoff, fault := m.failures.attempts, m.failures.backoff, m.failures.fault
+	m.failures.mu.Unlock()
+	if fault != nil {
+		backendSave := save
+		save = func() error {
+			if err := fault(personaID, appID); err != nil {
+				return err
+			}
+			return backendSave()
+		}
+	}
+	if attempts <= 0 {
+		attempts = DefaultSaveAttempts
+	}
+	if backoff <= 0 {
+		backoff = DefaultSaveBackoff
+	}
+
+	var err error
+	n := 0
+	for n < attempts {
+		n++
+		if err = m.traceSave(name, personaID, appID, save); err == nil || errors.Is(err, ErrPersonaQuarantined) {
+			break
+		}
+		err = m.scrubSaveError(err)
+		if n < attempts {
+			log.Printf("Warning: Could not save %s, retrying in %s (attempt %d of %d): %v", m.saveName(personaID, appID), backoff, n, attempts, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	m.failures.mu.Lock()
+	defer m.failures.mu.Unlock()
+	f, wasFailing := m.failures.failing[target]
+	switch {
+	case err == nil || errors.Is(err, ErrPersonaQuarantined):
+		if wasFailing {
+			delete(m.failures.failing, target)
+			log.Printf("Saving %s works again", m.saveName(personaID, appID))
+		}
+	default:
+		if !wasFailing {
+			f = sdk.SaveFailure{PersonaID: personaID, AppID: appID, Since: time.Now().UTC()}
+		}
+		f.Error, f.Attempts = err.Error(), f.Attempts+n
+		if m.failures.failing == nil {
+			m.failures.failing = make(map[string]sdk.SaveFailure)
+		}
+		m.failures.failing[target] = f
+		log.Printf("Error: Could not save %s after %d attempts, it is only in memory until a later save succeeds: %v", m.saveName(personaID, appID), n, err)
+	}
+	return err
+}
+
+// saveName names what a save writes in log messages.
+func (m *MemStore) saveName(personaID, appID string) string {
+	if appID == "" {
+		return "persona " + m.hasher.ID(personaID)
+	}
+	return "app " + appID + " of persona " + m.hasher.ID(personaID)
+}
+
+// scrubSaveError strips file paths, which contain the persona ID, from save
+// errors when persona IDs are being hashed.
+func (m *MemStore) scrubSaveError(err error) error {
+	var pathErr *fs.PathError
+	if m.hasher != nil && errors.As(err, &pathErr) {
+		return pathErr.Err
+	}
+	return err
+}
+
+// rejectingWrites returns sdk.ErrStorageFailure if writes are refused because
+// saves are failing.
+func (m *MemStore) rejectingWrites() error {
+	m.failures.mu.Lock()
+	defer m.failures.mu.Unlock()
+	if m.failures.reject && len(m.failures.failing) > 0 {
+		return sdk.ErrStorageFailure
+	}
+	return nil
+}
+
+// saveFailing reports whether the last save of any part of a persona failed,
+// which means memory holds the only copy of its latest data.
+func (m *MemStore) saveFailing(personaID string) bool {
+	m.failures.mu.Lock()
+	defer m.failures.mu.Unlock()
+	for _, f := range m.failures.failing {
+		if f.PersonaID == personaID {
+			return true
+		}
+	}
+	return false
+}
+
+// forgetSaveFailures drops the failures of a persona that was purged.
+func (m *MemStore) forgetSaveFailures(personaID string) {
+	m.failures.mu.Lock()
+	defer m.failures.mu.Unlock()
+	for target, f := range m.failures.failing {
+		if f.PersonaID == personaID {
+			delete(m.failures.failing, target)
+		}
+	}
+}
+
+// saveFailureList lists failing saves by persona and app, with hashed persona
+// IDs if the store hashes them.
+func (m *MemStore) saveFailureList() []sdk.SaveFailure {
+	m.failures.mu.Lock()
+	defer m.failures.mu.Unlock()
+	var list []sdk.SaveFailure
+	for _, f := range m.failures.failing {
+		f.PersonaID = m.hasher.ID(f.PersonaID)
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].PersonaID != list[j].PersonaID {
+			return list[i].PersonaID < list[j].PersonaID
+		}
+		return list[i].AppID < list[j].AppID
+	})
+	return list
+}
@@ -0,0 +1,142 @@
+package engine
+
+import "sync"
+
+// Defaults for the background save queue (see SetSaveQueue).
+const (
+	DefaultSaveWorkers    = 4
+	DefaultSaveQueueDepth = 1024
+)
+
+// saveQueue runs background saves on a bounded number of goroutines. Saves of
+// the same target (see saveOrder) coalesce while they wait: a newer snapshot
+// replaces the queued one, so a burst of writes to an app costs a few saves
+// rather than one per write. Once depth targets are waiting, writes wait for
+// room before they take the store lock (see waitForSaveRoom).
+type saveQueue struct {
+	mu      sync.Mutex
+	room    sync.Cond              // Signalled when a queued save starts; L is mu
+	workers int                    // 0 means DefaultSaveWorkers
+	depth   int                    // 0 means DefaultSaveQueueDepth
+	running int                    // Worker goroutines
+	queued  map[string]*queuedSave // By target, not started yet
+	order   []string               // Queued targets ready to start, oldest first
+	busy    map[string]bool        // Targets being saved
+}
+
+// queuedSave is the newest snapshot of a target waiting to be saved, along
+// with the writes waiting for it.
+type queuedSave struct {
+	personaID string
+	target    *saveTarget
+	seq       uint64
+	save      func() error
+	waits     []*saveWait
+}
+
+// SetSaveQueue bounds background saves to workers goroutines, with up to
+// depth apps (or personas, for backends saving whole personas) waiting to be
+// saved before writes are held up. Zero or less uses the defaults. Call it
+// before the store is used.
+func (m *MemStore) SetSaveQueue(workers, depth int) {
+	m.queue.mu.Lock()
+	defer m.queue.mu.Unlock()
+	m.queue.workers, m.queue.depth = workers, depth
+}
+
+// waitForSaveRoom blocks while the save queue is full. Writes call it before
+// taking the store lock, so readers are never held up by a full queue.
+func (m *MemStore) waitForSaveRoom() {
+	if m.persister == nil {
+		return
+	}
+	q := &m.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	depth := q.depth
+	if depth <= 0 {
+		depth = DefaultSaveQueueDepth
+	}
+	for len(q.queued) >= depth {
+		q.room.Wait()
+	}
+}
+
+// enqueueSave queues save, the save of snapshot seq of target (named key in
+// saveOrder), replacing the snapshot of key still waiting, if any. wait, if
+// not nil, must have been added to and is done once the save has landed or
+// was skipped for a newer one. It MUST be called while holding m.mu.Lock, where
+// the snapshot is taken, and never blocks.
+func (m *MemStore) enqueueSave(key, personaID string, target *saveTarget, seq uint64, wait *saveWait, save func() error) {
+	q := &m.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if e, ok := q.queued[key]; ok {
+		e.target, e.seq, e.save = target, seq, save
+		if wait != nil {
+			e.waits = append(e.waits, wait)
+		}
+		return
+	}
+
+	e := &queuedSave{personaID: personaID, target: target, seq: seq, save: save}
+	if wait != nil {
+		e.waits = append(e.waits, wait)
+	}
+	if q.queued == nil {
+		q.queued, q.busy = make(map[string]*queuedSave), make(map[string]bool)
+	}
+	q.queued[key] = e
+	m.wg.Add(1)
+	m.beginSave(personaID)
+	if !q.busy[key] {
+		q.order = append(q.order, key) // Otherwise it is queued once the running save ends
+	}
+
+	workers := q.workers
+	if workers <= 0 {
+		workers = DefaultSaveWorkers
+	}
+	if q.running < workers && len(q.order) > 0 {
+		q.running++
+		go m.saveWorker()
+	}
+}
+
+// saveWorker runs queued saves until none are ready to start. A target is
+// only ever saved by one worker at a time.
+func (m *MemStore) saveWorker() {
+	q := &m.queue
+	for {
+		q.mu.Lock()
+		if len(q.order) == 0 {
+			q.running--
+			q.mu.Unlock()
+			return
+		}
+		key := q.order[0]
+		q.order = q.order[1:]
+		e := q.queued[key]
+		delete(q.queued, key)
+		q.busy[key] = true
+		q.room.Broadcast()
+		q.mu.Unlock()
+
+		var err error
+		e.target.run(e.seq, func() { err = e.save() })
+		for _, w := range e.waits {
+			w.record(err)
+			w.wg.Done()
+		}
+		m.endSave(e.personaID)
+
+		q.mu.Lock()
+		delete(q.busy, key)
+		if _, ok := q.queued[key]; ok {
+			q.order = append(q.order, key)
+		}
+		q.mu.Unlock()
+		m.wg.Done()
+	}
+}
@@ -0,0 +1,25 @@
+package engine
+
+import "github.com/celerix-dev/celerix-store/pkg/sdk"
+
+// conformsLocked checks a value about to be written against the JSON Schema
+// registered for its app in _system/schemas, and checks schemas themselves as
+// they are registered. It MUST be called while holding m.mu.Lock.
+func (m *MemStore) conformsLocked(personaID, appID string, val any) error {
+	if personaID == SystemPersona && appID == sdk.SchemaApp {
+		_, err := sdk.CompileSchema(val)
+		return err
+	}
+	if err := m.residentLocked(SystemPersona); err != nil && err != ErrPersonaNotFound {
+		return err
+	}
+	def, ok := m.data[SystemPersona][sdk.SchemaApp][appID]
+	if !ok {
+		return nil
+	}
+	schema, err := sdk.CompileSchema(def)
+	if err != nil {
+		return err
+	}
+	return schema.Validate(val)
+}
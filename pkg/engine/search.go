@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"sort"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// Search finds keys by pattern under a single read lock, without copying
+// values. Evicted personas are read from the backend without loading them
+// back into memory.
+func (m *MemStore) Search(keyPattern string, opts sdk.SearchOptions) (sdk.SearchResult, error) {
+	search, err := sdk.NewKeySearch(keyPattern, opts)
+	if err != nil {
+		return sdk.SearchResult{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	personas := make([]string, 0, len(m.data)+len(m.memory.evicted))
+	for personaID := range m.data {
+		personas = append(personas, personaID)
+	}
+	for personaID := range m.memory.evicted {
+		personas = append(personas, personaID)
+	}
+	sort.Strings(personas)
+
+	loader, _ := m.persister.(PersonaLoader)
+	for _, personaID := range personas {
+		if !search.WantsPersona(personaID) {
+			continue
+		}
+		apps, ok := m.data[personaID]
+		if !ok && loader != nil {
+			if apps, err = loader.LoadPersona(personaID); err != nil {
+				continue
+			}
+		}
+		appIDs := make([]string, 0, len(apps))
+		for appID := range apps {
+			appIDs = append(appIDs, appID)
+		}
+		sort.Strings(appIDs)
+		for _, appID := range appIDs {
+			if !search.WantsApp(personaID, appID) {
+				continue
+			}
+			keys := make([]string, 0, len(apps[appID]))
+			for key := range apps[appID] {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if !search.Add(personaID, appID, keys) {
+				return search.Result(), nil
+			}
+		}
+	}
+	return search.Result(), nil
+}
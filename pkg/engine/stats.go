@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// DefaultTopContended is the number of namespaces reported by Stats.
+const DefaultTopContended = 10
+
+// maxTrackedNamespaces caps the namespaces whose contention is tracked. When
+// it is reached, the least contended half is forgotten to make room.
+const maxTrackedNamespaces = 10_000
+
+type nsKey struct {
+	personaID string
+	appID     string
+}
+
+// contention accumulates lock wait times and revision conflicts for a single
+// (persona, app) namespace.
+type contention struct {
+	writes    uint64
+	contended uint64
+	conflicts uint64
+	totalWait time.Duration
+	maxWait   time.Duration
+}
+
+// hotter reports whether c is more contended than o.
+func (c *contention) hotter(o *contention) bool {
+	if c.totalWait != o.totalWait {
+		return c.totalWait > o.totalWait
+	}
+	if c.conflicts != o.conflicts {
+		return c.conflicts > o.conflicts
+	}
+	return c.contended > o.contended
+}
+
+// contentionTracker records write lock waits and revision conflicts per
+// namespace.
+type contentionTracker struct {
+	mu   sync.Mutex
+	byNS map[nsKey]*contention
+}
+
+// entryLocked returns the record of a namespace, creating it if needed. It
+// MUST be called while holding t.mu.
+func (t *contentionTracker) entryLocked(personaID, appID string) *contention {
+	if t.byNS == nil {
+		t.byNS = make(map[nsKey]*contention)
+	}
+	k := nsKey{personaID, appID}
+	c := t.byNS[k]
+	if c == nil {
+		if len(t.byNS) >= maxTrackedNamespaces {
+			t.shrinkLocked()
+		}
+		c = &contention{}
+		t.byNS[k] = c
+	}
+	return c
+}
+
+// shrinkLocked forgets the least contended half of the namespaces. It MUST be
+// called while holding t.mu.
+func (t *contentionTracker) shrinkLocked() {
+	keys := make([]nsKey, 0, len(t.byNS))
+	for k := range t.byNS {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return t.byNS[keys[i]].hotter(t.byNS[keys[j]])
+	})
+	for _, k := range keys[len(keys)/2:] {
+		delete(t.byNS, k)
+	}
+}
+
+func (t *contentionTracker) record(personaID, appID string, wait time.Duration, contended bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c := t.entryLocked(personaID, appID)
+	c.writes++
+	if contended {
+		c.contended++
+		c.totalWait += wait
+		if wait > c.maxWait {
+			c.maxWait = wait
+		}
+	}
+}
+
+// conflict records a conditional write refused because the value had changed.
+func (t *contentionTracker) conflict(personaID, appID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.entryLocked(personaID, appID).conflicts++
+}
+
+// top returns the n namespaces with the highest total wait, then the most
+// conflicts.
+func (t *contentionTracker) top(n int) []sdk.NamespaceContention {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	keys := make([]nsKey, 0, len(t.byNS))
+	for k := range t.byNS {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return t.byNS[keys[i]].hotter(t.byNS[keys[j]])
+	})
+	if n > 0 && len(keys) > n {
+		keys = keys[:n]
+	}
+	list := make([]sdk.NamespaceContention, 0, len(keys))
+	for _, k := range keys {
+		c := t.byNS[k]
+		list = append(list, sdk.NamespaceContention{
+			PersonaID:   k.personaID,
+			AppID:       k.appID,
+			Writes:      c.writes,
+			Contended:   c.contended,
+			Conflicts:   c.conflicts,
+			TotalWaitMs: float64(c.totalWait) / float64(time.Millisecond),
+			MaxWaitMs:   float64(c.maxWait) / float64(time.Millisecond),
+		})
+	}
+	return list
+}
+
+// lockFor acquires the write lock on behalf of a (persona, app) namespace
+// and records whether, and for how long, the caller had to wait for it.
+func (m *MemStore) lockFor(personaID, appID string) {
+	if m.mu.TryLock() {
+		m.contention.record(personaID, appID, 0, false)
+		return
+	}
+	start := time.Now()
+	m.mu.Lock()
+	m.contention.record(personaID, appID, time.Since(start), true)
+}
+
+// Stats reports the size of the store, its memory use and the most contended
+// namespaces. Apps and keys of personas evicted from memory are not counted.
+func (m *MemStore) Stats() (sdk.Stats, error) {
+	m.mu.RLock()
+	stats := sdk.Stats{Personas: len(m.data) + len(m.memory.evicted)}
+	for _, apps := range m.data {
+		stats.Apps += len(apps)
+		for _, appData := range apps {
+			stats.Keys += len(appData)
+		}
+	}
+	m.memoryStats(&stats)
+	m.mu.RUnlock()
+
+	stats.FastMisses = m.existence.fastMisses.Load()
+	stats.TopContended = m.contention.top(DefaultTopContended)
+	for i := range stats.TopContended {
+		stats.TopContended[i].PersonaID = m.hasher.ID(stats.TopContended[i].PersonaID)
+	}
+	return stats, nil
+}
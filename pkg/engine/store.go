@@ -1,20 +1,93 @@
 // Package engine defines the core storage engine for the Celerix Store.
 package engine
 
-import "errors"
+import (
+	"io"
 
-// Standard errors for the engine.
-// Note: We keep these here for internal engine use, but SDK will have its own,
-// or we can alias them if we want exact matching.
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// Standard errors for the engine. They are the SDK's sentinels, so errors.Is
+// matches the same values whether a store is embedded or remote.
 var (
-	ErrPersonaNotFound = errors.New("persona not found")
-	ErrAppNotFound     = errors.New("app not found")
-	ErrKeyNotFound     = errors.New("key not found")
+	ErrPersonaNotFound = sdk.ErrPersonaNotFound
+	ErrAppNotFound     = sdk.ErrAppNotFound
+	ErrKeyNotFound     = sdk.ErrKeyNotFound
+	// ErrPersonaQuarantined is returned for writes to a persona whose file was corrupt on load.
+	ErrPersonaQuarantined = sdk.ErrPersonaQuarantined
+	// ErrMemoryLimit is returned for writes that would exceed the memory limit when nothing can be evicted.
+	ErrMemoryLimit = sdk.ErrMemoryLimit
 )
 
 // SystemPersona is the reserved ID for global/system-level data.
 const SystemPersona = "_system"
 
+// checkIDs rejects IDs that are unsafe as file and directory names (see
+// sdk.ValidateID) before anything is written. An empty key is not checked, for
+// calls that address a whole app. The quarantine directory sits beside the
+// persona directories, so it can't be a persona ID.
+func checkIDs(personaID, appID, key string) error {
+	if err := sdk.ValidateID("persona ID", personaID); err != nil {
+		return err
+	}
+	if personaID == QuarantineDir {
+		return &sdk.InvalidIDError{Kind: "persona ID", ID: personaID, Reason: "reserved"}
+	}
+	if err := sdk.ValidateID("app ID", appID); err != nil {
+		return err
+	}
+	if key == "" {
+		return nil
+	}
+	return sdk.ValidateID("key", key)
+}
+
+// StorageBackend is the durable storage behind a MemStore. The engine keeps all
+// data in memory and hands the backend a full snapshot of a persona whenever it
+// changes, so implementations only need whole-persona reads and writes.
+// Persistence (JSON files on disk) is the default; third parties can provide
+// S3, Postgres or Redis backends by implementing this interface.
+type StorageBackend interface {
+	// LoadAll returns every stored persona, keyed [personaID][appID][key].
+	LoadAll() (map[string]map[string]map[string]any, error)
+	// SavePersona replaces the stored state of a persona.
+	SavePersona(personaID string, data map[string]map[string]any) error
+	// DeletePersona removes a persona. Deleting a missing persona is not an error.
+	DeletePersona(personaID string) error
+	// Close releases any resources held by the backend.
+	Close() error
+}
+
+// PersonaLoader is an optional StorageBackend extension for reading a single
+// persona. It lets MemStore unload personas under memory pressure (EvictLRU)
+// and load them again on demand.
+type PersonaLoader interface {
+	// LoadPersona returns a stored persona, keyed [appID][key].
+	LoadPersona(personaID string) (map[string]map[string]any, error)
+}
+
+// PersonaPurger is an optional StorageBackend extension for erasing a persona
+// completely, including copies DeletePersona leaves alone, such as quarantined
+// files. MemStore.PurgePersona falls back to DeletePersona without it.
+type PersonaPurger interface {
+	// PurgePersona removes everything stored for a persona. Purging a
+	// missing persona is not an error.
+	PurgePersona(personaID string) error
+}
+
+// BlobBackend is an optional StorageBackend extension for binary values
+// ("blobs"). Blobs are streamed to and from the backend, never held in memory
+// or included in persona snapshots. MemStore's blob methods need it.
+type BlobBackend interface {
+	// WriteBlob stores everything read from r, replacing any blob at key,
+	// and returns the number of bytes stored.
+	WriteBlob(personaID, appID, key string, r io.Reader) (int64, error)
+	// OpenBlob opens a blob for reading and reports its size, or returns ErrKeyNotFound.
+	OpenBlob(personaID, appID, key string) (io.ReadCloser, int64, error)
+	// DeleteBlob removes a blob. Deleting a missing blob is not an error.
+	DeleteBlob(personaID, appID, key string) error
+}
+
 // AppScope and VaultScope interfaces are now defined in pkg/sdk.
 // We use 'any' or specific types if needed, but the engine implementations
 // will satisfy the sdk interfaces.
@@ -0,0 +1,33 @@
+package engine
+
+import (
+	"context"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// SetTracer makes the store start a span for every write to its storage
+// backend. Backend writes happen in the background, after the command that
+// caused them has returned, so their spans start traces of their own. Call it
+// before the store is used.
+func (m *MemStore) SetTracer(tracer sdk.Tracer) {
+	m.tracer = tracer
+}
+
+// traceSave runs save, in a span named name if the store has a tracer, and
+// returns its error. Persona IDs are hashed with the store's PersonaHasher, if
+// it has one.
+func (m *MemStore) traceSave(name, personaID, appID string, save func() error) error {
+	if m.tracer == nil {
+		return save()
+	}
+	_, span := m.tracer.Start(context.Background(), name, "")
+	span.SetAttribute("db.system", "celerix")
+	span.SetAttribute("celerix.persona", m.hasher.ID(personaID))
+	if appID != "" {
+		span.SetAttribute("celerix.app", appID)
+	}
+	err := save()
+	span.End(err)
+	return err
+}
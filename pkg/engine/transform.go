@@ -0,0 +1,234 @@
+package engine
+
+import (
+	"fmt"
+	"path"
+	"plugin"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// Transformer rewrites a value about to be written, e.g. to normalize it, and
+// returns what to store instead. An error refuses the write and is returned
+// by it unchanged. It must not modify val: return a changed copy.
+type Transformer func(personaID, appID, key string, val any) (any, error)
+
+// appTransformer is a Transformer enabled for the apps matching one of apps,
+// or for every app if there are none.
+type appTransformer struct {
+	transform Transformer
+	apps      []string
+}
+
+// AddTransformer runs t on the values written to the apps matching one of
+// appPatterns (path.Match patterns), or to every app if none are given:
+// by Set and its conditional variants, Merge (on the merged value) and
+// SetBatch, and by MergePersona on the values it gives the destination.
+// Values moved between personas were transformed when they were first
+// written, and the _system persona is never transformed.
+//
+// Transformers run in the order they were added, before the app's schema is
+// checked and before OnBeforeSet hooks, under the rules of OnBeforeSet.
+func (m *MemStore) AddTransformer(t Transformer, appPatterns ...string) error {
+	for _, pattern := range appPatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid app pattern %q: %w", pattern, err)
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks.transformers = append(m.hooks.transformers, appTransformer{t, appPatterns})
+	return nil
+}
+
+// transformLocked runs the transformers enabled for appID on val. It MUST be
+// called while holding m.mu.Lock.
+func (m *MemStore) transformLocked(personaID, appID, key string, val any) (any, error) {
+	if personaID == SystemPersona {
+		return val, nil
+	}
+	for _, t := range m.hooks.transformers {
+		if !matchesAny(t.apps, appID) {
+			continue
+		}
+		var err error
+		if val, err = t.transform(personaID, appID, key, val); err != nil {
+			return nil, err
+		}
+	}
+	return val, nil
+}
+
+func matchesAny(patterns []string, appID string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, appID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Names of the built-in transformers.
+const (
+	// TransformStripNulls removes null fields from objects, at any depth.
+	TransformStripNulls = "strip_nulls"
+	// TransformLowercaseKeys lowercases the field names of objects, at any
+	// depth, and refuses values in which two names become the same.
+	TransformLowercaseKeys = "lowercase_keys"
+	// TransformNormalizeTimestamps rewrites strings holding a timestamp with
+	// a numeric time zone (RFC 3339, RFC 1123Z or "2006-01-02 15:04:05-07:00")
+	// as RFC 3339 in UTC, so they sort and compare as strings.
+	TransformNormalizeTimestamps = "normalize_timestamps"
+)
+
+var transformers = struct {
+	sync.RWMutex
+	byName map[string]Transformer
+}{byName: map[string]Transformer{
+	TransformStripNulls:          transformCopy(stripNulls),
+	TransformLowercaseKeys:       transformCopy(lowercaseKeys),
+	TransformNormalizeTimestamps: transformCopy(normalizeTimestamps),
+}}
+
+// RegisterTransformer makes t available by name to LookupTransformer, e.g.
+// for CELERIX_TRANSFORMS. It replaces a transformer of the same name.
+func RegisterTransformer(name string, t Transformer) {
+	transformers.Lock()
+	defer transformers.Unlock()
+	transformers.byName[name] = t
+}
+
+// LookupTransformer returns the transformer registered as name.
+func LookupTransformer(name string) (Transformer, error) {
+	transformers.RLock()
+	defer transformers.RUnlock()
+	if t, ok := transformers.byName[name]; ok {
+		return t, nil
+	}
+	names := make([]string, 0, len(transformers.byName))
+	for n := range transformers.byName {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown transformer %q (have %s)", name, strings.Join(names, ", "))
+}
+
+// LoadTransformerPlugin opens a Go plugin (built with -buildmode=plugin
+// against the same version of this module) and registers the transformers in
+// its exported Transformers variable, a map from name to Transformer or to a
+// plain func with the same signature. It returns the names registered.
+// Plugins need a binary built with cgo, on Linux, macOS or FreeBSD.
+func LoadTransformerPlugin(file string) ([]string, error) {
+	p, err := plugin.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup("Transformers")
+	if err != nil {
+		return nil, err
+	}
+	var found map[string]Transformer
+	switch v := sym.(type) {
+	case *map[string]Transformer:
+		found = *v
+	case *map[string]func(personaID, appID, key string, val any) (any, error):
+		found = make(map[string]Transformer, len(*v))
+		for name, fn := range *v {
+			found[name] = fn
+		}
+	default:
+		return nil, fmt.Errorf("%s: Transformers is a %T, want map[string]engine.Transformer", file, sym)
+	}
+	names := make([]string, 0, len(found))
+	for name, t := range found {
+		RegisterTransformer(name, t)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// transformCopy makes a Transformer of a function rewriting the generic
+// types JSON decodes into, which gets a copy of the value it may change.
+// Values of other types, such as structs stored in embedded mode, reach it
+// unchanged and are passed over.
+func transformCopy(fn func(val any) (any, error)) Transformer {
+	return func(personaID, appID, key string, val any) (any, error) {
+		return fn(deepCopy(val))
+	}
+}
+
+func stripNulls(val any) (any, error) {
+	switch v := val.(type) {
+	case map[string]any:
+		for name, field := range v {
+			if field == nil {
+				delete(v, name)
+			} else {
+				v[name], _ = stripNulls(field)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			v[i], _ = stripNulls(item)
+		}
+	}
+	return val, nil
+}
+
+func lowercaseKeys(val any) (any, error) {
+	switch v := val.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for name, field := range v {
+			lower := strings.ToLower(name)
+			if _, dup := out[lower]; dup {
+				return nil, fmt.Errorf("%w: fields differing only in case: %q", sdk.ErrBadRequest, lower)
+			}
+			var err error
+			if out[lower], err = lowercaseKeys(field); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case []any:
+		for i, item := range v {
+			var err error
+			if v[i], err = lowercaseKeys(item); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return val, nil
+}
+
+// timestampLayouts are the layouts normalize_timestamps recognizes. Each has
+// a numeric time zone, so the instant is never guessed.
+var timestampLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05Z07:00", time.RFC1123Z}
+
+func normalizeTimestamps(val any) (any, error) {
+	switch v := val.(type) {
+	case string:
+		for _, layout := range timestampLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t.UTC().Format(time.RFC3339Nano), nil
+			}
+		}
+	case map[string]any:
+		for name, field := range v {
+			v[name], _ = normalizeTimestamps(field)
+		}
+	case []any:
+		for i, item := range v {
+			v[i], _ = normalizeTimestamps(item)
+		}
+	}
+	return val, nil
+}
@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"sort"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// Usage counts keys and bytes per persona and app under a single read lock.
+// Sizes are those of the memory accounting, so the apps held in memory add up
+// to MemoryBytes in Stats. Evicted personas are read from the backend and
+// sized the same way, without loading them back into memory. Persona IDs are
+// hashed like in Stats.
+func (m *MemStore) Usage() (sdk.UsageReport, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	personas := make([]string, 0, len(m.data)+len(m.memory.evicted))
+	for personaID := range m.data {
+		personas = append(personas, personaID)
+	}
+	for personaID := range m.memory.evicted {
+		personas = append(personas, personaID)
+	}
+	sort.Strings(personas)
+
+	report := sdk.UsageReport{Apps: []sdk.AppUsage{}}
+	loader, _ := m.persister.(PersonaLoader)
+	for _, personaID := range personas {
+		apps, loaded := m.data[personaID]
+		if !loaded && loader != nil {
+			var err error
+			if apps, err = loader.LoadPersona(personaID); err != nil {
+				continue
+			}
+		}
+		appIDs := make([]string, 0, len(apps))
+		for appID := range apps {
+			appIDs = append(appIDs, appID)
+		}
+		sort.Strings(appIDs)
+		for _, appID := range appIDs {
+			usage := sdk.AppUsage{PersonaID: m.hasher.ID(personaID), AppID: appID, Keys: len(apps[appID])}
+			if size, ok := m.memory.byNS[nsKey{personaID, appID}]; ok && loaded {
+				usage.Bytes = size
+			} else {
+				for key, val := range apps[appID] {
+					usage.Bytes += entrySize(key, val)
+				}
+			}
+			report.Add(usage)
+		}
+	}
+	return report, nil
+}
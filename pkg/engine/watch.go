@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// watchBufferSize is the number of events a subscriber may lag behind
+// before it is disconnected.
+const watchBufferSize = 256
+
+type subscription struct {
+	personaID string
+	appID     string
+	prefix    string
+	ch        chan sdk.ChangeEvent
+	done      chan struct{} // Closed with ch, once the subscription ends
+}
+
+// broker fans change events out to subscribers.
+type broker struct {
+	mu   sync.Mutex
+	subs map[*subscription]struct{}
+}
+
+func (b *broker) subscribe(personaID, appID, prefix string) *subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subs == nil {
+		b.subs = make(map[*subscription]struct{})
+	}
+	s := &subscription{
+		personaID: personaID,
+		appID:     appID,
+		prefix:    prefix,
+		ch:        make(chan sdk.ChangeEvent, watchBufferSize),
+		done:      make(chan struct{}),
+	}
+	b.subs[s] = struct{}{}
+	return s
+}
+
+func (b *broker) unsubscribe(s *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[s]; ok {
+		b.dropLocked(s)
+	}
+}
+
+// dropLocked ends a subscription. It MUST be called while holding b.mu.
+func (b *broker) dropLocked(s *subscription) {
+	delete(b.subs, s)
+	close(s.ch)
+	close(s.done)
+}
+
+// publish never blocks: a subscriber whose buffer is full is dropped so a slow
+// consumer can't stall writers.
+func (b *broker) publish(e sdk.ChangeEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subs {
+		if !e.Matches(s.personaID, s.appID, s.prefix) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			b.dropLocked(s)
+		}
+	}
+}
+
+// notify publishes a change event.
+// It is called while holding m.mu so subscribers observe writes in commit order.
+func (m *MemStore) notify(op, personaID, appID, key string, val any) {
+	m.raw.forget(nsKey{personaID, appID}, key)
+	val = m.copyValue(val)
+	m.runAfterHooksLocked(op, personaID, appID, key, val)
+	m.events.publish(sdk.ChangeEvent{
+		Op:        op,
+		PersonaID: personaID,
+		AppID:     appID,
+		Key:       key,
+		Value:     val,
+		Time:      time.Now().UTC(),
+	})
+}
+
+// Watch subscribes to changes for a persona/app whose keys start with prefix.
+// Use sdk.WatchAll as personaID or appID to match everything.
+func (m *MemStore) Watch(ctx context.Context, personaID, appID, prefix string) (<-chan sdk.ChangeEvent, error) {
+	s := m.events.subscribe(personaID, appID, prefix)
+	go func() {
+		select {
+		case <-ctx.Done():
+			m.events.unsubscribe(s)
+		case <-s.done: // Dropped for lagging
+		}
+	}()
+	return s.ch, nil
+}
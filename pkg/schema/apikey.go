@@ -0,0 +1,17 @@
+package schema
+
+import "time"
+
+// APIKeyRecord describes an API key for the HTTP API. It is typically stored
+// in the '_system' persona under the 'api_keys' app, keyed by ID.
+type APIKeyRecord struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Scopes are "read", "write" and "admin"; each includes the ones before.
+	Scopes []string `json:"scopes"`
+	// SecretHash is the hex SHA-256 hash of the key's secret. The key itself
+	// is only shown once, when it is created.
+	SecretHash string    `json:"secret_hash,omitempty"`
+	LastUsed   time.Time `json:"last_used"`
+	CreatedAt  time.Time `json:"created_at"`
+}
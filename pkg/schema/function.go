@@ -0,0 +1,16 @@
+package schema
+
+import "time"
+
+// FunctionRecord describes a compute function: a WebAssembly module the
+// COMPUTE command runs against an app's data. It is typically stored in the
+// '_system' persona under the 'functions' app, keyed by name.
+type FunctionRecord struct {
+	Name string `json:"name"`
+	// WASM is the compiled module, base64 in JSON. It is left out of lists.
+	WASM []byte `json:"wasm,omitempty"`
+	// SHA256 is the hex SHA-256 hash of WASM.
+	SHA256    string    `json:"sha256"`
+	Size      int       `json:"size"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,14 @@
+package schema
+
+import "time"
+
+// Metadata describes a persona or an app for people browsing the store, who
+// would otherwise only see opaque IDs. It is stored in the persona's '_meta'
+// app.
+type Metadata struct {
+	DisplayName string            `json:"display_name,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	// CreatedAt is set when metadata is first attached and kept afterwards.
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,14 @@
+package schema
+
+import "time"
+
+// ShareLinkRecord describes a share link granting read access to one app of
+// one persona. It is typically stored in the '_system' persona under the
+// 'share_links' app, keyed by ID; deleting it revokes the link.
+type ShareLinkRecord struct {
+	ID        string    `json:"id"`
+	PersonaID string    `json:"persona_id"`
+	AppID     string    `json:"app_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
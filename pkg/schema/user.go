@@ -6,10 +6,15 @@ import "time"
 // UserRecord represents a standardized user identity within the Celerix ecosystem.
 // It is typically stored in the '_system' persona under the 'users' app.
 type UserRecord struct {
-	ID           string    `json:"id"`
-	Username     string    `json:"username"`
-	DisplayName  string    `json:"display_name"`
-	RecoveryCode string    `json:"recovery_code,omitempty"`
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	// RecoveryCode is the hex SHA-256 hash of the user's recovery code. The
+	// code itself is only shown once, when it is generated.
+	RecoveryCode string `json:"recovery_code,omitempty"`
+	// PasswordHash is the user's password hashed with argon2id, if they have one.
+	PasswordHash string    `json:"password_hash,omitempty"`
+	Disabled     bool      `json:"disabled,omitempty"`
 	LastActive   time.Time `json:"last_active"`
 	CreatedAt    time.Time `json:"created_at"`
 }
@@ -0,0 +1,188 @@
+package sdk
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/schema"
+)
+
+// APIKeysApp is the app in the _system persona holding API key records by ID.
+const APIKeysApp = "api_keys"
+
+// APIKeyPrefix starts every API key, which reads cxk_<id>_<secret>, so keys
+// can be told apart from access tokens and spotted by secret scanners.
+const APIKeyPrefix = "cxk_"
+
+// API key scopes. Each includes the ones before it: a write key can read and
+// an admin key can do anything.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+var scopeRank = map[string]int{ScopeRead: 1, ScopeWrite: 2, ScopeAdmin: 3}
+
+// HasScope reports whether key grants scope.
+func HasScope(key schema.APIKeyRecord, scope string) bool {
+	for _, s := range key.Scopes {
+		if scopeRank[s] >= scopeRank[scope] {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAPIKey reports whether token looks like an API key rather than an access
+// token.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// APIKeyStore manages API keys in the _system persona. Only the hashes of
+// their secrets are stored, so reading _system doesn't hand out usable keys.
+type APIKeyStore struct {
+	store KVStore
+}
+
+// NewAPIKeyStore manages the API keys held in s.
+func NewAPIKeyStore(s KVStore) *APIKeyStore {
+	return &APIKeyStore{store: s}
+}
+
+// Create issues a key with the given scopes. The key is returned only here.
+func (k *APIKeyStore) Create(name string, scopes []string) (string, schema.APIKeyRecord, error) {
+	if err := checkScopes(scopes); err != nil {
+		return "", schema.APIKeyRecord{}, err
+	}
+	raw := make([]byte, 8+32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", schema.APIKeyRecord{}, err
+	}
+	id, secret := hex.EncodeToString(raw[:8]), hex.EncodeToString(raw[8:])
+	rec, err := k.save(id, secret, name, scopes)
+	if err != nil {
+		return "", schema.APIKeyRecord{}, err
+	}
+	return APIKeyPrefix + id + "_" + secret, rec, nil
+}
+
+// minSecretLength is the shortest secret Add accepts, in characters.
+const minSecretLength = 32
+
+// Add registers a key chosen by the caller rather than issued by Create, such
+// as a fixed key in a seed file that integration tests log in with. It must
+// read cxk_<id>_<secret>, with a secret of at least 32 characters, and its ID
+// must not be taken.
+func (k *APIKeyStore) Add(key, name string, scopes []string) (schema.APIKeyRecord, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(key, APIKeyPrefix), "_")
+	if !ok || !IsAPIKey(key) || ValidateID("API key ID", id) != nil || len(secret) < minSecretLength {
+		return schema.APIKeyRecord{}, fmt.Errorf("API keys read %s<id>_<secret of %d or more characters>: %w", APIKeyPrefix, minSecretLength, ErrBadRequest)
+	}
+	if err := checkScopes(scopes); err != nil {
+		return schema.APIKeyRecord{}, err
+	}
+	if _, err := k.store.Get(SystemPersona, APIKeysApp, id); err == nil {
+		return schema.APIKeyRecord{}, fmt.Errorf("API key %s already exists: %w", id, ErrConflict)
+	} else if !IsNotFound(err) {
+		return schema.APIKeyRecord{}, err
+	}
+	return k.save(id, secret, name, scopes)
+}
+
+// checkScopes refuses keys without scopes or with unknown ones.
+func checkScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("an API key needs at least one scope: %w", ErrBadRequest)
+	}
+	for _, scope := range scopes {
+		if scopeRank[scope] == 0 {
+			return fmt.Errorf("unknown scope %q (expected read, write or admin): %w", scope, ErrBadRequest)
+		}
+	}
+	return nil
+}
+
+// save stores a key with checked scopes and returns its record without the
+// secret hash.
+func (k *APIKeyStore) save(id, secret, name string, scopes []string) (schema.APIKeyRecord, error) {
+	rec := schema.APIKeyRecord{
+		ID:         id,
+		Name:       name,
+		Scopes:     scopes,
+		SecretHash: hashToken(secret),
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := k.store.Set(SystemPersona, APIKeysApp, id, rec); err != nil {
+		return schema.APIKeyRecord{}, err
+	}
+	rec.SecretHash = ""
+	return rec, nil
+}
+
+// Verify checks a key and returns its record, with LastUsed updated at most
+// once a minute. Unknown, malformed and revoked keys get ErrInvalidToken.
+func (k *APIKeyStore) Verify(key string) (schema.APIKeyRecord, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(key, APIKeyPrefix), "_")
+	if !ok || !IsAPIKey(key) || ValidateID("API key ID", id) != nil {
+		return schema.APIKeyRecord{}, ErrInvalidToken
+	}
+	rec, err := Get[schema.APIKeyRecord](k.store, SystemPersona, APIKeysApp, id)
+	if err != nil {
+		if IsNotFound(err) {
+			return schema.APIKeyRecord{}, ErrInvalidToken
+		}
+		return schema.APIKeyRecord{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(rec.SecretHash)) != 1 {
+		return schema.APIKeyRecord{}, ErrInvalidToken
+	}
+	if now := time.Now().UTC(); now.Sub(rec.LastUsed) >= activityInterval {
+		rec.LastUsed = now
+		if err := k.store.Set(SystemPersona, APIKeysApp, id, rec); err != nil {
+			return schema.APIKeyRecord{}, err
+		}
+	}
+	rec.SecretHash = ""
+	return rec, nil
+}
+
+// List returns the keys, without their secret hashes, sorted by name.
+func (k *APIKeyStore) List() ([]schema.APIKeyRecord, error) {
+	exporter, ok := k.store.(BatchExporter)
+	if !ok {
+		return nil, fmt.Errorf("listing API keys: %w", ErrNotSupported)
+	}
+	data, err := exporter.GetAppStore(SystemPersona, APIKeysApp)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	keys := make([]schema.APIKeyRecord, 0, len(data))
+	for id := range data {
+		rec, err := Get[schema.APIKeyRecord](k.store, SystemPersona, APIKeysApp, id)
+		if err != nil {
+			return nil, err
+		}
+		rec.SecretHash = ""
+		keys = append(keys, rec)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys, nil
+}
+
+// Revoke deletes a key by ID. Revoking an unknown key is not an error.
+func (k *APIKeyStore) Revoke(id string) error {
+	if err := k.store.Delete(SystemPersona, APIKeysApp, id); err != nil && !IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package sdk
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/schema"
+)
+
+// TokensApp holds issued tokens in the _system persona, keyed by their SHA-256
+// hash so that reading _system doesn't hand out usable tokens.
+// RevocationsApp holds, per user ID, the time before which all of the user's
+// tokens are revoked.
+const (
+	TokensApp      = "auth_tokens"
+	RevocationsApp = "auth_revocations"
+)
+
+// Default lifetimes of the tokens issued by a TokenStore.
+const (
+	DefaultAccessTTL  = 15 * time.Minute
+	DefaultRefreshTTL = 30 * 24 * time.Hour
+)
+
+// ErrInvalidToken is returned for unknown, expired and revoked tokens.
+var ErrInvalidToken = NewProtocolError(CodeUnauthorized, "invalid or expired token")
+
+// Tokens is a pair issued by Login or Refresh. The access token authenticates
+// requests (HTTP "Authorization: Bearer" or the TCP AUTH command) until
+// ExpiresAt; the refresh token trades for a new pair until RefreshExpiresAt.
+type Tokens struct {
+	AccessToken      string    `json:"access_token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	UserID           string    `json:"user_id"`
+}
+
+// tokenRecord is what is stored for an issued token.
+type tokenRecord struct {
+	UserID    string    `json:"user_id"`
+	Refresh   bool      `json:"refresh,omitempty"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// TokenStore issues opaque, short-lived tokens for the users of a UserStore
+// and keeps them in the _system persona, so every daemon or service sharing
+// the store accepts them.
+type TokenStore struct {
+	store      KVStore
+	users      *UserStore
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+// NewTokenStore issues tokens for users. Zero lifetimes use DefaultAccessTTL
+// and DefaultRefreshTTL.
+func NewTokenStore(s KVStore, users *UserStore, accessTTL, refreshTTL time.Duration) *TokenStore {
+	if accessTTL <= 0 {
+		accessTTL = DefaultAccessTTL
+	}
+	if refreshTTL <= 0 {
+		refreshTTL = DefaultRefreshTTL
+	}
+	return &TokenStore{store: s, users: users, accessTTL: accessTTL, refreshTTL: refreshTTL}
+}
+
+// Login checks a username and password and issues a token pair.
+func (t *TokenStore) Login(username, password string) (Tokens, error) {
+	user, err := t.users.Authenticate(username, password)
+	if err != nil {
+		return Tokens{}, err
+	}
+	return t.issue(user.ID)
+}
+
+// Refresh trades a refresh token for a new pair. The old refresh token is
+// revoked, so each can only be used once.
+func (t *TokenStore) Refresh(refreshToken string) (Tokens, error) {
+	rec, err := t.lookup(refreshToken, true)
+	if err != nil {
+		return Tokens{}, err
+	}
+	if _, err := t.users.Touch(rec.UserID); err != nil {
+		return Tokens{}, err
+	}
+	if err := t.Revoke(refreshToken); err != nil {
+		return Tokens{}, err
+	}
+	return t.issue(rec.UserID)
+}
+
+// Verify checks an access token and returns its user, marked active.
+func (t *TokenStore) Verify(accessToken string) (schema.UserRecord, error) {
+	rec, err := t.lookup(accessToken, false)
+	if err != nil {
+		return schema.UserRecord{}, err
+	}
+	user, err := t.users.Touch(rec.UserID)
+	if IsNotFound(err) {
+		return schema.UserRecord{}, ErrInvalidToken // The user was deleted
+	}
+	return user, err
+}
+
+// Revoke invalidates an access or refresh token. Revoking an unknown token is
+// not an error.
+func (t *TokenStore) Revoke(token string) error {
+	if err := t.store.Delete(SystemPersona, TokensApp, hashToken(token)); err != nil && !IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// RevokeUser invalidates every token issued to a user so far, e.g. after a
+// password change or a lost device.
+func (t *TokenStore) RevokeUser(userID string) error {
+	return t.store.Set(SystemPersona, RevocationsApp, userID, time.Now().UTC())
+}
+
+func (t *TokenStore) issue(userID string) (Tokens, error) {
+	now := time.Now().UTC()
+	tokens := Tokens{UserID: userID, ExpiresAt: now.Add(t.accessTTL), RefreshExpiresAt: now.Add(t.refreshTTL)}
+	var err error
+	if tokens.AccessToken, err = t.newToken(userID, false, now, tokens.ExpiresAt); err != nil {
+		return Tokens{}, err
+	}
+	if tokens.RefreshToken, err = t.newToken(userID, true, now, tokens.RefreshExpiresAt); err != nil {
+		t.Revoke(tokens.AccessToken)
+		return Tokens{}, err
+	}
+	return tokens, nil
+}
+
+// newToken creates a random token and stores its record.
+func (t *TokenStore) newToken(userID string, refresh bool, now, expires time.Time) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+	rec := tokenRecord{UserID: userID, Refresh: refresh, IssuedAt: now, ExpiresAt: expires}
+	if err := t.store.Set(SystemPersona, TokensApp, hashToken(token), rec); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// lookup returns the record of a live token of the given kind. Expired tokens
+// are deleted on the way.
+func (t *TokenStore) lookup(token string, refresh bool) (tokenRecord, error) {
+	if token == "" {
+		return tokenRecord{}, ErrInvalidToken
+	}
+	rec, err := Get[tokenRecord](t.store, SystemPersona, TokensApp, hashToken(token))
+	if err != nil {
+		if IsNotFound(err) {
+			return tokenRecord{}, ErrInvalidToken
+		}
+		return tokenRecord{}, err
+	}
+	if time.Now().After(rec.ExpiresAt) {
+		t.Revoke(token)
+		return tokenRecord{}, ErrInvalidToken
+	}
+	if rec.Refresh != refresh {
+		return tokenRecord{}, ErrInvalidToken
+	}
+	revokedAt, err := Get[time.Time](t.store, SystemPersona, RevocationsApp, rec.UserID)
+	if err == nil && !rec.IssuedAt.After(revokedAt) {
+		return tokenRecord{}, ErrInvalidToken
+	}
+	if err != nil && !IsNotFound(err) {
+		return tokenRecord{}, err
+	}
+	return rec, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
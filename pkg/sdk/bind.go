@@ -0,0 +1,132 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// rebindDelay is how long a Binding waits before resubscribing after the
+// store dropped its change stream.
+const rebindDelay = time.Second
+
+// Binding keeps a value bound by Bind up to date until Close. Updates replace
+// the bound value while holding the Binding's lock, so readers sharing it with
+// other goroutines should hold RLock while reading.
+type Binding struct {
+	mu   sync.RWMutex
+	stop context.CancelFunc
+}
+
+// RLock locks the bound value against updates.
+func (b *Binding) RLock() { b.mu.RLock() }
+
+// RUnlock undoes RLock.
+func (b *Binding) RUnlock() { b.mu.RUnlock() }
+
+// Close stops updating the bound value.
+func (b *Binding) Close() error {
+	b.stop()
+	return nil
+}
+
+// Bind decodes the JSON value of key into target and keeps it updated as the
+// key changes, for typed live configuration:
+//
+//	cfg := Config{Timeout: 30} // defaults
+//	b, err := sdk.Bind(client.App("svc", "config"), "main", &cfg, func(cfg Config, err error) {
+//	    log.Printf("config reloaded: %+v (%v)", cfg, err)
+//	})
+//
+// Whatever target holds when Bind is called are the defaults: every update
+// decodes the stored value over a fresh copy of them, and a missing or
+// deleted key restores them. After each change onChange, if set, is called
+// with the new value, or with the unchanged value and the reason when a
+// stored value doesn't decode. The scope must be a ScopeWatcher, as the
+// engine's and the remote client's are. If the change stream is dropped, Bind
+// resubscribes and reloads by itself.
+func Bind[T any](scope AppScope, key string, target *T, onChange func(cfg T, err error)) (*Binding, error) {
+	watcher, ok := scope.(ScopeWatcher)
+	if !ok {
+		return nil, fmt.Errorf("binding %s: %w", key, ErrNotSupported)
+	}
+	defaults, err := json.Marshal(target)
+	if err != nil {
+		return nil, fmt.Errorf("binding %s: %w", key, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Binding{stop: cancel}
+	// apply replaces target with val decoded over the defaults.
+	apply := func(val any, exists bool) error {
+		var next T
+		if err := json.Unmarshal(defaults, &next); err != nil {
+			return err
+		}
+		if exists {
+			data, err := json.Marshal(val)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(data, &next); err != nil {
+				return err
+			}
+		}
+		b.mu.Lock()
+		*target = next
+		b.mu.Unlock()
+		return nil
+	}
+	// reload reads the current value, subscribing first so no change is missed.
+	reload := func() (<-chan ChangeEvent, error) {
+		events, err := watcher.Watch(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		val, err := scope.Get(key)
+		if err != nil && !IsNotFound(err) {
+			return events, err
+		}
+		return events, apply(val, err == nil)
+	}
+	notify := func(err error) {
+		if onChange == nil {
+			return
+		}
+		b.mu.RLock()
+		cfg := *target
+		b.mu.RUnlock()
+		onChange(cfg, err)
+	}
+
+	events, err := reload()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("binding %s: %w", key, err)
+	}
+	go func() {
+		for {
+			for e := range events {
+				if e.Key == key {
+					notify(apply(e.Value, e.Op != OpDelete))
+				}
+			}
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(rebindDelay):
+				}
+				var err error
+				events, err = reload()
+				notify(err)
+				if events != nil {
+					break
+				}
+			}
+		}
+	}()
+	return b, nil
+}
@@ -0,0 +1,233 @@
+package sdk
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BlobStore is implemented by stores that keep binary values ("blobs") beside
+// the JSON ones. Blobs are streamed rather than held in memory, so they avoid
+// the base64 overhead of JSON and the line length of the protocol. They live
+// in their own key space (a blob and a JSON value may share a key) and are not
+// listed by GetAppStore or DumpApp. It is optional: callers should type-assert
+// a CelerixStore to check for support.
+type BlobStore interface {
+	// SetBlob stores everything read from r, replacing any blob at key, and
+	// returns the number of bytes stored. The old blob stays readable until r
+	// has been read to the end.
+	SetBlob(personaID, appID, key string, r io.Reader) (int64, error)
+	// GetBlob opens a blob for reading and reports its size. The caller must close it.
+	GetBlob(personaID, appID, key string) (io.ReadCloser, int64, error)
+	// DeleteBlob removes a blob. Deleting a missing blob is not an error.
+	DeleteBlob(personaID, appID, key string) error
+}
+
+// Blobs travel as chunks: a line with the chunk length, then that many raw
+// bytes. A zero length ends the blob. BlobChunkSize is the size of the chunks
+// the SDK and daemon send; MaxBlobChunk is the largest either accepts.
+const (
+	BlobChunkSize = 64 * 1024
+	MaxBlobChunk  = 1024 * 1024
+)
+
+// WriteBlobChunks copies r to w as blob chunks, ending with the zero-length
+// chunk. beforeChunk, if set, is called before each chunk is written, e.g. to
+// extend a write deadline.
+func WriteBlobChunks(w io.Writer, r io.Reader, beforeChunk func()) (int64, error) {
+	buf := make([]byte, BlobChunkSize)
+	var total int64
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if beforeChunk != nil {
+				beforeChunk()
+			}
+			if _, werr := fmt.Fprintf(w, "%d\n", n); werr != nil {
+				return total, werr
+			}
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return total, werr
+			}
+			total += int64(n)
+		}
+		if err == io.EOF {
+			if beforeChunk != nil {
+				beforeChunk()
+			}
+			_, werr := fmt.Fprint(w, "0\n")
+			return total, werr
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// BlobChunkReader reads the blob chunks written by WriteBlobChunks, returning
+// io.EOF after the zero-length chunk. A line starting with ERR in place of a
+// chunk length, sent when the other side fails part way, is returned as its
+// error with ParseError in protocol version Protocol.
+type BlobChunkReader struct {
+	Reader   *bufio.Reader
+	Protocol int
+	// BeforeChunk, if set, is called before each chunk length is read, e.g.
+	// to extend a read deadline.
+	BeforeChunk func()
+
+	remaining int64
+	done      bool
+}
+
+func (br *BlobChunkReader) Read(p []byte) (int, error) {
+	for br.remaining == 0 {
+		if br.done {
+			return 0, io.EOF
+		}
+		if br.BeforeChunk != nil {
+			br.BeforeChunk()
+		}
+		line, err := br.Reader.ReadString('\n')
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		line = strings.TrimSpace(line)
+		if msg, ok := strings.CutPrefix(line, "ERR"); ok {
+			return 0, ParseError(strings.TrimSpace(msg), br.Protocol)
+		}
+		n, err := strconv.ParseInt(line, 10, 64)
+		if err != nil || n < 0 || n > MaxBlobChunk {
+			return 0, NewProtocolError(CodeBadRequest, "invalid blob chunk length")
+		}
+		br.remaining = n
+		br.done = n == 0
+	}
+	if int64(len(p)) > br.remaining {
+		p = p[:br.remaining]
+	}
+	n, err := br.Reader.Read(p)
+	br.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Done reports whether the final zero-length chunk has been read.
+func (br *BlobChunkReader) Done() bool {
+	return br.done
+}
+
+// SetBlob streams r to the daemon over a dedicated connection, so a large
+// upload doesn't hold up other calls on the client.
+func (c *Client) SetBlob(personaID, appID, key string, r io.Reader) (int64, error) {
+	if err := ValidateIDs(personaID, appID, key); err != nil {
+		return 0, err
+	}
+	conn, reader, err := c.dialStream()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	extend := func() { conn.SetDeadline(time.Now().Add(c.commandTimeout())) }
+
+	src := &readErrTracker{r: r}
+	w := bufio.NewWriter(conn)
+	extend()
+	fmt.Fprintf(w, "BLOB_SET %s %s %s\n", personaID, appID, key)
+	_, sendErr := WriteBlobChunks(w, src, extend)
+	if sendErr == nil {
+		sendErr = w.Flush()
+	}
+	if src.err != nil {
+		return 0, src.err // The daemon gets a truncated stream and discards it
+	}
+
+	// The daemon may have rejected the upload part way; prefer its explanation.
+	extend()
+	resp, err := reader.ReadString('\n')
+	if err != nil {
+		if sendErr != nil {
+			return 0, sendErr
+		}
+		return 0, err
+	}
+	if resp = strings.TrimSpace(resp); strings.HasPrefix(resp, "ERR") {
+		return 0, ParseError(strings.TrimPrefix(resp, "ERR "), 1) // Stream connections skip HELLO
+	}
+	return strconv.ParseInt(strings.TrimPrefix(resp, "OK "), 10, 64)
+}
+
+// readErrTracker remembers the error of the reader it wraps, other than io.EOF.
+type readErrTracker struct {
+	r   io.Reader
+	err error
+}
+
+func (t *readErrTracker) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if err != nil && err != io.EOF {
+		t.err = err
+	}
+	return n, err
+}
+
+// GetBlob opens a blob on the daemon and streams it over a dedicated
+// connection, which is closed when the returned reader is.
+func (c *Client) GetBlob(personaID, appID, key string) (io.ReadCloser, int64, error) {
+	if err := ValidateIDs(personaID, appID, key); err != nil {
+		return nil, 0, err
+	}
+	conn, reader, err := c.dialStream()
+	if err != nil {
+		return nil, 0, err
+	}
+	extend := func() { conn.SetDeadline(time.Now().Add(c.commandTimeout())) }
+
+	extend()
+	if _, err := fmt.Fprintf(conn, "BLOB_GET %s %s %s\n", personaID, appID, key); err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	resp, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	if resp = strings.TrimSpace(resp); strings.HasPrefix(resp, "ERR") {
+		conn.Close()
+		return nil, 0, ParseError(strings.TrimPrefix(resp, "ERR "), 1)
+	}
+	size, err := strconv.ParseInt(strings.TrimPrefix(resp, "OK "), 10, 64)
+	if err != nil {
+		conn.Close()
+		return nil, 0, fmt.Errorf("unexpected BLOB_GET response %q", resp)
+	}
+	return &remoteBlob{BlobChunkReader{Reader: reader, Protocol: 1, BeforeChunk: extend}, conn}, size, nil
+}
+
+// remoteBlob is a blob being read from the daemon.
+type remoteBlob struct {
+	BlobChunkReader
+	conn net.Conn
+}
+
+func (b *remoteBlob) Close() error {
+	return b.conn.Close()
+}
+
+// DeleteBlob removes a blob on the daemon.
+func (c *Client) DeleteBlob(personaID, appID, key string) error {
+	if err := ValidateIDs(personaID, appID, key); err != nil {
+		return err
+	}
+	_, err := c.sendAndReceive(fmt.Sprintf("BLOB_DEL %s %s %s", personaID, appID, key))
+	return err
+}
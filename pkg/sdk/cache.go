@@ -0,0 +1,151 @@
+package sdk
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CacheableStore is what a CachedAppScope needs: reads and writes, and a
+// change stream to learn about writes made by others.
+type CacheableStore interface {
+	KVReader
+	KVWriter
+	Watcher
+}
+
+// CachedAppScope is an AppScope that keeps values it has read for up to a TTL,
+// so hot keys such as configuration don't cost a round trip per read. It
+// watches its persona/app and drops a cached value as soon as the key changes,
+// whoever changed it, so the TTL only bounds staleness if the change stream
+// itself lags. If the stream ends, caching stops and reads go to the store.
+//
+// Values are shared between readers: callers must not modify what Get returns.
+type CachedAppScope struct {
+	store     CacheableStore
+	personaID string
+	appID     string
+	ttl       time.Duration
+	stop      context.CancelFunc
+
+	mu      sync.Mutex
+	entries map[string]cachedValue
+	live    bool   // the change stream is running
+	gen     uint64 // bumped on every invalidation, see Get
+}
+
+type cachedValue struct {
+	val     any
+	expires time.Time
+}
+
+// maxCachedValues bounds a CachedAppScope; it is cleared when full.
+const maxCachedValues = 10000
+
+// NewCachedApp returns a caching scope for one persona/app of s. The change
+// stream is subscribed before it returns, so no write made afterwards can be
+// missed. Close releases it.
+func NewCachedApp(s CacheableStore, personaID, appID string, ttl time.Duration) (*CachedAppScope, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("cache ttl must be positive")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := s.Watch(ctx, personaID, appID, "")
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("watch %s/%s: %w", personaID, appID, err)
+	}
+
+	a := &CachedAppScope{
+		store:     s,
+		personaID: personaID,
+		appID:     appID,
+		ttl:       ttl,
+		stop:      cancel,
+		entries:   make(map[string]cachedValue),
+		live:      true,
+	}
+	go a.invalidate(events)
+	return a, nil
+}
+
+// invalidate drops cached values as change events arrive.
+func (a *CachedAppScope) invalidate(events <-chan ChangeEvent) {
+	for e := range events {
+		a.mu.Lock()
+		delete(a.entries, e.Key)
+		a.gen++
+		a.mu.Unlock()
+	}
+
+	a.mu.Lock()
+	a.live = false
+	a.entries = make(map[string]cachedValue)
+	a.gen++
+	a.mu.Unlock()
+}
+
+func (a *CachedAppScope) Get(key string) (any, error) {
+	a.mu.Lock()
+	if e, ok := a.entries[key]; ok && time.Now().Before(e.expires) {
+		a.mu.Unlock()
+		return e.val, nil
+	}
+	live, gen := a.live, a.gen
+	a.mu.Unlock()
+
+	val, err := a.store.Get(a.personaID, a.appID, key)
+	if err != nil || !live {
+		return val, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// A change that arrived while reading may be newer than val.
+	if a.gen == gen && a.live {
+		if len(a.entries) >= maxCachedValues {
+			a.entries = make(map[string]cachedValue)
+		}
+		a.entries[key] = cachedValue{val: val, expires: time.Now().Add(a.ttl)}
+	}
+	return val, nil
+}
+
+func (a *CachedAppScope) Set(key string, val any) error {
+	defer a.forget(key)
+	return a.store.Set(a.personaID, a.appID, key, val)
+}
+
+func (a *CachedAppScope) Delete(key string) error {
+	defer a.forget(key)
+	return a.store.Delete(a.personaID, a.appID, key)
+}
+
+// forget drops key right away instead of waiting for its change event, so
+// this scope reads its own writes.
+func (a *CachedAppScope) forget(key string) {
+	a.mu.Lock()
+	delete(a.entries, key)
+	a.gen++
+	a.mu.Unlock()
+}
+
+// Watch streams changes to the cached persona and app.
+func (a *CachedAppScope) Watch(ctx context.Context, prefix string) (<-chan ChangeEvent, error) {
+	return a.store.Watch(ctx, a.personaID, a.appID, prefix)
+}
+
+func (a *CachedAppScope) Vault(masterKey []byte) VaultScope {
+	return &scopedVault{app: a, masterKey: masterKey}
+}
+
+// Close stops watching for changes and empties the cache.
+func (a *CachedAppScope) Close() error {
+	a.stop()
+	a.mu.Lock()
+	a.live = false
+	a.entries = make(map[string]cachedValue)
+	a.mu.Unlock()
+	return nil
+}
@@ -4,43 +4,330 @@ package sdk
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/celerix-dev/celerix-store/internal/vault"
+	"github.com/celerix-dev/celerix-store/pkg/version"
 )
 
 // Client is a remote client for the Celerix Store.
 // It implements the CelerixStore interface.
 type Client struct {
-	addr   string
+	*clientConn
+	addr string
+	ctx  context.Context // set by WithContext; nil for context.Background()
+
+	misses *negativeCache // nil unless WithNegativeCache is used
+
+	plaintext *bool       // overrides CELERIX_DISABLE_TLS when set
+	tlsConfig *tls.Config // nil uses the default self-signed-friendly config
+
+	timeout   time.Duration // per command; DefaultTimeout if zero
+	keepalive time.Duration // see WithKeepalive
+	stop      context.CancelFunc
+
+	logger *slog.Logger
+
+	offlineOpts *OfflineOptions // set by WithOfflineQueue
+	offline     *offlineQueue   // opened in Connect
+
+	namespace  string // set by WithNamespace; empty for the default
+	token      string
+	adminToken string
+	opts       []ClientOption // as passed to Connect, for Namespace
+
+	replicaAddrs []string       // set by WithReplicas
+	readPref     ReadPreference // set by WithReadPreference
+	replicas     *replicaSet    // nil unless reads may go to replicas
+	noRetry      bool           // for replica connections, which fail over instead
+	retryWrites  bool           // set by WithRetryWrites
+	compression  bool           // set by WithCompression
+
+	interceptors []Interceptor // set by WithInterceptor
+	tracer       Tracer        // set by WithTracer
+
+	preciseNumbers bool // set by WithPreciseNumbers
+}
+
+// clientConn is the connection of a Client, shared with the views
+// WithContext returns.
+type clientConn struct {
 	conn   net.Conn
 	reader *bufio.Reader
 	mu     sync.Mutex // Protects concurrent access to the connection
+
+	server   version.Info  // reported by the daemon on connect; empty for old daemons
+	protocol int           // negotiated with HELLO on every (re)connect
+	idle     time.Duration // the daemon's idle timeout, if it announced one
+	compress bool          // lines may be compressed, negotiated with HELLO
+	lastUsed time.Time     // last successful round trip, protected by mu
+
+	authToken atomic.Value // string; see WithAuthToken
 }
 
+// Defaults for Client timing.
+const (
+	DefaultTimeout   = 30 * time.Second
+	DefaultKeepalive = time.Minute
+)
+
 // Connect establishes a TLS-encrypted connection to a remote Celerix Store daemon.
-// If CELERIX_DISABLE_TLS is set to "true", it falls back to plain TCP.
-func Connect(addr string) (*Client, error) {
-	c := &Client{addr: addr}
+// If CELERIX_DISABLE_TLS is set to "true", it falls back to plain TCP. An addr
+// of the form "unix:///path/to.sock" connects over a Unix domain socket.
+func Connect(addr string, opts ...ClientOption) (*Client, error) {
+	c := &Client{clientConn: &clientConn{}, addr: addr, logger: slog.Default(), opts: opts}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.namespace != "" {
+		if err := ValidateNamespace(c.namespace); err != nil {
+			return nil, err
+		}
+	}
+	if c.offlineOpts != nil {
+		q, err := openOfflineQueue(*c.offlineOpts)
+		if err != nil {
+			return nil, fmt.Errorf("open offline queue: %w", err)
+		}
+		c.offline = q
+	}
+	if len(c.replicaAddrs) > 0 && c.readPref != ReadPrimary {
+		c.replicas = newReplicaSet(c)
+	}
+
 	if err := c.reconnect(); err != nil {
-		return nil, err
+		if c.offline == nil {
+			return nil, err
+		}
+		// Offline mode starts without the daemon and catches up later.
+		c.offline.setOnline(err)
+		c.logger.Warn("[Celerix SDK] Daemon unreachable, starting offline", "error", err)
+	} else {
+		c.checkServerVersion()
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	c.stop = stop
+	if interval := c.keepaliveInterval(); interval > 0 {
+		go c.keepaliveLoop(ctx, interval)
+	}
+	if c.offline != nil {
+		go c.syncLoop(ctx.Done())
 	}
 	return c, nil
 }
 
+// keepaliveInterval resolves the WithKeepalive setting against the idle
+// timeout the daemon announced.
+func (c *Client) keepaliveInterval() time.Duration {
+	switch {
+	case c.keepalive != 0:
+		return c.keepalive
+	case c.idle > 0:
+		return c.idle / 2
+	case c.protocol >= 2:
+		return -1 // The daemon doesn't close idle connections
+	}
+	return DefaultKeepalive
+}
+
+// keepaliveLoop PINGs the daemon whenever the connection has been idle for
+// interval. A failed PING drops the connection so the next command reconnects.
+func (c *Client) keepaliveLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		if c.conn != nil && time.Since(c.lastUsed) >= interval {
+			c.conn.SetDeadline(time.Now().Add(c.commandTimeout()))
+			_, err := fmt.Fprint(c.conn, "PING\n")
+			if err == nil {
+				var resp string
+				if resp, err = c.reader.ReadString('\n'); err == nil && strings.TrimSpace(resp) != "PONG" {
+					err = fmt.Errorf("unexpected keepalive response %q", strings.TrimSpace(resp))
+				}
+			}
+			if err != nil {
+				c.logger.Debug("[Celerix SDK] Keepalive failed, dropping connection", "error", err)
+				c.conn.Close()
+				c.conn = nil
+			} else {
+				c.lastUsed = time.Now()
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// commandTimeout is the deadline for one round trip.
+func (c *Client) commandTimeout() time.Duration {
+	if c.timeout > 0 {
+		return c.timeout
+	}
+	return DefaultTimeout
+}
+
+// ServerVersion returns the build info the daemon reported when the client connected.
+// It is empty for daemons that predate version reporting.
+func (c *Client) ServerVersion() version.Info {
+	return c.server
+}
+
+// Protocol returns the line protocol version negotiated with the daemon: 1 for
+// daemons that predate HELLO, whose errors carry no codes.
+func (c *Client) Protocol() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.protocol
+}
+
+// checkServerVersion warns when the daemon's major version differs from the
+// SDK's. Daemons that predate HELLO are asked with VERSION instead; those that
+// don't know VERSION either ignore it, so a PING is sent behind it: reading
+// PONG first means there is no version to compare.
+func (c *Client) checkServerVersion() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.server.Version == "" {
+		c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+		defer c.conn.SetDeadline(time.Time{})
+
+		if _, err := fmt.Fprint(c.conn, "VERSION\nPING\n"); err != nil {
+			return
+		}
+		resp, err := c.reader.ReadString('\n')
+		if err != nil {
+			c.reconnect() // The stream position is unknown; start over on a clean connection
+			return
+		}
+		resp = strings.TrimSpace(resp)
+		if resp == "PONG" {
+			return
+		}
+		if _, err := c.reader.ReadString('\n'); err != nil { // The PONG
+			c.reconnect()
+			return
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(resp, "OK ")), &c.server); err != nil {
+			return
+		}
+	}
+
+	serverMajor, ok1 := version.Major(c.server.Version)
+	clientMajor, ok2 := version.Major(version.Get().Version)
+	if ok1 && ok2 && serverMajor != clientMajor {
+		c.logger.Warn("[Celerix SDK] Server version differs from SDK version; some commands may not be compatible",
+			"server", c.server.Version, "sdk", version.Get().Version)
+	}
+}
+
+// hello negotiates the protocol version on a fresh connection. Daemons that
+// predate HELLO ignore it, so a PING follows: reading PONG first means
+// protocol version 1. It returns ErrServerBusy when the daemon turned the
+// connection away.
+func (c *Client) hello() error {
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	defer c.conn.SetDeadline(time.Time{})
+
+	c.protocol, c.compress = 1, false
+	hello := fmt.Sprint("HELLO ", ProtocolVersion)
+	if c.compression {
+		hello += " " + Compression
+	}
+	if _, err := fmt.Fprintf(c.conn, "%s\nPING\n", hello); err != nil {
+		return err
+	}
+	resp, err := c.reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	resp = strings.TrimSpace(resp)
+	if isServerBusy(resp) {
+		return ErrServerBusy
+	}
+	if resp == "PONG" {
+		return nil
+	}
+	if _, err := c.reader.ReadString('\n'); err != nil { // The PONG
+		return err
+	}
+
+	var info Hello
+	if payload, ok := strings.CutPrefix(resp, "OK "); ok && json.Unmarshal([]byte(payload), &info) == nil {
+		c.protocol = info.Protocol
+		c.server = info.Server
+		c.idle = info.IdleTimeout
+		c.compress = c.compression && info.Compression == Compression
+	}
+	c.lastUsed = time.Now()
+	return nil
+}
+
+// isServerBusy reports whether a response is the daemon rejecting the
+// connection at its connection limit.
+func isServerBusy(resp string) bool {
+	return resp == "ERR "+ErrServerBusy.Error()
+}
+
 func (c *Client) reconnect() error {
 	if c.conn != nil {
 		c.conn.Close()
 		c.conn = nil
 	}
 
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	err = c.hello()
+	if err == nil {
+		err = c.setupConn(conn, c.reader, c.protocol)
+	}
+	if err != nil {
+		conn.Close()
+		c.conn = nil
+		return err
+	}
+	return nil
+}
+
+// UnixSocketPath returns the socket path of a Unix domain socket address such
+// as "unix:///run/celerix/celerix.sock" or "unix:celerix.sock".
+func UnixSocketPath(addr string) (string, bool) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return "", false
+	}
+	return strings.TrimPrefix(path, "//"), true
+}
+
+// dial opens a new connection to the daemon, honoring CELERIX_DISABLE_TLS.
+// Unix domain sockets never use TLS: they don't leave the host, and the
+// socket file's permissions decide who may connect.
+func (c *Client) dial() (net.Conn, error) {
 	var conn net.Conn
 	var err error
 
@@ -49,91 +336,233 @@ func (c *Client) reconnect() error {
 		KeepAlive: 60 * time.Second, // Increased keep-alive
 	}
 
-	if os.Getenv("CELERIX_DISABLE_TLS") == "true" {
+	if path, ok := UnixSocketPath(c.addr); ok {
+		return dialer.Dial("unix", path)
+	}
+
+	plaintext := os.Getenv("CELERIX_DISABLE_TLS") == "true"
+	if c.plaintext != nil {
+		plaintext = *c.plaintext
+	}
+
+	if plaintext {
 		conn, err = dialer.Dial("tcp", c.addr)
 	} else {
-		config := &tls.Config{
-			InsecureSkipVerify: true, // We use self-signed certs for internal traffic
+		config := c.tlsConfig
+		if config == nil {
+			config = &tls.Config{
+				InsecureSkipVerify: true, // We use self-signed certs for internal traffic
+			}
 		}
 		conn, err = tls.DialWithDialer(dialer, "tcp", c.addr, config)
 	}
+	return conn, err
+}
 
-	if err != nil {
-		return err
+// Internal helper for TCP communication
+func (c *Client) sendAndReceive(cmd string) (string, error) {
+	ctx, span := c.startSpan(c.context(), cmd)
+	traceparent := ""
+	if span != nil {
+		traceparent = span.TraceParent()
 	}
+	send := func(cmd string) (string, error) { return c.route(cmd, traceparent) }
 
-	c.conn = conn
-	c.reader = bufio.NewReader(conn)
-	return nil
+	var resp string
+	var err error
+	if len(c.interceptors) > 0 {
+		resp, err = c.intercept(ctx, cmd, send)
+	} else {
+		resp, err = send(cmd)
+	}
+	if span != nil {
+		span.End(err)
+	}
+	return resp, err
 }
 
-// Internal helper for TCP communication
-func (c *Client) sendAndReceive(cmd string) (string, error) {
+// route sends cmd to the primary or, for reads, where the read preference
+// says.
+func (c *Client) route(cmd, traceparent string) (string, error) {
+	if c.replicas != nil && isReadCommand(cmd) {
+		return c.replicas.read(c, cmd, traceparent)
+	}
+	return c.roundTrip(cmd, traceparent)
+}
+
+// roundTrip sends cmd to the daemon at c.addr, with traceparent if the daemon
+// speaks protocol version 3. Failures to reach the daemon are retried, but a
+// command whose answer was lost is only resent if it is idempotent, carries a
+// request ID the daemon deduplicates, or the client was created
+// WithRetryWrites.
+func (c *Client) roundTrip(cmd, traceparent string) (string, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	var err error
 	var resp string
+	name, _, _ := strings.Cut(cmd, " ")
+	requestID := "" // The same for every attempt, so the daemon can tell retries apart
 
 	// Try up to 3 times with exponential backoff
 	for i := 0; i < 3; i++ {
 		// Ensure we have a connection
 		if c.conn == nil {
 			if reconnectErr := c.reconnect(); reconnectErr != nil {
-				err = fmt.Errorf("reconnect failed: %w", reconnectErr)
+				err = &NetworkError{Command: name, Err: fmt.Errorf("reconnect failed: %w", reconnectErr)}
+				if c.noRetry {
+					return "", err
+				}
 				time.Sleep(time.Duration(i*100) * time.Millisecond)
 				continue
 			}
 		}
 
 		// Set deadlines for the operation
-		c.conn.SetDeadline(time.Now().Add(30 * time.Second))
+		c.conn.SetDeadline(time.Now().Add(c.commandTimeout()))
 
-		_, err = fmt.Fprint(c.conn, cmd+"\n")
-		if err == nil {
-			resp, err = c.reader.ReadString('\n')
-			if err == nil {
-				resp = strings.TrimSpace(resp)
-				if strings.HasPrefix(resp, "ERR") {
-					return "", fmt.Errorf("%s", strings.TrimPrefix(resp, "ERR "))
-				}
+		line := cmd
+		withID := c.protocol >= 4 && !isIdempotent(cmd)
+		if withID {
+			if requestID == "" {
+				requestID = newRequestID()
+			}
+			line = RequestCommand + " " + requestID + " " + line
+		}
+		if traceparent != "" && c.protocol >= 3 {
+			line = TraceCommand + " " + traceparent + " " + line
+		}
+		if c.compress && len(line) > CompressThreshold && len(line) <= MaxDecompressedLine {
+			line = CompressLine(line)
+		}
+		// A failed write never gets the closing newline across, so the
+		// daemon can't have run the command.
+		if _, writeErr := fmt.Fprint(c.conn, line+"\n"); writeErr != nil {
+			err = &NetworkError{Command: name, Err: writeErr}
+		} else if resp, err = c.reader.ReadString('\n'); err != nil {
+			err = &NetworkError{Command: name, Sent: true, Err: err}
+		} else if resp, err = DecompressLine(strings.TrimSpace(resp), 0); err != nil {
+			// The connection is out of step with the daemon; start afresh.
+			err = &NetworkError{Command: name, Sent: true, Err: err}
+		} else {
+			if isServerBusy(resp) {
+				// The daemon closes busy connections before reading any
+				// command; back off and try a fresh one.
+				err = ErrServerBusy
+			} else if strings.HasPrefix(resp, "ERR") {
+				return "", ParseError(strings.TrimPrefix(resp, "ERR "), c.protocol)
+			} else {
+				c.lastUsed = time.Now()
 				return resp, nil
 			}
 		}
 
 		// If we got here, there was an error communicating.
-		fmt.Fprintf(os.Stderr, "[Celerix SDK] Attempt %d failed: %v. Reconnecting...\n", i+1, err)
+		if c.noRetry || IsAmbiguous(err) && !c.retryWrites && !withID && !isIdempotent(cmd) {
+			// Drop the connection so the next command starts afresh
+			if c.conn != nil {
+				c.conn.Close()
+				c.conn = nil
+			}
+			return "", err
+		}
+		c.logger.Warn("[Celerix SDK] Command failed, reconnecting", "attempt", i+1, "error", err)
 
 		// Force a reconnect on the next iteration
 		if closeErr := c.reconnect(); closeErr != nil {
-			fmt.Fprintf(os.Stderr, "[Celerix SDK] Reconnect attempt failed: %v\n", closeErr)
+			c.logger.Warn("[Celerix SDK] Reconnect failed", "attempt", i+1, "error", closeErr)
 		}
 
 		// Wait before retrying (exponential backoff)
 		time.Sleep(time.Duration((i+1)*200) * time.Millisecond)
 	}
 
-	return "", fmt.Errorf("failed after 3 attempts. last error: %v", err)
+	return "", fmt.Errorf("failed after 3 attempts. last error: %w", err)
 }
 
 func (c *Client) Get(personaID, appID, key string) (any, error) {
+	if c.offline != nil {
+		// Writes not yet replayed are newer than anything the daemon has.
+		if w, ok := c.offline.lookup(personaID, appID, key); ok {
+			if w.Op == OpDelete {
+				return nil, ErrKeyNotFound
+			}
+			return w.Value, nil
+		}
+	}
+	val, err := c.getRemote(personaID, appID, key)
+	if c.offline != nil && isUnreachable(err) {
+		return nil, fmt.Errorf("%w: %v", ErrOffline, err)
+	}
+	return val, err
+}
+
+func (c *Client) getRemote(personaID, appID, key string) (any, error) {
+	if c.misses.missing(personaID, appID, key) {
+		return nil, ErrKeyNotFound
+	}
 	resp, err := c.sendAndReceive(fmt.Sprintf("GET %s %s %s", personaID, appID, key))
 	if err != nil {
+		if err.Error() == ErrKeyNotFound.Error() {
+			c.misses.remember(personaID, appID, key)
+		}
 		return nil, err
 	}
 	jsonData := strings.TrimPrefix(resp, "OK ")
 	var val any
-	err = json.Unmarshal([]byte(jsonData), &val)
+	err = c.decodeValue(jsonData, &val)
 	return val, err
 }
 
 func (c *Client) Set(personaID, appID, key string, val any) error {
+	w := OfflineWrite{Op: OpSet, PersonaID: personaID, AppID: appID, Key: key, Value: val}
+	return c.write(w, func() error { return c.setRemote(personaID, appID, key, val) })
+}
+
+func (c *Client) setRemote(personaID, appID, key string, val any) error {
+	if err := ValidateIDs(personaID, appID, key); err != nil {
+		return err
+	}
 	jsonData, _ := json.Marshal(val)
+	c.misses.forget(personaID, appID, key)
 	_, err := c.sendAndReceive(fmt.Sprintf("SET %s %s %s %s", personaID, appID, key, string(jsonData)))
 	return err
 }
 
+// Merge applies an RFC 7396 merge patch to the value at key on the server and
+// returns the merged result. The merge is atomic with respect to other writers.
+func (c *Client) Merge(personaID, appID, key string, patch any) (any, error) {
+	if err := ValidateIDs(personaID, appID, key); err != nil {
+		return nil, err
+	}
+	jsonData, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	c.misses.forget(personaID, appID, key)
+	resp, err := c.sendAndReceive(fmt.Sprintf("SET_MERGE %s %s %s %s", personaID, appID, key, string(jsonData)))
+	if err != nil {
+		return nil, err
+	}
+	var val any
+	err = c.decodeValue(strings.TrimPrefix(resp, "OK "), &val)
+	return val, err
+}
+
 func (c *Client) Delete(personaID, appID, key string) error {
+	w := OfflineWrite{Op: OpDelete, PersonaID: personaID, AppID: appID, Key: key}
+	return c.write(w, func() error { return c.deleteRemote(personaID, appID, key) })
+}
+
+func (c *Client) deleteRemote(personaID, appID, key string) error {
+	// Only the persona and app are checked, so keys stored before IDs were
+	// validated stay deletable.
+	if err := ValidateID("persona ID", personaID); err != nil {
+		return err
+	}
+	if err := ValidateID("app ID", appID); err != nil {
+		return err
+	}
 	_, err := c.sendAndReceive(fmt.Sprintf("DEL %s %s %s", personaID, appID, key))
 	return err
 }
@@ -167,7 +596,38 @@ func (c *Client) GetAppStore(personaID, appID string) (map[string]any, error) {
 	}
 	jsonData := strings.TrimPrefix(resp, "OK ")
 	var store map[string]any
-	err = json.Unmarshal([]byte(jsonData), &store)
+	err = c.decodeValue(jsonData, &store)
+	return store, err
+}
+
+// GetFields retrieves only the requested fields of a stored object.
+// Fields are dotted paths, e.g. "profile.email".
+func (c *Client) GetFields(personaID, appID, key string, fields []string) (any, error) {
+	if len(fields) == 0 {
+		return c.Get(personaID, appID, key)
+	}
+	resp, err := c.sendAndReceive(fmt.Sprintf("GET %s %s %s %s", personaID, appID, key, strings.Join(fields, ",")))
+	if err != nil {
+		return nil, err
+	}
+	jsonData := strings.TrimPrefix(resp, "OK ")
+	var val any
+	err = c.decodeValue(jsonData, &val)
+	return val, err
+}
+
+// GetAppStoreFields dumps an app with each value projected down to the requested fields.
+func (c *Client) GetAppStoreFields(personaID, appID string, fields []string) (map[string]any, error) {
+	if len(fields) == 0 {
+		return c.GetAppStore(personaID, appID)
+	}
+	resp, err := c.sendAndReceive(fmt.Sprintf("DUMP %s %s %s", personaID, appID, strings.Join(fields, ",")))
+	if err != nil {
+		return nil, err
+	}
+	jsonData := strings.TrimPrefix(resp, "OK ")
+	var store map[string]any
+	err = c.decodeValue(jsonData, &store)
 	return store, err
 }
 
@@ -178,10 +638,24 @@ func (c *Client) DumpApp(appID string) (map[string]map[string]any, error) {
 	}
 	jsonData := strings.TrimPrefix(resp, "OK ")
 	var store map[string]map[string]any
-	err = json.Unmarshal([]byte(jsonData), &store)
+	err = c.decodeValue(jsonData, &store)
 	return store, err
 }
 
+// GetPersona reads all apps of a persona in one round trip with DUMP_PERSONA.
+func (c *Client) GetPersona(personaID string) (map[string]map[string]any, error) {
+	if err := ValidateID("persona ID", personaID); err != nil {
+		return nil, err
+	}
+	resp, err := c.sendAndReceive(fmt.Sprintf("DUMP_PERSONA %s", personaID))
+	if err != nil {
+		return nil, err
+	}
+	var data map[string]map[string]any
+	err = c.decodeValue(strings.TrimPrefix(resp, "OK "), &data)
+	return data, err
+}
+
 func (c *Client) GetGlobal(appID, key string) (any, string, error) {
 	resp, err := c.sendAndReceive(fmt.Sprintf("GET_GLOBAL %s %s", appID, key))
 	if err != nil {
@@ -192,18 +666,281 @@ func (c *Client) GetGlobal(appID, key string) (any, string, error) {
 		Persona string `json:"persona"`
 		Value   any    `json:"value"`
 	}
-	err = json.Unmarshal([]byte(jsonData), &out)
+	err = c.decodeValue(jsonData, &out)
 	return out.Value, out.Persona, err
 }
 
+// Compute runs the compute function stored as function over the app of
+// personaID, or of every persona for "*", with COMPUTE, and returns its
+// result. args, if not nil, is passed to the function as JSON.
+func (c *Client) Compute(function, personaID, appID string, args any) (any, error) {
+	if err := ValidateID("function name", function); err != nil {
+		return nil, err
+	}
+	if err := ValidateID("app ID", appID); err != nil {
+		return nil, err
+	}
+	if personaID != "*" {
+		if err := ValidateID("persona ID", personaID); err != nil {
+			return nil, err
+		}
+	}
+	cmd := fmt.Sprintf("COMPUTE %s %s %s", function, personaID, appID)
+	if args != nil {
+		data, err := json.Marshal(args)
+		if err != nil {
+			return nil, err
+		}
+		cmd += " " + string(data)
+	}
+	resp, err := c.sendAndReceive(cmd)
+	if err != nil {
+		return nil, err
+	}
+	var result any
+	err = c.decodeValue(strings.TrimPrefix(resp, "OK "), &result)
+	return result, err
+}
+
 func (c *Client) Move(srcPersona, dstPersona, appID, key string) error {
+	if err := ValidateIDs(srcPersona, appID, key); err != nil {
+		return err
+	}
+	if err := ValidateID("persona", dstPersona); err != nil {
+		return err
+	}
+	c.misses.forget(dstPersona, appID, key)
 	_, err := c.sendAndReceive(fmt.Sprintf("MOVE %s %s %s %s", srcPersona, dstPersona, appID, key))
 	return err
 }
 
+// MoveKey moves a value to another persona, app or key in one step, with
+// MOVE_KEY.
+func (c *Client) MoveKey(srcPersona, srcApp, dstPersona, dstApp, srcKey, dstKey string) error {
+	if err := ValidateIDs(srcPersona, srcApp, srcKey); err != nil {
+		return err
+	}
+	if err := ValidateIDs(dstPersona, dstApp, dstKey); err != nil {
+		return err
+	}
+	c.misses.forget(dstPersona, dstApp, dstKey)
+	_, err := c.sendAndReceive(fmt.Sprintf("MOVE_KEY %s %s %s %s %s %s", srcPersona, srcApp, dstPersona, dstApp, srcKey, dstKey))
+	return err
+}
+
+// Stats fetches runtime statistics, including the most contended namespaces, from the daemon.
+func (c *Client) Stats() (Stats, error) {
+	var stats Stats
+	resp, err := c.sendAndReceive("STATS")
+	if err != nil {
+		return stats, err
+	}
+	jsonData := strings.TrimPrefix(resp, "OK ")
+	err = json.Unmarshal([]byte(jsonData), &stats)
+	return stats, err
+}
+
+// Health reports conditions on the daemon that need operator attention,
+// such as personas quarantined because their files were corrupt.
+func (c *Client) Health() (Health, error) {
+	var health Health
+	resp, err := c.sendAndReceive("INFO")
+	if err != nil {
+		return health, err
+	}
+	jsonData := strings.TrimPrefix(resp, "OK ")
+	err = json.Unmarshal([]byte(jsonData), &health)
+	return health, err
+}
+
+// Watch subscribes to changes for a persona/app (sdk.WatchAll matches any) whose
+// keys start with prefix. Each watch uses its own connection, so it does not block
+// other calls on the client. The channel is closed when ctx is cancelled or the
+// server ends the stream.
+func (c *Client) Watch(ctx context.Context, personaID, appID, prefix string) (<-chan ChangeEvent, error) {
+	conn, reader, err := c.dialStream()
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(c.commandTimeout()))
+	cmd := strings.TrimSpace(fmt.Sprintf("WATCH %s %s %s", personaID, appID, prefix))
+	if _, err := fmt.Fprint(conn, cmd+"\n"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp = strings.TrimSpace(resp); strings.HasPrefix(resp, "ERR") {
+		conn.Close()
+		return nil, ParseError(strings.TrimPrefix(resp, "ERR "), 1) // Stream connections skip HELLO
+	}
+	conn.SetDeadline(time.Time{})
+
+	events := make(chan ChangeEvent)
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	go func() {
+		defer close(events)
+		defer conn.Close()
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			payload, ok := strings.CutPrefix(strings.TrimSpace(line), "EVENT ")
+			if !ok {
+				return
+			}
+			var e ChangeEvent
+			if err := c.decodeValue(payload, &e); err != nil {
+				continue
+			}
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// Import streams ndjson records from r to the daemon over a dedicated connection
+// (see the package-level Import for the format). The daemon applies records in
+// batches and reports a checkpoint after each one; if the import fails, the
+// result's Records is the last checkpoint, to be passed as opts.Skip to resume.
+// opts.BatchSize is decided by the daemon.
+func (c *Client) Import(ctx context.Context, r io.Reader, opts ImportOptions) (ImportResult, error) {
+	var result ImportResult
+	conn, reader, err := c.dialStream()
+	if err != nil {
+		return result, err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	// Responses arrive while we are still sending, so read them concurrently.
+	type outcome struct {
+		result ImportResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		var checkpoint int64
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				done <- outcome{ImportResult{Records: checkpoint}, fmt.Errorf("import interrupted: %w", err)}
+				return
+			}
+			line = strings.TrimSpace(line)
+			switch {
+			case strings.HasPrefix(line, "CHECKPOINT "):
+				checkpoint, _ = strconv.ParseInt(strings.TrimPrefix(line, "CHECKPOINT "), 10, 64)
+				if opts.Checkpoint != nil {
+					opts.Checkpoint(checkpoint)
+				}
+			case strings.HasPrefix(line, "OK "):
+				var res ImportResult
+				err := json.Unmarshal([]byte(strings.TrimPrefix(line, "OK ")), &res)
+				done <- outcome{res, err}
+				return
+			default:
+				done <- outcome{ImportResult{Records: checkpoint}, ParseError(strings.TrimPrefix(line, "ERR "), 1)}
+				return
+			}
+		}
+	}()
+
+	var readErr error
+	sendErr := func() error {
+		if _, err := fmt.Fprintf(conn, "IMPORT %d\n", opts.Skip); err != nil {
+			return err
+		}
+		// Copy line by line so a final record without a trailing newline can't
+		// merge with the END marker.
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxImportLine)
+		w := bufio.NewWriter(conn)
+		for scanner.Scan() {
+			w.Write(scanner.Bytes())
+			if err := w.WriteByte('\n'); err != nil {
+				return err
+			}
+		}
+		if readErr = scanner.Err(); readErr != nil {
+			return readErr
+		}
+		w.WriteString("END\n")
+		return w.Flush()
+	}()
+
+	if readErr != nil {
+		return result, readErr // The daemon gets a truncated stream and discards the rest
+	}
+	if sendErr != nil && ctx.Err() == nil {
+		// The daemon may have rejected the stream; prefer its explanation.
+		select {
+		case out := <-done:
+			if out.err != nil {
+				return out.result, out.err
+			}
+		case <-time.After(5 * time.Second):
+		}
+		return result, sendErr
+	}
+
+	out := <-done
+	if ctx.Err() != nil {
+		return out.result, ctx.Err()
+	}
+	return out.result, out.err
+}
+
+// SetBatch writes records in one round trip, over an IMPORT stream of its own.
+// Unlike Set, it doesn't go through the offline queue.
+func (c *Client) SetBatch(records []Record) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range records {
+		if err := ValidateIDs(rec.PersonaID, rec.AppID, rec.Key); err != nil {
+			return err
+		}
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+		c.misses.forget(rec.PersonaID, rec.AppID, rec.Key)
+	}
+	_, err := c.Import(context.Background(), &buf, ImportOptions{})
+	return err
+}
+
 func (c *Client) Close() error {
+	c.stop()
+	if c.offline != nil {
+		c.offline.close()
+	}
+	if c.replicas != nil {
+		c.replicas.close()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
 	fmt.Fprintln(c.conn, "QUIT")
-	return c.conn.Close()
+	err := c.conn.Close()
+	c.conn = nil
+	return err
 }
 
 // --- Generics Support (Go 1.18+) ---
@@ -269,9 +1006,13 @@ func (a *RemoteAppScope) Delete(key string) error {
 	return a.client.Delete(a.personaID, a.appID, key)
 }
 
+// Watch streams changes to the scoped persona and app.
+func (a *RemoteAppScope) Watch(ctx context.Context, prefix string) (<-chan ChangeEvent, error) {
+	return a.client.Watch(ctx, a.personaID, a.appID, prefix)
+}
+
 // Vault returns a scope that automatically encrypts/decrypts data.
-// It returns any to satisfy the AppScope interface.
-func (a *RemoteAppScope) Vault(masterKey []byte) any {
+func (a *RemoteAppScope) Vault(masterKey []byte) VaultScope {
 	return &RemoteVaultScope{
 		app:       a,
 		masterKey: masterKey,
@@ -311,3 +1052,21 @@ func (v *RemoteVaultScope) Get(key string) (string, error) {
 	// 2. Decrypt locally
 	return vault.Decrypt(ciphertext, v.masterKey)
 }
+
+// SetJSON encrypts the JSON encoding of val and stores it in the scoped app.
+func (v *RemoteVaultScope) SetJSON(key string, val any) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return v.Set(key, string(data))
+}
+
+// GetJSON retrieves and decrypts a value stored with SetJSON into out.
+func (v *RemoteVaultScope) GetJSON(key string, out any) error {
+	plaintext, err := v.Get(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(plaintext), out)
+}
@@ -0,0 +1,415 @@
+package sdk
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ringPointsPerNode is the number of points each node gets on a HashRing.
+// More points spread personas more evenly.
+const ringPointsPerNode = 128
+
+// HashRing assigns persona IDs to nodes by consistent hashing: adding or
+// removing a node only moves the personas whose points fall next to its own,
+// roughly 1/n of them.
+type HashRing struct {
+	points []ringPoint // sorted by hash
+	nodes  []string
+}
+
+type ringPoint struct {
+	hash uint64
+	node string
+}
+
+// NewHashRing places nodes, identified by name (e.g. their address), on a
+// ring. The order of nodes doesn't matter.
+func NewHashRing(nodes ...string) *HashRing {
+	r := &HashRing{nodes: append([]string(nil), nodes...)}
+	sort.Strings(r.nodes)
+	for _, node := range r.nodes {
+		for i := 0; i < ringPointsPerNode; i++ {
+			r.points = append(r.points, ringPoint{ringHash(node + "#" + strconv.Itoa(i)), node})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i].hash < r.points[j].hash })
+	return r
+}
+
+// Owner returns the node holding personaID, or "" for an empty ring.
+func (r *HashRing) Owner(personaID string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := ringHash(personaID)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0 // Wrap around
+	}
+	return r.points[i].node
+}
+
+// Nodes returns the ring's nodes, sorted.
+func (r *HashRing) Nodes() []string {
+	return append([]string(nil), r.nodes...)
+}
+
+func ringHash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// Cluster shards personas across independent daemons: every persona lives on
+// the node a HashRing of the node names picks. Reads and writes of a persona
+// go to its node only; listings, DumpApp and GetGlobal ask every node and
+// merge the answers like a MultiStore. Moves between personas on different
+// nodes are copied and deleted, which is not atomic, and such personas can't
+// be merged.
+//
+// Every client of a cluster must be given the same nodes. After adding one,
+// run Rebalance to move the personas it now owns.
+type Cluster struct {
+	*MultiStore
+	ring *HashRing
+}
+
+// NewCluster shards personas across members by their names.
+func NewCluster(members ...Member) *Cluster {
+	names := make([]string, len(members))
+	for i, member := range members {
+		names[i] = member.Name
+	}
+	c := &Cluster{MultiStore: NewMultiStore(members...), ring: NewHashRing(names...)}
+	c.SetWriteRouter(c.ring.Owner)
+	return c
+}
+
+// ConnectCluster connects to every daemon in addrs with opts and shards
+// personas across them, naming the nodes by address.
+func ConnectCluster(addrs []string, opts ...ClientOption) (*Cluster, error) {
+	members := make([]Member, 0, len(addrs))
+	for _, addr := range addrs {
+		client, err := Connect(addr, opts...)
+		if err != nil {
+			for _, member := range members {
+				member.Store.(*Client).Close()
+			}
+			return nil, fmt.Errorf("connect to %s: %w", addr, err)
+		}
+		members = append(members, Member{Name: addr, Store: client})
+	}
+	return NewCluster(members...), nil
+}
+
+// Owner returns the name of the node holding personaID.
+func (c *Cluster) Owner(personaID string) string {
+	return c.ring.Owner(personaID)
+}
+
+// owner returns the member holding personaID.
+func (c *Cluster) owner(personaID string) (int, CelerixStore, error) {
+	i, err := c.writer(personaID)
+	if err != nil {
+		return 0, nil, err
+	}
+	return i, c.members[i].Store, nil
+}
+
+// Get reads from the node holding the persona.
+func (c *Cluster) Get(personaID, appID, key string) (any, error) {
+	i, s, err := c.owner(personaID)
+	if err != nil {
+		return nil, err
+	}
+	val, err := s.Get(personaID, appID, key)
+	c.record(i, err)
+	return val, err
+}
+
+// GetApps lists the persona's apps on its node.
+func (c *Cluster) GetApps(personaID string) ([]string, error) {
+	i, s, err := c.owner(personaID)
+	if err != nil {
+		return nil, err
+	}
+	apps, err := s.GetApps(personaID)
+	c.record(i, err)
+	return apps, err
+}
+
+// GetAppStore dumps the app from the persona's node.
+func (c *Cluster) GetAppStore(personaID, appID string) (map[string]any, error) {
+	i, s, err := c.owner(personaID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.GetAppStore(personaID, appID)
+	c.record(i, err)
+	return data, err
+}
+
+// GetPersona reads the persona from its node.
+func (c *Cluster) GetPersona(personaID string) (map[string]map[string]any, error) {
+	i, s, err := c.owner(personaID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := GetPersona(s, personaID)
+	c.record(i, err)
+	return data, err
+}
+
+// App returns a scope pinned to a persona and app on the persona's node.
+func (c *Cluster) App(personaID, appID string) AppScope {
+	return &multiAppScope{store: c, personaID: personaID, appID: appID}
+}
+
+// Close closes the members that can be closed, such as the clients of
+// ConnectCluster.
+func (c *Cluster) Close() error {
+	var errs []error
+	for _, member := range c.members {
+		if closer, ok := member.Store.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RebalanceMove is a persona Rebalance moves to the node owning it.
+type RebalanceMove struct {
+	PersonaID string `json:"persona_id"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Keys      int    `json:"keys"`
+	// Kept counts the keys left on From because they changed while being
+	// moved. Running Rebalance again moves them.
+	Kept int `json:"kept,omitempty"`
+}
+
+// Rebalance moves every persona found on a node other than its owner to the
+// owner, app by app: the data is copied with WriteBatch, then each key is
+// deleted from the old node if it still holds the copied value. Values the
+// owner already has are overwritten. With dryRun, it only reports the moves.
+// progress, if set, is called after each persona.
+func (c *Cluster) Rebalance(dryRun bool, progress func(RebalanceMove)) ([]RebalanceMove, error) {
+	var moves []RebalanceMove
+	for i := range c.members {
+		var err error
+		if moves, err = c.moveAway(i, c.ring, dryRun, progress, moves); err != nil {
+			return moves, err
+		}
+	}
+	return moves, nil
+}
+
+// Drain moves every persona on the node name to the node owning it once name
+// is removed, like Rebalance, so that name can leave the cluster. Give clients
+// the node list without name first, so that no new writes land on it.
+func (c *Cluster) Drain(name string, dryRun bool, progress func(RebalanceMove)) ([]RebalanceMove, error) {
+	i := c.memberIndex(name)
+	if i < 0 {
+		return nil, fmt.Errorf("%s is not a node of the cluster", name)
+	}
+	var others []string
+	for _, node := range c.ring.Nodes() {
+		if node != name {
+			others = append(others, node)
+		}
+	}
+	if len(others) == 0 {
+		return nil, fmt.Errorf("%s is the last node of the cluster", name)
+	}
+	return c.moveAway(i, NewHashRing(others...), dryRun, progress, nil)
+}
+
+// moveAway moves the personas on member i that ring assigns to another member
+// there, appending them to moves.
+func (c *Cluster) moveAway(i int, ring *HashRing, dryRun bool, progress func(RebalanceMove), moves []RebalanceMove) ([]RebalanceMove, error) {
+	member := c.members[i]
+	personas, err := member.Store.GetPersonas()
+	if err != nil {
+		return moves, fmt.Errorf("list personas on %s: %w", member.Name, err)
+	}
+	sort.Strings(personas)
+	for _, personaID := range personas {
+		j := c.memberIndex(ring.Owner(personaID))
+		if j < 0 {
+			return moves, fmt.Errorf("no node owns %s", personaID)
+		}
+		if j == i {
+			continue
+		}
+		move := RebalanceMove{PersonaID: personaID, From: member.Name, To: c.members[j].Name}
+		if err := c.movePersona(member.Store, c.members[j].Store, &move, dryRun); err != nil {
+			return moves, fmt.Errorf("move %s from %s to %s: %w", personaID, move.From, move.To, err)
+		}
+		if move.Keys == 0 {
+			continue // An empty persona left behind by an earlier move
+		}
+		moves = append(moves, move)
+		if progress != nil {
+			progress(move)
+		}
+	}
+	return moves, nil
+}
+
+// memberIndex returns the index of the member called name, or -1.
+func (c *Cluster) memberIndex(name string) int {
+	for i, member := range c.members {
+		if member.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Cluster) movePersona(from, to CelerixStore, move *RebalanceMove, dryRun bool) error {
+	apps, err := from.GetApps(move.PersonaID)
+	if err != nil {
+		return err
+	}
+	for _, appID := range apps {
+		data, err := from.GetAppStore(move.PersonaID, appID)
+		if IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		move.Keys += len(data)
+		if dryRun || len(data) == 0 {
+			continue
+		}
+		records := make([]Record, 0, len(data))
+		for _, key := range slices.Sorted(maps.Keys(data)) {
+			records = append(records, Record{PersonaID: move.PersonaID, AppID: appID, Key: key, Value: data[key]})
+		}
+		if err := WriteBatch(to, records); err != nil {
+			return err
+		}
+		for _, rec := range records {
+			if err := deleteIfUnchanged(from, rec); errors.Is(err, ErrConflict) {
+				move.Kept++
+			} else if err != nil && !IsNotFound(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteIfUnchanged deletes rec's key if it still holds rec's value, on
+// stores that are a Pruner, and unconditionally on others.
+func deleteIfUnchanged(s CelerixStore, rec Record) error {
+	if p, ok := s.(Pruner); ok {
+		return p.DeleteIfEquals(rec.PersonaID, rec.AppID, rec.Key, rec.Value)
+	}
+	return s.Delete(rec.PersonaID, rec.AppID, rec.Key)
+}
+
+// NodeStatus is the state of one node of a cluster, as reported by
+// Cluster.Status and ProbeCluster.
+type NodeStatus struct {
+	Name string `json:"name"`
+	// Error is set if the node couldn't be asked; the fields below are then
+	// empty.
+	Error string `json:"error,omitempty"`
+	// Version is the daemon's version, for nodes connected over TCP.
+	Version string `json:"version,omitempty"`
+	// Health is HealthOK or HealthDegraded, for nodes that report it.
+	Health   string `json:"health,omitempty"`
+	Personas int    `json:"personas"`
+	// Misplaced counts the personas holding data on the node that another
+	// node owns, which Rebalance would move.
+	Misplaced int `json:"misplaced"`
+	// LatencyMs is how long listing the node's personas took.
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+// Status asks every node for its health and personas, in node order.
+func (c *Cluster) Status() []NodeStatus {
+	list := make([]NodeStatus, len(c.members))
+	for i, member := range c.members {
+		list[i] = nodeStatus(member.Name, member.Store, c.ring)
+	}
+	return list
+}
+
+// ProbeCluster is Cluster.Status for the daemons at addrs, connecting to each
+// in turn with opts, so that nodes that are down are reported rather than
+// failing the whole probe like ConnectCluster.
+func ProbeCluster(addrs []string, opts ...ClientOption) []NodeStatus {
+	ring := NewHashRing(addrs...)
+	list := make([]NodeStatus, len(addrs))
+	for i, addr := range addrs {
+		client, err := Connect(addr, opts...)
+		if err != nil {
+			list[i] = NodeStatus{Name: addr, Error: err.Error()}
+			continue
+		}
+		list[i] = nodeStatus(addr, client, ring)
+		client.Close()
+	}
+	return list
+}
+
+func nodeStatus(name string, s CelerixStore, ring *HashRing) NodeStatus {
+	st := NodeStatus{Name: name}
+	if client, ok := s.(*Client); ok {
+		st.Version = client.ServerVersion().Version
+	}
+	if hr, ok := s.(HealthReporter); ok {
+		health, err := hr.Health()
+		if err != nil {
+			return NodeStatus{Name: name, Error: err.Error()}
+		}
+		st.Health = health.Status
+	}
+	start := time.Now()
+	personas, err := s.GetPersonas()
+	st.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+	if err != nil {
+		return NodeStatus{Name: name, Error: err.Error()}
+	}
+	st.Personas = len(personas)
+	for _, personaID := range personas {
+		if ring.Owner(personaID) == name {
+			continue
+		}
+		if hasData, err := holdsData(s, personaID); err != nil {
+			return NodeStatus{Name: name, Error: err.Error()}
+		} else if hasData {
+			st.Misplaced++
+		}
+	}
+	return st
+}
+
+// holdsData reports whether a persona has any keys, unlike one emptied by a
+// move, which stays listed.
+func holdsData(s CelerixStore, personaID string) (bool, error) {
+	apps, err := s.GetApps(personaID)
+	if err != nil {
+		return false, err
+	}
+	for _, appID := range apps {
+		data, err := s.GetAppStore(personaID, appID)
+		if err != nil && !IsNotFound(err) {
+			return false, err
+		}
+		if len(data) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
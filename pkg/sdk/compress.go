@@ -0,0 +1,77 @@
+package sdk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Compression is the line compression a client asks for with
+// "HELLO <version> gzip". A daemon that supports it says so in Hello, and from
+// then on either side may send a line longer than CompressThreshold as
+// CompressedLine followed by the gzipped line in base64. Streams such as
+// WATCH, IMPORT and blobs are never compressed.
+const Compression = "gzip"
+
+// CompressedLine starts a compressed line: "Z <base64>".
+const CompressedLine = "Z"
+
+// CompressThreshold is the length in bytes above which lines are compressed.
+// Shorter ones rarely shrink enough to make up for the work.
+const CompressThreshold = 1024
+
+// MaxDecompressedLine is the longest line, in bytes, the daemon decompresses,
+// so a small compressed line can't blow up in its memory. Clients send longer
+// lines uncompressed.
+const MaxDecompressedLine = 64 << 20
+
+// WithCompression compresses commands and responses longer than
+// CompressThreshold if the daemon supports it, trading CPU for bandwidth,
+// e.g. for dumps of big apps between datacenters.
+func WithCompression() ClientOption {
+	return func(c *Client) {
+		c.compression = true
+	}
+}
+
+// CompressLine returns line, without its newline, as a compressed line.
+func CompressLine(line string) string {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	io.WriteString(zw, line)
+	zw.Close()
+	return CompressedLine + " " + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// DecompressLine returns the line a compressed line stands for, failing if it
+// is longer than limit bytes (if limit is positive). Other lines are returned
+// as they are.
+func DecompressLine(line string, limit int64) (string, error) {
+	payload, ok := strings.CutPrefix(line, CompressedLine+" ")
+	if !ok {
+		return line, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", fmt.Errorf("invalid compressed line: %w", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("invalid compressed line: %w", err)
+	}
+	var r io.Reader = zr
+	if limit > 0 {
+		r = io.LimitReader(zr, limit+1)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("invalid compressed line: %w", err)
+	}
+	if limit > 0 && int64(len(out)) > limit {
+		return "", fmt.Errorf("compressed line longer than %d bytes", limit)
+	}
+	return string(out), nil
+}
@@ -5,13 +5,16 @@ import (
 	"os"
 )
 
-// Persistence is a stub to avoid importing engine,
-// but we need it for the interface.
-// Actually we should move engine logic or use interfaces.
+// Persistence is the part of the engine's persistence that New needs.
+//
+// Deprecated: Use engine.Open, which needs no registration.
 type Persistence interface {
 	LoadAll() (map[string]map[string]map[string]any, error)
 }
 
+// EngineProvider lets New create an embedded store without importing the engine.
+//
+// Deprecated: Use engine.Open, which needs no registration.
 type EngineProvider interface {
 	NewPersistence(dir string) (Persistence, error)
 	NewMemStore(initialData map[string]map[string]map[string]any, p Persistence) CelerixStore
@@ -19,6 +22,10 @@ type EngineProvider interface {
 
 var provider EngineProvider
 
+// RegisterEngine is called by the engine package's init to make embedded mode
+// available to New.
+//
+// Deprecated: Use engine.Open, which needs no registration.
 func RegisterEngine(p EngineProvider) {
 	provider = p
 }
@@ -26,6 +33,10 @@ func RegisterEngine(p EngineProvider) {
 // New initializes a CelerixStore based on the environment.
 // It automatically detects whether to connect to a remote server (via CELERIX_STORE_ADDR)
 // or initialize a local embedded engine.
+//
+// Embedded mode only works if the engine package was imported somewhere, which
+// registers it as a side effect. engine.OpenOrConnect behaves the same without
+// that, and engine.Open opens an embedded store directly.
 func New(dataDir string) (CelerixStore, error) {
 	// 1. Check if a Remote Store is defined in Environment Variables
 	remoteAddr := os.Getenv("CELERIX_STORE_ADDR")
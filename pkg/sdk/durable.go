@@ -0,0 +1,37 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SyncWriter stores values durably on request: SetSync returns only once the
+// value has been written to disk, instead of while it is still being saved in
+// the background. It is optional: use SetSync.
+type SyncWriter interface {
+	SetSync(personaID, appID, key string, val any) error
+}
+
+// SetSync stores a value in s and waits for it to be durable. s must be a
+// SyncWriter: a plain Set can't confirm durability, so there is no fallback.
+func SetSync(s any, personaID, appID, key string, val any) error {
+	if w, ok := s.(SyncWriter); ok {
+		return w.SetSync(personaID, appID, key, val)
+	}
+	return fmt.Errorf("durable writes: %w", ErrNotSupported)
+}
+
+// SetSync stores a value with SET_SYNC, returning once the daemon has written
+// it to disk. Unlike Set, it is never queued while the daemon is unreachable.
+func (c *Client) SetSync(personaID, appID, key string, val any) error {
+	if err := ValidateIDs(personaID, appID, key); err != nil {
+		return err
+	}
+	jsonData, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	c.misses.forget(personaID, appID, key)
+	_, err = c.sendAndReceive(fmt.Sprintf("SET_SYNC %s %s %s %s", personaID, appID, key, string(jsonData)))
+	return err
+}
@@ -0,0 +1,118 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/internal/vault"
+)
+
+// PersonaExport is a portable archive of everything stored for one persona,
+// e.g. to hand a user their data.
+type PersonaExport struct {
+	PersonaID  string                    `json:"persona_id"`
+	ExportedAt time.Time                 `json:"exported_at"`
+	Apps       map[string]map[string]any `json:"apps"`
+}
+
+// PersonaExporter is the subset of CelerixStore ExportPersona needs.
+type PersonaExporter interface {
+	AppEnumeration
+	GetAppStore(personaID, appID string) (map[string]any, error)
+}
+
+// PersonaReader reads all apps of a persona at once, as one consistent
+// snapshot. It is optional: use GetPersona, which falls back to reading app
+// by app.
+type PersonaReader interface {
+	// GetPersona returns every app of the persona with its keys. An unknown
+	// persona has no apps.
+	GetPersona(personaID string) (map[string]map[string]any, error)
+}
+
+// GetPersona reads all apps of a persona from s.
+func GetPersona(s PersonaExporter, personaID string) (map[string]map[string]any, error) {
+	if pr, ok := s.(PersonaReader); ok {
+		return pr.GetPersona(personaID)
+	}
+	apps, err := s.GetApps(personaID)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]map[string]any, len(apps))
+	for _, appID := range apps {
+		appData, err := s.GetAppStore(personaID, appID)
+		if IsNotFound(err) {
+			continue // Emptied since it was listed
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read app %s: %w", appID, err)
+		}
+		data[appID] = appData
+	}
+	return data, nil
+}
+
+// ExportPersona collects all apps of a persona into an archive.
+func ExportPersona(s PersonaExporter, personaID string) (*PersonaExport, error) {
+	apps, err := GetPersona(s, personaID)
+	if err != nil {
+		return nil, err
+	}
+	return &PersonaExport{PersonaID: personaID, ExportedAt: time.Now().UTC(), Apps: apps}, nil
+}
+
+// WriteExport writes the archive as JSON. With a recipient (an age1... public
+// key, see GenerateExportKey), the archive is encrypted in the age format so it
+// can travel over untrusted channels and only the key's owner can open it,
+// with ReadExport or the standard age tool.
+func WriteExport(w io.Writer, exp *PersonaExport, recipient string) error {
+	data, err := json.MarshalIndent(exp, "", "  ")
+	if err != nil {
+		return err
+	}
+	if recipient != "" {
+		if data, err = vault.EncryptToRecipient(data, recipient); err != nil {
+			return err
+		}
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ReadExport reads an archive written by WriteExport. Encrypted archives need
+// the identity (AGE-SECRET-KEY-1..., or the contents of an age identity file)
+// matching the recipient they were written for.
+func ReadExport(r io.Reader, identity string) (*PersonaExport, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if vault.IsAgeEncrypted(data) {
+		if identity == "" {
+			return nil, fmt.Errorf("export is encrypted: an identity is required")
+		}
+		if data, err = vault.DecryptWithIdentity(data, identity); err != nil {
+			return nil, err
+		}
+	}
+	var exp PersonaExport
+	if err := json.Unmarshal(data, &exp); err != nil {
+		return nil, err
+	}
+	return &exp, nil
+}
+
+// ValidateExportRecipient checks an age1... public key before any data is exported.
+func ValidateExportRecipient(recipient string) error {
+	_, err := vault.ParseAgeRecipient(recipient)
+	return err
+}
+
+// GenerateExportKey creates a key pair for encrypted exports: the user keeps
+// the identity and shares the recipient.
+func GenerateExportKey() (identity, recipient string, err error) {
+	return vault.GenerateAgeIdentity()
+}
@@ -0,0 +1,382 @@
+// Package fake provides an in-memory sdk.CelerixStore for unit tests of code
+// that uses the store, so that they need neither a daemon (see pkg/testutil)
+// nor a hand-written mock. It behaves like the engine where callers can tell,
+// is deterministic, records the calls made on it and can be told to fail.
+package fake
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// Operations of a Store, named after the sdk.CelerixStore methods. Calls made
+// through an app scope are recorded as the store operation they become.
+const (
+	OpGet         = "Get"
+	OpSet         = "Set"
+	OpDelete      = "Delete"
+	OpGetPersonas = "GetPersonas"
+	OpGetApps     = "GetApps"
+	OpGetAppStore = "GetAppStore"
+	OpDumpApp     = "DumpApp"
+	OpGetGlobal   = "GetGlobal"
+	OpMove        = "Move"
+)
+
+// Call is an operation made on a Store, as recorded by Calls and passed to
+// the hook. Fields an operation doesn't take are empty.
+type Call struct {
+	Op        string
+	PersonaID string
+	AppID     string
+	Key       string
+	// DstPersona is the persona a Move goes to.
+	DstPersona string
+	// Value is the value given to Set.
+	Value any
+}
+
+// Store is an in-memory sdk.CelerixStore. Values go through JSON on Set, as
+// they would on their way to a daemon, so structs come back as maps and
+// numbers as float64. Listings are sorted and GetGlobal looks through the
+// personas in order, so tests don't depend on map order. It is safe for
+// concurrent use.
+type Store struct {
+	mu    sync.Mutex
+	data  map[string]map[string]map[string]any
+	calls []Call
+	fails []*failure
+	hook  func(Call) error
+}
+
+// failure is an error injected with Fail or FailNext.
+type failure struct {
+	op   string
+	err  error
+	once bool
+}
+
+var _ sdk.CelerixStore = (*Store)(nil)
+
+// NewStore returns an empty fake store.
+func NewStore() *Store {
+	return &Store{data: make(map[string]map[string]map[string]any)}
+}
+
+// Load adds data, mapping persona IDs to app IDs to keys to values, without
+// recording calls or running the hook, to set up a test.
+func (s *Store) Load(data map[string]map[string]map[string]any) error {
+	for personaID, apps := range data {
+		for appID, values := range apps {
+			for key, val := range values {
+				if err := sdk.ValidateIDs(personaID, appID, key); err != nil {
+					return err
+				}
+				normalized, err := normalize(val)
+				if err != nil {
+					return err
+				}
+				s.mu.Lock()
+				s.setLocked(personaID, appID, key, normalized)
+				s.mu.Unlock()
+			}
+		}
+	}
+	return nil
+}
+
+// Calls returns the calls made so far, oldest first, including failed ones.
+func (s *Store) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// ResetCalls forgets the calls made so far.
+func (s *Store) ResetCalls() {
+	s.mu.Lock()
+	s.calls = nil
+	s.mu.Unlock()
+}
+
+// FailNext makes the next call of op fail with err, leaving the data alone.
+// An empty op matches any operation. Injected failures are used up in the
+// order they were added.
+func (s *Store) FailNext(op string, err error) {
+	s.mu.Lock()
+	s.fails = append(s.fails, &failure{op: op, err: err, once: true})
+	s.mu.Unlock()
+}
+
+// Fail makes every call of op fail with err until ClearFailures. An empty op
+// matches any operation, e.g. to simulate a daemon that is down.
+func (s *Store) Fail(op string, err error) {
+	s.mu.Lock()
+	s.fails = append(s.fails, &failure{op: op, err: err})
+	s.mu.Unlock()
+}
+
+// ClearFailures drops the failures injected with Fail and FailNext.
+func (s *Store) ClearFailures() {
+	s.mu.Lock()
+	s.fails = nil
+	s.mu.Unlock()
+}
+
+// SetHook has fn called before every operation, after injected failures are
+// checked; an error it returns fails the operation. It may call the store,
+// e.g. to change data under the caller's feet. A nil fn removes the hook.
+func (s *Store) SetHook(fn func(Call) error) {
+	s.mu.Lock()
+	s.hook = fn
+	s.mu.Unlock()
+}
+
+// begin records a call and returns the error it should fail with, if any.
+func (s *Store) begin(c Call) error {
+	s.mu.Lock()
+	s.calls = append(s.calls, c)
+	for i, f := range s.fails {
+		if f.op == "" || f.op == c.Op {
+			if f.once {
+				s.fails = append(s.fails[:i:i], s.fails[i+1:]...)
+			}
+			s.mu.Unlock()
+			return f.err
+		}
+	}
+	hook := s.hook
+	s.mu.Unlock()
+	if hook != nil {
+		return hook(c)
+	}
+	return nil
+}
+
+func (s *Store) Get(personaID, appID, key string) (any, error) {
+	if err := s.begin(Call{Op: OpGet, PersonaID: personaID, AppID: appID, Key: key}); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	apps, ok := s.data[personaID]
+	if !ok {
+		return nil, sdk.ErrPersonaNotFound
+	}
+	values, ok := apps[appID]
+	if !ok {
+		return nil, sdk.ErrAppNotFound
+	}
+	val, ok := values[key]
+	if !ok {
+		return nil, sdk.ErrKeyNotFound
+	}
+	return clone(val), nil
+}
+
+func (s *Store) Set(personaID, appID, key string, val any) error {
+	if err := s.begin(Call{Op: OpSet, PersonaID: personaID, AppID: appID, Key: key, Value: val}); err != nil {
+		return err
+	}
+	if err := sdk.ValidateIDs(personaID, appID, key); err != nil {
+		return err
+	}
+	normalized, err := normalize(val)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.setLocked(personaID, appID, key, normalized)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Store) setLocked(personaID, appID, key string, val any) {
+	apps, ok := s.data[personaID]
+	if !ok {
+		apps = make(map[string]map[string]any)
+		s.data[personaID] = apps
+	}
+	values, ok := apps[appID]
+	if !ok {
+		values = make(map[string]any)
+		apps[appID] = values
+	}
+	values[key] = val
+}
+
+// Delete removes a value. Like the engine, deleting a value that doesn't
+// exist succeeds.
+func (s *Store) Delete(personaID, appID, key string) error {
+	if err := s.begin(Call{Op: OpDelete, PersonaID: personaID, AppID: appID, Key: key}); err != nil {
+		return err
+	}
+	if err := sdk.ValidateID("persona ID", personaID); err != nil {
+		return err
+	}
+	if err := sdk.ValidateID("app ID", appID); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data[personaID][appID], key)
+	return nil
+}
+
+func (s *Store) GetPersonas() ([]string, error) {
+	if err := s.begin(Call{Op: OpGetPersonas}); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Sorted(maps.Keys(s.data)), nil
+}
+
+// GetApps lists the apps of a persona; like the engine, a persona without
+// apps has none rather than being an error.
+func (s *Store) GetApps(personaID string) ([]string, error) {
+	if err := s.begin(Call{Op: OpGetApps, PersonaID: personaID}); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Sorted(maps.Keys(s.data[personaID])), nil
+}
+
+func (s *Store) GetAppStore(personaID, appID string) (map[string]any, error) {
+	if err := s.begin(Call{Op: OpGetAppStore, PersonaID: personaID, AppID: appID}); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values, ok := s.data[personaID][appID]
+	if !ok {
+		return nil, sdk.ErrAppNotFound
+	}
+	return cloneMap(values), nil
+}
+
+func (s *Store) DumpApp(appID string) (map[string]map[string]any, error) {
+	if err := s.begin(Call{Op: OpDumpApp, AppID: appID}); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dump := make(map[string]map[string]any)
+	for personaID, apps := range s.data {
+		if values, ok := apps[appID]; ok {
+			dump[personaID] = cloneMap(values)
+		}
+	}
+	return dump, nil
+}
+
+// GetGlobal returns the value of key in the first persona, in sorted order,
+// that has one.
+func (s *Store) GetGlobal(appID, key string) (any, string, error) {
+	if err := s.begin(Call{Op: OpGetGlobal, AppID: appID, Key: key}); err != nil {
+		return nil, "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, personaID := range slices.Sorted(maps.Keys(s.data)) {
+		if val, ok := s.data[personaID][appID][key]; ok {
+			return clone(val), personaID, nil
+		}
+	}
+	return nil, "", sdk.ErrKeyNotFound
+}
+
+// Move moves a value to another persona, replacing any value there.
+func (s *Store) Move(srcPersona, dstPersona, appID, key string) error {
+	if err := s.begin(Call{Op: OpMove, PersonaID: srcPersona, AppID: appID, Key: key, DstPersona: dstPersona}); err != nil {
+		return err
+	}
+	if err := sdk.ValidateIDs(dstPersona, appID, key); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	apps, ok := s.data[srcPersona]
+	if !ok {
+		return sdk.ErrPersonaNotFound
+	}
+	values, ok := apps[appID]
+	if !ok {
+		return sdk.ErrAppNotFound
+	}
+	val, ok := values[key]
+	if !ok {
+		return sdk.ErrKeyNotFound
+	}
+	delete(values, key)
+	s.setLocked(dstPersona, appID, key, val)
+	return nil
+}
+
+func (s *Store) App(personaID, appID string) sdk.AppScope {
+	return &appScope{store: s, personaID: personaID, appID: appID}
+}
+
+// appScope pins a persona and app of a Store.
+type appScope struct {
+	store     *Store
+	personaID string
+	appID     string
+}
+
+func (a *appScope) Get(key string) (any, error) {
+	return a.store.Get(a.personaID, a.appID, key)
+}
+
+func (a *appScope) Set(key string, val any) error {
+	return a.store.Set(a.personaID, a.appID, key, val)
+}
+
+func (a *appScope) Delete(key string) error {
+	return a.store.Delete(a.personaID, a.appID, key)
+}
+
+func (a *appScope) Vault(masterKey []byte) sdk.VaultScope {
+	return sdk.KeyVault(a, masterKey)
+}
+
+// normalize returns val as it reads back after a round trip through JSON.
+func normalize(val any) (any, error) {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	var out any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// clone copies a normalized value, so that callers can't change stored ones.
+func clone(val any) any {
+	switch v := val.(type) {
+	case map[string]any:
+		return cloneMap(v)
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = clone(item)
+		}
+		return out
+	}
+	return val
+}
+
+func cloneMap(m map[string]any) map[string]any {
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = clone(v)
+	}
+	return out
+}
@@ -0,0 +1,140 @@
+package fake
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+func TestStore(t *testing.T) {
+	s := NewStore()
+	type plan struct {
+		Name  string `json:"name"`
+		Seats int    `json:"seats"`
+	}
+	if err := s.Set("bob", "billing", "plan", plan{"pro", 5}); err != nil {
+		t.Fatal(err)
+	}
+	s.Set("alice", "billing", "plan", "free")
+	s.Set("alice", "prefs", "theme", "dark")
+
+	// Values read back as they would from a daemon
+	got, err := s.Get("bob", "billing", "plan")
+	if want := map[string]any{"name": "pro", "seats": float64(5)}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v, %v", want, got, err)
+	}
+	got.(map[string]any)["seats"] = float64(50)
+	if again, _ := s.Get("bob", "billing", "plan"); again.(map[string]any)["seats"] != float64(5) {
+		t.Error("Expected stored values to be copied")
+	}
+
+	for _, tt := range []struct {
+		personaID, appID, key string
+		want                  error
+	}{
+		{"carol", "billing", "plan", sdk.ErrPersonaNotFound},
+		{"alice", "games", "plan", sdk.ErrAppNotFound},
+		{"alice", "billing", "seats", sdk.ErrKeyNotFound},
+	} {
+		if _, err := s.Get(tt.personaID, tt.appID, tt.key); !errors.Is(err, tt.want) {
+			t.Errorf("Get(%s, %s, %s): expected %v, got %v", tt.personaID, tt.appID, tt.key, tt.want, err)
+		}
+	}
+	if err := s.Set("", "billing", "plan", 1); !errors.Is(err, sdk.ErrBadRequest) {
+		t.Errorf("Expected an invalid ID to be refused, got %v", err)
+	}
+
+	if personas, _ := s.GetPersonas(); !reflect.DeepEqual(personas, []string{"alice", "bob"}) {
+		t.Errorf("Expected sorted personas, got %v", personas)
+	}
+	if apps, _ := s.GetApps("alice"); !reflect.DeepEqual(apps, []string{"billing", "prefs"}) {
+		t.Errorf("Expected sorted apps, got %v", apps)
+	}
+	if val, personaID, _ := s.GetGlobal("billing", "plan"); personaID != "alice" || val != "free" {
+		t.Errorf("Expected the first persona's value, got %v from %s", val, personaID)
+	}
+	if dump, _ := s.DumpApp("billing"); len(dump) != 2 {
+		t.Errorf("Expected both personas dumped, got %v", dump)
+	}
+
+	if err := s.Move("alice", "carol", "prefs", "theme"); err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := s.App("carol", "prefs").Get("theme"); val != "dark" {
+		t.Errorf("Expected the value moved, got %v", val)
+	}
+	if err := s.Move("alice", "carol", "prefs", "theme"); !errors.Is(err, sdk.ErrKeyNotFound) {
+		t.Errorf("Expected the source to be gone, got %v", err)
+	}
+
+	vault := s.App("alice", "secrets").Vault(make([]byte, 32))
+	if err := vault.Set("token", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := s.Get("alice", "secrets", "token"); raw == "hunter2" {
+		t.Error("Expected the vault value to be encrypted")
+	}
+	if plaintext, err := vault.Get("token"); err != nil || plaintext != "hunter2" {
+		t.Errorf("Expected the vault value back, got %q, %v", plaintext, err)
+	}
+}
+
+func TestStore_Failures(t *testing.T) {
+	s := NewStore()
+	s.Load(map[string]map[string]map[string]any{"alice": {"billing": {"plan": "pro"}}})
+	if calls := s.Calls(); len(calls) != 0 {
+		t.Errorf("Expected Load not to be recorded, got %v", calls)
+	}
+
+	boom := errors.New("boom")
+	s.FailNext(OpSet, boom)
+	if err := s.Set("alice", "billing", "plan", "free"); err != boom {
+		t.Errorf("Expected the injected error, got %v", err)
+	}
+	if val, _ := s.Get("alice", "billing", "plan"); val != "pro" {
+		t.Errorf("Expected a failed Set to leave the data alone, got %v", val)
+	}
+	if err := s.Set("alice", "billing", "plan", "free"); err != nil {
+		t.Errorf("Expected FailNext to fail once, got %v", err)
+	}
+
+	s.Fail("", sdk.ErrServerBusy)
+	for i := 0; i < 2; i++ {
+		if _, err := s.GetPersonas(); !errors.Is(err, sdk.ErrServerBusy) {
+			t.Errorf("Expected every call to fail, got %v", err)
+		}
+	}
+	s.ClearFailures()
+
+	var seen []string
+	s.SetHook(func(c Call) error {
+		seen = append(seen, c.Op+" "+c.Key)
+		if c.Key == "locked" {
+			return sdk.ErrConflict
+		}
+		return nil
+	})
+	app := s.App("alice", "billing")
+	if err := app.Set("locked", 1); !errors.Is(err, sdk.ErrConflict) {
+		t.Errorf("Expected the hook's error, got %v", err)
+	}
+	app.Delete("plan")
+	if want := []string{"Set locked", "Delete plan"}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("Expected the hook to see %v, got %v", want, seen)
+	}
+	s.SetHook(nil)
+
+	calls := s.Calls()
+	if len(calls) != 7 {
+		t.Fatalf("Expected 7 calls recorded, got %v", calls)
+	}
+	if c := calls[0]; c.Op != OpSet || c.PersonaID != "alice" || c.Value != "free" {
+		t.Errorf("Expected the failed Set recorded first, got %+v", c)
+	}
+	s.ResetCalls()
+	if len(s.Calls()) != 0 {
+		t.Error("Expected the calls to be forgotten")
+	}
+}
@@ -0,0 +1,71 @@
+package sdk
+
+import "hash/fnv"
+
+// Flag is the global definition of a feature flag.
+type Flag struct {
+	Enabled bool `json:"enabled"`
+	// Percent limits an enabled flag to a stable share of personas (1-99).
+	// 0 or 100 and above enable it for everyone.
+	Percent int `json:"percent,omitempty"`
+}
+
+// FeatureFlags evaluates feature flags defined in the _system persona, with
+// optional per-persona overrides stored in each persona under the same app.
+type FeatureFlags struct {
+	store KVStore
+	appID string
+}
+
+// NewFeatureFlags keeps flag definitions and overrides in appID.
+func NewFeatureFlags(s KVStore, appID string) *FeatureFlags {
+	return &FeatureFlags{store: s, appID: appID}
+}
+
+// Define creates or replaces the global definition of a flag.
+func (f *FeatureFlags) Define(name string, flag Flag) error {
+	return f.store.Set(SystemPersona, f.appID, name, flag)
+}
+
+// Override forces a flag on or off for one persona, regardless of its definition.
+func (f *FeatureFlags) Override(personaID, name string, enabled bool) error {
+	return f.store.Set(personaID, f.appID, name, enabled)
+}
+
+// ClearOverride makes the persona follow the global definition again.
+func (f *FeatureFlags) ClearOverride(personaID, name string) error {
+	if err := f.store.Delete(personaID, f.appID, name); err != nil && !IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// Enabled reports whether a flag is on for personaID. Undefined flags are off.
+func (f *FeatureFlags) Enabled(personaID, name string) (bool, error) {
+	override, err := Get[bool](f.store, personaID, f.appID, name)
+	if err == nil {
+		return override, nil
+	}
+	if !IsNotFound(err) {
+		return false, err
+	}
+
+	flag, err := Get[Flag](f.store, SystemPersona, f.appID, name)
+	if err != nil {
+		if IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !flag.Enabled {
+		return false, nil
+	}
+	if flag.Percent <= 0 || flag.Percent >= 100 {
+		return true, nil
+	}
+
+	// Hash persona and flag together so each flag rolls out to a different subset.
+	h := fnv.New32a()
+	h.Write([]byte(name + "/" + personaID))
+	return int(h.Sum32()%100) < flag.Percent, nil
+}
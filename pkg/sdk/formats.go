@@ -0,0 +1,210 @@
+package sdk
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Archive formats besides ndjson records, for round-tripping configuration
+// with tools that don't speak the archive's JSON.
+const (
+	FormatJSON = "json" // The PersonaExport archive, as written by WriteExport
+	FormatYAML = "yaml" // The same archive as YAML
+	FormatCSV  = "csv"  // app,key,value rows
+	FormatEnv  = "env"  // KEY=value lines of a single app, as read by dotenv
+)
+
+// WriteExportFormat writes the archive in one of the Format* formats. CSV and
+// env files hold plain strings as they are and other values as JSON; strings
+// that would read back as JSON are quoted. Env files hold a single app, so
+// exp must contain exactly one.
+func WriteExportFormat(w io.Writer, exp *PersonaExport, format string) error {
+	switch format {
+	case FormatJSON:
+		return WriteExport(w, exp, "")
+	case FormatYAML:
+		data, err := json.Marshal(exp)
+		if err != nil {
+			return err
+		}
+		if data, err = yaml.JSONToYAML(data); err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"app", "key", "value"})
+		for _, appID := range slices.Sorted(maps.Keys(exp.Apps)) {
+			for _, key := range slices.Sorted(maps.Keys(exp.Apps[appID])) {
+				text, err := flatValue(exp.Apps[appID][key])
+				if err != nil {
+					return fmt.Errorf("%s/%s: %w", appID, key, err)
+				}
+				cw.Write([]string{appID, key, text})
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case FormatEnv:
+		if len(exp.Apps) != 1 {
+			return fmt.Errorf("env files hold a single app, the export has %d", len(exp.Apps))
+		}
+		bw := bufio.NewWriter(w)
+		for _, data := range exp.Apps {
+			for _, key := range slices.Sorted(maps.Keys(data)) {
+				text, err := flatValue(data[key])
+				if err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+				fmt.Fprintf(bw, "%s=%s\n", key, quoteEnv(text))
+			}
+		}
+		return bw.Flush()
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// ReadExportFormat reads an archive written by WriteExportFormat, or by hand.
+// CSV and env files don't name their persona, and env files don't name their
+// app, so personaID and appID supply them; a non-empty personaID also
+// overrides the persona of a JSON or YAML archive.
+func ReadExportFormat(r io.Reader, format, personaID, appID string) (*PersonaExport, error) {
+	exp := &PersonaExport{PersonaID: personaID, ExportedAt: time.Now().UTC(), Apps: map[string]map[string]any{}}
+	add := func(appID, key, text string) {
+		if exp.Apps[appID] == nil {
+			exp.Apps[appID] = map[string]any{}
+		}
+		exp.Apps[appID][key] = parseFlatValue(text)
+	}
+
+	switch format {
+	case FormatJSON, FormatYAML:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if format == FormatYAML {
+			if data, err = yaml.YAMLToJSON(data); err != nil {
+				return nil, err
+			}
+		}
+		if err := json.Unmarshal(data, exp); err != nil {
+			return nil, err
+		}
+		if personaID != "" {
+			exp.PersonaID = personaID
+		}
+	case FormatCSV:
+		rows, err := csv.NewReader(r).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		for i, row := range rows {
+			if len(row) != 3 {
+				return nil, fmt.Errorf("row %d: want app,key,value", i+1)
+			}
+			if i == 0 && row[0] == "app" && row[1] == "key" {
+				continue // Header
+			}
+			add(row[0], row[1], row[2])
+		}
+	case FormatEnv:
+		if appID == "" {
+			return nil, fmt.Errorf("env files need an app ID")
+		}
+		scanner := bufio.NewScanner(r)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			key, val, ok := strings.Cut(strings.TrimPrefix(text, "export "), "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: want KEY=value", line)
+			}
+			val, err := unquoteEnv(strings.TrimSpace(val))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			add(appID, strings.TrimSpace(key), val)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+
+	if exp.PersonaID == "" {
+		return nil, fmt.Errorf("no persona ID for the imported values")
+	}
+	return exp, nil
+}
+
+// ImportPersona writes every value of an archive to s and returns how many
+// there were.
+func ImportPersona(s KVWriter, exp *PersonaExport) (int, error) {
+	var records []Record
+	for appID, data := range exp.Apps {
+		for key, val := range data {
+			records = append(records, Record{PersonaID: exp.PersonaID, AppID: appID, Key: key, Value: val})
+		}
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+	if err := WriteBatch(s, records); err != nil {
+		return 0, err
+	}
+	return len(records), nil
+}
+
+// flatValue renders a value for a CSV cell or env file: strings as they are
+// unless they would parse as JSON, anything else as JSON.
+func flatValue(val any) (string, error) {
+	if s, ok := val.(string); ok && !json.Valid([]byte(s)) {
+		return s, nil
+	}
+	data, err := json.Marshal(val)
+	return string(data), err
+}
+
+// parseFlatValue reverses flatValue.
+func parseFlatValue(text string) any {
+	var val any
+	if err := json.Unmarshal([]byte(text), &val); err == nil {
+		return val
+	}
+	return text
+}
+
+// quoteEnv double-quotes values a shell or dotenv parser would mangle.
+func quoteEnv(text string) string {
+	for _, r := range text {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_-.,:/@+", r)) {
+			return strconv.Quote(text)
+		}
+	}
+	return text
+}
+
+func unquoteEnv(text string) (string, error) {
+	switch {
+	case len(text) >= 2 && text[0] == '"' && text[len(text)-1] == '"':
+		return strconv.Unquote(text)
+	case len(text) >= 2 && text[0] == '\'' && text[len(text)-1] == '\'':
+		return text[1 : len(text)-1], nil
+	}
+	return text, nil
+}
@@ -0,0 +1,67 @@
+package sdk
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Kinds of FsckProblem.
+const (
+	// FsckCorrupt is a data file that doesn't parse.
+	FsckCorrupt = "corrupt"
+	// FsckChecksum is a data file whose checksum footer doesn't match its contents.
+	FsckChecksum = "checksum"
+	// FsckUnreadable is a data file that couldn't be read at all.
+	FsckUnreadable = "unreadable"
+	// FsckOrphanedTemp is a temporary file left behind by a save that never
+	// finished, usually because the process died between writing and renaming it.
+	FsckOrphanedTemp = "orphaned_temp"
+	// FsckQuarantined is a persona set aside by an earlier load.
+	FsckQuarantined = "quarantined"
+)
+
+// FsckProblem is one finding of a consistency check.
+type FsckProblem struct {
+	PersonaID string `json:"persona_id"`
+	File      string `json:"file,omitempty"`
+	Kind      string `json:"kind"`
+	Detail    string `json:"detail,omitempty"`
+	// Repair says what was done about the problem; empty if nothing was.
+	Repair string `json:"repair,omitempty"`
+}
+
+// FsckReport is the result of checking every data file of a store.
+type FsckReport struct {
+	Files    int           `json:"files"`
+	Problems []FsckProblem `json:"problems,omitempty"`
+}
+
+// Unresolved counts the problems that weren't repaired.
+func (r FsckReport) Unresolved() int {
+	n := 0
+	for _, p := range r.Problems {
+		if p.Repair == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Checker verifies the data a store has on disk without changing it. Repairs
+// need the daemon stopped (celerix-stored --fsck --repair). It is optional:
+// callers should type-assert a CelerixStore to check for support.
+type Checker interface {
+	Fsck() (FsckReport, error)
+}
+
+// Fsck checks the daemon's data files with FSCK. It needs admin access when
+// the daemon has an admin token.
+func (c *Client) Fsck() (FsckReport, error) {
+	var report FsckReport
+	resp, err := c.sendAndReceive("FSCK")
+	if err != nil {
+		return report, err
+	}
+	err = json.Unmarshal([]byte(strings.TrimPrefix(resp, "OK ")), &report)
+	return report, err
+}
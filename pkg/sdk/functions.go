@@ -0,0 +1,99 @@
+package sdk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/schema"
+)
+
+// FunctionsApp is the app in the _system persona holding compute functions by
+// name.
+const FunctionsApp = "functions"
+
+// MaxFunctionSize is the largest WebAssembly module FunctionStore.Put accepts,
+// in bytes. Modules built with Go are 2 to 5 MiB.
+const MaxFunctionSize = 8 << 20
+
+// wasmMagic starts every WebAssembly binary module.
+var wasmMagic = []byte("\x00asm")
+
+// FunctionStore manages the compute functions the COMPUTE command runs. They
+// live in the _system persona, so only admins can change them.
+type FunctionStore struct {
+	store KVStore
+}
+
+// NewFunctionStore manages the functions held in s.
+func NewFunctionStore(s KVStore) *FunctionStore {
+	return &FunctionStore{store: s}
+}
+
+// Put stores wasm as the function name, replacing one of the same name.
+func (f *FunctionStore) Put(name string, wasm []byte) (schema.FunctionRecord, error) {
+	if err := ValidateID("function name", name); err != nil {
+		return schema.FunctionRecord{}, err
+	}
+	if !bytes.HasPrefix(wasm, wasmMagic) {
+		return schema.FunctionRecord{}, fmt.Errorf("function %s is not a WebAssembly module: %w", name, ErrBadRequest)
+	}
+	if len(wasm) > MaxFunctionSize {
+		return schema.FunctionRecord{}, fmt.Errorf("function %s is %d bytes, more than %d: %w", name, len(wasm), MaxFunctionSize, ErrBadRequest)
+	}
+	sum := sha256.Sum256(wasm)
+	rec := schema.FunctionRecord{
+		Name:      name,
+		WASM:      wasm,
+		SHA256:    hex.EncodeToString(sum[:]),
+		Size:      len(wasm),
+		UpdatedAt: time.Now().UTC(),
+	}
+	if err := f.store.Set(SystemPersona, FunctionsApp, name, rec); err != nil {
+		return schema.FunctionRecord{}, err
+	}
+	rec.WASM = nil
+	return rec, nil
+}
+
+// Get returns the function name, with its module.
+func (f *FunctionStore) Get(name string) (schema.FunctionRecord, error) {
+	return Get[schema.FunctionRecord](f.store, SystemPersona, FunctionsApp, name)
+}
+
+// List returns the functions, without their modules, sorted by name.
+func (f *FunctionStore) List() ([]schema.FunctionRecord, error) {
+	exporter, ok := f.store.(BatchExporter)
+	if !ok {
+		return nil, fmt.Errorf("listing functions: %w", ErrNotSupported)
+	}
+	data, err := exporter.GetAppStore(SystemPersona, FunctionsApp)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	funcs := make([]schema.FunctionRecord, 0, len(data))
+	for name := range data {
+		rec, err := f.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		rec.WASM = nil
+		funcs = append(funcs, rec)
+	}
+	sort.Slice(funcs, func(i, j int) bool { return funcs[i].Name < funcs[j].Name })
+	return funcs, nil
+}
+
+// Delete removes a function. Deleting an unknown function is not an error.
+func (f *FunctionStore) Delete(name string) error {
+	if err := f.store.Delete(SystemPersona, FunctionsApp, name); err != nil && !IsNotFound(err) {
+		return err
+	}
+	return nil
+}
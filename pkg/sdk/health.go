@@ -0,0 +1,42 @@
+package sdk
+
+import "time"
+
+// Health states reported by HealthReporter.
+const (
+	HealthOK = "ok"
+	// HealthDegraded means the store is serving requests but needs operator attention.
+	HealthDegraded = "degraded"
+)
+
+// QuarantinedPersona describes a persona whose stored data was found corrupt
+// and set aside. Writes to it are refused until an operator resolves it.
+type QuarantinedPersona struct {
+	PersonaID string    `json:"persona_id"`
+	File      string    `json:"file,omitempty"`
+	Reason    string    `json:"reason"`
+	Since     time.Time `json:"since"`
+}
+
+// SaveFailure describes data the store keeps failing to save to its storage
+// backend. It exists only in memory until a later save succeeds.
+type SaveFailure struct {
+	PersonaID string    `json:"persona_id"`
+	AppID     string    `json:"app_id,omitempty"` // Empty if the whole persona is saved at once
+	Error     string    `json:"error"`
+	Attempts  int       `json:"attempts"`
+	Since     time.Time `json:"since"`
+}
+
+// Health is a summary of conditions that need operator attention.
+type Health struct {
+	Status       string               `json:"status"`
+	Quarantined  []QuarantinedPersona `json:"quarantined,omitempty"`
+	SaveFailures []SaveFailure        `json:"save_failures,omitempty"`
+}
+
+// HealthReporter exposes the store's health. It is optional: callers should
+// type-assert a CelerixStore to check for support.
+type HealthReporter interface {
+	Health() (Health, error)
+}
@@ -0,0 +1,112 @@
+package sdk
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaxIDLength is the longest persona ID, app ID or key the store accepts.
+const MaxIDLength = 128
+
+// IDChars are the characters allowed in persona IDs, app IDs and keys besides
+// ASCII letters and digits. IDs must also not start with '.', so none of them
+// can be "." or ".." or a hidden file. This keeps IDs safe as file names, URL
+// path segments and words of the line protocol.
+const IDChars = "._:@+=-"
+
+// InvalidIDError is returned for a persona ID, app ID or key that breaks the
+// rules of ValidateID. It matches ErrBadRequest with errors.Is.
+type InvalidIDError struct {
+	Kind   string // "persona ID", "app ID" or "key"
+	ID     string
+	Reason string
+}
+
+func (e *InvalidIDError) Error() string {
+	return fmt.Sprintf("invalid %s %q: %s", e.Kind, e.ID, e.Reason)
+}
+
+func (e *InvalidIDError) Unwrap() error {
+	return ErrBadRequest
+}
+
+// ValidateID checks one ID; kind names it in the error, e.g. "persona ID".
+func ValidateID(kind, id string) error {
+	switch {
+	case id == "":
+		return &InvalidIDError{kind, id, "must not be empty"}
+	case len(id) > MaxIDLength:
+		return &InvalidIDError{kind, id, fmt.Sprintf("longer than %d characters", MaxIDLength)}
+	case id[0] == '.':
+		return &InvalidIDError{kind, id, "must not start with '.'"}
+	}
+	for _, r := range id {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && !strings.ContainsRune(IDChars, r) {
+			return &InvalidIDError{kind, id, fmt.Sprintf("%q is not allowed (use letters, digits and %s)", r, IDChars)}
+		}
+	}
+	return nil
+}
+
+// ValidateIDs checks the IDs addressing a value.
+func ValidateIDs(personaID, appID, key string) error {
+	if err := ValidateID("persona ID", personaID); err != nil {
+		return err
+	}
+	if err := ValidateID("app ID", appID); err != nil {
+		return err
+	}
+	return ValidateID("key", key)
+}
+
+// CheckSystemWrite returns an unauthorized error if any of personaIDs is
+// SystemPersona. Servers call it for writes from clients without admin rights.
+func CheckSystemWrite(personaIDs ...string) error {
+	for _, id := range personaIDs {
+		if id == SystemPersona {
+			return NewProtocolError(CodeUnauthorized, "writes to the "+SystemPersona+" persona need admin rights")
+		}
+	}
+	return nil
+}
+
+// DenySystemWrites wraps w so that writes to SystemPersona fail with
+// CheckSystemWrite's error, e.g. to run an Import on behalf of a client without
+// admin rights. Batches are checked as a whole before any record is written.
+func DenySystemWrites(w KVWriter) KVWriter {
+	return systemGuard{w}
+}
+
+type systemGuard struct {
+	KVWriter
+}
+
+func (g systemGuard) Set(personaID, appID, key string, val any) error {
+	if err := CheckSystemWrite(personaID); err != nil {
+		return err
+	}
+	return g.KVWriter.Set(personaID, appID, key, val)
+}
+
+func (g systemGuard) Delete(personaID, appID, key string) error {
+	if err := CheckSystemWrite(personaID); err != nil {
+		return err
+	}
+	return g.KVWriter.Delete(personaID, appID, key)
+}
+
+func (g systemGuard) SetBatch(records []Record) error {
+	for _, rec := range records {
+		if err := CheckSystemWrite(rec.PersonaID); err != nil {
+			return err
+		}
+	}
+	return WriteBatch(g.KVWriter, records)
+}
+
+// Wait lets Import wait for the wrapped store's background persistence.
+func (g systemGuard) Wait() {
+	if w, ok := g.KVWriter.(interface{ Wait() }); ok {
+		w.Wait()
+	}
+}
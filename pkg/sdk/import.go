@@ -0,0 +1,135 @@
+package sdk
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// DefaultImportBatchSize is the number of records Import applies between checkpoints.
+const DefaultImportBatchSize = 1000
+
+// maxImportLine bounds a single ndjson record.
+const maxImportLine = 16 << 20
+
+// Record is a single value addressed by persona, app and key, as used by the
+// ndjson import format (one JSON object per line).
+type Record struct {
+	PersonaID string `json:"persona_id"`
+	AppID     string `json:"app_id"`
+	Key       string `json:"key"`
+	Value     any    `json:"value"`
+}
+
+// BatchWriter writes many records at once, e.g. under a single lock with one
+// persistence pass per persona. It is optional: Import falls back to Set.
+type BatchWriter interface {
+	SetBatch(records []Record) error
+}
+
+// ImportOptions controls Import.
+type ImportOptions struct {
+	// BatchSize is the number of records applied between checkpoints (default DefaultImportBatchSize).
+	BatchSize int
+	// Skip ignores the first Skip records, to resume an import after the last checkpoint.
+	Skip int64
+	// Checkpoint, if set, is called after each batch has been applied (and, for
+	// stores that persist in the background, written out) with the total number
+	// of records read so far, including skipped ones.
+	Checkpoint func(records int64)
+	// PreciseNumbers decodes numbers in values as json.Number (see DecodeValue).
+	PreciseNumbers bool
+}
+
+// ImportResult summarizes an import.
+type ImportResult struct {
+	// Records is the number of records read and applied (or skipped). After a
+	// failed import it is the last checkpoint: the value to pass as Skip to resume.
+	Records int64 `json:"records"`
+	Applied int64 `json:"applied"`
+}
+
+// Import streams ndjson records from r into s. Records are decoded and applied
+// in batches, so memory use is bounded by the batch size rather than the input,
+// and reading pauses while a batch is written, which pushes back on the sender.
+func Import(s KVWriter, r io.Reader, opts ImportOptions) (ImportResult, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultImportBatchSize
+	}
+
+	var result ImportResult
+	batch := make([]Record, 0, opts.BatchSize)
+	// flush applies the pending batch, which ends with record number upTo.
+	flush := func(upTo int64) error {
+		if len(batch) == 0 {
+			result.Records = upTo
+			return nil
+		}
+		if err := WriteBatch(s, batch); err != nil {
+			return err
+		}
+		// Checkpoints promise durability, so wait for background persistence
+		if w, ok := s.(interface{ Wait() }); ok {
+			w.Wait()
+		}
+		result.Applied += int64(len(batch))
+		result.Records = upTo
+		batch = batch[:0]
+		if opts.Checkpoint != nil {
+			opts.Checkpoint(result.Records)
+		}
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLine)
+	var read int64
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		read++
+		if read <= opts.Skip {
+			result.Records = read
+			continue
+		}
+
+		var rec Record
+		if err := DecodeValue(line, &rec, opts.PreciseNumbers); err != nil {
+			return result, fmt.Errorf("record %d: %w", read, err)
+		}
+		if rec.PersonaID == "" || rec.AppID == "" || rec.Key == "" {
+			return result, fmt.Errorf("record %d: persona_id, app_id and key are required", read)
+		}
+		batch = append(batch, rec)
+
+		if len(batch) == opts.BatchSize {
+			if err := flush(read); err != nil {
+				return result, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+	if err := flush(read); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// WriteBatch writes records to s in one SetBatch call, or with a Set per
+// record for stores that aren't a BatchWriter.
+func WriteBatch(s KVWriter, batch []Record) error {
+	if bw, ok := s.(BatchWriter); ok {
+		return bw.SetBatch(batch)
+	}
+	for _, rec := range batch {
+		if err := s.Set(rec.PersonaID, rec.AppID, rec.Key, rec.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
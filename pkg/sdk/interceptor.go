@@ -0,0 +1,58 @@
+package sdk
+
+import (
+	"context"
+	"strings"
+)
+
+// Operation is a command a Client is about to send to the daemon, as an
+// Interceptor sees it.
+type Operation struct {
+	// Context is the context of the client's commands (see Client.WithContext),
+	// holding the command's span with WithTracer.
+	Context context.Context
+	// Name is the command word, such as "GET", "SET" or "DUMP_APP".
+	Name string
+	// Command is the full command line, values included, so log it with care.
+	Command string
+}
+
+// Invoker sends an operation on to the next interceptor or, from the last
+// one, to the daemon.
+type Invoker func(op Operation) error
+
+// Interceptor wraps every command a Client sends. It may inspect or time op,
+// call next any number of times (zero to fail the call without sending it,
+// more to retry) and change the error the caller gets.
+type Interceptor func(op Operation, next Invoker) error
+
+// WithInterceptor adds an interceptor around every command the client sends,
+// for tracing, metrics, logging or retries. Interceptors run in the order
+// they were added, the first one outermost, and once per command whichever
+// node answers it (see WithReadPreference). Keepalive PINGs, streams such as
+// Watch and calls answered without the daemon, like negative cache hits and
+// offline-queued writes, are not intercepted.
+func WithInterceptor(interceptor Interceptor) ClientOption {
+	return func(c *Client) {
+		c.interceptors = append(c.interceptors, interceptor)
+	}
+}
+
+// intercept sends cmd with send through the client's interceptors.
+func (c *Client) intercept(ctx context.Context, cmd string, send func(cmd string) (string, error)) (string, error) {
+	var resp string
+	invoke := func(op Operation) error {
+		var err error
+		resp, err = send(op.Command)
+		return err
+	}
+	for i := len(c.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := c.interceptors[i], invoke
+		invoke = func(op Operation) error { return interceptor(op, next) }
+	}
+	name, _, _ := strings.Cut(cmd, " ")
+	if err := invoke(Operation{Context: ctx, Name: name, Command: cmd}); err != nil {
+		return "", err
+	}
+	return resp, nil
+}
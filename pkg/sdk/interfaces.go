@@ -1,6 +1,9 @@
 package sdk
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 var (
 	// ErrPersonaNotFound is returned when a requested persona does not exist.
@@ -9,8 +12,31 @@ var (
 	ErrAppNotFound = errors.New("app not found")
 	// ErrKeyNotFound is returned when a requested key does not exist within an app.
 	ErrKeyNotFound = errors.New("key not found")
+	// ErrPersonaQuarantined is returned when writing to a persona whose stored data is corrupt.
+	ErrPersonaQuarantined = errors.New("persona quarantined")
+	// ErrMemoryLimit is returned when a write would take the store past its memory limit.
+	ErrMemoryLimit = errors.New("memory limit reached")
+	// ErrStorageFailure is returned for writes refused because the store can't
+	// save to its storage backend, e.g. because the disk is full.
+	ErrStorageFailure = errors.New("storage failing")
+	// ErrServerBusy is returned when the daemon is at its connection limit.
+	ErrServerBusy = errors.New("server busy")
 )
 
+// IsNotFound reports whether err means the requested persona, app or key doesn't exist.
+// It works for both embedded and remote stores, whose errors don't share sentinel values.
+func IsNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, target := range []error{ErrPersonaNotFound, ErrAppNotFound, ErrKeyNotFound} {
+		if errors.Is(err, target) || strings.HasSuffix(err.Error(), target.Error()) {
+			return true
+		}
+	}
+	return false
+}
+
 // SystemPersona is the reserved ID for global/system-level data.
 const SystemPersona = "_system"
 
@@ -49,8 +75,27 @@ type Orchestrator interface {
 	Move(srcPersona, dstPersona, appID, key string) error
 }
 
+// KeyMover moves values between apps and renames keys, besides moving them
+// between personas like Orchestrator. It is optional: callers should
+// type-assert a CelerixStore to check for support.
+type KeyMover interface {
+	MoveKey(srcPersona, srcApp, dstPersona, dstApp, srcKey, dstKey string) error
+}
+
+// StatsReporter exposes runtime statistics such as write contention per namespace.
+// It is optional: callers should type-assert a CelerixStore to check for support.
+type StatsReporter interface {
+	Stats() (Stats, error)
+}
+
 // --- Composite Interfaces ---
 
+// KVStore combines basic reads and writes; it is all the SDK helpers need.
+type KVStore interface {
+	KVReader
+	KVWriter
+}
+
 // CelerixStore is the primary interface for interacting with the data store.
 // It combines all functional interfaces for a complete storage experience.
 type CelerixStore interface {
@@ -71,11 +116,18 @@ type AppScope interface {
 	Set(key string, val any) error
 	Delete(key string) error
 	// Vault returns a VaultScope for client-side encrypted storage.
-	Vault(masterKey []byte) any
+	//
+	// It used to return any. Callers that still type-assert the result keep
+	// working; implementations must change their return type to VaultScope.
+	Vault(masterKey []byte) VaultScope
 }
 
 // VaultScope provides a scoped interface for performing client-side encryption.
 type VaultScope interface {
 	Get(key string) (string, error)
 	Set(key string, plaintext string) error
+	// SetJSON encrypts the JSON encoding of val.
+	SetJSON(key string, val any) error
+	// GetJSON decrypts a value stored with SetJSON into out.
+	GetJSON(key string, out any) error
 }
@@ -0,0 +1,124 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrLockHeld is returned by Lock while another holder's lease is live.
+	ErrLockHeld = NewProtocolError(CodeConflict, "lock held")
+	// ErrLeaseLost is returned by Renew and Unlock for a lease that was
+	// released, or that expired and was taken by another holder.
+	ErrLeaseLost = NewProtocolError(CodeConflict, "lease lost")
+)
+
+// Lease is a held lock. The Token proves ownership to Renew and Unlock; the
+// lock is free again once ExpiresAt passes without a renewal.
+type Lease struct {
+	PersonaID string    `json:"persona_id"`
+	AppID     string    `json:"app_id"`
+	Name      string    `json:"name"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Locker is implemented by stores that can hand out named, expiring locks
+// (leases), e.g. to elect a single worker for a job. Locks are scoped to a
+// persona and app like keys, but are kept apart from the values. It is
+// optional: callers should type-assert a CelerixStore to check for support.
+type Locker interface {
+	// Lock acquires the lock for ttl, or returns ErrLockHeld.
+	Lock(personaID, appID, name string, ttl time.Duration) (Lease, error)
+	// Renew extends a lease to ttl from now, or returns ErrLeaseLost.
+	Renew(lease Lease, ttl time.Duration) (Lease, error)
+	// Unlock releases a lease, or returns ErrLeaseLost.
+	Unlock(lease Lease) error
+}
+
+// Lock acquires a named lock on the daemon for ttl.
+func (c *Client) Lock(personaID, appID, name string, ttl time.Duration) (Lease, error) {
+	if err := ValidateIDs(personaID, appID, name); err != nil {
+		return Lease{}, err
+	}
+	return c.leaseCommand(fmt.Sprintf("LOCK %s %s %s %d", personaID, appID, name, ttl.Milliseconds()))
+}
+
+// Renew extends a lease to ttl from now.
+func (c *Client) Renew(lease Lease, ttl time.Duration) (Lease, error) {
+	return c.leaseCommand(fmt.Sprintf("RENEW %s %s %s %s %d", lease.PersonaID, lease.AppID, lease.Name, lease.Token, ttl.Milliseconds()))
+}
+
+// Unlock releases a lease.
+func (c *Client) Unlock(lease Lease) error {
+	_, err := c.sendAndReceive(fmt.Sprintf("UNLOCK %s %s %s %s", lease.PersonaID, lease.AppID, lease.Name, lease.Token))
+	return err
+}
+
+func (c *Client) leaseCommand(command string) (Lease, error) {
+	resp, err := c.sendAndReceive(command)
+	if err != nil {
+		return Lease{}, err
+	}
+	var lease Lease
+	err = json.Unmarshal([]byte(strings.TrimPrefix(resp, "OK ")), &lease)
+	return lease, err
+}
+
+// lockRetryInterval bounds how long AcquireLock sleeps between attempts.
+const lockRetryInterval = time.Second
+
+// AcquireLock waits until the lock is free or ctx is done.
+func AcquireLock(ctx context.Context, l Locker, personaID, appID, name string, ttl time.Duration) (Lease, error) {
+	wait := 50 * time.Millisecond
+	for {
+		lease, err := l.Lock(personaID, appID, name, ttl)
+		if !errors.Is(err, ErrLockHeld) {
+			return lease, err
+		}
+		select {
+		case <-ctx.Done():
+			return Lease{}, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, lockRetryInterval)
+	}
+}
+
+// KeepLease renews a lease every third of ttl until ctx is done, then releases
+// it. The returned channel receives the error if a renewal fails, which means
+// the lock may now be held by someone else, and is closed when KeepLease stops.
+//
+//	lease, err := sdk.AcquireLock(ctx, locker, "jobs", "billing", "nightly", 30*time.Second)
+//	lost := sdk.KeepLease(ctx, locker, lease, 30*time.Second)
+//	select {
+//	case <-done: // finished the job; cancel ctx to release
+//	case err := <-lost: // stop working, someone else may have the lock
+//	}
+func KeepLease(ctx context.Context, l Locker, lease Lease, ttl time.Duration) <-chan error {
+	lost := make(chan error, 1)
+	go func() {
+		defer close(lost)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				l.Unlock(lease)
+				return
+			case <-ticker.C:
+				renewed, err := l.Renew(lease, ttl)
+				if err != nil {
+					lost <- err
+					return
+				}
+				lease = renewed
+			}
+		}
+	}()
+	return lost
+}
@@ -0,0 +1,53 @@
+package sdk
+
+import "encoding/json"
+
+// Merger applies JSON merge patches (RFC 7396) to stored values atomically,
+// replacing racy read-modify-write cycles for settings-style documents.
+type Merger interface {
+	// Merge deep-merges patch into the value at key and returns the result.
+	// A missing key is treated as an empty object.
+	Merge(personaID, appID, key string, patch any) (any, error)
+}
+
+// MergePatch applies an RFC 7396 merge patch to target and returns the result.
+// Objects are merged recursively, null members delete the corresponding field,
+// and any non-object patch replaces the target outright. target is never modified.
+func MergePatch(target, patch any) any {
+	p, ok := normalizeJSON(patch).(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	t, _ := normalizeJSON(target).(map[string]any)
+	result := make(map[string]any, len(t)+len(p))
+	for k, v := range t {
+		result[k] = v
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(result, k)
+			continue
+		}
+		result[k] = MergePatch(result[k], v)
+	}
+	return result
+}
+
+// normalizeJSON converts structs and typed maps (as stored in embedded mode)
+// into their generic JSON form so they can be merged field by field.
+func normalizeJSON(val any) any {
+	switch val.(type) {
+	case nil, map[string]any, []any, string, bool, float64, json.Number:
+		return val
+	}
+	bytes, err := json.Marshal(val)
+	if err != nil {
+		return val
+	}
+	var generic any
+	if err := json.Unmarshal(bytes, &generic); err != nil {
+		return val
+	}
+	return generic
+}
@@ -0,0 +1,144 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/schema"
+)
+
+// MetaApp is the app of every persona holding the metadata of the persona
+// (under PersonaMetaKey) and of its apps (under their app IDs). Being an
+// ordinary app, it goes wherever the persona goes: it is exported, merged and
+// deleted with it, and writing it needs no admin rights.
+const (
+	MetaApp        = "_meta"
+	PersonaMetaKey = "_persona"
+)
+
+// Described is a persona or app ID with its metadata, if it has any, as
+// returned by the verbose listings.
+type Described struct {
+	ID   string           `json:"id"`
+	Meta *schema.Metadata `json:"meta,omitempty"`
+}
+
+// SetPersonaMeta attaches metadata to a persona, replacing what it had except
+// for the creation time.
+func SetPersonaMeta(s KVStore, personaID string, meta schema.Metadata) error {
+	return setMeta(s, personaID, PersonaMetaKey, meta)
+}
+
+// GetPersonaMeta returns a persona's metadata, or a not-found error if it has
+// none.
+func GetPersonaMeta(s KVReader, personaID string) (schema.Metadata, error) {
+	return Get[schema.Metadata](s, personaID, MetaApp, PersonaMetaKey)
+}
+
+// SetAppMeta attaches metadata to one of a persona's apps, replacing what it
+// had except for the creation time.
+func SetAppMeta(s KVStore, personaID, appID string, meta schema.Metadata) error {
+	if appID == MetaApp || appID == PersonaMetaKey {
+		return fmt.Errorf("app ID %q is reserved for metadata: %w", appID, ErrBadRequest)
+	}
+	return setMeta(s, personaID, appID, meta)
+}
+
+// GetAppMeta returns an app's metadata, or a not-found error if it has none.
+func GetAppMeta(s KVReader, personaID, appID string) (schema.Metadata, error) {
+	return Get[schema.Metadata](s, personaID, MetaApp, appID)
+}
+
+func setMeta(s KVStore, personaID, key string, meta schema.Metadata) error {
+	old, err := Get[schema.Metadata](s, personaID, MetaApp, key)
+	switch {
+	case err == nil:
+		meta.CreatedAt = old.CreatedAt
+	case IsNotFound(err):
+		if meta.CreatedAt.IsZero() {
+			meta.CreatedAt = time.Now().UTC()
+		}
+	default:
+		return err
+	}
+	return s.Set(personaID, MetaApp, key, meta)
+}
+
+// DescribePersonas looks up the metadata of personaIDs, in order, with one
+// read per persona.
+func DescribePersonas(s KVReader, personaIDs []string) ([]Described, error) {
+	list := make([]Described, 0, len(personaIDs))
+	for _, personaID := range personaIDs {
+		d := Described{ID: personaID}
+		meta, err := GetPersonaMeta(s, personaID)
+		if err == nil {
+			d.Meta = &meta
+		} else if !IsNotFound(err) {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, nil
+}
+
+// DescribeApps looks up the metadata of a persona's appIDs, in order, with a
+// single MGet. MetaApp itself is left out.
+func DescribeApps(s KVReader, personaID string, appIDs []string) ([]Described, error) {
+	ids := make([]string, 0, len(appIDs))
+	for _, appID := range appIDs {
+		if appID != MetaApp {
+			ids = append(ids, appID)
+		}
+	}
+	list := make([]Described, 0, len(ids))
+	if len(ids) == 0 {
+		return list, nil
+	}
+	values, err := MGet(s, personaID, MetaApp, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, appID := range ids {
+		d := Described{ID: appID}
+		if val, ok := values[appID]; ok {
+			meta, ok := val.(schema.Metadata)
+			if !ok {
+				if err := remarshal(val, &meta); err != nil {
+					return nil, err
+				}
+			}
+			d.Meta = &meta
+		}
+		list = append(list, d)
+	}
+	return list, nil
+}
+
+// GetPersonasVerbose lists the personas with their metadata, with
+// LIST_PERSONAS VERBOSE.
+func (c *Client) GetPersonasVerbose() ([]Described, error) {
+	return c.listVerbose("LIST_PERSONAS VERBOSE")
+}
+
+// GetAppsVerbose lists a persona's apps with their metadata, with LIST_APPS
+// VERBOSE. MetaApp is left out.
+func (c *Client) GetAppsVerbose(personaID string) ([]Described, error) {
+	if err := ValidateID("persona ID", personaID); err != nil {
+		return nil, err
+	}
+	return c.listVerbose(fmt.Sprintf("LIST_APPS %s VERBOSE", personaID))
+}
+
+func (c *Client) listVerbose(cmd string) ([]Described, error) {
+	resp, err := c.sendAndReceive(cmd)
+	if err != nil {
+		return nil, err
+	}
+	var list []Described
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(resp, "OK ")), &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
@@ -0,0 +1,426 @@
+package sdk
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Member failures before it is skipped, and how long it is skipped for.
+const (
+	multiFailureThreshold = 3
+	multiRetryAfter       = 30 * time.Second
+)
+
+// ErrNoHealthyStore is returned by MultiStore when every member is unhealthy.
+var ErrNoHealthyStore = errors.New("no healthy store available")
+
+// Member is one store behind a MultiStore, e.g. the daemon for a region.
+type Member struct {
+	Name  string
+	Store CelerixStore
+}
+
+// MemberHealth is the health MultiStore tracks for a member. After repeated
+// failures a member is skipped for a while, then tried again.
+type MemberHealth struct {
+	Name             string    `json:"name"`
+	Healthy          bool      `json:"healthy"`
+	ConsecutiveFails int       `json:"consecutive_fails"`
+	LastError        string    `json:"last_error,omitempty"`
+	LastFailure      time.Time `json:"last_failure,omitempty"`
+}
+
+// MultiStore aggregates several stores, such as one daemon per region, behind
+// the CelerixStore interface. Reads fan out to all healthy members in parallel
+// and results are merged; where members disagree, the earlier member wins.
+// Writes go to the member chosen by the write router (the first member by default).
+type MultiStore struct {
+	members []Member
+	route   func(personaID string) string
+
+	mu     sync.Mutex
+	health []MemberHealth
+}
+
+// NewMultiStore aggregates members in order of preference.
+func NewMultiStore(members ...Member) *MultiStore {
+	m := &MultiStore{members: members, health: make([]MemberHealth, len(members))}
+	for i, member := range members {
+		m.health[i] = MemberHealth{Name: member.Name, Healthy: true}
+	}
+	return m
+}
+
+// SetWriteRouter decides which member (by name) owns a persona's writes, e.g.
+// by looking up the persona's home region. Unknown names fall back to the first member.
+func (m *MultiStore) SetWriteRouter(route func(personaID string) string) {
+	m.route = route
+}
+
+// MemberHealth reports the health of every member, in member order.
+func (m *MultiStore) MemberHealth() []MemberHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]MemberHealth(nil), m.health...)
+}
+
+// available reports whether member i should be asked. Unhealthy members are
+// retried once multiRetryAfter has passed since their last failure.
+func (m *MultiStore) available(i int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.health[i]
+	return h.Healthy || time.Since(h.LastFailure) > multiRetryAfter
+}
+
+// record updates member health. "Not found" is an answer, not a failure.
+func (m *MultiStore) record(i int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := &m.health[i]
+	if err == nil || IsNotFound(err) {
+		h.Healthy, h.ConsecutiveFails = true, 0
+		return
+	}
+	h.ConsecutiveFails++
+	h.LastError = err.Error()
+	h.LastFailure = time.Now()
+	if h.ConsecutiveFails >= multiFailureThreshold {
+		h.Healthy = false
+	}
+}
+
+type memberResult[T any] struct {
+	val T
+	err error
+	ok  bool // false if the member was skipped
+}
+
+// fanOut calls fn on every available member in parallel and returns the
+// results in member order.
+func fanOut[T any](m *MultiStore, fn func(CelerixStore) (T, error)) []memberResult[T] {
+	results := make([]memberResult[T], len(m.members))
+	var wg sync.WaitGroup
+	for i, member := range m.members {
+		if !m.available(i) {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, s CelerixStore) {
+			defer wg.Done()
+			val, err := fn(s)
+			m.record(i, err)
+			results[i] = memberResult[T]{val: val, err: err, ok: true}
+		}(i, member.Store)
+	}
+	wg.Wait()
+	return results
+}
+
+// firstError picks the error to report when no member produced a result:
+// a "not found" if any member answered, otherwise the first failure.
+func firstError[T any](results []memberResult[T]) error {
+	var failure error
+	for _, r := range results {
+		if !r.ok {
+			continue
+		}
+		if IsNotFound(r.err) {
+			return r.err
+		}
+		if failure == nil {
+			failure = r.err
+		}
+	}
+	if failure == nil {
+		return ErrNoHealthyStore
+	}
+	return failure
+}
+
+// writer returns the member owning personaID's writes.
+func (m *MultiStore) writer(personaID string) (int, error) {
+	if len(m.members) == 0 {
+		return 0, ErrNoHealthyStore
+	}
+	if m.route != nil {
+		name := m.route(personaID)
+		for i, member := range m.members {
+			if member.Name == name {
+				return i, nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+// Get returns the value from the first member (in order) that has the key.
+func (m *MultiStore) Get(personaID, appID, key string) (any, error) {
+	results := fanOut(m, func(s CelerixStore) (any, error) { return s.Get(personaID, appID, key) })
+	for _, r := range results {
+		if r.ok && r.err == nil {
+			return r.val, nil
+		}
+	}
+	return nil, firstError(results)
+}
+
+// Set writes to the member owning the persona.
+func (m *MultiStore) Set(personaID, appID, key string, val any) error {
+	i, err := m.writer(personaID)
+	if err != nil {
+		return err
+	}
+	err = m.members[i].Store.Set(personaID, appID, key, val)
+	m.record(i, err)
+	return err
+}
+
+// SetSync writes durably to the member owning the persona.
+func (m *MultiStore) SetSync(personaID, appID, key string, val any) error {
+	i, err := m.writer(personaID)
+	if err != nil {
+		return err
+	}
+	writer, ok := m.members[i].Store.(SyncWriter)
+	if !ok {
+		return fmt.Errorf("durable writes on %s: %w", m.members[i].Name, ErrNotSupported)
+	}
+	err = writer.SetSync(personaID, appID, key, val)
+	m.record(i, err)
+	return err
+}
+
+// Delete removes the key from the member owning the persona.
+func (m *MultiStore) Delete(personaID, appID, key string) error {
+	i, err := m.writer(personaID)
+	if err != nil {
+		return err
+	}
+	err = m.members[i].Store.Delete(personaID, appID, key)
+	m.record(i, err)
+	return err
+}
+
+// GetPersonas returns the union of every member's personas.
+func (m *MultiStore) GetPersonas() ([]string, error) {
+	return mergeLists(fanOut(m, func(s CelerixStore) ([]string, error) { return s.GetPersonas() }))
+}
+
+// GetApps returns the union of the persona's apps across members.
+func (m *MultiStore) GetApps(personaID string) ([]string, error) {
+	return mergeLists(fanOut(m, func(s CelerixStore) ([]string, error) { return s.GetApps(personaID) }))
+}
+
+// GetAppStore merges the app's keys across members.
+func (m *MultiStore) GetAppStore(personaID, appID string) (map[string]any, error) {
+	results := fanOut(m, func(s CelerixStore) (map[string]any, error) { return s.GetAppStore(personaID, appID) })
+	var merged map[string]any
+	for i := len(results) - 1; i >= 0; i-- { // Later members first so earlier ones win
+		if r := results[i]; r.ok && r.err == nil {
+			if merged == nil {
+				merged = make(map[string]any)
+			}
+			for k, v := range r.val {
+				merged[k] = v
+			}
+		}
+	}
+	if merged == nil {
+		return nil, firstError(results)
+	}
+	return merged, nil
+}
+
+// DumpApp merges the app's data for every persona across members.
+func (m *MultiStore) DumpApp(appID string) (map[string]map[string]any, error) {
+	results := fanOut(m, func(s CelerixStore) (map[string]map[string]any, error) { return s.DumpApp(appID) })
+	var merged map[string]map[string]any
+	for i := len(results) - 1; i >= 0; i-- {
+		if r := results[i]; r.ok && r.err == nil {
+			if merged == nil {
+				merged = make(map[string]map[string]any)
+			}
+			for personaID, data := range r.val {
+				if merged[personaID] == nil {
+					merged[personaID] = make(map[string]any)
+				}
+				for k, v := range data {
+					merged[personaID][k] = v
+				}
+			}
+		}
+	}
+	if merged == nil {
+		return nil, firstError(results)
+	}
+	return merged, nil
+}
+
+// GetGlobal finds the key in the first member (in order) that has it.
+func (m *MultiStore) GetGlobal(appID, key string) (any, string, error) {
+	type found struct {
+		val       any
+		personaID string
+	}
+	results := fanOut(m, func(s CelerixStore) (found, error) {
+		val, personaID, err := s.GetGlobal(appID, key)
+		return found{val, personaID}, err
+	})
+	for _, r := range results {
+		if r.ok && r.err == nil {
+			return r.val.val, r.val.personaID, nil
+		}
+	}
+	return nil, "", firstError(results)
+}
+
+// Move moves a key within the member owning both personas. When they live on
+// different members, the value is copied to the destination and then deleted
+// from the source, which is not atomic.
+func (m *MultiStore) Move(srcPersona, dstPersona, appID, key string) error {
+	src, err := m.writer(srcPersona)
+	if err != nil {
+		return err
+	}
+	dst, _ := m.writer(dstPersona)
+	if src == dst {
+		err := m.members[src].Store.Move(srcPersona, dstPersona, appID, key)
+		m.record(src, err)
+		return err
+	}
+
+	val, err := m.members[src].Store.Get(srcPersona, appID, key)
+	if err != nil {
+		return err
+	}
+	if err := m.members[dst].Store.Set(dstPersona, appID, key, val); err != nil {
+		return fmt.Errorf("copy to %s: %w", m.members[dst].Name, err)
+	}
+	return m.members[src].Store.Delete(srcPersona, appID, key)
+}
+
+// MoveKey moves a value within the member owning both personas, which must be
+// a KeyMover. Across members it is copied and deleted like Move.
+func (m *MultiStore) MoveKey(srcPersona, srcApp, dstPersona, dstApp, srcKey, dstKey string) error {
+	src, err := m.writer(srcPersona)
+	if err != nil {
+		return err
+	}
+	dst, _ := m.writer(dstPersona)
+	if src == dst {
+		mover, ok := m.members[src].Store.(KeyMover)
+		if !ok {
+			return fmt.Errorf("moving keys on %s: %w", m.members[src].Name, ErrNotSupported)
+		}
+		err := mover.MoveKey(srcPersona, srcApp, dstPersona, dstApp, srcKey, dstKey)
+		m.record(src, err)
+		return err
+	}
+
+	val, err := m.members[src].Store.Get(srcPersona, srcApp, srcKey)
+	if err != nil {
+		return err
+	}
+	if err := m.members[dst].Store.Set(dstPersona, dstApp, dstKey, val); err != nil {
+		return fmt.Errorf("copy to %s: %w", m.members[dst].Name, err)
+	}
+	return m.members[src].Store.Delete(srcPersona, srcApp, srcKey)
+}
+
+// MergePersona merges within the member owning both personas, which must be a
+// PersonaMerger. Personas on different members can't be merged.
+func (m *MultiStore) MergePersona(src, dst string, strategy MergeStrategy) error {
+	i, err := m.writer(src)
+	if err != nil {
+		return err
+	}
+	if j, _ := m.writer(dst); j != i {
+		return fmt.Errorf("merging personas across members: %w", ErrNotSupported)
+	}
+	merger, ok := m.members[i].Store.(PersonaMerger)
+	if !ok {
+		return fmt.Errorf("merging personas on %s: %w", m.members[i].Name, ErrNotSupported)
+	}
+	err = merger.MergePersona(src, dst, strategy)
+	m.record(i, err)
+	return err
+}
+
+// PurgePersona erases the persona from every member, wherever it ended up,
+// including members being skipped after failures. The report adds up what the
+// members erased. If some of them failed, the error names them and the purge
+// can be repeated.
+func (m *MultiStore) PurgePersona(personaID string) (PurgeReport, error) {
+	report := PurgeReport{PersonaID: personaID}
+	var errs []error
+	for i, member := range m.members {
+		r, err := PurgePersona(member.Store, personaID)
+		m.record(i, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("purge on %s: %w", member.Name, err))
+			continue
+		}
+		report.Apps += r.Apps
+		report.Keys += r.Keys
+		if r.PurgedAt.After(report.PurgedAt) {
+			report.PurgedAt = r.PurgedAt
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+func mergeLists(results []memberResult[[]string]) ([]string, error) {
+	seen := make(map[string]struct{})
+	answered := false
+	for _, r := range results {
+		if !r.ok || r.err != nil {
+			continue
+		}
+		answered = true
+		for _, v := range r.val {
+			seen[v] = struct{}{}
+		}
+	}
+	if !answered {
+		return nil, firstError(results)
+	}
+	list := make([]string, 0, len(seen))
+	for v := range seen {
+		list = append(list, v)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// --- Scoping Support ---
+
+// App returns a scope pinned to a persona and app.
+func (m *MultiStore) App(personaID, appID string) AppScope {
+	return &multiAppScope{store: m, personaID: personaID, appID: appID}
+}
+
+type multiAppScope struct {
+	store     KVStore // the MultiStore or Cluster
+	personaID string
+	appID     string
+}
+
+func (a *multiAppScope) Get(key string) (any, error) {
+	return a.store.Get(a.personaID, a.appID, key)
+}
+
+func (a *multiAppScope) Set(key string, val any) error {
+	return a.store.Set(a.personaID, a.appID, key, val)
+}
+
+func (a *multiAppScope) Delete(key string) error {
+	return a.store.Delete(a.personaID, a.appID, key)
+}
+
+func (a *multiAppScope) Vault(masterKey []byte) VaultScope {
+	return &scopedVault{app: a, masterKey: masterKey}
+}
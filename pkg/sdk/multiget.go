@@ -0,0 +1,112 @@
+package sdk
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MultiGetter answers existence checks and reads of several keys without a
+// round trip per key. It is optional: use Exists and MGet, which fall back to
+// Get.
+type MultiGetter interface {
+	// Exists reports whether key holds a value, without transferring it.
+	Exists(personaID, appID, key string) (bool, error)
+	// MGet returns the values of the keys that exist; missing keys, apps
+	// and personas are left out rather than being errors.
+	MGet(personaID, appID string, keys []string) (map[string]any, error)
+}
+
+// Exists reports whether key holds a value in s.
+func Exists(s KVReader, personaID, appID, key string) (bool, error) {
+	if mg, ok := s.(MultiGetter); ok {
+		return mg.Exists(personaID, appID, key)
+	}
+	_, err := s.Get(personaID, appID, key)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// MGet reads several keys of an app from s.
+func MGet(s KVReader, personaID, appID string, keys []string) (map[string]any, error) {
+	if mg, ok := s.(MultiGetter); ok {
+		return mg.MGet(personaID, appID, keys)
+	}
+	values := make(map[string]any, len(keys))
+	for _, key := range keys {
+		val, err := s.Get(personaID, appID, key)
+		if IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[key] = val
+	}
+	return values, nil
+}
+
+// Exists asks the daemon with EXISTS. Writes still in the offline queue are
+// taken into account.
+func (c *Client) Exists(personaID, appID, key string) (bool, error) {
+	if c.offline != nil {
+		if w, ok := c.offline.lookup(personaID, appID, key); ok {
+			return w.Op != OpDelete, nil
+		}
+	}
+	if err := ValidateIDs(personaID, appID, key); err != nil {
+		return false, err
+	}
+	if c.misses.missing(personaID, appID, key) {
+		return false, nil
+	}
+	resp, err := c.sendAndReceive(fmt.Sprintf("EXISTS %s %s %s", personaID, appID, key))
+	if err != nil {
+		if c.offline != nil && isUnreachable(err) {
+			return false, fmt.Errorf("%w: %v", ErrOffline, err)
+		}
+		return false, err
+	}
+	return strings.TrimPrefix(resp, "OK ") == "true", nil
+}
+
+// MGet reads several keys in one round trip with MGET. Writes still in the
+// offline queue are taken into account.
+func (c *Client) MGet(personaID, appID string, keys []string) (map[string]any, error) {
+	values := make(map[string]any, len(keys))
+	remote := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if err := ValidateIDs(personaID, appID, key); err != nil {
+			return nil, err
+		}
+		if c.offline != nil {
+			if w, ok := c.offline.lookup(personaID, appID, key); ok {
+				if w.Op != OpDelete {
+					values[key] = w.Value
+				}
+				continue
+			}
+		}
+		remote = append(remote, key)
+	}
+	if len(remote) == 0 {
+		return values, nil
+	}
+
+	resp, err := c.sendAndReceive(fmt.Sprintf("MGET %s %s %s", personaID, appID, strings.Join(remote, " ")))
+	if err != nil {
+		if c.offline != nil && isUnreachable(err) {
+			return nil, fmt.Errorf("%w: %v", ErrOffline, err)
+		}
+		return nil, err
+	}
+	var found map[string]any
+	if err := c.decodeValue(strings.TrimPrefix(resp, "OK "), &found); err != nil {
+		return nil, err
+	}
+	for key, val := range found {
+		values[key] = val
+	}
+	return values, nil
+}
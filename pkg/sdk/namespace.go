@@ -0,0 +1,151 @@
+package sdk
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// DefaultNamespace is the namespace connections start in: the daemon's main
+// store, which needs no token.
+const DefaultNamespace = "default"
+
+// ValidateNamespace checks that a namespace name is 1 to 64 lowercase letters,
+// digits, '-' or '_'.
+func ValidateNamespace(name string) error {
+	if name == "" || len(name) > 64 {
+		return fmt.Errorf("invalid namespace %q: must be 1 to 64 characters", name)
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+			return fmt.Errorf("invalid namespace %q: only a-z, 0-9, '-' and '_' are allowed", name)
+		}
+	}
+	return nil
+}
+
+// WithNamespace makes the client work in a namespace of the daemon, such as
+// "staging", instead of its main store. Namespaces are isolated from each
+// other; the daemon decides which exist (see CELERIX_NAMESPACES).
+func WithNamespace(name string) ClientOption {
+	return func(c *Client) {
+		c.namespace = name
+	}
+}
+
+// WithToken sets the token the namespace selected with WithNamespace requires.
+func WithToken(token string) ClientOption {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithAdminToken claims admin rights on the daemon, needed to write to the
+// _system persona when the daemon sets CELERIX_ADMIN_TOKEN.
+func WithAdminToken(token string) ClientOption {
+	return func(c *Client) {
+		c.adminToken = token
+	}
+}
+
+// WithAuthToken authenticates the client's connections with an access token
+// from a TokenStore, for daemons that require authentication. Use
+// SetAuthToken to switch to a refreshed token.
+func WithAuthToken(token string) ClientOption {
+	return func(c *Client) {
+		c.authToken.Store(token)
+	}
+}
+
+// SetAuthToken authenticates the current connection with a new access token,
+// e.g. after a refresh, and uses it for connections opened later.
+func (c *Client) SetAuthToken(token string) error {
+	if _, err := c.sendAndReceive("AUTH " + token); err != nil {
+		return err
+	}
+	c.authToken.Store(token)
+	return nil
+}
+
+func (c *Client) currentAuthToken() string {
+	token, _ := c.authToken.Load().(string)
+	return token
+}
+
+// Namespace connects a new client to another namespace of the same daemon,
+// with the same options as c plus opts (typically WithToken). Offline mode is
+// not inherited, since its queue belongs to c. Close it when done.
+func (c *Client) Namespace(name string, opts ...ClientOption) (*Client, error) {
+	if err := ValidateNamespace(name); err != nil {
+		return nil, err
+	}
+	all := append(append([]ClientOption{}, c.opts...), WithNamespace(name), WithToken(""))
+	all = append(all, opts...)
+	all = append(all, func(nc *Client) { nc.offlineOpts = nil })
+	return Connect(c.addr, all...)
+}
+
+// setupConn claims admin rights, authenticates and selects the client's
+// namespace on a fresh connection, as configured. Namespaces come last, since
+// a daemon requiring authentication refuses NAMESPACE before it.
+func (c *Client) setupConn(conn net.Conn, reader *bufio.Reader, protocol int) error {
+	authToken := c.currentAuthToken()
+	if c.namespace == "" && c.adminToken == "" && authToken == "" {
+		return nil
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+
+	if c.adminToken != "" {
+		if err := setupCommand(conn, reader, protocol, "ADMIN "+c.adminToken); err != nil {
+			return fmt.Errorf("admin: %w", err)
+		}
+	}
+	if authToken != "" {
+		if err := setupCommand(conn, reader, protocol, "AUTH "+authToken); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+	if c.namespace != "" {
+		cmd := "NAMESPACE " + c.namespace
+		if c.token != "" {
+			cmd += " " + c.token
+		}
+		if err := setupCommand(conn, reader, protocol, cmd); err != nil {
+			return fmt.Errorf("namespace %s: %w", c.namespace, err)
+		}
+	}
+	return nil
+}
+
+func setupCommand(conn net.Conn, reader *bufio.Reader, protocol int, cmd string) error {
+	if _, err := fmt.Fprint(conn, cmd+"\n"); err != nil {
+		return err
+	}
+	resp, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if resp = strings.TrimSpace(resp); strings.HasPrefix(resp, "ERR") {
+		return ParseError(strings.TrimPrefix(resp, "ERR "), protocol)
+	}
+	return nil
+}
+
+// dialStream opens a dedicated connection for a streaming command, set up
+// like the main one. Stream connections skip HELLO, so they speak protocol
+// version 1.
+func (c *Client) dialStream() (net.Conn, *bufio.Reader, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	if err := c.setupConn(conn, reader, 1); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, reader, nil
+}
@@ -0,0 +1,82 @@
+package sdk
+
+import (
+	"sync"
+	"time"
+)
+
+// maxNegativeEntries bounds the negative cache; it is cleared when full.
+const maxNegativeEntries = 10000
+
+type missKey struct {
+	personaID string
+	appID     string
+	key       string
+}
+
+// negativeCache remembers keys recently reported as missing by the server.
+type negativeCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[missKey]time.Time
+}
+
+func newNegativeCache(ttl time.Duration) *negativeCache {
+	return &negativeCache{ttl: ttl, entries: make(map[missKey]time.Time)}
+}
+
+func (n *negativeCache) missing(personaID, appID, key string) bool {
+	if n == nil {
+		return false
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	k := missKey{personaID, appID, key}
+	expires, ok := n.entries[k]
+	if !ok {
+		return false
+	}
+	if time.Now().After(expires) {
+		delete(n.entries, k)
+		return false
+	}
+	return true
+}
+
+func (n *negativeCache) remember(personaID, appID, key string) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if len(n.entries) >= maxNegativeEntries {
+		n.entries = make(map[missKey]time.Time)
+	}
+	n.entries[missKey{personaID, appID, key}] = time.Now().Add(n.ttl)
+}
+
+func (n *negativeCache) forget(personaID, appID, key string) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.entries, missKey{personaID, appID, key})
+}
+
+// forgetPersona drops every entry of a persona, after a write that may have
+// filled in any of its keys.
+func (n *negativeCache) forgetPersona(personaID string) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for k := range n.entries {
+		if k.personaID == personaID {
+			delete(n.entries, k)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// DecodeValue parses JSON into v as values are read back from the store. With
+// preciseNumbers, numbers decode to json.Number instead of float64, so
+// integers beyond 2^53 such as int64 IDs keep every digit.
+func DecodeValue(data []byte, v any, preciseNumbers bool) error {
+	if !preciseNumbers {
+		return json.Unmarshal(data, v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("invalid JSON: unexpected data after the value")
+	}
+	return nil
+}
+
+// decodeValue parses a reply holding stored values (see WithPreciseNumbers).
+func (c *Client) decodeValue(data string, v any) error {
+	return DecodeValue([]byte(data), v, c.preciseNumbers)
+}
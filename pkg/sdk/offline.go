@@ -0,0 +1,319 @@
+package sdk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrOffline is returned by reads that need the daemon while it is unreachable
+// and the value isn't in the offline queue.
+var ErrOffline = errors.New("daemon unreachable")
+
+// DefaultOfflineRetry is how often queued writes are retried by default.
+const DefaultOfflineRetry = 5 * time.Second
+
+// Queued writes are kept in OfflineOptions.Store under this persona and app.
+const (
+	offlinePersona = "queue"
+	offlineApp     = "writes"
+)
+
+// OfflineWrite is a Set or Delete buffered while the daemon was unreachable.
+type OfflineWrite struct {
+	Seq       uint64    `json:"seq"`
+	Op        string    `json:"op"` // OpSet or OpDelete
+	PersonaID string    `json:"persona_id"`
+	AppID     string    `json:"app_id"`
+	Key       string    `json:"key"`
+	Value     any       `json:"value,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// ConflictFunc decides how a queued write is replayed. current is the daemon's
+// value for the key at replay time (exists is false if it has none). Return the
+// write to apply, which may be w itself or a modified copy such as a merge of
+// both values, or nil to discard it.
+type ConflictFunc func(w OfflineWrite, current any, exists bool) *OfflineWrite
+
+// OfflineOptions configures offline mode, see WithOfflineQueue.
+type OfflineOptions struct {
+	// Store keeps the queue of writes not yet sent, typically an embedded store
+	// from engine.Open so they survive restarts of the application. The client
+	// takes ownership of it and closes it in Close.
+	Store CelerixStore
+	// RetryInterval is how often replaying queued writes is attempted
+	// (default DefaultOfflineRetry).
+	RetryInterval time.Duration
+	// OnConflict, if set, is consulted for every queued write before it is
+	// replayed. Without it the queued write simply wins.
+	OnConflict ConflictFunc
+}
+
+// SyncStatus reports the state of offline mode.
+type SyncStatus struct {
+	Online bool `json:"online"`
+	// Pending counts queued writes not yet replayed.
+	Pending int `json:"pending"`
+	// LastSync is when the queue was last emptied.
+	LastSync time.Time `json:"last_sync,omitempty"`
+	// LastError is the error that last sent writes to the queue or stopped a replay.
+	LastError string `json:"last_error,omitempty"`
+	// Discarded counts queued writes the daemon rejected on replay.
+	Discarded int `json:"discarded"`
+}
+
+// offlineQueue holds writes made while the daemon was unreachable, in order.
+type offlineQueue struct {
+	opts  OfflineOptions
+	local CelerixStore
+
+	flushMu sync.Mutex // One replay at a time
+
+	mu      sync.Mutex
+	seq     uint64
+	entries []OfflineWrite
+	latest  map[missKey]OfflineWrite // Newest queued write per key, for reads
+	status  SyncStatus
+}
+
+func openOfflineQueue(opts OfflineOptions) (*offlineQueue, error) {
+	if opts.Store == nil {
+		return nil, errors.New("offline mode needs a store for the queue")
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = DefaultOfflineRetry
+	}
+	queued, err := opts.Store.GetAppStore(offlinePersona, offlineApp)
+	if err != nil && !IsNotFound(err) {
+		return nil, err
+	}
+
+	q := &offlineQueue{
+		opts:   opts,
+		local:  opts.Store,
+		latest: make(map[missKey]OfflineWrite),
+		status: SyncStatus{Online: true},
+	}
+	for _, raw := range queued {
+		var w OfflineWrite
+		if err := remarshal(raw, &w); err != nil {
+			return nil, fmt.Errorf("corrupt offline queue entry: %w", err)
+		}
+		q.entries = append(q.entries, w)
+	}
+	sort.Slice(q.entries, func(i, j int) bool { return q.entries[i].Seq < q.entries[j].Seq })
+	for _, w := range q.entries {
+		q.latest[missKey{w.PersonaID, w.AppID, w.Key}] = w
+		q.seq = w.Seq
+	}
+	q.status.Pending = len(q.entries)
+	return q, nil
+}
+
+// remarshal converts a decoded JSON value into out.
+func remarshal(in, out any) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func entryKey(seq uint64) string {
+	return fmt.Sprintf("%020d", seq)
+}
+
+func (q *offlineQueue) pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+func (q *offlineQueue) enqueue(w OfflineWrite) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// Keep what was queued identical to what a reload from disk returns.
+	if w.Value != nil {
+		if err := remarshal(w.Value, &w.Value); err != nil {
+			return err
+		}
+	}
+	q.seq++
+	w.Seq, w.Time = q.seq, time.Now().UTC()
+	if err := q.local.Set(offlinePersona, offlineApp, entryKey(w.Seq), w); err != nil {
+		return fmt.Errorf("queue offline write: %w", err)
+	}
+	q.entries = append(q.entries, w)
+	q.latest[missKey{w.PersonaID, w.AppID, w.Key}] = w
+	q.status.Pending = len(q.entries)
+	return nil
+}
+
+// lookup returns the newest queued write for a key.
+func (q *offlineQueue) lookup(personaID, appID, key string) (OfflineWrite, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	w, ok := q.latest[missKey{personaID, appID, key}]
+	return w, ok
+}
+
+func (q *offlineQueue) head() (OfflineWrite, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.entries) == 0 {
+		return OfflineWrite{}, false
+	}
+	return q.entries[0], true
+}
+
+// pop removes the head of the queue once it has been replayed.
+func (q *offlineQueue) pop(discarded bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	w := q.entries[0]
+	if err := q.local.Delete(offlinePersona, offlineApp, entryKey(w.Seq)); err != nil && !IsNotFound(err) {
+		return err
+	}
+	q.entries = q.entries[1:]
+	k := missKey{w.PersonaID, w.AppID, w.Key}
+	if q.latest[k].Seq == w.Seq {
+		delete(q.latest, k)
+	}
+	q.status.Pending = len(q.entries)
+	if discarded {
+		q.status.Discarded++
+	}
+	if len(q.entries) == 0 {
+		q.status.LastSync = time.Now().UTC()
+	}
+	return nil
+}
+
+func (q *offlineQueue) setOnline(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.status.Online = err == nil
+	if err != nil {
+		q.status.LastError = err.Error()
+	}
+}
+
+func (q *offlineQueue) close() error {
+	if closer, ok := q.local.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// isUnreachable reports whether err means the daemon couldn't be reached, as
+// opposed to the daemon answering with an error.
+func isUnreachable(err error) bool {
+	var perr *ProtocolError
+	return err != nil && !errors.As(err, &perr)
+}
+
+// write sends a Set or Delete, or queues it in offline mode when the daemon is
+// unreachable or earlier writes are still queued, so that order is kept.
+func (c *Client) write(w OfflineWrite, send func() error) error {
+	q := c.offline
+	if q == nil {
+		return send()
+	}
+	if q.pending() == 0 {
+		err := send()
+		if !isUnreachable(err) {
+			return err
+		}
+		q.setOnline(err)
+		c.logger.Warn("[Celerix SDK] Daemon unreachable, queueing writes", "error", err)
+	}
+	return q.enqueue(w)
+}
+
+// Flush replays queued writes in order. It stops at the first write the
+// daemon can't be reached for, returning an error wrapping ErrOffline; writes
+// the daemon rejects are logged and discarded. Without offline mode it is a no-op.
+func (c *Client) Flush() error {
+	q := c.offline
+	if q == nil {
+		return nil
+	}
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+
+	for {
+		w, ok := q.head()
+		if !ok {
+			q.setOnline(nil)
+			return nil
+		}
+
+		apply := &w
+		if q.opts.OnConflict != nil {
+			current, err := c.getRemote(w.PersonaID, w.AppID, w.Key)
+			if isUnreachable(err) {
+				q.setOnline(err)
+				return fmt.Errorf("%w: %v", ErrOffline, err)
+			}
+			apply = q.opts.OnConflict(w, current, err == nil)
+		}
+
+		discarded := false
+		if apply != nil {
+			var err error
+			if apply.Op == OpDelete {
+				err = c.deleteRemote(apply.PersonaID, apply.AppID, apply.Key)
+			} else {
+				err = c.setRemote(apply.PersonaID, apply.AppID, apply.Key, apply.Value)
+			}
+			if isUnreachable(err) {
+				q.setOnline(err)
+				return fmt.Errorf("%w: %v", ErrOffline, err)
+			}
+			if err != nil {
+				c.logger.Warn("[Celerix SDK] Discarding queued write rejected by the daemon",
+					"op", apply.Op, "persona", apply.PersonaID, "app", apply.AppID, "key", apply.Key, "error", err)
+				discarded = true
+			}
+		}
+		if err := q.pop(discarded); err != nil {
+			return err
+		}
+	}
+}
+
+// SyncStatus reports whether the daemon is reachable and how many writes are
+// queued. Without offline mode, Online is always true.
+func (c *Client) SyncStatus() SyncStatus {
+	if c.offline == nil {
+		return SyncStatus{Online: true}
+	}
+	c.offline.mu.Lock()
+	defer c.offline.mu.Unlock()
+	return c.offline.status
+}
+
+// syncLoop replays queued writes in the background until the client is closed.
+func (c *Client) syncLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(c.offline.opts.RetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if c.offline.pending() > 0 {
+			if err := c.Flush(); err == nil {
+				c.logger.Info("[Celerix SDK] Daemon reachable again, queued writes replayed")
+			}
+		}
+	}
+}
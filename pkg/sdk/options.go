@@ -0,0 +1,105 @@
+package sdk
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"time"
+)
+
+// ClientOption configures optional Client behavior in Connect.
+type ClientOption func(*Client)
+
+// WithNegativeCache remembers "key not found" answers for ttl, so repeated Gets
+// for keys that don't exist skip the network round trip. Writes made through this
+// client invalidate the affected entries immediately; writes by other clients
+// become visible once the entry expires.
+func WithNegativeCache(ttl time.Duration) ClientOption {
+	return func(c *Client) {
+		c.misses = newNegativeCache(ttl)
+	}
+}
+
+// WithTimeout bounds each command's round trip (default DefaultTimeout).
+func WithTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// WithPreciseNumbers decodes numbers in values read from the daemon as
+// json.Number instead of float64, so large integers keep their precision. The
+// daemon must run with CELERIX_PRECISE_NUMBERS for them to survive there too.
+func WithPreciseNumbers() ClientOption {
+	return func(c *Client) {
+		c.preciseNumbers = true
+	}
+}
+
+// WithKeepalive sends a PING when the connection has been idle for interval, so
+// the daemon doesn't close it as idle. By default the interval is half the idle
+// timeout the daemon announces in HELLO (DefaultKeepalive for daemons that
+// don't); a negative interval turns keepalives off.
+func WithKeepalive(interval time.Duration) ClientOption {
+	return func(c *Client) {
+		c.keepalive = interval
+	}
+}
+
+// WithLogger routes the client's diagnostics (retries, reconnects, version
+// mismatches) to logger instead of slog.Default(). A nil logger silences them.
+func WithLogger(logger *slog.Logger) ClientOption {
+	return func(c *Client) {
+		if logger == nil {
+			logger = slog.New(slog.DiscardHandler)
+		}
+		c.logger = logger
+	}
+}
+
+// WithOfflineQueue enables offline mode for edge and desktop applications: while
+// the daemon is unreachable, Set and Delete are queued in opts.Store instead
+// of failing, reads of queued keys are answered from the queue, and
+// the queue is replayed in order once the daemon is back (see Client.Flush and
+// Client.SyncStatus). Connect then succeeds even if the daemon is down. Other
+// operations still need the daemon.
+func WithOfflineQueue(opts OfflineOptions) ClientOption {
+	return func(c *Client) {
+		c.offlineOpts = &opts
+	}
+}
+
+// WithoutTLS connects over plain TCP regardless of CELERIX_DISABLE_TLS.
+func WithoutTLS() ClientOption {
+	return func(c *Client) {
+		plaintext := true
+		c.plaintext = &plaintext
+	}
+}
+
+// WithTLSConfig connects over TLS with the given configuration regardless of
+// CELERIX_DISABLE_TLS. Use it to verify the daemon's certificate instead of
+// trusting any self-signed one.
+func WithTLSConfig(config *tls.Config) ClientOption {
+	return func(c *Client) {
+		plaintext := false
+		c.plaintext = &plaintext
+		c.tlsConfig = config
+	}
+}
+
+// WithReplicas adds the addresses of read replicas: daemons holding copies of
+// the primary's data. They are only used for reads, and only with a
+// WithReadPreference other than ReadPrimary. A replica that can't be reached
+// is skipped for a while and the read goes to the primary instead.
+func WithReplicas(addrs ...string) ClientOption {
+	return func(c *Client) {
+		c.replicaAddrs = addrs
+	}
+}
+
+// WithReadPreference decides where reads go when WithReplicas is used.
+func WithReadPreference(pref ReadPreference) ClientOption {
+	return func(c *Client) {
+		c.readPref = pref
+	}
+}
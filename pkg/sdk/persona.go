@@ -0,0 +1,57 @@
+package sdk
+
+import "fmt"
+
+// MergeStrategy decides what MergePersona does with keys held by both personas.
+type MergeStrategy string
+
+// Merge strategies. Keys with equal values in both personas are never
+// conflicts.
+const (
+	// MergeFailOnConflict merges nothing if any key differs between the two.
+	MergeFailOnConflict MergeStrategy = "fail-on-conflict"
+	// MergePreferSrc overwrites the destination's values.
+	MergePreferSrc MergeStrategy = "prefer-src"
+	// MergePreferDst keeps the destination's values.
+	MergePreferDst MergeStrategy = "prefer-dst"
+)
+
+// ParseMergeStrategy checks a strategy given as text. An empty one is
+// MergeFailOnConflict.
+func ParseMergeStrategy(text string) (MergeStrategy, error) {
+	switch s := MergeStrategy(text); s {
+	case "":
+		return MergeFailOnConflict, nil
+	case MergeFailOnConflict, MergePreferSrc, MergePreferDst:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown merge strategy %q: %w", text, ErrBadRequest)
+}
+
+// PersonaMerger folds one persona into another, e.g. when deduplicating user
+// accounts. It is optional: callers should type-assert a CelerixStore to
+// check for support.
+type PersonaMerger interface {
+	// MergePersona moves every app and key of src into dst, resolving keys
+	// held by both with strategy, and deletes src. With
+	// MergeFailOnConflict, a conflict leaves both personas untouched and
+	// returns an error matching ErrConflict.
+	MergePersona(src, dst string, strategy MergeStrategy) error
+}
+
+// MergePersona folds src into dst on the daemon, with MERGE_PERSONA.
+func (c *Client) MergePersona(src, dst string, strategy MergeStrategy) error {
+	if err := ValidateID("persona ID", src); err != nil {
+		return err
+	}
+	if err := ValidateID("persona ID", dst); err != nil {
+		return err
+	}
+	strategy, err := ParseMergeStrategy(string(strategy))
+	if err != nil {
+		return err
+	}
+	c.misses.forgetPersona(dst)
+	_, err = c.sendAndReceive(fmt.Sprintf("MERGE_PERSONA %s %s %s", src, dst, strategy))
+	return err
+}
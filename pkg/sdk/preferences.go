@@ -0,0 +1,71 @@
+package sdk
+
+// preferencesKey is the key each persona's preferences document is stored under.
+const preferencesKey = "preferences"
+
+// Preferences stores one settings document per persona for an app and fills in
+// defaults on read. Updates are merge patches; they run atomically on the store
+// when it implements Merger and fall back to read-modify-write otherwise.
+type Preferences struct {
+	store    KVStore
+	appID    string
+	defaults map[string]any
+}
+
+// NewPreferences manages preferences for appID. defaults may be nil.
+func NewPreferences(s KVStore, appID string, defaults map[string]any) *Preferences {
+	return &Preferences{store: s, appID: appID, defaults: defaults}
+}
+
+// Load returns the persona's preferences with defaults applied for unset fields.
+func (p *Preferences) Load(personaID string) (map[string]any, error) {
+	stored, err := p.store.Get(personaID, p.appID, preferencesKey)
+	if err != nil && !IsNotFound(err) {
+		return nil, err
+	}
+	return p.withDefaults(stored), nil
+}
+
+// Update applies an RFC 7396 merge patch (nil values delete fields) and returns
+// the resulting preferences with defaults applied.
+func (p *Preferences) Update(personaID string, patch map[string]any) (map[string]any, error) {
+	if m, ok := p.store.(Merger); ok {
+		merged, err := m.Merge(personaID, p.appID, preferencesKey, patch)
+		if err != nil {
+			return nil, err
+		}
+		return p.withDefaults(merged), nil
+	}
+
+	current, err := p.store.Get(personaID, p.appID, preferencesKey)
+	if err != nil && !IsNotFound(err) {
+		return nil, err
+	}
+	merged := MergePatch(current, patch)
+	if err := p.store.Set(personaID, p.appID, preferencesKey, merged); err != nil {
+		return nil, err
+	}
+	return p.withDefaults(merged), nil
+}
+
+// Reset removes the persona's stored preferences so only defaults remain.
+func (p *Preferences) Reset(personaID string) error {
+	if err := p.store.Delete(personaID, p.appID, preferencesKey); err != nil && !IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (p *Preferences) withDefaults(stored any) map[string]any {
+	// Merging onto an empty object copies the defaults, so callers can't modify them
+	// through the result. Applying the stored document over that gives stored values
+	// precedence while keeping nested defaults the user never touched.
+	result := MergePatch(map[string]any{}, p.defaults)
+	if stored != nil {
+		result = MergePatch(result, stored)
+	}
+	if m, ok := result.(map[string]any); ok {
+		return m
+	}
+	return make(map[string]any)
+}
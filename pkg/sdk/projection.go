@@ -0,0 +1,98 @@
+package sdk
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// FieldProjector allows fetching only selected fields of stored JSON objects,
+// so callers reading one field of a large document don't transfer the whole value.
+type FieldProjector interface {
+	GetFields(personaID, appID, key string, fields []string) (any, error)
+	GetAppStoreFields(personaID, appID string, fields []string) (map[string]any, error)
+}
+
+// ParseFields splits a comma-separated projection such as "name,profile.email".
+// Empty entries are dropped; a nil result means "no projection".
+func ParseFields(spec string) []string {
+	var fields []string
+	for _, f := range strings.Split(spec, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// Project returns a copy of val containing only the requested fields.
+// Fields are dotted paths into nested objects ("profile.email"). Arrays are
+// projected element by element, and scalars are returned unchanged.
+// Missing fields are omitted rather than reported as errors.
+func Project(val any, fields []string) any {
+	if len(fields) == 0 {
+		return val
+	}
+
+	switch v := val.(type) {
+	case nil, string, bool, float64, json.Number:
+		return v
+	case map[string]any:
+		out := make(map[string]any)
+		for _, f := range fields {
+			if coveredByParent(f, fields) {
+				continue
+			}
+			projectPath(v, out, strings.Split(f, "."))
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = Project(item, fields)
+		}
+		return out
+	}
+
+	// Structs and typed maps stored in embedded mode are normalized through JSON first.
+	switch generic := normalizeJSON(val).(type) {
+	case map[string]any, []any:
+		return Project(generic, fields)
+	}
+	return val
+}
+
+// coveredByParent reports whether a shorter field in the list already selects f
+// in full, e.g. "profile" covers "profile.email". Skipping such fields keeps
+// projectPath from writing into a value that is shared with the store.
+func coveredByParent(f string, fields []string) bool {
+	for _, other := range fields {
+		if strings.HasPrefix(f, other+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func projectPath(src, dst map[string]any, path []string) {
+	val, ok := src[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		dst[path[0]] = val
+		return
+	}
+
+	nested, ok := val.(map[string]any)
+	if !ok {
+		return
+	}
+	child, ok := dst[path[0]].(map[string]any)
+	if !ok {
+		child = make(map[string]any)
+	}
+	projectPath(nested, child, path[1:])
+	if len(child) > 0 {
+		dst[path[0]] = child
+	}
+}
@@ -0,0 +1,172 @@
+package sdk
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/version"
+)
+
+// ProtocolVersion is the newest line protocol version the SDK and daemon speak.
+// Version 1 reports errors as free text ("ERR key not found"). Version 2,
+// negotiated per connection with "HELLO 2", adds a status and a code:
+// "ERR 404 key_not_found key not found". Version 3 lets clients prefix a
+// command with its trace context (see TraceCommand), and version 4 with a
+// request ID (see RequestCommand).
+const ProtocolVersion = 4
+
+// Hello is the daemon's reply to "HELLO <version> [compression]": the
+// protocol version the connection now speaks, the daemon's build, how long it
+// lets a connection sit idle (zero for never), which the SDK uses to pace
+// keepalives, and whether lines may be compressed.
+type Hello struct {
+	Protocol    int           `json:"protocol"`
+	Server      version.Info  `json:"server"`
+	IdleTimeout time.Duration `json:"idle_timeout,omitempty"`
+	// Compression is Compression if the client asked for it and the daemon
+	// compresses long lines from now on.
+	Compression string `json:"compression,omitempty"`
+}
+
+// ErrorCode identifies a class of protocol error independently of its message.
+type ErrorCode string
+
+const (
+	CodeBadRequest         ErrorCode = "bad_request"
+	CodeUnknownCommand     ErrorCode = "unknown_command"
+	CodeUnauthorized       ErrorCode = "unauthorized"
+	CodePersonaNotFound    ErrorCode = "persona_not_found"
+	CodeAppNotFound        ErrorCode = "app_not_found"
+	CodeKeyNotFound        ErrorCode = "key_not_found"
+	CodePersonaQuarantined ErrorCode = "persona_quarantined"
+	CodeNotSupported       ErrorCode = "not_supported"
+	CodeMemoryLimit        ErrorCode = "memory_limit"
+	CodeStorageFailure     ErrorCode = "storage_failure"
+	CodeServerBusy         ErrorCode = "server_busy"
+	CodeConflict           ErrorCode = "conflict"
+	CodeInternal           ErrorCode = "internal"
+)
+
+var (
+	// ErrBadRequest is returned for malformed commands and invalid arguments.
+	ErrBadRequest = errors.New("bad request")
+	// ErrUnknownCommand is returned when the daemon doesn't know a command.
+	ErrUnknownCommand = errors.New("unknown command")
+	// ErrUnauthorized is returned when a request lacks valid credentials.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNotSupported is returned when the store behind the daemon lacks an optional capability.
+	ErrNotSupported = errors.New("not supported")
+	// ErrConflict is returned when a conditional write finds the value changed.
+	ErrConflict = errors.New("revision conflict")
+	// ErrInternal is returned for failures inside the daemon that have no more specific code.
+	ErrInternal = errors.New("internal error")
+)
+
+// errorCodes lists every code with its status and the sentinel it unwraps to,
+// most specific first: ClassifyError returns the first match.
+var errorCodes = []struct {
+	code   ErrorCode
+	status int
+	err    error
+}{
+	{CodePersonaNotFound, 404, ErrPersonaNotFound},
+	{CodeAppNotFound, 404, ErrAppNotFound},
+	{CodeKeyNotFound, 404, ErrKeyNotFound},
+	{CodePersonaQuarantined, 423, ErrPersonaQuarantined},
+	{CodeMemoryLimit, 507, ErrMemoryLimit},
+	{CodeStorageFailure, 507, ErrStorageFailure},
+	{CodeServerBusy, 503, ErrServerBusy},
+	{CodeConflict, 409, ErrConflict},
+	{CodeUnauthorized, 401, ErrUnauthorized},
+	{CodeUnknownCommand, 400, ErrUnknownCommand},
+	{CodeNotSupported, 501, ErrNotSupported},
+	{CodeBadRequest, 400, ErrBadRequest},
+	{CodeInternal, 500, ErrInternal},
+}
+
+// ProtocolError is an error reported by the daemon. It unwraps to the sentinel
+// for its code, so errors.Is(err, sdk.ErrKeyNotFound) works over the network.
+type ProtocolError struct {
+	Status  int
+	Code    ErrorCode
+	Message string
+}
+
+// NewProtocolError creates an error with an explicit code, for messages that
+// don't wrap one of the sentinels.
+func NewProtocolError(code ErrorCode, message string) *ProtocolError {
+	status := 500
+	for _, c := range errorCodes {
+		if c.code == code {
+			status = c.status
+			break
+		}
+	}
+	return &ProtocolError{Status: status, Code: code, Message: message}
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Message
+}
+
+// Is matches errors with the same code and message, so sentinels such as
+// ErrLockHeld still match after a round trip over the network.
+func (e *ProtocolError) Is(target error) bool {
+	t, ok := target.(*ProtocolError)
+	return ok && t.Code == e.Code && t.Message == e.Message
+}
+
+func (e *ProtocolError) Unwrap() error {
+	for _, c := range errorCodes {
+		if c.code == e.Code {
+			return c.err
+		}
+	}
+	return nil
+}
+
+// ClassifyError returns the protocol status and code for err. Errors from stores
+// that don't share the SDK's sentinels are matched by message, like IsNotFound.
+func ClassifyError(err error) (int, ErrorCode) {
+	var perr *ProtocolError
+	if errors.As(err, &perr) {
+		return perr.Status, perr.Code
+	}
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.status, c.code
+		}
+	}
+	for _, c := range errorCodes {
+		if strings.HasSuffix(err.Error(), c.err.Error()) {
+			return c.status, c.code
+		}
+	}
+	return 500, CodeInternal
+}
+
+// FormatError renders err as the text following "ERR " in the given protocol version.
+func FormatError(err error, protocol int) string {
+	if protocol < 2 {
+		return err.Error()
+	}
+	status, code := ClassifyError(err)
+	return fmt.Sprintf("%d %s %s", status, code, err.Error())
+}
+
+// ParseError turns the text following "ERR " back into an error. Version 1
+// messages carry no code, so one is inferred from the text where possible.
+func ParseError(text string, protocol int) error {
+	if protocol >= 2 {
+		if fields := strings.SplitN(text, " ", 3); len(fields) == 3 {
+			if status, err := strconv.Atoi(fields[0]); err == nil {
+				return &ProtocolError{Status: status, Code: ErrorCode(fields[1]), Message: fields[2]}
+			}
+		}
+	}
+	status, code := ClassifyError(errors.New(text))
+	return &ProtocolError{Status: status, Code: code, Message: text}
+}
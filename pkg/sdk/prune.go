@@ -0,0 +1,79 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Pruner removes stale keys on the store's side, atomically and in bulk,
+// instead of dumping an app and deleting key by key. It is optional: callers
+// should type-assert a CelerixStore to check for support, or use DeletePrefix.
+type Pruner interface {
+	// DeleteIfEquals deletes key if it holds a value equal to expected (as
+	// JSON), and returns ErrConflict if it holds another value or none.
+	DeleteIfEquals(personaID, appID, key string, expected any) error
+	// DeletePrefix deletes every key of the app starting with prefix and
+	// returns how many there were. An empty prefix empties the app.
+	DeletePrefix(personaID, appID, prefix string) (int, error)
+}
+
+// DeletePrefix deletes the keys starting with prefix from s. Stores that
+// aren't a Pruner get a dump followed by a Delete per key, which is not
+// atomic.
+func DeletePrefix(s CelerixStore, personaID, appID, prefix string) (int, error) {
+	if p, ok := s.(Pruner); ok {
+		return p.DeletePrefix(personaID, appID, prefix)
+	}
+	data, err := s.GetAppStore(personaID, appID)
+	if IsNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, key := range slices.Sorted(maps.Keys(data)) {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if err := s.Delete(personaID, appID, key); err != nil && !IsNotFound(err) {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// DeleteIfEquals deletes key on the daemon with DEL_IF_EQUALS.
+func (c *Client) DeleteIfEquals(personaID, appID, key string, expected any) error {
+	if err := ValidateID("persona ID", personaID); err != nil {
+		return err
+	}
+	if err := ValidateID("app ID", appID); err != nil {
+		return err
+	}
+	data, err := json.Marshal(expected)
+	if err != nil {
+		return err
+	}
+	_, err = c.sendAndReceive(fmt.Sprintf("DEL_IF_EQUALS %s %s %s %s", personaID, appID, key, data))
+	return err
+}
+
+// DeletePrefix deletes keys on the daemon with DEL_PREFIX.
+func (c *Client) DeletePrefix(personaID, appID, prefix string) (int, error) {
+	// The prefix is checked like a key, which also rules out emptying the
+	// app by accident.
+	if err := ValidateIDs(personaID, appID, prefix); err != nil {
+		return 0, err
+	}
+	resp, err := c.sendAndReceive(fmt.Sprintf("DEL_PREFIX %s %s %s", personaID, appID, prefix))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimPrefix(resp, "OK "))
+}
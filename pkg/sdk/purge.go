@@ -0,0 +1,50 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PurgeReport records what PurgePersona erased, e.g. for answering an erasure
+// request.
+type PurgeReport struct {
+	PersonaID string    `json:"persona_id"`
+	Apps      int       `json:"apps"`
+	Keys      int       `json:"keys"`
+	PurgedAt  time.Time `json:"purged_at"`
+}
+
+// Purger erases a persona for good: its data in memory and on disk, its blobs,
+// quarantined copies of its files and whatever the store remembers about it,
+// such as leases and persona hashes. Unlike deleting its keys, nothing of the
+// persona is left to list or recover. It is optional: callers should
+// type-assert a CelerixStore to check for support.
+type Purger interface {
+	PurgePersona(personaID string) (PurgeReport, error)
+}
+
+// PurgePersona erases a persona from s, which must be a Purger: deleting key
+// by key would leave traces behind, so there is no fallback.
+func PurgePersona(s any, personaID string) (PurgeReport, error) {
+	if p, ok := s.(Purger); ok {
+		return p.PurgePersona(personaID)
+	}
+	return PurgeReport{}, fmt.Errorf("purging personas: %w", ErrNotSupported)
+}
+
+// PurgePersona erases a persona on the daemon with PURGE_PERSONA. Writes to it
+// still in the offline queue are sent later and create it anew.
+func (c *Client) PurgePersona(personaID string) (PurgeReport, error) {
+	if err := ValidateID("persona ID", personaID); err != nil {
+		return PurgeReport{}, err
+	}
+	resp, err := c.sendAndReceive(fmt.Sprintf("PURGE_PERSONA %s", personaID))
+	if err != nil {
+		return PurgeReport{}, err
+	}
+	var report PurgeReport
+	err = json.Unmarshal([]byte(strings.TrimPrefix(resp, "OK ")), &report)
+	return report, err
+}
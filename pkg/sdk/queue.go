@@ -0,0 +1,85 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultVisibilityTimeout is how long a dequeued message stays hidden from
+// other consumers unless the caller picks a timeout.
+const DefaultVisibilityTimeout = 30 * time.Second
+
+var (
+	// ErrQueueEmpty is returned by Dequeue when no message is visible.
+	ErrQueueEmpty = NewProtocolError(CodeKeyNotFound, "queue empty")
+	// ErrReceiptExpired is returned by Ack for a receipt whose visibility
+	// timeout ran out, so the message may have gone to another consumer.
+	ErrReceiptExpired = NewProtocolError(CodeConflict, "receipt expired")
+)
+
+// QueueMessage is a message handed out by Dequeue. Ack it with its Receipt
+// once it has been handled; otherwise it becomes visible again when the
+// visibility timeout runs out.
+type QueueMessage struct {
+	ID         string    `json:"id"`
+	Item       any       `json:"item"`
+	Receipt    string    `json:"receipt,omitempty"`
+	Receives   int       `json:"receives"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+}
+
+// Queuer is implemented by stores with FIFO queues, for handing jobs between
+// processes without a separate broker. A queue is the value of a key, so it is
+// persisted, exported and moved like any other value. It is optional: callers
+// should type-assert a CelerixStore to check for support.
+type Queuer interface {
+	// Enqueue appends an item and returns its message ID.
+	Enqueue(personaID, appID, queue string, item any) (string, error)
+	// Dequeue hands out the oldest visible message and hides it for
+	// visibility. A visibility of 0 or less removes it right away instead.
+	// It returns ErrQueueEmpty if no message is visible.
+	Dequeue(personaID, appID, queue string, visibility time.Duration) (QueueMessage, error)
+	// Ack removes a dequeued message, or returns ErrReceiptExpired.
+	Ack(personaID, appID, queue, receipt string) error
+}
+
+// Enqueue appends an item to a queue on the daemon.
+func (c *Client) Enqueue(personaID, appID, queue string, item any) (string, error) {
+	if err := ValidateIDs(personaID, appID, queue); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.sendAndReceive(fmt.Sprintf("ENQUEUE %s %s %s %s", personaID, appID, queue, data))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(resp, "OK "), nil
+}
+
+// Dequeue takes the oldest visible message from a queue on the daemon.
+func (c *Client) Dequeue(personaID, appID, queue string, visibility time.Duration) (QueueMessage, error) {
+	if err := ValidateIDs(personaID, appID, queue); err != nil {
+		return QueueMessage{}, err
+	}
+	resp, err := c.sendAndReceive(fmt.Sprintf("DEQUEUE %s %s %s %d", personaID, appID, queue, visibility.Milliseconds()))
+	if err != nil {
+		return QueueMessage{}, err
+	}
+	var msg QueueMessage
+	err = c.decodeValue(strings.TrimPrefix(resp, "OK "), &msg)
+	return msg, err
+}
+
+// Ack removes a dequeued message from a queue on the daemon.
+func (c *Client) Ack(personaID, appID, queue, receipt string) error {
+	if receipt == "" || strings.ContainsAny(receipt, " \t\r\n") {
+		return fmt.Errorf("invalid receipt: %w", ErrBadRequest)
+	}
+	_, err := c.sendAndReceive(fmt.Sprintf("ACK %s %s %s %s", personaID, appID, queue, receipt))
+	return err
+}
@@ -0,0 +1,42 @@
+package sdk
+
+import "encoding/json"
+
+// RawReader answers reads with the JSON encoding of values, so a store that
+// keeps values encoded can hand them out without marshaling them again. It is
+// optional: use GetRaw and GetAppStoreRaw, which fall back to Get and
+// GetAppStore. The returned bytes may be shared and must not be modified.
+type RawReader interface {
+	GetRaw(personaID, appID, key string) (json.RawMessage, error)
+	GetAppStoreRaw(personaID, appID string) (map[string]json.RawMessage, error)
+}
+
+// GetRaw returns the JSON encoding of a value in s.
+func GetRaw(s KVReader, personaID, appID, key string) (json.RawMessage, error) {
+	if rr, ok := s.(RawReader); ok {
+		return rr.GetRaw(personaID, appID, key)
+	}
+	val, err := s.Get(personaID, appID, key)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(val)
+}
+
+// GetAppStoreRaw returns the JSON encoding of every value of an app in s.
+func GetAppStoreRaw(s BatchExporter, personaID, appID string) (map[string]json.RawMessage, error) {
+	if rr, ok := s.(RawReader); ok {
+		return rr.GetAppStoreRaw(personaID, appID)
+	}
+	data, err := s.GetAppStore(personaID, appID)
+	if err != nil {
+		return nil, err
+	}
+	raw := make(map[string]json.RawMessage, len(data))
+	for k, v := range data {
+		if raw[k], err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	return raw, nil
+}
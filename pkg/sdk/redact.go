@@ -0,0 +1,170 @@
+package sdk
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/celerix-dev/celerix-store/internal/vault"
+)
+
+// Redacted replaces what a RedactionPolicy hides.
+const Redacted = "[REDACTED]"
+
+// RedactionRule hides the values of keys matching Key in apps matching App,
+// or with Field only that field of them. App and Key are path.Match
+// patterns; Field is a dotted path into the value, as in Project, each of
+// whose parts may be a pattern too.
+type RedactionRule struct {
+	App, Key, Field string
+}
+
+// RedactionPolicy hides sensitive values from exports and dumps meant for
+// debugging, so they can be passed around without leaking credentials.
+type RedactionPolicy struct {
+	// Vault hides every string encrypted by a vault, wherever it is.
+	Vault bool
+	Rules []RedactionRule
+}
+
+// ParseRedactionPolicy reads a policy from a comma-separated list of rules,
+// each "app/key" or "app/key/field.path", e.g.
+// "vault,billing/card_*,*/*/password". The rule "vault" sets Vault.
+func ParseRedactionPolicy(spec string) (*RedactionPolicy, error) {
+	p := &RedactionPolicy{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if item == "vault" {
+			p.Vault = true
+			continue
+		}
+		parts := strings.SplitN(item, "/", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" || (len(parts) == 3 && parts[2] == "") {
+			return nil, fmt.Errorf("invalid redaction rule %q: want app/key or app/key/field", item)
+		}
+		rule := RedactionRule{App: parts[0], Key: parts[1]}
+		if len(parts) == 3 {
+			rule.Field = parts[2]
+		}
+		for _, pattern := range append([]string{rule.App, rule.Key}, strings.Split(rule.Field, ".")...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid redaction rule %q: %v", item, err)
+			}
+		}
+		p.Rules = append(p.Rules, rule)
+	}
+	return p, nil
+}
+
+// RedactValue returns val with what p hides in the given key replaced by
+// Redacted. val itself is left alone; parts that change are copied. A nil
+// policy hides nothing.
+func (p *RedactionPolicy) RedactValue(appID, key string, val any) any {
+	if p == nil {
+		return val
+	}
+	for _, rule := range p.Rules {
+		if ok, _ := path.Match(rule.App, appID); !ok {
+			continue
+		}
+		if ok, _ := path.Match(rule.Key, key); !ok {
+			continue
+		}
+		if rule.Field == "" {
+			return Redacted
+		}
+		val = redactField(normalizeJSON(val), strings.Split(rule.Field, "."))
+	}
+	if p.Vault {
+		val = redactVault(val)
+	}
+	return val
+}
+
+// RedactApp returns a copy of an app's data with p applied to every key.
+func (p *RedactionPolicy) RedactApp(appID string, data map[string]any) map[string]any {
+	if p == nil {
+		return data
+	}
+	out := make(map[string]any, len(data))
+	for key, val := range data {
+		out[key] = p.RedactValue(appID, key, val)
+	}
+	return out
+}
+
+// RedactExport returns a copy of exp with p applied to every app.
+func (p *RedactionPolicy) RedactExport(exp *PersonaExport) *PersonaExport {
+	if p == nil {
+		return exp
+	}
+	out := *exp
+	out.Apps = make(map[string]map[string]any, len(exp.Apps))
+	for appID, data := range exp.Apps {
+		out.Apps[appID] = p.RedactApp(appID, data)
+	}
+	return &out
+}
+
+// redactField replaces the fields at fieldPath, copying the objects on the
+// way to them. Arrays are redacted element by element.
+func redactField(val any, fieldPath []string) any {
+	switch v := val.(type) {
+	case map[string]any:
+		var out map[string]any
+		for name, field := range v {
+			if ok, _ := path.Match(fieldPath[0], name); !ok {
+				continue
+			}
+			if out == nil {
+				out = make(map[string]any, len(v))
+				for k, x := range v {
+					out[k] = x
+				}
+			}
+			if len(fieldPath) == 1 {
+				out[name] = Redacted
+			} else {
+				out[name] = redactField(field, fieldPath[1:])
+			}
+		}
+		if out == nil {
+			return v
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = redactField(item, fieldPath)
+		}
+		return out
+	}
+	return val
+}
+
+// redactVault replaces the vault-encrypted strings in val.
+func redactVault(val any) any {
+	switch v := normalizeJSON(val).(type) {
+	case string:
+		if vault.IsEncrypted(v) || vault.IsPassphraseEncrypted(v) {
+			return Redacted
+		}
+		return v
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, x := range v {
+			out[k] = redactVault(x)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, x := range v {
+			out[i] = redactVault(x)
+		}
+		return out
+	}
+	return val
+}
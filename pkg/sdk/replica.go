@@ -0,0 +1,180 @@
+package sdk
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReadPreference decides where a Client configured WithReplicas sends reads.
+// Writes always go to the primary, the address passed to Connect.
+type ReadPreference int
+
+const (
+	// ReadPrimary reads from the primary only (the default).
+	ReadPrimary ReadPreference = iota
+	// ReadReplica spreads reads over the replicas, reading from the primary
+	// only while none of them can be reached.
+	ReadReplica
+	// ReadNearest reads from whichever of the primary and the replicas has
+	// answered fastest lately.
+	ReadNearest
+)
+
+// ParseReadPreference reads "primary", "replica" or "nearest".
+func ParseReadPreference(text string) (ReadPreference, error) {
+	switch text {
+	case "primary", "":
+		return ReadPrimary, nil
+	case "replica":
+		return ReadReplica, nil
+	case "nearest":
+		return ReadNearest, nil
+	}
+	return ReadPrimary, fmt.Errorf("unknown read preference %q: %w", text, ErrBadRequest)
+}
+
+// replicaRetryAfter is how long a replica that couldn't be reached is left out
+// of reads before it is tried again. With ReadNearest, every
+// nearestProbeInterval-th read goes to the next replica in turn, so latencies
+// that have improved are noticed.
+const (
+	replicaRetryAfter    = 30 * time.Second
+	nearestProbeInterval = 32
+)
+
+// readCommands are the commands a replica may answer. Streams and per-daemon
+// commands such as STATS stay on the primary.
+var readCommands = map[string]bool{
+	"GET": true, "EXISTS": true, "MGET": true, "DUMP": true, "DUMP_APP": true, "DUMP_PERSONA": true,
+	"LIST_PERSONAS": true, "LIST_APPS": true, "GET_GLOBAL": true, "SEARCH": true, "USAGE": true,
+}
+
+// replicaNode is the primary (client nil) or a replica, with how fast it has
+// been answering.
+type replicaNode struct {
+	client    *Client
+	latency   time.Duration // moving average of round trips; 0 until measured
+	downUntil time.Time
+}
+
+// replicaSet routes a Client's reads by its ReadPreference. Replicas may lag
+// behind the primary, so a read may not see a write that just succeeded.
+type replicaSet struct {
+	pref ReadPreference
+
+	mu       sync.Mutex
+	primary  *replicaNode
+	replicas []*replicaNode
+	next     int // round robin over the replicas
+	reads    int // for ReadNearest probes
+}
+
+// newReplicaSet prepares a connection per replica address, with the primary's
+// options. Replicas connect on first use, so one being down doesn't stop
+// Connect.
+func newReplicaSet(c *Client) *replicaSet {
+	rs := &replicaSet{pref: c.readPref, primary: &replicaNode{}}
+	for _, addr := range c.replicaAddrs {
+		r := &Client{clientConn: &clientConn{}, addr: addr, logger: c.logger, stop: func() {}}
+		for _, opt := range c.opts {
+			opt(r)
+		}
+		// Reads fail over to the primary instead of retrying, and only the
+		// primary keeps a negative cache and an offline queue.
+		r.replicaAddrs, r.offlineOpts, r.misses, r.noRetry = nil, nil, nil, true
+		rs.replicas = append(rs.replicas, &replicaNode{client: r})
+	}
+	return rs
+}
+
+// pick chooses the node for the next read.
+func (rs *replicaSet) pick() *replicaNode {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	now := time.Now()
+	switch rs.pref {
+	case ReadReplica:
+		for range rs.replicas {
+			node := rs.replicas[rs.next%len(rs.replicas)]
+			rs.next++
+			if now.After(node.downUntil) {
+				return node
+			}
+		}
+	case ReadNearest:
+		rs.reads++
+		if rs.reads%nearestProbeInterval == 0 {
+			node := rs.replicas[rs.next%len(rs.replicas)]
+			rs.next++
+			if now.After(node.downUntil) {
+				return node
+			}
+		}
+		best := rs.primary
+		for _, node := range rs.replicas {
+			if !now.After(node.downUntil) {
+				continue
+			}
+			if node.latency == 0 {
+				return node // Not measured yet
+			}
+			if node.latency < best.latency {
+				best = node
+			}
+		}
+		return best
+	}
+	return rs.primary
+}
+
+// observe folds a round trip into the node's moving average latency.
+func (rs *replicaSet) observe(node *replicaNode, d time.Duration) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if node.latency == 0 {
+		node.latency = d
+	} else {
+		node.latency = (node.latency*7 + d) / 8
+	}
+}
+
+func (rs *replicaSet) fail(node *replicaNode) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	node.downUntil = time.Now().Add(replicaRetryAfter)
+}
+
+// read sends a read command to the node the preference picks, falling back to
+// the primary if a replica can't be reached.
+func (rs *replicaSet) read(c *Client, cmd, traceparent string) (string, error) {
+	if node := rs.pick(); node != rs.primary {
+		start := time.Now()
+		resp, err := node.client.roundTrip(cmd, traceparent)
+		if !isUnreachable(err) {
+			rs.observe(node, time.Since(start))
+			return resp, err
+		}
+		rs.fail(node)
+		c.logger.Warn("[Celerix SDK] Replica unreachable, reading from the primary", "addr", node.client.addr, "error", err)
+	}
+	start := time.Now()
+	resp, err := c.roundTrip(cmd, traceparent)
+	if !isUnreachable(err) {
+		rs.observe(rs.primary, time.Since(start))
+	}
+	return resp, err
+}
+
+func (rs *replicaSet) close() {
+	for _, node := range rs.replicas {
+		node.client.Close()
+	}
+}
+
+// isReadCommand reports whether a replica may answer cmd.
+func isReadCommand(cmd string) bool {
+	word, _, _ := strings.Cut(cmd, " ")
+	return readCommands[word]
+}
@@ -0,0 +1,87 @@
+package sdk
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// RequestCommand is the command prefix carrying a request ID on connections
+// speaking protocol version 4: "REQ <id> SET p a k v". The daemon remembers
+// the answers to writes sent with an ID for a few minutes, and answers a
+// command with an ID it has seen with the same answer instead of running it
+// again. The client adds a random ID to every write, so a write whose answer
+// was lost can be resent safely, unless the daemon restarted in between.
+const RequestCommand = "REQ"
+
+// NetworkError is a failure to talk to the daemon, as opposed to an error the
+// daemon answered with (a *ProtocolError). Such errors may go away on their
+// own, but unless Sent is false the command may already have been applied.
+type NetworkError struct {
+	Command string // The command's name, e.g. "SET"
+	// Sent is set if the command was sent and the answer was lost, so the
+	// daemon may or may not have run it.
+	Sent bool
+	Err  error
+}
+
+func (e *NetworkError) Error() string {
+	if e.Sent {
+		return fmt.Sprintf("%s may or may not have been applied: %v", e.Command, e.Err)
+	}
+	return fmt.Sprintf("%s not sent: %v", e.Command, e.Err)
+}
+
+func (e *NetworkError) Unwrap() error { return e.Err }
+
+// IsRetryable reports whether err is worth trying again: the daemon was busy
+// or couldn't be reached. Errors the daemon answered with, like
+// ErrKeyNotFound or ErrConflict, are not. A retryable error from a write that
+// isn't idempotent (see IsAmbiguous) can apply the write twice.
+func IsRetryable(err error) bool {
+	var nerr *NetworkError
+	return errors.Is(err, ErrServerBusy) || errors.As(err, &nerr)
+}
+
+// IsAmbiguous reports whether err means the command reached the daemon but
+// its answer was lost, so it may or may not have been applied. Writes to
+// daemons speaking protocol version 4 are resent with the same request ID
+// instead, so this only happens if every attempt failed.
+func IsAmbiguous(err error) bool {
+	var nerr *NetworkError
+	return errors.As(err, &nerr) && nerr.Sent
+}
+
+// WithRetryWrites makes the client resend writes whose answer was lost, as it
+// does for reads. Only use it if every write the application makes is safe to
+// apply twice: otherwise a retried ENQUEUE adds a second message, a retried
+// SET can undo another client's newer write, and a retried DEL reports
+// ErrKeyNotFound for a key it deleted.
+func WithRetryWrites() ClientOption {
+	return func(c *Client) {
+		c.retryWrites = true
+	}
+}
+
+// idempotentCommands can be resent after their answer was lost without
+// changing the outcome, because they don't write.
+var idempotentCommands = map[string]bool{
+	"GET": true, "EXISTS": true, "MGET": true, "DUMP": true, "DUMP_APP": true, "DUMP_PERSONA": true,
+	"LIST_PERSONAS": true, "LIST_APPS": true, "GET_GLOBAL": true, "SEARCH": true, "USAGE": true, "BLOB_GET": true,
+	"COMPUTE": true, "STATS": true, "INFO": true, "FSCK": true, "VERSION": true, "HELLO": true, "PING": true,
+}
+
+// newRequestID returns a random request ID for RequestCommand.
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id) // Never fails
+	return hex.EncodeToString(id)
+}
+
+// isIdempotent reports whether cmd can be resent after its answer was lost.
+func isIdempotent(cmd string) bool {
+	word, _, _ := strings.Cut(cmd, " ")
+	return idempotentCommands[word]
+}
@@ -0,0 +1,69 @@
+package sdk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Revision identifies the content of a value: equal values have the same
+// revision, so a client can tell whether a value changed since it read it.
+// A missing value has the revision "".
+func Revision(val any) string {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return ""
+	}
+	return RawRevision(data)
+}
+
+// RawRevision is the revision of a value given its JSON encoding, as returned
+// by GetRaw.
+func RawRevision(data json.RawMessage) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// ConditionalWriter is implemented by stores that can write a value only if it
+// hasn't changed since it was read, for optimistic locking. It is optional:
+// callers should type-assert a CelerixStore to check for support.
+type ConditionalWriter interface {
+	// SetIfRevision stores val if the current value has revision rev, or if
+	// rev is "" and there is no value yet. Otherwise it returns ErrConflict.
+	SetIfRevision(personaID, appID, key string, val any, rev string) error
+	// DeleteIfRevision deletes the value if it has revision rev, and returns
+	// ErrConflict otherwise.
+	DeleteIfRevision(personaID, appID, key, rev string) error
+}
+
+// SetIfRevision stores val on the daemon with SET_IF_REVISION.
+func (c *Client) SetIfRevision(personaID, appID, key string, val any, rev string) error {
+	if err := ValidateIDs(personaID, appID, key); err != nil {
+		return err
+	}
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	_, err = c.sendAndReceive(fmt.Sprintf("SET_IF_REVISION %s %s %s %s %s", personaID, appID, key, wireRevision(rev), data))
+	return err
+}
+
+// DeleteIfRevision deletes key on the daemon with DEL_IF_REVISION.
+func (c *Client) DeleteIfRevision(personaID, appID, key, rev string) error {
+	if err := ValidateIDs(personaID, appID, key); err != nil {
+		return err
+	}
+	_, err := c.sendAndReceive(fmt.Sprintf("DEL_IF_REVISION %s %s %s %s", personaID, appID, key, wireRevision(rev)))
+	return err
+}
+
+// wireRevision encodes the revision of a missing value as "-", since the
+// protocol splits commands on spaces.
+func wireRevision(rev string) string {
+	if rev == "" {
+		return "-"
+	}
+	return rev
+}
@@ -0,0 +1,438 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SchemaApp is the app in the _system persona that holds JSON Schemas, keyed
+// by the ID of the app they describe. Once an app has a schema, the engine
+// rejects values for it that don't match.
+const SchemaApp = "schemas"
+
+// Schema is a compiled JSON Schema. The store supports the validation keywords
+// of draft 2020-12 that need no references: type, enum, const, properties,
+// required, additionalProperties, items, minimum, maximum, exclusiveMinimum,
+// exclusiveMaximum, minLength, maxLength, pattern, minItems, maxItems, allOf,
+// anyOf, oneOf and not. Annotations such as title and description are ignored;
+// $ref is refused rather than silently skipped.
+type Schema struct {
+	reject bool // The schema `false`
+
+	types      []string
+	enum       []any
+	constVal   any
+	hasConst   bool
+	properties map[string]*Schema
+	required   []string
+	additional *Schema
+	items      *Schema
+
+	minimum, maximum, exclusiveMinimum, exclusiveMaximum *float64
+	minLength, maxLength, minItems, maxItems             int
+
+	pattern             *regexp.Regexp
+	allOf, anyOf, oneOf []*Schema
+	not                 *Schema
+}
+
+// SchemaError is returned for a value that doesn't match a schema, or for a
+// schema that can't be compiled. It matches ErrBadRequest with errors.Is.
+type SchemaError struct {
+	// Path is a JSON Pointer to the offending part of the value or schema.
+	Path   string
+	Reason string
+
+	inSchema bool
+}
+
+func (e *SchemaError) Error() string {
+	what := "value does not match schema"
+	if e.inSchema {
+		what = "invalid schema"
+	}
+	if e.Path == "" {
+		return what + ": " + e.Reason
+	}
+	return what + " at " + e.Path + ": " + e.Reason
+}
+
+func (e *SchemaError) Unwrap() error {
+	return ErrBadRequest
+}
+
+var schemaTypes = []string{"null", "boolean", "object", "array", "number", "integer", "string"}
+
+// CompileSchema compiles a JSON Schema given as a decoded JSON value, a Go value
+// that marshals to one, or raw JSON bytes.
+func CompileSchema(schema any) (*Schema, error) {
+	v, err := schemaValue(schema)
+	if err != nil {
+		return nil, err
+	}
+	return compileSchema(v, "")
+}
+
+// schemaValue decodes a schema given to CompileSchema or SetSchema.
+func schemaValue(schema any) (any, error) {
+	if raw, ok := schema.([]byte); ok {
+		schema = json.RawMessage(raw)
+	}
+	v, err := plainJSON(schema)
+	if err != nil {
+		return nil, &SchemaError{Reason: err.Error(), inSchema: true}
+	}
+	return v, nil
+}
+
+func compileSchema(v any, path string) (*Schema, error) {
+	fail := func(at, format string, args ...any) (*Schema, error) {
+		return nil, &SchemaError{Path: path + at, Reason: fmt.Sprintf(format, args...), inSchema: true}
+	}
+
+	if b, ok := v.(bool); ok {
+		return &Schema{reject: !b, minLength: -1, maxLength: -1, minItems: -1, maxItems: -1}, nil
+	}
+	def, ok := v.(map[string]any)
+	if !ok {
+		return fail("", "must be an object or a boolean")
+	}
+	s := &Schema{minLength: -1, maxLength: -1, minItems: -1, maxItems: -1}
+
+	if _, ok := def["$ref"]; ok {
+		return fail("/$ref", "references are not supported")
+	}
+
+	switch t := def["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{t}
+	case []any:
+		for _, name := range t {
+			str, ok := name.(string)
+			if !ok {
+				return fail("/type", "must be a string or an array of strings")
+			}
+			s.types = append(s.types, str)
+		}
+	default:
+		return fail("/type", "must be a string or an array of strings")
+	}
+	for _, t := range s.types {
+		if !slices.Contains(schemaTypes, t) {
+			return fail("/type", "unknown type %q", t)
+		}
+	}
+
+	if e, ok := def["enum"]; ok {
+		if s.enum, ok = e.([]any); !ok {
+			return fail("/enum", "must be an array")
+		}
+	}
+	s.constVal, s.hasConst = def["const"]
+
+	if p, ok := def["properties"]; ok {
+		props, ok := p.(map[string]any)
+		if !ok {
+			return fail("/properties", "must be an object")
+		}
+		s.properties = make(map[string]*Schema, len(props))
+		for name, sub := range props {
+			compiled, err := compileSchema(sub, path+"/properties/"+escapePointer(name))
+			if err != nil {
+				return nil, err
+			}
+			s.properties[name] = compiled
+		}
+	}
+	if r, ok := def["required"]; ok {
+		names, ok := r.([]any)
+		if !ok {
+			return fail("/required", "must be an array of strings")
+		}
+		for _, name := range names {
+			str, ok := name.(string)
+			if !ok {
+				return fail("/required", "must be an array of strings")
+			}
+			s.required = append(s.required, str)
+		}
+	}
+
+	for keyword, dst := range map[string]**Schema{"additionalProperties": &s.additional, "items": &s.items, "not": &s.not} {
+		if sub, ok := def[keyword]; ok {
+			compiled, err := compileSchema(sub, path+"/"+keyword)
+			if err != nil {
+				return nil, err
+			}
+			*dst = compiled
+		}
+	}
+	for keyword, dst := range map[string]*[]*Schema{"allOf": &s.allOf, "anyOf": &s.anyOf, "oneOf": &s.oneOf} {
+		sub, ok := def[keyword]
+		if !ok {
+			continue
+		}
+		list, ok := sub.([]any)
+		if !ok || len(list) == 0 {
+			return fail("/"+keyword, "must be a non-empty array of schemas")
+		}
+		for i, item := range list {
+			compiled, err := compileSchema(item, path+"/"+keyword+"/"+strconv.Itoa(i))
+			if err != nil {
+				return nil, err
+			}
+			*dst = append(*dst, compiled)
+		}
+	}
+
+	for keyword, dst := range map[string]**float64{"minimum": &s.minimum, "maximum": &s.maximum, "exclusiveMinimum": &s.exclusiveMinimum, "exclusiveMaximum": &s.exclusiveMaximum} {
+		if n, ok := def[keyword]; ok {
+			f, ok := n.(float64)
+			if !ok {
+				return fail("/"+keyword, "must be a number")
+			}
+			*dst = &f
+		}
+	}
+	for keyword, dst := range map[string]*int{"minLength": &s.minLength, "maxLength": &s.maxLength, "minItems": &s.minItems, "maxItems": &s.maxItems} {
+		if n, ok := def[keyword]; ok {
+			f, ok := n.(float64)
+			if !ok || f < 0 || f != math.Trunc(f) {
+				return fail("/"+keyword, "must be a non-negative integer")
+			}
+			*dst = int(f)
+		}
+	}
+
+	if p, ok := def["pattern"]; ok {
+		str, ok := p.(string)
+		if !ok {
+			return fail("/pattern", "must be a string")
+		}
+		re, err := regexp.Compile(str)
+		if err != nil {
+			return fail("/pattern", "%v", err)
+		}
+		s.pattern = re
+	}
+	return s, nil
+}
+
+// Validate reports the first part of val that doesn't match the schema as a
+// *SchemaError. val may be a decoded JSON value or a Go value that marshals
+// to one.
+func (s *Schema) Validate(val any) error {
+	v, err := plainJSON(val)
+	if err != nil {
+		return &SchemaError{Reason: err.Error()}
+	}
+	return s.validate(v, "")
+}
+
+func (s *Schema) validate(v any, path string) error {
+	fail := func(format string, args ...any) error {
+		return &SchemaError{Path: path, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	if s.reject {
+		return fail("no value is allowed")
+	}
+	if len(s.types) > 0 && !slices.ContainsFunc(s.types, func(t string) bool { return hasJSONType(v, t) }) {
+		return fail("expected %s, got %s", strings.Join(s.types, " or "), jsonType(v))
+	}
+	if s.enum != nil && !slices.ContainsFunc(s.enum, func(e any) bool { return reflect.DeepEqual(e, v) }) {
+		return fail("not one of the allowed values")
+	}
+	if s.hasConst && !reflect.DeepEqual(s.constVal, v) {
+		want, _ := json.Marshal(s.constVal)
+		return fail("must be %s", want)
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				return fail("missing required property %q", name)
+			}
+		}
+		// Sorted, so the same value always reports the same error
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			sub, ok := s.properties[name]
+			if !ok {
+				sub = s.additional
+			}
+			if sub == nil {
+				continue
+			}
+			if err := sub.validate(v[name], path+"/"+escapePointer(name)); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if s.minItems >= 0 && len(v) < s.minItems {
+			return fail("needs at least %d items", s.minItems)
+		}
+		if s.maxItems >= 0 && len(v) > s.maxItems {
+			return fail("allows at most %d items", s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range v {
+				if err := s.items.validate(item, path+"/"+strconv.Itoa(i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := len([]rune(v))
+		if s.minLength >= 0 && length < s.minLength {
+			return fail("must be at least %d characters", s.minLength)
+		}
+		if s.maxLength >= 0 && length > s.maxLength {
+			return fail("must be at most %d characters", s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return fail("does not match pattern %q", s.pattern)
+		}
+	case float64:
+		switch {
+		case s.minimum != nil && v < *s.minimum:
+			return fail("must be at least %v", *s.minimum)
+		case s.maximum != nil && v > *s.maximum:
+			return fail("must be at most %v", *s.maximum)
+		case s.exclusiveMinimum != nil && v <= *s.exclusiveMinimum:
+			return fail("must be greater than %v", *s.exclusiveMinimum)
+		case s.exclusiveMaximum != nil && v >= *s.exclusiveMaximum:
+			return fail("must be less than %v", *s.exclusiveMaximum)
+		}
+	}
+
+	for _, sub := range s.allOf {
+		if err := sub.validate(v, path); err != nil {
+			return err
+		}
+	}
+	if s.anyOf != nil && !slices.ContainsFunc(s.anyOf, func(sub *Schema) bool { return sub.validate(v, path) == nil }) {
+		return fail("matches none of the anyOf schemas")
+	}
+	if s.oneOf != nil {
+		matches := 0
+		for _, sub := range s.oneOf {
+			if sub.validate(v, path) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fail("matches %d of the oneOf schemas, want exactly 1", matches)
+		}
+	}
+	if s.not != nil && s.not.validate(v, path) == nil {
+		return fail("must not match the not schema")
+	}
+	return nil
+}
+
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	default:
+		return "string"
+	}
+}
+
+func hasJSONType(v any, t string) bool {
+	got := jsonType(v)
+	return got == t || (t == "number" && got == "integer")
+}
+
+// escapePointer escapes a property name for use in a JSON Pointer.
+func escapePointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+// plainJSON returns v as the generic types encoding/json decodes into,
+// converting it through JSON only if it contains anything else.
+func plainJSON(v any) (any, error) {
+	if isPlainJSON(v) {
+		return v, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out any
+	err = json.Unmarshal(data, &out)
+	return out, err
+}
+
+func isPlainJSON(v any) bool {
+	switch v := v.(type) {
+	case nil, bool, float64, string:
+		return true
+	case map[string]any:
+		for _, item := range v {
+			if !isPlainJSON(item) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		for _, item := range v {
+			if !isPlainJSON(item) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// SetSchema registers the JSON Schema that values of appID must match from
+// now on. Values already stored are not checked.
+func SetSchema(s KVWriter, appID string, schema any) error {
+	v, err := schemaValue(schema)
+	if err != nil {
+		return err
+	}
+	if _, err := compileSchema(v, ""); err != nil {
+		return err
+	}
+	return s.Set(SystemPersona, SchemaApp, appID, v)
+}
+
+// GetSchema returns the JSON Schema registered for appID, or a not-found
+// error if it has none.
+func GetSchema(s KVReader, appID string) (any, error) {
+	return s.Get(SystemPersona, SchemaApp, appID)
+}
+
+// DeleteSchema stops validating the values of appID.
+func DeleteSchema(s KVWriter, appID string) error {
+	if err := s.Delete(SystemPersona, SchemaApp, appID); err != nil && !IsNotFound(err) {
+		return err
+	}
+	return nil
+}
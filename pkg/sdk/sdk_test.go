@@ -1,41 +1,36 @@
 package sdk_test
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	"github.com/celerix-dev/celerix-store/internal/server"
+	"github.com/celerix-dev/celerix-store/internal/vault"
 	"github.com/celerix-dev/celerix-store/pkg/engine"
+	"github.com/celerix-dev/celerix-store/pkg/schema"
 	"github.com/celerix-dev/celerix-store/pkg/sdk"
+	"github.com/celerix-dev/celerix-store/pkg/sdk/fake"
+	"github.com/celerix-dev/celerix-store/pkg/server"
+	"github.com/celerix-dev/celerix-store/pkg/testutil"
+	"github.com/celerix-dev/celerix-store/pkg/version"
 )
 
-// MockStore implements CelerixStore for testing SDK helpers
-type MockStore struct {
-	data map[string]any
-}
-
-func (m *MockStore) Get(personaID, appID, key string) (any, error) {
-	return m.data[key], nil
-}
-func (m *MockStore) Set(personaID, appID, key string, val any) error {
-	m.data[key] = val
-	return nil
-}
-func (m *MockStore) Delete(personaID, appID, key string) error  { return nil }
-func (m *MockStore) GetPersonas() ([]string, error)             { return nil, nil }
-func (m *MockStore) GetApps(personaID string) ([]string, error) { return nil, nil }
-func (m *MockStore) GetAppStore(personaID, appID string) (map[string]any, error) {
-	return nil, nil
-}
-func (m *MockStore) DumpApp(appID string) (map[string]map[string]any, error) { return nil, nil }
-func (m *MockStore) GetGlobal(appID, key string) (any, string, error)        { return nil, "", nil }
-func (m *MockStore) Move(srcPersona, dstPersona, appID, key string) error    { return nil }
-func (m *MockStore) App(personaID, appID string) sdk.AppScope                { return nil }
-
 func TestGenericGetSet(t *testing.T) {
-	ms := &MockStore{data: make(map[string]any)}
+	ms := fake.NewStore()
 
 	type User struct {
 		Name string `json:"name"`
@@ -63,12 +58,13 @@ func TestGenericGetSet(t *testing.T) {
 
 func TestGenericGetWithJsonConversion(t *testing.T) {
 	// Simulate data coming from JSON (where it's map[string]any)
-	ms := &MockStore{data: map[string]any{
+	ms := fake.NewStore()
+	ms.Load(map[string]map[string]map[string]any{"p1": {"a1": {
 		"user1": map[string]any{
 			"name": "Bob",
 			"age":  float64(25), // JSON unmarshals numbers as float64
 		},
-	}}
+	}}})
 
 	type User struct {
 		Name string `json:"name"`
@@ -128,6 +124,20 @@ func TestClient_Integration(t *testing.T) {
 		t.Errorf("Client Get failed: %v, %v", val, err)
 	}
 
+	// Test Projection
+	err = client.Set("p1", "a1", "profile", map[string]any{"name": "Alice", "bio": "long text"})
+	if err != nil {
+		t.Fatalf("Client Set failed: %v", err)
+	}
+	projected, err := client.GetFields("p1", "a1", "profile", []string{"name"})
+	if err != nil || !reflect.DeepEqual(projected, map[string]any{"name": "Alice"}) {
+		t.Errorf("Client GetFields failed: %v, %v", projected, err)
+	}
+	dump, err := client.GetAppStoreFields("p1", "a1", []string{"bio"})
+	if err != nil || !reflect.DeepEqual(dump["profile"], map[string]any{"bio": "long text"}) {
+		t.Errorf("Client GetAppStoreFields failed: %v, %v", dump, err)
+	}
+
 	// Test App Scope
 	app := client.App("p1", "a1")
 	err = app.Set("k2", "v2")
@@ -142,10 +152,7 @@ func TestClient_Integration(t *testing.T) {
 
 	// Test Vault Scope
 	masterKey := []byte("thisis32byteslongsecretkey123456")
-	vault := app.Vault(masterKey).(interface {
-		Set(key string, plaintext string) error
-		Get(key string) (string, error)
-	})
+	vault := app.Vault(masterKey)
 
 	err = vault.Set("secret", "mypassword")
 	if err != nil {
@@ -156,6 +163,178 @@ func TestClient_Integration(t *testing.T) {
 	if err != nil || got != "mypassword" {
 		t.Errorf("Vault Get failed: %v, %v", got, err)
 	}
+
+	// Passphrase vaults store the salt with the value
+	if err := sdk.PassphraseVault(app, "correct horse").Set("token", "abc"); err != nil {
+		t.Fatalf("Passphrase vault Set failed: %v", err)
+	}
+	got, err = sdk.PassphraseVault(client.App("p1", "a1"), "correct horse").Get("token")
+	if err != nil || got != "abc" {
+		t.Errorf("Passphrase vault Get failed: %v, %v", got, err)
+	}
+
+	if err := vault.SetJSON("config", map[string]any{"retries": 3}); err != nil {
+		t.Fatalf("Vault SetJSON failed: %v", err)
+	}
+	var config struct{ Retries int }
+	if err := vault.GetJSON("config", &config); err != nil || config.Retries != 3 {
+		t.Errorf("Vault GetJSON failed: %+v, %v", config, err)
+	}
+}
+
+func TestClient_UnixSocket(t *testing.T) {
+	router := server.NewRouter(engine.NewMemStore(nil, nil))
+	cert, err := vault.GenerateSelfSignedCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.SetCertificate(cert) // Not used over the socket
+	addr := "unix://" + filepath.Join(t.TempDir(), "celerix.sock")
+	listener, err := server.Listen(addr)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go router.Serve(context.Background(), listener)
+	defer router.Stop()
+
+	client, err := sdk.Connect(addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+	if err := client.Set("p1", "a1", "k1", "v1"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if val, err := client.Get("p1", "a1", "k1"); err != nil || val != "v1" {
+		t.Errorf("Expected v1, got %v (%v)", val, err)
+	}
+}
+
+func TestClient_Interceptors(t *testing.T) {
+	srv := testutil.StartServer(t)
+	var trace []string
+	errBlocked := errors.New("blocked")
+	client := srv.Connect(t,
+		sdk.WithInterceptor(func(op sdk.Operation, next sdk.Invoker) error {
+			trace = append(trace, "outer "+op.Name)
+			return next(op)
+		}),
+		sdk.WithInterceptor(func(op sdk.Operation, next sdk.Invoker) error {
+			trace = append(trace, "inner "+op.Name)
+			if op.Name == "DEL" {
+				return errBlocked // Never sent
+			}
+			return next(op)
+		}),
+	)
+
+	trace = nil
+	if err := client.Set("p1", "a1", "k1", "v1"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if val, err := client.Get("p1", "a1", "k1"); err != nil || val != "v1" {
+		t.Errorf("Expected v1, got %v (%v)", val, err)
+	}
+	if err := client.Delete("p1", "a1", "k1"); !errors.Is(err, errBlocked) {
+		t.Errorf("Expected the interceptor's error, got %v", err)
+	}
+	if _, err := client.Get("p1", "a1", "k1"); err != nil {
+		t.Errorf("The blocked delete was sent: %v", err)
+	}
+	want := []string{"outer SET", "inner SET", "outer GET", "inner GET", "outer DEL", "inner DEL", "outer GET", "inner GET"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Errorf("Expected %v, got %v", want, trace)
+	}
+}
+
+// recordingTracer is an sdk.Tracer keeping its spans in memory.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	name, parent, id string
+	err              error
+	ended            bool
+	tracer           *recordingTracer
+}
+
+type spanKey struct{}
+
+func (t *recordingTracer) Start(ctx context.Context, name, traceparent string) (context.Context, sdk.Span) {
+	if parent, ok := ctx.Value(spanKey{}).(*recordedSpan); ok {
+		traceparent = parent.id
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := &recordedSpan{name: name, parent: traceparent, id: fmt.Sprintf("00-%032x-%016x-01", 1, len(t.spans)+1), tracer: t}
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *recordedSpan) SetAttribute(key, value string) {}
+func (s *recordedSpan) TraceParent() string            { return s.id }
+func (s *recordedSpan) End(err error) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.err, s.ended = err, true
+}
+
+// ended returns the span named name once it has ended.
+func (t *recordingTracer) ended(name string) *recordedSpan {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		t.mu.Lock()
+		for _, s := range t.spans {
+			if s.name == name && s.ended {
+				t.mu.Unlock()
+				return s
+			}
+		}
+		t.mu.Unlock()
+	}
+	return nil
+}
+
+func TestClient_Tracing(t *testing.T) {
+	tracer := &recordingTracer{}
+	router := server.NewRouter(engine.NewMemStore(nil, nil))
+	router.SetConfig(server.RouterConfig{Tracer: tracer})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go router.Serve(context.Background(), listener)
+	defer router.Stop()
+
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS(), sdk.WithTracer(tracer))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	ctx, root := tracer.Start(context.Background(), "request", "")
+	if err := client.WithContext(ctx).Set("p1", "a1", "k1", "v1"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := client.Get("p1", "a1", "missing"); !sdk.IsNotFound(err) {
+		t.Fatalf("Expected not found, got %v", err)
+	}
+
+	set := tracer.ended("celerix SET")
+	if set == nil || set.parent != root.TraceParent() {
+		t.Fatalf("Expected the SET span to be a child of the request, got %+v", set)
+	}
+	if served := tracer.ended("celerix.server SET"); served == nil || served.parent != set.id || served.err != nil {
+		t.Errorf("Expected the server span to be a child of the SET span, got %+v", served)
+	}
+	get := tracer.ended("celerix GET")
+	if get == nil || get.parent != "" || !sdk.IsNotFound(get.err) {
+		t.Errorf("Expected a root GET span recording not found, got %+v", get)
+	}
+	if served := tracer.ended("celerix.server GET"); served == nil || served.parent != get.id || !sdk.IsNotFound(served.err) {
+		t.Errorf("Expected the server GET span to record not found, got %+v", served)
+	}
 }
 
 func TestClient_RetryLogic(t *testing.T) {
@@ -189,3 +368,2192 @@ func TestClient_RetryLogic(t *testing.T) {
 	// We just want to see it doesn't panic.
 	client.Get("p1", "a1", "k1")
 }
+
+func TestProject(t *testing.T) {
+	val := map[string]any{
+		"name": "Alice",
+		"age":  float64(30),
+		"profile": map[string]any{
+			"email": "alice@example.com",
+			"phone": "555",
+		},
+	}
+
+	got := sdk.Project(val, sdk.ParseFields("name, profile.email,missing"))
+	want := map[string]any{
+		"name":    "Alice",
+		"profile": map[string]any{"email": "alice@example.com"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// A parent field selects the whole subtree and must not alias the source.
+	got = sdk.Project(val, []string{"profile.email", "profile"})
+	if !reflect.DeepEqual(got, map[string]any{"profile": val["profile"]}) {
+		t.Errorf("Unexpected parent projection: %v", got)
+	}
+	if len(val["profile"].(map[string]any)) != 2 {
+		t.Error("Projection mutated the source value")
+	}
+
+	// Structs are normalized through JSON.
+	type User struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+	got = sdk.Project(User{Name: "Bob", Age: 25}, []string{"age"})
+	if !reflect.DeepEqual(got, map[string]any{"age": float64(25)}) {
+		t.Errorf("Unexpected struct projection: %v", got)
+	}
+
+	if sdk.Project("scalar", []string{"name"}) != "scalar" {
+		t.Error("Scalars should be returned unchanged")
+	}
+}
+
+func TestRedactionPolicy(t *testing.T) {
+	policy, err := sdk.ParseRedactionPolicy("vault, billing/card_*,*/*/password,*/profile/keys.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []sdk.RedactionRule{{App: "billing", Key: "card_*"}, {App: "*", Key: "*", Field: "password"}, {App: "*", Key: "profile", Field: "keys.*"}}
+	if !policy.Vault || !reflect.DeepEqual(policy.Rules, want) {
+		t.Errorf("Unexpected policy %+v", policy)
+	}
+	for _, spec := range []string{"billing", "billing/", "/k1", "a1/k1/", "a1/[k1"} {
+		if _, err := sdk.ParseRedactionPolicy(spec); err == nil {
+			t.Errorf("Expected %q to be refused", spec)
+		}
+	}
+
+	store := engine.NewMemStore(nil, nil)
+	store.Set("p1", "billing", "card_visa", "4111")
+	store.Set("p1", "billing", "plan", "pro")
+	store.Set("p1", "users", "alice", map[string]any{"name": "Alice", "password": "hunter2"})
+	store.Set("p1", "users", "profile", map[string]any{"keys": map[string]any{"ssh": "ssh-ed25519", "gpg": "x"}, "theme": "dark"})
+	store.Set("p1", "users", "list", []any{map[string]any{"password": "a"}, "b"})
+	ciphertext, err := vault.Encrypt("s3cret", make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Set("p1", "secrets", "api", ciphertext)
+
+	exp, err := sdk.ExportPersona(store, "p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := policy.RedactExport(exp).Apps
+	wantApps := map[string]map[string]any{
+		"billing": {"card_visa": sdk.Redacted, "plan": "pro"},
+		"users": {
+			"alice":   map[string]any{"name": "Alice", "password": sdk.Redacted},
+			"profile": map[string]any{"keys": map[string]any{"ssh": sdk.Redacted, "gpg": sdk.Redacted}, "theme": "dark"},
+			"list":    []any{map[string]any{"password": sdk.Redacted}, "b"},
+		},
+		"secrets": {"api": sdk.Redacted},
+	}
+	if !reflect.DeepEqual(got, wantApps) {
+		t.Errorf("Expected %v, got %v", wantApps, got)
+	}
+	if exp.Apps["users"]["alice"].(map[string]any)["password"] != "hunter2" {
+		t.Error("Redaction changed the export it was given")
+	}
+	if val, _ := store.Get("p1", "users", "alice"); val.(map[string]any)["password"] != "hunter2" {
+		t.Error("Redaction changed the stored value")
+	}
+
+	var none *sdk.RedactionPolicy
+	if none.RedactValue("billing", "card_visa", "4111") != "4111" {
+		t.Error("Expected a nil policy to hide nothing")
+	}
+}
+
+func TestClient_Watch(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	router := server.NewRouter(store)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go router.HandleConnection(conn)
+		}
+	}()
+	defer listener.Close()
+
+	os.Setenv("CELERIX_DISABLE_TLS", "true")
+	defer os.Unsetenv("CELERIX_DISABLE_TLS")
+
+	client, err := sdk.Connect(listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := client.Watch(ctx, "p1", sdk.WatchAll, "")
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	// The watch stream runs on its own connection, so regular calls still work.
+	if err := client.Set("p1", "a1", "k1", map[string]any{"n": float64(1)}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	select {
+	case e := <-events:
+		if e.Op != sdk.OpSet || e.AppID != "a1" || e.Key != "k1" || !reflect.DeepEqual(e.Value, map[string]any{"n": float64(1)}) {
+			t.Errorf("Unexpected event: %+v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for event")
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("Expected channel to close after cancel")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Watch channel was not closed")
+	}
+}
+
+func TestClient_NegativeCache(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	router := server.NewRouter(store)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go router.HandleConnection(conn)
+		}
+	}()
+	defer listener.Close()
+
+	os.Setenv("CELERIX_DISABLE_TLS", "true")
+	defer os.Unsetenv("CELERIX_DISABLE_TLS")
+
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithNegativeCache(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	store.Set("p1", "a1", "other", "v")
+	if _, err := client.Get("p1", "a1", "k1"); err == nil {
+		t.Fatal("Expected a miss")
+	}
+
+	// A write by someone else is hidden until the entry expires...
+	store.Set("p1", "a1", "k1", "v1")
+	if _, err := client.Get("p1", "a1", "k1"); err != sdk.ErrKeyNotFound {
+		t.Errorf("Expected cached ErrKeyNotFound, got %v", err)
+	}
+
+	// ...but this client's own writes invalidate it immediately.
+	if err := client.Set("p1", "a1", "k1", "v2"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if val, err := client.Get("p1", "a1", "k1"); err != nil || val != "v2" {
+		t.Errorf("Expected v2, got %v, %v", val, err)
+	}
+}
+
+func TestSessionStore(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	sessions := sdk.NewSessionStore(store, "sessions", time.Minute)
+
+	sess, err := sessions.Create("p1", map[string]any{"ip": "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	got, err := sessions.Get(sess.ID)
+	if err != nil || got.PersonaID != "p1" {
+		t.Fatalf("Get failed: %+v, %v", got, err)
+	}
+
+	// Expire the session behind the store's back
+	got.ExpiresAt = time.Now().Add(-time.Second)
+	store.Set(sdk.SystemPersona, "sessions", sess.ID, got)
+	if _, err := sessions.Get(sess.ID); err != sdk.ErrSessionExpired {
+		t.Errorf("Expected ErrSessionExpired, got %v", err)
+	}
+	if _, err := sessions.Get(sess.ID); !sdk.IsNotFound(err) {
+		t.Errorf("Expected expired session to be deleted, got %v", err)
+	}
+}
+
+func TestPreferences(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	prefs := sdk.NewPreferences(store, "a1", map[string]any{
+		"theme":  "light",
+		"notify": map[string]any{"email": true, "push": false},
+	})
+
+	got, err := prefs.Load("p1")
+	if err != nil || got["theme"] != "light" {
+		t.Fatalf("Expected defaults, got %v, %v", got, err)
+	}
+
+	got, err = prefs.Update("p1", map[string]any{"notify": map[string]any{"push": true}})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	want := map[string]any{"theme": "light", "notify": map[string]any{"email": true, "push": true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	if err := prefs.Reset("p1"); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	got, _ = prefs.Load("p1")
+	if got["notify"].(map[string]any)["push"] != false {
+		t.Errorf("Expected defaults after reset, got %v", got)
+	}
+}
+
+func TestFeatureFlags(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	flags := sdk.NewFeatureFlags(store, "flags")
+
+	if on, err := flags.Enabled("p1", "missing"); err != nil || on {
+		t.Errorf("Expected undefined flag to be off, got %v, %v", on, err)
+	}
+
+	flags.Define("f", sdk.Flag{Enabled: true})
+	if on, _ := flags.Enabled("p1", "f"); !on {
+		t.Error("Expected flag to be on")
+	}
+
+	flags.Override("p1", "f", false)
+	if on, _ := flags.Enabled("p1", "f"); on {
+		t.Error("Expected override to turn flag off")
+	}
+	flags.ClearOverride("p1", "f")
+	if on, _ := flags.Enabled("p1", "f"); !on {
+		t.Error("Expected flag to follow definition after clearing override")
+	}
+
+	flags.Define("half", sdk.Flag{Enabled: true, Percent: 50})
+	enabled := 0
+	for i := 0; i < 200; i++ {
+		if on, _ := flags.Enabled(fmt.Sprintf("p%d", i), "half"); on {
+			enabled++
+		}
+	}
+	if enabled < 60 || enabled > 140 {
+		t.Errorf("Expected roughly half of personas enabled, got %d/200", enabled)
+	}
+}
+
+func TestClient_ServerVersion(t *testing.T) {
+	srv := testutil.StartServer(t)
+	if got := srv.Client.ServerVersion(); got.Version != version.Get().Version {
+		t.Errorf("Expected server version %q, got %+v", version.Get().Version, got)
+	}
+
+	// A daemon that predates VERSION ignores it and only answers the PING
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			switch scanner.Text() {
+			case "PING":
+				fmt.Fprintln(conn, "PONG")
+			case "LIST_PERSONAS":
+				fmt.Fprintln(conn, `OK ["p1"]`)
+			case "GET p1 a1 missing":
+				fmt.Fprintln(conn, "ERR key not found")
+			}
+		}
+	}()
+
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS())
+	if err != nil {
+		t.Fatalf("Failed to connect to old daemon: %v", err)
+	}
+	defer client.Close()
+	if got := client.ServerVersion(); got.Version != "" {
+		t.Errorf("Expected no version from old daemon, got %+v", got)
+	}
+	if personas, err := client.GetPersonas(); err != nil || len(personas) != 1 {
+		t.Errorf("Expected connection to stay usable, got %v, %v", personas, err)
+	}
+
+	// Without error codes, sentinels are still inferred from the message
+	if client.Protocol() != 1 {
+		t.Errorf("Expected protocol 1 with an old daemon, got %d", client.Protocol())
+	}
+	if _, err := client.Get("p1", "a1", "missing"); !errors.Is(err, sdk.ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound from old daemon, got %v", err)
+	}
+}
+
+func TestClient_ErrorCodes(t *testing.T) {
+	srv := testutil.StartServer(t)
+	client := srv.Client
+	if client.Protocol() != sdk.ProtocolVersion {
+		t.Fatalf("Expected protocol %d, got %d", sdk.ProtocolVersion, client.Protocol())
+	}
+
+	client.Set("p1", "a1", "k1", "v1")
+	_, err := client.Get("p1", "a1", "missing")
+	var perr *sdk.ProtocolError
+	if !errors.As(err, &perr) || perr.Status != 404 || perr.Code != sdk.CodeKeyNotFound {
+		t.Fatalf("Expected a 404 key_not_found error, got %#v", err)
+	}
+	if !errors.Is(err, sdk.ErrKeyNotFound) || !sdk.IsNotFound(err) || err.Error() != "key not found" {
+		t.Errorf("Expected error to match ErrKeyNotFound, got %v", err)
+	}
+	if _, err := client.Get("nobody", "a1", "k1"); !errors.Is(err, sdk.ErrPersonaNotFound) {
+		t.Errorf("Expected ErrPersonaNotFound, got %v", err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 25; i++ {
+		fmt.Fprintf(&input, `{"persona_id":"p%d","app_id":"a1","key":"k%d","value":%d}`+"\n", i%3, i, i)
+	}
+
+	store := engine.NewMemStore(nil, nil)
+	var checkpoints []int64
+	result, err := sdk.Import(store, strings.NewReader(input.String()), sdk.ImportOptions{
+		BatchSize:  10,
+		Checkpoint: func(n int64) { checkpoints = append(checkpoints, n) },
+	})
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if result.Records != 25 || result.Applied != 25 || !reflect.DeepEqual(checkpoints, []int64{10, 20, 25}) {
+		t.Errorf("Unexpected result %+v, checkpoints %v", result, checkpoints)
+	}
+	if val, _ := store.Get("p1", "a1", "k7"); val != float64(7) {
+		t.Errorf("Expected k7=7, got %v", val)
+	}
+
+	// A bad record stops the import at the last checkpoint, and Skip resumes from there
+	bad := input.String() + "not json\n"
+	result, err = sdk.Import(engine.NewMemStore(nil, nil), strings.NewReader(bad), sdk.ImportOptions{BatchSize: 10})
+	if err == nil || result.Records != 20 {
+		t.Errorf("Expected failure at checkpoint 20, got %+v, %v", result, err)
+	}
+	result, err = sdk.Import(store, strings.NewReader(input.String()), sdk.ImportOptions{Skip: 20})
+	if err != nil || result.Applied != 5 || result.Records != 25 {
+		t.Errorf("Expected resume to apply the last 5 records, got %+v, %v", result, err)
+	}
+}
+
+func TestClient_Import(t *testing.T) {
+	srv := testutil.StartServer(t)
+
+	var input strings.Builder
+	for i := 0; i < 2500; i++ {
+		fmt.Fprintf(&input, `{"persona_id":"p1","app_id":"a1","key":"k%d","value":"v%d"}`+"\n", i, i)
+	}
+
+	var checkpoints []int64
+	result, err := srv.Client.Import(context.Background(), strings.NewReader(input.String()), sdk.ImportOptions{
+		Checkpoint: func(n int64) { checkpoints = append(checkpoints, n) },
+	})
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if result.Applied != 2500 || !reflect.DeepEqual(checkpoints, []int64{1000, 2000, 2500}) {
+		t.Errorf("Unexpected result %+v, checkpoints %v", result, checkpoints)
+	}
+	if val, err := srv.Client.Get("p1", "a1", "k2499"); err != nil || val != "v2499" {
+		t.Errorf("Expected imported value, got %v, %v", val, err)
+	}
+
+	_, err = srv.Client.Import(context.Background(), strings.NewReader(`{"persona_id":"p1"}`), sdk.ImportOptions{})
+	if err == nil || !strings.Contains(err.Error(), "required") {
+		t.Errorf("Expected the daemon's validation error, got %v", err)
+	}
+	// The regular connection is unaffected by import streams
+	if _, err := srv.Client.GetPersonas(); err != nil {
+		t.Errorf("Client unusable after import: %v", err)
+	}
+}
+
+// downStore is a member whose daemon is unreachable.
+type downStore struct {
+	sdk.CelerixStore
+	calls int
+}
+
+func (d *downStore) Get(personaID, appID, key string) (any, error) {
+	d.calls++
+	return nil, fmt.Errorf("connection refused")
+}
+
+func (d *downStore) DumpApp(appID string) (map[string]map[string]any, error) {
+	d.calls++
+	return nil, fmt.Errorf("connection refused")
+}
+
+func TestMultiStore(t *testing.T) {
+	eu := engine.NewMemStore(nil, nil)
+	us := engine.NewMemStore(nil, nil)
+	eu.Set("alice", "a1", "theme", "dark")
+	eu.Set("shared", "a1", "k", "from-eu")
+	us.Set("bob", "a1", "theme", "light")
+	us.Set("shared", "a1", "k", "from-us")
+	us.Set("shared", "a1", "only-us", true)
+
+	multi := sdk.NewMultiStore(sdk.Member{Name: "eu", Store: eu}, sdk.Member{Name: "us", Store: us})
+	multi.SetWriteRouter(func(personaID string) string {
+		if personaID == "bob" {
+			return "us"
+		}
+		return "eu"
+	})
+
+	if val, err := multi.Get("bob", "a1", "theme"); err != nil || val != "light" {
+		t.Errorf("Expected bob's theme from us, got %v, %v", val, err)
+	}
+	if _, err := multi.Get("nobody", "a1", "theme"); !sdk.IsNotFound(err) {
+		t.Errorf("Expected not found, got %v", err)
+	}
+	if personas, _ := multi.GetPersonas(); !reflect.DeepEqual(personas, []string{"alice", "bob", "shared"}) {
+		t.Errorf("Expected union of personas, got %v", personas)
+	}
+
+	dump, err := multi.DumpApp("a1")
+	if err != nil {
+		t.Fatalf("DumpApp failed: %v", err)
+	}
+	if dump["shared"]["k"] != "from-eu" || dump["shared"]["only-us"] != true || dump["bob"]["theme"] != "light" {
+		t.Errorf("Unexpected merged dump: %v", dump)
+	}
+	if val, personaID, err := multi.GetGlobal("a1", "theme"); err != nil || personaID == "" || val == nil {
+		t.Errorf("GetGlobal failed: %v, %v, %v", val, personaID, err)
+	}
+
+	// Writes follow the router, and cross-member moves copy then delete
+	multi.Set("bob", "a1", "lang", "en")
+	if _, err := us.Get("bob", "a1", "lang"); err != nil {
+		t.Errorf("Expected bob's write to land in us: %v", err)
+	}
+	if err := multi.Move("bob", "alice", "a1", "lang"); err != nil {
+		t.Fatalf("Move failed: %v", err)
+	}
+	if val, _ := eu.Get("alice", "a1", "lang"); val != "en" {
+		t.Errorf("Expected moved key in eu, got %v", val)
+	}
+
+	// A failing member is skipped after repeated failures
+	down := &downStore{CelerixStore: engine.NewMemStore(nil, nil)}
+	multi = sdk.NewMultiStore(sdk.Member{Name: "eu", Store: eu}, sdk.Member{Name: "down", Store: down})
+	for i := 0; i < 5; i++ {
+		if val, err := multi.Get("alice", "a1", "theme"); err != nil || val != "dark" {
+			t.Fatalf("Expected reads to keep working, got %v, %v", val, err)
+		}
+	}
+	if down.calls != 3 {
+		t.Errorf("Expected the down member to be skipped after 3 failures, called %d times", down.calls)
+	}
+	health := multi.MemberHealth()
+	if !health[0].Healthy || health[1].Healthy || health[1].LastError != "connection refused" {
+		t.Errorf("Unexpected member health: %+v", health)
+	}
+}
+
+func TestExportPersona(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	store.Set("alice", "a1", "theme", "dark")
+	store.Set("alice", "a2", "lang", "en")
+	store.Set("bob", "a1", "theme", "light")
+
+	exp, err := sdk.ExportPersona(store, "alice")
+	if err != nil {
+		t.Fatalf("ExportPersona failed: %v", err)
+	}
+	if len(exp.Apps) != 2 || exp.Apps["a2"]["lang"] != "en" {
+		t.Fatalf("Unexpected export: %+v", exp)
+	}
+
+	identity, recipient, err := sdk.GenerateExportKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf strings.Builder
+	if err := sdk.WriteExport(&buf, exp, recipient); err != nil {
+		t.Fatalf("WriteExport failed: %v", err)
+	}
+	if strings.Contains(buf.String(), "dark") {
+		t.Fatal("Encrypted export contains plaintext")
+	}
+	if _, err := sdk.ReadExport(strings.NewReader(buf.String()), ""); err == nil {
+		t.Error("Expected reading an encrypted export without an identity to fail")
+	}
+	got, err := sdk.ReadExport(strings.NewReader(buf.String()), identity)
+	if err != nil {
+		t.Fatalf("ReadExport failed: %v", err)
+	}
+	if got.PersonaID != "alice" || got.Apps["a1"]["theme"] != "dark" {
+		t.Errorf("Unexpected decrypted export: %+v", got)
+	}
+}
+
+func TestExportFormats(t *testing.T) {
+	apps := map[string]map[string]any{
+		"a1": {
+			"name":    "Alice Smith",
+			"port":    float64(8080),
+			"port_s":  "8080",
+			"enabled": true,
+			"tags":    []any{"x", "y"},
+			"motd":    "line one\nsays \"hi\"",
+			"empty":   "",
+		},
+	}
+	exp := &sdk.PersonaExport{PersonaID: "alice", Apps: apps}
+
+	for _, format := range []string{sdk.FormatJSON, sdk.FormatYAML, sdk.FormatCSV, sdk.FormatEnv} {
+		var buf strings.Builder
+		if err := sdk.WriteExportFormat(&buf, exp, format); err != nil {
+			t.Fatalf("%s: WriteExportFormat failed: %v", format, err)
+		}
+		got, err := sdk.ReadExportFormat(strings.NewReader(buf.String()), format, "alice", "a1")
+		if err != nil {
+			t.Fatalf("%s: ReadExportFormat failed: %v\n%s", format, err, buf.String())
+		}
+		if !reflect.DeepEqual(got.Apps, apps) {
+			t.Errorf("%s: round trip changed the values: %v\n%s", format, got.Apps, buf.String())
+		}
+	}
+
+	env, err := sdk.ReadExportFormat(strings.NewReader("# comment\nexport HOST=db.local\nPORT='5432'\n"), sdk.FormatEnv, "alice", "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := engine.NewMemStore(nil, nil)
+	if n, err := sdk.ImportPersona(store, env); err != nil || n != 2 {
+		t.Fatalf("ImportPersona imported %d values: %v", n, err)
+	}
+	if val, _ := store.Get("alice", "db", "PORT"); val != float64(5432) {
+		t.Errorf("Expected PORT=5432, got %v", val)
+	}
+
+	two := &sdk.PersonaExport{PersonaID: "alice", Apps: map[string]map[string]any{"a1": {}, "a2": {}}}
+	if err := sdk.WriteExportFormat(io.Discard, two, sdk.FormatEnv); err == nil {
+		t.Error("Expected an env export of two apps to fail")
+	}
+}
+
+func TestShadowStore(t *testing.T) {
+	primary := engine.NewMemStore(nil, nil)
+	secondary := engine.NewMemStore(nil, nil)
+	var divergences []sdk.Divergence
+	shadow := sdk.NewShadowStore(primary, secondary, sdk.ShadowOptions{
+		Verify:       true,
+		CompareReads: 1,
+		OnDivergence: func(d sdk.Divergence) { divergences = append(divergences, d) },
+	})
+	defer shadow.Close()
+
+	shadow.Set("alice", "a1", "theme", "dark")
+	shadow.Set("alice", "a1", "tmp", 1)
+	shadow.Delete("alice", "a1", "tmp")
+	shadow.Move("alice", "bob", "a1", "theme")
+	shadow.Merge("alice", "a1", "prefs", map[string]any{"lang": "en"})
+	shadow.Flush()
+
+	if val, err := secondary.Get("bob", "a1", "theme"); err != nil || val != "dark" {
+		t.Errorf("Expected the move to be mirrored, got %v, %v", val, err)
+	}
+	if report := shadow.Report(); report.Mirrored != 5 || report.Diverged != 0 {
+		t.Fatalf("Unexpected report: %+v (%v)", report, divergences)
+	}
+
+	// A value only the shadow got wrong is reported on read
+	secondary.Set("alice", "a1", "prefs", "corrupted")
+	if _, err := shadow.Get("alice", "a1", "prefs"); err != nil {
+		t.Fatal(err)
+	}
+	shadow.Flush()
+	if len(divergences) != 1 || divergences[0].Kind != sdk.DivergenceMismatch || divergences[0].Shadow != "corrupted" {
+		t.Errorf("Expected one mismatch, got %+v", divergences)
+	}
+
+	stats, err := shadow.Stats()
+	if err != nil || stats.Shadow == nil || stats.Shadow.Diverged != 1 {
+		t.Errorf("Expected shadow traffic in stats, got %+v, %v", stats.Shadow, err)
+	}
+}
+
+func TestClient_Keepalive(t *testing.T) {
+	router := server.NewRouter(engine.NewMemStore(nil, nil))
+	router.SetConfig(server.RouterConfig{IdleTimeout: 300 * time.Millisecond})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go router.Serve(ctx, listener)
+
+	// Keepalives follow the idle timeout announced in HELLO
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+	time.Sleep(time.Second)
+	if err := client.Set("p1", "a1", "k1", "v1"); err != nil {
+		t.Fatalf("Set after idling failed: %v", err)
+	}
+	if accepted := router.ConnectionStats().Accepted; accepted != 1 {
+		t.Errorf("Expected the idle connection to be kept alive, got %d connections", accepted)
+	}
+
+	// Without keepalives the daemon closes the connection and the client
+	// reconnects, reporting the retry to its logger
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	quiet, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS(), sdk.WithKeepalive(-1), sdk.WithLogger(logger))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer quiet.Close()
+	time.Sleep(time.Second)
+	if _, err := quiet.Get("p1", "a1", "k1"); err != nil {
+		t.Fatalf("Get after reconnecting failed: %v", err)
+	}
+	if accepted := router.ConnectionStats().Accepted; accepted != 3 {
+		t.Errorf("Expected a reconnect, got %d connections", accepted)
+	}
+	if !strings.Contains(logs.String(), "level=WARN") || !strings.Contains(logs.String(), "attempt=1") {
+		t.Errorf("Expected the retry to be logged, got %q", logs.String())
+	}
+}
+
+// countingStore counts reads that reach the store.
+type countingStore struct {
+	sdk.CacheableStore
+	gets int
+}
+
+func (s *countingStore) Get(personaID, appID, key string) (any, error) {
+	s.gets++
+	return s.CacheableStore.Get(personaID, appID, key)
+}
+
+func TestBind(t *testing.T) {
+	type config struct {
+		Host    string   `json:"host"`
+		Timeout int      `json:"timeout"`
+		Tags    []string `json:"tags"`
+	}
+	store := engine.NewMemStore(nil, nil)
+	store.Set("svc", "config", "main", map[string]any{"host": "db.local"})
+
+	cfg := config{Host: "localhost", Timeout: 30}
+	changes := make(chan error, 10)
+	b, err := sdk.Bind(store.App("svc", "config"), "main", &cfg, func(_ config, err error) { changes <- err })
+	if err != nil {
+		t.Fatalf("Bind failed: %v", err)
+	}
+	defer b.Close()
+	if cfg.Host != "db.local" || cfg.Timeout != 30 {
+		t.Fatalf("Expected the stored value over the defaults, got %+v", cfg)
+	}
+
+	wait := func() error {
+		select {
+		case err := <-changes:
+			return err
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected onChange to be called")
+			return nil
+		}
+	}
+	store.Set("svc", "config", "other", "ignored")
+	store.Set("svc", "config", "main", map[string]any{"timeout": 5, "tags": []string{"a"}})
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	b.RLock()
+	if cfg.Host != "localhost" || cfg.Timeout != 5 || len(cfg.Tags) != 1 {
+		t.Errorf("Expected fields missing from the update to fall back to the defaults, got %+v", cfg)
+	}
+	b.RUnlock()
+
+	store.Set("svc", "config", "main", "not an object")
+	if err := wait(); err == nil {
+		t.Error("Expected a value that doesn't decode to be reported")
+	}
+	store.Delete("svc", "config", "main")
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	b.RLock()
+	if cfg.Host != "localhost" || cfg.Timeout != 30 || cfg.Tags != nil {
+		t.Errorf("Expected a deleted key to restore the defaults, got %+v", cfg)
+	}
+	b.RUnlock()
+}
+
+func TestCachedApp(t *testing.T) {
+	srv := testutil.StartServer(t)
+	srv.Client.Set("p1", "config", "theme", "dark")
+
+	store := &countingStore{CacheableStore: srv.Client}
+	app, err := sdk.NewCachedApp(store, "p1", "config", time.Minute)
+	if err != nil {
+		t.Fatalf("NewCachedApp failed: %v", err)
+	}
+	defer app.Close()
+
+	for i := 0; i < 3; i++ {
+		if val, err := app.Get("theme"); err != nil || val != "dark" {
+			t.Fatalf("Get failed: %v, %v", val, err)
+		}
+	}
+	if store.gets != 1 {
+		t.Errorf("Expected 1 read to reach the store, got %d", store.gets)
+	}
+
+	// Writes through the scope are read back at once
+	app.Set("theme", "light")
+	if val, _ := app.Get("theme"); val != "light" {
+		t.Errorf("Expected own write to be visible, got %v", val)
+	}
+
+	// Writes by other clients invalidate the cache through the change stream
+	other := srv.Connect(t)
+	other.Set("p1", "config", "theme", "solarized")
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		val, _ := app.Get("theme")
+		if val == "solarized" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the cached value to be invalidated, still %v", val)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClient_OfflineQueue(t *testing.T) {
+	// Reserve an address with nothing listening on it yet
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := probe.Addr().String()
+	probe.Close()
+
+	dir := t.TempDir()
+	queue, err := engine.Open(dir)
+	if err != nil {
+		t.Fatalf("Failed to open queue store: %v", err)
+	}
+	var conflicts []string
+	opts := sdk.OfflineOptions{
+		Store:         queue,
+		RetryInterval: time.Hour, // Replayed by hand below
+		OnConflict: func(w sdk.OfflineWrite, current any, exists bool) *sdk.OfflineWrite {
+			if exists && w.Key == "theme" {
+				conflicts = append(conflicts, fmt.Sprintf("%v<>%v", w.Value, current))
+				return nil // The daemon's value wins
+			}
+			return &w
+		},
+	}
+	logger := sdk.WithLogger(nil)
+
+	client, err := sdk.Connect(addr, sdk.WithoutTLS(), sdk.WithOfflineQueue(opts), logger)
+	if err != nil {
+		t.Fatalf("Connect in offline mode failed: %v", err)
+	}
+	if err := client.Set("p1", "a1", "k1", "offline"); err != nil {
+		t.Fatalf("Offline Set failed: %v", err)
+	}
+	client.Set("p1", "a1", "theme", "dark")
+	client.Delete("p1", "a1", "old")
+	if val, err := client.Get("p1", "a1", "k1"); err != nil || val != "offline" {
+		t.Errorf("Expected queued value, got %v, %v", val, err)
+	}
+	if _, err := client.Get("p1", "a1", "old"); !errors.Is(err, sdk.ErrKeyNotFound) {
+		t.Errorf("Expected queued delete to hide the key, got %v", err)
+	}
+	if _, err := client.Get("p1", "a1", "unknown"); !errors.Is(err, sdk.ErrOffline) {
+		t.Errorf("Expected ErrOffline for keys not in the queue, got %v", err)
+	}
+	if status := client.SyncStatus(); status.Online || status.Pending != 3 {
+		t.Errorf("Expected 3 pending writes while offline, got %+v", status)
+	}
+
+	// The queue survives restarts
+	client.Close()
+	if opts.Store, err = engine.Open(dir); err != nil {
+		t.Fatalf("Failed to reopen queue store: %v", err)
+	}
+	client, err = sdk.Connect(addr, sdk.WithoutTLS(), sdk.WithOfflineQueue(opts), logger)
+	if err != nil {
+		t.Fatalf("Reconnect in offline mode failed: %v", err)
+	}
+	defer client.Close()
+	if status := client.SyncStatus(); status.Pending != 3 {
+		t.Fatalf("Expected the queue to be reloaded, got %+v", status)
+	}
+
+	// The daemon comes back
+	store := engine.NewMemStore(map[string]map[string]map[string]any{
+		"p1": {"a1": {"theme": "light", "old": "stale"}},
+	}, nil)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("Address was taken in the meantime: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewRouter(store).Serve(ctx, listener)
+
+	if err := client.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if status := client.SyncStatus(); !status.Online || status.Pending != 0 || status.LastSync.IsZero() {
+		t.Errorf("Expected an empty queue after Flush, got %+v", status)
+	}
+	if val, _ := store.Get("p1", "a1", "k1"); val != "offline" {
+		t.Errorf("Expected queued Set to be replayed, got %v", val)
+	}
+	if _, err := store.Get("p1", "a1", "old"); err == nil {
+		t.Error("Expected queued Delete to be replayed")
+	}
+	if val, _ := store.Get("p1", "a1", "theme"); val != "light" || len(conflicts) != 1 || conflicts[0] != "dark<>light" {
+		t.Errorf("Expected the conflict hook to keep the daemon's value, got %v, %v", val, conflicts)
+	}
+}
+
+func TestRotateVault(t *testing.T) {
+	oldKey := []byte("thisis32byteslongsecretkey123456")
+	newKey := []byte("another32byteslongsecretkey65432")
+	store := engine.NewMemStore(nil, nil)
+	app := store.App("p1", "a1")
+	for _, k := range []string{"a", "b", "c"} {
+		if err := app.Vault(oldKey).Set(k, "secret-"+k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app.Set("plain", "not encrypted")
+	app.Set("count", 3)
+
+	// Resume after "a" as if an earlier run had stopped there
+	progress, err := sdk.RotateVault(store, "p1", "a1", oldKey, newKey, "a")
+	if err != nil {
+		t.Fatalf("RotateVault failed: %v", err)
+	}
+	if progress.Rotated != 2 || progress.Skipped != 2 || progress.LastKey != "plain" {
+		t.Errorf("Unexpected progress: %+v", progress)
+	}
+	if _, err := app.Vault(newKey).Get("a"); err == nil {
+		t.Error("Expected keys before resumeAfter to be left alone")
+	}
+
+	// Running again from the start only rotates what is left
+	progress, err = sdk.RotateVault(store, "p1", "a1", oldKey, newKey, "")
+	if err != nil || progress.Rotated != 1 || progress.Skipped != 4 {
+		t.Fatalf("Unexpected second run: %+v, %v", progress, err)
+	}
+	for _, k := range []string{"a", "b", "c"} {
+		if got, err := app.Vault(newKey).Get(k); err != nil || got != "secret-"+k {
+			t.Errorf("Expected %s under the new key, got %q, %v", k, got, err)
+		}
+	}
+
+	wrong := []byte("yetanother32byteslongsecretkey!!")
+	if _, err := sdk.RotateVault(store, "p1", "a1", wrong, wrong, ""); err == nil {
+		t.Error("Expected a vault value no key opens to stop the rotation")
+	}
+}
+
+// racingStore runs race, once, right after key is read, as if another client
+// wrote it then.
+type racingStore struct {
+	*engine.MemStore
+	key  string
+	race func()
+}
+
+func (s *racingStore) Get(personaID, appID, key string) (any, error) {
+	val, err := s.MemStore.Get(personaID, appID, key)
+	if race := s.race; race != nil && key == s.key {
+		s.race = nil
+		race()
+	}
+	return val, err
+}
+
+func TestRotateVault_ConcurrentWrite(t *testing.T) {
+	oldKey := []byte("thisis32byteslongsecretkey123456")
+	newKey := []byte("another32byteslongsecretkey65432")
+	store := &racingStore{MemStore: engine.NewMemStore(nil, nil), key: "k"}
+	app := store.App("p1", "a1")
+	app.Vault(oldKey).Set("k", "before")
+	store.race = func() { app.Vault(oldKey).Set("k", "during") }
+
+	progress, err := sdk.RotateVault(store, "p1", "a1", oldKey, newKey, "")
+	if err != nil || progress.Rotated != 1 {
+		t.Fatalf("Unexpected rotation: %+v, %v", progress, err)
+	}
+	if got, err := app.Vault(newKey).Get("k"); err != nil || got != "during" {
+		t.Errorf("Expected the concurrent write rotated, got %q, %v", got, err)
+	}
+}
+
+func TestClient_Blobs(t *testing.T) {
+	store, err := engine.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewRouter(store).Serve(ctx, listener)
+
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Several chunks, with bytes that would break JSON and line framing
+	data := bytes.Repeat([]byte("\x00\r\n0\nERR"), sdk.BlobChunkSize/3)
+	if n, err := client.SetBlob("p1", "a1", "file", bytes.NewReader(data)); err != nil || n != int64(len(data)) {
+		t.Fatalf("SetBlob failed: %d, %v", n, err)
+	}
+	blob, size, err := client.GetBlob("p1", "a1", "file")
+	if err != nil || size != int64(len(data)) {
+		t.Fatalf("GetBlob failed: %d, %v", size, err)
+	}
+	var got bytes.Buffer
+	if _, err := got.ReadFrom(blob); err != nil || !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("Blob round trip failed: %d bytes, %v", got.Len(), err)
+	}
+	blob.Close()
+
+	// The command connection is unaffected
+	if err := client.Set("p1", "a1", "k1", "v1"); err != nil {
+		t.Errorf("Set after blobs failed: %v", err)
+	}
+	if err := client.DeleteBlob("p1", "a1", "file"); err != nil {
+		t.Fatalf("DeleteBlob failed: %v", err)
+	}
+	if _, _, err := client.GetBlob("p1", "a1", "file"); !sdk.IsNotFound(err) {
+		t.Errorf("Expected a deleted blob to be missing, got %v", err)
+	}
+}
+
+func TestClient_Locks(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewRouter(engine.NewMemStore(nil, nil)).Serve(ctx, listener)
+
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	lease, err := client.Lock("jobs", "billing", "nightly", time.Minute)
+	if err != nil || lease.Token == "" {
+		t.Fatalf("Lock failed: %+v, %v", lease, err)
+	}
+	if _, err := client.Lock("jobs", "billing", "nightly", time.Minute); !errors.Is(err, sdk.ErrLockHeld) {
+		t.Errorf("Expected a held lock to be refused, got %v", err)
+	}
+	renewed, err := client.Renew(lease, 2*time.Minute)
+	if err != nil || !renewed.ExpiresAt.After(lease.ExpiresAt) {
+		t.Errorf("Renew failed: %+v, %v", renewed, err)
+	}
+	if err := client.Unlock(sdk.Lease{PersonaID: "jobs", AppID: "billing", Name: "nightly", Token: "forged"}); !errors.Is(err, sdk.ErrLeaseLost) {
+		t.Errorf("Expected a wrong token to be refused, got %v", err)
+	}
+	if err := client.Unlock(lease); err != nil {
+		t.Fatalf("Unlock failed: %v", err)
+	}
+
+	// An expired lease is free for the taking, and lost to its old holder
+	short, err := client.Lock("jobs", "billing", "nightly", 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitCtx, stop := context.WithTimeout(ctx, 2*time.Second)
+	defer stop()
+	next, err := sdk.AcquireLock(waitCtx, client, "jobs", "billing", "nightly", time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireLock failed: %v", err)
+	}
+	if _, err := client.Renew(short, time.Minute); !errors.Is(err, sdk.ErrLeaseLost) {
+		t.Errorf("Expected the expired lease to be lost, got %v", err)
+	}
+
+	keepCtx, release := context.WithCancel(ctx)
+	lost := sdk.KeepLease(keepCtx, client, next, 30*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	if _, err := client.Lock("jobs", "billing", "nightly", time.Minute); !errors.Is(err, sdk.ErrLockHeld) {
+		t.Errorf("Expected KeepLease to keep the lock, got %v", err)
+	}
+	release()
+	if err, ok := <-lost; ok {
+		t.Errorf("Expected KeepLease to stop cleanly, got %v", err)
+	}
+	if _, err := client.Lock("jobs", "billing", "nightly", time.Minute); err != nil {
+		t.Errorf("Expected KeepLease to release the lock, got %v", err)
+	}
+}
+
+func TestClient_Queue(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewRouter(engine.NewMemStore(nil, nil)).Serve(ctx, listener)
+
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	id, err := client.Enqueue("p1", "jobs", "emails", map[string]any{"to": "alice"})
+	if err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	msg, err := client.Dequeue("p1", "jobs", "emails", time.Minute)
+	if err != nil || msg.ID != id || msg.Item.(map[string]any)["to"] != "alice" {
+		t.Fatalf("Unexpected message %+v, %v", msg, err)
+	}
+	if _, err := client.Dequeue("p1", "jobs", "emails", time.Minute); !errors.Is(err, sdk.ErrQueueEmpty) {
+		t.Errorf("Expected the hidden message to leave the queue empty, got %v", err)
+	}
+	if err := client.Ack("p1", "jobs", "emails", msg.Receipt); err != nil {
+		t.Errorf("Ack failed: %v", err)
+	}
+	if err := client.Ack("p1", "jobs", "emails", msg.Receipt); !errors.Is(err, sdk.ErrReceiptExpired) {
+		t.Errorf("Expected a second ack to fail, got %v", err)
+	}
+}
+
+func TestClient_Namespace(t *testing.T) {
+	router := server.NewRouter(engine.NewMemStore(nil, nil))
+	staging := engine.NewMemStore(nil, nil)
+	router.AddNamespace("staging", staging, "s3cret")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go router.Serve(ctx, listener)
+
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := client.Namespace("staging"); !errors.Is(err, sdk.ErrUnauthorized) {
+		t.Errorf("Expected a missing token to be refused, got %v", err)
+	}
+	ns, err := client.Namespace("staging", sdk.WithToken("s3cret"))
+	if err != nil {
+		t.Fatalf("Namespace failed: %v", err)
+	}
+	defer ns.Close()
+
+	client.Set("p1", "a1", "k1", "default")
+	ns.Set("p1", "a1", "k1", "staging")
+	if val, _ := client.Get("p1", "a1", "k1"); val != "default" {
+		t.Errorf("Expected the default namespace to be untouched, got %v", val)
+	}
+	if val, _ := staging.Get("p1", "a1", "k1"); val != "staging" {
+		t.Errorf("Expected the namespaced write in the staging store, got %v", val)
+	}
+
+	// Streaming commands use their own connections, which must enter the namespace too
+	events, err := ns.Watch(ctx, "p1", "a1", "")
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	staging.Set("p1", "a1", "k2", "watched")
+	select {
+	case e := <-events:
+		if e.Key != "k2" {
+			t.Errorf("Unexpected event %+v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Expected an event from the namespace")
+	}
+}
+
+func TestSchema(t *testing.T) {
+	schema, err := sdk.CompileSchema([]byte(`{
+		"type": "object",
+		"required": ["theme"],
+		"properties": {
+			"theme": {"enum": ["light", "dark"]},
+			"font_size": {"type": "integer", "minimum": 8, "maximum": 32},
+			"tags": {"type": "array", "items": {"type": "string", "pattern": "^[a-z]+$"}, "maxItems": 3}
+		},
+		"additionalProperties": false
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type prefs struct {
+		Theme    string `json:"theme"`
+		FontSize int    `json:"font_size"`
+	}
+	for _, val := range []any{
+		map[string]any{"theme": "dark", "font_size": 12.0, "tags": []any{"a", "b"}},
+		prefs{Theme: "light", FontSize: 14},
+	} {
+		if err := schema.Validate(val); err != nil {
+			t.Errorf("Expected %v to match, got %v", val, err)
+		}
+	}
+
+	for val, want := range map[string]string{
+		`"dark"`:                               "value does not match schema: expected object, got string",
+		`{}`:                                   `value does not match schema: missing required property "theme"`,
+		`{"theme": "blue"}`:                    "value does not match schema at /theme: not one of the allowed values",
+		`{"theme": "dark", "font_size": 12.5}`: "value does not match schema at /font_size: expected integer, got number",
+		`{"theme": "dark", "font_size": 64}`:   "value does not match schema at /font_size: must be at most 32",
+		`{"theme": "dark", "tags": ["A"]}`:     `value does not match schema at /tags/0: does not match pattern "^[a-z]+$"`,
+		`{"theme": "dark", "color": "red"}`:    "value does not match schema at /color: no value is allowed",
+	} {
+		var v any
+		json.Unmarshal([]byte(val), &v)
+		err := schema.Validate(v)
+		if err == nil || err.Error() != want {
+			t.Errorf("%s: expected %q, got %v", val, want, err)
+		}
+		if !errors.Is(err, sdk.ErrBadRequest) {
+			t.Errorf("%s: expected a bad request, got %v", val, err)
+		}
+	}
+
+	for def, want := range map[string]string{
+		`{"type": "text"}`:                         `invalid schema at /type: unknown type "text"`,
+		`{"properties": {"a": {"minLength": -1}}}`: "invalid schema at /properties/a/minLength: must be a non-negative integer",
+		`{"$ref": "#/$defs/x"}`:                    "invalid schema at /$ref: references are not supported",
+	} {
+		if _, err := sdk.CompileSchema([]byte(def)); err == nil || err.Error() != want {
+			t.Errorf("%s: expected %q, got %v", def, want, err)
+		}
+	}
+}
+
+func TestUserStore(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	users := sdk.NewUserStore(store)
+
+	alice, code, err := users.Create("Alice", "Alice A.")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if code == "" || strings.Contains(alice.RecoveryCode, code) {
+		t.Errorf("Expected a recovery code stored only as a hash, got %q and %q", code, alice.RecoveryCode)
+	}
+	if _, _, err := users.Create("alice", ""); !errors.Is(err, sdk.ErrUsernameTaken) {
+		t.Errorf("Expected usernames to be unique regardless of case, got %v", err)
+	}
+	if got, err := users.Lookup("ALICE"); err != nil || got.ID != alice.ID {
+		t.Errorf("Lookup failed: %+v, %v", got, err)
+	}
+
+	if _, err := users.Recover("alice", "wrong"); !errors.Is(err, sdk.ErrWrongRecoveryCode) {
+		t.Errorf("Expected a wrong code to be rejected, got %v", err)
+	}
+	got, err := users.Recover("alice", strings.ToLower(code))
+	if err != nil || got.LastActive.IsZero() {
+		t.Errorf("Expected recovery to mark the user active, got %+v, %v", got, err)
+	}
+
+	// Sessions of a disabled user stop working
+	sessions := sdk.NewSessionStore(store, "sessions", time.Minute)
+	sessions.TrackUsers(users)
+	sess, _ := sessions.Create(alice.ID, nil)
+	if _, err := sessions.Get(sess.ID); err != nil {
+		t.Errorf("Expected the session to be live, got %v", err)
+	}
+	if _, err := users.SetDisabled(alice.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sessions.Get(sess.ID); !errors.Is(err, sdk.ErrUserDisabled) {
+		t.Errorf("Expected ErrUserDisabled, got %v", err)
+	}
+
+	if list, err := users.List(); err != nil || len(list) != 1 {
+		t.Errorf("Expected one user, got %v, %v", list, err)
+	}
+	if err := users.Delete(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := users.Create("alice", ""); err != nil {
+		t.Errorf("Expected the username to be free again, got %v", err)
+	}
+}
+
+func TestTokenStore(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	users := sdk.NewUserStore(store)
+	tokens := sdk.NewTokenStore(store, users, 0, 0)
+
+	alice, _, _ := users.Create("alice", "")
+	if _, err := tokens.Login("alice", "secret"); !errors.Is(err, sdk.ErrUnauthorized) {
+		t.Errorf("Expected users without a password to be refused, got %v", err)
+	}
+	if err := users.SetPassword(alice.ID, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.Login("alice", "wrong"); err != sdk.ErrWrongCredentials {
+		t.Errorf("Expected ErrWrongCredentials, got %v", err)
+	}
+	issued, err := tokens.Login("alice", "secret")
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if user, err := tokens.Verify(issued.AccessToken); err != nil || user.ID != alice.ID {
+		t.Errorf("Verify failed: %+v, %v", user, err)
+	}
+	if _, err := tokens.Verify(issued.RefreshToken); err != sdk.ErrInvalidToken {
+		t.Errorf("Expected a refresh token to be refused as an access token, got %v", err)
+	}
+	if _, err := store.Get(sdk.SystemPersona, sdk.TokensApp, issued.AccessToken); !sdk.IsNotFound(err) {
+		t.Errorf("Expected tokens to be stored only as hashes, got %v", err)
+	}
+
+	refreshed, err := tokens.Refresh(issued.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	if _, err := tokens.Refresh(issued.RefreshToken); err != sdk.ErrInvalidToken {
+		t.Errorf("Expected a used refresh token to be refused, got %v", err)
+	}
+	if err := tokens.Revoke(refreshed.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.Verify(refreshed.AccessToken); err != sdk.ErrInvalidToken {
+		t.Errorf("Expected a revoked token to be refused, got %v", err)
+	}
+
+	time.Sleep(time.Millisecond)
+	if err := tokens.RevokeUser(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokens.Verify(issued.AccessToken); err != sdk.ErrInvalidToken {
+		t.Errorf("Expected RevokeUser to revoke earlier tokens, got %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	if later, err := tokens.Login("alice", "secret"); err != nil {
+		t.Errorf("Login failed: %v", err)
+	} else if _, err := tokens.Verify(later.AccessToken); err != nil {
+		t.Errorf("Expected tokens issued after RevokeUser to work, got %v", err)
+	}
+}
+
+func TestClient_StreamApp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := engine.NewMemStore(nil, nil)
+	for _, p := range []string{"p3", "p1", "p2"} {
+		store.Set(p, "app", "owner", p)
+	}
+	go server.NewRouter(store).Serve(ctx, listener)
+
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var seen []string
+	err = client.StreamApp("app", "p1", func(personaID string, data map[string]any) error {
+		if data["owner"] != personaID {
+			t.Errorf("Unexpected data for %s: %v", personaID, data)
+		}
+		seen = append(seen, personaID)
+		return nil
+	})
+	if err != nil || strings.Join(seen, ",") != "p2,p3" {
+		t.Fatalf("Expected the personas after p1, got %v, %v", seen, err)
+	}
+
+	page, next, err := sdk.DumpAppPage(client, "app", "", 2)
+	if err != nil || len(page) != 2 || next != "p2" {
+		t.Fatalf("Expected a first page of two, got %v, %q, %v", page, next, err)
+	}
+	page, next, err = sdk.DumpAppPage(client, "app", next, 2)
+	if err != nil || len(page) != 1 || page["p3"] == nil || next != "" {
+		t.Errorf("Expected a last page with p3, got %v, %q, %v", page, next, err)
+	}
+
+	// The main connection is unaffected by the streams.
+	if _, err := client.Get("p1", "app", "owner"); err != nil {
+		t.Errorf("Get after streaming failed: %v", err)
+	}
+}
+
+func TestClient_MoveKey(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewRouter(engine.NewMemStore(nil, nil)).Serve(ctx, listener)
+
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.Set("p1", "inbox", "msg", "hi")
+	if err := client.MoveKey("p1", "inbox", "p1", "archive", "msg", "msg-2024"); err != nil {
+		t.Fatalf("MoveKey failed: %v", err)
+	}
+	if val, err := client.Get("p1", "archive", "msg-2024"); err != nil || val != "hi" {
+		t.Errorf("Expected the archived message, got %v, %v", val, err)
+	}
+	if err := client.MoveKey("p1", "inbox", "p1", "archive", "msg", "msg-2024"); !sdk.IsNotFound(err) {
+		t.Errorf("Expected moving a moved key to fail with not found, got %v", err)
+	}
+}
+
+func TestClient_MergePersona(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewRouter(engine.NewMemStore(nil, nil)).Serve(ctx, listener)
+
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.Set("old", "prefs", "theme", "dark")
+	client.Set("new", "prefs", "theme", "light")
+	if err := client.MergePersona("old", "new", sdk.MergeFailOnConflict); !errors.Is(err, sdk.ErrConflict) {
+		t.Fatalf("Expected a conflict over the wire, got %v", err)
+	}
+	if err := client.MergePersona("old", "new", "newest"); !errors.Is(err, sdk.ErrBadRequest) {
+		t.Errorf("Expected an unknown strategy to be refused, got %v", err)
+	}
+	if err := client.MergePersona("old", "new", sdk.MergePreferSrc); err != nil {
+		t.Fatalf("MergePersona failed: %v", err)
+	}
+	if val, err := client.Get("new", "prefs", "theme"); err != nil || val != "dark" {
+		t.Errorf("Expected the source's value, got %v, %v", val, err)
+	}
+	if _, err := client.Get("old", "prefs", "theme"); !sdk.IsNotFound(err) {
+		t.Errorf("Expected the source to be gone, got %v", err)
+	}
+}
+
+func TestClient_ExistsAndMGet(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewRouter(engine.NewMemStore(nil, nil)).Serve(ctx, listener)
+
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.Set("p1", "a1", "k1", "v1")
+	client.Set("p1", "a1", "k2", map[string]any{"n": 2.0})
+	if ok, err := client.Exists("p1", "a1", "k1"); err != nil || !ok {
+		t.Errorf("Expected k1 to exist, got %v, %v", ok, err)
+	}
+	if ok, err := client.Exists("p1", "a1", "missing"); err != nil || ok {
+		t.Errorf("Expected missing not to exist, got %v, %v", ok, err)
+	}
+
+	values, err := client.MGet("p1", "a1", []string{"k1", "k2", "missing"})
+	want := map[string]any{"k1": "v1", "k2": map[string]any{"n": 2.0}}
+	if err != nil || !reflect.DeepEqual(values, want) {
+		t.Errorf("Expected %v, got %v, %v", want, values, err)
+	}
+	if _, err := client.MGet("p1", "a1", []string{"bad key"}); err == nil {
+		t.Error("Expected an invalid key to be refused")
+	}
+}
+
+func TestClient_Prune(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewRouter(engine.NewMemStore(nil, nil)).Serve(ctx, listener)
+
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.Set("p1", "a1", "tmp:1", "a")
+	client.Set("p1", "a1", "tmp:2", "b")
+	client.Set("p1", "a1", "keep", map[string]any{"v": 1.0})
+
+	if err := client.DeleteIfEquals("p1", "a1", "keep", map[string]any{"v": 2.0}); !errors.Is(err, sdk.ErrConflict) {
+		t.Errorf("Expected a conflict, got %v", err)
+	}
+	if n, err := client.DeletePrefix("p1", "a1", "tmp:"); err != nil || n != 2 {
+		t.Errorf("Expected 2 keys deleted, got %d, %v", n, err)
+	}
+	if err := client.DeleteIfEquals("p1", "a1", "keep", map[string]any{"v": 1.0}); err != nil {
+		t.Errorf("Expected keep to be deleted, got %v", err)
+	}
+	if _, err := client.Get("p1", "a1", "keep"); !sdk.IsNotFound(err) {
+		t.Errorf("Expected keep to be gone, got %v", err)
+	}
+	if _, err := client.DeletePrefix("p1", "a1", ""); err == nil {
+		t.Error("Expected an empty prefix to be refused")
+	}
+}
+
+func TestClient_ConditionalWrites(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewRouter(engine.NewMemStore(nil, nil)).Serve(ctx, listener)
+
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var _ sdk.ConditionalWriter = client
+	if err := client.SetIfRevision("p1", "a1", "k", map[string]any{"v": 1.0}, ""); err != nil {
+		t.Fatalf("Expected a missing key to be created, got %v", err)
+	}
+	if err := client.SetIfRevision("p1", "a1", "k", "again", ""); !errors.Is(err, sdk.ErrConflict) {
+		t.Errorf("Expected a conflict creating an existing key, got %v", err)
+	}
+	rev := sdk.Revision(map[string]any{"v": 1.0})
+	if err := client.SetIfRevision("p1", "a1", "k", "new value", rev); err != nil {
+		t.Errorf("Expected the write at the current revision to succeed, got %v", err)
+	}
+	if err := client.SetIfRevision("p1", "a1", "k", "lost update", rev); !errors.Is(err, sdk.ErrConflict) {
+		t.Errorf("Expected a conflict at a stale revision, got %v", err)
+	}
+	if err := client.DeleteIfRevision("p1", "a1", "k", rev); !errors.Is(err, sdk.ErrConflict) {
+		t.Errorf("Expected a conflict deleting at a stale revision, got %v", err)
+	}
+	if err := client.DeleteIfRevision("p1", "a1", "k", sdk.Revision("new value")); err != nil {
+		t.Errorf("Expected the delete to succeed, got %v", err)
+	}
+	if _, err := client.Get("p1", "a1", "k"); !sdk.IsNotFound(err) {
+		t.Errorf("Expected k to be gone, got %v", err)
+	}
+}
+
+func TestClient_GetPersona(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewRouter(engine.NewMemStore(nil, nil)).Serve(ctx, listener)
+
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.Set("p1", "a1", "k1", "v1")
+	client.Set("p1", "a2", "k2", 2.0)
+	client.Set("p2", "a1", "k1", "other")
+
+	data, err := client.GetPersona("p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 2 || data["a1"]["k1"] != "v1" || data["a2"]["k2"] != 2.0 {
+		t.Errorf("Unexpected persona data: %v", data)
+	}
+	if data, err := client.GetPersona("nobody"); err != nil || len(data) != 0 {
+		t.Errorf("Expected no apps for an unknown persona, got %v, %v", data, err)
+	}
+
+	exp, err := sdk.ExportPersona(client, "p1")
+	if err != nil || len(exp.Apps) != 2 {
+		t.Errorf("Expected an export of 2 apps, got %v, %v", exp, err)
+	}
+}
+
+func TestClient_Metadata(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewRouter(engine.NewMemStore(nil, nil)).Serve(ctx, listener)
+
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.Set("p1", "a1", "k1", "v1")
+	client.Set("p1", "a2", "k1", "v1")
+	client.Set("p2", "a1", "k1", "v1")
+	if err := sdk.SetPersonaMeta(client, "p1", schema.Metadata{DisplayName: "Alice", Labels: map[string]string{"team": "ops"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sdk.SetAppMeta(client, "p1", "a1", schema.Metadata{Description: "Settings"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sdk.SetAppMeta(client, "p1", sdk.MetaApp, schema.Metadata{}); !errors.Is(err, sdk.ErrBadRequest) {
+		t.Errorf("Expected metadata on the metadata app to be refused, got %v", err)
+	}
+
+	first, err := sdk.GetPersonaMeta(client, "p1")
+	if err != nil || first.DisplayName != "Alice" || first.CreatedAt.IsZero() {
+		t.Fatalf("Expected Alice with a creation time, got %+v, %v", first, err)
+	}
+	sdk.SetPersonaMeta(client, "p1", schema.Metadata{DisplayName: "Alice B."})
+	if meta, _ := sdk.GetPersonaMeta(client, "p1"); !meta.CreatedAt.Equal(first.CreatedAt) || meta.Labels != nil {
+		t.Errorf("Expected the metadata replaced but the creation time kept, got %+v", meta)
+	}
+
+	personas, err := client.GetPersonasVerbose()
+	if err != nil || len(personas) != 2 {
+		t.Fatalf("Expected 2 personas, got %v, %v", personas, err)
+	}
+	for _, p := range personas {
+		if (p.ID == "p1") != (p.Meta != nil) {
+			t.Errorf("Expected only p1 to have metadata, got %s: %+v", p.ID, p.Meta)
+		}
+	}
+
+	apps, err := client.GetAppsVerbose("p1")
+	if err != nil || len(apps) != 2 {
+		t.Fatalf("Expected a1 and a2 without the metadata app, got %v, %v", apps, err)
+	}
+	for _, a := range apps {
+		if a.ID == "a1" && (a.Meta == nil || a.Meta.Description != "Settings") || a.ID == "a2" && a.Meta != nil {
+			t.Errorf("Unexpected metadata for %s: %+v", a.ID, a.Meta)
+		}
+	}
+}
+
+func TestClient_SetBatch(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewRouter(engine.NewMemStore(nil, nil)).Serve(ctx, listener)
+
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := client.Get("p1", "a1", "k1"); !sdk.IsNotFound(err) {
+		t.Fatalf("Expected k1 to be missing, got %v", err)
+	}
+	err = client.SetBatch([]sdk.Record{
+		{PersonaID: "p1", AppID: "a1", Key: "k1", Value: "v1"},
+		{PersonaID: "p2", AppID: "a1", Key: "k1", Value: 2.0},
+	})
+	if err != nil {
+		t.Fatalf("SetBatch failed: %v", err)
+	}
+	// The earlier miss must not hide the batch's write.
+	if val, err := client.Get("p1", "a1", "k1"); err != nil || val != "v1" {
+		t.Errorf("Expected v1, got %v, %v", val, err)
+	}
+	if val, err := client.Get("p2", "a1", "k1"); err != nil || val != 2.0 {
+		t.Errorf("Expected 2, got %v, %v", val, err)
+	}
+	if err := client.SetBatch([]sdk.Record{{PersonaID: "p1", AppID: "a1", Key: "bad key"}}); !errors.Is(err, sdk.ErrBadRequest) {
+		t.Errorf("Expected an invalid key to be refused, got %v", err)
+	}
+}
+
+func TestClient_ReadPreference(t *testing.T) {
+	serve := func(store *engine.MemStore) string {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		go server.NewRouter(store).Serve(ctx, listener)
+		return listener.Addr().String()
+	}
+	primary, replica := engine.NewMemStore(nil, nil), engine.NewMemStore(nil, nil)
+	primaryAddr, replicaAddr := serve(primary), serve(replica)
+	replica.Set("p1", "a1", "k1", "from replica")
+
+	client, err := sdk.Connect(primaryAddr, sdk.WithoutTLS(), sdk.WithReplicas(replicaAddr), sdk.WithReadPreference(sdk.ReadReplica))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.Set("p1", "a1", "k1", "from primary"); err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := primary.Get("p1", "a1", "k1"); val != "from primary" {
+		t.Errorf("Expected the write on the primary, got %v", val)
+	}
+	if val, err := client.Get("p1", "a1", "k1"); err != nil || val != "from replica" {
+		t.Errorf("Expected the read from the replica, got %v, %v", val, err)
+	}
+
+	// A replica that can't be reached fails over to the primary.
+	dead, _ := net.Listen("tcp", "127.0.0.1:0")
+	deadAddr := dead.Addr().String()
+	dead.Close()
+	client, err = sdk.Connect(primaryAddr, sdk.WithoutTLS(), sdk.WithReplicas(deadAddr), sdk.WithReadPreference(sdk.ReadReplica), sdk.WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if val, err := client.Get("p1", "a1", "k1"); err != nil || val != "from primary" {
+		t.Errorf("Expected the read from the primary, got %v, %v", val, err)
+	}
+}
+
+func TestCluster(t *testing.T) {
+	nodes := map[string]*engine.MemStore{}
+	members := func(names ...string) []sdk.Member {
+		var list []sdk.Member
+		for _, name := range names {
+			if nodes[name] == nil {
+				nodes[name] = engine.NewMemStore(nil, nil)
+			}
+			list = append(list, sdk.Member{Name: name, Store: nodes[name]})
+		}
+		return list
+	}
+
+	cluster := sdk.NewCluster(members("n1", "n2", "n3")...)
+	for i := 0; i < 200; i++ {
+		if err := cluster.Set(fmt.Sprintf("user%d", i), "prefs", "theme", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, node := range nodes {
+		personas, _ := node.GetPersonas()
+		if len(personas) == 0 {
+			t.Errorf("Expected %s to hold some personas", name)
+		}
+		for _, p := range personas {
+			if owner := cluster.Owner(p); owner != name {
+				t.Errorf("%s is on %s but owned by %s", p, name, owner)
+			}
+		}
+	}
+
+	// Adding a node only moves personas to it.
+	grown := sdk.NewCluster(members("n1", "n2", "n3", "n4")...)
+	misplaced := 0
+	for _, st := range grown.Status() {
+		if st.Error != "" || st.Health != sdk.HealthOK {
+			t.Errorf("Expected %s to be healthy, got %+v", st.Name, st)
+		}
+		misplaced += st.Misplaced
+	}
+	moves, err := grown.Rebalance(false, nil)
+	if err != nil {
+		t.Fatalf("Rebalance failed: %v", err)
+	}
+	if len(moves) == 0 || len(moves) > 100 {
+		t.Errorf("Expected about a quarter of the personas to move, got %d", len(moves))
+	}
+	if misplaced != len(moves) {
+		t.Errorf("Expected Status to report the %d personas to move, got %d", len(moves), misplaced)
+	}
+	for _, m := range moves {
+		if m.To != "n4" || m.Keys != 1 {
+			t.Errorf("Unexpected move %+v", m)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		if val, err := grown.Get(fmt.Sprintf("user%d", i), "prefs", "theme"); err != nil || val != i {
+			t.Errorf("user%d: expected %d, got %v, %v", i, i, val, err)
+		}
+	}
+	if again, _ := grown.Rebalance(false, nil); len(again) != 0 {
+		t.Errorf("Expected nothing left to move, got %v", again)
+	}
+	if all, _ := grown.GetPersonas(); len(all) != 200 {
+		t.Errorf("Expected 200 personas across the cluster, got %d", len(all))
+	}
+	for _, st := range grown.Status() {
+		if st.Misplaced != 0 || st.Personas == 0 {
+			t.Errorf("Expected %s to hold only its own personas, got %+v", st.Name, st)
+		}
+	}
+
+	// Draining a node moves its personas to the others.
+	if _, err := grown.Drain("n5", false, nil); err == nil {
+		t.Error("Expected draining an unknown node to fail")
+	}
+	drained, err := grown.Drain("n2", false, nil)
+	if err != nil || len(drained) == 0 {
+		t.Fatalf("Drain failed: %v, %v", drained, err)
+	}
+	shrunk := sdk.NewCluster(members("n1", "n3", "n4")...)
+	for i := 0; i < 200; i++ {
+		if val, err := shrunk.Get(fmt.Sprintf("user%d", i), "prefs", "theme"); err != nil || val != i {
+			t.Errorf("user%d: expected %d after the drain, got %v, %v", i, i, val, err)
+		}
+	}
+	dump, _ := nodes["n2"].DumpApp("prefs")
+	for p, data := range dump {
+		if len(data) != 0 {
+			t.Errorf("Expected n2 to be empty, found %s: %v", p, data)
+		}
+	}
+}
+
+func TestClient_PreciseNumbers(t *testing.T) {
+	dir := t.TempDir()
+	store, err := engine.Open(dir, engine.WithPreciseNumbers())
+	if err != nil {
+		t.Fatalf("Failed to open the store: %v", err)
+	}
+	router := server.NewRouter(store)
+	router.SetConfig(server.RouterConfig{PreciseNumbers: true})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go router.Serve(context.Background(), listener)
+	defer router.Stop()
+
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS(), sdk.WithPreciseNumbers())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	const id = int64(9007199254740993) // 2^53 + 1, which a float64 can't hold
+	if err := client.Set("p1", "a1", "order", map[string]any{"id": id}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	val, err := client.Get("p1", "a1", "order")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if n, ok := val.(map[string]any)["id"].(json.Number); !ok || n.String() != "9007199254740993" {
+		t.Errorf("Expected the exact ID, got %#v", val)
+	}
+
+	// The ID also survives a restart
+	store.Close()
+	reopened, err := engine.Open(dir, engine.WithPreciseNumbers())
+	if err != nil {
+		t.Fatalf("Failed to reopen the store: %v", err)
+	}
+	defer reopened.Close()
+	order, _ := reopened.Get("p1", "a1", "order")
+	if n, ok := order.(map[string]any)["id"].(json.Number); !ok || n.String() != "9007199254740993" {
+		t.Errorf("Expected the exact ID after a restart, got %#v", order)
+	}
+}
+
+// lossyConn loses the daemon's answers while drop is above zero, closing the
+// connection after the command was run, and counts the SETs it receives.
+type lossyConn struct {
+	net.Conn
+	drop *atomic.Int32
+	sets *atomic.Int32
+}
+
+func (c lossyConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.sets.Add(int32(bytes.Count(p[:n], []byte("SET "))))
+	return n, err
+}
+
+func (c lossyConn) Write(p []byte) (int, error) {
+	if c.drop.Add(-1) >= 0 {
+		c.Conn.Close()
+		return 0, net.ErrClosed
+	}
+	c.drop.Store(0)
+	return c.Conn.Write(p)
+}
+
+func TestClient_RetryOnlyIdempotent(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	router := server.NewRouter(store)
+	// Without request IDs, which the daemon doesn't take when it doesn't
+	// deduplicate them
+	router.SetConfig(server.RouterConfig{DedupWindow: -1})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var drop, sets atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go router.HandleConnection(lossyConn{conn, &drop, &sets})
+		}
+	}()
+
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS(), sdk.WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// A SET whose answer is lost is applied once and reported as ambiguous.
+	drop.Store(1)
+	err = client.Set("p1", "a1", "k", "v1")
+	if !sdk.IsAmbiguous(err) || !sdk.IsRetryable(err) {
+		t.Errorf("Expected an ambiguous, retryable error, got %v", err)
+	}
+	if n := sets.Load(); n != 1 {
+		t.Errorf("Expected the SET to be sent once, got %d", n)
+	}
+	if val, err := store.Get("p1", "a1", "k"); err != nil || val != "v1" {
+		t.Errorf("Expected the SET to be applied, got %v, %v", val, err)
+	}
+
+	// Reads are resent.
+	drop.Store(1)
+	if val, err := client.Get("p1", "a1", "k"); err != nil || val != "v1" {
+		t.Errorf("Expected the GET to be retried, got %v, %v", val, err)
+	}
+
+	// Answers from the daemon are never retried, nor retryable.
+	if _, err := client.Get("p1", "a1", "missing"); !errors.Is(err, sdk.ErrKeyNotFound) || sdk.IsRetryable(err) {
+		t.Errorf("Expected a non-retryable ErrKeyNotFound, got %v", err)
+	}
+
+	// Unless the caller opts in to resending writes.
+	retrying, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS(), sdk.WithLogger(nil), sdk.WithRetryWrites())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer retrying.Close()
+	sets.Store(0)
+	drop.Store(1)
+	if err := retrying.Set("p1", "a1", "k", "v2"); err != nil {
+		t.Errorf("Expected the SET to be retried, got %v", err)
+	}
+	if n := sets.Load(); n != 2 {
+		t.Errorf("Expected the SET to be sent twice, got %d", n)
+	}
+}
+
+func TestClient_RequestIDs(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	router := server.NewRouter(store)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var drop, sets atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go router.HandleConnection(lossyConn{conn, &drop, &sets})
+		}
+	}()
+
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS(), sdk.WithLogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Set("p1", "a1", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Run again, the MOVE would fail as the key is gone from p1.
+	drop.Store(1)
+	if err := client.Move("p1", "p2", "a1", "k"); err != nil {
+		t.Errorf("Expected the retried MOVE to be answered like the first attempt, got %v", err)
+	}
+	if val, err := store.Get("p2", "a1", "k"); err != nil || val != "v" {
+		t.Errorf("Expected k in p2, got %v, %v", val, err)
+	}
+
+	// Without losing the answer, a write runs every time it is sent.
+	if err := client.Move("p1", "p2", "a1", "k"); !sdk.IsNotFound(err) {
+		t.Errorf("Expected a second MOVE to find nothing to move, got %v", err)
+	}
+}
+
+// countingConn counts the bytes the daemon writes.
+type countingConn struct {
+	net.Conn
+	written *atomic.Int64
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	c.written.Add(int64(len(p)))
+	return c.Conn.Write(p)
+}
+
+func TestClient_Compression(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	router := server.NewRouter(store)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var written atomic.Int64
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go router.HandleConnection(countingConn{conn, &written})
+		}
+	}()
+
+	dump := func(opts ...sdk.ClientOption) (map[string]any, int64) {
+		client, err := sdk.Connect(listener.Addr().String(), append(opts, sdk.WithoutTLS())...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		big := map[string]any{"text": strings.Repeat("all work and no play ", 500)}
+		if err := client.Set("p1", "a1", "big", big); err != nil {
+			t.Fatal(err)
+		}
+		before := written.Load()
+		data, err := client.GetAppStore("p1", "a1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data, written.Load() - before
+	}
+
+	plain, plainSize := dump()
+	compressed, compressedSize := dump(sdk.WithCompression())
+	if !reflect.DeepEqual(plain, compressed) {
+		t.Errorf("Expected the same dump with compression, got %v", compressed)
+	}
+	if compressedSize*4 > plainSize {
+		t.Errorf("Expected the dump to shrink, got %d bytes instead of %d", compressedSize, plainSize)
+	}
+}
+
+func TestClient_Search(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	store.Set("alice", "billing", "stripe_token", "x")
+	store.Set("alice", "github", "api_token", "y")
+	store.Set("bob", "billing", "stripe_token", "z")
+	store.Set("bob", "prefs", "theme", "dark")
+
+	// The router falls back to listing apps for stores that aren't a Searcher.
+	for name, served := range map[string]sdk.CelerixStore{
+		"searcher": store,
+		"fallback": struct{ sdk.CelerixStore }{store},
+	} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		go server.NewRouter(served).Serve(ctx, listener)
+		client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := client.Search("*_token", sdk.SearchOptions{Limit: 2})
+		if err != nil || len(res.Hits) != 2 || res.Next != "alice/github/api_token" {
+			t.Errorf("%s: expected the first page of 2 hits, got %+v, %v", name, res, err)
+		}
+		res, err = client.Search("*_token", sdk.SearchOptions{Limit: 2, After: res.Next})
+		if err != nil || len(res.Hits) != 1 || res.Hits[0] != (sdk.SearchHit{PersonaID: "bob", AppID: "billing", Key: "stripe_token"}) || res.Next != "" {
+			t.Errorf("%s: expected the last page, got %+v, %v", name, res, err)
+		}
+		res, err = client.Search("*", sdk.SearchOptions{App: "prefs"})
+		if err != nil || len(res.Hits) != 1 || res.Hits[0].Key != "theme" {
+			t.Errorf("%s: expected the prefs app only, got %+v, %v", name, res, err)
+		}
+		client.Close()
+		cancel()
+	}
+}
+
+func TestClient_Usage(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	store.Set("alice", "billing", "plan", "pro")
+	store.Set("alice", "billing", "seats", 5)
+	store.Set("bob", "prefs", "theme", "dark")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.NewRouter(store).Serve(ctx, listener)
+	client, err := sdk.Connect(listener.Addr().String(), sdk.WithoutTLS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	want, _ := store.Usage()
+	report, err := client.Usage()
+	if err != nil || !reflect.DeepEqual(report, want) {
+		t.Errorf("Expected %+v, got %+v, %v", want, report, err)
+	}
+
+	// Stores without a UsageReporter are sized by their JSON encoding.
+	report, err = sdk.Usage(struct{ sdk.CelerixStore }{store})
+	wantApps := []sdk.AppUsage{
+		{PersonaID: "alice", AppID: "billing", Keys: 2, Bytes: int64(len("plan") + len(`"pro"`) + len("seats") + len("5"))},
+		{PersonaID: "bob", AppID: "prefs", Keys: 1, Bytes: int64(len("theme") + len(`"dark"`))},
+	}
+	if err != nil || !reflect.DeepEqual(report.Apps, wantApps) || report.Keys != 3 {
+		t.Errorf("Expected %+v, got %+v, %v", wantApps, report, err)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	const key = "cxk_0123456789abcdef_00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff"
+	seed, err := sdk.ReadSeed(strings.NewReader(`
+personas:
+  acme:
+    _meta:
+      _persona: {display_name: Acme Corp}
+    billing:
+      plan: pro
+      seats: 12
+users:
+  - username: dev
+    password: hunter2
+    apps:
+      prefs: {theme: dark}
+api_keys:
+  - name: ci
+    scopes: [write]
+    key: `+key+`
+`), sdk.FormatYAML, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := engine.NewMemStore(nil, nil)
+	result, err := sdk.ApplySeed(store, seed)
+	if err != nil || result != (sdk.SeedResult{Records: 4, Users: 1, APIKeys: 1}) {
+		t.Fatalf("Expected 4 records, a user and a key, got %+v, %v", result, err)
+	}
+	if seats, err := store.Get("acme", "billing", "seats"); err != nil || seats != float64(12) {
+		t.Errorf("Expected 12 seats, got %v, %v", seats, err)
+	}
+	if meta, err := sdk.GetPersonaMeta(store, "acme"); err != nil || meta.DisplayName != "Acme Corp" {
+		t.Errorf("Expected the persona's metadata, got %+v, %v", meta, err)
+	}
+	user, err := sdk.NewUserStore(store).Authenticate("dev", "hunter2")
+	if err != nil {
+		t.Fatalf("Expected the seeded user to log in, got %v", err)
+	}
+	if theme, err := store.Get(user.ID, "prefs", "theme"); err != nil || theme != "dark" {
+		t.Errorf("Expected the user's persona to be seeded, got %v, %v", theme, err)
+	}
+	rec, err := sdk.NewAPIKeyStore(store).Verify(key)
+	if err != nil || rec.Name != "ci" || !sdk.HasScope(rec, sdk.ScopeWrite) {
+		t.Errorf("Expected the seeded key to be accepted, got %+v, %v", rec, err)
+	}
+
+	// Seeding twice fails on the existing user
+	if _, err := sdk.ApplySeed(store, seed); !errors.Is(err, sdk.ErrUsernameTaken) {
+		t.Errorf("Expected ErrUsernameTaken, got %v", err)
+	}
+	if _, err := sdk.ReadSeed(strings.NewReader(`{"persona": {}}`), sdk.FormatJSON, false); err == nil {
+		t.Error("Expected a misspelt section to be refused")
+	}
+	if _, err := sdk.NewAPIKeyStore(store).Add("cxk_abc_short", "weak", []string{sdk.ScopeRead}); !errors.Is(err, sdk.ErrBadRequest) {
+		t.Errorf("Expected a short secret to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Limits on the hits of one Search page.
+const (
+	DefaultSearchLimit = 100
+	MaxSearchLimit     = 1000
+)
+
+// SearchHit is where a key matching a search was found.
+type SearchHit struct {
+	PersonaID string `json:"persona"`
+	AppID     string `json:"app"`
+	Key       string `json:"key"`
+}
+
+// cursor renders the hit as a Search cursor. IDs can't contain '/'.
+func (h SearchHit) cursor() string {
+	return h.PersonaID + "/" + h.AppID + "/" + h.Key
+}
+
+// SearchResult is a page of hits, sorted by persona, app and key, with the
+// cursor to pass as After for the next page, which is empty on the last.
+type SearchResult struct {
+	Hits []SearchHit `json:"hits"`
+	Next string      `json:"next,omitempty"`
+}
+
+// SearchOptions narrows a Search.
+type SearchOptions struct {
+	App   string // Only search apps matching this pattern; empty for all
+	Limit int    // Hits per page, up to MaxSearchLimit (default DefaultSearchLimit)
+	After string // The Next of the previous page
+}
+
+// Searcher finds keys by pattern across all personas and apps, without
+// copying their values. It is optional: use Search, which falls back to
+// listing every app of every persona.
+type Searcher interface {
+	Search(keyPattern string, opts SearchOptions) (SearchResult, error)
+}
+
+// Search finds the keys matching keyPattern across all personas and apps of
+// s, e.g. to audit where a credential or setting is stored. Patterns are
+// those of path.Match: "*token*" or "db_?_url". Only locations are returned,
+// not values.
+func Search(s CelerixStore, keyPattern string, opts SearchOptions) (SearchResult, error) {
+	if sr, ok := s.(Searcher); ok {
+		return sr.Search(keyPattern, opts)
+	}
+	search, err := NewKeySearch(keyPattern, opts)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	personas, err := s.GetPersonas()
+	if err != nil {
+		return SearchResult{}, err
+	}
+	sort.Strings(personas)
+	for _, personaID := range personas {
+		if !search.WantsPersona(personaID) {
+			continue
+		}
+		apps, err := s.GetApps(personaID)
+		if err != nil {
+			return SearchResult{}, err
+		}
+		sort.Strings(apps)
+		for _, appID := range apps {
+			if !search.WantsApp(personaID, appID) {
+				continue
+			}
+			data, err := s.GetAppStore(personaID, appID)
+			if IsNotFound(err) {
+				continue // Deleted meanwhile
+			}
+			if err != nil {
+				return SearchResult{}, err
+			}
+			if !search.Add(personaID, appID, slices.Sorted(maps.Keys(data))) {
+				return search.Result(), nil
+			}
+		}
+	}
+	return search.Result(), nil
+}
+
+// KeySearch collects the hits of one Search page, for Searcher
+// implementations. Personas, apps and keys must be offered in order.
+type KeySearch struct {
+	pattern, app string
+	limit        int
+	after        [3]string
+	hits         []SearchHit
+	full         bool
+}
+
+// NewKeySearch checks the patterns and cursor of a search.
+func NewKeySearch(keyPattern string, opts SearchOptions) (*KeySearch, error) {
+	if keyPattern == "" {
+		return nil, fmt.Errorf("empty key pattern: %w", ErrBadRequest)
+	}
+	for _, p := range []string{keyPattern, opts.App} {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, ErrBadRequest)
+		}
+	}
+	s := &KeySearch{pattern: keyPattern, app: opts.App, limit: opts.Limit}
+	if s.limit <= 0 {
+		s.limit = DefaultSearchLimit
+	}
+	s.limit = min(s.limit, MaxSearchLimit)
+	if opts.After != "" {
+		parts := strings.Split(opts.After, "/")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid cursor %q: %w", opts.After, ErrBadRequest)
+		}
+		copy(s.after[:], parts)
+	}
+	return s, nil
+}
+
+// WantsPersona reports whether a persona may hold hits for this page.
+func (s *KeySearch) WantsPersona(personaID string) bool {
+	return !s.full && personaID >= s.after[0]
+}
+
+// WantsApp reports whether an app may hold hits for this page.
+func (s *KeySearch) WantsApp(personaID, appID string) bool {
+	if s.full || personaID == s.after[0] && appID < s.after[1] {
+		return false
+	}
+	if s.app == "" {
+		return true
+	}
+	ok, _ := path.Match(s.app, appID)
+	return ok
+}
+
+// Add offers the sorted keys of an app and reports whether the page has room
+// for more.
+func (s *KeySearch) Add(personaID, appID string, keys []string) bool {
+	for _, key := range keys {
+		h := SearchHit{personaID, appID, key}
+		if s.after[0] != "" && !comesAfter(h, s.after) {
+			continue
+		}
+		if ok, _ := path.Match(s.pattern, key); !ok {
+			continue
+		}
+		if len(s.hits) == s.limit {
+			s.full = true
+			return false
+		}
+		s.hits = append(s.hits, h)
+	}
+	return true
+}
+
+// Result returns the page.
+func (s *KeySearch) Result() SearchResult {
+	res := SearchResult{Hits: s.hits}
+	if res.Hits == nil {
+		res.Hits = []SearchHit{}
+	}
+	if s.full {
+		res.Next = s.hits[len(s.hits)-1].cursor()
+	}
+	return res
+}
+
+// comesAfter reports whether h comes after the cursor c.
+func comesAfter(h SearchHit, c [3]string) bool {
+	if h.PersonaID != c[0] {
+		return h.PersonaID > c[0]
+	}
+	if h.AppID != c[1] {
+		return h.AppID > c[1]
+	}
+	return h.Key > c[2]
+}
+
+// Search finds keys on the daemon with SEARCH.
+func (c *Client) Search(keyPattern string, opts SearchOptions) (SearchResult, error) {
+	app := opts.App
+	if app == "" {
+		app = "*"
+	}
+	cmd := fmt.Sprintf("SEARCH %s %s %d", keyPattern, app, opts.Limit)
+	if opts.After != "" {
+		cmd += " " + opts.After
+	}
+	resp, err := c.sendAndReceive(cmd)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	var res SearchResult
+	err = json.Unmarshal([]byte(strings.TrimPrefix(resp, "OK ")), &res)
+	return res, err
+}
@@ -0,0 +1,131 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Seed is the content of a seed file: personas with their apps and keys, and
+// the users and API keys allowed in, for reproducible development and test
+// environments.
+type Seed struct {
+	// Personas maps persona IDs to app IDs to keys to values. Metadata goes in
+	// the MetaApp of a persona like any other app.
+	Personas map[string]map[string]map[string]any `json:"personas,omitempty"`
+	Users    []SeedUser                           `json:"users,omitempty"`
+	APIKeys  []SeedAPIKey                         `json:"api_keys,omitempty"`
+}
+
+// SeedUser is a user to create, with their password and the apps of their
+// persona, whose ID is only known once the user exists.
+type SeedUser struct {
+	Username    string                    `json:"username"`
+	DisplayName string                    `json:"display_name,omitempty"`
+	Password    string                    `json:"password,omitempty"`
+	Apps        map[string]map[string]any `json:"apps,omitempty"`
+}
+
+// SeedAPIKey is an API key to accept. Key must be given, so that clients of
+// the seeded store know it (see APIKeyStore.Add).
+type SeedAPIKey struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	Key    string   `json:"key"`
+}
+
+// SeedResult counts what ApplySeed created.
+type SeedResult struct {
+	Records int `json:"records"`
+	Users   int `json:"users"`
+	APIKeys int `json:"api_keys"`
+}
+
+// ReadSeed reads a seed file in FormatJSON or FormatYAML. Unknown fields are
+// refused, so that a misspelt section isn't silently left out.
+func ReadSeed(r io.Reader, format string, preciseNumbers bool) (*Seed, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatJSON:
+	case FormatYAML:
+		if data, err = yaml.YAMLToJSON(data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown seed format %q", format)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if preciseNumbers {
+		dec.UseNumber()
+	}
+	var seed Seed
+	if err := dec.Decode(&seed); err != nil {
+		return nil, fmt.Errorf("invalid seed: %w", err)
+	}
+	return &seed, nil
+}
+
+// ApplySeed writes a seed to s: the personas' values, then the users with
+// their passwords and apps, then the API keys. It stops at the first error,
+// leaving what was written so far; it is meant for empty stores, where
+// creating a user or key that exists fails.
+func ApplySeed(s CelerixStore, seed *Seed) (SeedResult, error) {
+	var result SeedResult
+	write := func(personaID string, apps map[string]map[string]any) error {
+		var batch []Record
+		for _, appID := range slices.Sorted(maps.Keys(apps)) {
+			for _, key := range slices.Sorted(maps.Keys(apps[appID])) {
+				batch = append(batch, Record{PersonaID: personaID, AppID: appID, Key: key, Value: apps[appID][key]})
+			}
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := WriteBatch(s, batch); err != nil {
+			return fmt.Errorf("seeding persona %s: %w", personaID, err)
+		}
+		result.Records += len(batch)
+		return nil
+	}
+
+	for _, personaID := range slices.Sorted(maps.Keys(seed.Personas)) {
+		if err := write(personaID, seed.Personas[personaID]); err != nil {
+			return result, err
+		}
+	}
+
+	users := NewUserStore(s)
+	for _, u := range seed.Users {
+		user, _, err := users.Create(u.Username, u.DisplayName)
+		if err != nil {
+			return result, fmt.Errorf("seeding user %s: %w", u.Username, err)
+		}
+		if u.Password != "" {
+			if err := users.SetPassword(user.ID, u.Password); err != nil {
+				return result, fmt.Errorf("seeding user %s: %w", u.Username, err)
+			}
+		}
+		result.Users++
+		if err := write(user.ID, u.Apps); err != nil {
+			return result, err
+		}
+	}
+
+	keys := NewAPIKeyStore(s)
+	for _, k := range seed.APIKeys {
+		if _, err := keys.Add(k.Key, k.Name, k.Scopes); err != nil {
+			return result, fmt.Errorf("seeding API key %s: %w", k.Name, err)
+		}
+		result.APIKeys++
+	}
+	return result, nil
+}
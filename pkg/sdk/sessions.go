@@ -0,0 +1,102 @@
+package sdk
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// ErrSessionExpired is returned by SessionStore when a session exists but has expired.
+var ErrSessionExpired = errors.New("session expired")
+
+// Session is a login session owned by a persona.
+type Session struct {
+	ID        string         `json:"id"`
+	PersonaID string         `json:"persona_id"`
+	Data      map[string]any `json:"data,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	ExpiresAt time.Time      `json:"expires_at"`
+}
+
+// SessionStore keeps expiring sessions in one app of the _system persona, so every
+// service sharing the store can validate a session ID without knowing its owner.
+type SessionStore struct {
+	store KVStore
+	appID string
+	ttl   time.Duration
+	users *UserStore
+}
+
+// NewSessionStore stores sessions under _system/appID. Sessions expire ttl after
+// they were created or last touched.
+func NewSessionStore(s KVStore, appID string, ttl time.Duration) *SessionStore {
+	return &SessionStore{store: s, appID: appID, ttl: ttl}
+}
+
+// TrackUsers makes sessions of personas that are users in u count as activity:
+// Get and Touch update the user's LastActive, and fail with ErrUserDisabled
+// once the user is disabled.
+func (s *SessionStore) TrackUsers(u *UserStore) {
+	s.users = u
+}
+
+// Create starts a new session for personaID with a random ID.
+func (s *SessionStore) Create(personaID string, data map[string]any) (Session, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Session{}, err
+	}
+
+	now := time.Now().UTC()
+	sess := Session{
+		ID:        hex.EncodeToString(id),
+		PersonaID: personaID,
+		Data:      data,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+	}
+	if err := s.store.Set(SystemPersona, s.appID, sess.ID, sess); err != nil {
+		return Session{}, err
+	}
+	return sess, nil
+}
+
+// Get returns a live session. Expired sessions are deleted and reported as ErrSessionExpired.
+func (s *SessionStore) Get(id string) (Session, error) {
+	sess, err := Get[Session](s.store, SystemPersona, s.appID, id)
+	if err != nil {
+		return Session{}, err
+	}
+	if time.Now().After(sess.ExpiresAt) {
+		s.store.Delete(SystemPersona, s.appID, id)
+		return Session{}, ErrSessionExpired
+	}
+	if s.users != nil {
+		if _, err := s.users.Touch(sess.PersonaID); err != nil && !IsNotFound(err) {
+			return Session{}, err
+		}
+	}
+	return sess, nil
+}
+
+// Touch extends a live session by the store's ttl.
+func (s *SessionStore) Touch(id string) (Session, error) {
+	sess, err := s.Get(id)
+	if err != nil {
+		return Session{}, err
+	}
+	sess.ExpiresAt = time.Now().UTC().Add(s.ttl)
+	if err := s.store.Set(SystemPersona, s.appID, id, sess); err != nil {
+		return Session{}, err
+	}
+	return sess, nil
+}
+
+// Delete ends a session. Deleting an unknown session is not an error.
+func (s *SessionStore) Delete(id string) error {
+	if err := s.store.Delete(SystemPersona, s.appID, id); err != nil && !IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,669 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultShadowQueueSize is the number of writes ShadowStore buffers for the shadow.
+const DefaultShadowQueueSize = 1024
+
+// shadowRecentDivergences bounds the divergences kept for ShadowReport.
+const shadowRecentDivergences = 20
+
+// Divergence kinds reported by ShadowStore.
+const (
+	// DivergenceError means the shadow failed an operation the primary accepted.
+	DivergenceError = "error"
+	// DivergenceMismatch means the shadow holds a different value than the primary.
+	DivergenceMismatch = "mismatch"
+	// DivergenceDropped means a write was never mirrored because the queue was full.
+	DivergenceDropped = "dropped"
+)
+
+// Divergence describes one disagreement between the primary and the shadow store.
+type Divergence struct {
+	Kind      string    `json:"kind"`
+	Op        string    `json:"op"`
+	PersonaID string    `json:"persona_id"`
+	AppID     string    `json:"app_id"`
+	Key       string    `json:"key"`
+	Primary   any       `json:"primary,omitempty"`
+	Shadow    any       `json:"shadow,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// ShadowReport summarizes shadow traffic so far.
+type ShadowReport struct {
+	Mirrored uint64 `json:"mirrored"`
+	Dropped  uint64 `json:"dropped"`
+	Errors   uint64 `json:"errors"`
+	Compared uint64 `json:"compared"`
+	Diverged uint64 `json:"diverged"`
+	// Recent holds the latest divergences, oldest first.
+	Recent []Divergence `json:"recent,omitempty"`
+}
+
+// ShadowOptions controls ShadowStore.
+type ShadowOptions struct {
+	// QueueSize bounds the writes waiting to be mirrored (default DefaultShadowQueueSize).
+	// When it is full, writes are dropped from the shadow rather than slowing down the primary.
+	QueueSize int
+	// Verify reads every mirrored value back from the shadow and compares it.
+	Verify bool
+	// CompareReads is the fraction of Gets (0 to 1) that are also sent to the
+	// shadow in the background and compared.
+	CompareReads float64
+	// OnDivergence, if set, is called for every divergence from the background goroutine.
+	OnDivergence func(Divergence)
+}
+
+type shadowOp struct {
+	op        string
+	personaID string
+	appID     string
+	key       string
+	val       any
+	exists    bool   // get: whether the primary had the key
+	dstID     string // Move and MergePersona destination
+	strategy  MergeStrategy
+	batch     []Record // SetBatch
+}
+
+// ShadowStore mirrors writes to a secondary store, such as a new daemon version
+// or persistence backend, and reports where the two diverge. The primary stays
+// authoritative: every call is answered by it, and mirroring happens in order
+// on a background goroutine so the shadow can never fail a request or slow it
+// down beyond keeping writes in order.
+type ShadowStore struct {
+	primary CelerixStore
+	shadow  CelerixStore
+	opts    ShadowOptions
+
+	// writeMu keeps the mirror queue in the primary's commit order.
+	writeMu sync.Mutex
+	queue   chan shadowOp
+	pending sync.WaitGroup
+	done    chan struct{}
+	closed  sync.Once
+
+	mirrored, dropped, errors, compared, diverged atomic.Uint64
+
+	mu     sync.Mutex
+	recent []Divergence
+}
+
+// NewShadowStore starts mirroring writes from primary to shadow. Call Close to
+// stop once the queue is drained; the ShadowStore must not be used afterwards.
+func NewShadowStore(primary, shadow CelerixStore, opts ShadowOptions) *ShadowStore {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultShadowQueueSize
+	}
+	s := &ShadowStore{
+		primary: primary,
+		shadow:  shadow,
+		opts:    opts,
+		queue:   make(chan shadowOp, opts.QueueSize),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Report returns the shadow traffic statistics.
+func (s *ShadowStore) Report() ShadowReport {
+	s.mu.Lock()
+	recent := append([]Divergence(nil), s.recent...)
+	s.mu.Unlock()
+	return ShadowReport{
+		Mirrored: s.mirrored.Load(),
+		Dropped:  s.dropped.Load(),
+		Errors:   s.errors.Load(),
+		Compared: s.compared.Load(),
+		Diverged: s.diverged.Load(),
+		Recent:   recent,
+	}
+}
+
+// Flush waits until every queued write has been mirrored.
+func (s *ShadowStore) Flush() {
+	s.pending.Wait()
+}
+
+// Close drains the queue and stops mirroring. It does not close either store.
+func (s *ShadowStore) Close() error {
+	s.closed.Do(func() {
+		s.pending.Wait()
+		close(s.done)
+	})
+	return nil
+}
+
+func (s *ShadowStore) enqueue(op shadowOp) {
+	s.pending.Add(1)
+	select {
+	case s.queue <- op:
+	default:
+		s.pending.Done()
+		if op.op == "get" {
+			return // Comparisons are only a sample anyway
+		}
+		s.dropped.Add(1)
+		s.report(Divergence{Kind: DivergenceDropped, Op: op.op, PersonaID: op.personaID, AppID: op.appID, Key: op.key})
+	}
+}
+
+func (s *ShadowStore) run() {
+	for {
+		select {
+		case op := <-s.queue:
+			s.mirror(op)
+			s.pending.Done()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *ShadowStore) mirror(op shadowOp) {
+	var err error
+	switch op.op {
+	case "get":
+		s.compare(op.op, op.personaID, op.appID, op.key, op.val, op.exists)
+		return
+	case "set":
+		err = s.shadow.Set(op.personaID, op.appID, op.key, op.val)
+	case "delete":
+		err = s.shadow.Delete(op.personaID, op.appID, op.key)
+	case "move":
+		err = s.shadow.Move(op.personaID, op.dstID, op.appID, op.key)
+	case "batch":
+		err = WriteBatch(s.shadow, op.batch)
+	case "delete_prefix":
+		_, err = DeletePrefix(s.shadow, op.personaID, op.appID, op.key)
+	case "purge_persona":
+		_, err = PurgePersona(s.shadow, op.personaID)
+	case "merge_persona":
+		if merger, ok := s.shadow.(PersonaMerger); ok {
+			err = merger.MergePersona(op.personaID, op.dstID, op.strategy)
+		} else {
+			err = fmt.Errorf("merging personas: %w", ErrNotSupported)
+		}
+	}
+	if err != nil {
+		s.errors.Add(1)
+		s.report(Divergence{Kind: DivergenceError, Op: op.op, PersonaID: op.personaID, AppID: op.appID, Key: op.key, Error: err.Error()})
+		return
+	}
+	s.mirrored.Add(1)
+
+	if !s.opts.Verify {
+		return
+	}
+	switch op.op {
+	case "set":
+		s.compare(op.op, op.personaID, op.appID, op.key, op.val, true)
+	case "delete":
+		s.compare(op.op, op.personaID, op.appID, op.key, nil, false)
+	case "move":
+		s.compare(op.op, op.dstID, op.appID, op.key, op.val, true)
+	}
+}
+
+// compare reads key from the shadow and reports a mismatch with the primary's
+// value. exists=false means the primary doesn't have the key.
+func (s *ShadowStore) compare(op, personaID, appID, key string, want any, exists bool) {
+	s.compared.Add(1)
+	got, err := s.shadow.Get(personaID, appID, key)
+	switch {
+	case err != nil && IsNotFound(err):
+		if !exists {
+			return
+		}
+		got = nil
+	case err != nil:
+		s.errors.Add(1)
+		s.report(Divergence{Kind: DivergenceError, Op: op, PersonaID: personaID, AppID: appID, Key: key, Error: err.Error()})
+		return
+	case !exists:
+		s.report(Divergence{Kind: DivergenceMismatch, Op: op, PersonaID: personaID, AppID: appID, Key: key, Shadow: got})
+		return
+	}
+	if !sameJSON(want, got) {
+		s.report(Divergence{Kind: DivergenceMismatch, Op: op, PersonaID: personaID, AppID: appID, Key: key, Primary: want, Shadow: got})
+	}
+}
+
+// sameJSON compares values by their JSON encoding, so a remote shadow's decoded
+// values (float64 numbers, generic maps) match the primary's typed ones.
+func sameJSON(a, b any) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return reflect.DeepEqual(a, b)
+	}
+	var va, vb any
+	json.Unmarshal(ja, &va)
+	json.Unmarshal(jb, &vb)
+	return reflect.DeepEqual(va, vb)
+}
+
+func (s *ShadowStore) report(d Divergence) {
+	d.Time = time.Now().UTC()
+	if d.Kind != DivergenceDropped {
+		s.diverged.Add(1)
+	}
+	s.mu.Lock()
+	s.recent = append(s.recent, d)
+	if len(s.recent) > shadowRecentDivergences {
+		s.recent = s.recent[len(s.recent)-shadowRecentDivergences:]
+	}
+	s.mu.Unlock()
+	if s.opts.OnDivergence != nil {
+		s.opts.OnDivergence(d)
+	}
+}
+
+// --- CelerixStore ---
+
+// Get reads from the primary and, for a sample of reads, compares with the
+// shadow. The comparison is queued behind pending writes so it sees the same state.
+func (s *ShadowStore) Get(personaID, appID, key string) (any, error) {
+	if s.opts.CompareReads <= 0 || rand.Float64() >= s.opts.CompareReads {
+		return s.primary.Get(personaID, appID, key)
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	val, err := s.primary.Get(personaID, appID, key)
+	if err == nil || IsNotFound(err) {
+		s.enqueue(shadowOp{op: "get", personaID: personaID, appID: appID, key: key, val: val, exists: err == nil})
+	}
+	return val, err
+}
+
+// Exists and MGet read from the primary.
+func (s *ShadowStore) Exists(personaID, appID, key string) (bool, error) {
+	return Exists(s.primary, personaID, appID, key)
+}
+
+func (s *ShadowStore) MGet(personaID, appID string, keys []string) (map[string]any, error) {
+	return MGet(s.primary, personaID, appID, keys)
+}
+
+func (s *ShadowStore) Set(personaID, appID, key string, val any) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := s.primary.Set(personaID, appID, key, val); err != nil {
+		return err
+	}
+	s.enqueue(shadowOp{op: "set", personaID: personaID, appID: appID, key: key, val: val})
+	return nil
+}
+
+func (s *ShadowStore) Delete(personaID, appID, key string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := s.primary.Delete(personaID, appID, key); err != nil {
+		return err
+	}
+	s.enqueue(shadowOp{op: "delete", personaID: personaID, appID: appID, key: key})
+	return nil
+}
+
+func (s *ShadowStore) Move(srcPersona, dstPersona, appID, key string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	val, _ := s.primary.Get(srcPersona, appID, key)
+	if err := s.primary.Move(srcPersona, dstPersona, appID, key); err != nil {
+		return err
+	}
+	s.enqueue(shadowOp{op: "move", personaID: srcPersona, dstID: dstPersona, appID: appID, key: key, val: val})
+	return nil
+}
+
+// MoveKey moves on the primary and mirrors the move as a Set at the
+// destination and a Delete at the source, so the shadow needn't be a KeyMover.
+func (s *ShadowStore) MoveKey(srcPersona, srcApp, dstPersona, dstApp, srcKey, dstKey string) error {
+	mover, ok := s.primary.(KeyMover)
+	if !ok {
+		return fmt.Errorf("moving keys: %w", ErrNotSupported)
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	val, _ := s.primary.Get(srcPersona, srcApp, srcKey)
+	if err := mover.MoveKey(srcPersona, srcApp, dstPersona, dstApp, srcKey, dstKey); err != nil {
+		return err
+	}
+	if srcPersona == dstPersona && srcApp == dstApp && srcKey == dstKey {
+		return nil
+	}
+	s.enqueue(shadowOp{op: "set", personaID: dstPersona, appID: dstApp, key: dstKey, val: val})
+	s.enqueue(shadowOp{op: "delete", personaID: srcPersona, appID: srcApp, key: srcKey})
+	return nil
+}
+
+// MergePersona merges on the primary, then on the shadow, which must be a
+// PersonaMerger too.
+func (s *ShadowStore) MergePersona(src, dst string, strategy MergeStrategy) error {
+	merger, ok := s.primary.(PersonaMerger)
+	if !ok {
+		return fmt.Errorf("merging personas: %w", ErrNotSupported)
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := merger.MergePersona(src, dst, strategy); err != nil {
+		return err
+	}
+	s.enqueue(shadowOp{op: "merge_persona", personaID: src, dstID: dst, strategy: strategy})
+	return nil
+}
+
+// PurgePersona erases the persona from the primary and then from the shadow.
+func (s *ShadowStore) PurgePersona(personaID string) (PurgeReport, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	report, err := PurgePersona(s.primary, personaID)
+	if err != nil {
+		return report, err
+	}
+	s.enqueue(shadowOp{op: "purge_persona", personaID: personaID})
+	return report, nil
+}
+
+// Merge applies the patch on the primary and mirrors the merged result as a Set,
+// so both stores end up with the same value even if their merge logic differs.
+func (s *ShadowStore) Merge(personaID, appID, key string, patch any) (any, error) {
+	merger, ok := s.primary.(Merger)
+	if !ok {
+		return nil, fmt.Errorf("merge not supported")
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	merged, err := merger.Merge(personaID, appID, key, patch)
+	if err != nil {
+		return nil, err
+	}
+	s.enqueue(shadowOp{op: "set", personaID: personaID, appID: appID, key: key, val: merged})
+	return merged, nil
+}
+
+// SetIfRevision writes to the primary if its value still has revision rev and
+// mirrors the write as a plain Set.
+func (s *ShadowStore) SetIfRevision(personaID, appID, key string, val any, rev string) error {
+	writer, ok := s.primary.(ConditionalWriter)
+	if !ok {
+		return fmt.Errorf("conditional writes: %w", ErrNotSupported)
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := writer.SetIfRevision(personaID, appID, key, val, rev); err != nil {
+		return err
+	}
+	s.enqueue(shadowOp{op: "set", personaID: personaID, appID: appID, key: key, val: val})
+	return nil
+}
+
+// SetSync writes durably to the primary and mirrors the write as a plain Set.
+func (s *ShadowStore) SetSync(personaID, appID, key string, val any) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := SetSync(s.primary, personaID, appID, key, val); err != nil {
+		return err
+	}
+	s.enqueue(shadowOp{op: "set", personaID: personaID, appID: appID, key: key, val: val})
+	return nil
+}
+
+// DeleteIfRevision deletes from the primary if its value still has revision
+// rev and mirrors the delete.
+func (s *ShadowStore) DeleteIfRevision(personaID, appID, key, rev string) error {
+	writer, ok := s.primary.(ConditionalWriter)
+	if !ok {
+		return fmt.Errorf("conditional writes: %w", ErrNotSupported)
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := writer.DeleteIfRevision(personaID, appID, key, rev); err != nil {
+		return err
+	}
+	s.enqueue(shadowOp{op: "delete", personaID: personaID, appID: appID, key: key})
+	return nil
+}
+
+// DeleteIfEquals deletes from the primary if its value still equals expected
+// and mirrors the delete.
+func (s *ShadowStore) DeleteIfEquals(personaID, appID, key string, expected any) error {
+	pruner, ok := s.primary.(Pruner)
+	if !ok {
+		return fmt.Errorf("pruning: %w", ErrNotSupported)
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := pruner.DeleteIfEquals(personaID, appID, key, expected); err != nil {
+		return err
+	}
+	s.enqueue(shadowOp{op: "delete", personaID: personaID, appID: appID, key: key})
+	return nil
+}
+
+// DeletePrefix deletes the keys starting with prefix from the primary and
+// mirrors the delete of the same prefix.
+func (s *ShadowStore) DeletePrefix(personaID, appID, prefix string) (int, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	n, err := DeletePrefix(s.primary, personaID, appID, prefix)
+	if err != nil {
+		return n, err
+	}
+	if n > 0 {
+		s.enqueue(shadowOp{op: "delete_prefix", personaID: personaID, appID: appID, key: prefix})
+	}
+	return n, nil
+}
+
+// Lock, Renew and Unlock use the primary's locks; leases aren't mirrored.
+func (s *ShadowStore) Lock(personaID, appID, name string, ttl time.Duration) (Lease, error) {
+	locker, ok := s.primary.(Locker)
+	if !ok {
+		return Lease{}, fmt.Errorf("locks: %w", ErrNotSupported)
+	}
+	return locker.Lock(personaID, appID, name, ttl)
+}
+
+func (s *ShadowStore) Renew(lease Lease, ttl time.Duration) (Lease, error) {
+	locker, ok := s.primary.(Locker)
+	if !ok {
+		return Lease{}, fmt.Errorf("locks: %w", ErrNotSupported)
+	}
+	return locker.Renew(lease, ttl)
+}
+
+func (s *ShadowStore) Unlock(lease Lease) error {
+	locker, ok := s.primary.(Locker)
+	if !ok {
+		return fmt.Errorf("locks: %w", ErrNotSupported)
+	}
+	return locker.Unlock(lease)
+}
+
+// Enqueue, Dequeue and Ack change the primary's queue and mirror the queue's
+// new value as a Set.
+func (s *ShadowStore) Enqueue(personaID, appID, queue string, item any) (string, error) {
+	var id string
+	err := s.queueOp(personaID, appID, queue, func(q Queuer) (err error) {
+		id, err = q.Enqueue(personaID, appID, queue, item)
+		return err
+	})
+	return id, err
+}
+
+func (s *ShadowStore) Dequeue(personaID, appID, queue string, visibility time.Duration) (QueueMessage, error) {
+	var msg QueueMessage
+	err := s.queueOp(personaID, appID, queue, func(q Queuer) (err error) {
+		msg, err = q.Dequeue(personaID, appID, queue, visibility)
+		return err
+	})
+	return msg, err
+}
+
+func (s *ShadowStore) Ack(personaID, appID, queue, receipt string) error {
+	return s.queueOp(personaID, appID, queue, func(q Queuer) error {
+		return q.Ack(personaID, appID, queue, receipt)
+	})
+}
+
+func (s *ShadowStore) queueOp(personaID, appID, queue string, op func(Queuer) error) error {
+	queuer, ok := s.primary.(Queuer)
+	if !ok {
+		return fmt.Errorf("queues: %w", ErrNotSupported)
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := op(queuer); err != nil {
+		return err
+	}
+	if val, err := s.primary.Get(personaID, appID, queue); err == nil {
+		s.enqueue(shadowOp{op: "set", personaID: personaID, appID: appID, key: queue, val: val})
+	}
+	return nil
+}
+
+// SetBatch writes the records to the primary and mirrors them as one batch.
+func (s *ShadowStore) SetBatch(records []Record) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if err := WriteBatch(s.primary, records); err != nil {
+		return err
+	}
+	if len(records) > 0 {
+		first := records[0]
+		s.enqueue(shadowOp{op: "batch", personaID: first.PersonaID, appID: first.AppID, key: first.Key,
+			batch: append([]Record(nil), records...)})
+	}
+	return nil
+}
+
+// Wait waits for the primary's background persistence, if any, and for the
+// shadow to catch up, so import checkpoints cover both stores.
+func (s *ShadowStore) Wait() {
+	if w, ok := s.primary.(interface{ Wait() }); ok {
+		w.Wait()
+	}
+	s.Flush()
+}
+
+func (s *ShadowStore) GetPersonas() ([]string, error) { return s.primary.GetPersonas() }
+
+func (s *ShadowStore) GetApps(personaID string) ([]string, error) {
+	return s.primary.GetApps(personaID)
+}
+
+func (s *ShadowStore) GetAppStore(personaID, appID string) (map[string]any, error) {
+	return s.primary.GetAppStore(personaID, appID)
+}
+
+func (s *ShadowStore) GetPersona(personaID string) (map[string]map[string]any, error) {
+	return GetPersona(s.primary, personaID)
+}
+
+func (s *ShadowStore) DumpApp(appID string) (map[string]map[string]any, error) {
+	return s.primary.DumpApp(appID)
+}
+
+// StreamApp streams the primary's data.
+func (s *ShadowStore) StreamApp(appID, after string, fn func(personaID string, data map[string]any) error) error {
+	return StreamApp(s.primary, appID, after, fn)
+}
+
+func (s *ShadowStore) GetGlobal(appID, key string) (any, string, error) {
+	return s.primary.GetGlobal(appID, key)
+}
+
+// Search searches the primary.
+func (s *ShadowStore) Search(keyPattern string, opts SearchOptions) (SearchResult, error) {
+	return Search(s.primary, keyPattern, opts)
+}
+
+// Usage reports the primary's usage.
+func (s *ShadowStore) Usage() (UsageReport, error) {
+	return Usage(s.primary)
+}
+
+// Stats reports the primary's statistics along with the shadow traffic.
+func (s *ShadowStore) Stats() (Stats, error) {
+	var stats Stats
+	if reporter, ok := s.primary.(StatsReporter); ok {
+		var err error
+		if stats, err = reporter.Stats(); err != nil {
+			return stats, err
+		}
+	}
+	report := s.Report()
+	stats.Shadow = &report
+	return stats, nil
+}
+
+// Health reports the primary's health.
+func (s *ShadowStore) Health() (Health, error) {
+	if reporter, ok := s.primary.(HealthReporter); ok {
+		return reporter.Health()
+	}
+	return Health{Status: HealthOK}, nil
+}
+
+// Fsck checks the primary's data files.
+func (s *ShadowStore) Fsck() (FsckReport, error) {
+	if checker, ok := s.primary.(Checker); ok {
+		return checker.Fsck()
+	}
+	return FsckReport{}, fmt.Errorf("fsck: %w", ErrNotSupported)
+}
+
+// Watch streams the primary's changes.
+func (s *ShadowStore) Watch(ctx context.Context, personaID, appID, prefix string) (<-chan ChangeEvent, error) {
+	watcher, ok := s.primary.(Watcher)
+	if !ok {
+		return nil, fmt.Errorf("watch not supported")
+	}
+	return watcher.Watch(ctx, personaID, appID, prefix)
+}
+
+// --- Scoping Support ---
+
+// App returns a scope whose writes are mirrored as well.
+func (s *ShadowStore) App(personaID, appID string) AppScope {
+	return &shadowAppScope{store: s, personaID: personaID, appID: appID}
+}
+
+type shadowAppScope struct {
+	store     *ShadowStore
+	personaID string
+	appID     string
+}
+
+func (a *shadowAppScope) Get(key string) (any, error) {
+	return a.store.Get(a.personaID, a.appID, key)
+}
+
+func (a *shadowAppScope) Set(key string, val any) error {
+	return a.store.Set(a.personaID, a.appID, key, val)
+}
+
+func (a *shadowAppScope) Delete(key string) error {
+	return a.store.Delete(a.personaID, a.appID, key)
+}
+
+func (a *shadowAppScope) Watch(ctx context.Context, prefix string) (<-chan ChangeEvent, error) {
+	return a.store.Watch(ctx, a.personaID, a.appID, prefix)
+}
+
+func (a *shadowAppScope) Vault(masterKey []byte) VaultScope {
+	return &scopedVault{app: a, masterKey: masterKey}
+}
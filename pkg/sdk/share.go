@@ -0,0 +1,157 @@
+package sdk
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/schema"
+)
+
+// ShareLinksApp is the app in the _system persona holding the records of
+// share links by ID.
+const ShareLinksApp = "share_links"
+
+// Lifetimes of share links: DefaultShareTTL when none is asked for, and at
+// most MaxShareTTL.
+const (
+	DefaultShareTTL = time.Hour
+	MaxShareTTL     = 7 * 24 * time.Hour
+)
+
+// ErrInvalidShare is returned for share links that were tampered with, signed
+// with another key, revoked or have expired.
+var ErrInvalidShare = NewProtocolError(CodeUnauthorized, "invalid or expired share link")
+
+// ShareSigner issues share links, which grant read access to the dump of one
+// app of one persona until they expire, without credentials. A link carries
+// its ID and a signature over it, and is recorded in _system/share_links
+// until it expires, so it can be listed and revoked on its own. Changing the
+// key revokes every link at once.
+type ShareSigner struct {
+	key   []byte
+	store KVStore
+}
+
+// NewShareSigner signs links with key and records them in s. With an empty
+// key a random one is generated, so links only work for the lifetime of the
+// process.
+func NewShareSigner(s KVStore, key []byte) *ShareSigner {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	return &ShareSigner{key: key, store: s}
+}
+
+// Create records a link to the app of personaID expiring after ttl, to the
+// second, and returns it with its signature. Records of expired links are
+// dropped on the way.
+func (s *ShareSigner) Create(personaID, appID string, ttl time.Duration) (schema.ShareLinkRecord, string, error) {
+	if _, err := s.List(); err != nil && !errors.Is(err, ErrNotSupported) {
+		return schema.ShareLinkRecord{}, "", err
+	}
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		return schema.ShareLinkRecord{}, "", err
+	}
+	now := time.Now().UTC()
+	rec := schema.ShareLinkRecord{
+		ID:        hex.EncodeToString(raw),
+		PersonaID: personaID,
+		AppID:     appID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl).Truncate(time.Second),
+	}
+	if err := s.store.Set(SystemPersona, ShareLinksApp, rec.ID, rec); err != nil {
+		return schema.ShareLinkRecord{}, "", err
+	}
+	return rec, s.Sign(rec.ID, personaID, appID, rec.ExpiresAt), nil
+}
+
+// Sign returns the signature of link id to the app of personaID expiring at
+// expiresAt, to the second.
+func (s *ShareSigner) Sign(id, personaID, appID string, expiresAt time.Time) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(id + "\n" + personaID + "\n" + appID + "\n" + strconv.FormatInt(expiresAt.Unix(), 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns ErrInvalidShare unless signature was returned by Create for
+// the same link, and the link hasn't expired or been revoked.
+func (s *ShareSigner) Verify(id, personaID, appID string, expiresAt time.Time, signature string) error {
+	if !hmac.Equal([]byte(signature), []byte(s.Sign(id, personaID, appID, expiresAt))) || !time.Now().Before(expiresAt) {
+		return ErrInvalidShare
+	}
+	if err := ValidateID("share link ID", id); err != nil {
+		return ErrInvalidShare
+	}
+	rec, err := s.Get(id)
+	if err != nil {
+		if IsNotFound(err) {
+			return ErrInvalidShare
+		}
+		return err
+	}
+	if rec.PersonaID != personaID || rec.AppID != appID || !rec.ExpiresAt.Equal(expiresAt) {
+		return ErrInvalidShare
+	}
+	return nil
+}
+
+// Get returns the record of a link that hasn't been revoked, or ErrNotFound.
+func (s *ShareSigner) Get(id string) (schema.ShareLinkRecord, error) {
+	return Get[schema.ShareLinkRecord](s.store, SystemPersona, ShareLinksApp, id)
+}
+
+// List returns the links that haven't expired or been revoked, newest first,
+// and drops the records of expired ones.
+func (s *ShareSigner) List() ([]schema.ShareLinkRecord, error) {
+	exporter, ok := s.store.(BatchExporter)
+	if !ok {
+		return nil, fmt.Errorf("listing share links: %w", ErrNotSupported)
+	}
+	data, err := exporter.GetAppStore(SystemPersona, ShareLinksApp)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	now := time.Now()
+	links := make([]schema.ShareLinkRecord, 0, len(data))
+	for id := range data {
+		rec, err := s.Get(id)
+		if err != nil {
+			if IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if !now.Before(rec.ExpiresAt) {
+			if err := s.Revoke(id); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		links = append(links, rec)
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].CreatedAt.After(links[j].CreatedAt) })
+	return links, nil
+}
+
+// Revoke deletes the record of a link, so it stops working. Revoking an
+// unknown link is not an error.
+func (s *ShareSigner) Revoke(id string) error {
+	if err := s.store.Delete(SystemPersona, ShareLinksApp, id); err != nil && !IsNotFound(err) {
+		return err
+	}
+	return nil
+}
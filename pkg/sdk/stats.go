@@ -0,0 +1,71 @@
+package sdk
+
+// NamespaceContention summarizes write lock contention for a single persona/app pair.
+type NamespaceContention struct {
+	PersonaID string `json:"persona_id"`
+	AppID     string `json:"app_id"`
+	Writes    uint64 `json:"writes"`
+	Contended uint64 `json:"contended"`
+	// Conflicts counts conditional writes refused because the value had changed.
+	Conflicts   uint64  `json:"conflicts"`
+	TotalWaitMs float64 `json:"total_wait_ms"`
+	MaxWaitMs   float64 `json:"max_wait_ms"`
+}
+
+// ContentionRate returns the fraction of writes that had to wait for the lock.
+func (n NamespaceContention) ContentionRate() float64 {
+	if n.Writes == 0 {
+		return 0
+	}
+	return float64(n.Contended) / float64(n.Writes)
+}
+
+// ConflictRate returns the fraction of writes refused for a revision conflict.
+func (n NamespaceContention) ConflictRate() float64 {
+	if n.Writes == 0 {
+		return 0
+	}
+	return float64(n.Conflicts) / float64(n.Writes)
+}
+
+// NamespaceMemory is the approximate memory held by a single persona/app pair.
+type NamespaceMemory struct {
+	PersonaID string `json:"persona_id"`
+	AppID     string `json:"app_id"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// ServerStats reports a daemon's connection admission counters.
+type ServerStats struct {
+	ActiveConnections int64  `json:"active_connections"`
+	MaxConnections    int    `json:"max_connections"`
+	Accepted          uint64 `json:"accepted"`
+	// Rejected counts connections turned away with "server busy".
+	Rejected uint64 `json:"rejected"`
+}
+
+// Stats is a point-in-time report of the store's size and hot spots.
+type Stats struct {
+	Personas int `json:"personas"`
+	Apps     int `json:"apps"`
+	Keys     int `json:"keys"`
+	// FastMisses counts Gets for missing keys answered without taking the store lock.
+	FastMisses uint64 `json:"fast_misses"`
+	// MemoryBytes is the approximate size of the data held in memory.
+	MemoryBytes int64 `json:"memory_bytes"`
+	// MemoryLimit is the configured cap on MemoryBytes (0 means unlimited).
+	MemoryLimit    int64  `json:"memory_limit"`
+	EvictionPolicy string `json:"eviction_policy,omitempty"`
+	// Evictions counts apps or personas evicted to stay under the limit.
+	Evictions       uint64 `json:"evictions"`
+	EvictedPersonas int    `json:"evicted_personas"`
+	// TopMemory lists the namespaces holding the most memory, largest first.
+	TopMemory []NamespaceMemory `json:"top_memory"`
+	// Server reports connection counters when the stats come from a daemon over TCP.
+	Server *ServerStats `json:"server,omitempty"`
+	// Shadow reports mirrored traffic when the store is a ShadowStore.
+	Shadow *ShadowReport `json:"shadow,omitempty"`
+	// TopContended lists the namespaces with the highest total lock wait, then
+	// the most revision conflicts, most contended first.
+	TopContended []NamespaceContention `json:"top_contended"`
+}
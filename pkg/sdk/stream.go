@@ -0,0 +1,133 @@
+package sdk
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AppStreamer hands out an app's data one persona at a time, so dumping an
+// app held by many personas doesn't build the whole dataset in memory. It is
+// optional: use StreamApp, which falls back to DumpApp.
+type AppStreamer interface {
+	// StreamApp calls fn with the app's data for every persona holding it,
+	// in persona ID order, starting after the persona ID after ("" for the
+	// first). An error from fn stops the stream and is returned.
+	StreamApp(appID, after string, fn func(personaID string, data map[string]any) error) error
+}
+
+// StreamApp streams an app from s, using DumpApp for stores that aren't an
+// AppStreamer.
+func StreamApp(s BatchExporter, appID, after string, fn func(personaID string, data map[string]any) error) error {
+	if streamer, ok := s.(AppStreamer); ok {
+		return streamer.StreamApp(appID, after, fn)
+	}
+	dump, err := s.DumpApp(appID)
+	if err != nil {
+		return err
+	}
+	for _, personaID := range slices.Sorted(maps.Keys(dump)) {
+		if personaID <= after {
+			continue
+		}
+		if err := fn(personaID, dump[personaID]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errPageFull stops a stream once a page is complete.
+var errPageFull = errors.New("page full")
+
+// DumpAppPage returns the app's data for up to limit personas after the
+// persona ID after, and the cursor to pass as after for the next page, which
+// is empty on the last page. A limit of 0 or less returns every persona.
+func DumpAppPage(s BatchExporter, appID, after string, limit int) (map[string]map[string]any, string, error) {
+	page := map[string]map[string]any{}
+	var last, next string
+	err := StreamApp(s, appID, after, func(personaID string, data map[string]any) error {
+		if limit > 0 && len(page) == limit {
+			next = last
+			return errPageFull
+		}
+		page[personaID] = data
+		last = personaID
+		return nil
+	})
+	if err != nil && !errors.Is(err, errPageFull) {
+		return nil, "", err
+	}
+	return page, next, nil
+}
+
+// StreamApp streams an app over a connection of its own, with
+// DUMP_APP_STREAM, so other commands aren't held up.
+func (c *Client) StreamApp(appID, after string, fn func(personaID string, data map[string]any) error) error {
+	if err := ValidateID("app ID", appID); err != nil {
+		return err
+	}
+	conn, reader, err := c.dialStream()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	cmd := "DUMP_APP_STREAM " + appID
+	if after != "" {
+		cmd += " " + after
+	}
+	conn.SetDeadline(time.Now().Add(c.commandTimeout()))
+	if _, err := fmt.Fprintln(conn, cmd); err != nil {
+		return err
+	}
+	for {
+		conn.SetDeadline(time.Now().Add(c.commandTimeout()))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		kind, payload, _ := strings.Cut(line, " ")
+		switch kind {
+		case "OK":
+		case "END":
+			return nil
+		case "ERR":
+			return ParseError(payload, 1)
+		case "PERSONA":
+			var entry struct {
+				Persona string         `json:"persona"`
+				Data    map[string]any `json:"data"`
+			}
+			if err := c.decodeValue(payload, &entry); err != nil {
+				return err
+			}
+			if err := fn(entry.Persona, entry.Data); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected DUMP_APP_STREAM response %q", line)
+		}
+	}
+}
+
+// PageIDs sorts ids and returns up to limit of those after the ID after, with
+// the cursor for the next page, which is empty on the last. A limit of 0 or
+// less returns them all.
+func PageIDs(ids []string, after string, limit int) ([]string, string) {
+	sort.Strings(ids)
+	i := sort.SearchStrings(ids, after)
+	for i < len(ids) && ids[i] == after {
+		i++
+	}
+	ids = ids[i:]
+	if limit <= 0 || len(ids) <= limit {
+		return ids, ""
+	}
+	return ids[:limit], ids[limit-1]
+}
@@ -0,0 +1,72 @@
+package sdk
+
+import (
+	"context"
+	"strings"
+)
+
+// Tracer starts spans in a tracing system such as OpenTelemetry, which the
+// SDK doesn't depend on: an adapter of a few lines implements Tracer on top
+// of it (see USAGE.md). The same interface is used by Clients, the Router
+// and the engine.
+type Tracer interface {
+	// Start starts a span named name as a child of the span in ctx or, if ctx
+	// has none, of traceparent: the W3C traceparent of a remote parent, empty
+	// for none. The returned context holds the new span.
+	Start(ctx context.Context, name, traceparent string) (context.Context, Span)
+}
+
+// Span is an operation being traced.
+type Span interface {
+	// SetAttribute records a detail of the operation.
+	SetAttribute(key, value string)
+	// TraceParent returns the span's W3C traceparent, which Clients send to
+	// the daemon so its spans join the trace; empty to send none.
+	TraceParent() string
+	// End ends the span, marking it failed if err isn't nil.
+	End(err error)
+}
+
+// TraceCommand is the command prefix carrying a client's trace context on
+// connections speaking protocol version 3: "TRACE <traceparent> GET p a k".
+const TraceCommand = "TRACE"
+
+// WithTracer starts a span for every command the client sends, as a child of
+// the span in the context given to WithContext, and passes the span on to
+// the daemon, whose own spans then join the trace.
+func WithTracer(tracer Tracer) ClientOption {
+	return func(c *Client) {
+		c.tracer = tracer
+	}
+}
+
+// WithContext returns a view of the client whose commands belong to ctx: with
+// WithTracer, their spans are children of the span in ctx, and interceptors
+// see ctx in Operation.Context. The view shares the client's connection and
+// options, so it is cheap to make per request; closing it closes the client.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	view := *c
+	view.ctx = ctx
+	return &view
+}
+
+// context returns the context commands belong to.
+func (c *Client) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// startSpan starts the span of a command, if the client has a tracer.
+func (c *Client) startSpan(ctx context.Context, cmd string) (context.Context, Span) {
+	if c.tracer == nil {
+		return ctx, nil
+	}
+	name, _, _ := strings.Cut(cmd, " ")
+	ctx, span := c.tracer.Start(ctx, "celerix "+name, "")
+	span.SetAttribute("db.system", "celerix")
+	span.SetAttribute("db.operation", name)
+	span.SetAttribute("server.address", c.addr)
+	return ctx, span
+}
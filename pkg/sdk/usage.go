@@ -0,0 +1,86 @@
+package sdk
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// AppUsage is the number of keys and approximate size of a single persona/app pair.
+type AppUsage struct {
+	PersonaID string `json:"persona_id"`
+	AppID     string `json:"app_id"`
+	Keys      int    `json:"keys"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// UsageReport lists the usage of every persona/app pair, sorted by persona
+// and app, with the totals.
+type UsageReport struct {
+	Apps  []AppUsage `json:"apps"`
+	Keys  int        `json:"keys"`
+	Bytes int64      `json:"bytes"`
+}
+
+// UsageReporter counts keys and bytes per persona and app without copying
+// values. It is optional: use Usage, which falls back to reading every app.
+type UsageReporter interface {
+	Usage() (UsageReport, error)
+}
+
+// Usage reports how many keys and bytes each app of each persona of s holds,
+// e.g. for capacity dashboards. Sizes are approximate: a MemStore reports the
+// memory it holds, as in Stats, and other stores the length of the values
+// encoded as JSON.
+func Usage(s CelerixStore) (UsageReport, error) {
+	if ur, ok := s.(UsageReporter); ok {
+		return ur.Usage()
+	}
+	personas, err := s.GetPersonas()
+	if err != nil {
+		return UsageReport{}, err
+	}
+	sort.Strings(personas)
+	report := UsageReport{Apps: []AppUsage{}}
+	for _, personaID := range personas {
+		apps, err := s.GetApps(personaID)
+		if err != nil {
+			return UsageReport{}, err
+		}
+		sort.Strings(apps)
+		for _, appID := range apps {
+			data, err := s.GetAppStore(personaID, appID)
+			if IsNotFound(err) {
+				continue // Deleted meanwhile
+			}
+			if err != nil {
+				return UsageReport{}, err
+			}
+			usage := AppUsage{PersonaID: personaID, AppID: appID, Keys: len(data)}
+			for key, val := range data {
+				encoded, _ := json.Marshal(val)
+				usage.Bytes += int64(len(key) + len(encoded))
+			}
+			report.Add(usage)
+		}
+	}
+	return report, nil
+}
+
+// Add appends the usage of an app and adds it to the totals.
+func (r *UsageReport) Add(usage AppUsage) {
+	r.Apps = append(r.Apps, usage)
+	r.Keys += usage.Keys
+	r.Bytes += usage.Bytes
+}
+
+// Usage fetches the daemon's usage report with USAGE.
+func (c *Client) Usage() (UsageReport, error) {
+	resp, err := c.sendAndReceive("USAGE")
+	if err != nil {
+		return UsageReport{}, err
+	}
+	var report UsageReport
+	err = json.Unmarshal([]byte(strings.TrimPrefix(resp, "OK ")), &report)
+	return report, err
+}
@@ -0,0 +1,276 @@
+package sdk
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/internal/vault"
+	"github.com/celerix-dev/celerix-store/pkg/schema"
+)
+
+// UsersApp and UsernamesApp are the apps in the _system persona holding user
+// records by ID and user IDs by lower-cased username.
+const (
+	UsersApp     = "users"
+	UsernamesApp = "usernames"
+)
+
+var (
+	// ErrUsernameTaken is returned when creating a user whose username is in use.
+	ErrUsernameTaken = errors.New("username taken")
+	// ErrUserDisabled is returned when a disabled user tries to authenticate.
+	ErrUserDisabled = errors.New("user disabled")
+	// ErrWrongRecoveryCode is returned when a recovery code doesn't match.
+	ErrWrongRecoveryCode = errors.New("wrong recovery code")
+	// ErrWrongCredentials is returned by Authenticate for an unknown username
+	// or a wrong password, without saying which.
+	ErrWrongCredentials = NewProtocolError(CodeUnauthorized, "wrong username or password")
+)
+
+// activityInterval is how stale LastActive may get before Touch rewrites it,
+// so busy users don't cause a write per request.
+const activityInterval = time.Minute
+
+// UserStore manages schema.UserRecord users in the _system persona. A user's
+// ID doubles as the ID of their persona.
+type UserStore struct {
+	store KVStore
+}
+
+// NewUserStore keeps users in _system/users, with a username index in
+// _system/usernames.
+func NewUserStore(s KVStore) *UserStore {
+	return &UserStore{store: s}
+}
+
+// Create adds a user and returns it with its recovery code, which is only
+// stored as a hash and can't be shown again.
+func (u *UserStore) Create(username, displayName string) (schema.UserRecord, string, error) {
+	if err := ValidateID("username", username); err != nil {
+		return schema.UserRecord{}, "", err
+	}
+	name := strings.ToLower(username)
+	if _, err := u.store.Get(SystemPersona, UsernamesApp, name); err == nil {
+		return schema.UserRecord{}, "", ErrUsernameTaken
+	} else if !IsNotFound(err) {
+		return schema.UserRecord{}, "", err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return schema.UserRecord{}, "", err
+	}
+	code, hash, err := newRecoveryCode()
+	if err != nil {
+		return schema.UserRecord{}, "", err
+	}
+	now := time.Now().UTC()
+	user := schema.UserRecord{
+		ID:           hex.EncodeToString(id),
+		Username:     username,
+		DisplayName:  displayName,
+		RecoveryCode: hash,
+		CreatedAt:    now,
+	}
+	if err := u.store.Set(SystemPersona, UsersApp, user.ID, user); err != nil {
+		return schema.UserRecord{}, "", err
+	}
+	if err := u.store.Set(SystemPersona, UsernamesApp, name, user.ID); err != nil {
+		u.store.Delete(SystemPersona, UsersApp, user.ID)
+		return schema.UserRecord{}, "", err
+	}
+	return user, code, nil
+}
+
+// Get returns a user by ID.
+func (u *UserStore) Get(id string) (schema.UserRecord, error) {
+	return Get[schema.UserRecord](u.store, SystemPersona, UsersApp, id)
+}
+
+// Lookup returns a user by username, ignoring case.
+func (u *UserStore) Lookup(username string) (schema.UserRecord, error) {
+	id, err := Get[string](u.store, SystemPersona, UsernamesApp, strings.ToLower(username))
+	if err != nil {
+		return schema.UserRecord{}, err
+	}
+	return u.Get(id)
+}
+
+// List returns every user, sorted by username. It needs a store that can dump
+// an app, such as the engine or the daemon client.
+func (u *UserStore) List() ([]schema.UserRecord, error) {
+	exporter, ok := u.store.(BatchExporter)
+	if !ok {
+		return nil, fmt.Errorf("listing users: %w", ErrNotSupported)
+	}
+	data, err := exporter.GetAppStore(SystemPersona, UsersApp)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	users := make([]schema.UserRecord, 0, len(data))
+	for id := range data {
+		user, err := u.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+// Update changes a user's display name.
+func (u *UserStore) Update(id, displayName string) (schema.UserRecord, error) {
+	return u.modify(id, func(user *schema.UserRecord) { user.DisplayName = displayName })
+}
+
+// SetDisabled disables or re-enables a user. Disabled users can't authenticate,
+// but their data is kept.
+func (u *UserStore) SetDisabled(id string, disabled bool) (schema.UserRecord, error) {
+	return u.modify(id, func(user *schema.UserRecord) { user.Disabled = disabled })
+}
+
+// ResetRecoveryCode replaces a user's recovery code and returns the new one.
+func (u *UserStore) ResetRecoveryCode(id string) (string, error) {
+	code, hash, err := newRecoveryCode()
+	if err != nil {
+		return "", err
+	}
+	if _, err := u.modify(id, func(user *schema.UserRecord) { user.RecoveryCode = hash }); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// SetPassword sets the password a user logs in with.
+func (u *UserStore) SetPassword(id, password string) error {
+	if password == "" {
+		return fmt.Errorf("empty password: %w", ErrBadRequest)
+	}
+	hash, err := vault.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = u.modify(id, func(user *schema.UserRecord) { user.PasswordHash = hash })
+	return err
+}
+
+// Authenticate checks a username and password and returns the user, marked
+// active. Users without a password can't log in.
+func (u *UserStore) Authenticate(username, password string) (schema.UserRecord, error) {
+	user, err := u.Lookup(username)
+	if err != nil {
+		if IsNotFound(err) {
+			return schema.UserRecord{}, ErrWrongCredentials
+		}
+		return schema.UserRecord{}, err
+	}
+	if !vault.VerifyPassword(password, user.PasswordHash) {
+		return schema.UserRecord{}, ErrWrongCredentials
+	}
+	return u.Touch(user.ID)
+}
+
+// Recover checks a recovery code for username and returns the user. The code
+// stays valid; call ResetRecoveryCode once the user has regained access.
+func (u *UserStore) Recover(username, code string) (schema.UserRecord, error) {
+	user, err := u.Lookup(username)
+	if err != nil {
+		return schema.UserRecord{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashRecoveryCode(code)), []byte(user.RecoveryCode)) != 1 {
+		return schema.UserRecord{}, ErrWrongRecoveryCode
+	}
+	if user.Disabled {
+		return schema.UserRecord{}, ErrUserDisabled
+	}
+	return u.Touch(user.ID)
+}
+
+// Touch records that a user was just active and returns ErrUserDisabled for
+// disabled users. Call it on every authenticated operation; LastActive is
+// rewritten at most once a minute.
+func (u *UserStore) Touch(id string) (schema.UserRecord, error) {
+	user, err := u.Get(id)
+	if err != nil {
+		return schema.UserRecord{}, err
+	}
+	if user.Disabled {
+		return schema.UserRecord{}, ErrUserDisabled
+	}
+	now := time.Now().UTC()
+	if now.Sub(user.LastActive) < activityInterval {
+		return user, nil
+	}
+	user.LastActive = now
+	if err := u.store.Set(SystemPersona, UsersApp, id, user); err != nil {
+		return schema.UserRecord{}, err
+	}
+	return user, nil
+}
+
+// Delete removes a user record and frees its username. The user's persona is
+// left alone. Deleting an unknown user is not an error.
+func (u *UserStore) Delete(id string) error {
+	user, err := u.Get(id)
+	if err != nil {
+		if IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if err := u.store.Delete(SystemPersona, UsersApp, id); err != nil {
+		return err
+	}
+	if err := u.store.Delete(SystemPersona, UsernamesApp, strings.ToLower(user.Username)); err != nil && !IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (u *UserStore) modify(id string, change func(*schema.UserRecord)) (schema.UserRecord, error) {
+	user, err := u.Get(id)
+	if err != nil {
+		return schema.UserRecord{}, err
+	}
+	change(&user)
+	if err := u.store.Set(SystemPersona, UsersApp, id, user); err != nil {
+		return schema.UserRecord{}, err
+	}
+	return user, nil
+}
+
+// newRecoveryCode returns a random code, grouped for reading aloud
+// (XXXXX-XXXXX-XXXXX-XXXXX), and the hash to store.
+func newRecoveryCode() (code, hash string, err error) {
+	b := make([]byte, 25*5/8)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	raw := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+	groups := make([]string, 0, 4)
+	for i := 0; i+5 <= len(raw) && len(groups) < 4; i += 5 {
+		groups = append(groups, raw[i:i+5])
+	}
+	code = strings.Join(groups, "-")
+	return code, hashRecoveryCode(code), nil
+}
+
+// hashRecoveryCode hashes a code, ignoring case and separators so a code read
+// over the phone still matches.
+func hashRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
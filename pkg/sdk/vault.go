@@ -0,0 +1,190 @@
+package sdk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/celerix-dev/celerix-store/internal/vault"
+)
+
+// KeyFromPassphrase derives a 32-byte vault master key from a passphrase with
+// argon2id. The same passphrase and salt always give the same key, so keep the
+// salt (see NewVaultSalt); it need not be secret.
+func KeyFromPassphrase(passphrase string, salt []byte) []byte {
+	return vault.KeyFromPassphrase(passphrase, salt)
+}
+
+// NewVaultSalt returns a random salt for KeyFromPassphrase.
+func NewVaultSalt() ([]byte, error) {
+	return vault.NewSalt()
+}
+
+// PassphraseVault is like app.Vault, but derives keys from a passphrase
+// instead of taking a raw 32-byte key. The salt and KDF parameters are stored
+// with every value, so nothing but the passphrase has to be kept. Deriving a
+// key is deliberately slow; it happens once per salt and scope.
+func PassphraseVault(app AppScope, passphrase string) VaultScope {
+	return &scopedVault{app: app, passphrase: vault.NewPassphrase(passphrase)}
+}
+
+// KeyVault encrypts values client-side with a raw 32-byte master key on top of
+// any app scope. AppScope implementations outside this package, such as fakes,
+// return it from their Vault method.
+func KeyVault(app AppScope, masterKey []byte) VaultScope {
+	return &scopedVault{app: app, masterKey: masterKey}
+}
+
+// scopedVault encrypts values client-side on top of any app scope, with either
+// a raw master key or a passphrase.
+type scopedVault struct {
+	app interface {
+		Get(key string) (any, error)
+		Set(key string, val any) error
+	}
+	masterKey  []byte
+	passphrase *vault.Passphrase
+}
+
+func (v *scopedVault) Set(key string, plaintext string) error {
+	var ciphertext string
+	var err error
+	if v.passphrase != nil {
+		ciphertext, err = v.passphrase.Encrypt(plaintext)
+	} else {
+		ciphertext, err = vault.Encrypt(plaintext, v.masterKey)
+	}
+	if err != nil {
+		return err
+	}
+	return v.app.Set(key, ciphertext)
+}
+
+func (v *scopedVault) Get(key string) (string, error) {
+	val, err := v.app.Get(key)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, ok := val.(string)
+	if !ok {
+		return "", fmt.Errorf("vault data is not a string")
+	}
+	if v.passphrase != nil {
+		return v.passphrase.Decrypt(ciphertext)
+	}
+	return vault.Decrypt(ciphertext, v.masterKey)
+}
+
+func (v *scopedVault) SetJSON(key string, val any) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return v.Set(key, string(data))
+}
+
+func (v *scopedVault) GetJSON(key string, out any) error {
+	plaintext, err := v.Get(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(plaintext), out)
+}
+
+// VaultRotation reports how far RotateVault got.
+type VaultRotation struct {
+	// Rotated counts values re-encrypted under the new key.
+	Rotated int `json:"rotated"`
+	// Skipped counts values left alone: already under the new key, or not
+	// vault values at all.
+	Skipped int `json:"skipped"`
+	// LastKey is the last key handled. Keys are handled in sorted order, so
+	// passing it back to RotateVault resumes after it.
+	LastKey string `json:"last_key,omitempty"`
+}
+
+// RotateVault re-encrypts every vault value in a persona/app from oldKey to
+// newKey, one key at a time in sorted order. Values that already decrypt with
+// newKey are skipped, so a rotation that stopped part way can simply be run
+// again, or resumed with resumeAfter set to the LastKey it reported (pass ""
+// to start from the beginning).
+//
+// Strings other than vault values are skipped; so are passphrase vault values,
+// which have no master key. A vault value that decrypts with neither key stops
+// the rotation with an error. Writers should switch to newKey before the
+// rotation starts, or values they write under oldKey may be missed. On stores
+// implementing ConditionalWriter, a value written while it is rotated is read
+// again rather than overwritten.
+func RotateVault(s CelerixStore, personaID, appID string, oldKey, newKey []byte, resumeAfter string) (VaultRotation, error) {
+	progress := VaultRotation{LastKey: resumeAfter}
+	values, err := s.GetAppStore(personaID, appID)
+	if err != nil {
+		return progress, err
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		if k > resumeAfter {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		rotated, err := rotateKey(s, personaID, appID, k, oldKey, newKey)
+		if err != nil {
+			return progress, fmt.Errorf("rotate %s: %w", k, err)
+		}
+		if rotated {
+			progress.Rotated++
+		} else {
+			progress.Skipped++
+		}
+		progress.LastKey = k
+	}
+	return progress, nil
+}
+
+// maxRotateAttempts bounds how often rotateKey re-reads a value that keeps
+// changing under it.
+const maxRotateAttempts = 5
+
+// rotateKey re-encrypts one value under newKey and reports whether it did;
+// values that aren't master key vault values are skipped. On a store
+// implementing ConditionalWriter, the value is only replaced if it hasn't
+// changed since it was read, and read again if it has, so a concurrent write
+// isn't overwritten with the old value.
+func rotateKey(s CelerixStore, personaID, appID, key string, oldKey, newKey []byte) (bool, error) {
+	cw, conditional := s.(ConditionalWriter)
+	for attempt := 1; ; attempt++ {
+		// Read again: the value may have changed since the listing
+		val, err := s.Get(personaID, appID, key)
+		if IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		stored, ok := val.(string)
+		if !ok || vault.IsPassphraseEncrypted(stored) {
+			return false, nil
+		}
+		updated, rotated, err := vault.Reencrypt(stored, oldKey, newKey)
+		if err != nil {
+			if !vault.IsEncrypted(stored) {
+				return false, nil // A bare string that isn't a pre-versioning vault value
+			}
+			return false, err
+		}
+		if !rotated {
+			return false, nil
+		}
+		if !conditional {
+			return true, s.Set(personaID, appID, key, updated)
+		}
+		err = cw.SetIfRevision(personaID, appID, key, updated, Revision(stored))
+		if !errors.Is(err, ErrConflict) || attempt == maxRotateAttempts {
+			return err == nil, err
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package sdk
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Change operations reported in a ChangeEvent.
+const (
+	OpSet    = "set"
+	OpDelete = "delete"
+)
+
+// WatchAll can be passed as the persona or app ID to Watch to match every persona or app.
+const WatchAll = "*"
+
+// ChangeEvent describes a single mutation applied to the store.
+type ChangeEvent struct {
+	Op        string    `json:"op"`
+	PersonaID string    `json:"persona_id"`
+	AppID     string    `json:"app_id"`
+	Key       string    `json:"key"`
+	Value     any       `json:"value,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// Watcher streams change events for a persona/app, optionally limited to keys with a prefix.
+// The returned channel is closed when ctx is cancelled or the subscription is dropped
+// (for example because the consumer fell too far behind); callers should re-subscribe
+// and re-read current state if they need to recover.
+type Watcher interface {
+	Watch(ctx context.Context, personaID, appID, prefix string) (<-chan ChangeEvent, error)
+}
+
+// ScopeWatcher is implemented by app scopes of stores that are Watchers, to
+// stream the changes to their persona/app. It is optional: callers should
+// type-assert an AppScope to check for support.
+type ScopeWatcher interface {
+	Watch(ctx context.Context, prefix string) (<-chan ChangeEvent, error)
+}
+
+// Matches reports whether the event falls within a watch on personaID/appID/prefix.
+func (e ChangeEvent) Matches(personaID, appID, prefix string) bool {
+	if personaID != WatchAll && personaID != e.PersonaID {
+		return false
+	}
+	if appID != WatchAll && appID != e.AppID {
+		return false
+	}
+	return strings.HasPrefix(e.Key, prefix)
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"net"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// Authorizer decides whether a connection may run a command, so embedders
+// can plug in their own policy, such as an OPA query or an internal RBAC
+// service. The Router asks it before every command other than HELLO, AUTH,
+// ADMIN, VERSION, PING and QUIT, after authentication and before the _system
+// persona is protected, on the connection's goroutine, so it should be quick.
+//
+// An error it returns refuses the command and is sent to the client. Errors
+// without a protocol code of their own (see sdk.ClassifyError) are sent as
+// unauthorized.
+type Authorizer interface {
+	Authorize(req AuthRequest) error
+}
+
+// AuthorizerFunc lets a function be used as an Authorizer.
+type AuthorizerFunc func(req AuthRequest) error
+
+func (f AuthorizerFunc) Authorize(req AuthRequest) error {
+	return f(req)
+}
+
+// AuthRequest is a command about to run, with who sent it.
+type AuthRequest struct {
+	RemoteAddr net.Addr
+	// UserID is the user the connection authenticated as with AUTH, if any.
+	UserID string
+	// Admin is set once the connection sent the configured admin token.
+	Admin bool
+	// Namespace is the namespace the connection selected with NAMESPACE, or
+	// sdk.DefaultNamespace.
+	Namespace string
+
+	Command string
+	// Args are the command's arguments, as sent, except for the token of
+	// NAMESPACE.
+	Args []string
+	// Write is set for commands that change data. IMPORT is authorized as a
+	// whole, without the personas its records go to.
+	Write bool
+	// PersonaID and AppID are what the command works on, where it names
+	// them: LIST_APPS names only a persona, DUMP_APP only an app, and
+	// LIST_PERSONAS neither. COMPUTE over every persona names "*".
+	PersonaID string
+	AppID     string
+	// DstPersonaID and DstAppID are the destination of MOVE, MOVE_KEY and
+	// MERGE_PERSONA.
+	DstPersonaID string
+	DstAppID     string
+}
+
+// commandTargets lists the positions of the IDs a command names, for
+// AuthRequest. Zero means the command doesn't name one.
+var commandTargets = map[string]struct{ persona, app, dstPersona, dstApp int }{
+	"GET":             {1, 2, 0, 0},
+	"EXISTS":          {1, 2, 0, 0},
+	"MGET":            {1, 2, 0, 0},
+	"SET":             {1, 2, 0, 0},
+	"SET_SYNC":        {1, 2, 0, 0},
+	"SET_MERGE":       {1, 2, 0, 0},
+	"DEL":             {1, 2, 0, 0},
+	"DEL_IF_EQUALS":   {1, 2, 0, 0},
+	"SET_IF_REVISION": {1, 2, 0, 0},
+	"DEL_IF_REVISION": {1, 2, 0, 0},
+	"DEL_PREFIX":      {1, 2, 0, 0},
+	"LIST_APPS":       {1, 0, 0, 0},
+	"DUMP":            {1, 2, 0, 0},
+	"DUMP_APP":        {0, 1, 0, 0},
+	"DUMP_PERSONA":    {1, 0, 0, 0},
+	"DUMP_APP_STREAM": {0, 1, 0, 0},
+	"GET_GLOBAL":      {0, 1, 0, 0},
+	"MOVE":            {1, 3, 2, 3},
+	"PURGE_PERSONA":   {1, 0, 0, 0},
+	"MERGE_PERSONA":   {1, 0, 2, 0},
+	"MOVE_KEY":        {1, 2, 3, 4},
+	"LOCK":            {1, 2, 0, 0},
+	"RENEW":           {1, 2, 0, 0},
+	"UNLOCK":          {1, 2, 0, 0},
+	"ENQUEUE":         {1, 2, 0, 0},
+	"DEQUEUE":         {1, 2, 0, 0},
+	"ACK":             {1, 2, 0, 0},
+	"WATCH":           {1, 2, 0, 0},
+	"BLOB_SET":        {1, 2, 0, 0},
+	"BLOB_GET":        {1, 2, 0, 0},
+	"BLOB_DEL":        {1, 2, 0, 0},
+	"COMPUTE":         {2, 3, 0, 0},
+}
+
+// newAuthRequest describes a command whose arguments were checked against
+// commands.
+func newAuthRequest(conn net.Conn, command string, parts []string) AuthRequest {
+	if command == "NAMESPACE" {
+		parts = parts[:2]
+	}
+	_, write := writeTargets[command]
+	req := AuthRequest{RemoteAddr: conn.RemoteAddr(), Command: command, Args: parts[1:], Write: write || command == "IMPORT"}
+	t := commandTargets[command]
+	arg := func(i int) string {
+		if i == 0 {
+			return ""
+		}
+		return parts[i]
+	}
+	req.PersonaID, req.AppID, req.DstPersonaID, req.DstAppID = arg(t.persona), arg(t.app), arg(t.dstPersona), arg(t.dstApp)
+	return req
+}
+
+// authorize asks the configured Authorizer about req.
+func (r *Router) authorize(req AuthRequest) error {
+	err := r.config.Authorizer.Authorize(req)
+	if err == nil {
+		return nil
+	}
+	if _, code := sdk.ClassifyError(err); code == sdk.CodeInternal {
+		return sdk.NewProtocolError(sdk.CodeUnauthorized, err.Error())
+	}
+	return err
+}
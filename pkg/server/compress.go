@@ -0,0 +1,31 @@
+package server
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// compressConn sends lines longer than sdk.CompressThreshold compressed while
+// enabled is set. Each response line is a single Write.
+type compressConn struct {
+	net.Conn
+	enabled bool
+}
+
+func (c *compressConn) Write(p []byte) (int, error) {
+	if !c.enabled || len(p) <= sdk.CompressThreshold || !bytes.HasSuffix(p, []byte("\n")) {
+		return c.Conn.Write(p)
+	}
+	if _, err := c.Conn.Write([]byte(sdk.CompressLine(string(p[:len(p)-1])) + "\n")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// streamCommands answer with more than one line, or with raw chunks, which
+// are never compressed.
+var streamCommands = map[string]bool{
+	"WATCH": true, "IMPORT": true, "BLOB_SET": true, "BLOB_GET": true, "DUMP_APP_STREAM": true,
+}
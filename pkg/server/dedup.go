@@ -0,0 +1,94 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// maxRequestIDs bounds the request IDs remembered at once, whatever the
+// dedup window; the oldest are forgotten first.
+const maxRequestIDs = 100000
+
+// dedupConn records what is written to a connection while recording is set,
+// so the answer to a request can be replayed when the request is retried.
+type dedupConn struct {
+	net.Conn
+	recording bool
+	answer    []byte
+}
+
+func (c *dedupConn) Write(p []byte) (int, error) {
+	if c.recording {
+		// Recorded even if the write fails: that is when the client retries.
+		c.answer = append(c.answer, p...)
+	}
+	return c.Conn.Write(p)
+}
+
+// request is a write sent with a request ID, and its answer once it has one.
+type request struct {
+	line     string // The command, to catch IDs reused for another one
+	protocol int    // The protocol version answer is in
+	at       time.Time
+	done     chan struct{} // Closed once answer is set
+	answer   []byte
+}
+
+// requestKey identifies a request: IDs are only unique within a namespace.
+type requestKey struct {
+	namespace string
+	id        string
+}
+
+// requestLog remembers recent request IDs and their answers (see
+// sdk.RequestCommand), so that a write retried after its answer was lost is
+// answered again instead of being applied twice.
+type requestLog struct {
+	mu    sync.Mutex
+	byID  map[requestKey]*request
+	order []requestKey // Oldest first
+}
+
+// begin returns the request with ID id in namespace, and whether it is new,
+// in which case the caller runs it, answering in protocol, and must finish
+// it. Requests older than window are forgotten first.
+func (l *requestLog) begin(namespace, id, line string, protocol int, window time.Duration) (*request, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for len(l.order) > 0 {
+		oldest := l.byID[l.order[0]]
+		if now.Sub(oldest.at) < window && len(l.order) < maxRequestIDs {
+			break
+		}
+		delete(l.byID, l.order[0])
+		l.order = l.order[1:]
+	}
+
+	key := requestKey{namespace, id}
+	if req, ok := l.byID[key]; ok {
+		return req, false
+	}
+	if l.byID == nil {
+		l.byID = make(map[requestKey]*request)
+	}
+	req := &request{line: line, protocol: protocol, at: now, done: make(chan struct{})}
+	l.byID[key] = req
+	l.order = append(l.order, key)
+	return req, true
+}
+
+// finish records the answer of a request begun on conn and stops recording.
+func (req *request) finish(conn *dedupConn) {
+	req.answer, conn.answer, conn.recording = conn.answer, nil, false
+	close(req.done)
+}
+
+// dedupable lists the writes a request ID applies to: those answered with a
+// single line. Others, like AUTH or BLOB_SET, run as if it wasn't there.
+func dedupable(command string) bool {
+	_, ok := writeTargets[command]
+	return ok && command != "BLOB_SET"
+}
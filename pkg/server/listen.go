@@ -0,0 +1,29 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+// Listen opens a listener on addr: a Unix domain socket for an address such as
+// "unix:///run/celerix/celerix.sock" (see sdk.UnixSocketPath), otherwise TCP
+// on host:port. A socket file left behind by a daemon that didn't shut down
+// cleanly is replaced; one another process is still listening on is not.
+func Listen(addr string) (net.Listener, error) {
+	path, ok := sdk.UnixSocketPath(addr)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, &net.OpError{Op: "listen", Net: "unix", Addr: &net.UnixAddr{Name: path, Net: "unix"}, Err: errors.New("socket in use")}
+		}
+		os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,1424 @@
+// Package server implements the celerix-stored TCP line protocol, for embedding
+// the daemon's network interface in another process.
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/compute"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+	"github.com/celerix-dev/celerix-store/pkg/version"
+)
+
+// Defaults for RouterConfig fields left at zero.
+const (
+	DefaultMaxConnections = 100
+	DefaultIdleTimeout    = 5 * time.Minute
+	DefaultDedupWindow    = 5 * time.Minute
+)
+
+// authRecheck is how often a connection's access token is verified again, so
+// expiry, revocation and disabled users take effect on open connections.
+const authRecheck = time.Minute
+
+// RouterConfig tunes the TCP server. Zero values use the defaults.
+type RouterConfig struct {
+	// MaxConnections caps concurrently served connections (default DefaultMaxConnections).
+	// Connections beyond it are answered with "ERR server busy" and closed.
+	MaxConnections int
+	// IdleTimeout is how long a connection may wait between commands, or
+	// between lines of an IMPORT stream, before it is closed (default
+	// DefaultIdleTimeout). A negative value lets connections idle forever.
+	// Clients learn it from HELLO and send keepalive PINGs to stay within it.
+	IdleTimeout time.Duration
+	// WriteTimeout bounds writing a single response, so a client that stops
+	// reading can't hold a connection forever. Zero means no limit.
+	WriteTimeout time.Duration
+	// AdminToken, if set, makes the _system persona read-only for connections
+	// that haven't sent ADMIN <token>.
+	AdminToken string
+	// Tokens, if set, lets connections authenticate as a user with
+	// AUTH <access token>.
+	Tokens *sdk.TokenStore
+	// RequireAuth refuses commands other than HELLO, AUTH, ADMIN, VERSION,
+	// PING and QUIT until the connection has sent AUTH or a correct ADMIN.
+	RequireAuth bool
+	// Tracer, if set, gets a span for every command, joining the client's
+	// trace when it sent one with sdk.TraceCommand.
+	Tracer sdk.Tracer
+	// PreciseNumbers decodes numbers in written values as json.Number instead
+	// of float64, so large integers are stored with every digit.
+	PreciseNumbers bool
+	// Authorizer, if set, is asked before every command whether the
+	// connection may run it.
+	Authorizer Authorizer
+	// DedupWindow is how long the answers to writes sent with a request ID
+	// (see sdk.RequestCommand) are kept to answer retries of them (default
+	// DefaultDedupWindow). A negative value turns request IDs off, and with
+	// them protocol version 4, so clients don't resend writes.
+	DedupWindow time.Duration
+	// Compute, if set, runs the functions of the COMPUTE command. Without it
+	// COMPUTE is answered as not supported.
+	Compute *compute.Runner
+}
+
+func (c RouterConfig) withDefaults() RouterConfig {
+	if c.MaxConnections <= 0 {
+		c.MaxConnections = DefaultMaxConnections
+	}
+	if c.IdleTimeout == 0 {
+		c.IdleTimeout = DefaultIdleTimeout
+	}
+	if c.DedupWindow == 0 {
+		c.DedupWindow = DefaultDedupWindow
+	}
+	return c
+}
+
+type Router struct {
+	store      sdk.CelerixStore
+	namespaces map[string]namespace
+	cert       *tls.Certificate
+	config     RouterConfig
+	listener   net.Listener
+	mu         sync.Mutex
+
+	// Connections being served, so Serve can drain them on shutdown.
+	connMu   sync.Mutex
+	conns    map[net.Conn]struct{}
+	draining bool
+	open     sync.WaitGroup
+
+	accepted, rejected atomic.Uint64
+	active             atomic.Int64
+	lastBusyLog        atomic.Int64 // unix seconds
+
+	requests requestLog
+}
+
+func NewRouter(s sdk.CelerixStore) *Router {
+	return &Router{store: s, config: RouterConfig{}.withDefaults()}
+}
+
+// SetCertificate sets the TLS certificate for the router
+func (r *Router) SetCertificate(cert tls.Certificate) {
+	r.cert = &cert
+}
+
+// namespace is a store served under a name besides the default one.
+type namespace struct {
+	store sdk.CelerixStore
+	token string
+}
+
+// AddNamespace serves store as namespace name, which clients select with
+// NAMESPACE <name> [token]. Namespaces are isolated: a connection only ever
+// sees the store of the namespace it selected. An empty token lets any client
+// in. It must be called before Serve.
+func (r *Router) AddNamespace(name string, store sdk.CelerixStore, token string) error {
+	if err := sdk.ValidateNamespace(name); err != nil {
+		return err
+	}
+	if name == sdk.DefaultNamespace {
+		return fmt.Errorf("namespace %q is the router's own store", name)
+	}
+	if r.namespaces == nil {
+		r.namespaces = make(map[string]namespace)
+	}
+	r.namespaces[name] = namespace{store: store, token: token}
+	return nil
+}
+
+// namespace returns the store of a namespace if token opens it. Unknown
+// namespaces are refused like bad tokens, so they can't be probed for.
+func (r *Router) namespace(name, token string) (sdk.CelerixStore, error) {
+	if name == sdk.DefaultNamespace {
+		return r.store, nil
+	}
+	ns, ok := r.namespaces[name]
+	if !ok || subtle.ConstantTimeCompare([]byte(ns.token), []byte(token)) != 1 {
+		return nil, sdk.NewProtocolError(sdk.CodeUnauthorized, "unknown namespace or wrong token")
+	}
+	return ns.store, nil
+}
+
+// SetConfig replaces the server limits and timeouts. It must be called before Serve.
+func (r *Router) SetConfig(config RouterConfig) {
+	r.config = config.withDefaults()
+}
+
+// Stop closes the listener and stops the server
+func (r *Router) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.listener != nil {
+		r.listener.Close()
+		r.listener = nil
+	}
+}
+
+// Listen starts the TCP server
+func (r *Router) Listen(port string) error {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return err
+	}
+	return r.Serve(context.Background(), listener)
+}
+
+// Serve accepts connections on listener, e.g. one inherited through systemd
+// socket activation, until ctx is cancelled or Stop is called. Connections are
+// wrapped in TLS if a certificate is set. On return the listener is closed and
+// every connection has finished: idle ones are closed right away, busy ones
+// after their current command.
+func (r *Router) Serve(ctx context.Context, listener net.Listener) error {
+	// Unix domain sockets stay on the host and are guarded by the socket
+	// file's permissions, so they are served without TLS.
+	if r.cert != nil && listener.Addr().Network() != "unix" {
+		listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{*r.cert}})
+	}
+
+	r.mu.Lock()
+	r.listener = listener
+	r.mu.Unlock()
+
+	stop := context.AfterFunc(ctx, r.Stop)
+	defer stop()
+	defer func() {
+		r.Stop()
+		r.drain()
+	}()
+
+	semaphore := make(chan struct{}, r.config.MaxConnections)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			r.mu.Lock()
+			isNil := r.listener == nil
+			r.mu.Unlock()
+			if isNil {
+				return nil // Graceful shutdown
+			}
+			continue
+		}
+
+		r.track(conn)
+
+		// Admission is decided before the connection gets a goroutine, so a
+		// saturated server answers at once instead of leaving the client hanging.
+		select {
+		case semaphore <- struct{}{}:
+		default:
+			r.rejected.Add(1)
+			go r.reject(conn)
+			continue
+		}
+
+		r.accepted.Add(1)
+		r.active.Add(1)
+		go func(c net.Conn) {
+			defer func() {
+				r.active.Add(-1)
+				<-semaphore
+				c.Close()
+				r.untrack(c)
+			}()
+			r.handleConnection(c)
+		}(conn)
+	}
+}
+
+func (r *Router) track(conn net.Conn) {
+	r.connMu.Lock()
+	defer r.connMu.Unlock()
+	if r.conns == nil {
+		r.conns = make(map[net.Conn]struct{})
+	}
+	r.conns[conn] = struct{}{}
+	r.open.Add(1)
+}
+
+func (r *Router) untrack(conn net.Conn) {
+	r.connMu.Lock()
+	defer r.connMu.Unlock()
+	delete(r.conns, conn)
+	r.open.Done()
+}
+
+// reject tells a client the server is saturated and hangs up.
+func (r *Router) reject(conn net.Conn) {
+	defer r.untrack(conn)
+	defer conn.Close()
+
+	// Logged at most once a second, since rejections come in bursts.
+	if now := time.Now().Unix(); r.lastBusyLog.Swap(now) != now {
+		log.Printf("[Celerix Store] Server busy: rejecting connections beyond %d (%d rejected so far)", r.config.MaxConnections, r.rejected.Load())
+	}
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	// Always in protocol version 1: the client hasn't had a chance to send HELLO.
+	fmt.Fprintln(conn, "ERR", sdk.ErrServerBusy)
+}
+
+// ConnectionStats reports admission control counters.
+func (r *Router) ConnectionStats() sdk.ServerStats {
+	return sdk.ServerStats{
+		ActiveConnections: r.active.Load(),
+		MaxConnections:    r.config.MaxConnections,
+		Accepted:          r.accepted.Load(),
+		Rejected:          r.rejected.Load(),
+	}
+}
+
+// drain makes every connection's next read fail and waits for them to finish.
+func (r *Router) drain() {
+	r.connMu.Lock()
+	r.draining = true
+	for conn := range r.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	r.connMu.Unlock()
+	r.open.Wait()
+
+	r.connMu.Lock()
+	r.draining = false
+	r.connMu.Unlock()
+}
+
+// armWrite bounds writing the next response by WriteTimeout.
+func (r *Router) armWrite(conn net.Conn) {
+	if r.config.WriteTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(r.config.WriteTimeout))
+	}
+}
+
+// armRead sets the deadline for the next read on conn: timeout from now, no
+// deadline for timeout 0, or immediately while the server is shutting down.
+func (r *Router) armRead(conn net.Conn, timeout time.Duration) {
+	r.connMu.Lock()
+	defer r.connMu.Unlock()
+	switch {
+	case r.draining:
+		conn.SetReadDeadline(time.Now())
+	case timeout > 0:
+		conn.SetReadDeadline(time.Now().Add(timeout))
+	default:
+		conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// commands lists every command with its minimum number of arguments, so that
+// malformed commands get an error instead of leaving the client waiting for a
+// response that never comes.
+var commands = map[string]struct {
+	args  int
+	usage string
+}{
+	"GET":             {3, "GET <persona> <app> <key> [fields]"},
+	"EXISTS":          {3, "EXISTS <persona> <app> <key>"},
+	"MGET":            {3, "MGET <persona> <app> <key> [key...]"},
+	"SET":             {4, "SET <persona> <app> <key> <json>"},
+	"SET_SYNC":        {4, "SET_SYNC <persona> <app> <key> <json>"},
+	"SET_MERGE":       {4, "SET_MERGE <persona> <app> <key> <json merge patch>"},
+	"DEL":             {3, "DEL <persona> <app> <key>"},
+	"DEL_IF_EQUALS":   {4, "DEL_IF_EQUALS <persona> <app> <key> <json>"},
+	"SET_IF_REVISION": {5, "SET_IF_REVISION <persona> <app> <key> <revision|-> <json>"},
+	"DEL_IF_REVISION": {4, "DEL_IF_REVISION <persona> <app> <key> <revision>"},
+	"DEL_PREFIX":      {3, "DEL_PREFIX <persona> <app> <prefix>"},
+	"LIST_PERSONAS":   {0, "LIST_PERSONAS [VERBOSE]"},
+	"LIST_APPS":       {1, "LIST_APPS <persona> [VERBOSE]"},
+	"DUMP":            {2, "DUMP <persona> <app> [fields]"},
+	"DUMP_APP":        {1, "DUMP_APP <app>"},
+	"DUMP_PERSONA":    {1, "DUMP_PERSONA <persona>"},
+	"DUMP_APP_STREAM": {1, "DUMP_APP_STREAM <app> [after persona]"},
+	"GET_GLOBAL":      {2, "GET_GLOBAL <app> <key>"},
+	"SEARCH":          {1, "SEARCH <key pattern> [app pattern] [limit] [after]"},
+	"MOVE":            {4, "MOVE <source persona> <destination persona> <app> <key>"},
+	"PURGE_PERSONA":   {1, "PURGE_PERSONA <persona>"},
+	"MERGE_PERSONA":   {2, "MERGE_PERSONA <source persona> <destination persona> [fail-on-conflict|prefer-src|prefer-dst]"},
+	"MOVE_KEY":        {6, "MOVE_KEY <source persona> <source app> <destination persona> <destination app> <source key> <destination key>"},
+	"LOCK":            {4, "LOCK <persona> <app> <name> <ttl ms>"},
+	"RENEW":           {5, "RENEW <persona> <app> <name> <token> <ttl ms>"},
+	"UNLOCK":          {4, "UNLOCK <persona> <app> <name> <token>"},
+	"ENQUEUE":         {4, "ENQUEUE <persona> <app> <queue> <json>"},
+	"DEQUEUE":         {3, "DEQUEUE <persona> <app> <queue> [visibility ms]"},
+	"ACK":             {4, "ACK <persona> <app> <queue> <receipt>"},
+	"WATCH":           {2, "WATCH <persona> <app> [prefix]"},
+	"IMPORT":          {0, "IMPORT [skip]"},
+	"BLOB_SET":        {3, "BLOB_SET <persona> <app> <key>, followed by chunks"},
+	"BLOB_GET":        {3, "BLOB_GET <persona> <app> <key>"},
+	"BLOB_DEL":        {3, "BLOB_DEL <persona> <app> <key>"},
+	"COMPUTE":         {3, "COMPUTE <function> <persona|*> <app> [args json]"},
+	"STATS":           {0, "STATS"},
+	"USAGE":           {0, "USAGE"},
+	"NAMESPACE":       {1, "NAMESPACE <name> [token]"},
+	"ADMIN":           {1, "ADMIN <token>"},
+	"AUTH":            {1, "AUTH <access token>"},
+	"HELLO":           {0, "HELLO [version] [gzip]"},
+	"VERSION":         {0, "VERSION"},
+	"INFO":            {0, "INFO"},
+	"FSCK":            {0, "FSCK"},
+	"PING":            {0, "PING"},
+	"QUIT":            {0, "QUIT"},
+}
+
+// writeTargets lists the commands that write, with the positions of the
+// persona IDs they write to, for protecting the _system persona.
+var writeTargets = map[string][]int{
+	"SET":             {1},
+	"SET_SYNC":        {1},
+	"SET_MERGE":       {1},
+	"DEL":             {1},
+	"DEL_IF_EQUALS":   {1},
+	"SET_IF_REVISION": {1},
+	"DEL_IF_REVISION": {1},
+	"DEL_PREFIX":      {1},
+	"MOVE":            {1, 2},
+	"MOVE_KEY":        {1, 3},
+	"MERGE_PERSONA":   {1, 2},
+	"PURGE_PERSONA":   {1},
+	"LOCK":            {1},
+	"RENEW":           {1},
+	"UNLOCK":          {1},
+	"ENQUEUE":         {1},
+	"DEQUEUE":         {1},
+	"ACK":             {1},
+	"BLOB_SET":        {1},
+	"BLOB_DEL":        {1},
+}
+
+// authExempt lists the commands allowed before authenticating when
+// RouterConfig.RequireAuth is set.
+var authExempt = map[string]bool{
+	"HELLO": true, "AUTH": true, "ADMIN": true, "VERSION": true, "PING": true, "QUIT": true,
+}
+
+func (r *Router) HandleConnection(conn net.Conn) {
+	r.handleConnection(conn)
+}
+
+func (r *Router) handleConnection(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+
+	// Connections speak protocol version 1 until the client sends HELLO, and
+	// use the default namespace until they send NAMESPACE.
+	protocol := 1
+	store := r.store
+	admin := r.config.AdminToken == ""
+	adminAuthed := false         // ADMIN with the configured token also counts for RequireAuth
+	var authToken, userID string // Set once AUTH succeeds
+	var authCheckedAt time.Time
+	namespaceName := sdk.DefaultNamespace
+
+	// The span of the command being served ends once it has been answered,
+	// before the next command is read.
+	var span sdk.Span
+	var spanErr error
+	endSpan := func() {
+		if span != nil {
+			span.End(spanErr)
+			span, spanErr = nil, nil
+		}
+	}
+	defer endSpan()
+	fail := func(err error) {
+		spanErr = err
+		writeErr(conn, protocol, err)
+	}
+
+	// Long answers are compressed once HELLO negotiated it. The answer to a
+	// command sent with a request ID is recorded, uncompressed, until the
+	// next command is read.
+	compress := &compressConn{Conn: conn}
+	compressing := false
+	rec := &dedupConn{Conn: compress}
+	conn = rec
+	var pending *request
+	finishRequest := func() {
+		if pending != nil {
+			pending.finish(rec)
+			pending = nil
+		}
+	}
+	defer finishRequest()
+
+	for {
+		endSpan()
+		finishRequest()
+
+		// Set a deadline for the next command
+		r.armRead(conn, r.config.IdleTimeout)
+
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return // Connection closed or timeout
+		}
+		r.armWrite(conn)
+
+		line = strings.TrimSpace(line)
+		if compressing {
+			if line, err = sdk.DecompressLine(line, sdk.MaxDecompressedLine); err != nil {
+				fail(sdk.NewProtocolError(sdk.CodeBadRequest, err.Error()))
+				continue
+			}
+		}
+		parts := strings.Fields(line)
+		if len(parts) < 1 {
+			continue
+		}
+		// TRACE <traceparent> <command> carries the client's trace context
+		traceparent := ""
+		if len(parts) > 2 && strings.ToUpper(parts[0]) == sdk.TraceCommand {
+			traceparent, parts = parts[1], parts[2:]
+		}
+		// REQ <id> <command> lets a retry of a write be answered without
+		// running it again
+		requestID := ""
+		if len(parts) > 2 && strings.ToUpper(parts[0]) == sdk.RequestCommand {
+			requestID, parts = parts[1], parts[2:]
+		}
+
+		command := strings.ToUpper(parts[0])
+		compress.enabled = compressing && !streamCommands[command]
+		syntax, known := commands[command]
+		if !known {
+			fail(sdk.NewProtocolError(sdk.CodeUnknownCommand, "unknown command"))
+			continue
+		}
+		if r.config.Tracer != nil {
+			_, span = r.config.Tracer.Start(context.Background(), "celerix.server "+command, traceparent)
+			span.SetAttribute("db.system", "celerix")
+			span.SetAttribute("db.operation", command)
+		}
+		if len(parts) <= syntax.args {
+			fail(sdk.NewProtocolError(sdk.CodeBadRequest, "usage: "+syntax.usage))
+			continue
+		}
+
+		if authToken != "" && time.Since(authCheckedAt) > authRecheck {
+			if _, err := r.config.Tokens.Verify(authToken); err != nil {
+				authToken, userID = "", ""
+			} else {
+				authCheckedAt = time.Now()
+			}
+		}
+		if r.config.RequireAuth && authToken == "" && !adminAuthed && !authExempt[command] {
+			fail(sdk.NewProtocolError(sdk.CodeUnauthorized, "authentication required: send AUTH <token>"))
+			if command == "BLOB_SET" {
+				return // The chunks that follow can't be told apart from commands
+			}
+			continue
+		}
+
+		if r.config.Authorizer != nil && !authExempt[command] {
+			req := newAuthRequest(conn, command, parts)
+			req.UserID, req.Admin, req.Namespace = userID, adminAuthed, namespaceName
+			if err := r.authorize(req); err != nil {
+				fail(err)
+				if command == "BLOB_SET" {
+					return // The chunks that follow can't be told apart from commands
+				}
+				continue
+			}
+		}
+
+		if targets, ok := writeTargets[command]; ok && !admin {
+			var personaIDs []string
+			for _, i := range targets {
+				personaIDs = append(personaIDs, parts[i])
+			}
+			if err := sdk.CheckSystemWrite(personaIDs...); err != nil {
+				fail(err)
+				if command == "BLOB_SET" {
+					return // The chunks that follow can't be told apart from commands
+				}
+				continue
+			}
+		}
+
+		if requestID != "" && r.config.DedupWindow > 0 && dedupable(command) {
+			cmdline := strings.Join(parts, " ")
+			req, first := r.requests.begin(namespaceName, requestID, cmdline, protocol, r.config.DedupWindow)
+			if !first {
+				if req.line != cmdline {
+					fail(sdk.NewProtocolError(sdk.CodeBadRequest, "request ID reused for another command"))
+					continue
+				}
+				if req.protocol != protocol {
+					fail(sdk.NewProtocolError(sdk.CodeBadRequest, "request ID answered in another protocol version"))
+					continue
+				}
+				<-req.done // A retry may arrive before the first attempt is answered
+				conn.Write(req.answer)
+				continue
+			}
+			pending, rec.recording = req, true
+		}
+
+		switch command {
+		case "GET":
+			if len(parts) == 4 {
+				// Stores keeping values encoded answer without marshaling
+				res, err := sdk.GetRaw(store, parts[1], parts[2], parts[3])
+				if err != nil {
+					fail(err)
+				} else {
+					fmt.Fprintln(conn, "OK", string(res))
+				}
+				continue
+			}
+			val, err := store.Get(parts[1], parts[2], parts[3])
+			if err == nil {
+				// GET persona app key field1,field2
+				val = sdk.Project(val, sdk.ParseFields(parts[4]))
+			}
+			if err != nil {
+				fail(err)
+			} else {
+				// Send back as JSON
+				res, err := json.Marshal(val)
+				if err != nil {
+					fail(sdk.ErrInternal)
+				} else {
+					fmt.Fprintln(conn, "OK", string(res))
+				}
+			}
+
+		case "EXISTS":
+			ok, err := sdk.Exists(store, parts[1], parts[2], parts[3])
+			if err != nil {
+				fail(err)
+			} else {
+				fmt.Fprintln(conn, "OK", ok)
+			}
+
+		case "MGET":
+			// MGET persona app key1 key2 ...
+			values, err := sdk.MGet(store, parts[1], parts[2], parts[3:])
+			if err != nil {
+				fail(err)
+			} else {
+				res, err := json.Marshal(values)
+				if err != nil {
+					fail(sdk.ErrInternal)
+				} else {
+					fmt.Fprintln(conn, "OK", string(res))
+				}
+			}
+
+		case "SET", "SET_SYNC":
+			// The value is everything after the 4th word
+			valueStr := strings.Join(parts[4:], " ")
+			var val any
+			if err := sdk.DecodeValue([]byte(valueStr), &val, r.config.PreciseNumbers); err != nil {
+				fail(sdk.NewProtocolError(sdk.CodeBadRequest, "invalid json value"))
+				continue
+			}
+
+			var err error
+			if command == "SET_SYNC" {
+				// Answered once the value is on disk
+				err = sdk.SetSync(store, parts[1], parts[2], parts[3], val)
+			} else {
+				err = store.Set(parts[1], parts[2], parts[3], val)
+			}
+			if err != nil {
+				fail(err)
+			} else {
+				fmt.Fprintln(conn, "OK")
+			}
+
+		case "SET_MERGE":
+			merger, ok := store.(sdk.Merger)
+			if !ok {
+				fail(sdk.NewProtocolError(sdk.CodeNotSupported, "merge not supported"))
+				continue
+			}
+			// SET_MERGE persona app key <json merge patch>
+			var patch any
+			if err := sdk.DecodeValue([]byte(strings.Join(parts[4:], " ")), &patch, r.config.PreciseNumbers); err != nil {
+				fail(sdk.NewProtocolError(sdk.CodeBadRequest, "invalid json value"))
+				continue
+			}
+			merged, err := merger.Merge(parts[1], parts[2], parts[3], patch)
+			if err != nil {
+				fail(err)
+			} else {
+				res, err := json.Marshal(merged)
+				if err != nil {
+					fail(sdk.ErrInternal)
+				} else {
+					fmt.Fprintln(conn, "OK", string(res))
+				}
+			}
+
+		case "DEL":
+			err := store.Delete(parts[1], parts[2], parts[3])
+			if err != nil {
+				fail(err)
+			} else {
+				fmt.Fprintln(conn, "OK")
+			}
+
+		case "DEL_IF_EQUALS":
+			pruner, ok := store.(sdk.Pruner)
+			if !ok {
+				fail(sdk.NewProtocolError(sdk.CodeNotSupported, "pruning not supported"))
+				continue
+			}
+			// DEL_IF_EQUALS persona app key <json>
+			var expected any
+			if err := sdk.DecodeValue([]byte(strings.Join(parts[4:], " ")), &expected, r.config.PreciseNumbers); err != nil {
+				fail(sdk.NewProtocolError(sdk.CodeBadRequest, "invalid json value"))
+				continue
+			}
+			if err := pruner.DeleteIfEquals(parts[1], parts[2], parts[3], expected); err != nil {
+				fail(err)
+			} else {
+				fmt.Fprintln(conn, "OK")
+			}
+
+		case "SET_IF_REVISION", "DEL_IF_REVISION":
+			writer, ok := store.(sdk.ConditionalWriter)
+			if !ok {
+				fail(sdk.NewProtocolError(sdk.CodeNotSupported, "conditional writes not supported"))
+				continue
+			}
+			// SET_IF_REVISION persona app key <revision> <json>, with "-"
+			// for the revision of a missing value
+			rev := parts[4]
+			if rev == "-" {
+				rev = ""
+			}
+			var err error
+			if command == "SET_IF_REVISION" {
+				var val any
+				if err := sdk.DecodeValue([]byte(strings.Join(parts[5:], " ")), &val, r.config.PreciseNumbers); err != nil {
+					fail(sdk.NewProtocolError(sdk.CodeBadRequest, "invalid json value"))
+					continue
+				}
+				err = writer.SetIfRevision(parts[1], parts[2], parts[3], val, rev)
+			} else {
+				err = writer.DeleteIfRevision(parts[1], parts[2], parts[3], rev)
+			}
+			if err != nil {
+				fail(err)
+			} else {
+				fmt.Fprintln(conn, "OK")
+			}
+
+		case "DEL_PREFIX":
+			n, err := sdk.DeletePrefix(store, parts[1], parts[2], parts[3])
+			if err != nil {
+				fail(err)
+			} else {
+				fmt.Fprintln(conn, "OK", n)
+			}
+
+		case "LIST_PERSONAS":
+			var list any
+			personas, err := store.GetPersonas()
+			if err == nil && len(parts) > 1 && strings.EqualFold(parts[1], "VERBOSE") {
+				list, err = sdk.DescribePersonas(store, personas)
+			} else {
+				list = personas
+			}
+			if err != nil {
+				fail(err)
+			} else {
+				res, err := json.Marshal(list)
+				if err != nil {
+					fail(sdk.ErrInternal)
+				} else {
+					fmt.Fprintln(conn, "OK", string(res))
+				}
+			}
+
+		case "LIST_APPS":
+			var list any
+			apps, err := store.GetApps(parts[1])
+			if err == nil && len(parts) > 2 && strings.EqualFold(parts[2], "VERBOSE") {
+				list, err = sdk.DescribeApps(store, parts[1], apps)
+			} else {
+				list = apps
+			}
+			if err != nil {
+				fail(err)
+			} else {
+				res, err := json.Marshal(list)
+				if err != nil {
+					fail(sdk.ErrInternal)
+				} else {
+					fmt.Fprintln(conn, "OK", string(res))
+				}
+			}
+
+		case "DUMP":
+			if len(parts) == 3 {
+				raw, err := sdk.GetAppStoreRaw(store, parts[1], parts[2])
+				if err != nil {
+					fail(err)
+				} else if res, err := json.Marshal(raw); err != nil {
+					fail(sdk.ErrInternal)
+				} else {
+					fmt.Fprintln(conn, "OK", string(res))
+				}
+				continue
+			}
+			data, err := store.GetAppStore(parts[1], parts[2])
+			if err == nil && len(parts) > 3 {
+				// DUMP persona app field1,field2
+				fields := sdk.ParseFields(parts[3])
+				for k, v := range data {
+					data[k] = sdk.Project(v, fields)
+				}
+			}
+			if err != nil {
+				fail(err)
+			} else {
+				res, err := json.Marshal(data)
+				if err != nil {
+					fail(sdk.ErrInternal)
+				} else {
+					fmt.Fprintln(conn, "OK", string(res))
+				}
+			}
+
+		case "DUMP_APP":
+			data, err := store.DumpApp(parts[1])
+			if err != nil {
+				fail(err)
+			} else {
+				res, err := json.Marshal(data)
+				if err != nil {
+					fail(sdk.ErrInternal)
+				} else {
+					fmt.Fprintln(conn, "OK", string(res))
+				}
+			}
+
+		case "COMPUTE":
+			// COMPUTE function persona app [args], persona * for all of them
+			if r.config.Compute == nil {
+				fail(sdk.NewProtocolError(sdk.CodeNotSupported, "compute functions are not enabled"))
+				continue
+			}
+			var args any
+			if len(parts) > 4 {
+				if err := json.Unmarshal([]byte(strings.Join(parts[4:], " ")), &args); err != nil {
+					fail(sdk.NewProtocolError(sdk.CodeBadRequest, "invalid json args"))
+					continue
+				}
+			}
+			res, err := r.config.Compute.Compute(context.Background(), store, parts[1], parts[2], parts[3], args)
+			if err != nil {
+				fail(err)
+			} else {
+				fmt.Fprintln(conn, "OK", string(res))
+			}
+
+		case "DUMP_PERSONA":
+			data, err := sdk.GetPersona(store, parts[1])
+			if err != nil {
+				fail(err)
+			} else {
+				res, err := json.Marshal(data)
+				if err != nil {
+					fail(sdk.ErrInternal)
+				} else {
+					fmt.Fprintln(conn, "OK", string(res))
+				}
+			}
+
+		case "DUMP_APP_STREAM":
+			// DUMP_APP_STREAM app [after]
+			after := ""
+			if len(parts) > 2 {
+				after = parts[2]
+			}
+			if !r.streamApp(conn, protocol, store, parts[1], after) {
+				return
+			}
+
+		case "SEARCH":
+			// SEARCH <key pattern> [app pattern] [limit] [after]
+			var opts sdk.SearchOptions
+			if len(parts) > 2 && parts[2] != "*" {
+				opts.App = parts[2]
+			}
+			if len(parts) > 3 {
+				n, err := strconv.Atoi(parts[3])
+				if err != nil || n < 0 {
+					fail(sdk.NewProtocolError(sdk.CodeBadRequest, "invalid limit"))
+					continue
+				}
+				opts.Limit = n
+			}
+			if len(parts) > 4 {
+				opts.After = parts[4]
+			}
+			res, err := sdk.Search(store, parts[1], opts)
+			if err != nil {
+				fail(err)
+			} else {
+				out, _ := json.Marshal(res)
+				fmt.Fprintln(conn, "OK", string(out))
+			}
+
+		case "USAGE":
+			report, err := sdk.Usage(store)
+			if err != nil {
+				fail(err)
+			} else {
+				out, _ := json.Marshal(report)
+				fmt.Fprintln(conn, "OK", string(out))
+			}
+
+		case "GET_GLOBAL":
+			val, personaID, err := store.GetGlobal(parts[1], parts[2])
+			if err != nil {
+				fail(err)
+			} else {
+				// We return a small JSON object with both value and persona
+				out := map[string]any{
+					"persona": personaID,
+					"value":   val,
+				}
+				final, err := json.Marshal(out)
+				if err != nil {
+					fail(sdk.ErrInternal)
+				} else {
+					fmt.Fprintln(conn, "OK", string(final))
+				}
+			}
+
+		case "MOVE":
+			// MOVE src dst app key
+			err := store.Move(parts[1], parts[2], parts[3], parts[4])
+			if err != nil {
+				fail(err)
+			} else {
+				fmt.Fprintln(conn, "OK")
+			}
+
+		case "MOVE_KEY":
+			// MOVE_KEY srcPersona srcApp dstPersona dstApp srcKey dstKey
+			mover, ok := store.(sdk.KeyMover)
+			if !ok {
+				fail(sdk.NewProtocolError(sdk.CodeNotSupported, "moving keys not supported"))
+				continue
+			}
+			if err := mover.MoveKey(parts[1], parts[2], parts[3], parts[4], parts[5], parts[6]); err != nil {
+				fail(err)
+			} else {
+				fmt.Fprintln(conn, "OK")
+			}
+
+		case "MERGE_PERSONA":
+			// MERGE_PERSONA src dst [strategy]
+			merger, ok := store.(sdk.PersonaMerger)
+			if !ok {
+				fail(sdk.NewProtocolError(sdk.CodeNotSupported, "merging personas not supported"))
+				continue
+			}
+			strategy := ""
+			if len(parts) > 3 {
+				strategy = parts[3]
+			}
+			if err := merger.MergePersona(parts[1], parts[2], sdk.MergeStrategy(strategy)); err != nil {
+				fail(err)
+			} else {
+				fmt.Fprintln(conn, "OK")
+			}
+
+		case "PURGE_PERSONA":
+			purger, ok := store.(sdk.Purger)
+			if !ok {
+				fail(sdk.NewProtocolError(sdk.CodeNotSupported, "purging personas not supported"))
+				continue
+			}
+			report, err := purger.PurgePersona(parts[1])
+			if err != nil {
+				fail(err)
+				continue
+			}
+			res, _ := json.Marshal(report)
+			fmt.Fprintln(conn, "OK", string(res))
+
+		case "LOCK", "RENEW", "UNLOCK":
+			locker, ok := store.(sdk.Locker)
+			if !ok {
+				fail(sdk.NewProtocolError(sdk.CodeNotSupported, "locks not supported"))
+				continue
+			}
+			lease := sdk.Lease{PersonaID: parts[1], AppID: parts[2], Name: parts[3]}
+			var ttl time.Duration
+			if command != "UNLOCK" {
+				ms, err := strconv.ParseInt(parts[len(parts)-1], 10, 64)
+				if err != nil || ms <= 0 {
+					fail(sdk.NewProtocolError(sdk.CodeBadRequest, "invalid ttl"))
+					continue
+				}
+				ttl = time.Duration(ms) * time.Millisecond
+			}
+			if command != "LOCK" {
+				lease.Token = parts[4]
+			}
+			var err error
+			switch command {
+			case "LOCK":
+				lease, err = locker.Lock(lease.PersonaID, lease.AppID, lease.Name, ttl)
+			case "RENEW":
+				lease, err = locker.Renew(lease, ttl)
+			case "UNLOCK":
+				err = locker.Unlock(lease)
+			}
+			if err != nil {
+				fail(err)
+			} else if command == "UNLOCK" {
+				fmt.Fprintln(conn, "OK")
+			} else {
+				res, _ := json.Marshal(lease)
+				fmt.Fprintln(conn, "OK", string(res))
+			}
+
+		case "ENQUEUE", "DEQUEUE", "ACK":
+			queuer, ok := store.(sdk.Queuer)
+			if !ok {
+				fail(sdk.NewProtocolError(sdk.CodeNotSupported, "queues not supported"))
+				continue
+			}
+			switch command {
+			case "ENQUEUE":
+				var item any
+				if err := sdk.DecodeValue([]byte(strings.Join(parts[4:], " ")), &item, r.config.PreciseNumbers); err != nil {
+					fail(sdk.NewProtocolError(sdk.CodeBadRequest, "invalid json value"))
+					continue
+				}
+				if id, err := queuer.Enqueue(parts[1], parts[2], parts[3], item); err != nil {
+					fail(err)
+				} else {
+					fmt.Fprintln(conn, "OK", id)
+				}
+			case "DEQUEUE":
+				visibility := sdk.DefaultVisibilityTimeout
+				if len(parts) > 4 {
+					ms, err := strconv.ParseInt(parts[4], 10, 64)
+					if err != nil {
+						fail(sdk.NewProtocolError(sdk.CodeBadRequest, "invalid visibility timeout"))
+						continue
+					}
+					visibility = time.Duration(ms) * time.Millisecond
+				}
+				if msg, err := queuer.Dequeue(parts[1], parts[2], parts[3], visibility); err != nil {
+					fail(err)
+				} else {
+					res, _ := json.Marshal(msg)
+					fmt.Fprintln(conn, "OK", string(res))
+				}
+			case "ACK":
+				if err := queuer.Ack(parts[1], parts[2], parts[3], parts[4]); err != nil {
+					fail(err)
+				} else {
+					fmt.Fprintln(conn, "OK")
+				}
+			}
+
+		case "WATCH":
+			watcher, ok := store.(sdk.Watcher)
+			if !ok {
+				fail(sdk.NewProtocolError(sdk.CodeNotSupported, "watch not supported"))
+				continue
+			}
+			// WATCH persona app [prefix]
+			prefix := ""
+			if len(parts) > 3 {
+				prefix = parts[3]
+			}
+			r.streamWatch(conn, reader, protocol, watcher, parts[1], parts[2], prefix)
+			return
+
+		case "IMPORT":
+			// IMPORT [skip], followed by ndjson records and a line containing END
+			opts := sdk.ImportOptions{PreciseNumbers: r.config.PreciseNumbers}
+			if len(parts) > 1 {
+				skip, err := strconv.ParseInt(parts[1], 10, 64)
+				if err != nil || skip < 0 {
+					fail(sdk.NewProtocolError(sdk.CodeBadRequest, "invalid skip count"))
+					return // The records that follow can't be told apart from commands
+				}
+				opts.Skip = skip
+			}
+			var dst sdk.KVWriter = store
+			if !admin {
+				dst = sdk.DenySystemWrites(store)
+			}
+			if !r.streamImport(conn, reader, protocol, dst, opts) {
+				return
+			}
+
+		case "BLOB_SET":
+			// BLOB_SET persona app key, followed by "<length>\n<bytes>" chunks and "0\n"
+			if !r.receiveBlob(conn, reader, protocol, store, parts[1], parts[2], parts[3]) {
+				return
+			}
+
+		case "BLOB_GET":
+			if !r.sendBlob(conn, protocol, store, parts[1], parts[2], parts[3]) {
+				return
+			}
+
+		case "BLOB_DEL":
+			blobs, ok := store.(sdk.BlobStore)
+			if !ok {
+				fail(sdk.NewProtocolError(sdk.CodeNotSupported, "blobs not supported"))
+				continue
+			}
+			if err := blobs.DeleteBlob(parts[1], parts[2], parts[3]); err != nil {
+				fail(err)
+			} else {
+				fmt.Fprintln(conn, "OK")
+			}
+
+		case "STATS":
+			reporter, ok := store.(sdk.StatsReporter)
+			if !ok {
+				fail(sdk.NewProtocolError(sdk.CodeNotSupported, "stats not supported"))
+				continue
+			}
+			stats, err := reporter.Stats()
+			if err != nil {
+				fail(err)
+			} else {
+				server := r.ConnectionStats()
+				stats.Server = &server
+				res, err := json.Marshal(stats)
+				if err != nil {
+					fail(sdk.ErrInternal)
+				} else {
+					fmt.Fprintln(conn, "OK", string(res))
+				}
+			}
+
+		case "HELLO":
+			// HELLO <version> picks the newest protocol both sides speak.
+			if len(parts) > 1 {
+				v, err := strconv.Atoi(parts[1])
+				if err != nil || v < 1 {
+					fail(sdk.NewProtocolError(sdk.CodeBadRequest, "invalid protocol version"))
+					continue
+				}
+				protocol = min(v, sdk.ProtocolVersion)
+				if r.config.DedupWindow < 0 {
+					protocol = min(protocol, 3) // Version 4 promises request IDs are honored
+				}
+			}
+			hello := sdk.Hello{
+				Protocol:    protocol,
+				Server:      version.Get(),
+				IdleTimeout: max(r.config.IdleTimeout, 0),
+			}
+			if len(parts) > 2 && parts[2] == sdk.Compression {
+				hello.Compression = sdk.Compression
+			}
+			res, _ := json.Marshal(hello)
+			fmt.Fprintln(conn, "OK", string(res))
+			// Only lines after the answer to HELLO may be compressed
+			compressing = hello.Compression != ""
+
+		case "ADMIN":
+			if r.config.AdminToken != "" && subtle.ConstantTimeCompare([]byte(parts[1]), []byte(r.config.AdminToken)) != 1 {
+				fail(sdk.NewProtocolError(sdk.CodeUnauthorized, "wrong admin token"))
+				continue
+			}
+			admin = true
+			adminAuthed = r.config.AdminToken != ""
+			fmt.Fprintln(conn, "OK")
+
+		case "AUTH":
+			if r.config.Tokens == nil {
+				fail(sdk.NewProtocolError(sdk.CodeNotSupported, "token authentication not enabled"))
+				continue
+			}
+			user, err := r.config.Tokens.Verify(parts[1])
+			if err != nil {
+				fail(err)
+				continue
+			}
+			authToken, userID, authCheckedAt = parts[1], user.ID, time.Now()
+			fmt.Fprintln(conn, "OK", user.ID)
+
+		case "NAMESPACE":
+			// NAMESPACE <name> [token] switches the connection to another namespace.
+			token := ""
+			if len(parts) > 2 {
+				token = parts[2]
+			}
+			ns, err := r.namespace(parts[1], token)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			store, namespaceName = ns, parts[1]
+			fmt.Fprintln(conn, "OK")
+
+		case "VERSION":
+			res, _ := json.Marshal(version.Get())
+			fmt.Fprintln(conn, "OK", string(res))
+
+		case "INFO":
+			health := sdk.Health{Status: sdk.HealthOK}
+			if reporter, ok := store.(sdk.HealthReporter); ok {
+				var err error
+				if health, err = reporter.Health(); err != nil {
+					fail(err)
+					continue
+				}
+			}
+			res, err := json.Marshal(health)
+			if err != nil {
+				fail(sdk.ErrInternal)
+			} else {
+				fmt.Fprintln(conn, "OK", string(res))
+			}
+
+		case "FSCK":
+			// File names contain persona IDs, including those of _system.
+			if !admin {
+				fail(sdk.NewProtocolError(sdk.CodeUnauthorized, "admin token required: send ADMIN <token>"))
+				continue
+			}
+			checker, ok := store.(sdk.Checker)
+			if !ok {
+				fail(sdk.NewProtocolError(sdk.CodeNotSupported, "fsck not supported"))
+				continue
+			}
+			report, err := checker.Fsck()
+			if err != nil {
+				fail(err)
+				continue
+			}
+			res, _ := json.Marshal(report)
+			fmt.Fprintln(conn, "OK", string(res))
+
+		case "PING":
+			fmt.Fprintln(conn, "PONG")
+
+		case "QUIT":
+			return
+		}
+	}
+}
+
+// writeErr sends err as an ERR line in the connection's protocol version.
+func writeErr(conn net.Conn, protocol int, err error) {
+	fmt.Fprintln(conn, "ERR", sdk.FormatError(err, protocol))
+}
+
+// streamWatch turns the connection into a one-way stream of "EVENT <json>" lines.
+// The stream ends, and the connection is closed, when the client sends anything
+// (e.g. QUIT) or disconnects, or when the subscription is dropped for lagging.
+func (r *Router) streamWatch(conn net.Conn, reader *bufio.Reader, protocol int, w sdk.Watcher, personaID, appID, prefix string) {
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := w.Watch(ctx, personaID, appID, prefix)
+	if err != nil {
+		writeErr(conn, protocol, err)
+		return
+	}
+
+	// Watchers are expected to sit idle for long periods.
+	r.armRead(conn, 0)
+	fmt.Fprintln(conn, "OK")
+
+	go func() {
+		reader.ReadString('\n')
+		cancel()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				writeErr(conn, protocol, sdk.NewProtocolError(sdk.CodeInternal, "subscription dropped"))
+				return
+			}
+			res, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			r.armWrite(conn)
+			if _, err := fmt.Fprintln(conn, "EVENT", string(res)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// streamApp answers OK, then sends the app's data as one
+// "PERSONA {"persona": ..., "data": ...}" line per persona, in persona ID
+// order, followed by END. A failure while streaming is sent as an ERR line
+// in place of END, which keeps the connection usable; a failure to write
+// returns false and the connection must be closed.
+func (r *Router) streamApp(conn net.Conn, protocol int, store sdk.CelerixStore, appID, after string) bool {
+	r.armWrite(conn)
+	if _, err := fmt.Fprintln(conn, "OK"); err != nil {
+		return false
+	}
+	var sendErr error
+	err := sdk.StreamApp(store, appID, after, func(personaID string, data map[string]any) error {
+		res, err := json.Marshal(map[string]any{"persona": personaID, "data": data})
+		if err != nil {
+			return sdk.ErrInternal
+		}
+		r.armWrite(conn)
+		_, sendErr = fmt.Fprintln(conn, "PERSONA", string(res))
+		return sendErr
+	})
+	switch {
+	case sendErr != nil:
+		return false
+	case err != nil:
+		writeErr(conn, protocol, err)
+	default:
+		fmt.Fprintln(conn, "END")
+	}
+	return true
+}
+
+// streamImport applies ndjson records sent after IMPORT until the END line,
+// reporting "CHECKPOINT <records>" after each durable batch. It returns false
+// if the import failed and the connection must be closed, since the rest of
+// the stream can't be resynchronized with the command protocol.
+func (r *Router) streamImport(conn net.Conn, reader *bufio.Reader, protocol int, store sdk.KVWriter, opts sdk.ImportOptions) bool {
+	src := &importReader{reader: reader, conn: conn, router: r}
+	opts.Checkpoint = func(records int64) {
+		r.armWrite(conn)
+		fmt.Fprintln(conn, "CHECKPOINT", records)
+	}
+
+	result, err := sdk.Import(store, src, opts)
+	r.armWrite(conn)
+	if err != nil {
+		status, code := sdk.ClassifyError(err)
+		writeErr(conn, protocol, &sdk.ProtocolError{
+			Status:  status,
+			Code:    code,
+			Message: fmt.Sprintf("import failed after %d records: %v", result.Records, err),
+		})
+		return false
+	}
+	if !src.done {
+		writeErr(conn, protocol, sdk.NewProtocolError(sdk.CodeBadRequest, "import stream ended without END"))
+		return false
+	}
+	res, _ := json.Marshal(result)
+	fmt.Fprintln(conn, "OK", string(res))
+	return true
+}
+
+// importReader exposes the lines of an IMPORT stream up to END as an io.Reader.
+// Reading line by line from the connection means a slow store stops us reading,
+// which fills the TCP window and slows the sender down.
+type importReader struct {
+	reader  *bufio.Reader
+	conn    net.Conn
+	router  *Router
+	pending []byte
+	done    bool
+}
+
+func (ir *importReader) Read(p []byte) (int, error) {
+	for len(ir.pending) == 0 {
+		if ir.done {
+			return 0, io.EOF
+		}
+		ir.router.armRead(ir.conn, ir.router.config.IdleTimeout)
+		line, err := ir.reader.ReadBytes('\n')
+		if err != nil && len(line) == 0 {
+			return 0, err
+		}
+		if string(bytes.TrimSpace(line)) == "END" {
+			ir.done = true
+			continue
+		}
+		ir.pending = line
+	}
+	n := copy(p, ir.pending)
+	ir.pending = ir.pending[n:]
+	return n, nil
+}
+
+// receiveBlob stores the chunks sent after BLOB_SET and answers "OK <size>".
+// It returns false if the connection must be closed because the rest of the
+// chunks can't be told apart from commands.
+func (r *Router) receiveBlob(conn net.Conn, reader *bufio.Reader, protocol int, store sdk.CelerixStore, personaID, appID, key string) bool {
+	blobs, ok := store.(sdk.BlobStore)
+	if !ok {
+		writeErr(conn, protocol, sdk.NewProtocolError(sdk.CodeNotSupported, "blobs not supported"))
+		return false
+	}
+	src := &sdk.BlobChunkReader{
+		Reader:      reader,
+		Protocol:    protocol,
+		BeforeChunk: func() { r.armRead(conn, r.config.IdleTimeout) },
+	}
+	size, err := blobs.SetBlob(personaID, appID, key, src)
+	r.armWrite(conn)
+	if err != nil {
+		writeErr(conn, protocol, err)
+		return false
+	}
+	if !src.Done() {
+		writeErr(conn, protocol, sdk.NewProtocolError(sdk.CodeInternal, "blob upload not fully read"))
+		return false
+	}
+	fmt.Fprintln(conn, "OK", size)
+	return true
+}
+
+// sendBlob answers BLOB_GET with "OK <size>" and the blob's chunks. A read
+// error part way is sent as an ERR line in place of the next chunk, after
+// which the connection must be closed, so it returns false.
+func (r *Router) sendBlob(conn net.Conn, protocol int, store sdk.CelerixStore, personaID, appID, key string) bool {
+	blobs, ok := store.(sdk.BlobStore)
+	if !ok {
+		writeErr(conn, protocol, sdk.NewProtocolError(sdk.CodeNotSupported, "blobs not supported"))
+		return true
+	}
+	blob, size, err := blobs.GetBlob(personaID, appID, key)
+	if err != nil {
+		writeErr(conn, protocol, err)
+		return true
+	}
+	defer blob.Close()
+
+	w := bufio.NewWriter(conn)
+	fmt.Fprintln(w, "OK", size)
+	if _, err := sdk.WriteBlobChunks(w, blob, func() { r.armWrite(conn) }); err != nil {
+		// If it was the connection that failed, this fails too.
+		w.Flush()
+		writeErr(conn, protocol, err)
+		return false
+	}
+	return w.Flush() == nil
+}
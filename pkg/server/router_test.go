@@ -0,0 +1,826 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/celerix-dev/celerix-store/pkg/compute"
+	"github.com/celerix-dev/celerix-store/pkg/engine"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+func TestRouter_TCP_Commands(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	router := NewRouter(store)
+
+	// Start listener on a random port
+	// We'll let Router.Listen use ":0" to get a random port
+	go router.Listen("0")
+
+	// Wait a bit for listener to be set
+	var port string
+	for i := 0; i < 10; i++ {
+		time.Sleep(50 * time.Millisecond)
+		router.mu.Lock()
+		if router.listener != nil {
+			port = fmt.Sprintf("%d", router.listener.Addr().(*net.TCPAddr).Port)
+			router.mu.Unlock()
+			break
+		}
+		router.mu.Unlock()
+	}
+
+	if port == "" {
+		t.Fatalf("Server did not start in time")
+	}
+
+	defer router.Stop()
+
+	// Client
+	conn, err := net.Dial("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+
+	// Test PING
+	fmt.Fprintf(conn, "PING\n")
+	line, _ := reader.ReadString('\n')
+	if line != "PONG\n" {
+		t.Errorf("Expected PONG, got %q", line)
+	}
+
+	// Test SET
+	fmt.Fprintf(conn, "SET p1 a1 k1 {\"name\": \"test\"}\n")
+	line, _ = reader.ReadString('\n')
+	if line != "OK\n" {
+		t.Errorf("Expected OK, got %q", line)
+	}
+
+	// Test GET
+	fmt.Fprintf(conn, "GET p1 a1 k1\n")
+	line, _ = reader.ReadString('\n')
+	if line != "OK {\"name\":\"test\"}\n" {
+		t.Errorf("Expected OK {\"name\":\"test\"}, got %q", line)
+	}
+
+	// Test SET_SYNC, which a store without a backend answers right away
+	fmt.Fprintf(conn, "SET_SYNC p1 a1 k2 42\n")
+	line, _ = reader.ReadString('\n')
+	if line != "OK\n" {
+		t.Errorf("Expected OK, got %q", line)
+	}
+
+	// Test DEL
+	fmt.Fprintf(conn, "DEL p1 a1 k1\n")
+	line, _ = reader.ReadString('\n')
+	if line != "OK\n" {
+		t.Errorf("Expected OK, got %q", line)
+	}
+
+	// Test GET after DEL
+	fmt.Fprintf(conn, "GET p1 a1 k1\n")
+	line, _ = reader.ReadString('\n')
+	if len(line) < 3 || line[:3] != "ERR" {
+		t.Errorf("Expected ERR, got %q", line)
+	}
+}
+
+func TestRouter_ConcurrentConnections(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	router := NewRouter(store)
+
+	go router.Listen("0")
+	var port string
+	for i := 0; i < 10; i++ {
+		time.Sleep(50 * time.Millisecond)
+		router.mu.Lock()
+		if router.listener != nil {
+			port = fmt.Sprintf("%d", router.listener.Addr().(*net.TCPAddr).Port)
+			router.mu.Unlock()
+			break
+		}
+		router.mu.Unlock()
+	}
+	if port == "" {
+		t.Fatalf("Server did not start in time")
+	}
+	defer router.Stop()
+
+	// Try to open 101 connections
+	conns := make([]net.Conn, 0)
+	for i := 0; i < 110; i++ {
+		conn, err := net.DialTimeout("tcp", "127.0.0.1:"+port, 100*time.Millisecond)
+		if err == nil {
+			conns = append(conns, conn)
+		}
+	}
+
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+func TestRouter_MalformedCommands(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	router := NewRouter(store)
+
+	go router.Listen("0")
+	var port string
+	for i := 0; i < 10; i++ {
+		time.Sleep(50 * time.Millisecond)
+		router.mu.Lock()
+		if router.listener != nil {
+			port = fmt.Sprintf("%d", router.listener.Addr().(*net.TCPAddr).Port)
+			router.mu.Unlock()
+			break
+		}
+		router.mu.Unlock()
+	}
+	if port == "" {
+		t.Fatalf("Server did not start in time")
+	}
+	defer router.Stop()
+
+	conn, _ := net.Dial("tcp", "127.0.0.1:"+port)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// Every command gets exactly one response, in order
+	for _, tc := range []struct{ cmd, want string }{
+		{"SET p1 a1 k1", "ERR usage: SET <persona> <app> <key> <json>"},
+		{"SET p1 a1 k1 {invalid}", "ERR invalid json value"},
+		{"GET p1 a1", "ERR usage: GET <persona> <app> <key> [fields]"},
+		{"DEL", "ERR usage: DEL <persona> <app> <key>"},
+		{"MOVE p1 p2 a1", "ERR usage: MOVE <source persona> <destination persona> <app> <key>"},
+		{"LIST_APPS", "ERR usage: LIST_APPS <persona> [VERBOSE]"},
+		{"FROBNICATE p1", "ERR unknown command"},
+		{`SET p1 a1 k1 "v1"`, "OK"},
+		{"PING", "PONG"},
+	} {
+		fmt.Fprintf(conn, "%s\n", tc.cmd)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("%s: no response: %v", tc.cmd, err)
+		}
+		if got := strings.TrimSpace(line); got != tc.want {
+			t.Errorf("%s: expected %q, got %q", tc.cmd, tc.want, got)
+		}
+	}
+
+	// With error codes negotiated
+	fmt.Fprintf(conn, "HELLO 2\nDUMP p1\nNOPE\n")
+	reader.ReadString('\n')
+	for _, want := range []string{
+		"ERR 400 bad_request usage: DUMP <persona> <app> [fields]",
+		"ERR 400 unknown_command unknown command",
+	} {
+		if line, _ := reader.ReadString('\n'); strings.TrimSpace(line) != want {
+			t.Errorf("Expected %q, got %q", want, line)
+		}
+	}
+}
+
+func TestRouter_DumpAndGlobal(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	store.Set("p1", "a1", "k1", "v1")
+	router := NewRouter(store)
+
+	go router.Listen("0")
+	var port string
+	for i := 0; i < 10; i++ {
+		time.Sleep(50 * time.Millisecond)
+		router.mu.Lock()
+		if router.listener != nil {
+			port = fmt.Sprintf("%d", router.listener.Addr().(*net.TCPAddr).Port)
+			router.mu.Unlock()
+			break
+		}
+		router.mu.Unlock()
+	}
+	if port == "" {
+		t.Fatalf("Server did not start in time")
+	}
+	defer router.Stop()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// Test LIST_PERSONAS
+	fmt.Fprintf(conn, "LIST_PERSONAS\n")
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if line != "OK [\"p1\"]\n" {
+		t.Errorf("Expected OK [\"p1\"], got %q", line)
+	}
+
+	// Test LIST_APPS
+	fmt.Fprintf(conn, "LIST_APPS p1\n")
+	line, _ = reader.ReadString('\n')
+	if line != "OK [\"a1\"]\n" {
+		t.Errorf("Expected OK [\"a1\"], got %q", line)
+	}
+
+	// Test DUMP
+	fmt.Fprintf(conn, "DUMP p1 a1\n")
+	line, _ = reader.ReadString('\n')
+	if line != "OK {\"k1\":\"v1\"}\n" {
+		t.Errorf("Expected OK {\"k1\":\"v1\"}, got %q", line)
+	}
+
+	// Test GET_GLOBAL
+	fmt.Fprintf(conn, "GET_GLOBAL a1 k1\n")
+	line, _ = reader.ReadString('\n')
+	if line != "OK {\"persona\":\"p1\",\"value\":\"v1\"}\n" {
+		t.Errorf("Expected global JSON, got %q", line)
+	}
+}
+
+func TestRouter_Stats(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	store.Set("p1", "a1", "k1", "v1")
+	router := NewRouter(store)
+
+	go router.Listen("0")
+	var port string
+	for i := 0; i < 10; i++ {
+		time.Sleep(50 * time.Millisecond)
+		router.mu.Lock()
+		if router.listener != nil {
+			port = fmt.Sprintf("%d", router.listener.Addr().(*net.TCPAddr).Port)
+			router.mu.Unlock()
+			break
+		}
+		router.mu.Unlock()
+	}
+	if port == "" {
+		t.Fatalf("Server did not start in time")
+	}
+	defer router.Stop()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	fmt.Fprintf(conn, "STATS\n")
+	line, _ := reader.ReadString('\n')
+	if !strings.HasPrefix(line, "OK ") {
+		t.Fatalf("Expected OK, got %q", line)
+	}
+	var stats sdk.Stats
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "OK ")), &stats); err != nil {
+		t.Fatalf("Invalid stats JSON: %v", err)
+	}
+	if stats.Keys != 1 || len(stats.TopContended) != 1 || stats.TopContended[0].Writes != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestRouter_Serve(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	router := NewRouter(store)
+	router.SetConfig(RouterConfig{IdleTimeout: 200 * time.Millisecond})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- router.Serve(ctx, listener) }()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	fmt.Fprintf(conn, "PING\n")
+	if line, _ := reader.ReadString('\n'); line != "PONG\n" {
+		t.Fatalf("Expected PONG, got %q", line)
+	}
+
+	// Idle connections are closed after IdleTimeout
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := reader.ReadString('\n'); err == nil {
+		t.Error("Expected the idle connection to be closed")
+	}
+
+	// Cancelling the context closes open connections and returns
+	idle, _ := net.Dial("tcp", listener.Addr().String())
+	defer idle.Close()
+	fmt.Fprintf(idle, "PING\n")
+	bufio.NewReader(idle).ReadString('\n')
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve returned %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return after cancel")
+	}
+	if _, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Error("Expected the listener to be closed")
+	}
+}
+
+func TestRouter_ServerBusy(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	router := NewRouter(store)
+	router.SetConfig(RouterConfig{MaxConnections: 1})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go router.Serve(ctx, listener)
+
+	first, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer first.Close()
+	reader := bufio.NewReader(first)
+	fmt.Fprintf(first, "PING\n")
+	if line, _ := reader.ReadString('\n'); line != "PONG\n" {
+		t.Fatalf("Expected PONG, got %q", line)
+	}
+
+	// The second connection is told right away, without sending anything
+	second, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if line, _ := bufio.NewReader(second).ReadString('\n'); line != "ERR server busy\n" {
+		t.Fatalf("Expected ERR server busy, got %q", line)
+	}
+
+	fmt.Fprintf(first, "STATS\n")
+	line, _ := reader.ReadString('\n')
+	var stats sdk.Stats
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "OK ")), &stats); err != nil {
+		t.Fatalf("Failed to parse stats %q: %v", line, err)
+	}
+	want := sdk.ServerStats{ActiveConnections: 1, MaxConnections: 1, Accepted: 1, Rejected: 1}
+	if stats.Server == nil || *stats.Server != want {
+		t.Errorf("Expected server stats %+v, got %+v", want, stats.Server)
+	}
+}
+
+func TestRouter_Hello(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	router := NewRouter(store)
+
+	client, srv := net.Pipe()
+	defer client.Close()
+	go router.HandleConnection(srv)
+	reader := bufio.NewReader(client)
+	send := func(cmd string) string {
+		fmt.Fprintf(client, "%s\n", cmd)
+		line, _ := reader.ReadString('\n')
+		return strings.TrimSpace(line)
+	}
+
+	// Protocol version 1 until HELLO
+	if got := send("GET p1 a1 missing"); got != "ERR persona not found" {
+		t.Errorf("Expected a version 1 error, got %q", got)
+	}
+
+	resp := send("HELLO 9")
+	var hello sdk.Hello
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(resp, "OK ")), &hello); err != nil || hello.Protocol != sdk.ProtocolVersion {
+		t.Fatalf("Expected protocol %d, got %q", sdk.ProtocolVersion, resp)
+	}
+
+	send(`SET p1 a1 k1 "v1"`)
+	for cmd, want := range map[string]string{
+		"GET p1 a1 missing":  "ERR 404 key_not_found key not found",
+		"GET nobody a1 k1":   "ERR 404 persona_not_found persona not found",
+		"GET p1 a2 k1":       "ERR 404 app_not_found app not found",
+		"SET p1 a1 k2 {oops": "ERR 400 bad_request invalid json value",
+		"HELLO zero":         "ERR 400 bad_request invalid protocol version",
+	} {
+		if got := send(cmd); got != want {
+			t.Errorf("%s: expected %q, got %q", cmd, want, got)
+		}
+	}
+}
+
+func TestRouter_CompressedLineLimit(t *testing.T) {
+	router := NewRouter(engine.NewMemStore(nil, nil))
+	router.SetConfig(RouterConfig{RequireAuth: true})
+
+	client, srv := net.Pipe()
+	defer client.Close()
+	go router.HandleConnection(srv)
+	reader := bufio.NewReader(client)
+	send := func(cmd string) string {
+		fmt.Fprintf(client, "%s\n", cmd)
+		line, _ := reader.ReadString('\n')
+		return strings.TrimSpace(line)
+	}
+
+	if got := send("HELLO 4 gzip"); !strings.Contains(got, `"compression":"gzip"`) {
+		t.Fatalf("Expected compression, got %q", got)
+	}
+	// A line expanding beyond the limit is refused before authentication
+	bomb := sdk.CompressLine("PING " + strings.Repeat(" ", sdk.MaxDecompressedLine))
+	if got := send(bomb); !strings.HasPrefix(got, "ERR 400 bad_request") {
+		t.Errorf("Expected an oversized line to be refused, got %q", got)
+	}
+	if got := send(sdk.CompressLine("PING")); got != "PONG" {
+		t.Errorf("Expected the connection to stay usable, got %q", got)
+	}
+}
+
+func TestRouter_RequestIDs(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	router := NewRouter(store)
+	store.Set("p1", "a1", "k", "v")
+
+	connect := func() func(string) string {
+		client, srv := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		go router.HandleConnection(srv)
+		reader := bufio.NewReader(client)
+		return func(cmd string) string {
+			fmt.Fprintf(client, "%s\n", cmd)
+			line, _ := reader.ReadString('\n')
+			return strings.TrimSpace(line)
+		}
+	}
+	send := connect()
+	send("HELLO 4")
+
+	if got := send("REQ r1 MOVE p1 p2 a1 k"); got != "OK" {
+		t.Fatalf("Expected OK, got %q", got)
+	}
+	// A retry is answered the same, even on another connection, without
+	// running again.
+	retry := connect()
+	retry("HELLO 4")
+	if got := retry("REQ r1 MOVE p1 p2 a1 k"); got != "OK" {
+		t.Errorf("Expected the retry to be answered OK, got %q", got)
+	}
+	if got := send("REQ r2 MOVE p1 p2 a1 k"); got != "ERR 404 key_not_found key not found" {
+		t.Errorf("Expected a new request to run, got %q", got)
+	}
+	if got := send("REQ r1 DEL p2 a1 k"); !strings.HasPrefix(got, "ERR 400 bad_request") {
+		t.Errorf("Expected a reused request ID to be refused, got %q", got)
+	}
+	// Reads ignore the request ID.
+	if got := send(`REQ r3 GET p2 a1 k`); got != `OK "v"` {
+		t.Errorf("Expected the value, got %q", got)
+	}
+
+	// Answers are only replayed in the protocol version they were given in.
+	old := connect()
+	old("HELLO 3")
+	if got := old("REQ r1 MOVE p1 p2 a1 k"); !strings.Contains(got, "another protocol version") {
+		t.Errorf("Expected the answer not to be replayed, got %q", got)
+	}
+
+	// Request IDs are per namespace.
+	staging := engine.NewMemStore(nil, nil)
+	staging.Set("p1", "a1", "k", "staged")
+	router.AddNamespace("staging", staging, "s3cret")
+	send("NAMESPACE staging s3cret")
+	if got := send("REQ r1 MOVE p1 p2 a1 k"); got != "OK" {
+		t.Errorf("Expected the request to run in another namespace, got %q", got)
+	}
+	if val, err := staging.Get("p2", "a1", "k"); err != nil || val != "staged" {
+		t.Errorf("Expected the move in the namespace, got %v, %v", val, err)
+	}
+
+	// Daemons not deduplicating don't speak version 4.
+	plain := NewRouter(store)
+	plain.SetConfig(RouterConfig{DedupWindow: -1})
+	client, srv := net.Pipe()
+	defer client.Close()
+	go plain.HandleConnection(srv)
+	fmt.Fprintf(client, "HELLO 4\n")
+	if got, _ := bufio.NewReader(client).ReadString('\n'); !strings.Contains(got, `"protocol":3`) {
+		t.Errorf("Expected protocol 3, got %q", got)
+	}
+}
+
+func TestRouter_Namespaces(t *testing.T) {
+	router := NewRouter(engine.NewMemStore(nil, nil))
+	staging := engine.NewMemStore(nil, nil)
+	if err := router.AddNamespace("staging", staging, "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.AddNamespace("Bad Name", staging, ""); err == nil {
+		t.Error("Expected an invalid namespace name to be rejected")
+	}
+
+	client, srv := net.Pipe()
+	defer client.Close()
+	go router.HandleConnection(srv)
+	reader := bufio.NewReader(client)
+	send := func(cmd string) string {
+		fmt.Fprintf(client, "%s\n", cmd)
+		line, _ := reader.ReadString('\n')
+		return strings.TrimSpace(line)
+	}
+
+	send(`SET p1 a1 k1 "default"`)
+	for cmd, want := range map[string]string{
+		"NAMESPACE staging":       "ERR unknown namespace or wrong token",
+		"NAMESPACE staging wrong": "ERR unknown namespace or wrong token",
+		"NAMESPACE missing":       "ERR unknown namespace or wrong token",
+	} {
+		if got := send(cmd); got != want {
+			t.Errorf("%s: expected %q, got %q", cmd, want, got)
+		}
+	}
+
+	if got := send("NAMESPACE staging s3cret"); got != "OK" {
+		t.Fatalf("Expected to enter the namespace, got %q", got)
+	}
+	if got := send("GET p1 a1 k1"); got != "ERR persona not found" {
+		t.Errorf("Expected the default namespace to be invisible, got %q", got)
+	}
+	send(`SET p1 a1 k1 "staging"`)
+	if val, _ := staging.Get("p1", "a1", "k1"); val != "staging" {
+		t.Errorf("Expected the write to land in the namespace, got %v", val)
+	}
+
+	send("NAMESPACE default")
+	if got := send("GET p1 a1 k1"); got != `OK "default"` {
+		t.Errorf("Expected the default namespace back, got %q", got)
+	}
+}
+
+func TestRouter_AdminToken(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	router := NewRouter(store)
+	router.SetConfig(RouterConfig{AdminToken: "s3cret"})
+
+	client, srv := net.Pipe()
+	defer client.Close()
+	go router.HandleConnection(srv)
+	reader := bufio.NewReader(client)
+	send := func(cmd string) string {
+		fmt.Fprintf(client, "%s\n", cmd)
+		line, _ := reader.ReadString('\n')
+		return strings.TrimSpace(line)
+	}
+
+	denied := "ERR writes to the _system persona need admin rights"
+	for _, cmd := range []string{`SET _system a1 k1 "x"`, "DEL _system a1 k1", "MOVE p1 _system a1 k1"} {
+		if got := send(cmd); got != denied {
+			t.Errorf("%s: expected %q, got %q", cmd, denied, got)
+		}
+	}
+	if got := send(`SET p1 a1 k1 "x"`); got != "OK" {
+		t.Errorf("Expected other personas to stay writable, got %q", got)
+	}
+	if got := send(`SET ../p1 a1 k1 "x"`); !strings.HasPrefix(got, "ERR invalid persona ID") {
+		t.Errorf("Expected an invalid persona ID to be rejected, got %q", got)
+	}
+	if got := send("FSCK"); !strings.HasPrefix(got, "ERR admin token required") {
+		t.Errorf("Expected FSCK to need admin rights, got %q", got)
+	}
+
+	if got := send("ADMIN wrong"); got != "ERR wrong admin token" {
+		t.Errorf("Expected a wrong token to be rejected, got %q", got)
+	}
+	if got := send("ADMIN s3cret"); got != "OK" {
+		t.Fatalf("Expected the admin token to be accepted, got %q", got)
+	}
+	if got := send(`SET _system a1 k1 "x"`); got != "OK" {
+		t.Errorf("Expected admins to write to _system, got %q", got)
+	}
+}
+
+func TestRouter_RequireAuth(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	users := sdk.NewUserStore(store)
+	tokens := sdk.NewTokenStore(store, users, 0, 0)
+	alice, _, _ := users.Create("alice", "")
+	users.SetPassword(alice.ID, "secret")
+	issued, err := tokens.Login("alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(store)
+	router.SetConfig(RouterConfig{Tokens: tokens, RequireAuth: true})
+	client, srv := net.Pipe()
+	defer client.Close()
+	go router.HandleConnection(srv)
+	reader := bufio.NewReader(client)
+	send := func(cmd string) string {
+		fmt.Fprintf(client, "%s\n", cmd)
+		line, _ := reader.ReadString('\n')
+		return strings.TrimSpace(line)
+	}
+
+	if got := send("LIST_PERSONAS"); got != "ERR authentication required: send AUTH <token>" {
+		t.Errorf("Expected unauthenticated commands to be refused, got %q", got)
+	}
+	if got := send("PING"); got != "PONG" {
+		t.Errorf("Expected PING to work unauthenticated, got %q", got)
+	}
+	if got := send("AUTH nope"); got != "ERR invalid or expired token" {
+		t.Errorf("Expected a bad token to be refused, got %q", got)
+	}
+	if got := send("AUTH " + issued.AccessToken); got != "OK "+alice.ID {
+		t.Fatalf("Expected AUTH to succeed, got %q", got)
+	}
+	if got := send("LIST_PERSONAS"); !strings.HasPrefix(got, "OK") {
+		t.Errorf("Expected commands to work after AUTH, got %q", got)
+	}
+}
+
+func TestRouter_Authorizer(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	router := NewRouter(store)
+	var seen []AuthRequest
+	router.SetConfig(RouterConfig{Authorizer: AuthorizerFunc(func(req AuthRequest) error {
+		seen = append(seen, req)
+		if req.PersonaID == "p2" || req.DstPersonaID == "p2" {
+			return errors.New("p2 is off limits")
+		}
+		return nil
+	})})
+
+	client, srv := net.Pipe()
+	defer client.Close()
+	go router.HandleConnection(srv)
+	reader := bufio.NewReader(client)
+	send := func(cmd string) string {
+		fmt.Fprintf(client, "%s\n", cmd)
+		line, _ := reader.ReadString('\n')
+		return strings.TrimSpace(line)
+	}
+
+	if got := send(`SET p1 a1 k1 "x"`); got != "OK" {
+		t.Fatalf("Expected the write to be allowed, got %q", got)
+	}
+	for _, cmd := range []string{"GET p2 a1 k1", `SET p2 a1 k1 "x"`, "MOVE p1 p2 a1 k1"} {
+		if got := send(cmd); got != "ERR p2 is off limits" {
+			t.Errorf("%s: expected the authorizer's refusal, got %q", cmd, got)
+		}
+	}
+	if _, err := store.Get("p2", "a1", "k1"); err == nil {
+		t.Error("Expected a refused write not to be applied")
+	}
+	if got := send("PING"); got != "PONG" {
+		t.Errorf("Expected PING to work, got %q", got)
+	}
+
+	want := []AuthRequest{
+		{Command: "SET", Args: []string{"p1", "a1", "k1", `"x"`}, Write: true, PersonaID: "p1", AppID: "a1"},
+		{Command: "GET", Args: []string{"p2", "a1", "k1"}, PersonaID: "p2", AppID: "a1"},
+		{Command: "SET", Args: []string{"p2", "a1", "k1", `"x"`}, Write: true, PersonaID: "p2", AppID: "a1"},
+		{Command: "MOVE", Args: []string{"p1", "p2", "a1", "k1"}, Write: true, PersonaID: "p1", AppID: "a1", DstPersonaID: "p2", DstAppID: "a1"},
+	}
+	if len(seen) != len(want) {
+		t.Fatalf("Expected the authorizer to see %d commands, saw %+v", len(want), seen)
+	}
+	for i, req := range seen {
+		if req.RemoteAddr == nil || req.Namespace != sdk.DefaultNamespace {
+			t.Errorf("Expected the connection's identity, got %+v", req)
+		}
+		req.RemoteAddr, req.Namespace = nil, ""
+		if fmt.Sprint(req) != fmt.Sprint(want[i]) {
+			t.Errorf("Expected %+v, got %+v", want[i], req)
+		}
+	}
+}
+
+// FuzzRouter feeds hostile input to a connection: whatever a client sends, the
+// daemon must answer or hang up, never panic.
+func FuzzRouter(f *testing.F) {
+	for _, seed := range []string{
+		"GET p1 a1 k1",
+		`SET p1 a1 k1 {"a":[1,2,{"b":null}]}`,
+		`SET_MERGE p1 a1 k1 {"a":null}`,
+		"DUMP p1 a1 a,b.c",
+		"HELLO 2\nGET p1 a1 missing",
+		"HELLO 99999999999999999999",
+		"IMPORT 0\n{\"persona_id\":\"p\",\"app_id\":\"a\",\"key\":\"k\",\"value\":1}\nEND",
+		"BLOB_SET p1 a1 b\n3\nabc\n0",
+		"BLOB_SET p1 a1 b\n-1",
+		"LOCK p a n 100\nRENEW p a n x 100",
+		"ENQUEUE p a q 1\nDEQUEUE p a q -1\nACK p a q x",
+		"MOVE p1 p2 a1 k1",
+		"NAMESPACE x\nADMIN y\nAUTH z",
+		"WATCH * * \x00",
+		"SET ../.. a k 1",
+		"DEL _system a k",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		store := engine.NewMemStore(nil, nil)
+		store.Set("p1", "a1", "k1", map[string]any{"a": "x"})
+		router := NewRouter(store)
+		router.SetConfig(RouterConfig{IdleTimeout: time.Second})
+
+		client, srv := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			router.HandleConnection(srv)
+			srv.Close()
+		}()
+		go io.Copy(io.Discard, client)
+		client.SetWriteDeadline(time.Now().Add(time.Second))
+		io.WriteString(client, input+"\n")
+		client.Close()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("connection still open after the client hung up on %q", input)
+		}
+	})
+}
+
+func TestListen_UnixSocket(t *testing.T) {
+	path := t.TempDir() + "/celerix.sock"
+	l, err := Listen("unix://" + path)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	router := NewRouter(engine.NewMemStore(nil, nil))
+	go router.Serve(context.Background(), l)
+
+	// A second daemon must not take over a socket in use
+	if _, err := Listen("unix:" + path); err == nil {
+		t.Fatal("Expected an error listening on a socket in use")
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintln(conn, "PING")
+	resp, _ := bufio.NewReader(conn).ReadString('\n')
+	if resp != "PONG\n" {
+		t.Errorf("Expected PONG, got %q", resp)
+	}
+}
+
+func TestRouter_Compute(t *testing.T) {
+	store := engine.NewMemStore(nil, nil)
+	store.Set("p1", "a1", "k1", "x")
+	runner, err := compute.NewRunner(compute.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Close()
+
+	connect := func(config RouterConfig) func(cmd string) string {
+		router := NewRouter(store)
+		router.SetConfig(config)
+		client, srv := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		go router.HandleConnection(srv)
+		reader := bufio.NewReader(client)
+		return func(cmd string) string {
+			fmt.Fprintf(client, "%s\n", cmd)
+			line, _ := reader.ReadString('\n')
+			return strings.TrimSpace(line)
+		}
+	}
+
+	if got := connect(RouterConfig{})("COMPUTE count p1 a1"); !strings.Contains(got, "not enabled") {
+		t.Errorf("Expected COMPUTE to be refused without a runner, got %q", got)
+	}
+	send := connect(RouterConfig{Compute: runner})
+	if got := send("COMPUTE count p1 a1"); !strings.Contains(got, "no function count") {
+		t.Errorf("Expected an unknown function to be reported, got %q", got)
+	}
+	if got := send("COMPUTE count p1 a1 {bad"); !strings.Contains(got, "invalid json args") {
+		t.Errorf("Expected bad args to be refused, got %q", got)
+	}
+}
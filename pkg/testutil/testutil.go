@@ -0,0 +1,173 @@
+// Package testutil boots an in-process celerix-stored daemon for integration tests.
+//
+//	func TestMyService(t *testing.T) {
+//	    srv := testutil.StartServer(t)
+//	    svc := myservice.New(srv.Client)
+//	    ...
+//	}
+//
+// Everything started by StartServer is torn down through t.Cleanup.
+package testutil
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/celerix-dev/celerix-store/internal/api"
+	"github.com/celerix-dev/celerix-store/internal/vault"
+	"github.com/celerix-dev/celerix-store/pkg/engine"
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+	"github.com/celerix-dev/celerix-store/pkg/server"
+	"github.com/gin-gonic/gin"
+)
+
+// Server is a running in-process daemon.
+type Server struct {
+	// Addr is the TCP address of the line protocol listener (127.0.0.1:port).
+	Addr string
+	// HTTPURL is the base URL of the management API, e.g. http://127.0.0.1:port.
+	// API routes live under HTTPURL + "/api".
+	HTTPURL string
+	// DataDir is the temporary directory persona files are written to.
+	// It is empty unless WithPersistence is used.
+	DataDir string
+	// TLS reports whether the TCP listener requires TLS.
+	TLS bool
+
+	// Store is the engine behind both listeners, for seeding and inspecting state directly.
+	Store *engine.MemStore
+	// Client is connected to Addr and closed automatically at cleanup.
+	Client *sdk.Client
+}
+
+// Option configures StartServer.
+type Option func(*config)
+
+type config struct {
+	tls         bool
+	persistence bool
+	data        map[string]map[string]map[string]any
+	seed        *sdk.Seed
+}
+
+// WithTLS serves the TCP protocol over TLS with a freshly generated self-signed certificate.
+func WithTLS() Option {
+	return func(c *config) { c.tls = true }
+}
+
+// WithPersistence writes persona files to a temporary directory (Server.DataDir)
+// instead of keeping everything in memory.
+func WithPersistence() Option {
+	return func(c *config) { c.persistence = true }
+}
+
+// WithData starts the daemon with the given persona -> app -> key -> value data already loaded.
+func WithData(data map[string]map[string]map[string]any) Option {
+	return func(c *config) { c.data = data }
+}
+
+// WithSeed applies a seed before the daemon starts (see sdk.ApplySeed), which
+// unlike WithData can also create users and API keys.
+func WithSeed(seed *sdk.Seed) Option {
+	return func(c *config) { c.seed = seed }
+}
+
+// StartServer starts a daemon on random local ports and returns it with a connected client.
+// It fails the test immediately if anything can't be started.
+func StartServer(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+
+	cfg := &config{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	srv := &Server{TLS: cfg.tls}
+
+	var backend engine.StorageBackend
+	if cfg.persistence {
+		srv.DataDir = t.TempDir()
+		p, err := engine.NewPersistence(srv.DataDir)
+		if err != nil {
+			t.Fatalf("testutil: failed to initialize persistence: %v", err)
+		}
+		backend = p
+	}
+
+	srv.Store = engine.NewMemStore(cfg.data, backend)
+	t.Cleanup(func() { srv.Store.Close() })
+	if cfg.seed != nil {
+		if _, err := sdk.ApplySeed(srv.Store, cfg.seed); err != nil {
+			t.Fatalf("testutil: failed to apply the seed: %v", err)
+		}
+	}
+
+	// TCP line protocol
+	router := server.NewRouter(srv.Store)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("testutil: failed to listen: %v", err)
+	}
+	srv.Addr = listener.Addr().String()
+
+	clientOpt := sdk.WithoutTLS()
+	if cfg.tls {
+		cert, err := vault.GenerateSelfSignedCert()
+		if err != nil {
+			listener.Close()
+			t.Fatalf("testutil: failed to generate TLS certificate: %v", err)
+		}
+		router.SetCertificate(cert)
+		clientOpt = sdk.WithTLSConfig(&tls.Config{InsecureSkipVerify: true})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		router.Serve(ctx, listener)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-served
+	})
+
+	// HTTP management API
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(gin.Recovery())
+	h := &api.Handler{Store: srv.Store, Tokens: sdk.NewTokenStore(srv.Store, sdk.NewUserStore(srv.Store), 0, 0), APIKeys: sdk.NewAPIKeyStore(srv.Store)}
+	h.RegisterRoutes(r.Group("/api"))
+	httpServer := httptest.NewServer(r)
+	srv.HTTPURL = httpServer.URL
+	t.Cleanup(httpServer.Close)
+
+	client, err := sdk.Connect(srv.Addr, clientOpt)
+	if err != nil {
+		t.Fatalf("testutil: failed to connect client: %v", err)
+	}
+	srv.Client = client
+	t.Cleanup(func() { client.Close() })
+
+	return srv
+}
+
+// Connect opens an additional client to the server, closed automatically at cleanup.
+func (s *Server) Connect(t testing.TB, opts ...sdk.ClientOption) *sdk.Client {
+	t.Helper()
+
+	transport := sdk.WithoutTLS()
+	if s.TLS {
+		transport = sdk.WithTLSConfig(&tls.Config{InsecureSkipVerify: true})
+	}
+
+	client, err := sdk.Connect(s.Addr, append([]sdk.ClientOption{transport}, opts...)...)
+	if err != nil {
+		t.Fatalf("testutil: failed to connect client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
@@ -0,0 +1,75 @@
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/celerix-dev/celerix-store/pkg/sdk"
+)
+
+func TestStartServer(t *testing.T) {
+	srv := StartServer(t, WithData(map[string]map[string]map[string]any{
+		"p1": {"a1": {"seeded": "yes"}},
+	}))
+
+	if err := srv.Client.Set("p1", "a1", "k1", "v1"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if val, err := srv.Client.Get("p1", "a1", "seeded"); err != nil || val != "yes" {
+		t.Errorf("Expected seeded value, got %v, %v", val, err)
+	}
+
+	resp, err := http.Get(srv.HTTPURL + "/api/personas/p1/apps/a1")
+	if err != nil {
+		t.Fatalf("HTTP request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var data map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if data["k1"] != "v1" {
+		t.Errorf("Expected k1=v1 over HTTP, got %v", data)
+	}
+}
+
+func TestStartServer_TLSAndPersistence(t *testing.T) {
+	srv := StartServer(t, WithTLS(), WithPersistence())
+
+	other := srv.Connect(t)
+	if err := other.Set("p1", "a1", "k1", "v1"); err != nil {
+		t.Fatalf("Set over TLS failed: %v", err)
+	}
+	if val, err := srv.Client.Get("p1", "a1", "k1"); err != nil || val != "v1" {
+		t.Errorf("Expected v1, got %v, %v", val, err)
+	}
+
+	srv.Store.Wait()
+	if _, err := os.Stat(filepath.Join(srv.DataDir, "p1", "a1.json")); err != nil {
+		t.Errorf("Expected app file in data dir: %v", err)
+	}
+}
+
+func TestStartServer_Seed(t *testing.T) {
+	srv := StartServer(t, WithSeed(&sdk.Seed{
+		Personas: map[string]map[string]map[string]any{"p1": {"a1": {"k1": "v1"}}},
+		Users:    []sdk.SeedUser{{Username: "dev", Password: "pw"}},
+	}))
+
+	if val, err := srv.Client.Get("p1", "a1", "k1"); err != nil || val != "v1" {
+		t.Errorf("Expected the seeded value, got %v, %v", val, err)
+	}
+	resp, err := http.Post(srv.HTTPURL+"/api/auth/login", "application/json", strings.NewReader(`{"username":"dev","password":"pw"}`))
+	if err != nil {
+		t.Fatalf("HTTP request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the seeded user to log in, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,72 @@
+// Package version reports the version of the running Celerix binary.
+//
+// Release builds set the variables with ldflags:
+//
+//	go build -ldflags "-X github.com/celerix-dev/celerix-store/pkg/version.Version=v1.2.3 \
+//	  -X github.com/celerix-dev/celerix-store/pkg/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X github.com/celerix-dev/celerix-store/pkg/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without ldflags, the values fall back to what the Go toolchain recorded in the binary.
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// Set via ldflags at build time.
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// Info describes a build of the daemon, CLI or SDK.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build info of the running binary.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildDate: BuildDate, GoVersion: runtime.Version()}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version // Installed with go install module@version
+	}
+	for _, s := range bi.Settings {
+		switch {
+		case s.Key == "vcs.revision" && info.Commit == "unknown" && len(s.Value) >= 7:
+			info.Commit = s.Value[:7]
+		case s.Key == "vcs.time" && info.BuildDate == "unknown":
+			info.BuildDate = s.Value
+		}
+	}
+	return info
+}
+
+// String formats the info for --version output.
+func (i Info) String() string {
+	return fmt.Sprintf("%s (commit %s, built %s, %s)", i.Version, i.Commit, i.BuildDate, i.GoVersion)
+}
+
+// Major returns the major version number of a "v1.2.3"-style version.
+// It reports false for development builds and anything else it can't parse.
+func Major(v string) (int, bool) {
+	v = strings.TrimPrefix(v, "v")
+	major, _, _ := strings.Cut(v, ".")
+	n, err := strconv.Atoi(major)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
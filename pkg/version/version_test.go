@@ -0,0 +1,23 @@
+package version
+
+import "testing"
+
+func TestMajor(t *testing.T) {
+	tests := []struct {
+		in    string
+		major int
+		ok    bool
+	}{
+		{"v1.2.3", 1, true},
+		{"0.2.4", 0, true},
+		{"v2", 2, true},
+		{"dev", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		major, ok := Major(tt.in)
+		if major != tt.major || ok != tt.ok {
+			t.Errorf("Major(%q) = %d, %v; want %d, %v", tt.in, major, ok, tt.major, tt.ok)
+		}
+	}
+}